@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Administration roles granting part of the administrator endpoints
+	ROLE_STORAGE = "storage"
+)
+
+// ErrNotAdmin is returned by authAdmin for authenticated users that are not administrators
+var ErrNotAdmin = errors.New("user is not an administrator, forbidden")
+
+// roleUids names the environment variable listing the uids granted each role
+var roleUids = map[string]string{
+	ROLE_STORAGE: "STORAGE_ADMIN_UIDS",
+}
+
+// authAdmin authenticates the request and ensures the user is an administrator
+// administrators are the uids listed in the comma separated ADMIN_UIDS environment variable
+func authAdmin(req *http.Request) (JWTClaims, error) {
+	claims, err := authRequest(req)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	if !isAdmin(claims.Uid) {
+		return JWTClaims{}, ErrNotAdmin
+	}
+
+	return claims, nil
+}
+
+// authRole authenticates the request and ensures the user is an administrator or was granted the role
+func authRole(req *http.Request, role string) (JWTClaims, error) {
+	claims, err := authRequest(req)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	if !isAdmin(claims.Uid) && !uidListed(os.Getenv(roleUids[role]), claims.Uid) {
+		return JWTClaims{}, ErrNotAdmin
+	}
+
+	return claims, nil
+}
+
+// isAdmin reports whether the uid is listed in ADMIN_UIDS
+func isAdmin(uid int) bool {
+	return uidListed(os.Getenv("ADMIN_UIDS"), uid)
+}
+
+// uidListed reports whether the uid is in the comma separated list
+func uidListed(list string, uid int) bool {
+	for _, listed := range strings.Split(list, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(listed))
+		if err == nil && id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAdminAuthError reports a failure of authAdmin to the client
+func writeAdminAuthError(w http.ResponseWriter, err error) {
+	if err == ErrNotAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Forbidden, this endpoint is restricted to administrators"))
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+}
@@ -0,0 +1,695 @@
+package main
+
+/*
+	This file lets users group their images into named albums. Images keep a position within each
+	album so clients can present them in the order the owner chose, and an image may belong to any
+	number of albums. Marking an album shareable shares every image it contains through the public
+	image and album endpoints without changing the shareable flag of the images themselves, removing
+	an image from the album or unsharing the album stops sharing it again. The owner may choose one
+	of the images as the cover of the album, otherwise the first image in order is the cover.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	ALBUM_TABLE       = "album"
+	ALBUM_IMAGE_TABLE = "album_image"
+
+	ALBUM_TITLE_MAX       = 100  // Characters allowed in an album title
+	ALBUM_DESCRIPTION_MAX = 2000 // Characters allowed in an album description
+	ALBUM_MAX_IMAGES      = 5000 // Images an album may contain
+	ALBUM_ADD_MAX         = 100  // Images that may be added by a single request
+)
+
+var (
+	// ErrAlbumImageNotFound is returned when adding images that don't exist or belong to another user
+	ErrAlbumImageNotFound = errors.New("image not found")
+
+	// ErrAlbumFull is returned when adding images would exceed ALBUM_MAX_IMAGES
+	ErrAlbumFull = errors.New("album is full")
+
+	// ErrAlbumOrderMismatch is returned when an order doesn't list every image of the album exactly once
+	ErrAlbumOrderMismatch = errors.New("order doesn't match the album images")
+)
+
+// Album is a named collection of a user's images tagged for sql serialization
+type Album struct {
+	Id          int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid         int32     `sql:"uid"`
+	Title       string    `sql:"title"`
+	Description string    `sql:"description"`
+	Shareable   bool      `sql:"shareable"`
+	CoverId     int32     `sql:"cover_id" opt:"NOT NULL DEFAULT 0"` // Image chosen as cover, 0 uses the first image
+	Created     time.Time `sql:"created"`
+	Updated     time.Time `sql:"updated"`
+}
+
+// AlbumImage places an image in an album, images are ordered by ascending position
+type AlbumImage struct {
+	Id       int32 `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	AlbumId  int32 `sql:"album_id"`
+	ImageId  int32 `sql:"image_id"`
+	Position int32 `sql:"position"`
+}
+
+// AlbumParams are the album properties set by users, omitted properties are unchanged by updates
+type AlbumParams struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Shareable   *bool   `json:"shareable"`
+}
+
+// AlbumResp describes an album
+type AlbumResp struct {
+	Id          int32     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Shareable   bool      `json:"shareable"`
+	ImageCount  int       `json:"imageCount"`
+	ImageIds    []int32   `json:"imageIds"` // Images in the owner's order
+	CoverId     int32     `json:"coverId"`  // 0 if the album is empty
+	Cover       *Image    `json:"cover"`
+	Created     Timestamp `json:"created"`
+	Updated     Timestamp `json:"updated"`
+}
+
+// AlbumDetailResp describes an album with its images in order
+type AlbumDetailResp struct {
+	AlbumResp
+	Images []Image `json:"images"`
+}
+
+// albumResp returns the response describing the album with its image ids in order and its cover,
+// the cover is nil if the album is empty
+func albumResp(album Album, imageIds []int32, cover *Image) AlbumResp {
+	resp := AlbumResp{
+		Id:          album.Id,
+		Title:       album.Title,
+		Description: album.Description,
+		Shareable:   album.Shareable,
+		ImageCount:  len(imageIds),
+		ImageIds:    append([]int32{}, imageIds...),
+		Cover:       cover,
+		Created:     Timestamp(album.Created),
+		Updated:     Timestamp(album.Updated),
+	}
+	if cover != nil {
+		resp.CoverId = cover.Id
+	}
+	return resp
+}
+
+// albumCover returns the id of the cover among the images of the album in order, the chosen cover
+// while it is in the album and the first image otherwise. Returns 0 if the album is empty
+func albumCover(album Album, imageIds []int32) int32 {
+	if containsImageId(imageIds, album.CoverId) {
+		return album.CoverId
+	}
+	if len(imageIds) > 0 {
+		return imageIds[0]
+	}
+	return 0
+}
+
+// apply validates the parameters and sets them on the album
+func (p AlbumParams) apply(album *Album) error {
+	if p.Title != nil {
+		title := strings.TrimSpace(cleanText(*p.Title, false))
+		if len(title) == 0 || utf8.RuneCountInString(title) > ALBUM_TITLE_MAX {
+			return fmt.Errorf("title is required and may not exceed %v characters", ALBUM_TITLE_MAX)
+		}
+		album.Title = title
+	}
+	if p.Description != nil {
+		description := cleanText(*p.Description, true)
+		if utf8.RuneCountInString(description) > ALBUM_DESCRIPTION_MAX {
+			return fmt.Errorf("description may not exceed %v characters", ALBUM_DESCRIPTION_MAX)
+		}
+		album.Description = description
+	}
+	if p.Shareable != nil {
+		album.Shareable = *p.Shareable
+	}
+	return nil
+}
+
+// createAlbum accepts a json body with the title, description and shareable flag of a new album
+func createAlbum(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to create album sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var params AlbumParams
+	err = json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	now := time.Now().UTC()
+	album := Album{Uid: int32(claims.Uid), Created: now, Updated: now}
+	if params.Title == nil {
+		params.Title = new(string)
+	}
+	err = params.apply(&album)
+	if err != nil {
+		logger.Error("invalid album sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	// Sharing an album is subject to the same policy as sharing an image
+	if album.Shareable {
+		_, _, err = resolveShareable(req.Context(), sqlStore{}, "true")
+		if err != nil {
+			writeSharingError(w, err)
+			return
+		}
+	}
+
+	album.Id, err = AddAlbum(album)
+	if err != nil {
+		logger.Error("failed to add album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create album, try again later"))
+		return
+	}
+
+	writeAlbumJSON(w, http.StatusCreated, albumResp(album, nil, nil))
+	logger.Info("Created album %v for user %v", album.Id, claims.Uid)
+}
+
+// listAlbums returns the albums of the authenticated user
+func listAlbums(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list albums sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	albums, orders, err := UserAlbums(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve albums sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve albums, try again later"))
+		return
+	}
+
+	coverIds := []int32{}
+	for _, album := range albums {
+		if id := albumCover(album, orders[album.Id]); id != 0 {
+			coverIds = append(coverIds, id)
+		}
+	}
+	covers, err := GetImages(coverIds)
+	if err != nil {
+		logger.Error("failed to retrieve album covers sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve albums, try again later"))
+		return
+	}
+
+	resp := []AlbumResp{}
+	for _, album := range albums {
+		var cover *Image
+		if image, ok := covers[albumCover(album, orders[album.Id])]; ok {
+			cover = &image
+		}
+		resp = append(resp, albumResp(album, orders[album.Id], cover))
+	}
+	writeAlbumJSON(w, http.StatusOK, resp)
+}
+
+// getAlbum returns an album of the authenticated user with its images in order
+func getAlbum(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	images, err := AlbumImages(album.Id)
+	if err != nil {
+		logger.Error("failed to retrieve album images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve album, try again later"))
+		return
+	}
+
+	hintPreloads(w, req, images)
+	writeAlbumImages(w, album, images)
+}
+
+// getPublicAlbum returns a shareable album with its images without authentication
+// albums that aren't shareable are reported as not found
+func (s *Server) getPublicAlbum(w http.ResponseWriter, req *http.Request) {
+	album, ok := findAlbum(w, req)
+	if !ok {
+		return
+	}
+	if !album.Shareable {
+		logger.Error("public request for private album %v sending 404", album.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no album with that id"))
+		return
+	}
+
+	// Users blocked by the owner can't view the album, like private albums
+	blocked, err := s.blockedViewer(req, album.Uid)
+	if err != nil {
+		logger.Error("failed to retrieve blocks sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve album, try again later"))
+		return
+	}
+	if blocked {
+		logger.Error("public request for album %v by blocked user sending 404", album.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no album with that id"))
+		return
+	}
+
+	writeAlbumDetail(w, album)
+}
+
+// updateAlbum accepts a json body with the album properties to change
+func updateAlbum(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	var params AlbumParams
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	wasShareable := album.Shareable
+	err = params.apply(&album)
+	if err != nil {
+		logger.Error("invalid album sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	if album.Shareable && !wasShareable {
+		_, _, err = resolveShareable(req.Context(), sqlStore{}, "true")
+		if err != nil {
+			writeSharingError(w, err)
+			return
+		}
+	}
+
+	album.Updated = time.Now().UTC()
+	err = UpdateAlbum(album)
+	if err != nil {
+		logger.Error("failed to update album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+
+	writeAlbumDetail(w, album)
+}
+
+// deleteAlbum deletes an album of the authenticated user, the images it contained are kept
+func deleteAlbum(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	err := DeleteAlbum(album.Id)
+	if err != nil {
+		logger.Error("failed to delete album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete album, try again later"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Deleted album %v", album.Id)
+}
+
+// addAlbumImages accepts a json body with the ids of the user's images to add to the album.
+// Images are inserted in order at position, or appended when position is omitted, and images
+// already in the album are moved so the endpoint also reorders albums
+func addAlbumImages(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		ImageIds []int32 `json:"imageIds"`
+		Position *int    `json:"position"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	imageIds := []int32{}
+	for _, id := range body.ImageIds {
+		if !containsImageId(imageIds, id) {
+			imageIds = append(imageIds, id)
+		}
+	}
+	if len(imageIds) == 0 || len(imageIds) > ALBUM_ADD_MAX || (body.Position != nil && *body.Position < 0) {
+		logger.Error("invalid album images sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - imageIds must list 1 to %v images and position may not be negative", ALBUM_ADD_MAX)))
+		return
+	}
+
+	position := -1
+	if body.Position != nil {
+		position = *body.Position
+	}
+
+	err = AddAlbumImages(album, imageIds, position)
+	if err == ErrAlbumImageNotFound {
+		logger.Error("album images not owned by user %v sending 404", album.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, every image must be one of your images"))
+		return
+	}
+	if err == ErrAlbumFull {
+		logger.Error("album %v is full sending 409", album.Id)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - Albums may contain at most %v images", ALBUM_MAX_IMAGES)))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to add album images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+
+	writeAlbumDetail(w, album)
+}
+
+// reorderAlbum accepts a json body listing every image id of the album in the order chosen by the owner
+func reorderAlbum(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		ImageIds []int32 `json:"imageIds"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	unique := []int32{}
+	for _, id := range body.ImageIds {
+		if !containsImageId(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) != len(body.ImageIds) || len(body.ImageIds) > ALBUM_MAX_IMAGES {
+		logger.Error("invalid album order sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - imageIds may list at most %v images and each image once", ALBUM_MAX_IMAGES)))
+		return
+	}
+
+	err = ReorderAlbumImages(album, body.ImageIds)
+	if err == ErrAlbumOrderMismatch {
+		logger.Error("order doesn't match the images of album %v sending 409", album.Id)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - imageIds must list every image of the album, retrieve the album and try again"))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to reorder album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+
+	writeAlbumDetail(w, album)
+}
+
+// setAlbumCover accepts a json body with the id of the image of the album to use as its cover
+func setAlbumCover(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		ImageId int32 `json:"imageId"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	set, err := SetAlbumCover(album.Id, body.ImageId)
+	if err != nil {
+		logger.Error("failed to set album cover sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+	if !set {
+		logger.Error("cover %v not in album %v sending 404", body.ImageId, album.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the image is not in this album"))
+		return
+	}
+
+	album.CoverId = body.ImageId
+	writeAlbumDetail(w, album)
+}
+
+// resetAlbumCover removes the cover chosen by the owner so the first image is the cover again
+func resetAlbumCover(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	_, err := SetAlbumCover(album.Id, 0)
+	if err != nil {
+		logger.Error("failed to reset album cover sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+
+	album.CoverId = 0
+	writeAlbumDetail(w, album)
+}
+
+// removeAlbumImage removes an image from the album, the image itself is kept
+func removeAlbumImage(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	imageId, err := strconv.Atoi(mux.Vars(req)["imageId"])
+	if err != nil {
+		logger.Error("invalid image id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	removed, err := RemoveAlbumImage(album.Id, int32(imageId))
+	if err != nil {
+		logger.Error("failed to remove album image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update album, try again later"))
+		return
+	}
+	if !removed {
+		logger.Error("image %v not in album %v sending 404", imageId, album.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the image is not in this album"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedAlbum authenticates the user and retrieves the album in the url owned by them
+// writes the error response and returns false otherwise, other users' albums are reported as not found
+func ownedAlbum(w http.ResponseWriter, req *http.Request) (Album, bool) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for album sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return Album{}, false
+	}
+
+	album, ok := findAlbum(w, req)
+	if !ok {
+		return Album{}, false
+	}
+	if album.Uid != int32(claims.Uid) {
+		logger.Error("album %v not owned by user %v sending 404", album.Id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no album with that id"))
+		return Album{}, false
+	}
+
+	return album, true
+}
+
+// findAlbum retrieves the album in the url, writing the error response and returning false if it doesn't exist
+func findAlbum(w http.ResponseWriter, req *http.Request) (Album, bool) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		logger.Error("invalid album id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return Album{}, false
+	}
+
+	album, ok, err := GetAlbum(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve album, try again later"))
+		return Album{}, false
+	}
+	if !ok {
+		logger.Error("album %v does not exist sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no album with that id"))
+		return Album{}, false
+	}
+
+	return album, true
+}
+
+// writeAlbumDetail writes the album with its images in order as the json response body
+func writeAlbumDetail(w http.ResponseWriter, album Album) {
+	images, err := AlbumImages(album.Id)
+	if err != nil {
+		logger.Error("failed to retrieve album images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve album, try again later"))
+		return
+	}
+
+	writeAlbumImages(w, album, images)
+}
+
+// writeAlbumImages writes the album with its images in order as the json response body
+func writeAlbumImages(w http.ResponseWriter, album Album, images []Image) {
+	imageIds := []int32{}
+	for _, image := range images {
+		imageIds = append(imageIds, image.Id)
+	}
+	var cover *Image
+	coverId := albumCover(album, imageIds)
+	for i := range images {
+		if images[i].Id == coverId {
+			cover = &images[i]
+		}
+	}
+
+	writeAlbumJSON(w, http.StatusOK, AlbumDetailResp{AlbumResp: albumResp(album, imageIds, cover), Images: images})
+}
+
+// writeAlbumJSON writes the response as json with the status code
+func writeAlbumJSON(w http.ResponseWriter, status int, resp interface{}) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// publiclyShared reports whether the image may be served publicly, either because it is
+// shareable itself or because it belongs to a shareable album, unless moderation restricted it
+func (s *Server) publiclyShared(ctx context.Context, image Image) (bool, error) {
+	restricted, err := s.store.ModerationRestricted(ctx, image.Id)
+	if err != nil || restricted {
+		return false, err
+	}
+	if image.Shareable {
+		return true, nil
+	}
+	return s.store.InShareableAlbum(ctx, image.Id)
+}
+
+// albumOrder returns the image ids of the album after inserting ids at position, ids already in
+// the album are moved. A negative position or one past the end appends the ids
+func albumOrder(current []int32, ids []int32, position int) []int32 {
+	kept := []int32{}
+	for _, id := range current {
+		if !containsImageId(ids, id) {
+			kept = append(kept, id)
+		}
+	}
+	if position < 0 || position > len(kept) {
+		position = len(kept)
+	}
+
+	order := append([]int32{}, kept[:position]...)
+	order = append(order, ids...)
+	return append(order, kept[position:]...)
+}
+
+// containsImageId reports whether the id is in the list
+func containsImageId(ids []int32, id int32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
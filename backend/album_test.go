@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestAlbumOrder ensures images are inserted at the requested position and existing images are moved
+func TestAlbumOrder(t *testing.T) {
+	tt := []struct {
+		Current  []int32
+		Ids      []int32
+		Position int
+		Expected []int32
+	}{
+		{[]int32{}, []int32{4, 5}, -1, []int32{4, 5}},
+		{[]int32{1, 2, 3}, []int32{4}, -1, []int32{1, 2, 3, 4}},
+		{[]int32{1, 2, 3}, []int32{4, 5}, 1, []int32{1, 4, 5, 2, 3}},
+		{[]int32{1, 2, 3}, []int32{3}, 0, []int32{3, 1, 2}},
+		{[]int32{1, 2, 3}, []int32{1}, 10, []int32{2, 3, 1}},
+	}
+
+	for _, tc := range tt {
+		order := albumOrder(tc.Current, tc.Ids, tc.Position)
+		if !reflect.DeepEqual(order, tc.Expected) {
+			t.Errorf("wrong order adding %v at %v to %v: got %v want %v", tc.Ids, tc.Position, tc.Current, order, tc.Expected)
+		}
+	}
+}
+
+// TestAlbumCover ensures the chosen cover is used while it is in the album and the first image otherwise
+func TestAlbumCover(t *testing.T) {
+	tt := []struct {
+		CoverId  int32
+		ImageIds []int32
+		Expected int32
+	}{
+		{0, []int32{}, 0},
+		{0, []int32{4, 5}, 4},
+		{5, []int32{4, 5}, 5},
+		{7, []int32{4, 5}, 4},
+		{7, []int32{}, 0},
+	}
+
+	for _, tc := range tt {
+		if cover := albumCover(Album{CoverId: tc.CoverId}, tc.ImageIds); cover != tc.Expected {
+			t.Errorf("wrong cover of %v choosing %v: got %v want %v", tc.ImageIds, tc.CoverId, cover, tc.Expected)
+		}
+	}
+}
+
+// TestAlbumParams ensures album properties are validated and omitted properties are unchanged
+func TestAlbumParams(t *testing.T) {
+	title := "  Holiday  "
+	album := Album{Title: "Old", Description: "kept"}
+	err := AlbumParams{Title: &title}.apply(&album)
+	if err != nil || album.Title != "Holiday" || album.Description != "kept" {
+		t.Errorf("wrong album update: got %+v %v", album, err)
+	}
+
+	// Control characters are removed, descriptions keep their line breaks
+	title, description := "Holi\x1bday\x00", "Day one\n\tbeach\r"
+	err = AlbumParams{Title: &title, Description: &description}.apply(&album)
+	if err != nil || album.Title != "Holiday" || album.Description != "Day one\n\tbeach" {
+		t.Errorf("wrong cleaned album: got %+v %v", album, err)
+	}
+
+	// Lengths are counted in characters rather than bytes
+	accented := strings.Repeat("é", ALBUM_TITLE_MAX)
+	if err := (AlbumParams{Title: &accented}).apply(&album); err != nil {
+		t.Errorf("expected title of %v characters to be accepted: %v", ALBUM_TITLE_MAX, err)
+	}
+
+	blank := " \x07"
+	long := strings.Repeat("a", ALBUM_DESCRIPTION_MAX+1)
+	for _, params := range []AlbumParams{{Title: &blank}, {Description: &long}} {
+		if err := params.apply(&album); err == nil {
+			t.Errorf("expected invalid params to be refused: %+v", params)
+		}
+	}
+}
+
+// TestAlbums ensures albums are created, filled, ordered and shared with their images
+func TestAlbums(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+	router := configureRoutes()
+
+	first := uploadTestImage(t, router, token, false)
+	second := uploadTestImage(t, router, token, false)
+
+	send := func(method string, path string, body string, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authenticated {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/album", `{"title": ""}`, true)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for album without title: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = send("POST", "/album", `{"title": "Holiday"}`, true)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to create album: got %v", rr.Code)
+	}
+	album := AlbumResp{}
+	json.Unmarshal(rr.Body.Bytes(), &album)
+
+	rr = send("POST", fmt.Sprintf("/album/%v/images", album.Id), fmt.Sprintf(`{"imageIds": [%v, %v]}`, first.Id, second.Id), true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to add album images: got %v", rr.Code)
+	}
+	rr = send("POST", fmt.Sprintf("/album/%v/images", album.Id), fmt.Sprintf(`{"imageIds": [%v], "position": 0}`, second.Id), true)
+	detail := AlbumDetailResp{}
+	json.Unmarshal(rr.Body.Bytes(), &detail)
+	if detail.ImageCount != 2 || len(detail.Images) != 2 || detail.Images[0].Id != second.Id {
+		t.Errorf("wrong album order: got %+v", detail)
+	}
+
+	rr = send("POST", fmt.Sprintf("/album/%v/images", album.Id), `{"imageIds": [999999]}`, true)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for image of another user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// The curated order and cover are returned by listings
+	orderPath := fmt.Sprintf("/album/%v/order", album.Id)
+	if rr = send("PUT", orderPath, fmt.Sprintf(`{"imageIds": [%v]}`, first.Id), true); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code for incomplete order: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr = send("PUT", orderPath, fmt.Sprintf(`{"imageIds": [%v, %v, %v]}`, first.Id, second.Id, first.Id), true); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for order repeating an image: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	rr = send("PUT", orderPath, fmt.Sprintf(`{"imageIds": [%v, %v]}`, first.Id, second.Id), true)
+	detail = AlbumDetailResp{}
+	json.Unmarshal(rr.Body.Bytes(), &detail)
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(detail.ImageIds, []int32{first.Id, second.Id}) || detail.CoverId != first.Id {
+		t.Errorf("wrong reordered album: got %v %+v", rr.Code, detail.AlbumResp)
+	}
+
+	coverPath := fmt.Sprintf("/album/%v/cover", album.Id)
+	if rr = send("PUT", coverPath, `{"imageId": 999999}`, true); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for cover outside the album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("PUT", coverPath, fmt.Sprintf(`{"imageId": %v}`, second.Id), true); rr.Code != http.StatusOK {
+		t.Errorf("failed to set cover: got %v", rr.Code)
+	}
+	albums := []AlbumResp{}
+	json.Unmarshal(send("GET", "/album", "", true).Body.Bytes(), &albums)
+	if len(albums) != 1 || !reflect.DeepEqual(albums[0].ImageIds, []int32{first.Id, second.Id}) || albums[0].Cover == nil || albums[0].Cover.Id != second.Id {
+		t.Errorf("wrong order or cover in album list: got %+v", albums)
+	}
+	if rr = send("DELETE", coverPath, "", true); rr.Code != http.StatusOK {
+		t.Errorf("failed to reset cover: got %v", rr.Code)
+	}
+	detail = AlbumDetailResp{}
+	json.Unmarshal(send("GET", fmt.Sprintf("/album/%v", album.Id), "", true).Body.Bytes(), &detail)
+	if detail.CoverId != first.Id {
+		t.Errorf("wrong cover after reset: got %v want %v", detail.CoverId, first.Id)
+	}
+
+	// Images are shared publicly while the album is shareable
+	publicImage := fmt.Sprintf("/public/image/%v/%v", first.Uid, first.Id)
+	if rr = send("GET", publicImage, "", false); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for image in private album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("GET", fmt.Sprintf("/public/album/%v", album.Id), "", false); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for private album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr = send("PUT", fmt.Sprintf("/album/%v", album.Id), `{"shareable": true}`, true); rr.Code != http.StatusOK {
+		t.Fatalf("failed to share album: got %v", rr.Code)
+	}
+	if rr = send("GET", publicImage, "", false); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for image in shareable album: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr = send("GET", fmt.Sprintf("/public/album/%v", album.Id), "", false); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for shareable album: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if rr = send("DELETE", fmt.Sprintf("/album/%v/images/%v", album.Id, first.Id), "", true); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to remove album image: got %v", rr.Code)
+	}
+	if rr = send("GET", publicImage, "", false); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for image removed from shareable album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = send("GET", "/album", "", true)
+	albums = []AlbumResp{}
+	json.Unmarshal(rr.Body.Bytes(), &albums)
+	if len(albums) != 1 || albums[0].ImageCount != 1 || !albums[0].Shareable {
+		t.Errorf("wrong album list: got %+v", albums)
+	}
+
+	if rr = send("DELETE", fmt.Sprintf("/album/%v", album.Id), "", true); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to delete album: got %v", rr.Code)
+	}
+	if rr = send("GET", fmt.Sprintf("/album/%v", album.Id), "", true); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for deleted album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+/*
+	This file emits analytics events describing how images are used: uploads, views, shares and
+	searches. Events are queued in memory and delivered in batches by a background goroutine to the
+	configured sink, a JSON lines file, an HTTP collector or the outbox where handlers may publish
+	them to a message bus. Analytics are best effort: events are dropped rather than delaying
+	requests when the queue is full or the sink fails. User ids are anonymized unless configured
+	otherwise so external tools can count distinct users without identifying them.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	// Analytics sinks
+	ANALYTICS_NONE   = "none" // Default, analytics are disabled
+	ANALYTICS_FILE   = "file"
+	ANALYTICS_HTTP   = "http"
+	ANALYTICS_OUTBOX = "outbox"
+
+	// Handling of user ids in events
+	ANALYTICS_ANONYMIZED = "anonymized" // Default, ids are replaced by a keyed hash
+	ANALYTICS_RAW        = "raw"
+
+	ANALYTICS_FILE_PATH = "analytics.log" // Default if ANALYTICS_FILE env variable is not defined
+	ANALYTICS_SCHEMA    = 1               // Version of the event format, increased on incompatible changes
+	ANALYTICS_QUEUE     = 4096            // Events waiting for delivery before new events are dropped
+	ANALYTICS_BATCH     = 100             // Maximum events passed to the sink at once
+	ANALYTICS_FLUSH     = 5               // Seconds before a partial batch is delivered
+	ANALYTICS_TIMEOUT   = 10              // Seconds allowed for an HTTP collector to accept a batch
+
+	// Event types
+	ANALYTICS_UPLOAD = "image.upload"
+	ANALYTICS_VIEW   = "image.view"
+	ANALYTICS_SHARE  = "image.share"
+	ANALYTICS_SEARCH = "image.search"
+)
+
+// AnalyticsConfig selects the sink of analytics events and how users are identified
+type AnalyticsConfig struct {
+	Sink    string `yaml:"sink"`
+	File    string `yaml:"file"`    // Path of the file sink
+	URL     string `yaml:"url"`     // Address the http sink posts batches to
+	UserIds string `yaml:"userIds"` // anonymized or raw
+	Salt    string `yaml:"salt"`    // Key of anonymized ids, defaults to the signing key
+}
+
+// AnalyticsEvent is a single analytics event, the format is versioned by Schema
+type AnalyticsEvent struct {
+	Schema  int               `json:"schema"`
+	Id      string            `json:"id"` // Random id letting sinks discard repeated deliveries
+	Type    string            `json:"type"`
+	Time    Timestamp         `json:"time"`
+	User    string            `json:"user,omitempty"`  // Empty for anonymous requests
+	Owner   string            `json:"owner,omitempty"` // Owner of the image, identified like User
+	ImageId int32             `json:"imageId,omitempty"`
+	Props   map[string]string `json:"props,omitempty"`
+}
+
+// AnalyticsSink delivers batches of analytics events
+type AnalyticsSink interface {
+	Name() string
+	Send(events []AnalyticsEvent) error
+	Close() error
+}
+
+// analyticsEmitter queues events for delivery to a sink
+type analyticsEmitter struct {
+	sink    AnalyticsSink
+	config  AnalyticsConfig
+	queue   chan AnalyticsEvent
+	done    chan struct{}
+	dropped sync.Once
+
+	// closed is set under the write lock once the queue is closed so late events are discarded
+	lock   sync.RWMutex
+	closed bool
+}
+
+// analytics is the emitter used by handlers, nil while analytics are disabled
+var analytics *analyticsEmitter
+
+// startAnalytics starts delivering events to the configured sink
+// the outbox sink writes to the database so it can't be used by read-only instances
+func startAnalytics(config AnalyticsConfig) error {
+	if config.Sink == ANALYTICS_OUTBOX && readOnly() {
+		return fmt.Errorf("the %s analytics sink cannot be used in read-only mode", ANALYTICS_OUTBOX)
+	}
+
+	sink, err := newAnalyticsSink(config)
+	if err != nil || sink == nil {
+		return err
+	}
+
+	analytics = newAnalyticsEmitter(sink, config)
+	logger.Info("Emitting analytics events to %s sink", sink.Name())
+	return nil
+}
+
+// newAnalyticsSink returns the sink named by the configuration, nil when analytics are disabled
+func newAnalyticsSink(config AnalyticsConfig) (AnalyticsSink, error) {
+	switch config.Sink {
+	case ANALYTICS_NONE:
+		return nil, nil
+	case ANALYTICS_FILE:
+		file, err := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open analytics file: %v", err)
+		}
+		return &fileSink{file: file}, nil
+	case ANALYTICS_HTTP:
+		return &httpSink{url: config.URL, client: &http.Client{Timeout: ANALYTICS_TIMEOUT * time.Second}}, nil
+	case ANALYTICS_OUTBOX:
+		return outboxSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown analytics sink %q", config.Sink)
+	}
+}
+
+// newAnalyticsEmitter starts the goroutine delivering queued events to the sink
+func newAnalyticsEmitter(sink AnalyticsSink, config AnalyticsConfig) *analyticsEmitter {
+	emitter := &analyticsEmitter{
+		sink:   sink,
+		config: config,
+		queue:  make(chan AnalyticsEvent, ANALYTICS_QUEUE),
+		done:   make(chan struct{}),
+	}
+	go emitter.run()
+	return emitter
+}
+
+// emitAnalytics queues an event about the image, uid is 0 for anonymous requests
+// props should describe the request without including user content
+func emitAnalytics(eventType string, uid int, imageMeta Image, props map[string]string) {
+	emitter := analytics
+	if emitter == nil {
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := AnalyticsEvent{
+		Schema:  ANALYTICS_SCHEMA,
+		Id:      hex.EncodeToString(id),
+		Type:    eventType,
+		Time:    Timestamp(time.Now().UTC()),
+		User:    emitter.userId(uid),
+		Owner:   emitter.userId(int(imageMeta.Uid)),
+		ImageId: imageMeta.Id,
+		Props:   props,
+	}
+
+	emitter.lock.RLock()
+	defer emitter.lock.RUnlock()
+	if emitter.closed {
+		return
+	}
+
+	select {
+	case emitter.queue <- event:
+	default:
+		// Only the first drop is logged to avoid flooding the log while the sink is slow
+		emitter.dropped.Do(func() {
+			logger.Warning("analytics queue is full, events are being dropped")
+		})
+	}
+}
+
+// userId returns the id identifying the user in events, empty for anonymous requests
+func (e *analyticsEmitter) userId(uid int) string {
+	if uid == 0 {
+		return ""
+	}
+	if e.config.UserIds == ANALYTICS_RAW {
+		return strconv.Itoa(uid)
+	}
+	return anonymizeUser(uid, e.config.Salt)
+}
+
+// anonymizeUser returns a stable keyed hash of the uid, the same user always has the same id
+// while the salt is unchanged but the uid can't be recovered without it
+func anonymizeUser(uid int, salt string) string {
+	key := []byte(salt)
+	if len(key) == 0 {
+		key = getSigningKey()
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(uid)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// run delivers queued events in batches until the queue is closed
+func (e *analyticsEmitter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(ANALYTICS_FLUSH * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]AnalyticsEvent, 0, ANALYTICS_BATCH)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.sink.Send(batch)
+		if err != nil {
+			logger.Error("failed to deliver %v analytics events to %s sink: %v", len(batch), e.sink.Name(), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) == ANALYTICS_BATCH {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// stopAnalytics delivers the queued events and closes the sink
+func stopAnalytics() error {
+	emitter := analytics
+	if emitter == nil {
+		return nil
+	}
+	analytics = nil
+
+	emitter.lock.Lock()
+	emitter.closed = true
+	close(emitter.queue)
+	emitter.lock.Unlock()
+
+	<-emitter.done
+	return emitter.sink.Close()
+}
+
+// fileSink appends events to a file as JSON lines
+type fileSink struct {
+	file *os.File
+}
+
+func (s *fileSink) Name() string {
+	return ANALYTICS_FILE
+}
+
+func (s *fileSink) Send(events []AnalyticsEvent) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		err := encoder.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.file.Write(buf.Bytes())
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// httpSink posts each batch to a collector as a json array
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Name() string {
+	return ANALYTICS_HTTP
+}
+
+func (s *httpSink) Send(events []AnalyticsEvent) error {
+	js, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded %v", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// outboxSink writes events to the outbox with the topic analytics.<type>, handlers registered
+// for these topics may publish them to a message bus with at least once delivery
+type outboxSink struct{}
+
+func (outboxSink) Name() string {
+	return ANALYTICS_OUTBOX
+}
+
+func (outboxSink) Send(events []AnalyticsEvent) error {
+	return AddAnalyticsEvents(events)
+}
+
+func (outboxSink) Close() error {
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memorySink records delivered events for inspection by tests
+type memorySink struct {
+	sync.Mutex
+	events []AnalyticsEvent
+	closed bool
+}
+
+func (s *memorySink) Name() string {
+	return "memory"
+}
+
+func (s *memorySink) Send(events []AnalyticsEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// TestAnonymizeUser ensures anonymized ids are stable for a salt and don't reveal the uid
+func TestAnonymizeUser(t *testing.T) {
+	id := anonymizeUser(42, "salt")
+	if id != anonymizeUser(42, "salt") {
+		t.Errorf("anonymized id is not stable")
+	}
+	if id == anonymizeUser(43, "salt") || id == anonymizeUser(42, "pepper") {
+		t.Errorf("anonymized id doesn't depend on the uid and salt")
+	}
+	if id == "42" || len(id) != 32 {
+		t.Errorf("wrong anonymized id: got %s", id)
+	}
+	if anonymizeUser(42, "") != anonymizeUser(42, string(getSigningKey())) {
+		t.Errorf("empty salt doesn't fall back to the signing key")
+	}
+}
+
+// TestEmitAnalytics ensures queued events are delivered to the sink when analytics stop
+func TestEmitAnalytics(t *testing.T) {
+	emitAnalytics(ANALYTICS_VIEW, 1, Image{}, nil) // Disabled analytics ignore events
+
+	sink := &memorySink{}
+	analytics = newAnalyticsEmitter(sink, AnalyticsConfig{UserIds: ANALYTICS_RAW})
+	emitAnalytics(ANALYTICS_UPLOAD, 7, Image{Id: 3, Uid: 7}, map[string]string{"encoding": "image/png"})
+	emitAnalytics(ANALYTICS_VIEW, 0, Image{Id: 3, Uid: 7}, map[string]string{"access": "public"})
+	emitAnalytics(ANALYTICS_SEARCH, 7, Image{}, map[string]string{"filters": "tag"})
+
+	err := stopAnalytics()
+	if err != nil {
+		t.Fatalf("failed to stop analytics: %v", err)
+	}
+	emitAnalytics(ANALYTICS_VIEW, 7, Image{}, nil) // Events after stopping are discarded
+
+	if len(sink.events) != 3 || !sink.closed {
+		t.Fatalf("wrong events delivered: got %+v closed %v", sink.events, sink.closed)
+	}
+	upload, view, search := sink.events[0], sink.events[1], sink.events[2]
+	if upload.Type != ANALYTICS_UPLOAD || upload.User != "7" || upload.Owner != "7" || upload.ImageId != 3 || upload.Schema != ANALYTICS_SCHEMA || len(upload.Id) == 0 {
+		t.Errorf("wrong upload event: got %+v", upload)
+	}
+	if view.User != "" || view.Owner != "7" || view.Props["access"] != "public" {
+		t.Errorf("wrong anonymous view event: got %+v", view)
+	}
+	if search.ImageId != 0 || search.Owner != "" || upload.Id == search.Id {
+		t.Errorf("wrong search event: got %+v", search)
+	}
+}
+
+// TestFileSink ensures events are appended to the file as JSON lines
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "analytics.log")
+	for i := 0; i < 2; i++ {
+		sink, err := newAnalyticsSink(AnalyticsConfig{Sink: ANALYTICS_FILE, File: path})
+		if err != nil {
+			t.Fatalf("failed to open file sink: %v", err)
+		}
+		err = sink.Send([]AnalyticsEvent{{Type: ANALYTICS_UPLOAD}, {Type: ANALYTICS_VIEW}})
+		if err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+		sink.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open analytics file: %v", err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AnalyticsEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Errorf("line %v is not an event: %v", lines, err)
+		}
+		lines++
+	}
+	if lines != 4 {
+		t.Errorf("wrong number of events appended: got %v want 4", lines)
+	}
+}
+
+// TestHTTPSink ensures batches are posted as json arrays and rejected batches are reported
+func TestHTTPSink(t *testing.T) {
+	var received []AnalyticsEvent
+	status := http.StatusAccepted
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer collector.Close()
+
+	sink, err := newAnalyticsSink(AnalyticsConfig{Sink: ANALYTICS_HTTP, URL: collector.URL})
+	if err != nil {
+		t.Fatalf("failed to create http sink: %v", err)
+	}
+
+	err = sink.Send([]AnalyticsEvent{{Type: ANALYTICS_SHARE, ImageId: 5}})
+	if err != nil || len(received) != 1 || received[0].ImageId != 5 {
+		t.Errorf("batch not delivered: got %+v %v", received, err)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err = sink.Send([]AnalyticsEvent{{Type: ANALYTICS_SHARE}}); err == nil {
+		t.Errorf("expected error for rejected batch")
+	}
+}
+
+// TestSearchFilters ensures only the names of search filters are recorded
+func TestSearchFilters(t *testing.T) {
+	filters := searchFilters(url.Values{"tag": {"holiday"}, "page": {"2"}, "encoding": {"image/png"}})
+	if filters != "encoding,tag" {
+		t.Errorf("wrong search filters: got %s", filters)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+/*
+	This file implements announcements, notices administrators publish to every client such as
+	upcoming maintenance windows or policy updates. Each announcement has a severity and is shown
+	from its start until its end, announcements without an end are shown until they are deleted.
+	Clients poll GET /announcements without signing in, so notices of outages affecting sign in
+	still reach them, and may list upcoming announcements to warn users ahead of maintenance.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	ANNOUNCEMENT_TABLE = "announcement"
+
+	ANNOUNCEMENT_TITLE_MAX   = 200       // Characters allowed in the title of an announcement
+	ANNOUNCEMENT_MESSAGE_MAX = 2000      // Characters allowed in the message of an announcement
+	ANNOUNCEMENT_RETENTION   = 30 * 24   // Hours ended announcements are listed to administrators before they are purged
+	ANNOUNCEMENT_MAX_AGE     = 60        // Seconds clients may cache the active announcements
+	ANNOUNCEMENT_INTERVAL    = time.Hour // Interval between purges of ended announcements
+
+	// Announcement severities, most severe last
+	SEVERITY_INFO     = "info"
+	SEVERITY_WARNING  = "warning"
+	SEVERITY_CRITICAL = "critical"
+)
+
+// announcementSeverities lists the severities in order of increasing severity
+var announcementSeverities = []string{SEVERITY_INFO, SEVERITY_WARNING, SEVERITY_CRITICAL}
+
+// Announcement is a notice published to clients tagged for sql serialization
+type Announcement struct {
+	Id        int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Title     string    `sql:"title"`
+	Message   string    `sql:"message"`
+	Severity  string    `sql:"severity"`
+	Starts    time.Time `sql:"starts"`
+	Ends      time.Time `sql:"ends"` // Zero for announcements shown until they are deleted
+	CreatedBy int32     `sql:"created_by"`
+	Created   time.Time `sql:"created"`
+	Updated   time.Time `sql:"updated"`
+}
+
+// AnnouncementParams describe an announcement created or replaced by an administrator
+// starts defaults to now and ends may be omitted to show the announcement until it's deleted
+type AnnouncementParams struct {
+	Title    string     `json:"title"`
+	Message  string     `json:"message"`
+	Severity string     `json:"severity"`
+	Starts   *Timestamp `json:"starts"`
+	Ends     *Timestamp `json:"ends"`
+}
+
+// AnnouncementResp is the json representation of an announcement
+type AnnouncementResp struct {
+	Id       int32      `json:"id"`
+	Title    string     `json:"title"`
+	Message  string     `json:"message"`
+	Severity string     `json:"severity"`
+	Starts   Timestamp  `json:"starts"`
+	Ends     *Timestamp `json:"ends,omitempty"`
+	Active   bool       `json:"active"`
+}
+
+func init() {
+	RegisterPurgeJob("announcements", ANNOUNCEMENT_INTERVAL, ANNOUNCEMENT_TABLE, "ends > $1 AND ends < $2", func() []interface{} {
+		return []interface{}{time.Time{}, time.Now().UTC().Add(-ANNOUNCEMENT_RETENTION * time.Hour)}
+	})
+}
+
+// active reports whether the announcement is shown at the time
+func (a Announcement) active(now time.Time) bool {
+	return !now.Before(a.Starts) && (a.Ends.IsZero() || now.Before(a.Ends))
+}
+
+// Resp returns the json representation of the announcement
+func (a Announcement) Resp() AnnouncementResp {
+	resp := AnnouncementResp{
+		Id:       a.Id,
+		Title:    a.Title,
+		Message:  a.Message,
+		Severity: a.Severity,
+		Starts:   Timestamp(a.Starts),
+		Active:   a.active(time.Now().UTC()),
+	}
+	if !a.Ends.IsZero() {
+		ends := Timestamp(a.Ends)
+		resp.Ends = &ends
+	}
+	return resp
+}
+
+// severityRank returns the position of the severity in announcementSeverities
+func severityRank(severity string) int {
+	for i, listed := range announcementSeverities {
+		if listed == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// newAnnouncement validates the parameters and returns the announcement they describe
+func (p AnnouncementParams) newAnnouncement(now time.Time) (Announcement, error) {
+	announcement := Announcement{
+		Title:    strings.TrimSpace(cleanText(p.Title, false)),
+		Message:  strings.TrimSpace(cleanText(p.Message, true)),
+		Severity: strings.ToLower(p.Severity),
+		Starts:   now,
+		Created:  now,
+		Updated:  now,
+	}
+
+	if len(announcement.Title) == 0 || utf8.RuneCountInString(announcement.Title) > ANNOUNCEMENT_TITLE_MAX {
+		return Announcement{}, fmt.Errorf("title is required and may not exceed %v characters", ANNOUNCEMENT_TITLE_MAX)
+	}
+	if utf8.RuneCountInString(announcement.Message) > ANNOUNCEMENT_MESSAGE_MAX {
+		return Announcement{}, fmt.Errorf("message may not exceed %v characters", ANNOUNCEMENT_MESSAGE_MAX)
+	}
+	if len(announcement.Severity) == 0 {
+		announcement.Severity = SEVERITY_INFO
+	}
+	if severityRank(announcement.Severity) < 0 {
+		return Announcement{}, fmt.Errorf("severity must be one of %s", strings.Join(announcementSeverities, ", "))
+	}
+	if p.Starts != nil {
+		announcement.Starts = p.Starts.Time().UTC()
+	}
+	if p.Ends != nil {
+		announcement.Ends = p.Ends.Time().UTC()
+		if !announcement.Ends.After(announcement.Starts) {
+			return Announcement{}, fmt.Errorf("ends must be after starts")
+		}
+	}
+
+	return announcement, nil
+}
+
+// sortAnnouncements orders announcements most severe first then by start
+func sortAnnouncements(announcements []Announcement) {
+	sort.SliceStable(announcements, func(i, j int) bool {
+		a, b := announcements[i], announcements[j]
+		if rank, other := severityRank(a.Severity), severityRank(b.Severity); rank != other {
+			return rank > other
+		}
+		return a.Starts.Before(b.Starts)
+	})
+}
+
+// listAnnouncements responds with the announcements shown now, most severe first, without requiring
+// sign in. upcoming=true includes announcements that haven't started yet
+func listAnnouncements(w http.ResponseWriter, req *http.Request) {
+	upcoming := req.URL.Query().Get("upcoming") == "true"
+	announcements, err := CurrentAnnouncements(time.Now().UTC(), upcoming)
+	if err != nil {
+		logger.Error("failed to retrieve announcements sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve announcements, try again later"))
+		return
+	}
+
+	sortAnnouncements(announcements)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", ANNOUNCEMENT_MAX_AGE))
+	writeAnnouncements(w, announcements)
+}
+
+// adminListAnnouncements responds with every announcement including scheduled and ended announcements
+func adminListAnnouncements(w http.ResponseWriter, req *http.Request) {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list announcements: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	announcements, err := AllAnnouncements()
+	if err != nil {
+		logger.Error("failed to retrieve announcements sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve announcements, try again later"))
+		return
+	}
+
+	writeAnnouncements(w, announcements)
+}
+
+// createAnnouncement publishes the announcement described by the json body
+func createAnnouncement(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to create announcement: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	announcement, ok := decodeAnnouncement(w, req)
+	if !ok {
+		return
+	}
+	announcement.CreatedBy = int32(claims.Uid)
+
+	announcement.Id, err = AddAnnouncement(announcement)
+	if err != nil {
+		logger.Error("failed to add announcement sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to publish announcement, try again later"))
+		return
+	}
+
+	logger.Info("Administrator %v published %s announcement %v", claims.Uid, announcement.Severity, announcement.Id)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/announcements/%v", versionPrefix(req), announcement.Id))
+	writeAnnouncement(w, http.StatusCreated, announcement)
+}
+
+// updateAnnouncement replaces the announcement with the id by the one described by the json body
+func updateAnnouncement(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update announcement: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	announcement, ok := decodeAnnouncement(w, req)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	announcement.Id = int32(id)
+	announcement, found, err := ReplaceAnnouncement(announcement)
+	if err != nil {
+		logger.Error("failed to update announcement sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update announcement, try again later"))
+		return
+	}
+	if !found {
+		logger.Error("announcement %v not found sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no announcement with that id"))
+		return
+	}
+
+	logger.Info("Administrator %v updated announcement %v", claims.Uid, id)
+	writeAnnouncement(w, http.StatusOK, announcement)
+}
+
+// deleteAnnouncement withdraws the announcement with the id
+func deleteAnnouncement(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to delete announcement: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	found, err := DeleteAnnouncement(int32(id))
+	if err != nil {
+		logger.Error("failed to delete announcement sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete announcement, try again later"))
+		return
+	}
+	if !found {
+		logger.Error("announcement %v not found sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no announcement with that id"))
+		return
+	}
+
+	logger.Info("Administrator %v deleted announcement %v", claims.Uid, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeAnnouncement reads and validates the announcement of the json body, reporting false
+// once the failure has been written to the client
+func decodeAnnouncement(w http.ResponseWriter, req *http.Request) (Announcement, bool) {
+	params := AnnouncementParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return Announcement{}, false
+	}
+
+	announcement, err := params.newAnnouncement(time.Now().UTC())
+	if err != nil {
+		logger.Error("invalid announcement sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return Announcement{}, false
+	}
+	return announcement, true
+}
+
+// writeAnnouncement writes the announcement as the json response body
+func writeAnnouncement(w http.ResponseWriter, status int, announcement Announcement) {
+	js, err := json.Marshal(announcement.Resp())
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// writeAnnouncements writes the announcements as a json array response body
+func writeAnnouncements(w http.ResponseWriter, announcements []Announcement) {
+	resps := []AnnouncementResp{}
+	for _, announcement := range announcements {
+		resps = append(resps, announcement.Resp())
+	}
+
+	js, err := json.Marshal(resps)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewAnnouncement ensures omitted fields use the defaults and invalid announcements are refused
+func TestNewAnnouncement(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	starts, ends := Timestamp(now.Add(time.Hour)), Timestamp(now.Add(3*time.Hour))
+
+	announcement, err := AnnouncementParams{Title: " Maintenance\n", Message: "Uploads pause\nbriefly"}.newAnnouncement(now)
+	if err != nil || announcement.Title != "Maintenance" || announcement.Message != "Uploads pause\nbriefly" || announcement.Severity != SEVERITY_INFO ||
+		!announcement.Starts.Equal(now) || !announcement.Ends.IsZero() {
+		t.Errorf("wrong default announcement: got %+v %v", announcement, err)
+	}
+
+	announcement, err = AnnouncementParams{Title: "Maintenance", Severity: "Critical", Starts: &starts, Ends: &ends}.newAnnouncement(now)
+	if err != nil || announcement.Severity != SEVERITY_CRITICAL || !announcement.Starts.Equal(starts.Time()) || !announcement.Ends.Equal(ends.Time()) {
+		t.Errorf("wrong scheduled announcement: got %+v %v", announcement, err)
+	}
+	if announcement.active(now) || !announcement.active(now.Add(time.Hour)) || announcement.active(now.Add(3*time.Hour)) {
+		t.Errorf("expected announcement to be active from its start until its end")
+	}
+
+	for _, params := range []AnnouncementParams{
+		{},
+		{Title: strings.Repeat("a", ANNOUNCEMENT_TITLE_MAX+1)},
+		{Title: "Maintenance", Message: strings.Repeat("a", ANNOUNCEMENT_MESSAGE_MAX+1)},
+		{Title: "Maintenance", Severity: "urgent"},
+		{Title: "Maintenance", Starts: &ends, Ends: &starts},
+	} {
+		if _, err := params.newAnnouncement(now); err == nil {
+			t.Errorf("expected %+v to be refused", params)
+		}
+	}
+}
+
+// TestSortAnnouncements ensures the most severe announcements are listed first
+func TestSortAnnouncements(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	announcements := []Announcement{
+		{Id: 1, Severity: SEVERITY_INFO, Starts: now},
+		{Id: 2, Severity: SEVERITY_CRITICAL, Starts: now.Add(time.Hour)},
+		{Id: 3, Severity: SEVERITY_WARNING, Starts: now},
+		{Id: 4, Severity: SEVERITY_CRITICAL, Starts: now},
+	}
+	sortAnnouncements(announcements)
+	for i, id := range []int32{4, 2, 3, 1} {
+		if announcements[i].Id != id {
+			t.Fatalf("wrong order: got %+v", announcements)
+		}
+	}
+}
+
+// TestAnnouncements ensures announcements published by administrators are listed to clients without
+// signing in while they are active and withdrawn when deleted
+func TestAnnouncements(t *testing.T) {
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	router := configureRoutes()
+	admin, _, _ := generateJWT(7, testUser.Email)
+	member, _, _ := generateJWT(8, testUser.Email)
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	listed := func(query string, id int32) bool {
+		announcements := []AnnouncementResp{}
+		json.Unmarshal(send("GET", "/announcements"+query, "", "").Body.Bytes(), &announcements)
+		for _, announcement := range announcements {
+			if announcement.Id == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	if rr := send("POST", "/admin/announcements", `{"title": "Maintenance"}`, member); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code publishing as a member: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	rr := send("POST", "/admin/announcements", `{"title": "Maintenance", "message": "Uploads pause tonight", "severity": "warning"}`, admin)
+	active := AnnouncementResp{}
+	json.Unmarshal(rr.Body.Bytes(), &active)
+	if rr.Code != http.StatusCreated || active.Id == 0 || !active.Active || active.Ends != nil {
+		t.Fatalf("failed to publish announcement: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	starts := time.Now().UTC().Add(24 * time.Hour).Format(time.RFC3339)
+	ends := time.Now().UTC().Add(26 * time.Hour).Format(time.RFC3339)
+	rr = send("POST", "/admin/announcements", fmt.Sprintf(`{"title": "Upgrade", "starts": %q, "ends": %q}`, starts, ends), admin)
+	scheduled := AnnouncementResp{}
+	json.Unmarshal(rr.Body.Bytes(), &scheduled)
+	if rr.Code != http.StatusCreated || scheduled.Active || scheduled.Ends == nil {
+		t.Fatalf("failed to schedule announcement: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	if !listed("", active.Id) || listed("", scheduled.Id) || !listed("?upcoming=true", scheduled.Id) {
+		t.Errorf("expected only the active announcement to be listed unless upcoming announcements are requested")
+	}
+
+	rr = send("PUT", fmt.Sprintf("/admin/announcements/%v", scheduled.Id), `{"title": "Upgrade", "severity": "critical"}`, admin)
+	updated := AnnouncementResp{}
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	if rr.Code != http.StatusOK || updated.Severity != SEVERITY_CRITICAL || !updated.Active || !listed("", scheduled.Id) {
+		t.Errorf("failed to update announcement: got %v %+v", rr.Code, updated)
+	}
+
+	for _, id := range []int32{active.Id, scheduled.Id} {
+		if rr := send("DELETE", fmt.Sprintf("/admin/announcements/%v", id), "", admin); rr.Code != http.StatusNoContent {
+			t.Errorf("failed to delete announcement %v: got %v", id, rr.Code)
+		}
+		if listed("?upcoming=true", id) {
+			t.Errorf("expected deleted announcement %v to be withdrawn", id)
+		}
+	}
+	if rr := send("DELETE", fmt.Sprintf("/admin/announcements/%v", active.Id), "", admin); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code deleting a deleted announcement: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+/*
+	This file implements API keys, long lived credentials users create for scripts and integrations
+	that can't sign in. A key is sent in the X-API-Key header and acts for its user like an auth
+	token until it is deleted. Only the sha256 hash of a key is stored so the key is returned once
+	when it is created, its prefix identifies it in listings. Keys can't manage keys, creating and
+	deleting them requires signing in.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	API_KEY_TABLE = "api_key"
+
+	API_KEY_HEADER   = "X-API-Key"
+	API_KEY_PREFIX   = "pk_"           // Marks keys so they are recognizable in leaked secrets
+	API_KEY_BYTES    = 32              // Random bytes of a key
+	API_KEY_SHOWN    = 8               // Characters of a key listed to identify it
+	API_KEY_MAX      = 20              // Keys a user may have at once
+	API_KEY_NAME_MAX = 100             // Characters allowed in the name of a key
+	API_KEY_TOUCH    = 5 * time.Minute // Interval between updates of the last use of a key
+)
+
+// ApiKey is a key of a user tagged for sql serialization
+type ApiKey struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32     `sql:"uid"`
+	Name     string    `sql:"name"`
+	Prefix   string    `sql:"prefix"` // First characters of the key
+	KeyHash  string    `sql:"key_hash" opt:"UNIQUE"`
+	Created  time.Time `sql:"created"`
+	LastUsed time.Time `sql:"last_used"`
+}
+
+// ApiKeyParams names a new key
+type ApiKeyParams struct {
+	Name string `json:"name"`
+}
+
+// ApiKeyResp describes a key to its user, the key itself is only returned when it is created
+type ApiKeyResp struct {
+	Id       int32      `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Key      string     `json:"key,omitempty"`
+	Created  Timestamp  `json:"created"`
+	LastUsed *Timestamp `json:"lastUsed,omitempty"` // Omitted until the key is used
+}
+
+// Resp returns the response describing the key
+func (k ApiKey) Resp() ApiKeyResp {
+	resp := ApiKeyResp{Id: k.Id, Name: k.Name, Prefix: k.Prefix, Created: Timestamp(k.Created)}
+	if !k.LastUsed.IsZero() {
+		lastUsed := Timestamp(k.LastUsed)
+		resp.LastUsed = &lastUsed
+	}
+	return resp
+}
+
+// newApiKey returns a random key and the hash stored in its place
+func newApiKey() (string, string, error) {
+	buf := make([]byte, API_KEY_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %v", err)
+	}
+	key := API_KEY_PREFIX + hex.EncodeToString(buf)
+	return key, hashToken(key), nil
+}
+
+// apiKeyResolver authenticates the API key of the X-API-Key header
+type apiKeyResolver struct{}
+
+func (apiKeyResolver) Name() string {
+	return AUTH_API_KEY
+}
+
+func (apiKeyResolver) Resolve(req *http.Request) (Principal, bool, error) {
+	key := req.Header.Get(API_KEY_HEADER)
+	if len(key) == 0 {
+		return Principal{}, false, nil
+	}
+
+	apiKey, found, err := GetApiKeyByHash(hashToken(key))
+	if err != nil {
+		return Principal{}, true, err
+	}
+	if !found {
+		return Principal{}, true, fmt.Errorf("unknown api key")
+	}
+	user, err := GetUserById(apiKey.Uid)
+	if err != nil {
+		return Principal{}, true, err
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(apiKey.LastUsed) > API_KEY_TOUCH {
+		err = TouchApiKey(apiKey.Id, now)
+		if err != nil {
+			logger.Error("failed to record use of api key %v: %v", apiKey.Id, err)
+		}
+	}
+
+	return Principal{Uid: int(user.Uid), Email: user.Email, KeyId: apiKey.Id}, true, nil
+}
+
+// authSignedIn authenticates a request managing credentials, which must not be made with an API key
+func authSignedIn(w http.ResponseWriter, req *http.Request, action string) (JWTClaims, bool) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to %s sending 401: %v", action, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return JWTClaims{}, false
+	}
+	if principal, _ := requestPrincipal(req); principal.Method == AUTH_API_KEY {
+		logger.Error("api key %v used to %s sending 403", principal.KeyId, action)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprintf("403 - Forbidden, sign in to %s", action)))
+		return JWTClaims{}, false
+	}
+	return claims, true
+}
+
+// createApiKey accepts a json body naming a new key and returns the key
+func createApiKey(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage api keys")
+	if !ok {
+		return
+	}
+
+	params := ApiKeyParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	name := strings.TrimSpace(cleanText(params.Name, false))
+	if len(name) == 0 || utf8.RuneCountInString(name) > API_KEY_NAME_MAX {
+		logger.Error("invalid api key name sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - name is required and may not exceed %v characters", API_KEY_NAME_MAX)))
+		return
+	}
+
+	count, err := CountApiKeys(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to count api keys sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create api key, try again later"))
+		return
+	}
+	if count >= API_KEY_MAX {
+		logger.Error("user %v has %v api keys sending 409", claims.Uid, count)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - You may have at most %v api keys, delete one first", API_KEY_MAX)))
+		return
+	}
+
+	apiKey := ApiKey{Uid: int32(claims.Uid), Name: name, Created: time.Now().UTC()}
+	key, hash, err := newApiKey()
+	if err == nil {
+		apiKey.KeyHash = hash
+		apiKey.Prefix = key[:len(API_KEY_PREFIX)+API_KEY_SHOWN]
+		apiKey.Id, err = AddApiKey(apiKey)
+	}
+	if err != nil {
+		logger.Error("failed to add api key sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create api key, try again later"))
+		return
+	}
+
+	resp := apiKey.Resp()
+	resp.Key = key
+	writeAlbumJSON(w, http.StatusCreated, resp)
+	logger.Info("Created api key %v for user %v", apiKey.Id, claims.Uid)
+}
+
+// listApiKeys returns the keys of the user in order of creation
+func listApiKeys(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list api keys sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	keys, err := UserApiKeys(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve api keys sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve api keys, try again later"))
+		return
+	}
+
+	resp := []ApiKeyResp{}
+	for _, key := range keys {
+		resp = append(resp, key.Resp())
+	}
+	writeAlbumJSON(w, http.StatusOK, resp)
+}
+
+// deleteApiKey revokes a key of the user, requests made with it are refused immediately
+func deleteApiKey(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage api keys")
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	deleted, err := DeleteApiKey(int32(claims.Uid), int32(id))
+	if err != nil {
+		logger.Error("failed to delete api key sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete api key, try again later"))
+		return
+	}
+	if !deleted {
+		logger.Error("user %v has no api key %v sending 404", claims.Uid, id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no api key with that id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Deleted api key %v of user %v", id, claims.Uid)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestApiKeys ensures keys act for their user until deleted and can't manage keys
+func TestApiKeys(t *testing.T) {
+	t.Parallel()
+	token, uid := getTestToken(t)
+	router := configureRoutes()
+	send := func(method string, path string, body string, header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("POST", "/user/api-keys", `{"name": " "}`, "Authorization", "Bearer "+token); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for empty name: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	rr := send("POST", "/user/api-keys", `{"name": "backup script"}`, "Authorization", "Bearer "+token)
+	created := ApiKeyResp{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(created.Key, created.Prefix) || !strings.HasPrefix(created.Key, API_KEY_PREFIX) {
+		t.Fatalf("failed to create api key: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// The key acts for its user
+	rr = send("GET", "/user", "", API_KEY_HEADER, created.Key)
+	user := User{}
+	json.Unmarshal(rr.Body.Bytes(), &user)
+	if rr.Code != http.StatusOK || user.Uid != int32(uid) {
+		t.Errorf("wrong user of api key: got %v %+v", rr.Code, user)
+	}
+	if rr := send("GET", "/user", "", API_KEY_HEADER, created.Key+"0"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for unknown key: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Keys are listed without the key and record their last use
+	rr = send("GET", "/user/api-keys", "", API_KEY_HEADER, created.Key)
+	keys := []ApiKeyResp{}
+	json.Unmarshal(rr.Body.Bytes(), &keys)
+	if rr.Code != http.StatusOK || len(keys) != 1 || len(keys[0].Key) > 0 || keys[0].LastUsed == nil {
+		t.Errorf("wrong api keys: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	path := fmt.Sprintf("/user/api-keys/%v", created.Id)
+	if rr := send("POST", "/user/api-keys", `{"name": "another"}`, API_KEY_HEADER, created.Key); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code creating key with a key: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := send("DELETE", path, "", API_KEY_HEADER, created.Key); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code deleting key with a key: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := send("DELETE", path, "", "Authorization", "Bearer "+token); rr.Code != http.StatusNoContent {
+		t.Errorf("wrong code deleting key: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := send("GET", "/user", "", API_KEY_HEADER, created.Key); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for deleted key: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("DELETE", path, "", "Authorization", "Bearer "+token); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code deleting key twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
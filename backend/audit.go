@@ -0,0 +1,154 @@
+package main
+
+/*
+	This file implements the audit log. Every action changing an account or its images, such as
+	registering, signing in, uploading, updating, deleting, restoring and sharing images, is recorded with the
+	acting user, the address of the client, the moment it happened and the affected object so users
+	can review their account activity through /user/activity and spot access they don't recognize.
+	Entries are kept for AUDIT_RETENTION days and are removed with the account.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	AUDIT_TABLE         = "audit_log"
+	AUDIT_RETENTION     = 365       // Default days entries are kept if the AUDIT_RETENTION env variable is not defined
+	AUDIT_REAP_INTERVAL = time.Hour // Interval between purges of expired entries
+
+	// Audited actions
+	AUDIT_REGISTER     = "register"
+	AUDIT_LOGIN        = "login"
+	AUDIT_UPLOAD       = "upload"
+	AUDIT_UPDATE       = "update"
+	AUDIT_DELETE       = "delete"
+	AUDIT_RESTORE      = "restore"
+	AUDIT_SHARE        = "share"
+	AUDIT_UNSHARE      = "unshare"
+	AUDIT_GUEST_UPLOAD = "guest_upload" // Upload through a guest link of the user
+)
+
+// AuditEntry records an action taken by a user tagged for sql serialization
+type AuditEntry struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32     `sql:"uid"`
+	Action   string    `sql:"action"`
+	ObjectId int32     `sql:"object_id"` // Image affected by the action, 0 for account actions
+	Ip       string    `sql:"ip"`
+	Created  time.Time `sql:"created"`
+}
+
+// ActivityEntry describes an action in the user's activity
+type ActivityEntry struct {
+	Action   string    `json:"action"`
+	ObjectId int32     `json:"objectId,omitempty"`
+	Ip       string    `json:"ip"`
+	Time     Timestamp `json:"time"`
+}
+
+// ActivityResp is a page of the user's activity
+type ActivityResp struct {
+	Page         int             `json:"page"`
+	PageSize     int             `json:"pageSize"`
+	TotalResults int64           `json:"totalResults"`
+	Activity     []ActivityEntry `json:"activity"`
+}
+
+func init() {
+	RegisterPurgeJob("audit-reaper", AUDIT_REAP_INTERVAL, AUDIT_TABLE, "created < $1", func() []interface{} {
+		return []interface{}{time.Now().UTC().Add(-getAuditRetention())}
+	})
+}
+
+// getAuditRetention returns how long entries are kept defined by the AUDIT_RETENTION environment variable in days
+func getAuditRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("AUDIT_RETENTION"))
+	if err != nil || days <= 0 {
+		days = AUDIT_RETENTION
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// recordAudit adds an entry for the action the user took through the request and publishes the library event
+// of actions changing an image, failures are logged rather than failing the request as the action has already been carried out
+func recordAudit(req *http.Request, uid int, action string, objectId int32) {
+	recordAuditFrom(clientIP(req), uid, action, objectId)
+}
+
+// recordAuditFrom adds an entry for an action the user took from the ip outside of a request,
+// such as queued uploads saved after the request was answered
+func recordAuditFrom(ip string, uid int, action string, objectId int32) {
+	entry := AuditEntry{
+		Uid:      int32(uid),
+		Action:   action,
+		ObjectId: objectId,
+		Ip:       ip,
+		Created:  time.Now().UTC(),
+	}
+
+	err := AddAuditEntry(entry)
+	if err != nil {
+		logger.Error("failed to record %s by user %v in the audit log: %v", action, uid, err)
+	}
+
+	publishAudited(uid, action, objectId)
+}
+
+// userActivity returns a page of the authenticated user's audit log, most recent first
+func userActivity(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for activity sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	page := 0
+	if param := req.URL.Query().Get("page"); len(param) > 0 {
+		page, err = strconv.Atoi(param)
+		if err != nil || page < 0 {
+			logger.Error("invalid activity page %q sending 400", param)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - page must be a non negative integer"))
+			return
+		}
+	}
+
+	entries, total, err := AuditEntries(req.Context(), int32(claims.Uid), page)
+	if err != nil {
+		logger.Error("failed to retrieve activity sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve activity, try again later"))
+		return
+	}
+
+	resp := ActivityResp{Page: page, PageSize: PAGE_SIZE, TotalResults: total, Activity: []ActivityEntry{}}
+	for _, entry := range entries {
+		resp.Activity = append(resp.Activity, ActivityEntry{
+			Action:   entry.Action,
+			ObjectId: entry.ObjectId,
+			Ip:       entry.Ip,
+			Time:     Timestamp(entry.Created),
+		})
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal activity sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve activity, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAuditRetention ensures the retention period is configured in days
+func TestAuditRetention(t *testing.T) {
+	defer os.Unsetenv("AUDIT_RETENTION")
+
+	if retention := getAuditRetention(); retention != AUDIT_RETENTION*24*time.Hour {
+		t.Errorf("wrong default retention: got %v", retention)
+	}
+	os.Setenv("AUDIT_RETENTION", "90")
+	if retention := getAuditRetention(); retention != 90*24*time.Hour {
+		t.Errorf("wrong configured retention: got %v", retention)
+	}
+	os.Setenv("AUDIT_RETENTION", "0")
+	if retention := getAuditRetention(); retention != AUDIT_RETENTION*24*time.Hour {
+		t.Errorf("wrong retention for invalid configuration: got %v", retention)
+	}
+}
+
+// TestAudit ensures sign ins and changes to images are listed in the user's activity
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req := httptest.NewRequest("GET", "/auth", nil)
+	req.SetBasicAuth(testEmail(t, "user"), userPass)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to sign in: got %v", rr.Code)
+	}
+
+	image := uploadTestImage(t, router, token, false)
+	imagePath := strings.TrimPrefix(image.Ref, REF_URL)
+	if rr := send("PUT", imagePath, `{"shareable": "true"}`); rr.Code != http.StatusOK {
+		t.Fatalf("failed to update image: got %v", rr.Code)
+	}
+	if rr := send("DELETE", imagePath, ""); rr.Code != http.StatusOK {
+		t.Fatalf("failed to delete image: got %v", rr.Code)
+	}
+
+	if rr := send("GET", "/user/activity?page=-1", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid page: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = send("GET", "/user/activity", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to retrieve activity: got %v", rr.Code)
+	}
+	resp := ActivityResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+
+	actions := []string{}
+	for _, entry := range resp.Activity {
+		actions = append(actions, entry.Action)
+		if entry.Ip != "192.0.2.1" {
+			t.Errorf("wrong address for %s: got %q", entry.Action, entry.Ip)
+		}
+		if entry.Action != AUDIT_LOGIN && entry.ObjectId != image.Id {
+			t.Errorf("wrong object of %s: got %v want %v", entry.Action, entry.ObjectId, image.Id)
+		}
+	}
+	expected := []string{AUDIT_DELETE, AUDIT_SHARE, AUDIT_UPDATE, AUDIT_UPLOAD, AUDIT_LOGIN}
+	if resp.TotalResults != int64(len(expected)) || !reflect.DeepEqual(actions, expected) {
+		t.Errorf("wrong activity: got %v (%v total) want %v", actions, resp.TotalResults, expected)
+	}
+}
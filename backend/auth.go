@@ -0,0 +1,187 @@
+package main
+
+/*
+	This file authenticates requests. The authenticate middleware resolves the credentials of each
+	request through a chain of credential resolvers and attaches the resulting Principal, or the
+	reason the credentials were refused, to the request context. Handlers retrieve it through
+	authRequest, so a new way of signing in only needs a resolver registered with RegisterResolver.
+
+	Resolvers are tried in order and the first that finds credentials of its kind decides, invalid
+	credentials are refused rather than passed on to the next resolver. The auth token cookie takes
+	precedence over headers, followed by API keys, bearer tokens signed by the server and bearer
+	tokens of an external authorization server checked through token introspection.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Methods principals were authenticated with
+	AUTH_COOKIE        = "cookie"
+	AUTH_BEARER        = "bearer"
+	AUTH_API_KEY       = "api_key"
+	AUTH_INTROSPECTION = "introspection"
+)
+
+var ErrNoCredentials = errors.New("no credentials, unauthorized")
+
+// Principal is the authenticated user of a request and how it was authenticated
+type Principal struct {
+	Uid      int
+	Email    string
+	Method   string
+	ClientId string // Client acting on behalf of the user with an access token
+	Scope    string // Space separated scopes granted to the client
+	KeyId    int32  // API key of the request
+}
+
+// Claims returns the principal as the claims of an auth token
+func (p Principal) Claims() JWTClaims {
+	return JWTClaims{Email: p.Email, Uid: p.Uid, ClientId: p.ClientId, Scope: p.Scope}
+}
+
+// CredentialResolver authenticates one kind of credentials
+type CredentialResolver interface {
+	Name() string
+	// Resolve returns the principal of the credentials of the request, reporting false if the
+	// request has no credentials of this kind
+	Resolve(req *http.Request) (Principal, bool, error)
+}
+
+// resolvers are tried in order of registration
+var resolvers []CredentialResolver
+
+func init() {
+	RegisterResolver(cookieResolver{})
+	RegisterResolver(apiKeyResolver{})
+	RegisterResolver(bearerResolver{})
+	RegisterResolver(introspectionResolver{})
+}
+
+// RegisterResolver adds a resolver tried after those already registered
+func RegisterResolver(resolver CredentialResolver) {
+	resolvers = append(resolvers, resolver)
+}
+
+// authResult is the outcome of resolving the credentials of a request
+type authResult struct {
+	principal Principal
+	err       error
+}
+
+// principalKey holds the authResult of a request context
+type principalKey struct{}
+
+// resolvePrincipal returns the principal of the first resolver finding credentials in the request
+func resolvePrincipal(req *http.Request) (Principal, error) {
+	for _, resolver := range resolvers {
+		principal, found, err := resolver.Resolve(req)
+		if !found {
+			continue
+		}
+		if err != nil {
+			return Principal{}, fmt.Errorf("%s credentials refused: %v", resolver.Name(), err)
+		}
+		principal.Method = resolver.Name()
+		return principal, nil
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+// authenticate is router middleware attaching the principal of each request to its context
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "OPTIONS" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		principal, err := resolvePrincipal(req)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, authResult{principal, err})))
+	})
+}
+
+// requestPrincipal returns the principal attached by authenticate, resolving it for requests
+// that didn't pass through the middleware
+func requestPrincipal(req *http.Request) (Principal, error) {
+	if result, ok := req.Context().Value(principalKey{}).(authResult); ok {
+		return result.principal, result.err
+	}
+	return resolvePrincipal(req)
+}
+
+// tokenPrincipal returns the principal of an auth token or client access token signed by the server
+func tokenPrincipal(tokenStr string) (Principal, error) {
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// Upload policies, login states and totp challenges are signed with the same keys but never grant access
+	switch claims.Subject {
+	case UPLOAD_POLICY_SUBJECT:
+		return Principal{}, fmt.Errorf("upload policy used as auth token, unauthorized")
+	case LOGIN_STATE_SUBJECT:
+		return Principal{}, fmt.Errorf("login state used as auth token, unauthorized")
+	case TOTP_CHALLENGE_SUBJECT:
+		return Principal{}, fmt.Errorf("totp challenge used as auth token, unauthorized")
+	}
+
+	// Tokens revoked by signing out are refused until they expire
+	if len(claims.Id) > 0 && tokenRevoked(claims.Id) {
+		return Principal{}, fmt.Errorf("token revoked by signing out, unauthorized")
+	}
+
+	return Principal{Uid: claims.Uid, Email: claims.Email, ClientId: claims.ClientId, Scope: claims.Scope}, nil
+}
+
+// bearerToken returns the token of the Authorization header, empty if there is none
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// signedToken reports whether the token has the form of a jwt rather than an opaque token
+func signedToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// cookieResolver authenticates the auth token cookie set by signing in
+type cookieResolver struct{}
+
+func (cookieResolver) Name() string {
+	return AUTH_COOKIE
+}
+
+func (cookieResolver) Resolve(req *http.Request) (Principal, bool, error) {
+	cookie, err := req.Cookie(TOKEN_COOKIE)
+	if err != nil {
+		return Principal{}, false, nil
+	}
+	principal, err := tokenPrincipal(cookie.Value)
+	return principal, true, err
+}
+
+// bearerResolver authenticates auth tokens and client access tokens signed by the server sent as bearer tokens
+type bearerResolver struct{}
+
+func (bearerResolver) Name() string {
+	return AUTH_BEARER
+}
+
+func (bearerResolver) Resolve(req *http.Request) (Principal, bool, error) {
+	token := bearerToken(req)
+	if !signedToken(token) {
+		return Principal{}, false, nil
+	}
+	principal, err := tokenPrincipal(token)
+	return principal, true, err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// headerResolver authenticates the uid of the X-Test-Uid header
+type headerResolver struct{}
+
+func (headerResolver) Name() string {
+	return "test"
+}
+
+func (headerResolver) Resolve(req *http.Request) (Principal, bool, error) {
+	uid := req.Header.Get("X-Test-Uid")
+	if len(uid) == 0 {
+		return Principal{}, false, nil
+	}
+	if uid != "7" {
+		return Principal{}, true, fmt.Errorf("unknown uid")
+	}
+	return Principal{Uid: 7}, true, nil
+}
+
+// TestResolvePrincipal ensures the first resolver finding credentials decides and tokens that
+// never grant access are refused
+func TestResolvePrincipal(t *testing.T) {
+	cookieToken, _, _ := generateJWT(1, "cookie@mail.com")
+	bearer, _, _ := generateJWT(2, "bearer@mail.com")
+	policy, _ := signToken(&UploadPolicyClaims{Owner: 1, MaxBytes: 1, StandardClaims: jwt.StandardClaims{Subject: UPLOAD_POLICY_SUBJECT, ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+	state, _ := signToken(&LoginState{Nonce: "n", StandardClaims: jwt.StandardClaims{Subject: LOGIN_STATE_SUBJECT, ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+
+	request := func(cookie string, authorization string) *http.Request {
+		req := httptest.NewRequest("GET", "/user", nil)
+		if len(cookie) > 0 {
+			req.AddCookie(&http.Cookie{Name: TOKEN_COOKIE, Value: cookie})
+		}
+		if len(authorization) > 0 {
+			req.Header.Set("Authorization", authorization)
+		}
+		return req
+	}
+
+	for _, test := range []struct {
+		name   string
+		req    *http.Request
+		uid    int
+		method string
+	}{
+		{"cookie", request(cookieToken, ""), 1, AUTH_COOKIE},
+		{"bearer", request("", "Bearer "+bearer), 2, AUTH_BEARER},
+		{"cookie before bearer", request(cookieToken, "Bearer "+bearer), 1, AUTH_COOKIE},
+		{"invalid cookie", request("invalid", "Bearer "+bearer), 0, ""},
+		{"upload policy", request("", "Bearer "+policy), 0, ""},
+		{"login state", request(state, ""), 0, ""},
+		{"opaque token without introspection", request("", "Bearer opaque"), 0, ""},
+		{"basic auth", request("", "Basic dXNlcjpwYXNz"), 0, ""},
+		{"nothing", request("", ""), 0, ""},
+	} {
+		principal, err := resolvePrincipal(test.req)
+		if principal.Uid != test.uid || principal.Method != test.method || (err == nil) != (test.uid != 0) {
+			t.Errorf("wrong principal with %s: got %+v %v", test.name, principal, err)
+		}
+	}
+
+	// Registered resolvers are tried after the built in resolvers
+	defer func(registered []CredentialResolver) { resolvers = registered }(resolvers)
+	RegisterResolver(headerResolver{})
+
+	handled := Principal{}
+	handler := authenticate(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handled, _ = requestPrincipal(req)
+		if _, err := authRequest(req); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	req := request("", "")
+	req.Header.Set("X-Test-Uid", "7")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || handled.Uid != 7 || handled.Method != "test" {
+		t.Errorf("wrong principal of registered resolver: got %v %+v", rr.Code, handled)
+	}
+
+	req.Header.Set("X-Test-Uid", "8")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for refused credentials: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
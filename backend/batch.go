@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	BATCH_WORKERS   = 4        // Number of files of a batch saved concurrently
+	MAX_BATCH_FILES = 50       // Maximum number of files accepted in a single batch upload
+	BATCH_MEMORY    = 32 << 20 // Bytes of a batch held in memory, the remainder is buffered to temporary files
+)
+
+// BatchResult reports the outcome of a single file of a batch upload
+// Image is set when the file was saved, otherwise Error describes the failure
+type BatchResult struct {
+	Index    int    `json:"index"`
+	Filename string `json:"filename"`
+	Status   int    `json:"status"`
+	Image    *Image `json:"image,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchUpload accepts multipart form-data with any number of files in the images field
+// and saves them concurrently. shareable and tags apply to every file of the batch.
+// The response is an array with the result of each file in the order they were sent
+func (s *Server) batchUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to batch upload sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	// Validate Content-Type of the request
+	contentType := req.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		logger.Error("request content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to upload, please use multipart form data with images of supported types"))
+		return
+	}
+
+	// Refuse to read bodies larger than a full batch of maximum size files
+	maxBytes := getMaxUploadBytes()
+	req.Body = http.MaxBytesReader(w, req.Body, MAX_BATCH_FILES*(maxBytes+UPLOAD_FORM_OVERHEAD))
+
+	err = req.ParseMultipartForm(BATCH_MEMORY)
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			logger.Error("batch exceeds size limit sending 413: %v", err)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("413 - Batch exceeds the limit of %v files of %v bytes", MAX_BATCH_FILES, maxBytes)))
+			return
+		}
+		logger.Error("failed to parse multipart form sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to read files, ensure the images are attached as the images field"))
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	files := req.MultipartForm.File["images"]
+	if len(files) == 0 || len(files) > MAX_BATCH_FILES {
+		logger.Error("batch of %v files sending 400", len(files))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Batch uploads must include between 1 and %v files in the images field", MAX_BATCH_FILES)))
+		return
+	}
+
+	// The sharing policy and deduplication are applied to each file as it is saved
+	shareable := req.FormValue("shareable")
+	dedup := req.FormValue("dedup")
+
+	// Tags are optional and provided as a comma separated list
+	tags, err := parseTags(req.FormValue("tags"))
+	if err != nil {
+		logger.Error("invalid tags sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	// Save files with a bounded pool of workers, results are stored by index to preserve order
+	results := make([]BatchResult, len(files))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < BATCH_WORKERS && i < len(files); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = s.saveBatchFile(req, claims.Uid, index, files[index], maxBytes, shareable, dedup, tags)
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// marshal response in json
+	js, err := json.Marshal(results)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	logger.Info("Successfully processed batch upload of %v files", len(files))
+	return
+}
+
+// saveBatchFile saves a single file of a batch upload and reports the outcome
+func (s *Server) saveBatchFile(req *http.Request, uid int, index int, imgHeader *multipart.FileHeader, maxBytes int64, shareable string, dedup string, tags []string) BatchResult {
+	result := BatchResult{Index: index, Filename: imgHeader.Filename}
+
+	if imgHeader.Size > maxBytes {
+		result.Status = http.StatusRequestEntityTooLarge
+		result.Error = fmt.Sprintf("413 - Upload exceeds the limit of %v bytes", maxBytes)
+		return result
+	}
+
+	img, err := imgHeader.Open()
+	if err != nil {
+		logger.Error("failed to open batch file %v: %v", index, err)
+		result.Status = http.StatusBadRequest
+		result.Error = "400 - Failed to read file"
+		return result
+	}
+	defer img.Close()
+
+	imageData, err := s.saveImage(req.Context(), uid, img, imgHeader, "", imageText{}, shareable, dedup, tags, acceptedTypes())
+	if err != nil {
+		logger.Error("failed to save batch file %v: %v", index, err)
+		result.Status, result.Error = uploadErrorStatus(err)
+		return result
+	}
+
+	recordAudit(req, uid, AUDIT_UPLOAD, imageData.Id)
+	result.Status = http.StatusOK
+	result.Image = &imageData
+	return result
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestBatchUpload ensures each file of a batch is reported individually and in order
+// None of the evaluated files reach the database
+func TestBatchUpload(t *testing.T) {
+	router := configureRoutes()
+
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	os.Setenv("MAX_UPLOAD_BYTES", "1000")
+	defer os.Unsetenv("MAX_UPLOAD_BYTES")
+
+	// Batches without files are rejected
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, batchUploadRequest(t, token, map[string][]byte{}))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong code for empty batch: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Unsupported and oversized files fail without failing the batch
+	files := map[string][]byte{}
+	for i := 0; i < 2*BATCH_WORKERS; i++ {
+		files[fmt.Sprintf("%v.txt", i)] = []byte("not an image")
+	}
+	files["large.png"] = testImage(t, "png", 128)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, batchUploadRequest(t, token, files))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong code: got %v want %v", status, http.StatusOK)
+	}
+
+	results := []BatchResult{}
+	err = json.Unmarshal(rr.Body.Bytes(), &results)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(results) != len(files) {
+		t.Fatalf("wrong number of results: got %v want %v", len(results), len(files))
+	}
+
+	for i, result := range results {
+		expected := http.StatusBadRequest
+		if result.Filename == "large.png" {
+			expected = http.StatusRequestEntityTooLarge
+		}
+		if result.Index != i || result.Status != expected || result.Image != nil || len(result.Error) == 0 {
+			t.Errorf("wrong result for %s: got %+v", result.Filename, result)
+		}
+	}
+}
+
+// batchUploadRequest prepares a batch upload of the named files
+func batchUploadRequest(t *testing.T, token string, files map[string][]byte) *http.Request {
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "/image/batch", form)
+	if err != nil {
+		t.Fatalf("failed to generate request with form data: %v", err)
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	return req
+}
@@ -0,0 +1,145 @@
+package main
+
+/*
+	This file implements content addressed storage. Uploaded files are stored once under a key
+	derived from the sha256 hash of their content beneath BLOB_DIR, so identical images uploaded
+	by any number of users share a single blob while each keeps its own image_meta row with its
+	title, permissions and quota usage. The blob table counts the images referencing each blob and
+	the file is deleted when the last reference is released. Files stored before content addressing
+	keep their per image keys and are shared through links as before.
+
+	Uploads are written under a staging key first and moved into place as the last step of the
+	transaction recording the image, so a failed upload leaves neither an image without its file
+	nor a referenced blob without content. Staged files left by a crashed instance are reported
+	as orphans by storage checks.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	BLOB_TABLE  = "blob"
+	BLOB_DIR    = "blobs"   // Storage key prefix of content addressed files
+	STAGING_DIR = "staging" // Storage key prefix of uploads waiting for their image to commit
+)
+
+// Blob counts the images referencing a content addressed file tagged for sql serialization
+type Blob struct {
+	Key     string    `sql:"file_key" opt:"PRIMARY KEY"`
+	Size    int64     `sql:"size"`
+	Refs    int32     `sql:"refs"`
+	Created time.Time `sql:"created"`
+}
+
+// blobKey returns the content addressed storage key of a file with the sha256 hash and encoding,
+// blobs are spread over directories named by the first two hash characters
+func blobKey(hash string, encoding string) string {
+	return fmt.Sprintf("%s/%s/%s.%s", BLOB_DIR, hash[:2], hash, strings.Split(encoding, "/")[1])
+}
+
+// isBlobKey reports whether the storage key addresses a content addressed file
+func isBlobKey(key string) bool {
+	return strings.HasPrefix(key, BLOB_DIR+"/")
+}
+
+// acquireBlob adds a reference to the blob at key writing the content when the blob is new or its file is
+// missing. A reference that fails to be written is released so the blob isn't left without its file
+func acquireBlob(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	created, err := AcquireBlob(key, size)
+	if err != nil {
+		return err
+	}
+
+	// Blobs already referenced are only written again if their file went missing
+	if !created {
+		object, err := storage.Open(ctx, key)
+		if err == nil {
+			object.Close()
+			return nil
+		}
+	}
+
+	err = storage.Put(ctx, key, content, size, contentType)
+	if err != nil {
+		releaseBlob(ctx, key)
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	return nil
+}
+
+// releaseBlob removes a reference to the blob at key deleting its file with the last reference
+func releaseBlob(ctx context.Context, key string) error {
+	return ReleaseBlob(key, func() error {
+		err := storage.Delete(ctx, key)
+		if err == ErrObjectNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// stageUpload writes the content of an upload under a random staging key and returns the key
+func stageUpload(ctx context.Context, content io.Reader, size int64, contentType string) (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate staging key: %v", err)
+	}
+
+	key := fmt.Sprintf("%s/%s.%s", STAGING_DIR, hex.EncodeToString(buf), strings.Split(contentType, "/")[1])
+	err = storage.Put(ctx, key, content, size, contentType)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage upload: %v", err)
+	}
+	return key, nil
+}
+
+// discardStaged deletes the staged upload at key unless it was moved into place
+func discardStaged(ctx context.Context, key string) {
+	if len(key) == 0 {
+		return
+	}
+	err := storage.Delete(ctx, key)
+	if err != nil && err != ErrObjectNotFound {
+		logger.Error("failed to delete staged upload %s: %v", key, err)
+	}
+}
+
+// placeBlob adds a reference to the blob at key within the transaction recording an image and ensures the file
+// of the blob is stored before the transaction commits. The staged upload is moved into place when the blob is
+// new or its file went missing, uploads linking a stored blob aren't staged and write their content instead.
+// Uploads of the same content wait on the locked blob row until the transaction ends
+func placeBlob(ctx context.Context, tx dbtx, key string, staged string, content io.Reader, size int64, contentType string) error {
+	created, err := acquireBlobRef(tx, key, size)
+	if err != nil {
+		return err
+	}
+
+	// Blobs already referenced are only written again if their file went missing
+	if !created {
+		object, err := storage.Open(ctx, key)
+		if err == nil {
+			object.Close()
+			return nil
+		}
+	}
+
+	if len(staged) == 0 {
+		err = storage.Put(ctx, key, content, size, contentType)
+	} else {
+		err = renameObject(ctx, staged, key, contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// TestBlobKey ensures blobs are addressed by content hash and encoding
+func TestBlobKey(t *testing.T) {
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	key := blobKey(hash, "image/jpeg")
+	if key != "blobs/9f/"+hash+".jpeg" {
+		t.Errorf("wrong blob key: got %v", key)
+	}
+	if !isBlobKey(key) {
+		t.Errorf("blob key not recognised: %v", key)
+	}
+	for _, key := range []string{"4/12.png", "trash/4/12.png", "blobsy/12.png"} {
+		if isBlobKey(key) {
+			t.Errorf("%v recognised as a blob key", key)
+		}
+	}
+}
+
+// TestBlobs uploads identical content as two users and ensures they share one blob removed with its last reference
+func TestBlobs(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	member := createTestUser(t, "member")
+	memberToken, _, err := generateJWT(int(member.Uid), member.Email)
+	if err != nil {
+		t.Fatalf("failed to generate member jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	content := testImage(t, "png", 29)
+	first := dedupUpload(t, router, token, DEDUP_STORE, content, http.StatusOK)
+	second := dedupUpload(t, router, memberToken, DEDUP_STORE, content, http.StatusOK)
+
+	key := blobKey(first.Hash, first.Encoding)
+	for _, image := range []Image{first, second} {
+		meta, err := GetImageMeta(context.Background(), image.Id)
+		if err != nil {
+			t.Fatalf("failed to retrieve image: %v", err)
+		}
+		if imageKey(meta) != key {
+			t.Errorf("image %v not stored in the blob: got key %v want %v", image.Id, imageKey(meta), key)
+		}
+	}
+	if refs := blobRefs(t, key); refs != 2 {
+		t.Errorf("wrong blob references: got %v want 2", refs)
+	}
+
+	// The blob remains while an image references it
+	deleteDedupImage(router, token, first)
+	file, err := storage.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("blob deleted while referenced: %v", err)
+	}
+	file.Close()
+
+	deleteDedupImage(router, memberToken, second)
+	if _, err := storage.Open(context.Background(), key); err != ErrObjectNotFound {
+		t.Errorf("blob not deleted with its last reference: %v", err)
+	}
+	if refs := blobRefs(t, key); refs != 0 {
+		t.Errorf("wrong blob references after deletion: got %v want 0", refs)
+	}
+}
+
+// blobRefs returns the number of images referencing the blob at key, 0 if it isn't stored
+func blobRefs(t *testing.T, key string) int32 {
+	db, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	blobs, err := selectWhere(db, Blob{}, BLOB_TABLE, "file_key = $1", key)
+	if err != nil {
+		t.Fatalf("failed to retrieve blob: %v", err)
+	}
+	if len(blobs) == 0 {
+		return 0
+	}
+	return blobs[0].(Blob).Refs
+}
+
+// stuckStorage stores files locally but fails to rename them
+type stuckStorage struct {
+	localStorage
+}
+
+func (s *stuckStorage) Rename(ctx context.Context, from string, to string) error {
+	return errors.New("rename unavailable")
+}
+
+// TestRenameObject ensures files are renamed in place or copied and deleted by drivers that can't rename
+func TestRenameObject(t *testing.T) {
+	dir := t.TempDir()
+	defer func(configured Storage) { storage = configured }(storage)
+
+	ctx := context.Background()
+	content := []byte("staged content")
+	for _, driver := range []Storage{
+		&localStorage{root: filepath.Join(dir, "local")},
+		&mirrorStorage{primary: &localStorage{root: filepath.Join(dir, "primary")}, mirror: &localStorage{root: filepath.Join(dir, "mirror")}},
+	} {
+		storage = driver
+		err := storage.Put(ctx, "staging/a.png", bytes.NewReader(content), int64(len(content)), "image/png")
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		err = renameObject(ctx, "staging/a.png", "blobs/ab/a.png", "image/png")
+		if err != nil {
+			t.Fatalf("failed to rename %s file: %v", driver.Name(), err)
+		}
+		if _, err := storage.Open(ctx, "staging/a.png"); err != ErrObjectNotFound {
+			t.Errorf("%s source remains after rename: %v", driver.Name(), err)
+		}
+		object, err := storage.Open(ctx, "blobs/ab/a.png")
+		if err != nil {
+			t.Fatalf("renamed %s file missing: %v", driver.Name(), err)
+		}
+		data, _ := ioutil.ReadAll(object)
+		object.Close()
+		if !bytes.Equal(data, content) {
+			t.Errorf("renamed %s file differs: got %q", driver.Name(), data)
+		}
+
+		if err := renameObject(ctx, "staging/missing.png", "blobs/ab/b.png", "image/png"); err == nil {
+			t.Errorf("%s renamed a missing file", driver.Name())
+		}
+	}
+}
+
+// TestUploadRollback ensures an upload whose file can't be put in place records nothing and leaves no staged file
+func TestUploadRollback(t *testing.T) {
+	dir := t.TempDir()
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &stuckStorage{localStorage{root: dir}}
+
+	user := createTestUser(t, "rollback")
+	token, _, err := generateJWT(int(user.Uid), user.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt token: %v", err)
+	}
+	before, err := GetUserUsage(user.Uid)
+	if err != nil {
+		t.Fatalf("failed to retrieve usage: %v", err)
+	}
+
+	content := testImage(t, "png", 31)
+	dedupUpload(t, configureRoutes(), token, DEDUP_STORE, content, http.StatusInternalServerError)
+
+	db, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	if count, err := countWhere(db, IMAGE_TABLE, "uid = $1", user.Uid); err != nil || count != 0 {
+		t.Errorf("image recorded by failed upload: got %v %v", count, err)
+	}
+	after, err := GetUserUsage(user.Uid)
+	if err != nil || after.Used != before.Used {
+		t.Errorf("storage usage changed by failed upload: got %v want %v", after.Used, before.Used)
+	}
+	if staged, _ := ioutil.ReadDir(filepath.Join(dir, STAGING_DIR)); len(staged) != 0 {
+		t.Errorf("staged upload left behind: got %v files", len(staged))
+	}
+}
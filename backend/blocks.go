@@ -0,0 +1,199 @@
+package main
+
+/*
+	This file lets users block other accounts. A blocked user can't view the images of the user
+	who blocked them, whether they are shareable, in a shareable album or shared with them, and
+	their image meta queries no longer include them. Endpoints serving or interacting with another
+	user's content authorize through canInteract so blocks apply to every such endpoint. Public
+	endpoints only apply blocks to authenticated requests as anonymous viewers can't be identified.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const USER_BLOCK_TABLE = "user_blocks"
+
+// UserBlock records that the user blocked another account tagged for sql serialization
+type UserBlock struct {
+	Id         int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid        int32     `sql:"uid"`
+	BlockedUid int32     `sql:"blocked_uid"`
+	Created    time.Time `sql:"created"`
+}
+
+// UserBlockReq names the account to block
+type UserBlockReq struct {
+	Email string `json:"email"`
+}
+
+// UserBlockResp describes a blocked account, only the email used to block it is revealed
+type UserBlockResp struct {
+	Uid     int32     `json:"uid"`
+	Email   string    `json:"email"`
+	Created Timestamp `json:"created"`
+}
+
+// canInteract reports whether the user may view or interact with content owned by the owner,
+// which is refused when the owner blocked the user. Users may always interact with their own content
+func (s *Server) canInteract(ctx context.Context, uid int, owner int32) (bool, error) {
+	if uid == int(owner) {
+		return true, nil
+	}
+	blocked, err := s.store.IsBlocked(ctx, owner, int32(uid))
+	return !blocked, err
+}
+
+// blockedViewer reports whether the request is authenticated as a user the owner blocked,
+// anonymous requests to public endpoints are never blocked
+func (s *Server) blockedViewer(req *http.Request, owner int32) (bool, error) {
+	claims, err := authRequest(req)
+	if err != nil {
+		return false, nil
+	}
+	allowed, err := s.canInteract(req.Context(), claims.Uid, owner)
+	return !allowed, err
+}
+
+// blockUser blocks the account with the email in the body for the authenticated user
+func blockUser(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to block user sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var blockReq UserBlockReq
+	err = json.NewDecoder(req.Body).Decode(&blockReq)
+	if err != nil || len(strings.TrimSpace(blockReq.Email)) == 0 {
+		logger.Error("invalid block request sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, provide the email of the account to block"))
+		return
+	}
+
+	user, found, err := GetUserByEmail(strings.TrimSpace(blockReq.Email))
+	if err != nil {
+		logger.Error("failed to retrieve user sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to block user, try again later"))
+		return
+	}
+	if !found {
+		logger.Error("no account for user %v to block sending 404", claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no account with that email"))
+		return
+	}
+	if int(user.Uid) == claims.Uid {
+		logger.Error("user %v blocking themselves sending 400", claims.Uid)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Users can't block themselves"))
+		return
+	}
+
+	block, created, err := AddUserBlock(int32(claims.Uid), user.Uid)
+	if err != nil {
+		logger.Error("failed to add user block sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to block user, try again later"))
+		return
+	}
+
+	// Repeated blocks succeed without creating another block
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		logger.Info("User %v blocked user %v", claims.Uid, user.Uid)
+	}
+	writeBlockJSON(w, status, UserBlockResp{Uid: user.Uid, Email: user.Email, Created: Timestamp(block.Created)})
+}
+
+// listBlocks returns the accounts blocked by the authenticated user
+func listBlocks(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list blocks sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	blocks, users, err := UserBlocks(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve blocks sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve blocked users, try again later"))
+		return
+	}
+
+	resp := []UserBlockResp{}
+	for _, block := range blocks {
+		resp = append(resp, UserBlockResp{Uid: block.BlockedUid, Email: users[block.BlockedUid].Email, Created: Timestamp(block.Created)})
+	}
+	writeBlockJSON(w, http.StatusOK, resp)
+}
+
+// unblockUser removes the block of the account in the url for the authenticated user
+func unblockUser(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to unblock user sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	uid, err := strconv.Atoi(mux.Vars(req)["uid"])
+	if err != nil {
+		logger.Error("invalid uid sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	removed, err := DeleteUserBlock(int32(claims.Uid), int32(uid))
+	if err != nil {
+		logger.Error("failed to delete user block sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to unblock user, try again later"))
+		return
+	}
+	if !removed {
+		logger.Error("user %v has not blocked user %v sending 404", claims.Uid, uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, that account is not blocked"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("User %v unblocked user %v", claims.Uid, uid)
+}
+
+// writeBlockJSON writes the response as json with the status code
+func writeBlockJSON(w http.ResponseWriter, status int, resp interface{}) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
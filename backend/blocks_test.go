@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUserBlocks ensures blocked users can't view shared or shareable images of the blocking user until unblocked
+func TestUserBlocks(t *testing.T) {
+	t.Parallel()
+
+	token, uid := getTestToken(t)
+
+	blocked := createTestUser(t, "blocked")
+	blockedToken, _, err := generateJWT(int(blocked.Uid), blocked.Email)
+	if err != nil {
+		t.Fatalf("failed to generate blocked user jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	private := uploadTestImage(t, router, token, false)
+	shareable := uploadTestImage(t, router, token, true)
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	visible := func() int {
+		rr := send("GET", fmt.Sprintf("/image/meta?uid=%v", uid), "", blockedToken)
+		resp := QueryResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.TotalResults
+	}
+	privatePath := strings.TrimPrefix(private.Ref, REF_URL)
+	publicPath := fmt.Sprintf("/public/image/%v/%v", shareable.Uid, shareable.Id)
+
+	if rr := send("POST", fmt.Sprintf("/image/%v/shares", private.Id), `{"email": "blocked@mail.com"}`, token); rr.Code != http.StatusCreated {
+		t.Fatalf("failed to share image: got %v", rr.Code)
+	}
+	if count := visible(); count != 2 {
+		t.Errorf("wrong number of visible images before blocking: got %v want 2", count)
+	}
+
+	if rr := send("POST", "/user/blocks", fmt.Sprintf(`{"email": %q}`, testEmail(t, "user")), token); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for blocking oneself: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send("POST", "/user/blocks", `{"email": "blocked@mail.com"}`, token); rr.Code != http.StatusCreated {
+		t.Fatalf("failed to block user: got %v", rr.Code)
+	}
+
+	if rr := send("GET", privatePath, "", blockedToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for shared image of blocking user: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("GET", publicPath, "", blockedToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for shareable image of blocking user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("GET", publicPath, "", ""); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for anonymous request: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if count := visible(); count != 0 {
+		t.Errorf("wrong number of visible images after blocking: got %v want 0", count)
+	}
+
+	rr := send("GET", "/user/blocks", "", token)
+	blocks := []UserBlockResp{}
+	json.Unmarshal(rr.Body.Bytes(), &blocks)
+	if len(blocks) != 1 || blocks[0].Uid != blocked.Uid || blocks[0].Email != blocked.Email {
+		t.Errorf("wrong blocks: got %+v", blocks)
+	}
+
+	if rr := send("DELETE", fmt.Sprintf("/user/blocks/%v", blocked.Uid), "", token); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to unblock user: got %v", rr.Code)
+	}
+	if rr := send("GET", privatePath, "", blockedToken); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for shared image after unblocking: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+/*
+	This file implements the multi-select actions of gallery clients. PATCH /image/bulk changes the
+	shareable flag, the tags or the album of many images at once and DELETE /image/bulk moves them to
+	the trash. The changes to every image the user may modify are applied in a single transaction,
+	the response reports the outcome of each requested image so clients can tell which images were
+	skipped, for example because they were deleted meanwhile or are restricted by moderation.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	BULK_MAX = 100 // Images that may be changed by a single bulk request
+)
+
+// BulkParams lists the images of a bulk request and the changes applied to each of them,
+// omitted fields are left unchanged and an empty tag list clears the tags
+type BulkParams struct {
+	Ids       []int32   `json:"ids"`
+	Shareable *bool     `json:"shareable"`
+	Tags      *[]string `json:"tags"`
+	Album     *int32    `json:"album"`
+}
+
+// BulkResult reports the outcome of a single image of a bulk request
+// Image is set when an update was applied, otherwise Error describes why the image was skipped
+type BulkResult struct {
+	Id     int32  `json:"id"`
+	Status int    `json:"status"`
+	Image  *Image `json:"image,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkIds returns the requested ids without repetitions in the order they were first listed
+func bulkIds(ids []int32) ([]int32, error) {
+	unique := []int32{}
+	for _, id := range ids {
+		if !containsImageId(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 || len(unique) > BULK_MAX {
+		return nil, fmt.Errorf("ids must list 1 to %v images", BULK_MAX)
+	}
+	return unique, nil
+}
+
+// decodeBulkParams reads the bulk request body, writing the error response and returning false if it is invalid
+func decodeBulkParams(w http.ResponseWriter, req *http.Request) (BulkParams, bool) {
+	params := BulkParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return BulkParams{}, false
+	}
+
+	params.Ids, err = bulkIds(params.Ids)
+	if err != nil {
+		logger.Error("invalid bulk ids sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return BulkParams{}, false
+	}
+	return params, true
+}
+
+// bulkImages retrieves the requested images the user owns, the results of the others are set to 404
+func bulkImages(uid int, ids []int32, results map[int32]*BulkResult) ([]Image, error) {
+	found, err := GetImages(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []Image{}
+	for _, id := range ids {
+		image, ok := found[id]
+		if !ok || image.Uid != int32(uid) {
+			results[id].Status = http.StatusNotFound
+			results[id].Error = "404 - Not found, no image with that id"
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// newBulkResults returns the results of the ids in the order they were requested and indexed by id
+func newBulkResults(ids []int32) ([]BulkResult, map[int32]*BulkResult) {
+	list := make([]BulkResult, len(ids))
+	index := map[int32]*BulkResult{}
+	for i, id := range ids {
+		list[i].Id = id
+		index[id] = &list[i]
+	}
+	return list, index
+}
+
+// bulkUpdate accepts a json body with the ids of images and the shareable flag, tags or album applied to all of
+// them. Images are added to the end of the album, images already in it keep their position
+func bulkUpdate(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update images sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	params, ok := decodeBulkParams(w, req)
+	if !ok {
+		return
+	}
+	if params.Shareable == nil && params.Tags == nil && params.Album == nil {
+		logger.Error("bulk update without changes sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Specify shareable, tags or album to change"))
+		return
+	}
+
+	var tags []string
+	if params.Tags != nil {
+		tags, err = parseTags(strings.Join(*params.Tags, ","))
+		if err != nil {
+			logger.Error("invalid tags sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+			return
+		}
+	}
+
+	if params.Shareable != nil && *params.Shareable {
+		_, _, err := resolveShareable(req.Context(), sqlStore{}, "true")
+		if err != nil {
+			writeSharingError(w, err)
+			return
+		}
+	}
+
+	var album *Album
+	if params.Album != nil {
+		found, ok, err := GetAlbum(*params.Album)
+		if err != nil {
+			logger.Error("failed to retrieve album sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to retrieve album, try again later"))
+			return
+		}
+		if !ok || found.Uid != int32(claims.Uid) {
+			logger.Error("album %v not owned by user %v sending 404", *params.Album, claims.Uid)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not found, no album with that id"))
+			return
+		}
+		album = &found
+	}
+
+	list, results := newBulkResults(params.Ids)
+	images, err := bulkImages(claims.Uid, params.Ids, results)
+	if err != nil {
+		logger.Error("failed to retrieve images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update images, try again later"))
+		return
+	}
+
+	// Images restricted by moderation may not be shared again until the case is appealed
+	updated := []Image{}
+	for _, image := range images {
+		if params.Shareable != nil && *params.Shareable && !image.Shareable {
+			restricted, err := ModerationRestricted(req.Context(), image.Id)
+			if err != nil {
+				logger.Error("failed to retrieve moderation cases sending 500: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("500 - Failed to update images, try again later"))
+				return
+			}
+			if restricted {
+				results[image.Id].Status = http.StatusForbidden
+				results[image.Id].Error = "403 - Image was unshared after reports, appeal the moderation case to share it again"
+				continue
+			}
+		}
+		updated = append(updated, image)
+	}
+
+	if len(updated) > 0 {
+		ids := []int32{}
+		for _, image := range updated {
+			ids = append(ids, image.Id)
+		}
+		err = BulkUpdateImages(ids, params.Shareable, tags, album)
+		if err == ErrAlbumFull {
+			logger.Error("album %v is full sending 409", album.Id)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprintf("409 - Albums may contain at most %v images", ALBUM_MAX_IMAGES)))
+			return
+		}
+		if err != nil {
+			logger.Error("failed to update images sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update images, try again later"))
+			return
+		}
+	}
+
+	for _, image := range updated {
+		wasShareable := image.Shareable
+		if params.Shareable != nil {
+			image.Shareable = *params.Shareable
+		}
+		if tags != nil {
+			image.Tags = tags
+		}
+
+		recordAudit(req, claims.Uid, AUDIT_UPDATE, image.Id)
+		if image.Shareable && !wasShareable {
+			recordAudit(req, claims.Uid, AUDIT_SHARE, image.Id)
+			emitAnalytics(ANALYTICS_SHARE, claims.Uid, image, map[string]string{"source": "bulk"})
+		}
+		if !image.Shareable && wasShareable {
+			recordAudit(req, claims.Uid, AUDIT_UNSHARE, image.Id)
+		}
+
+		updatedImage := image
+		results[image.Id].Status = http.StatusOK
+		results[image.Id].Image = &updatedImage
+	}
+
+	logger.Info("Bulk updated %v of %v images for UID: %v", len(updated), len(list), claims.Uid)
+	writeBulkResults(w, list)
+}
+
+// bulkDelete accepts a json body with the ids of images and moves them to the trash
+func bulkDelete(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to delete images sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	params, ok := decodeBulkParams(w, req)
+	if !ok {
+		return
+	}
+
+	list, results := newBulkResults(params.Ids)
+	images, err := bulkImages(claims.Uid, params.Ids, results)
+	if err != nil {
+		logger.Error("failed to retrieve images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to delete images, try again later"))
+		return
+	}
+
+	// Files are copied to the trash before the images are trashed together, the originals are
+	// deleted once the transaction commits and the copies if it fails
+	fileKeys := map[int32]string{}
+	copied := map[int32]Image{}
+	for _, image := range images {
+		fileKey, err := copyToTrash(req.Context(), image)
+		if err != nil {
+			logger.Error("failed to move file of image %v to the trash: %v", image.Id, err)
+			results[image.Id].Status = http.StatusInternalServerError
+			results[image.Id].Error = "500 - Unable to delete image, try again later"
+			continue
+		}
+		fileKeys[image.Id] = fileKey
+		copied[image.Id] = image
+	}
+
+	trashed := []int32{}
+	if len(fileKeys) > 0 {
+		trashed, err = TrashImagesData(fileKeys, time.Now().UTC())
+		if err != nil {
+			removeTrashCopies(req.Context(), copied, fileKeys)
+			logger.Error("failed to trash images sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Unable to delete images, try again later"))
+			return
+		}
+	}
+
+	for _, id := range trashed {
+		image := copied[id]
+		delete(copied, id)
+		if fileKeys[id] != imageKey(image) {
+			err = storage.Delete(req.Context(), imageKey(image))
+			if err != nil {
+				logger.Error("failed to delete file of trashed image %v, clean orphaned files via automated data integrity check: %v", id, err)
+			}
+		}
+		recordAudit(req, claims.Uid, AUDIT_DELETE, id)
+		results[id].Status = http.StatusOK
+	}
+
+	// Images trashed by another request meanwhile are reported as not found
+	removeTrashCopies(req.Context(), copied, fileKeys)
+	for id := range copied {
+		results[id].Status = http.StatusNotFound
+		results[id].Error = "404 - Not found, no image with that id"
+	}
+
+	logger.Info("Bulk moved %v of %v images to the trash for UID: %v", len(trashed), len(list), claims.Uid)
+	writeBulkResults(w, list)
+}
+
+// removeTrashCopies deletes the copies of files made for images that weren't trashed
+func removeTrashCopies(ctx context.Context, images map[int32]Image, fileKeys map[int32]string) {
+	for id, image := range images {
+		if fileKeys[id] != imageKey(image) {
+			storage.Delete(ctx, fileKeys[id])
+		}
+	}
+}
+
+// writeBulkResults responds with the result of each image in the order they were requested
+func writeBulkResults(w http.ResponseWriter, results []BulkResult) {
+	js, err := json.Marshal(results)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
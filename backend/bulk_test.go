@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBulkIds ensures bulk requests list between 1 and BULK_MAX distinct images
+func TestBulkIds(t *testing.T) {
+	ids, err := bulkIds([]int32{3, 1, 3, 2, 1})
+	if err != nil || fmt.Sprint(ids) != "[3 1 2]" {
+		t.Errorf("wrong ids: got %v %v", ids, err)
+	}
+	if _, err := bulkIds(nil); err == nil {
+		t.Errorf("empty ids accepted")
+	}
+	many := []int32{}
+	for i := int32(1); i <= BULK_MAX+1; i++ {
+		many = append(many, i)
+	}
+	if _, err := bulkIds(many); err == nil {
+		t.Errorf("%v ids accepted", len(many))
+	}
+}
+
+// TestBulkImages ensures bulk requests change and trash the user's images and report the others
+func TestBulkImages(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+	otherToken, _ := getTestToken(t)
+	router := configureRoutes()
+
+	first := uploadTestImage(t, router, token, false)
+	second := uploadTestImage(t, router, token, false)
+	other := uploadTestImage(t, router, otherToken, false)
+	defer DeleteImageData(context.Background(), first)
+	defer DeleteImageData(context.Background(), second)
+	defer DeleteImageData(context.Background(), other)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/album", `{"title": "Bulk"}`)
+	album := AlbumResp{}
+	json.Unmarshal(rr.Body.Bytes(), &album)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to create album: got %v", rr.Code)
+	}
+
+	if rr := send("PATCH", "/image/bulk", fmt.Sprintf(`{"ids": [%v]}`, first.Id)); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for update without changes: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send("PATCH", "/image/bulk", fmt.Sprintf(`{"ids": [%v], "tags": ["not a tag"]}`, first.Id)); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid tags: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send("PATCH", "/image/bulk", fmt.Sprintf(`{"ids": [%v], "album": %v}`, first.Id, album.Id+1000000)); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for unknown album: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	body := fmt.Sprintf(`{"ids": [%v, %v, %v], "shareable": true, "tags": ["bulk", "holiday"], "album": %v}`, first.Id, other.Id, second.Id, album.Id)
+	rr = send("PATCH", "/image/bulk", body)
+	results := []BulkResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if rr.Code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("failed to update images: got %v %s", rr.Code, rr.Body.String())
+	}
+	for i, want := range []struct {
+		Id     int32
+		Status int
+	}{{first.Id, http.StatusOK}, {other.Id, http.StatusNotFound}, {second.Id, http.StatusOK}} {
+		if results[i].Id != want.Id || results[i].Status != want.Status {
+			t.Errorf("wrong result %v: got %+v want %+v", i, results[i], want)
+		}
+	}
+
+	for _, image := range []Image{first, second} {
+		updated, err := GetImageMeta(context.Background(), image.Id)
+		if err != nil || !updated.Shareable || strings.Join(updated.Tags, ",") != "bulk,holiday" {
+			t.Errorf("image %v not updated: got %+v %v", image.Id, updated, err)
+		}
+	}
+	if updated, _ := GetImageMeta(context.Background(), other.Id); updated.Shareable {
+		t.Errorf("image of another user updated")
+	}
+	rr = send("GET", fmt.Sprintf("/album/%v", album.Id), "")
+	json.Unmarshal(rr.Body.Bytes(), &album)
+	if fmt.Sprint(album.ImageIds) != fmt.Sprint([]int32{first.Id, second.Id}) {
+		t.Errorf("wrong album images: got %v", album.ImageIds)
+	}
+
+	rr = send("DELETE", "/image/bulk", fmt.Sprintf(`{"ids": [%v, %v]}`, first.Id, other.Id))
+	results = []BulkResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if rr.Code != http.StatusOK || len(results) != 2 || results[0].Status != http.StatusOK || results[1].Status != http.StatusNotFound {
+		t.Fatalf("wrong bulk delete: got %v %s", rr.Code, rr.Body.String())
+	}
+	if _, found, err := GetTrashedImage(context.Background(), first.Id); err != nil || !found {
+		t.Errorf("image not moved to the trash: %v", err)
+	}
+	if _, found, _ := GetTrashedImage(context.Background(), other.Id); found {
+		t.Errorf("image of another user moved to the trash")
+	}
+
+	rr = send("DELETE", "/image/bulk", fmt.Sprintf(`{"ids": [%v]}`, first.Id))
+	results = []BulkResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Status != http.StatusNotFound {
+		t.Errorf("wrong result deleting trashed image: got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+/*
+	This file implements zip bundles of image exports. A bundle holds the original file of each
+	exported image beneath images/ and ends with manifest.json listing the metadata, size and
+	SHA-256 of every file so recipients can check the archive is complete and unaltered. Images
+	whose file is missing from storage are listed in the manifest rather than failing the export.
+*/
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	EXPORT_ZIP = "zip"
+
+	BUNDLE_VERSION  = 1 // Version of the manifest format
+	BUNDLE_DIR      = "images"
+	BUNDLE_MANIFEST = "manifest.json"
+)
+
+// BundleManifest describes the files of a zip bundle
+type BundleManifest struct {
+	Version int          `json:"version"`
+	Uid     int32        `json:"uid"`
+	Created Timestamp    `json:"created"`
+	Files   []BundleFile `json:"files"`
+	Missing []Image      `json:"missing"` // Images whose file couldn't be found in storage
+	Bytes   int64        `json:"bytes"`   // Total size of the files
+}
+
+// BundleFile is a file of a zip bundle with the metadata of its image
+type BundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"` // Hex digest of the file as written to the bundle
+	Image  Image  `json:"image"`
+}
+
+// zipExport writes the files of images to a zip bundle, the manifest is written when it's closed
+type zipExport struct {
+	ctx      context.Context
+	writer   *zip.Writer
+	manifest BundleManifest
+}
+
+// newZipExport returns a zip exportWriter for the images of the user
+func newZipExport(ctx context.Context, w io.Writer, uid int32) *zipExport {
+	return &zipExport{
+		ctx:    ctx,
+		writer: zip.NewWriter(w),
+		manifest: BundleManifest{
+			Version: BUNDLE_VERSION,
+			Uid:     uid,
+			Created: Timestamp(time.Now().UTC()),
+			Files:   []BundleFile{},
+			Missing: []Image{},
+		},
+	}
+}
+
+// bundlePath returns the path of the file of the image within a bundle, prefixed by the id so
+// images with the same title don't collide
+func bundlePath(image Image) string {
+	return fmt.Sprintf("%s/%v-%s", BUNDLE_DIR, image.Id, image.Title)
+}
+
+// Write adds the file of each image to the bundle hashing it as it is written
+func (e *zipExport) Write(images []Image) error {
+	for _, image := range images {
+		object, err := storage.Open(e.ctx, imageKey(image))
+		if err == ErrObjectNotFound {
+			e.manifest.Missing = append(e.manifest.Missing, image)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open file of image %v: %v", image.Id, err)
+		}
+
+		file := BundleFile{Path: bundlePath(image), Image: image}
+		err = func() error {
+			defer object.Close()
+
+			// Images are already compressed so they are stored as is
+			entry, err := e.writer.CreateHeader(&zip.FileHeader{Name: file.Path, Method: zip.Store, Modified: image.Uploaded})
+			if err != nil {
+				return err
+			}
+			hasher := sha256.New()
+			file.Size, err = io.Copy(io.MultiWriter(entry, hasher), object)
+			file.Sha256 = hex.EncodeToString(hasher.Sum(nil))
+			return err
+		}()
+		if err != nil {
+			return fmt.Errorf("failed to add file of image %v: %v", image.Id, err)
+		}
+
+		e.manifest.Files = append(e.manifest.Files, file)
+		e.manifest.Bytes += file.Size
+	}
+	return e.Flush()
+}
+
+// Flush writes buffered files to the underlying writer
+func (e *zipExport) Flush() error {
+	return e.writer.Flush()
+}
+
+// Close writes the manifest and completes the bundle
+func (e *zipExport) Close() error {
+	entry, err := e.writer.CreateHeader(&zip.FileHeader{Name: BUNDLE_MANIFEST, Method: zip.Deflate, Modified: time.Time(e.manifest.Created)})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(e.manifest)
+	if err != nil {
+		return err
+	}
+	return e.writer.Close()
+}
@@ -0,0 +1,149 @@
+package main
+
+/*
+	This file implements browser and CDN caching of images. Public references include a version
+	derived from the content hash of the image so a versioned url always identifies the same bytes
+	and can be cached forever. Requests without the current version are cached for
+	PUBLIC_CACHE_MAX_AGE seconds, by default only with revalidation so clients pick up changes.
+	Authenticated image requests may be cached by the browser for IMAGE_CACHE_MAX_AGE seconds.
+	Images are tagged with their content hash so revalidating an unchanged image is answered with
+	304 Not Modified instead of the file.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PUBLIC_MAX_AGE       = 365 * 24 * 60 * 60 // Seconds versioned public images may be cached
+	PUBLIC_CACHE_MAX_AGE = 0                  // Default if PUBLIC_CACHE_MAX_AGE env variable is not defined, in seconds
+	IMAGE_CACHE_MAX_AGE  = 0                  // Default if IMAGE_CACHE_MAX_AGE env variable is not defined, in seconds
+	VERSION_LENGTH       = 16                 // Characters of the content hash used as the url version
+	VERSION_PARAM        = "v"
+
+	CACHE_IMMUTABLE          = "public, max-age=%v, immutable"
+	CACHE_PUBLIC             = "public, max-age=%v"
+	CACHE_REVALIDATE         = "public, no-cache"
+	CACHE_PRIVATE            = "private, max-age=%v"
+	CACHE_PRIVATE_REVALIDATE = "private, no-cache"
+)
+
+// imageJSON is the json representation of Image without its MarshalJSON method
+type imageJSON Image
+
+// MarshalJSON adds the versioned public reference of shareable images and formats the image dates
+func (i Image) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		imageJSON
+		PublicRef string    `json:"publicRef,omitempty"`
+		Uploaded  Timestamp `json:"uploaded"`
+		Taken     Timestamp `json:"taken"`
+	}{imageJSON(i), i.PublicRef(), Timestamp(i.Uploaded), Timestamp(i.Taken)})
+}
+
+// Version returns the version included in public references, empty for images stored before hashes were recorded
+func (i Image) Version() string {
+	if len(i.Hash) < VERSION_LENGTH {
+		return ""
+	}
+	return i.Hash[:VERSION_LENGTH]
+}
+
+// PublicRef returns the versioned public url of a shareable image, empty if the image is private or unversioned
+func (i Image) PublicRef() string {
+	if !i.Shareable || len(i.Version()) == 0 {
+		return ""
+	}
+
+	refUrl := os.Getenv("REF_URL")
+	if len(refUrl) == 0 {
+		refUrl = REF_URL
+	}
+	return fmt.Sprintf("%s/public/%s/%v/%v.%v?%s=%s", refUrl, IMAGE_DIR, i.Uid, i.Id, strings.Split(i.Encoding, "/")[1], VERSION_PARAM, i.Version())
+}
+
+// getPublicCacheMaxAge returns the seconds unversioned public images may be cached, 0 requires revalidation
+func getPublicCacheMaxAge() int {
+	maxAge, err := strconv.Atoi(os.Getenv("PUBLIC_CACHE_MAX_AGE"))
+	if err != nil || maxAge < 0 {
+		maxAge = PUBLIC_CACHE_MAX_AGE
+	}
+	return maxAge
+}
+
+// getImageCacheMaxAge returns the seconds browsers may cache authenticated image requests, 0 requires revalidation
+func getImageCacheMaxAge() int {
+	maxAge, err := strconv.Atoi(os.Getenv("IMAGE_CACHE_MAX_AGE"))
+	if err != nil || maxAge < 0 {
+		maxAge = IMAGE_CACHE_MAX_AGE
+	}
+	return maxAge
+}
+
+// publicCacheControl returns the Cache-Control header of a public image request and the seconds it may be cached
+// only requests for the current version of an unwatermarked image are immutable, the watermark
+// policy may change the served bytes without changing the content hash
+func publicCacheControl(req *http.Request, image Image, policy SharingPolicy) (string, int) {
+	version := req.URL.Query().Get(VERSION_PARAM)
+	if len(version) > 0 && version == image.Version() && !policy.Watermark {
+		return fmt.Sprintf(CACHE_IMMUTABLE, PUBLIC_MAX_AGE), PUBLIC_MAX_AGE
+	}
+	if maxAge := getPublicCacheMaxAge(); maxAge > 0 {
+		return fmt.Sprintf(CACHE_PUBLIC, maxAge), maxAge
+	}
+	return CACHE_REVALIDATE, 0
+}
+
+// privateCacheControl returns the Cache-Control header of an authenticated image request and the seconds it may be cached,
+// shared caches must not store images served to a user
+func privateCacheControl() (string, int) {
+	if maxAge := getImageCacheMaxAge(); maxAge > 0 {
+		return fmt.Sprintf(CACHE_PRIVATE, maxAge), maxAge
+	}
+	return CACHE_PRIVATE_REVALIDATE, 0
+}
+
+// setCacheHeaders sets the Cache-Control header and the matching Expires header for HTTP/1.0 caches
+func setCacheHeaders(header http.Header, cacheControl string, maxAge int, now time.Time) {
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Expires", now.Add(time.Duration(maxAge)*time.Second).UTC().Format(http.TimeFormat))
+}
+
+// dropCacheHeaders removes the caching headers intended for the image so error responses aren't cached
+func dropCacheHeaders(header http.Header) {
+	header.Del("Cache-Control")
+	header.Del("Expires")
+}
+
+// imageETag returns a strong entity tag of the file stored at key for the image. The stored image is
+// tagged with its content hash, derived files and images stored before hashes were recorded with a
+// tag that changes whenever the file is replaced
+func imageETag(imageMeta Image, key string, file Object) string {
+	if key == imageKey(imageMeta) && len(imageMeta.Hash) > 0 {
+		return fmt.Sprintf(`"%s"`, imageMeta.Hash)
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, imageMeta.Id, file.Size(), file.ModTime().UnixNano())
+}
+
+// hashFile returns the hex sha256 of the file content and rewinds it for later reads
+func hashFile(file io.ReadSeeker) (string, error) {
+	hasher := sha256.New()
+	_, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
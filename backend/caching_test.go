@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPublicRef ensures only shareable images with a content hash have a versioned public reference
+func TestPublicRef(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	image := Image{Id: 7, Uid: 3, Encoding: "image/png", Shareable: true, Hash: hash}
+
+	expected := fmt.Sprintf("%s/public/image/3/7.png?v=%s", REF_URL, hash[:VERSION_LENGTH])
+	if ref := image.PublicRef(); ref != expected {
+		t.Errorf("wrong public ref: got %q want %q", ref, expected)
+	}
+
+	js, err := json.Marshal(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"publicRef":"`+expected+`"`) {
+		t.Errorf("public ref missing from json: %s", js)
+	}
+
+	for _, unversioned := range []Image{{Encoding: "image/png", Hash: hash}, {Encoding: "image/png", Shareable: true}} {
+		if ref := unversioned.PublicRef(); len(ref) != 0 {
+			t.Errorf("unexpected public ref for %+v: %q", unversioned, ref)
+		}
+		js, _ := json.Marshal(unversioned)
+		if strings.Contains(string(js), "publicRef") {
+			t.Errorf("unexpected public ref in json: %s", js)
+		}
+	}
+}
+
+// TestPublicCacheControl ensures only the current version of unwatermarked images is immutable
+// and other requests are cached for PUBLIC_CACHE_MAX_AGE
+func TestPublicCacheControl(t *testing.T) {
+	image := Image{Encoding: "image/png", Shareable: true, Hash: strings.Repeat("ab", 32)}
+	immutable := fmt.Sprintf(CACHE_IMMUTABLE, PUBLIC_MAX_AGE)
+	defer os.Unsetenv("PUBLIC_CACHE_MAX_AGE")
+
+	tt := []struct {
+		Query    string
+		Image    Image
+		Policy   SharingPolicy
+		MaxAge   string
+		Expected string
+		Seconds  int
+	}{
+		{"", image, SharingPolicy{}, "", CACHE_REVALIDATE, 0},
+		{"?v=" + image.Version(), image, SharingPolicy{}, "", immutable, PUBLIC_MAX_AGE},
+		{"?v=" + image.Version(), image, SharingPolicy{Watermark: true}, "", CACHE_REVALIDATE, 0},
+		{"?v=0000000000000000", image, SharingPolicy{}, "", CACHE_REVALIDATE, 0},
+		{"?v=", Image{Shareable: true}, SharingPolicy{}, "", CACHE_REVALIDATE, 0},
+		{"", image, SharingPolicy{}, "300", "public, max-age=300", 300},
+		{"?v=" + image.Version(), image, SharingPolicy{}, "300", immutable, PUBLIC_MAX_AGE},
+		{"", image, SharingPolicy{}, "-1", CACHE_REVALIDATE, 0},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("PUBLIC_CACHE_MAX_AGE", tc.MaxAge)
+		req, _ := http.NewRequest("GET", "/public/image/1/1.png"+tc.Query, nil)
+		if cacheControl, maxAge := publicCacheControl(req, tc.Image, tc.Policy); cacheControl != tc.Expected || maxAge != tc.Seconds {
+			t.Errorf("wrong Cache-Control for %q with max age %q: got %q %v want %q %v", tc.Query, tc.MaxAge, cacheControl, maxAge, tc.Expected, tc.Seconds)
+		}
+	}
+}
+
+// TestPrivateCacheControl ensures authenticated image requests are only cached by browsers
+func TestPrivateCacheControl(t *testing.T) {
+	defer os.Unsetenv("IMAGE_CACHE_MAX_AGE")
+
+	if cacheControl, maxAge := privateCacheControl(); cacheControl != CACHE_PRIVATE_REVALIDATE || maxAge != 0 {
+		t.Errorf("wrong default Cache-Control: got %q %v", cacheControl, maxAge)
+	}
+	os.Setenv("IMAGE_CACHE_MAX_AGE", "3600")
+	if cacheControl, maxAge := privateCacheControl(); cacheControl != "private, max-age=3600" || maxAge != 3600 {
+		t.Errorf("wrong Cache-Control: got %q %v", cacheControl, maxAge)
+	}
+
+	header := http.Header{}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	setCacheHeaders(header, "private, max-age=3600", 3600, now)
+	if header.Get("Cache-Control") != "private, max-age=3600" || header.Get("Expires") != "Fri, 16 Oct 2026 18:00:00 GMT" {
+		t.Errorf("wrong cache headers: got %v", header)
+	}
+	dropCacheHeaders(header)
+	if len(header) != 0 {
+		t.Errorf("cache headers not dropped: got %v", header)
+	}
+}
+
+// TestHashFile ensures the file is hashed and rewound
+func TestHashFile(t *testing.T) {
+	content := "image content"
+	file := strings.NewReader(content)
+
+	hash, err := hashFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("wrong hash: got %v", hash)
+	}
+
+	rest, _ := ioutil.ReadAll(file)
+	if string(rest) != content {
+		t.Errorf("file not rewound: read %q", rest)
+	}
+}
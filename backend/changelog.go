@@ -0,0 +1,242 @@
+package main
+
+/*
+	This file publishes changes to the API so clients can migrate ahead of them. Endpoints are
+	marked deprecated by adding them to deprecatedRoutes, responses of deprecated endpoints then
+	carry the Deprecation (RFC 9745) and Sunset (RFC 8594) headers with links to the migration notes
+	and the replacement. GET /api/changelog lists the changes in apiChanges together with every
+	deprecation, newest first, as JSON for clients and tooling to check against.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	CHANGELOG_MAX_AGE = 300 // Seconds clients may cache the changelog
+
+	// Kinds of API changes
+	CHANGE_ADDED      = "added"
+	CHANGE_CHANGED    = "changed"
+	CHANGE_DEPRECATED = "deprecated"
+	CHANGE_REMOVED    = "removed"
+)
+
+// Deprecation marks the routes matching a method and path template deprecated
+type Deprecation struct {
+	Method      string
+	Path        string    // Path template the route was registered with omitting variable patterns, /image/{id}/shares, relative to the API version unless it is given
+	Date        time.Time // Moment the route was deprecated
+	Sunset      time.Time // Moment the route stops being served, zero if not scheduled
+	Link        string    // Migration notes
+	Successor   string    // Route replacing the deprecated one
+	Description string
+}
+
+// ApiChange describes a change to the API
+type ApiChange struct {
+	Date        string     `json:"date"` // Day of the change as YYYY-MM-DD
+	Type        string     `json:"type"`
+	Method      string     `json:"method,omitempty"`
+	Path        string     `json:"path,omitempty"`
+	Description string     `json:"description"`
+	Sunset      *Timestamp `json:"sunset,omitempty"`
+	Link        string     `json:"link,omitempty"`
+	Successor   string     `json:"successor,omitempty"`
+}
+
+// ChangelogResp lists changes to the API, newest first
+type ChangelogResp struct {
+	Changes []ApiChange `json:"changes"`
+}
+
+// routeVariablePattern matches a path template variable capturing its name
+var routeVariablePattern = regexp.MustCompile(`\{([^:}]+):[^}]*\}`)
+
+// deprecatedRoutes lists the deprecated routes, deprecations are added to the changelog
+var deprecatedRoutes = []Deprecation{}
+
+// apiChanges lists changes to the API other than deprecations
+var apiChanges = []ApiChange{
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/{uid}/{img}", Description: "The ETag of the stored image is its content hash, responses carry Cache-Control and Expires headers allowing browsers to keep the image for IMAGE_CACHE_MAX_AGE seconds"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/public/image/{uid}/{img}", Description: "The ETag of the stored image is its content hash, unversioned requests may be cached for PUBLIC_CACHE_MAX_AGE seconds and responses carry a matching Expires header"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/auth/signout", Description: "Sign out revoking the auth token of the request until it expires and clearing the token cookie"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "POST", Path: "/auth/totp", Description: "Each challenge completes a single sign in"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/{uid}/{img}/stats", Description: "Daily views and downloads of an image for its owner, metadata reports viewCount and downloadCount"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/{uid}/{img}", Description: "download=true serves the image as an attachment and counts it as a download"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/{uid}/{img}/rating", Description: "Rate images the user can view with 1 to 5 stars, metadata reports the average rating and ratingCount"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/meta", Description: "sort=rating orders images by their average rating"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/{uid}/{img}/comments", Description: "Comments on images the user can view, listed in pages and edited or deleted by their author, owners may delete any comment on their images"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/{uid}/{img}/like", Description: "Like images the user can view, metadata reports likeCount and /image/meta?liked=true lists the liked images"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "PATCH", Path: "/image/bulk", Description: "Change the shareable flag, tags or album of up to 100 images in one transaction with a result per image"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "DELETE", Path: "/image/bulk", Description: "Move up to 100 images to the trash in one transaction with a result per image"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/user/webhooks", Description: "Webhooks receiving signed callbacks when the user's images are added, updated, shared, unshared, deleted or restored"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/{uid}/{img}", Description: "Images whose file was lost from every storage copy are answered with 410 instead of 404 or 500, a missing primary copy is restored from the mirror"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/events", Description: "Server-Sent Events stream of images the user can see being added, updated, shared, unshared, deleted or restored"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/user/storage/estimate", Description: "Projected monthly storage and bandwidth cost of the user's images, the organisation total at GET /admin/storage/estimate"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/api/openapi.json", Description: "OpenAPI 3 document describing every endpoint, browsed with Swagger UI at /api/docs"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Path: "/api/v1", Description: "Every endpoint of the API is served under /api/v1, service endpoints such as /healthz, /metrics and /api/changelog stay at the root"},
+	{Date: "2026-10-16", Type: CHANGE_DEPRECATED, Description: "Unversioned paths of the API are aliases of /api/v1 announcing their deprecation and successor in the Deprecation and Link headers, and their removal in the Sunset header once scheduled by LEGACY_ROUTES_SUNSET", Successor: "/api/v1"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "POST", Path: "/register", Description: "Passwords must meet the password policy, refused passwords return 400 with a json body listing the failed rules"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "PUT", Path: "/user/password", Description: "New passwords must meet the password policy, refused passwords return 400 with a json body listing the failed rules"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "POST", Path: "/user/password/reset", Description: "Passwords must meet the password policy, refused passwords return 400 with a json body listing the failed rules"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/user/totp", Description: "Enroll a TOTP secret for two-factor authentication, enabled once a code is verified at /user/totp/verify which returns recovery codes"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/auth/totp", Description: "Complete a sign in requiring a second factor with its challenge and a code or recovery code"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/auth", Description: "Users with two-factor authentication enabled must send a code in the X-TOTP-Code header, otherwise a 401 returns a challenge to complete the sign in with"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/user/api-keys", Description: "Create API keys sent in the X-API-Key header in place of an auth token"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/user/api-keys", Description: "List the API keys of the user"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "DELETE", Path: "/user/api-keys/{id}", Description: "Delete an API key"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Description: "Every authenticated endpoint accepts API keys and, when configured, tokens of an external authorization server checked through introspection. Authorization headers must use the Bearer scheme"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/meta", Description: "The first page may hint the thumbnails of its first images with Link rel=preload headers and 103 Early Hints when PRELOAD_HINTS is enabled"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/album/{id}", Description: "May hint the thumbnails of the first images with Link rel=preload headers and 103 Early Hints when PRELOAD_HINTS is enabled"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/auth/{provider}/login", Description: "Sign in with Google or GitHub, accounts are linked to existing users by verified email or register users without a password"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/auth/{provider}/callback", Description: "Completes signing in with a provider and returns the standard auth token"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/.well-known/jwks.json", Description: "Public keys of RS256 and ES256 signing keys so other services can verify tokens"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/announcements", Description: "Maintenance windows and policy updates published by administrators with their severity and schedule"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Description: "Tokens name the key that signed them in the kid header so signing keys can be rotated without signing users out"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/admin/signing-keys/reload", Description: "Reload the keyring of signing keys without restarting"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/meta/export", Description: "format=zip bundles the files of the images with a manifest.json listing the metadata and SHA-256 of each file"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/intake", Description: "Queue many images at once, answered with 202 and a status URL for each file while they are saved in the background"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/auth", Description: "The token cookie is HttpOnly, Secure and SameSite=Lax with Path=/ by default, set COOKIE_SECURE=false for development over plain HTTP"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "PUT", Path: "/album/{id}/cover", Description: "Choose the cover image of an album, albums without a chosen cover use their first image"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "PUT", Path: "/album/{id}/order", Description: "Replace the order of the images of an album"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/album", Description: "Albums include the ids of their images in order with the id and metadata of their cover"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Description: "CORS headers are only sent to the origins allowed by CORS_ORIGINS, which may send credentials when CORS_CREDENTIALS is set. Preflights list the methods of the route and may be cached for CORS_MAX_AGE seconds"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/guest/{token}", Description: "Guests without an account upload into an album through a guest link until its upload limit or expiry is reached"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/album/{id}/guest-links", Description: "Guest upload links to an album limiting the number of uploads, their size and the lifetime of the link"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/{uid}/{fileId}", Description: "meta=true returns the metadata of the image as json instead of the file"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/admin/storage/checks", Description: "Integrity checks reporting orphaned and missing files of a user or key prefix, open to administrators and storage administrators"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/admin/storage", Description: "Health, latency and object counts of the storage drivers"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/meta", Description: "Results are ordered by the sort and order parameters, pageSize sets the page size up to 200 and responses link the previous and next pages"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/user/notifications", Description: "Notifications of the user, users are notified when mentioned in images they can view"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "PUT", Path: "/user", Description: "Users choose a unique username, @username mentions in descriptions are returned as mentions entities of the image"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/{uid}/{fileId}", Description: "Clients accepting image/avif or image/webp are served the smaller files stored by re-encode campaigns"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/admin/reencode-campaigns", Description: "Campaigns storing AVIF and WebP files of the most viewed images first and reporting the savings"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image", Description: "Images have a description searched by the description query parameter of /image/meta and alternative text for screen readers"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "GET", Path: "/image/{uid}/{fileId}", Description: "Images are scaled down for the Sec-CH-Width, Sec-CH-Viewport-Width, Sec-CH-DPR and Save-Data client hints"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/api/changelog", Description: "Machine readable list of API changes and deprecations"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "POST", Path: "/image", Description: "Identical uploads of any users share one stored blob"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/duplicates", Description: "Groups of identical or similar images of the user, uploads of duplicates follow DUPLICATE_POLICY"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/{id}/exif.json", Description: "Complete EXIF data of an image for its owner and accounts it is shared with"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "PUT", Path: "/image/{uid}/{fileId}", Description: "Titles containing path separators, dot segments or control characters are refused with 400"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/user/activity", Description: "Audit log of the user's account and image changes"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "POST", Path: "/image", Description: "Uploads are refused with 507 while the server is low on disk space"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/metrics", Description: "Prometheus metrics of the image volume"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Method: "DELETE", Path: "/image/{uid}/{fileId}", Description: "Deleted images move to the trash and can be restored until the retention period passes"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/image/trash", Description: "Trashed images of the user"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/{id}/reports", Description: "Report an image for moderation"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/user/blocks", Description: "Block accounts from viewing and interacting with the user's content"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/image/{id}/shares", Description: "Grant other accounts view access to a private image"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "GET", Path: "/timeline", Description: "Images grouped by the day or month they were taken"},
+	{Date: "2026-10-16", Type: CHANGE_ADDED, Method: "POST", Path: "/album", Description: "Albums grouping images into ordered, shareable collections"},
+	{Date: "2026-10-16", Type: CHANGE_CHANGED, Description: "Timestamps are encoded as RFC 3339 UTC, TIMESTAMP_FORMAT=legacy restores Go time strings"},
+}
+
+// routePath returns the path template without the patterns of its variables
+func routePath(template string) string {
+	return routeVariablePattern.ReplaceAllString(template, "{$1}")
+}
+
+// routeDeprecation returns the deprecation of the route matched by the request
+func routeDeprecation(req *http.Request) (Deprecation, bool) {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return Deprecation{}, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return Deprecation{}, false
+	}
+	path := routePath(template)
+
+	for _, deprecation := range deprecatedRoutes {
+		if (deprecation.Path == path || deprecation.Path == apiPath(path)) && deprecation.Method == req.Method {
+			return deprecation, true
+		}
+	}
+	return Deprecation{}, false
+}
+
+// deprecationHeaders is router middleware announcing the deprecation and sunset of deprecated routes
+func deprecationHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deprecation, deprecated := routeDeprecation(req)
+		if !deprecated {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.Date.Unix()))
+		if !deprecation.Sunset.IsZero() {
+			w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if len(deprecation.Link) > 0 {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"; type=\"text/html\"", deprecation.Link))
+		}
+		if len(deprecation.Successor) > 0 {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", deprecation.Successor))
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// changelog returns the API changes and deprecations newest first, changes before the since date are omitted
+func changelog(w http.ResponseWriter, req *http.Request) {
+	since := req.URL.Query().Get("since")
+	if len(since) > 0 {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			logger.Error("invalid changelog since %q sending 400", since)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - since must be a date formatted as YYYY-MM-DD"))
+			return
+		}
+	}
+
+	changes := append([]ApiChange{}, apiChanges...)
+	for _, deprecation := range deprecatedRoutes {
+		change := ApiChange{
+			Date:        deprecation.Date.UTC().Format("2006-01-02"),
+			Type:        CHANGE_DEPRECATED,
+			Method:      deprecation.Method,
+			Path:        deprecation.Path,
+			Description: deprecation.Description,
+			Link:        deprecation.Link,
+			Successor:   deprecation.Successor,
+		}
+		if !deprecation.Sunset.IsZero() {
+			sunset := Timestamp(deprecation.Sunset)
+			change.Sunset = &sunset
+		}
+		changes = append(changes, change)
+	}
+
+	// Dates compare chronologically as strings, changes of a day keep their listed order
+	resp := ChangelogResp{Changes: []ApiChange{}}
+	sort.SliceStable(changes, func(a, b int) bool { return changes[a].Date > changes[b].Date })
+	for _, change := range changes {
+		if change.Date >= since {
+			resp.Changes = append(resp.Changes, change)
+		}
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal changelog sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve the changelog, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", CHANGELOG_MAX_AGE))
+	w.Write(js)
+}
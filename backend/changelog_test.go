@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRoutePath ensures variable patterns are removed from path templates
+func TestRoutePath(t *testing.T) {
+	tt := map[string]string{
+		"/image/{uid:[0-9]+}/{fileId}": "/image/{uid}/{fileId}",
+		"/image/{id:[0-9]+}/exif.json": "/image/{id}/exif.json",
+		"/ping":                        "/ping",
+	}
+	for template, expected := range tt {
+		if path := routePath(template); path != expected {
+			t.Errorf("wrong path for %s: got %s want %s", template, path, expected)
+		}
+	}
+}
+
+// TestDeprecationHeaders ensures only deprecated routes announce their deprecation and sunset
+func TestDeprecationHeaders(t *testing.T) {
+	defer func(configured []Deprecation) { deprecatedRoutes = configured }(deprecatedRoutes)
+	deprecated := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	deprecatedRoutes = []Deprecation{{
+		Method:    "GET",
+		Path:      "/ping",
+		Date:      deprecated,
+		Sunset:    deprecated.AddDate(0, 6, 0),
+		Link:      "https://pictocache.jacobyjoukema.com/docs/migrate-ping",
+		Successor: "/healthz",
+	}}
+
+	router := configureRoutes()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+	if header := rr.Header().Get("Deprecation"); header != "@1767312000" {
+		t.Errorf("wrong deprecation header: got %q", header)
+	}
+	if header := rr.Header().Get("Sunset"); header != "Thu, 02 Jul 2026 00:00:00 GMT" {
+		t.Errorf("wrong sunset header: got %q", header)
+	}
+	links := rr.Header()["Link"]
+	if len(links) != 2 || links[0] != `<https://pictocache.jacobyjoukema.com/docs/migrate-ping>; rel="deprecation"; type="text/html"` || links[1] != `</healthz>; rel="successor-version"` {
+		t.Errorf("wrong link headers: got %q", links)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if header := rr.Header().Get("Deprecation"); len(header) > 0 {
+		t.Errorf("deprecation announced for a current route: got %q", header)
+	}
+}
+
+// TestChangelog ensures deprecations are listed with the changes newest first
+func TestChangelog(t *testing.T) {
+	defer func(configured []Deprecation, changes []ApiChange) {
+		deprecatedRoutes, apiChanges = configured, changes
+	}(deprecatedRoutes, apiChanges)
+	apiChanges = []ApiChange{
+		{Date: "2026-01-01", Type: CHANGE_ADDED, Method: "GET", Path: "/healthz", Description: "Liveness probe"},
+		{Date: "2025-06-01", Type: CHANGE_ADDED, Method: "GET", Path: "/ping", Description: "Ping"},
+	}
+	sunset := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	deprecatedRoutes = []Deprecation{{Method: "GET", Path: "/ping", Date: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), Sunset: sunset, Successor: "/healthz"}}
+
+	router := configureRoutes()
+	changelog := func(query string) (int, ChangelogResp) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/changelog"+query, nil))
+		resp := ChangelogResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	code, resp := changelog("")
+	if code != http.StatusOK || len(resp.Changes) != 3 {
+		t.Fatalf("wrong changelog: got %v %+v", code, resp)
+	}
+	deprecation := resp.Changes[0]
+	if deprecation.Type != CHANGE_DEPRECATED || deprecation.Date != "2026-02-01" || deprecation.Sunset == nil || !time.Time(*deprecation.Sunset).Equal(sunset) || deprecation.Successor != "/healthz" {
+		t.Errorf("wrong deprecation: got %+v", deprecation)
+	}
+	if resp.Changes[1].Date != "2026-01-01" || resp.Changes[2].Date != "2025-06-01" {
+		t.Errorf("changes not newest first: got %+v", resp.Changes)
+	}
+
+	if _, resp := changelog("?since=2026-01-01"); len(resp.Changes) != 2 {
+		t.Errorf("wrong changes since 2026-01-01: got %+v", resp.Changes)
+	}
+	if code, _ := changelog("?since=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid since: got %v want %v", code, http.StatusBadRequest)
+	}
+}
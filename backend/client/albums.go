@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// CreateAlbum creates an album, the title is required
+func (c *Client) CreateAlbum(ctx context.Context, params AlbumParams) (Album, error) {
+	album := Album{}
+	err := c.call(ctx, "POST", "/album", nil, params, &album)
+	return album, err
+}
+
+// Albums returns the albums of the user
+func (c *Client) Albums(ctx context.Context) ([]Album, error) {
+	albums := []Album{}
+	err := c.call(ctx, "GET", "/album", nil, nil, &albums)
+	return albums, err
+}
+
+// Album returns the album with its images in order
+func (c *Client) Album(ctx context.Context, id int32) (AlbumDetail, error) {
+	album := AlbumDetail{}
+	err := c.call(ctx, "GET", fmt.Sprintf("/album/%v", id), nil, nil, &album)
+	return album, err
+}
+
+// UpdateAlbum changes the properties of the album that aren't nil
+func (c *Client) UpdateAlbum(ctx context.Context, id int32, params AlbumParams) (AlbumDetail, error) {
+	album := AlbumDetail{}
+	err := c.call(ctx, "PUT", fmt.Sprintf("/album/%v", id), nil, params, &album)
+	return album, err
+}
+
+// DeleteAlbum deletes the album, its images are kept
+func (c *Client) DeleteAlbum(ctx context.Context, id int32) error {
+	return c.call(ctx, "DELETE", fmt.Sprintf("/album/%v", id), nil, nil, nil)
+}
+
+// AddAlbumImages adds the images to the end of the album
+func (c *Client) AddAlbumImages(ctx context.Context, id int32, imageIds []int32) (AlbumDetail, error) {
+	album := AlbumDetail{}
+	err := c.call(ctx, "POST", fmt.Sprintf("/album/%v/images", id), nil, map[string][]int32{"imageIds": imageIds}, &album)
+	return album, err
+}
+
+// RemoveAlbumImage removes the image from the album
+func (c *Client) RemoveAlbumImage(ctx context.Context, id int32, imageId int32) error {
+	return c.call(ctx, "DELETE", fmt.Sprintf("/album/%v/images/%v", id, imageId), nil, nil, nil)
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+// TotpRequiredError is returned by Auth when the user has enabled two-factor authentication and
+// no code was given, the sign in is completed with CompleteTotp and the challenge
+type TotpRequiredError struct {
+	Challenge string
+}
+
+func (e *TotpRequiredError) Error() string {
+	return "picto cache requires a two-factor authentication code"
+}
+
+// Registration is the account created by Register
+type Registration struct {
+	Email     string
+	Password  string
+	Firstname string
+	Lastname  string
+}
+
+// Register creates an account and signs in as it
+func (c *Client) Register(ctx context.Context, account Registration) (Token, error) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	form.WriteField("email", account.Email)
+	form.WriteField("password", account.Password)
+	form.WriteField("firstname", account.Firstname)
+	form.WriteField("lastname", account.Lastname)
+	form.Close()
+
+	req, err := c.NewRequest(ctx, "POST", "/register", nil, body)
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return c.signIn(req)
+}
+
+// Auth signs in with the email and password, the totp code is only required once the user has
+// enabled two-factor authentication and may be a recovery code. The returned token is used by
+// later requests of the client
+func (c *Client) Auth(ctx context.Context, email string, password string, totpCode string) (Token, error) {
+	req, err := c.NewRequest(ctx, "GET", "/auth", nil, nil)
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Del("X-API-Key")
+	req.SetBasicAuth(email, password)
+	if len(totpCode) > 0 {
+		req.Header.Set("X-TOTP-Code", totpCode)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, ERROR_BODY_MAX))
+		var required struct {
+			TotpRequired bool   `json:"totpRequired"`
+			Challenge    string `json:"challenge"`
+		}
+		if json.Unmarshal(body, &required) == nil && required.TotpRequired {
+			return Token{}, &TotpRequiredError{Challenge: required.Challenge}
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return Token{}, newError(resp)
+	}
+	return c.useToken(resp)
+}
+
+// CompleteTotp completes a sign in requiring two-factor authentication with the challenge of the
+// TotpRequiredError and a code or recovery code
+func (c *Client) CompleteTotp(ctx context.Context, challenge string, code string) (Token, error) {
+	buf, err := json.Marshal(map[string]string{"challenge": challenge, "code": code})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to encode request: %v", err)
+	}
+	req, err := c.NewRequest(ctx, "POST", "/auth/totp", nil, bytes.NewReader(buf))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.signIn(req)
+}
+
+// signIn sends a request answered with a token and uses the token
+func (c *Client) signIn(req *http.Request) (Token, error) {
+	req.Header.Del("Authorization")
+	req.Header.Del("X-API-Key")
+	resp, err := c.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+	return c.useToken(resp)
+}
+
+// useToken decodes the token of the response and sets it as the token of the client
+func (c *Client) useToken(resp *http.Response) (Token, error) {
+	token := Token{}
+	err := decodeResponse(resp, &token)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(token.Value) == 0 {
+		return Token{}, fmt.Errorf("response has no token")
+	}
+	c.SetToken(token.Value)
+	return token, nil
+}
+
+// User returns the signed in user
+func (c *Client) User(ctx context.Context) (User, error) {
+	user := User{}
+	err := c.call(ctx, "GET", "/user", nil, nil, &user)
+	return user, err
+}
+
+// CreateApiKey creates an API key named name, its Key is only returned once
+func (c *Client) CreateApiKey(ctx context.Context, name string) (ApiKey, error) {
+	key := ApiKey{}
+	err := c.call(ctx, "POST", "/user/api-keys", nil, map[string]string{"name": name}, &key)
+	return key, err
+}
+
+// ApiKeys returns the API keys of the user in order of creation
+func (c *Client) ApiKeys(ctx context.Context) ([]ApiKey, error) {
+	keys := []ApiKey{}
+	err := c.call(ctx, "GET", "/user/api-keys", nil, nil, &keys)
+	return keys, err
+}
+
+// DeleteApiKey deletes the API key with the id
+func (c *Client) DeleteApiKey(ctx context.Context, id int32) error {
+	return c.call(ctx, "DELETE", fmt.Sprintf("/user/api-keys/%v", id), nil, nil, nil)
+}
@@ -0,0 +1,207 @@
+/*
+Package client provides typed Go bindings for the Picto Cache API so other Go services can
+sign in, upload and query images, manage albums and shares without building requests by hand.
+The bindings follow devops/swagger/api-spec.yaml, endpoints without a binding can be called
+through Client.Do which still handles authentication and errors.
+
+A Client authenticates with an auth token, set by Auth or SetToken, or an API key given to New
+with WithAPIKey. It is safe for concurrent use.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_TIMEOUT = time.Minute // Timeout of the default http client, uploads of large files may need longer
+
+	ERROR_BODY_MAX = 4096 // Bytes of an error response kept as its message
+
+	API_PREFIX = "/api/v1" // Version of the API the bindings follow
+)
+
+// Client calls the API of a Picto Cache server
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	apiKey  string
+
+	mu    sync.RWMutex
+	token string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with the http client instead of a client with DEFAULT_TIMEOUT
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// WithAPIKey authenticates requests with the API key when no auth token is set
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithToken authenticates requests with the auth token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client of the server at baseURL such as https://pictures.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %v", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q, must be http or https", baseURL)
+	}
+
+	c := &Client{baseURL: parsed, http: &http.Client{Timeout: DEFAULT_TIMEOUT}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// SetToken replaces the auth token of the client, an empty token falls back to the API key
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the auth token of the client
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// Error is a response of the API with an error status
+type Error struct {
+	StatusCode int
+	Message    string        // Message of the response without its status prefix
+	RetryAfter time.Duration // Wait requested by 429 and 503 responses, 0 if none
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("picto cache responded %v: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether the error is a 404 response of the API
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// newError returns the error of a response with an error status
+func newError(resp *http.Response) *Error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, ERROR_BODY_MAX))
+	message := strings.TrimSpace(string(body))
+	// Errors are written as the status code followed by a message, 404 - Not found
+	if prefix := fmt.Sprintf("%v - ", resp.StatusCode); strings.HasPrefix(message, prefix) {
+		message = strings.TrimPrefix(message, prefix)
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: message}
+	var seconds int
+	if _, err := fmt.Sscanf(resp.Header.Get("Retry-After"), "%d", &seconds); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// NewRequest returns an authenticated request of the path relative to the API version of the bindings
+func (c *Client) NewRequest(ctx context.Context, method string, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := *c.baseURL
+	target.Path = c.baseURL.Path + API_PREFIX + path
+	if len(query) > 0 {
+		target.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.Token(); len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if len(c.apiKey) > 0 {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	return req, nil
+}
+
+// Do sends the request and returns the response, responses with an error status are closed and
+// returned as an *Error. The caller closes the body of the response
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+	return resp, nil
+}
+
+// call sends a request with the json encoding of in as its body, if in isn't nil, and decodes the
+// json response into out, if out isn't nil
+func (c *Client) call(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := c.NewRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, out)
+}
+
+// decodeResponse decodes the json body of the response into out, discarding it if out is nil
+func decodeResponse(resp *http.Response, out interface{}) error {
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	err := json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode %v response: %v", resp.StatusCode, err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer serves the handler and returns a client of it
+func testServer(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := New(server.URL, opts...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return c
+}
+
+// TestAuth ensures signing in uses the token for later requests and reports required second factors
+func TestAuth(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, req *http.Request) {
+		switch strings.TrimPrefix(req.URL.Path, API_PREFIX) {
+		case "/auth":
+			email, password, _ := req.BasicAuth()
+			if email != "user@mail.com" || password != "pass" || len(req.Header.Get("X-API-Key")) > 0 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("401 - Unauthorized, invalid login"))
+				return
+			}
+			if req.Header.Get("X-TOTP-Code") != "287082" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"totpRequired": true, "challenge": "challenge"}`))
+				return
+			}
+			w.Write([]byte(`{"name": "token", "token": "abc", "expiration": "2026-10-16T12:30:00Z"}`))
+		case "/user":
+			if req.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("401 - Unauthorized request"))
+				return
+			}
+			w.Write([]byte(`{"uid": 7, "email": "user@mail.com"}`))
+		}
+	}, WithAPIKey("pk_key"))
+	ctx := context.Background()
+
+	_, err := c.Auth(ctx, "user@mail.com", "wrong", "")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Unauthorized, invalid login" {
+		t.Errorf("wrong error of invalid login: got %v", err)
+	}
+	_, err = c.Auth(ctx, "user@mail.com", "pass", "")
+	if required, ok := err.(*TotpRequiredError); !ok || required.Challenge != "challenge" {
+		t.Errorf("expected second factor to be required: got %v", err)
+	}
+
+	token, err := c.Auth(ctx, "user@mail.com", "pass", "287082")
+	if err != nil || token.Value != "abc" || !token.Expiration.Equal(time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)) {
+		t.Fatalf("failed to sign in: got %+v %v", token, err)
+	}
+	user, err := c.User(ctx)
+	if err != nil || user.Uid != 7 {
+		t.Errorf("wrong user: got %+v %v", user, err)
+	}
+}
+
+// TestUploadImage ensures uploads are streamed as multipart forms with the file and its metadata
+func TestUploadImage(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != API_PREFIX+"/image" || req.Header.Get("X-API-Key") != "pk_key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, header, err := req.FormFile("image")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - no image"))
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+		json.NewEncoder(w).Encode(Image{
+			Id:          1,
+			Title:       header.Filename,
+			Size:        int32(len(content)),
+			Description: req.FormValue("description"),
+			Tags:        strings.Split(req.FormValue("tags"), ","),
+			Shareable:   req.FormValue("shareable") == "true",
+		})
+	}, WithAPIKey("pk_key"))
+
+	image, err := c.UploadImage(context.Background(), Upload{
+		Filename:    "cat.png",
+		Content:     strings.NewReader("image content"),
+		Description: "A cat",
+		Tags:        []string{"cat", "pet"},
+		Shareable:   true,
+	})
+	if err != nil || image.Title != "cat.png" || image.Size != 13 || image.Description != "A cat" || len(image.Tags) != 2 || !image.Shareable {
+		t.Errorf("wrong uploaded image: got %+v %v", image, err)
+	}
+}
+
+// TestEachImage ensures every page of a query is followed with the filters of the query
+func TestEachImage(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("tags") != "cat,pet" || query.Get("shareable") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - wrong query"))
+			return
+		}
+		page, _ := strconv.Atoi(query.Get("page"))
+		resp := MetaPage{Page: page, PageSize: 2, TotalResults: 5}
+		for i := page * 2; i < page*2+2 && i < 5; i++ {
+			resp.Images = append(resp.Images, Image{Id: int32(i + 1)})
+		}
+		if page < 2 {
+			resp.Next = fmt.Sprintf("/image/meta?page=%v", page+1)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	shareable := true
+	ids := []int32{}
+	err := c.EachImage(context.Background(), MetaQuery{Tags: []string{"cat", "pet"}, Shareable: &shareable}, func(image Image) error {
+		ids = append(ids, image.Id)
+		return nil
+	})
+	if err != nil || fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Errorf("wrong images: got %v %v", ids, err)
+	}
+
+	_, err = c.QueryMeta(context.Background(), MetaQuery{})
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected error of bad query: got %v", err)
+	}
+}
+
+// TestRefPath ensures images are addressed by the path of their ref with or without a scheme
+func TestRefPath(t *testing.T) {
+	for ref, want := range map[string]string{
+		"localhost:8000/image/7/cat.png":             "/image/7/cat.png",
+		"https://pictures.example.com/image/7/1.png": "/image/7/1.png",
+		"":                                     "",
+		"https://pictures.example.com/album/1": "",
+	} {
+		path, err := refPath(ref)
+		if path != want || (err == nil) != (len(want) > 0) {
+			t.Errorf("wrong path of %q: got %q %v", ref, path, err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Upload describes an image to upload, the file is streamed from Content without being buffered
+type Upload struct {
+	Filename    string // Name of the file, its extension is replaced by the detected encoding
+	Content     io.Reader
+	Title       string // Defaults to the filename
+	Description string
+	AltText     string
+	Tags        []string
+	Shareable   bool
+	Dedup       bool // Link identical content stored by another member of the organisation instead of storing a copy
+}
+
+// UploadImage uploads an image and returns its metadata
+func (c *Client) UploadImage(ctx context.Context, upload Upload) (Image, error) {
+	body, contentType := streamForm(upload)
+	req, err := c.NewRequest(ctx, "POST", "/image", nil, body)
+	if err != nil {
+		body.Close()
+		return Image{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Do(req)
+	// Ends the goroutine writing the form if the request failed before reading it
+	body.CloseWithError(fmt.Errorf("upload ended"))
+	if err != nil {
+		return Image{}, err
+	}
+	defer resp.Body.Close()
+
+	image := Image{}
+	return image, decodeResponse(resp, &image)
+}
+
+// streamForm returns a reader of the multipart form of the upload written as it is read
+func streamForm(upload Upload) (*io.PipeReader, string) {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		fields := [][2]string{
+			{"title", upload.Title},
+			{"description", upload.Description},
+			{"altText", upload.AltText},
+			{"tags", strings.Join(upload.Tags, ",")},
+			{"shareable", strconv.FormatBool(upload.Shareable)},
+		}
+		if upload.Dedup {
+			fields = append(fields, [2]string{"dedup", "link"})
+		}
+		for _, field := range fields {
+			if len(field[1]) == 0 {
+				continue
+			}
+			if err := form.WriteField(field[0], field[1]); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := form.CreateFormFile("image", upload.Filename)
+		if err == nil {
+			_, err = io.Copy(part, upload.Content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, form.FormDataContentType()
+}
+
+// refPath returns the path of the ref of an image, refs name the server without a scheme by default
+func refPath(ref string) (string, error) {
+	if !strings.Contains(ref, "://") {
+		ref = "http://" + ref
+	}
+	parsed, err := url.Parse(ref)
+	if err != nil || !strings.HasPrefix(parsed.Path, "/image/") {
+		return "", fmt.Errorf("invalid image ref %q", ref)
+	}
+	return parsed.Path, nil
+}
+
+// ImageOptions select a rendition of an image, zero values return the original file
+type ImageOptions struct {
+	Width  int
+	Height int
+	Format string // Encoding such as webp, negotiated from Accept if empty
+}
+
+// Image downloads the file of the image, the caller closes the returned reader
+func (c *Client) Image(ctx context.Context, image Image, opts ImageOptions) (io.ReadCloser, string, error) {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return nil, "", err
+	}
+	query := url.Values{}
+	if opts.Width > 0 {
+		query.Set("w", strconv.Itoa(opts.Width))
+	}
+	if opts.Height > 0 {
+		query.Set("h", strconv.Itoa(opts.Height))
+	}
+	if len(opts.Format) > 0 {
+		query.Set("format", opts.Format)
+	}
+
+	req, err := c.NewRequest(ctx, "GET", path, query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// ImageUpdate changes the metadata of an image, nil properties are unchanged and empty texts clear them
+type ImageUpdate struct {
+	Title       *string
+	Description *string
+	AltText     *string
+	Shareable   *bool
+}
+
+// UpdateImage changes the metadata of the image and returns the updated metadata
+func (c *Client) UpdateImage(ctx context.Context, image Image, update ImageUpdate) (Image, error) {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Every property is sent as a string, omitted properties are unchanged
+	params := map[string]string{}
+	if update.Title != nil {
+		params["title"] = *update.Title
+	}
+	if update.Description != nil {
+		params["description"] = *update.Description
+	}
+	if update.AltText != nil {
+		params["altText"] = *update.AltText
+	}
+	if update.Shareable != nil {
+		params["shareable"] = strconv.FormatBool(*update.Shareable)
+	}
+
+	updated := Image{}
+	err = c.call(ctx, "PUT", path, nil, params, &updated)
+	return updated, err
+}
+
+// DeleteImage moves the image to the trash
+func (c *Client) DeleteImage(ctx context.Context, image Image) error {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "DELETE", path, nil, nil, nil)
+}
+
+// RestoreImage restores the image from the trash
+func (c *Client) RestoreImage(ctx context.Context, image Image) error {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "POST", path+"/restore", nil, nil, nil)
+}
+
+// LikeImage adds the image to the images the user likes
+func (c *Client) LikeImage(ctx context.Context, image Image) error {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "POST", path+"/like", nil, nil, nil)
+}
+
+// UnlikeImage removes the image from the images the user likes
+func (c *Client) UnlikeImage(ctx context.Context, image Image) error {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "DELETE", path+"/like", nil, nil, nil)
+}
+
+// RateImage records the user's rating of the image with 1 to 5 stars, replacing an earlier rating
+func (c *Client) RateImage(ctx context.Context, image Image, stars int) error {
+	path, err := refPath(image.Ref)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "POST", path+"/rating", nil, map[string]int{"stars": stars}, nil)
+}
+
+// MetaQuery filters and orders the metadata of images, zero values are not applied
+type MetaQuery struct {
+	Id           int32
+	Title        string
+	Description  string
+	Encoding     string
+	Tags         []string
+	TagMode      string // all or any of the tags, all by default
+	Shareable    *bool
+	SharedWithMe bool // Query images shared with the user instead of their own
+	Liked        bool // Query the images the user likes
+	Sort         string
+	Order        string // asc or desc
+	Page         int
+	PageSize     int
+}
+
+// Values returns the query parameters of the query
+func (q MetaQuery) Values() url.Values {
+	values := url.Values{}
+	set := func(key string, value string) {
+		if len(value) > 0 {
+			values.Set(key, value)
+		}
+	}
+	if q.Id > 0 {
+		set("id", strconv.Itoa(int(q.Id)))
+	}
+	set("title", q.Title)
+	set("description", q.Description)
+	set("encoding", q.Encoding)
+	set("tags", strings.Join(q.Tags, ","))
+	set("tagMode", q.TagMode)
+	if q.Shareable != nil {
+		set("shareable", strconv.FormatBool(*q.Shareable))
+	}
+	if q.SharedWithMe {
+		set("sharedWithMe", "true")
+	}
+	if q.Liked {
+		set("liked", "true")
+	}
+	set("sort", q.Sort)
+	set("order", q.Order)
+	if q.Page > 0 {
+		set("page", strconv.Itoa(q.Page))
+	}
+	if q.PageSize > 0 {
+		set("pageSize", strconv.Itoa(q.PageSize))
+	}
+	return values
+}
+
+// QueryMeta returns a page of the metadata of the images matching the query
+func (c *Client) QueryMeta(ctx context.Context, query MetaQuery) (MetaPage, error) {
+	page := MetaPage{}
+	err := c.call(ctx, "GET", "/image/meta", query.Values(), nil, &page)
+	return page, err
+}
+
+// EachImage calls fn with the metadata of every image matching the query, following the pages of
+// the query from query.Page until the last page or fn returns an error
+func (c *Client) EachImage(ctx context.Context, query MetaQuery, fn func(Image) error) error {
+	for {
+		page, err := c.QueryMeta(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, image := range page.Images {
+			if err := fn(image); err != nil {
+				return err
+			}
+		}
+		if len(page.Next) == 0 || len(page.Images) == 0 {
+			return nil
+		}
+		query.Page = page.Page + 1
+	}
+}
+
+// ShareImage shares the image with the account of the email
+func (c *Client) ShareImage(ctx context.Context, image Image, email string) (Share, error) {
+	share := Share{}
+	err := c.call(ctx, "POST", fmt.Sprintf("/image/%v/shares", image.Id), nil, map[string]string{"email": email}, &share)
+	return share, err
+}
+
+// ImageShares returns the users the image is shared with
+func (c *Client) ImageShares(ctx context.Context, image Image) ([]Share, error) {
+	shares := []Share{}
+	err := c.call(ctx, "GET", fmt.Sprintf("/image/%v/shares", image.Id), nil, nil, &shares)
+	return shares, err
+}
+
+// RevokeShare stops sharing the image with the user
+func (c *Client) RevokeShare(ctx context.Context, image Image, uid int32) error {
+	return c.call(ctx, "DELETE", fmt.Sprintf("/image/%v/shares/%v", image.Id, uid), nil, nil, nil)
+}
@@ -0,0 +1,119 @@
+package client
+
+import "time"
+
+// Timestamps are decoded as time.Time, which requires the server's default RFC 3339 TIMESTAMP_FORMAT
+
+// Token is an auth token returned by signing in
+type Token struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"token"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// User is an account
+type User struct {
+	Uid       int32  `json:"uid"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+}
+
+// Mention is a user mentioned in the description of an image
+type Mention struct {
+	Uid      int32  `json:"uid"`
+	Username string `json:"username"`
+	Offset   int32  `json:"offset"`
+	Length   int32  `json:"length"`
+}
+
+// Image is the metadata of an image
+type Image struct {
+	Id            int32     `json:"id"`
+	Uid           int32     `json:"uid"`
+	Title         string    `json:"title"`
+	Ref           string    `json:"ref"` // Location of the file, see Client.Image
+	Size          int32     `json:"size"`
+	Encoding      string    `json:"encoding"`
+	Shareable     bool      `json:"shareable"`
+	Description   string    `json:"description"`
+	AltText       string    `json:"altText"`
+	Hash          string    `json:"hash"` // Hex sha256 of the file
+	Tags          []string  `json:"tags"`
+	Mentions      []Mention `json:"mentions"`
+	LikeCount     int64     `json:"likeCount"`   // Users liking the image
+	Rating        float64   `json:"rating"`      // Average stars, 0 if unrated
+	RatingCount   int64     `json:"ratingCount"` // Users who rated the image
+	ViewCount     int64     `json:"viewCount"`
+	DownloadCount int64     `json:"downloadCount"`
+}
+
+// FacetCount is the number of images with a tag or encoding
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Facets count the tags and encodings across every page of a query
+type Facets struct {
+	Tags      []FacetCount `json:"tags"`
+	Encodings []FacetCount `json:"encodings"`
+}
+
+// MetaPage is a page of the results of a metadata query
+type MetaPage struct {
+	Page         int     `json:"page"`
+	PageSize     int     `json:"pageSize"`
+	TotalResults int     `json:"totalResults"`
+	Images       []Image `json:"imageMeta"`
+	Facets       Facets  `json:"facets"`
+	Prev         string  `json:"prev,omitempty"` // Path of the previous page, empty on the first page
+	Next         string  `json:"next,omitempty"` // Path of the next page, empty on the last page
+}
+
+// Share is a user an image is shared with
+type Share struct {
+	Uid       int32     `json:"uid"`
+	Email     string    `json:"email"`
+	Firstname string    `json:"firstname"`
+	Lastname  string    `json:"lastname"`
+	Created   time.Time `json:"created"`
+}
+
+// Album is an ordered collection of images
+type Album struct {
+	Id          int32     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Shareable   bool      `json:"shareable"`
+	ImageCount  int       `json:"imageCount"`
+	ImageIds    []int32   `json:"imageIds"`
+	CoverId     int32     `json:"coverId"` // 0 if the album is empty
+	Cover       *Image    `json:"cover"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// AlbumDetail is an album with its images in order
+type AlbumDetail struct {
+	Album
+	Images []Image `json:"images"`
+}
+
+// AlbumParams are the properties of an album, nil properties are unchanged by updates
+type AlbumParams struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Shareable   *bool   `json:"shareable,omitempty"`
+}
+
+// ApiKey is an API key of the user, Key is only set when the key is created
+type ApiKey struct {
+	Id       int32      `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Key      string     `json:"key,omitempty"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
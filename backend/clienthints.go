@@ -0,0 +1,116 @@
+package main
+
+/*
+	This file sizes images for the client from its Client Hints. Browsers that were sent Accept-CH
+	report the width the image is displayed at (Sec-CH-Width), the viewport width (Sec-CH-Viewport-Width),
+	the device pixel ratio (Sec-CH-DPR) and whether the user asked to save data (Save-Data). Image GETs
+	without explicit variant parameters are then served as a variant scaled down to the nearest of a few
+	standard widths so variants are shared between devices and stay cached. Save-Data requests are served
+	at a device pixel ratio of 1. Responses vary on the hints so shared caches keep the variants apart.
+*/
+
+import (
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SAVE_DATA_WIDTH = 640 // Width served to Save-Data requests that don't hint a width
+	HINT_MAX_DPR    = 4   // Largest device pixel ratio honored
+)
+
+// hintWidths are the widths images are scaled to for client hints in ascending order, hinted widths
+// are rounded up to the nearest so the image isn't displayed blurry
+var hintWidths = []int{160, 320, 480, 640, 960, 1280, 1920, 2560, 3840}
+
+// acceptedHints lists the client hints requested from browsers, variedHints the headers responses depend on
+var (
+	acceptedHints = []string{"Sec-CH-Width", "Sec-CH-Viewport-Width", "Sec-CH-DPR", "Save-Data"}
+	variedHints   = []string{"Sec-CH-Width", "Sec-CH-Viewport-Width", "Sec-CH-DPR", "Width", "Viewport-Width", "DPR", "Save-Data"}
+)
+
+// clientHintsEnabled reports whether images are sized by client hints, disabled by setting CLIENT_HINTS to false
+func clientHintsEnabled() bool {
+	return os.Getenv("CLIENT_HINTS") != "false"
+}
+
+// clientHintsApply reports whether the image is sized by the client hints of requests for it
+func clientHintsApply(image Image) bool {
+	return clientHintsEnabled() && canDecode(image.Encoding) && canEncode(image.Encoding)
+}
+
+// hintValue returns the value of the client hint, falling back to the header of its earlier draft
+func hintValue(req *http.Request, hint string) string {
+	value := req.Header.Get("Sec-CH-" + hint)
+	if len(value) == 0 {
+		value = req.Header.Get(hint)
+	}
+	return strings.TrimSpace(value)
+}
+
+// hintedWidth returns the width in pixels the client hints of the request ask for rounded up to a
+// standard width, 0 when the request has no usable hints or asks for more than the largest standard width
+func hintedWidth(req *http.Request) int {
+	dpr, err := strconv.ParseFloat(hintValue(req, "DPR"), 64)
+	if err != nil || dpr <= 0 {
+		dpr = 1
+	}
+	if dpr > HINT_MAX_DPR {
+		dpr = HINT_MAX_DPR
+	}
+
+	// Width is in device pixels, Viewport-Width in CSS pixels
+	width, err := strconv.ParseFloat(hintValue(req, "Width"), 64)
+	if err != nil || width <= 0 {
+		width = 0
+		viewport, err := strconv.ParseFloat(hintValue(req, "Viewport-Width"), 64)
+		if err == nil && viewport > 0 {
+			width = viewport * dpr
+		}
+	}
+
+	if strings.EqualFold(req.Header.Get("Save-Data"), "on") {
+		width = width / dpr
+		if width == 0 {
+			width = SAVE_DATA_WIDTH
+		}
+	}
+	if width == 0 {
+		return 0
+	}
+
+	for _, standard := range hintWidths {
+		if float64(standard) >= width {
+			return standard
+		}
+	}
+	return 0
+}
+
+// writeHintedImage serves the image scaled to the width hinted by the client, the stored file is served
+// when no hint was sent or the image is no wider than the hinted width
+func writeHintedImage(w http.ResponseWriter, req *http.Request, imageMeta Image) {
+	w.Header().Set("Accept-CH", strings.Join(acceptedHints, ", "))
+	w.Header().Add("Vary", strings.Join(variedHints, ", "))
+
+	file, ok := openImageFile(w, req, imageMeta, imageKey(imageMeta))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	if width := hintedWidth(req); width > 0 {
+		config, _, err := image.DecodeConfig(file)
+		_, seekErr := file.Seek(0, io.SeekStart)
+		if err == nil && seekErr == nil && width < config.Width {
+			writeVariant(w, req, imageMeta, file, variantOptions{Width: width, Fit: FIT_CONTAIN, Encoding: imageMeta.Encoding})
+			return
+		}
+	}
+
+	serveImageFile(w, req, imageMeta, imageKey(imageMeta), file)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHintedWidth ensures hints are converted to device pixels and rounded up to a standard width
+func TestHintedWidth(t *testing.T) {
+	tt := []struct {
+		Headers  map[string]string
+		Expected int
+	}{
+		{map[string]string{}, 0},
+		{map[string]string{"Sec-CH-Width": "500"}, 640},
+		{map[string]string{"Width": "320"}, 320},
+		{map[string]string{"Sec-CH-Viewport-Width": "400", "Sec-CH-DPR": "2"}, 960},
+		{map[string]string{"Sec-CH-Viewport-Width": "400", "Sec-CH-DPR": "9"}, 1920},
+		{map[string]string{"Sec-CH-Width": "1000", "Sec-CH-DPR": "2", "Save-Data": "on"}, 640},
+		{map[string]string{"Save-Data": "on"}, SAVE_DATA_WIDTH},
+		{map[string]string{"Sec-CH-Width": "5000"}, 0},
+		{map[string]string{"Sec-CH-Width": "wide"}, 0},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest("GET", "/image/1/1.png", nil)
+		for header, value := range tc.Headers {
+			req.Header.Set(header, value)
+		}
+		if width := hintedWidth(req); width != tc.Expected {
+			t.Errorf("wrong width for %v: got %v want %v", tc.Headers, width, tc.Expected)
+		}
+	}
+}
+
+// TestWriteHintedImage ensures images wider than the hinted width are scaled down and others served as stored
+func TestWriteHintedImage(t *testing.T) {
+	dir := t.TempDir()
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &localStorage{root: dir}
+
+	content := testImage(t, "png", 700)
+	image := Image{Id: 1, Uid: 1, Title: "wide.png", Encoding: "image/png"}
+	storage.Put(context.Background(), imageKey(image), bytes.NewReader(content), int64(len(content)), image.Encoding)
+
+	serve := func(header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/image/1/1.png", nil)
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		writeHintedImage(rr, req, image)
+		return rr
+	}
+
+	rr := serve("Sec-CH-Width", "500")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to serve hinted image: got %v", rr.Code)
+	}
+	config, err := png.DecodeConfig(rr.Body)
+	if err != nil || config.Width != 640 {
+		t.Errorf("wrong hinted width: got %v %v want 640", config.Width, err)
+	}
+	if len(rr.Header().Get("Accept-CH")) == 0 || len(rr.Header().Get("Vary")) == 0 {
+		t.Errorf("missing client hint headers: got %v", rr.Header())
+	}
+
+	for _, header := range []string{"", "Sec-CH-Width"} {
+		rr = serve(header, "1200")
+		if !bytes.Equal(rr.Body.Bytes(), content) {
+			t.Errorf("stored image not served for hint %q: got %v bytes", header, rr.Body.Len())
+		}
+	}
+}
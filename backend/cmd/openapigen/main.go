@@ -0,0 +1,216 @@
+/*
+openapigen generates the OpenAPI document served by the server at /api/openapi.json from the
+YAML specification maintained in devops/swagger. The document is converted to json in the order
+it is written, its servers are made relative to the host serving it and error responses without
+a body are described as the plain text Error schema. The output is a Go source file of package
+main holding the document, run through go generate in ./backend:
+
+	go run ./cmd/openapigen -in ../devops/swagger/api-spec.yaml -out openapi_spec.go
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	API_SERVER     = "/api/v1" // Server of the versioned endpoints
+	SERVICE_SERVER = "/"       // Server of paths listing servers of their own, the service endpoints
+	ERROR_SCHEMA   = "#/components/schemas/Error"
+)
+
+var (
+	in  = flag.String("in", "../devops/swagger/api-spec.yaml", "YAML specification to convert")
+	out = flag.String("out", "openapi_spec.go", "Go source file to write")
+)
+
+func main() {
+	flag.Parse()
+
+	spec, err := ioutil.ReadFile(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "openapigen: %v\n", err)
+		os.Exit(1)
+	}
+	source, err := generate(spec, *in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "openapigen: %v\n", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(*out, source, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "openapigen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate returns the Go source holding the json document of the YAML specification read from name
+func generate(spec []byte, name string) ([]byte, error) {
+	doc := yaml.Node{}
+	err := yaml.Unmarshal(spec, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s is not an OpenAPI document", name)
+	}
+	root := doc.Content[0]
+
+	setValue(root, "servers", servers(API_SERVER))
+	paths := value(root, "paths")
+	if paths == nil {
+		return nil, fmt.Errorf("%s has no paths", name)
+	}
+	for i := 1; i < len(paths.Content); i += 2 {
+		describeErrors(paths.Content[i])
+	}
+
+	buf := new(bytes.Buffer)
+	err = writeJSON(buf, root, "")
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(buf.String(), "`") {
+		return nil, fmt.Errorf("%s contains a backtick which can't be held in a raw string", name)
+	}
+
+	source := new(bytes.Buffer)
+	fmt.Fprintf(source, "// Code generated by openapigen from %s. DO NOT EDIT.\n\n", strings.TrimPrefix(name, "../"))
+	fmt.Fprintf(source, "package main\n\n")
+	fmt.Fprintf(source, "// openapiSpec is the OpenAPI document served at /api/openapi.json\n")
+	fmt.Fprintf(source, "const openapiSpec = `%s`\n", buf.String())
+	return source.Bytes(), nil
+}
+
+// describeErrors makes the servers of the path relative and describes the error responses of its
+// operations without a body as the Error schema
+func describeErrors(path *yaml.Node) {
+	if value(path, "servers") != nil {
+		setValue(path, "servers", servers(SERVICE_SERVER))
+	}
+	for i := 1; i < len(path.Content); i += 2 {
+		responses := value(path.Content[i], "responses")
+		if responses == nil {
+			continue
+		}
+		for j := 0; j+1 < len(responses.Content); j += 2 {
+			status, response := responses.Content[j].Value, responses.Content[j+1]
+			if len(status) != 3 || status[0] < '4' || value(response, "content") != nil {
+				continue
+			}
+			setValue(response, "content", mapping("text/plain", mapping("schema", mapping("$ref", scalar(ERROR_SCHEMA)))))
+		}
+	}
+}
+
+// value returns the value of the key of the mapping, nil if it has none
+func value(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setValue replaces the value of the key of the mapping, appending the key if it has none
+func setValue(node *yaml.Node, key string, val *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = val
+			return
+		}
+	}
+	node.Content = append(node.Content, scalar(key), val)
+}
+
+// scalar returns a string node
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// mapping returns a mapping node of a single key
+func mapping(key string, val *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{scalar(key), val}}
+}
+
+// servers returns a list of a single server at the url
+func servers(url string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{mapping("url", scalar(url))}}
+}
+
+// writeJSON writes the node as indented json preserving the order of mappings
+func writeJSON(buf *bytes.Buffer, node *yaml.Node, indent string) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias, indent)
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "%s  %s: ", indent, key)
+			err = writeJSON(buf, node.Content[i+1], indent+"  ")
+			if err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range node.Content {
+			buf.WriteString(indent + "  ")
+			err := writeJSON(buf, item, indent+"  ")
+			if err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	case yaml.ScalarNode:
+		var scalar interface{}
+		err := node.Decode(&scalar)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", node.Line, err)
+		}
+		if node.Tag == "!!timestamp" {
+			scalar = node.Value // Dates such as examples are kept as written
+		}
+		js, err := json.Marshal(scalar)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", node.Line, err)
+		}
+		buf.Write(js)
+	default:
+		return fmt.Errorf("line %v: unexpected yaml node", node.Line)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestGenerate ensures servers are made relative, error responses are described and order is kept
+func TestGenerate(t *testing.T) {
+	spec := `openapi: 3.0.0
+servers:
+  - url: https://pictocache.example.com/api/v1
+paths:
+  /ping:
+    servers:
+      - url: https://pictocache.example.com/
+    get:
+      responses:
+        '200':
+          description: pong
+        '404':
+          description: not found
+  /image:
+    post:
+      responses:
+        '400':
+          description: bad request
+          content:
+            application/json:
+              schema:
+                type: object
+`
+	source, err := generate([]byte(spec), "spec.yaml")
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	js := string(source[bytes.IndexByte(source, '`')+1 : bytes.LastIndexByte(source, '`')])
+
+	doc := struct {
+		Servers []struct{ Url string }
+		Paths   map[string]map[string]json.RawMessage
+	}{}
+	if err := json.Unmarshal([]byte(js), &doc); err != nil {
+		t.Fatalf("failed to unmarshal document: %v", err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].Url != API_SERVER {
+		t.Errorf("wrong servers: got %+v", doc.Servers)
+	}
+	if !strings.Contains(string(doc.Paths["/ping"]["servers"]), `"url": "/"`) {
+		t.Errorf("wrong path servers: got %s", doc.Paths["/ping"]["servers"])
+	}
+	if !strings.Contains(string(doc.Paths["/ping"]["get"]), ERROR_SCHEMA) {
+		t.Errorf("error response not described: got %s", doc.Paths["/ping"]["get"])
+	}
+	if strings.Contains(string(doc.Paths["/image"]["post"]), ERROR_SCHEMA) {
+		t.Errorf("described error response replaced: got %s", doc.Paths["/image"]["post"])
+	}
+	if strings.Index(js, "/ping") > strings.Index(js, "/image") {
+		t.Errorf("order of paths not kept")
+	}
+}
+
+// TestGeneratedSpec ensures openapi_spec.go was regenerated after the specification last changed
+func TestGeneratedSpec(t *testing.T) {
+	spec, err := ioutil.ReadFile("../../../devops/swagger/api-spec.yaml")
+	if err != nil {
+		t.Fatalf("failed to read specification: %v", err)
+	}
+	source, err := generate(spec, "../devops/swagger/api-spec.yaml")
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	generated, err := ioutil.ReadFile("../../openapi_spec.go")
+	if err != nil {
+		t.Fatalf("failed to read generated document: %v", err)
+	}
+	if !bytes.Equal(source, generated) {
+		t.Errorf("openapi_spec.go is out of date, run go generate in ./backend")
+	}
+}
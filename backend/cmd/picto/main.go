@@ -0,0 +1,358 @@
+/*
+picto is a command line tool for Picto Cache built on the client package. It signs in, uploads
+files and globs with tags, lists images and downloads them, and watches folders to upload new
+images as they appear, so screenshot folders and other files can be synced from scripts.
+
+The server is named by the PICTO_URL environment variable or the -server flag. Requests are
+authenticated with the PICTO_API_KEY environment variable if it is set, otherwise with the token
+saved by picto login in the user's config directory.
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"picto-cache/client"
+)
+
+const (
+	DEFAULT_SERVER = "http://localhost:8000" // Default if PICTO_URL env variable is not defined
+	TOKEN_FILE     = "token"                 // Name of the saved token in the config directory
+)
+
+var server = flag.String("server", "", "url of the server, defaults to the PICTO_URL env variable or "+DEFAULT_SERVER)
+
+// errUsage is returned by commands given invalid arguments
+var errUsage = errors.New("invalid arguments")
+
+// command runs a subcommand with its arguments
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"login":    {"login [-totp code] email", login},
+	"logout":   {"logout", logout},
+	"upload":   {"upload [-tags a,b] [-shareable] [-dedup] file|glob...", upload},
+	"list":     {"list [-tags a,b] [-title text] [-shared]", list},
+	"download": {"download [-o path] [-w width] [-h height] id", download},
+	"watch":    {"watch [-tags a,b] [-shareable] [-dedup] [-delete] [-interval 5s] [-once] dir", watch},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "picto: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(context.Background(), flag.Args()[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: picto %s\n", cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "picto %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: picto [-server url] command\n\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  picto %s\n", commands[name].usage)
+	}
+}
+
+// serverURL returns the url of the server from the -server flag or PICTO_URL environment variable
+func serverURL() string {
+	if len(*server) > 0 {
+		return *server
+	}
+	if env := os.Getenv("PICTO_URL"); len(env) > 0 {
+		return env
+	}
+	return DEFAULT_SERVER
+}
+
+// tokenPath returns the path the token of the server is saved at
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find config directory: %v", err)
+	}
+	return filepath.Join(dir, "picto", TOKEN_FILE), nil
+}
+
+// newClient returns a client authenticated with the API key or saved token
+func newClient() (*client.Client, error) {
+	opts := []client.Option{}
+	if key := os.Getenv("PICTO_API_KEY"); len(key) > 0 {
+		opts = append(opts, client.WithAPIKey(key))
+	} else if path, err := tokenPath(); err == nil {
+		if token, err := ioutil.ReadFile(path); err == nil {
+			opts = append(opts, client.WithToken(strings.TrimSpace(string(token))))
+		}
+	}
+	return client.New(serverURL(), opts...)
+}
+
+// login signs in and saves the token, the password is read from PICTO_PASSWORD or standard input
+func login(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	totp := flags.String("totp", "", "two-factor authentication code or recovery code")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	password := os.Getenv("PICTO_PASSWORD")
+	if len(password) == 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	c, err := client.New(serverURL())
+	if err != nil {
+		return err
+	}
+	token, err := c.Auth(ctx, flags.Arg(0), password, *totp)
+	var required *client.TotpRequiredError
+	if errors.As(err, &required) {
+		return fmt.Errorf("two-factor authentication is enabled, sign in again with -totp code")
+	}
+	if err != nil {
+		return err
+	}
+
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(token.Value), 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save token: %v", err)
+	}
+	fmt.Printf("Signed in until %s\n", token.Expiration.Local().Format(time.Kitchen))
+	return nil
+}
+
+// logout removes the saved token
+func logout(ctx context.Context, args []string) error {
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// expandFiles returns the files matching the arguments, which are file paths or glob patterns,
+// without duplicates or directories
+func expandFiles(args []string) ([]string, error) {
+	files := []string{}
+	seen := map[string]bool{}
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() || seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+	return files, nil
+}
+
+// splitTags returns the tags of a comma separated list
+func splitTags(list string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// upload uploads every file matching the arguments, continuing past failed files
+func upload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	tags := flags.String("tags", "", "comma separated tags of the images")
+	shareable := flags.Bool("shareable", false, "make the images public")
+	dedup := flags.Bool("dedup", false, "link identical content stored by another member instead of storing a copy")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	files, err := expandFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range files {
+		image, err := uploadFile(ctx, c, path, client.Upload{Tags: splitTags(*tags), Shareable: *shareable, Dedup: *dedup})
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s\t%v\t%s\n", path, image.Id, image.Ref)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files failed", failed, len(files))
+	}
+	return nil
+}
+
+// uploadFile streams the file to the server
+func uploadFile(ctx context.Context, c *client.Client, path string, upload client.Upload) (client.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return client.Image{}, err
+	}
+	defer file.Close()
+
+	upload.Filename = filepath.Base(path)
+	upload.Content = file
+	return c.UploadImage(ctx, upload)
+}
+
+// list prints every image of the user matching the filters
+func list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	tags := flags.String("tags", "", "only list images with all of the comma separated tags")
+	title := flags.String("title", "", "only list images whose title contains the text")
+	shared := flags.Bool("shared", false, "list images shared with you instead of your own")
+	flags.Parse(args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "ID\tTITLE\tSIZE\tTAGS")
+	query := client.MetaQuery{Title: *title, Tags: splitTags(*tags), SharedWithMe: *shared}
+	err = c.EachImage(ctx, query, func(image client.Image) error {
+		_, err := fmt.Fprintf(out, "%v\t%s\t%v\t%s\n", image.Id, image.Title, image.Size, strings.Join(image.Tags, ","))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// download saves the file of the image with the id, named after its title unless -o is given
+func download(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	output := flags.String("o", "", "path to save the image at, - writes it to standard output")
+	width := flags.Int("w", 0, "width of the downloaded rendition")
+	height := flags.Int("h", 0, "height of the downloaded rendition")
+	flags.Parse(args)
+	var id int32
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	if _, err := fmt.Sscanf(flags.Arg(0), "%d", &id); err != nil || id <= 0 {
+		return fmt.Errorf("invalid id %q", flags.Arg(0))
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	page, err := c.QueryMeta(ctx, client.MetaQuery{Id: id})
+	if err == nil && len(page.Images) == 0 {
+		page, err = c.QueryMeta(ctx, client.MetaQuery{Id: id, SharedWithMe: true})
+	}
+	if err != nil {
+		return err
+	}
+	if len(page.Images) == 0 {
+		return fmt.Errorf("no image with id %v", id)
+	}
+	image := page.Images[0]
+
+	body, _, err := c.Image(ctx, image, client.ImageOptions{Width: *width, Height: *height})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	path := *output
+	if len(path) == 0 {
+		path = filepath.Base(image.Title)
+	}
+	if path == "-" {
+		_, err = io.Copy(os.Stdout, body)
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	fmt.Println(path)
+	return nil
+}
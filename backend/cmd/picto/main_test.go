@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestExpandFiles ensures paths and globs expand to each file once without directories
+func TestExpandFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "b.png", "notes.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+	}
+	os.Mkdir(filepath.Join(dir, "c.png"), 0700)
+
+	files, err := expandFiles([]string{filepath.Join(dir, "*.png"), filepath.Join(dir, "a.png"), filepath.Join(dir, "notes.txt")})
+	want := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png"), filepath.Join(dir, "notes.txt")}
+	if err != nil || !reflect.DeepEqual(files, want) {
+		t.Errorf("wrong files: got %v %v want %v", files, err, want)
+	}
+
+	if _, err := expandFiles([]string{filepath.Join(dir, "*.gif")}); err == nil {
+		t.Errorf("expected error for pattern matching nothing")
+	}
+	if tags := splitTags(" cat, ,pet,"); !reflect.DeepEqual(tags, []string{"cat", "pet"}) {
+		t.Errorf("wrong tags: got %v", tags)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+/*
+	This file implements picto watch, which keeps the images of a folder uploaded. The folder is
+	scanned on an interval and new or changed images are uploaded once they have stopped being
+	written to. Files are identified by the sha256 hash of their content, so content the user has
+	already uploaded, from this folder or elsewhere, is recorded without being sent again. With
+	-delete the images uploaded from files since removed from the folder are moved to the trash,
+	images the user uploaded some other way are never deleted.
+
+	The files synced from each folder are recorded in the user's config directory so unchanged
+	files aren't hashed again and deletions are noticed across restarts.
+*/
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"picto-cache/client"
+)
+
+const (
+	WATCH_INTERVAL = 5 * time.Second // Default time between scans
+	SETTLE_TIME    = 2 * time.Second // Files modified more recently are still being written
+	SYNC_DIR       = "sync"          // Directory of the config directory recording synced folders
+)
+
+// IMAGE_EXTENSIONS are the files uploaded from watched folders
+var IMAGE_EXTENSIONS = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".avif"}
+
+// syncedFile is a file of the folder and the image it was uploaded as
+type syncedFile struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Hash     string    `json:"hash"`
+	Id       int32     `json:"id"`
+	Ref      string    `json:"ref"`
+	Uploaded bool      `json:"uploaded"` // Uploaded from the folder rather than found among the user's images
+}
+
+// fileStamp identifies a version of a file without reading it
+type fileStamp struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// watcher syncs a folder with the images of the user
+type watcher struct {
+	c         *client.Client
+	dir       string
+	upload    client.Upload // Options of every upload, without a file
+	delete    bool          // Move the images of removed files to the trash
+	statePath string
+
+	files  map[string]syncedFile   // Synced files by path relative to dir
+	remote map[string]client.Image // Images of the user by hash
+	failed map[string]fileStamp    // Files that failed to upload, retried once they change
+	now    func() time.Time
+}
+
+// watch uploads the images of a folder and keeps uploading new images until interrupted
+func watch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	tags := flags.String("tags", "", "comma separated tags of the uploaded images")
+	shareable := flags.Bool("shareable", false, "make the uploaded images public")
+	dedup := flags.Bool("dedup", false, "link identical content stored by another member instead of storing a copy")
+	remove := flags.Bool("delete", false, "move the images of files removed from the folder to the trash")
+	interval := flags.Duration("interval", WATCH_INTERVAL, "time between scans of the folder")
+	once := flags.Bool("once", false, "sync the folder once and exit")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *interval <= 0 {
+		return errUsage
+	}
+
+	dir, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", flags.Arg(0))
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	statePath, err := syncStatePath(dir)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{
+		c:         c,
+		dir:       dir,
+		upload:    client.Upload{Tags: splitTags(*tags), Shareable: *shareable, Dedup: *dedup},
+		delete:    *remove,
+		statePath: statePath,
+		now:       time.Now,
+	}
+	err = w.load(ctx)
+	if err != nil {
+		return err
+	}
+	// Files being written when a single sync starts can't be waited for
+	if *once {
+		w.now = func() time.Time { return time.Now().Add(SETTLE_TIME) }
+		return w.sync(ctx)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Watching %s, press Ctrl+C to stop\n", dir)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if err := w.sync(ctx); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "sync failed, retrying: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncStatePath returns the path the synced files of the folder on the server are recorded at
+func syncStatePath(dir string) (string, error) {
+	path, err := tokenPath()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(serverURL() + "\n" + dir))
+	return filepath.Join(filepath.Dir(path), SYNC_DIR, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// load reads the recorded files of the folder and the hashes of the images of the user
+func (w *watcher) load(ctx context.Context) error {
+	w.files = map[string]syncedFile{}
+	w.failed = map[string]fileStamp{}
+	data, err := ioutil.ReadFile(w.statePath)
+	if err == nil {
+		err = json.Unmarshal(data, &w.files)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to read synced files of %s: %v", w.dir, err)
+	}
+
+	w.remote = map[string]client.Image{}
+	return w.c.EachImage(ctx, client.MetaQuery{}, func(image client.Image) error {
+		if len(image.Hash) > 0 {
+			w.remote[image.Hash] = image
+		}
+		return nil
+	})
+}
+
+// save records the synced files of the folder
+func (w *watcher) save() error {
+	data, err := json.MarshalIndent(w.files, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(w.statePath), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(w.statePath, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to record synced files: %v", err)
+	}
+	return nil
+}
+
+// imageFiles returns the images of the folder and its subfolders by relative path, hidden files
+// and folders are skipped
+func imageFiles(dir string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !imageExtension(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = info
+		return nil
+	})
+	return files, err
+}
+
+// imageExtension reports whether the path has the extension of an image
+func imageExtension(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, image := range IMAGE_EXTENSIONS {
+		if ext == image {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile returns the hex sha256 hash of the content of the file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sync uploads new and changed images of the folder and forgets removed files, moving their
+// images to the trash with -delete. Files that fail are reported and retried once they change
+func (w *watcher) sync(ctx context.Context) error {
+	files, err := imageFiles(w.dir)
+	if err != nil {
+		return fmt.Errorf("unable to scan %s: %v", w.dir, err)
+	}
+	for rel, info := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		stamp := fileStamp{info.Size(), info.ModTime()}
+		synced, known := w.files[rel]
+		if known && synced.Size == stamp.Size && synced.ModTime.Equal(stamp.ModTime) {
+			continue
+		}
+		if failed, ok := w.failed[rel]; (ok && failed == stamp) || w.now().Sub(stamp.ModTime) < SETTLE_TIME {
+			continue
+		}
+
+		image, uploaded, err := w.syncFile(ctx, rel)
+		if err != nil {
+			w.failed[rel] = stamp
+			fmt.Fprintf(os.Stderr, "%s: %v\n", rel, err)
+			continue
+		}
+		delete(w.failed, rel)
+		w.files[rel] = syncedFile{Size: stamp.Size, ModTime: stamp.ModTime, Hash: image.Hash, Id: image.Id, Ref: image.Ref, Uploaded: uploaded}
+		// The previous content of a changed file is removed like a removed file
+		if known && synced.Id != image.Id {
+			w.forget(ctx, rel, synced)
+		}
+	}
+
+	for rel, synced := range w.files {
+		if _, ok := files[rel]; !ok && ctx.Err() == nil {
+			delete(w.files, rel)
+			w.forget(ctx, rel, synced)
+		}
+	}
+	return w.save()
+}
+
+// syncFile uploads the file unless the user already has an image with its content, it reports
+// whether the file was uploaded
+func (w *watcher) syncFile(ctx context.Context, rel string) (client.Image, bool, error) {
+	path := filepath.Join(w.dir, rel)
+	hash, err := hashFile(path)
+	if err != nil {
+		return client.Image{}, false, err
+	}
+	if image, ok := w.remote[hash]; ok {
+		// Copies of a file uploaded from the folder count as uploaded from it
+		uploaded := false
+		for _, synced := range w.files {
+			uploaded = uploaded || (synced.Id == image.Id && synced.Uploaded)
+		}
+		fmt.Printf("%s\t%v\t%s\talready uploaded\n", rel, image.Id, image.Ref)
+		return image, uploaded, nil
+	}
+
+	image, err := uploadFile(ctx, w.c, path, w.upload)
+	if err != nil {
+		return client.Image{}, false, err
+	}
+	// Images whose hash isn't returned are recorded under the hash of the file
+	if len(image.Hash) == 0 {
+		image.Hash = hash
+	}
+	w.remote[image.Hash] = image
+	fmt.Printf("%s\t%v\t%s\n", rel, image.Id, image.Ref)
+	return image, true, nil
+}
+
+// forget moves the image uploaded from a file no longer in the folder to the trash with -delete,
+// images still synced from another file of the folder are kept
+func (w *watcher) forget(ctx context.Context, rel string, synced syncedFile) {
+	if !w.delete || !synced.Uploaded {
+		return
+	}
+	for _, other := range w.files {
+		if other.Id == synced.Id {
+			return
+		}
+	}
+	err := w.c.DeleteImage(ctx, client.Image{Id: synced.Id, Ref: synced.Ref})
+	if err != nil && !client.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "%s: unable to delete image %v: %v\n", rel, synced.Id, err)
+		return
+	}
+	delete(w.remote, synced.Hash)
+	fmt.Printf("%s\t%v\tdeleted\n", rel, synced.Id)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"picto-cache/client"
+)
+
+// TestWatcherSync ensures new files are uploaded once, content already uploaded is only recorded,
+// files still being written wait, and only images uploaded from removed files are deleted
+func TestWatcherSync(t *testing.T) {
+	var mu sync.Mutex
+	uploads := []string{}
+	deleted := []string{}
+	existing := sha256.Sum256([]byte("existing"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Method == "GET" && req.URL.Path == client.API_PREFIX+"/image/meta":
+			json.NewEncoder(w).Encode(client.MetaPage{Images: []client.Image{{Id: 1, Ref: "localhost/image/1/old.png", Hash: hex.EncodeToString(existing[:])}}})
+		case req.Method == "POST" && req.URL.Path == client.API_PREFIX+"/image":
+			file, header, err := req.FormFile("image")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			content, _ := ioutil.ReadAll(file)
+			sum := sha256.Sum256(content)
+			uploads = append(uploads, header.Filename)
+			id := len(uploads) + 1
+			json.NewEncoder(w).Encode(client.Image{Id: int32(id), Ref: fmt.Sprintf("localhost/image/%v/%s", id, header.Filename), Hash: hex.EncodeToString(sum[:])})
+		case req.Method == "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(req.URL.Path, client.API_PREFIX))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.New(server.URL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	}
+	write("a.png", "a")
+	write("copy.png", "a")
+	write("old.png", "existing")
+	write("nested/b.jpg", "b")
+	write("notes.txt", "notes")
+	write(".hidden/c.png", "c")
+
+	now := time.Now().Add(SETTLE_TIME)
+	w := &watcher{c: c, dir: dir, delete: true, statePath: filepath.Join(t.TempDir(), "state.json"), now: func() time.Time { return now }}
+	ctx := context.Background()
+	if err := w.load(ctx); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if err := w.sync(ctx); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(uploads) != 2 || len(w.files) != 4 || w.files["old.png"].Id != 1 || w.files["old.png"].Uploaded || w.files["a.png"].Id != w.files["copy.png"].Id {
+		t.Fatalf("wrong sync: got uploads %v files %+v", uploads, w.files)
+	}
+	copied := w.files["copy.png"]
+
+	// Files still being written wait for the next scan
+	write("new.png", "new")
+	now = time.Now()
+	w.sync(ctx)
+	if len(uploads) != 2 {
+		t.Errorf("expected recently modified file to wait: got uploads %v", uploads)
+	}
+
+	// Images of removed files are deleted once no other file uses them, the state survives restarts
+	os.Remove(filepath.Join(dir, "a.png"))
+	os.Remove(filepath.Join(dir, "old.png"))
+	now = time.Now().Add(SETTLE_TIME)
+	restarted := &watcher{c: c, dir: dir, delete: true, statePath: w.statePath, now: w.now}
+	if err := restarted.load(ctx); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	restarted.sync(ctx)
+	if len(uploads) != 3 || len(deleted) != 0 || len(restarted.files) != 3 {
+		t.Errorf("wrong sync after removing files: got uploads %v deleted %v files %+v", uploads, deleted, restarted.files)
+	}
+	os.Remove(filepath.Join(dir, "copy.png"))
+	restarted.sync(ctx)
+	if len(deleted) != 1 || "localhost"+deleted[0] != copied.Ref {
+		t.Errorf("wrong deleted images: got %v", deleted)
+	}
+}
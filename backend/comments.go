@@ -0,0 +1,332 @@
+package main
+
+/*
+	This file lets users discuss the images they can view. Anyone who may view an image, the owner,
+	users it is shared with and viewers of publicly shared images, may comment on it while the owner
+	hasn't blocked them. Authors edit and delete their own comments and the owner of an image may
+	delete any comment on it to moderate the discussion. Comments are listed oldest first in pages.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	COMMENT_TABLE = "image_comment"
+	COMMENT_MAX   = 2000 // Characters allowed in a comment
+)
+
+// Comment is a comment on an image tagged for sql serialization
+type Comment struct {
+	Id      int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId int32     `sql:"image_id"`
+	Uid     int32     `sql:"uid"` // Author of the comment
+	Body    string    `sql:"body"`
+	Created time.Time `sql:"created"`
+	Edited  time.Time `sql:"edited"` // Zero until the author edits the comment
+}
+
+// CommentReq is the body of a new or edited comment
+type CommentReq struct {
+	Body string `json:"body"`
+}
+
+// CommentResp describes a comment with the username of its author, empty if they haven't chosen one
+type CommentResp struct {
+	Id       int32      `json:"id"`
+	ImageId  int32      `json:"imageId"`
+	Uid      int32      `json:"uid"`
+	Username string     `json:"username"`
+	Body     string     `json:"body"`
+	Created  Timestamp  `json:"created"`
+	Edited   *Timestamp `json:"edited,omitempty"`
+}
+
+// CommentsResp is a page of the comments of an image oldest first
+type CommentsResp struct {
+	Page         int           `json:"page"`
+	PageSize     int           `json:"pageSize"`
+	TotalResults int           `json:"totalResults"`
+	Comments     []CommentResp `json:"comments"`
+	Prev         string        `json:"prev,omitempty"` // Previous page, omitted on the first page
+	Next         string        `json:"next,omitempty"` // Next page, omitted on the last page
+}
+
+// newCommentResp describes the comment written by the author
+func newCommentResp(comment Comment, author User) CommentResp {
+	resp := CommentResp{
+		Id:       comment.Id,
+		ImageId:  comment.ImageId,
+		Uid:      comment.Uid,
+		Username: author.Username,
+		Body:     comment.Body,
+		Created:  Timestamp(comment.Created),
+	}
+	if !comment.Edited.IsZero() {
+		edited := Timestamp(comment.Edited)
+		resp.Edited = &edited
+	}
+	return resp
+}
+
+// validateComment trims the body of the comment and ensures it is between 1 and COMMENT_MAX characters
+func validateComment(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if len(body) == 0 {
+		return "", fmt.Errorf("comments may not be empty")
+	}
+	if !utf8.ValidString(body) || utf8.RuneCountInString(body) > COMMENT_MAX {
+		return "", fmt.Errorf("comments must be valid text of at most %v characters", COMMENT_MAX)
+	}
+	return body, nil
+}
+
+// parseCommentPage validates the page and pageSize parameters of a comment listing
+func parseCommentPage(params url.Values) (MetaPage, error) {
+	page := MetaPage{PageSize: PAGE_SIZE, Order: ORDER_ASC}
+
+	var err error
+	if params.Has("page") {
+		page.Page, err = strconv.Atoi(params.Get("page"))
+		if err != nil || page.Page < 0 {
+			return MetaPage{}, fmt.Errorf("invalid page %q, must be a non negative integer", params.Get("page"))
+		}
+	}
+	if params.Has("pageSize") {
+		page.PageSize, err = strconv.Atoi(params.Get("pageSize"))
+		if err != nil || page.PageSize < 1 || page.PageSize > PAGE_SIZE_MAX {
+			return MetaPage{}, fmt.Errorf("invalid pageSize %q, must be between 1 and %v", params.Get("pageSize"), PAGE_SIZE_MAX)
+		}
+	}
+	return page, nil
+}
+
+// commentableImage authenticates the request and retrieves the image in the url if the user may view it,
+// writes the error response and returns false otherwise
+func (s *Server) commentableImage(w http.ResponseWriter, req *http.Request) (JWTClaims, Image, bool) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for comments sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return JWTClaims{}, Image{}, false
+	}
+
+	image, ok := s.viewableImage(w, req, claims.Uid)
+	if !ok {
+		return JWTClaims{}, Image{}, false
+	}
+	return claims, image, true
+}
+
+// imageComment retrieves the comment in the url if it belongs to the image,
+// writes the error response and returns false otherwise
+func imageComment(w http.ResponseWriter, req *http.Request, image Image) (Comment, bool) {
+	id, err := strconv.Atoi(mux.Vars(req)["commentId"])
+	if err != nil {
+		logger.Error("invalid comment id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return Comment{}, false
+	}
+
+	comment, found, err := GetComment(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve comment sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve comment, try again later"))
+		return Comment{}, false
+	}
+	if !found || comment.ImageId != image.Id {
+		logger.Error("comment %v not found on image %v sending 404", id, image.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no comment with that id on this image"))
+		return Comment{}, false
+	}
+	return comment, true
+}
+
+// decodeComment reads and validates the comment in the body, writes the error response and returns false if it is invalid
+func decodeComment(w http.ResponseWriter, req *http.Request) (string, bool) {
+	var commentReq CommentReq
+	err := json.NewDecoder(req.Body).Decode(&commentReq)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return "", false
+	}
+
+	body, err := validateComment(commentReq.Body)
+	if err != nil {
+		logger.Error("invalid comment sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return "", false
+	}
+	return body, true
+}
+
+// postComment adds the comment in the json body to the image in the url
+func (s *Server) postComment(w http.ResponseWriter, req *http.Request) {
+	claims, image, ok := s.commentableImage(w, req)
+	if !ok {
+		return
+	}
+
+	body, ok := decodeComment(w, req)
+	if !ok {
+		return
+	}
+
+	comment := Comment{ImageId: image.Id, Uid: int32(claims.Uid), Body: body, Created: time.Now().UTC()}
+	id, err := AddComment(comment)
+	if err != nil {
+		logger.Error("failed to add comment sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to add comment, try again later"))
+		return
+	}
+	comment.Id = id
+
+	logger.Info("User %v commented on image %v", claims.Uid, image.Id)
+	writeComment(w, http.StatusCreated, comment)
+}
+
+// listComments returns a page of the comments of the image in the url oldest first
+func (s *Server) listComments(w http.ResponseWriter, req *http.Request) {
+	_, image, ok := s.commentableImage(w, req)
+	if !ok {
+		return
+	}
+
+	page, err := parseCommentPage(req.URL.Query())
+	if err != nil {
+		logger.Error("invalid comments page sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	comments, authors, total, err := ImageComments(image.Id, page)
+	if err != nil {
+		logger.Error("failed to retrieve comments sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve comments, try again later"))
+		return
+	}
+
+	resp := CommentsResp{Page: page.Page, PageSize: page.PageSize, TotalResults: total, Comments: []CommentResp{}}
+	for _, comment := range comments {
+		resp.Comments = append(resp.Comments, newCommentResp(comment, authors[comment.Uid]))
+	}
+	resp.Prev, resp.Next = page.links(req.URL.Path, req.URL.Query(), total)
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal comments sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve comments, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// editComment replaces the body of a comment of the authenticated user with the one in the json body
+func (s *Server) editComment(w http.ResponseWriter, req *http.Request) {
+	claims, image, ok := s.commentableImage(w, req)
+	if !ok {
+		return
+	}
+
+	comment, ok := imageComment(w, req, image)
+	if !ok {
+		return
+	}
+	if comment.Uid != int32(claims.Uid) {
+		logger.Error("user %v attempting to edit comment %v of user %v sending 403", claims.Uid, comment.Id, comment.Uid)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Forbidden, only the author may edit a comment"))
+		return
+	}
+
+	body, ok := decodeComment(w, req)
+	if !ok {
+		return
+	}
+
+	comment.Body = body
+	comment.Edited = time.Now().UTC()
+	err := UpdateComment(comment)
+	if err != nil {
+		logger.Error("failed to update comment sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update comment, try again later"))
+		return
+	}
+
+	writeComment(w, http.StatusOK, comment)
+}
+
+// deleteComment deletes a comment of the authenticated user or any comment on an image they own
+func (s *Server) deleteComment(w http.ResponseWriter, req *http.Request) {
+	claims, image, ok := s.commentableImage(w, req)
+	if !ok {
+		return
+	}
+
+	comment, ok := imageComment(w, req, image)
+	if !ok {
+		return
+	}
+	if comment.Uid != int32(claims.Uid) && image.Uid != int32(claims.Uid) {
+		logger.Error("user %v attempting to delete comment %v of user %v sending 403", claims.Uid, comment.Id, comment.Uid)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Forbidden, only the author or the owner of the image may delete a comment"))
+		return
+	}
+
+	err := DeleteComment(comment.Id)
+	if err != nil {
+		logger.Error("failed to delete comment sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete comment, try again later"))
+		return
+	}
+
+	logger.Info("User %v deleted comment %v on image %v", claims.Uid, comment.Id, image.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeComment responds with the comment and the username of its author
+func writeComment(w http.ResponseWriter, status int, comment Comment) {
+	author, err := GetUserById(comment.Uid)
+	if err != nil {
+		logger.Error("failed to retrieve comment author: %v", err)
+	}
+
+	js, err := json.Marshal(newCommentResp(comment, author))
+	if err != nil {
+		logger.Error("failed to marshal comment sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestValidateComment ensures comments are trimmed and limited to COMMENT_MAX characters
+func TestValidateComment(t *testing.T) {
+	if body, err := validateComment("  nice shot \n"); err != nil || body != "nice shot" {
+		t.Errorf("wrong comment: got %q %v", body, err)
+	}
+	if _, err := validateComment(" \t "); err == nil {
+		t.Errorf("empty comment accepted")
+	}
+	if _, err := validateComment(strings.Repeat("é", COMMENT_MAX)); err != nil {
+		t.Errorf("comment of %v characters refused: %v", COMMENT_MAX, err)
+	}
+	if _, err := validateComment(strings.Repeat("a", COMMENT_MAX+1)); err == nil {
+		t.Errorf("comment of %v characters accepted", COMMENT_MAX+1)
+	}
+
+	if page, err := parseCommentPage(url.Values{"page": {"2"}, "pageSize": {"5"}}); err != nil || page.Page != 2 || page.PageSize != 5 {
+		t.Errorf("wrong page: got %+v %v", page, err)
+	}
+	if _, err := parseCommentPage(url.Values{"pageSize": {"0"}}); err == nil {
+		t.Errorf("empty pages accepted")
+	}
+}
+
+// TestComments ensures viewers comment on images, authors edit their comments and owners moderate them
+func TestComments(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "commenter")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	private := uploadTestImage(t, router, token, false)
+	public := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), private)
+	defer DeleteImageData(context.Background(), public)
+	commentsPath := strings.TrimPrefix(public.Ref, REF_URL) + "/comments"
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	post := func(body string, token string) CommentResp {
+		rr := send("POST", commentsPath, fmt.Sprintf(`{"body": %q}`, body), token)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("failed to comment: got %v %s", rr.Code, rr.Body.String())
+		}
+		comment := CommentResp{}
+		json.Unmarshal(rr.Body.Bytes(), &comment)
+		return comment
+	}
+
+	if rr := send("POST", strings.TrimPrefix(private.Ref, REF_URL)+"/comments", `{"body": "hi"}`, friendToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for commenting on a private image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", commentsPath, `{"body": "  "}`, friendToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for empty comment: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	first := post("first", friendToken)
+	second := post("second", token)
+	third := post("third", friendToken)
+	if first.Uid != friend.Uid || first.Body != "first" || first.Edited != nil {
+		t.Errorf("wrong comment: got %+v", first)
+	}
+
+	rr := send("GET", commentsPath+"?pageSize=2", "", friendToken)
+	page := CommentsResp{}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if rr.Code != http.StatusOK || page.TotalResults != 3 || len(page.Comments) != 2 || page.Comments[0].Id != first.Id || page.Comments[1].Id != second.Id || len(page.Next) == 0 {
+		t.Fatalf("wrong comments: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// Only authors edit their comments
+	secondPath := fmt.Sprintf("%s/%v", commentsPath, second.Id)
+	if rr := send("PUT", secondPath, `{"body": "edited"}`, friendToken); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code for editing another user's comment: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	rr = send("PUT", fmt.Sprintf("%s/%v", commentsPath, first.Id), `{"body": "edited"}`, friendToken)
+	edited := CommentResp{}
+	json.Unmarshal(rr.Body.Bytes(), &edited)
+	if rr.Code != http.StatusOK || edited.Body != "edited" || edited.Edited == nil {
+		t.Errorf("failed to edit comment: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// Commenters delete their own comments and owners delete any comment on their images
+	if rr := send("DELETE", secondPath, "", friendToken); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code for deleting the owner's comment: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := send("DELETE", fmt.Sprintf("%s/%v", commentsPath, third.Id), "", token); rr.Code != http.StatusNoContent {
+		t.Errorf("owner failed to delete comment: got %v", rr.Code)
+	}
+	if rr := send("DELETE", fmt.Sprintf("%s/%v", commentsPath, first.Id), "", friendToken); rr.Code != http.StatusNoContent {
+		t.Errorf("author failed to delete comment: got %v", rr.Code)
+	}
+	if rr := send("DELETE", fmt.Sprintf("%s/%v", commentsPath, first.Id), "", friendToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for deleting comment twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = send("GET", commentsPath, "", token)
+	page = CommentsResp{}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if page.TotalResults != 1 || page.Comments[0].Id != second.Id {
+		t.Errorf("wrong comments after deletion: got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+/*
+	This file compares two images for designers tracking revisions of exported assets. Both images
+	are decoded and sampled onto a common grid no larger than COMPARE_MAX_DIMENSION, images of
+	different sizes are stretched to the smaller of each dimension. The structural similarity (SSIM)
+	of their luminance, the mean squared error and PSNR of their colors and the share of visibly
+	changed pixels are reported, or a rendering highlighting the changed pixels with diff=true.
+	Either image may be any image the requester can view, including public images without a watermark.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	COMPARE_MAX_DIMENSION = 1024 // Largest width or height images are compared at
+	COMPARE_THRESHOLD     = 16   // Difference of a color channel from 0 to 255 counting a pixel as changed
+	COMPARE_WINDOW        = 8    // Width and height of the windows structural similarity is averaged over
+
+	ssimC1 = (0.01 * 255) * (0.01 * 255) // Stabilize the luminance term for dark windows
+	ssimC2 = (0.03 * 255) * (0.03 * 255) // Stabilize the contrast term for flat windows
+)
+
+// CompareResp describes the differences between two images compared at Width by Height
+type CompareResp struct {
+	A                  int32    `json:"a"`
+	B                  int32    `json:"b"`
+	Identical          bool     `json:"identical"` // The files have the same content
+	SameSize           bool     `json:"sameSize"`
+	Width              int      `json:"width"`
+	Height             int      `json:"height"`
+	SSIM               float64  `json:"ssim"` // Structural similarity from -1 to 1, 1 when the pixels are equal
+	MSE                float64  `json:"mse"`
+	PSNR               *float64 `json:"psnr,omitempty"` // Peak signal to noise ratio in dB, omitted when the pixels are equal
+	ChangedPixels      int      `json:"changedPixels"`
+	ChangedRatio       float64  `json:"changedRatio"`
+	PerceptualDistance *int     `json:"perceptualDistance,omitempty"` // Bits the perceptual hashes differ by, when both are recorded
+}
+
+// compareSize returns the size two images of the sizes are compared at
+func compareSize(a image.Rectangle, b image.Rectangle) (int, int) {
+	width, height := a.Dx(), a.Dy()
+	if b.Dx() < width {
+		width = b.Dx()
+	}
+	if b.Dy() < height {
+		height = b.Dy()
+	}
+
+	// Scale the limiting dimension to the maximum preserving the aspect ratio
+	if width > COMPARE_MAX_DIMENSION || height > COMPARE_MAX_DIMENSION {
+		if width >= height {
+			width, height = COMPARE_MAX_DIMENSION, height*COMPARE_MAX_DIMENSION/width
+		} else {
+			width, height = width*COMPARE_MAX_DIMENSION/height, COMPARE_MAX_DIMENSION
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// sampleImage stretches the image to width by height, each destination pixel averages the block
+// of source pixels it covers
+func sampleImage(src image.Image, width int, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := bounds.Min.Y + (y+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := bounds.Min.X + (x+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.RGBAModel.Convert(src.At(sx, sy)).(color.RGBA)
+					r, g, b, a, n = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// luminance returns the luma of the pixel at offset i of the image from 0 to 255
+func luminance(img *image.RGBA, i int) float64 {
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
+
+// compareImages measures the differences between two images of the same bounds, filling the
+// metrics of resp, and returns the magnitude of the largest channel difference of each pixel
+func compareImages(a *image.RGBA, b *image.RGBA, resp *CompareResp) []uint8 {
+	bounds := a.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	resp.Width, resp.Height = width, height
+
+	changes := make([]uint8, width*height)
+	var squared float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := a.PixOffset(x, y)
+			largest := 0
+			for c := 0; c < 3; c++ {
+				d := int(a.Pix[i+c]) - int(b.Pix[i+c])
+				squared += float64(d * d)
+				if d < 0 {
+					d = -d
+				}
+				if d > largest {
+					largest = d
+				}
+			}
+			changes[y*width+x] = uint8(largest)
+			if largest >= COMPARE_THRESHOLD {
+				resp.ChangedPixels++
+			}
+		}
+	}
+	resp.ChangedRatio = float64(resp.ChangedPixels) / float64(width*height)
+	resp.MSE = squared / float64(3*width*height)
+	if resp.MSE > 0 {
+		psnr := 10 * math.Log10(255*255/resp.MSE)
+		resp.PSNR = &psnr
+	}
+
+	// Average the structural similarity of the luminance of each window, partial windows at the edges included
+	var total float64
+	windows := 0
+	for wy := 0; wy < height; wy += COMPARE_WINDOW {
+		for wx := 0; wx < width; wx += COMPARE_WINDOW {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+			for y := wy; y < wy+COMPARE_WINDOW && y < height; y++ {
+				for x := wx; x < wx+COMPARE_WINDOW && x < width; x++ {
+					i := a.PixOffset(x, y)
+					la, lb := luminance(a, i), luminance(b, i)
+					sumA, sumB, sumAA, sumBB, sumAB, n = sumA+la, sumB+lb, sumAA+la*la, sumBB+lb*lb, sumAB+la*lb, n+1
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) / ((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+			windows++
+		}
+	}
+	resp.SSIM = total / float64(windows)
+
+	return changes
+}
+
+// renderDiff draws the first image faded to gray with changed pixels highlighted in red
+// by the magnitude of their change
+func renderDiff(a *image.RGBA, changes []uint8) *image.RGBA {
+	bounds := a.Bounds()
+	dst := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			i := a.PixOffset(x, y)
+			gray := uint8(192 + luminance(a, i)/4)
+			change := changes[y*bounds.Dx()+x]
+			if change < COMPARE_THRESHOLD {
+				dst.SetRGBA(x, y, color.RGBA{gray, gray, gray, 255})
+				continue
+			}
+			fade := uint8(int(gray) * (255 - int(change)) / 255)
+			dst.SetRGBA(x, y, color.RGBA{255, fade, fade, 255})
+		}
+	}
+	return dst
+}
+
+// comparableImage retrieves the image with the id if the user may view it, either through the
+// authenticated image endpoints or publicly without a watermark that the comparison would bypass
+func (s *Server) comparableImage(ctx context.Context, uid int, id int32) (Image, bool, error) {
+	img, err := s.store.GetImageMeta(ctx, id)
+	if err != nil && strings.Contains(err.Error(), "404 - Not found") {
+		return Image{}, false, nil
+	}
+	if err != nil {
+		return Image{}, false, err
+	}
+
+	allowed, err := s.canViewImage(ctx, uid, img)
+	if err != nil || allowed {
+		return img, allowed, err
+	}
+	allowed, err = s.canInteract(ctx, uid, img.Uid)
+	if err != nil || !allowed {
+		return Image{}, false, err
+	}
+	shared, err := s.publiclyShared(ctx, img)
+	if err != nil || !shared {
+		return Image{}, false, err
+	}
+	policy, err := s.store.SharingPolicy(ctx)
+	if err != nil {
+		return Image{}, false, err
+	}
+	return img, !policy.Watermark, nil
+}
+
+// decodeStoredImage opens and decodes the stored file of the image
+func decodeStoredImage(ctx context.Context, img Image) (image.Image, error) {
+	file, err := storage.Open(ctx, imageKey(img))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %v: %v", img.Id, err)
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %v: %v", img.Id, err)
+	}
+	return src, nil
+}
+
+// compareImage compares the images with the ids a and b, responding with the metrics as json
+// or with a png rendering of the differences when diff is true
+func (s *Server) compareImage(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to compare images sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	params := req.URL.Query()
+	diff := params.Get("diff") == "true"
+	images := [2]Image{}
+	for i, param := range []string{"a", "b"} {
+		id, err := strconv.ParseInt(params.Get(param), 10, 32)
+		if err != nil || id < 1 {
+			logger.Error("invalid image id %s=%q sending 400", param, params.Get(param))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - Bad request, a and b must be the ids of the images to compare"))
+			return
+		}
+
+		img, allowed, err := s.comparableImage(req.Context(), claims.Uid, int32(id))
+		if err != nil {
+			logger.Error("failed to retrieve image %v sending 500: %v", id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to compare images, try again later"))
+			return
+		}
+
+		// Images the user may not view are reported as not found
+		if !allowed {
+			logger.Error("image %v not viewable by user %v sending 404", id, claims.Uid)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("404 - Not found, no image with id %v available", id)))
+			return
+		}
+		if !canDecode(img.Encoding) {
+			logger.Error("image %v of type %s cannot be decoded sending 400", img.Id, img.Encoding)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - Images of type %s cannot be compared", img.Encoding)))
+			return
+		}
+		images[i] = img
+	}
+
+	decoded := [2]image.Image{}
+	for i, img := range images {
+		decoded[i], err = decodeStoredImage(req.Context(), img)
+		if err != nil {
+			logger.Error("failed to read image sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to compare images, try again later"))
+			return
+		}
+	}
+
+	width, height := compareSize(decoded[0].Bounds(), decoded[1].Bounds())
+	a, b := sampleImage(decoded[0], width, height), sampleImage(decoded[1], width, height)
+	resp := CompareResp{
+		A:         images[0].Id,
+		B:         images[1].Id,
+		Identical: len(images[0].Hash) > 0 && images[0].Hash == images[1].Hash,
+		SameSize:  decoded[0].Bounds().Size() == decoded[1].Bounds().Size(),
+	}
+	changes := compareImages(a, b, &resp)
+
+	phashA, errA := strconv.ParseUint(images[0].PHash, 16, 64)
+	phashB, errB := strconv.ParseUint(images[1].PHash, 16, 64)
+	if errA == nil && errB == nil {
+		distance := bits.OnesCount64(phashA ^ phashB)
+		resp.PerceptualDistance = &distance
+	}
+
+	w.Header().Set("Cache-Control", "private")
+	if diff {
+		buf := new(bytes.Buffer)
+		err = png.Encode(buf, renderDiff(a, changes))
+		if err != nil {
+			logger.Error("failed to encode diff sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to compare images, try again later"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+		return
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal comparison sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to compare images, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCompareSize ensures images are compared at the smaller of each dimension within the maximum
+func TestCompareSize(t *testing.T) {
+	tt := []struct {
+		A, B          image.Rectangle
+		Width, Height int
+	}{
+		{image.Rect(0, 0, 100, 50), image.Rect(0, 0, 100, 50), 100, 50},
+		{image.Rect(0, 0, 100, 50), image.Rect(0, 0, 80, 60), 80, 50},
+		{image.Rect(0, 0, 4000, 3000), image.Rect(0, 0, 4000, 3000), COMPARE_MAX_DIMENSION, 768},
+		{image.Rect(0, 0, 10, 5000), image.Rect(0, 0, 10, 5000), 2, COMPARE_MAX_DIMENSION},
+	}
+
+	for _, tc := range tt {
+		if width, height := compareSize(tc.A, tc.B); width != tc.Width || height != tc.Height {
+			t.Errorf("wrong size for %v and %v: got %vx%v want %vx%v", tc.A, tc.B, width, height, tc.Width, tc.Height)
+		}
+	}
+}
+
+// TestCompareImages ensures equal images are reported as such and changed pixels are counted and rendered
+func TestCompareImages(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			a.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+
+	resp := CompareResp{}
+	changes := compareImages(a, sampleImage(a, 16, 16), &resp)
+	if resp.SSIM != 1 || resp.MSE != 0 || resp.PSNR != nil || resp.ChangedPixels != 0 {
+		t.Errorf("equal images reported as different: got %+v", resp)
+	}
+
+	b := sampleImage(a, 16, 16)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			b.SetRGBA(x, y, color.RGBA{255, 255, 255, 255})
+		}
+	}
+	resp = CompareResp{}
+	changes = compareImages(a, b, &resp)
+	if resp.ChangedPixels != 32 || resp.ChangedRatio != 0.125 {
+		t.Errorf("wrong changed pixels: got %v (%v) want 32 (0.125)", resp.ChangedPixels, resp.ChangedRatio)
+	}
+	if resp.SSIM >= 1 || resp.SSIM <= 0 || resp.PSNR == nil || math.IsInf(*resp.PSNR, 0) {
+		t.Errorf("wrong similarity of changed images: got %+v", resp)
+	}
+
+	rendered := renderDiff(a, changes)
+	if c := rendered.RGBAAt(0, 0); c.R != 255 || c.G == 255 {
+		t.Errorf("changed pixel not highlighted: got %v", c)
+	}
+	if c := rendered.RGBAAt(15, 15); c.R != c.G || c.G != c.B {
+		t.Errorf("unchanged pixel not gray: got %v", c)
+	}
+}
+
+// TestCompareImage ensures viewable images are compared and images of other users are not found
+func TestCompareImage(t *testing.T) {
+	token, _ := getTestToken(t)
+	other := createTestUser(t, "other")
+	otherToken, _, err := generateJWT(int(other.Uid), other.Email)
+	if err != nil {
+		t.Fatalf("failed to generate other user jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	first := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), first)
+	second := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), second)
+
+	send := func(query string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/image/compare?"+query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(fmt.Sprintf("a=%v&b=%v", first.Id, second.Id), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to compare images: got %v %s", rr.Code, rr.Body.String())
+	}
+	resp := CompareResp{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !resp.Identical || !resp.SameSize || resp.SSIM != 1 || resp.Width != 16 {
+		t.Errorf("wrong comparison of identical uploads: got %+v", resp)
+	}
+
+	rr = send(fmt.Sprintf("a=%v&b=%v&diff=true", first.Id, second.Id), token)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("wrong diff rendering: got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	if rr := send(fmt.Sprintf("a=%v", first.Id), token); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing image accepted: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send(fmt.Sprintf("a=%v&b=%v", first.Id, second.Id), otherToken); rr.Code != http.StatusNotFound {
+		t.Errorf("private image compared by another user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+/*
+	This file compresses responses for clients that accept it. Metadata, query pages and other text
+	responses are compressed with gzip or deflate, whichever the Accept-Encoding header of the request
+	prefers, once they reach COMPRESSION_MIN_BYTES. Images, archives and other binary types are
+	already compressed and are sent as they are, as are range requests and responses encoded by their
+	handler. Compressed responses vary by Accept-Encoding and their entity tags are weakened since the
+	bytes sent no longer match the tag of the handler.
+*/
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	COMPRESSION_MIN_BYTES = 1024 // Default if COMPRESSION_MIN_BYTES env variable is not defined
+
+	ENCODING_GZIP    = "gzip"
+	ENCODING_DEFLATE = "deflate"
+)
+
+// COMPRESSIBLE_TYPES are the media types compressed, types ending in / match any subtype
+var COMPRESSIBLE_TYPES = []string{"application/json", "application/problem+json", "application/javascript", "application/xml", "application/yaml", "text/"}
+
+// compressionEnabled reports whether responses are compressed, disabled by setting COMPRESSION to false
+func compressionEnabled() bool {
+	return os.Getenv("COMPRESSION") != "false"
+}
+
+// getCompressionMinBytes returns the size from which responses are compressed
+func getCompressionMinBytes() int {
+	size, err := strconv.Atoi(os.Getenv("COMPRESSION_MIN_BYTES"))
+	if err != nil || size < 0 {
+		size = COMPRESSION_MIN_BYTES
+	}
+	return size
+}
+
+// compressibleType reports whether responses of the Content-Type benefit from compression,
+// event streams are excluded so each event reaches the client as soon as it is written
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, compressible := range COMPRESSIBLE_TYPES {
+		if mediaType == compressible || strings.HasSuffix(compressible, "/") && strings.HasPrefix(mediaType, compressible) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the encoding of the Accept-Encoding header with the highest weight,
+// gzip when both are preferred equally, or empty when the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	weights := map[string]float64{}
+	wildcard := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		weight := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					q = 0
+				}
+				weight = q
+			}
+		}
+		if coding == "*" {
+			wildcard = weight
+			continue
+		}
+		weights[coding] = weight
+	}
+
+	// The wildcard covers the encodings not named explicitly, ties prefer gzip
+	best, bestWeight := "", 0.0
+	for _, encoding := range []string{ENCODING_GZIP, ENCODING_DEFLATE} {
+		weight, named := weights[encoding]
+		if !named {
+			weight = wildcard
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
+
+// encoderPools reuse the compressors of finished responses
+var encoderPools = map[string]*sync.Pool{
+	ENCODING_GZIP:    {New: func() interface{} { return gzip.NewWriter(nil) }},
+	ENCODING_DEFLATE: {New: func() interface{} { return zlib.NewWriter(nil) }},
+}
+
+// encoder is implemented by gzip.Writer and zlib.Writer, deflate responses use the zlib format of RFC 1950
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressResponses is router middleware compressing the responses of clients accepting gzip or deflate
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !compressionEnabled() || req.Method == "HEAD" || req.Method == "OPTIONS" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(req.Header.Get("Accept-Encoding")), minBytes: getCompressionMinBytes()}
+		defer cw.Close()
+		next.ServeHTTP(cw, req)
+	})
+}
+
+// compressWriter holds the start of the response until it is known whether it is compressed.
+// Responses are compressed once they reach minBytes, smaller responses are sent as they are
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // Encoding accepted by the client, empty when none
+	minBytes int
+	status   int    // Status written by the handler, 0 until written
+	buf      []byte // Start of the body held until the response is started
+	started  bool
+	encoder  encoder // nil unless the response is compressed
+}
+
+// WriteHeader holds the status until the response is started, informational responses are sent immediately
+func (c *compressWriter) WriteHeader(code int) {
+	if code < http.StatusOK {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if c.started || c.status != 0 {
+		return
+	}
+	c.status = code
+	// Responses without a body are started right away
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		c.start(false)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.started {
+		if !c.compressible() {
+			c.start(false)
+		} else {
+			c.buf = append(c.buf, b...)
+			if len(c.buf) >= c.minBytes {
+				c.start(true)
+			}
+			return len(b), nil
+		}
+	}
+	if c.encoder != nil {
+		return c.encoder.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush starts the response so streaming handlers reach the client, compressing it if it may be
+func (c *compressWriter) Flush() {
+	if !c.started && c.status != 0 {
+		c.start(c.compressible())
+	}
+	if c.encoder != nil {
+		c.encoder.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close sends responses smaller than minBytes as they are and finishes compressed responses
+func (c *compressWriter) Close() {
+	if !c.started && c.status != 0 {
+		c.start(false)
+	}
+	if c.encoder != nil {
+		c.encoder.Close()
+		c.encoder.Reset(nil)
+		encoderPools[c.encoding].Put(c.encoder)
+		c.encoder = nil
+	}
+}
+
+// compressible reports whether the response held so far may be compressed
+func (c *compressWriter) compressible() bool {
+	header := c.Header()
+	return len(c.encoding) > 0 && c.status != http.StatusPartialContent && len(header.Get("Content-Encoding")) == 0 &&
+		compressibleType(header.Get("Content-Type"))
+}
+
+// start writes the status and the body held so far, compressed if requested
+func (c *compressWriter) start(compress bool) {
+	c.started = true
+	header := c.Header()
+	// Responses other clients may receive compressed vary by the encodings accepted
+	if compressibleType(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if compress {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		c.encoder = encoderPools[c.encoding].Get().(encoder)
+		c.encoder.Reset(c.ResponseWriter)
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return
+	}
+	if c.encoder != nil {
+		c.encoder.Write(c.buf)
+	} else {
+		c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+}
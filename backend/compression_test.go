@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestNegotiateEncoding ensures the accepted encoding with the highest weight is chosen
+func TestNegotiateEncoding(t *testing.T) {
+	tt := []struct {
+		AcceptEncoding string
+		Expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", ENCODING_GZIP},
+		{"deflate", ENCODING_DEFLATE},
+		{"gzip, deflate, br", ENCODING_GZIP},
+		{"deflate, gzip", ENCODING_GZIP},
+		{"gzip;q=0.5, deflate", ENCODING_DEFLATE},
+		{"GZIP ; q=0.8", ENCODING_GZIP},
+		{"gzip;q=0", ""},
+		{"*", ENCODING_GZIP},
+		{"*;q=0.5, gzip;q=0", ENCODING_DEFLATE},
+		{"gzip;q=bad, deflate;q=0.1", ENCODING_DEFLATE},
+	}
+
+	for _, tc := range tt {
+		if encoding := negotiateEncoding(tc.AcceptEncoding); encoding != tc.Expected {
+			t.Errorf("wrong encoding for %q: got %q want %q", tc.AcceptEncoding, encoding, tc.Expected)
+		}
+	}
+}
+
+// TestCompressResponses ensures large text responses are compressed for clients accepting it
+// while images, small responses, ranges and HEAD requests are sent as they are
+func TestCompressResponses(t *testing.T) {
+	js := `{"imageMeta":[` + strings.Repeat(`{"title":"image","encoding":"image/png"},`, 100) + `{}]}`
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"meta"`)
+			w.Header().Set("Content-Length", "1")
+			w.Write([]byte(js[:100]))
+			w.Write([]byte(js[100:]))
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(js))
+		case "/range":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(js))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	send := func(method string, path string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		ENCODING_GZIP:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		ENCODING_DEFLATE: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	for encoding, decode := range decoders {
+		rr := send("GET", "/json", encoding)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != encoding || rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("response not compressed with %s: got %v %v", encoding, rr.Code, rr.Header())
+		}
+		if len(rr.Header().Get("Content-Length")) > 0 || rr.Header().Get("ETag") != `W/"meta"` {
+			t.Errorf("wrong headers of compressed response: got %v", rr.Header())
+		}
+		if rr.Body.Len() >= len(js) {
+			t.Errorf("%s response not smaller: got %v bytes of %v", encoding, rr.Body.Len(), len(js))
+		}
+		reader, err := decode(rr.Body)
+		if err != nil {
+			t.Fatalf("failed to decode %s response: %v", encoding, err)
+		}
+		body, _ := ioutil.ReadAll(reader)
+		if string(body) != js {
+			t.Errorf("wrong %s body: got %q", encoding, body)
+		}
+	}
+
+	tt := []struct {
+		Method         string
+		Path           string
+		AcceptEncoding string
+		Code           int
+		Vary           string
+	}{
+		{"GET", "/json", "", http.StatusOK, "Accept-Encoding"},
+		{"GET", "/json", "br", http.StatusOK, "Accept-Encoding"},
+		{"GET", "/small", "gzip", http.StatusOK, "Accept-Encoding"},
+		{"GET", "/image", "gzip", http.StatusOK, ""},
+		{"GET", "/range", "gzip", http.StatusPartialContent, "Accept-Encoding"},
+		{"GET", "/empty", "gzip", http.StatusNoContent, ""},
+		{"HEAD", "/json", "gzip", http.StatusOK, ""},
+	}
+	for _, tc := range tt {
+		rr := send(tc.Method, tc.Path, tc.AcceptEncoding)
+		if rr.Code != tc.Code || len(rr.Header().Get("Content-Encoding")) > 0 || rr.Header().Get("Vary") != tc.Vary {
+			t.Errorf("wrong %s %s response with %q: got %v %v", tc.Method, tc.Path, tc.AcceptEncoding, rr.Code, rr.Header())
+		}
+	}
+	if rr := send("GET", "/json", ""); rr.Body.String() != js || rr.Header().Get("ETag") != `"meta"` {
+		t.Errorf("wrong uncompressed body: got %q %v", rr.Body.String(), rr.Header())
+	}
+	if rr := send("GET", "/small", "gzip"); rr.Body.String() != `{}` {
+		t.Errorf("wrong small body: got %q", rr.Body.String())
+	}
+
+	os.Setenv("COMPRESSION", "false")
+	defer os.Unsetenv("COMPRESSION")
+	if rr := send("GET", "/json", "gzip"); len(rr.Header().Get("Content-Encoding")) > 0 || rr.Body.String() != js {
+		t.Errorf("response compressed while disabled: got %v", rr.Header())
+	}
+}
+
+// TestCompressFlush ensures flushing a streamed response sends what was written so far
+func TestCompressFlush(t *testing.T) {
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		w.Write([]byte("{\"id\":1}\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("{\"id\":2}\n"))
+	}))
+
+	req := httptest.NewRequest("GET", "/export", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !rr.Flushed || rr.Body.String() != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("wrong streamed response: got flushed %v %q", rr.Flushed, rr.Body.String())
+	}
+
+	text := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(" second"))
+	}))
+	rr = httptest.NewRecorder()
+	text.ServeHTTP(rr, req)
+	reader, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil || !rr.Flushed || rr.Header().Get("Content-Encoding") != ENCODING_GZIP {
+		t.Fatalf("streamed text not compressed: got %v %v", rr.Header(), err)
+	}
+	body, _ := ioutil.ReadAll(reader)
+	if string(body) != "first second" {
+		t.Errorf("wrong streamed text: got %q", body)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+/*
+	This file loads the server configuration once at startup. Settings start from the defaults for
+	non-production deployments, are replaced by the YAML or JSON file named by CONFIG_FILE and finally
+	by environment variables, so deployments may keep a shared file and override single values. The
+	result is validated as a whole and every problem is reported together, naming both the file key
+	and the environment variable of each setting.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the server and store layers
+type Config struct {
+	Database  DatabaseConfig  `yaml:"database"`
+	Listen    ListenConfig    `yaml:"listen"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Analytics AnalyticsConfig `yaml:"analytics"`
+	Cors      CorsConfig      `yaml:"cors"`
+	Cookie    CookieConfig    `yaml:"cookie"`
+	Password  PasswordConfig  `yaml:"password"`
+	State     StateConfig     `yaml:"state"`
+	Web       WebConfig       `yaml:"web"`
+}
+
+// DatabaseConfig describes the primary database, its optional read replica and the connection pool limits
+// NOTE: PRODUCTION DEPLOYMENTS MUST USE SECURED PASSWORDS
+type DatabaseConfig struct {
+	Name         string `yaml:"name"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	Host         string `yaml:"host"`
+	Port         string `yaml:"port"`
+	ReplicaHost  string `yaml:"replicaHost"` // Empty reads every query from the primary
+	ReplicaPort  string `yaml:"replicaPort"` // Empty uses the port of the primary
+	Schema       string `yaml:"schema"`      // Empty uses the search path of the database user
+	MaxOpen      int    `yaml:"maxOpen"`
+	MaxIdle      int    `yaml:"maxIdle"`
+	ConnLifetime int    `yaml:"connLifetime"` // Minutes
+}
+
+// StorageConfig names the driver storing image files and the optional driver mirroring them
+type StorageConfig struct {
+	Driver string `yaml:"driver"`
+	Mirror string `yaml:"mirror"` // Empty disables mirrored writes
+}
+
+// defaultConfig returns the configuration used when neither a file nor the environment set a value
+func defaultConfig() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Name:         DB_NAME,
+			User:         DB_USER,
+			Password:     DB_PASS,
+			Host:         DB_HOST,
+			Port:         DB_PORT,
+			MaxOpen:      DB_MAX_OPEN,
+			MaxIdle:      DB_MAX_IDLE,
+			ConnLifetime: DB_CONN_LIFETIME,
+		},
+		Listen: ListenConfig{
+			Addr:              PORT,
+			CacheDir:          AUTOCERT_CACHE,
+			ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+			ReadTimeout:       READ_TIMEOUT,
+			WriteTimeout:      WRITE_TIMEOUT,
+			IdleTimeout:       IDLE_TIMEOUT,
+			MaxHeaderBytes:    MAX_HEADER_BYTES,
+			HTTP2:             true,
+		},
+		Storage: StorageConfig{Driver: STORAGE_DRIVER},
+		Analytics: AnalyticsConfig{
+			Sink:    ANALYTICS_NONE,
+			File:    ANALYTICS_FILE_PATH,
+			UserIds: ANALYTICS_ANONYMIZED,
+		},
+		Cors: CorsConfig{
+			Origins: []string{CORS_ANY_ORIGIN},
+			MaxAge:  CORS_MAX_AGE,
+		},
+		Cookie: CookieConfig{
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: SAME_SITE_LAX,
+			Path:     "/",
+		},
+		Password: PasswordConfig{
+			MinLength:   PASSWORD_MIN_LENGTH,
+			MinEntropy:  PASSWORD_MIN_ENTROPY,
+			BreachedURL: PASSWORD_BREACHED_URL,
+		},
+		State: StateConfig{Driver: STATE_MEMORY},
+	}
+}
+
+// LoadConfig returns the validated configuration read from the file at path, when not empty,
+// with the environment variables applied on top
+func LoadConfig(path string) (Config, error) {
+	config := defaultConfig()
+
+	if len(path) > 0 {
+		err := readConfigFile(path, &config)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	var problems []string
+	problems = append(problems, config.applyEnv()...)
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return config, fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return config, nil
+}
+
+// readConfigFile decodes a YAML or JSON file over the configuration, unknown keys are refused
+// so misspelled settings aren't silently ignored
+func readConfigFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv replaces settings with the environment variables that are defined
+// returns a problem for each variable that can't be parsed
+func (c *Config) applyEnv() []string {
+	envString("DB_NAME", &c.Database.Name)
+	envString("DB_USER", &c.Database.User)
+	envString("DB_PASS", &c.Database.Password)
+	envString("DB_HOST", &c.Database.Host)
+	envString("DB_PORT", &c.Database.Port)
+	envString("DB_REPLICA_HOST", &c.Database.ReplicaHost)
+	envString("DB_REPLICA_PORT", &c.Database.ReplicaPort)
+	envString("DB_SCHEMA", &c.Database.Schema)
+
+	var problems []string
+	for _, setting := range []struct {
+		Name  string
+		Value *int
+	}{
+		{"DB_MAX_OPEN", &c.Database.MaxOpen},
+		{"DB_MAX_IDLE", &c.Database.MaxIdle},
+		{"DB_CONN_LIFETIME", &c.Database.ConnLifetime},
+		{"CORS_MAX_AGE", &c.Cors.MaxAge},
+		{"PASSWORD_MIN_LENGTH", &c.Password.MinLength},
+		{"PASSWORD_MIN_ENTROPY", &c.Password.MinEntropy},
+		{"LISTEN_READ_HEADER_TIMEOUT", &c.Listen.ReadHeaderTimeout},
+		{"LISTEN_READ_TIMEOUT", &c.Listen.ReadTimeout},
+		{"LISTEN_WRITE_TIMEOUT", &c.Listen.WriteTimeout},
+		{"LISTEN_IDLE_TIMEOUT", &c.Listen.IdleTimeout},
+		{"LISTEN_MAX_HEADER_BYTES", &c.Listen.MaxHeaderBytes},
+	} {
+		err := envInt(setting.Name, setting.Value)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// LISTEN_ADDR takes precedence over the older GO_PORT
+	envString("GO_PORT", &c.Listen.Addr)
+	envString("LISTEN_ADDR", &c.Listen.Addr)
+	envString("TLS_CERT_FILE", &c.Listen.CertFile)
+	envString("TLS_KEY_FILE", &c.Listen.KeyFile)
+	envString("AUTOCERT_CACHE", &c.Listen.CacheDir)
+	envString("AUTOCERT_EMAIL", &c.Listen.Email)
+	envString("HTTP_REDIRECT_ADDR", &c.Listen.RedirectAddr)
+	if domains := os.Getenv("AUTOCERT_DOMAINS"); len(domains) > 0 {
+		c.Listen.Domains = nil
+		for _, domain := range strings.Split(domains, ",") {
+			if domain = strings.TrimSpace(domain); len(domain) > 0 {
+				c.Listen.Domains = append(c.Listen.Domains, domain)
+			}
+		}
+	}
+
+	envString("STORAGE_DRIVER", &c.Storage.Driver)
+	envString("STORAGE_MIRROR", &c.Storage.Mirror)
+
+	envString("ANALYTICS_SINK", &c.Analytics.Sink)
+	envString("ANALYTICS_FILE", &c.Analytics.File)
+	envString("ANALYTICS_URL", &c.Analytics.URL)
+	envString("ANALYTICS_USER_IDS", &c.Analytics.UserIds)
+	envString("ANALYTICS_SALT", &c.Analytics.Salt)
+
+	if origins := os.Getenv("CORS_ORIGINS"); len(origins) > 0 {
+		c.Cors.Origins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); len(origin) > 0 {
+				c.Cors.Origins = append(c.Cors.Origins, origin)
+			}
+		}
+	}
+	envString("COOKIE_SAME_SITE", &c.Cookie.SameSite)
+	envString("COOKIE_PATH", &c.Cookie.Path)
+	envString("COOKIE_DOMAIN", &c.Cookie.Domain)
+	envString("PASSWORD_DENYLIST", &c.Password.Denylist)
+	envString("PASSWORD_BREACHED_URL", &c.Password.BreachedURL)
+	envString("STATE_DRIVER", &c.State.Driver)
+	envString("REDIS_URL", &c.State.RedisURL)
+	envString("WEB_DIR", &c.Web.Dir)
+
+	for _, setting := range []struct {
+		Name  string
+		Value *bool
+	}{
+		{"CORS_CREDENTIALS", &c.Cors.Credentials},
+		{"COOKIE_SECURE", &c.Cookie.Secure},
+		{"COOKIE_HTTP_ONLY", &c.Cookie.HttpOnly},
+		{"PASSWORD_CHECK_BREACHED", &c.Password.Breached},
+		{"HTTP2", &c.Listen.HTTP2},
+	} {
+		err := envFlag(setting.Name, setting.Value)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	return problems
+}
+
+// envString replaces value with the environment variable when it is defined
+func envString(name string, value *string) {
+	if raw := os.Getenv(name); len(raw) > 0 {
+		*value = raw
+	}
+}
+
+// envInt replaces value with the environment variable when it is defined
+func envInt(name string, value *int) error {
+	raw := os.Getenv(name)
+	if len(raw) == 0 {
+		return nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", name, raw)
+	}
+	*value = parsed
+	return nil
+}
+
+// envFlag replaces value with the boolean environment variable when it is defined
+func envFlag(name string, value *bool) error {
+	raw := os.Getenv(name)
+	if len(raw) == 0 {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false, got %q", name, raw)
+	}
+	*value = parsed
+	return nil
+}
+
+// validate returns a problem for each invalid or conflicting setting
+func (c Config) validate() []string {
+	var problems []string
+	db := c.Database
+
+	for _, setting := range []struct{ Name, Value string }{
+		{"database.name (DB_NAME)", db.Name},
+		{"database.user (DB_USER)", db.User},
+		{"database.host (DB_HOST)", db.Host},
+		{"listen.addr (LISTEN_ADDR)", c.Listen.Addr},
+	} {
+		if len(setting.Value) == 0 {
+			problems = append(problems, fmt.Sprintf("%s is required", setting.Name))
+		}
+	}
+
+	if !validPort(db.Port) {
+		problems = append(problems, fmt.Sprintf("database.port (DB_PORT) must be a port from 1 to 65535, got %q", db.Port))
+	}
+	if len(db.ReplicaPort) > 0 && !validPort(db.ReplicaPort) {
+		problems = append(problems, fmt.Sprintf("database.replicaPort (DB_REPLICA_PORT) must be a port from 1 to 65535, got %q", db.ReplicaPort))
+	}
+	if db.MaxOpen <= 0 {
+		problems = append(problems, fmt.Sprintf("database.maxOpen (DB_MAX_OPEN) must be positive, got %v", db.MaxOpen))
+	}
+	if db.MaxIdle < 0 || db.MaxIdle > db.MaxOpen {
+		problems = append(problems, fmt.Sprintf("database.maxIdle (DB_MAX_IDLE) must be from 0 to maxOpen (%v), got %v", db.MaxOpen, db.MaxIdle))
+	}
+	if db.ConnLifetime <= 0 {
+		problems = append(problems, fmt.Sprintf("database.connLifetime (DB_CONN_LIFETIME) must be a positive number of minutes, got %v", db.ConnLifetime))
+	}
+
+	listen := c.Listen
+	if (len(listen.CertFile) > 0) != (len(listen.KeyFile) > 0) {
+		problems = append(problems, "listen.certFile (TLS_CERT_FILE) and listen.keyFile (TLS_KEY_FILE) must be set together")
+	}
+	if len(listen.CertFile) > 0 && len(listen.Domains) > 0 {
+		problems = append(problems, "listen.certFile (TLS_CERT_FILE) and listen.domains (AUTOCERT_DOMAINS) cannot both be set")
+	}
+	if len(listen.RedirectAddr) > 0 && !listen.TLS() {
+		problems = append(problems, "listen.redirectAddr (HTTP_REDIRECT_ADDR) requires TLS to be configured")
+	}
+	problems = append(problems, listen.validate()...)
+
+	switch c.Storage.Driver {
+	case "local", "s3":
+	default:
+		problems = append(problems, fmt.Sprintf("storage.driver (STORAGE_DRIVER) must be local or s3, got %q", c.Storage.Driver))
+	}
+	switch c.Storage.Mirror {
+	case "":
+	case c.Storage.Driver:
+		problems = append(problems, "storage.mirror (STORAGE_MIRROR) must differ from storage.driver (STORAGE_DRIVER)")
+	case "local", "s3":
+	default:
+		problems = append(problems, fmt.Sprintf("storage.mirror (STORAGE_MIRROR) must be local, s3 or empty, got %q", c.Storage.Mirror))
+	}
+
+	analytics := c.Analytics
+	switch analytics.Sink {
+	case ANALYTICS_NONE, ANALYTICS_OUTBOX:
+	case ANALYTICS_FILE:
+		if len(analytics.File) == 0 {
+			problems = append(problems, "analytics.file (ANALYTICS_FILE) is required by the file sink")
+		}
+	case ANALYTICS_HTTP:
+		if u, err := url.Parse(analytics.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("analytics.url (ANALYTICS_URL) must be an http or https url for the http sink, got %q", analytics.URL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("analytics.sink (ANALYTICS_SINK) must be none, file, http or outbox, got %q", analytics.Sink))
+	}
+	if analytics.UserIds != ANALYTICS_ANONYMIZED && analytics.UserIds != ANALYTICS_RAW {
+		problems = append(problems, fmt.Sprintf("analytics.userIds (ANALYTICS_USER_IDS) must be anonymized or raw, got %q", analytics.UserIds))
+	}
+
+	problems = append(problems, c.Cors.validate()...)
+	problems = append(problems, c.Cookie.validate()...)
+	problems = append(problems, c.Password.validate()...)
+	problems = append(problems, c.State.validate()...)
+	problems = append(problems, c.Web.validate()...)
+
+	return problems
+}
+
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// replica returns the configuration of the read replica, the primary with the replica host and port
+func (c DatabaseConfig) replica() DatabaseConfig {
+	c.Host = c.ReplicaHost
+	if len(c.ReplicaPort) > 0 {
+		c.Port = c.ReplicaPort
+	}
+	return c
+}
+
+// lifetime returns the duration before a connection is recycled
+func (c DatabaseConfig) lifetime() time.Duration {
+	return time.Duration(c.ConnLifetime) * time.Minute
+}
+
+// dbConfig is the database configuration used by the store, the defaults until configureDB is called
+var (
+	dbConfig     = defaultConfig().Database
+	dbConfigLock sync.RWMutex
+)
+
+// configureDB sets the database configuration of the store, it must be called before the first query
+func configureDB(config DatabaseConfig) {
+	dbConfigLock.Lock()
+	defer dbConfigLock.Unlock()
+	dbConfig = config
+}
+
+// databaseConfig returns the database configuration of the store
+func databaseConfig() DatabaseConfig {
+	dbConfigLock.RLock()
+	defer dbConfigLock.RUnlock()
+	return dbConfig
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configEnv lists the environment variables read by LoadConfig
+var configEnv = []string{
+	"DB_NAME", "DB_USER", "DB_PASS", "DB_HOST", "DB_PORT", "DB_REPLICA_HOST", "DB_REPLICA_PORT", "DB_SCHEMA",
+	"DB_MAX_OPEN", "DB_MAX_IDLE", "DB_CONN_LIFETIME", "GO_PORT", "LISTEN_ADDR", "TLS_CERT_FILE",
+	"TLS_KEY_FILE", "AUTOCERT_DOMAINS", "AUTOCERT_CACHE", "AUTOCERT_EMAIL", "HTTP_REDIRECT_ADDR", "STORAGE_DRIVER", "STORAGE_MIRROR",
+	"ANALYTICS_SINK", "ANALYTICS_FILE", "ANALYTICS_URL", "ANALYTICS_USER_IDS", "ANALYTICS_SALT",
+	"CORS_ORIGINS", "CORS_CREDENTIALS", "CORS_MAX_AGE", "COOKIE_SECURE", "COOKIE_HTTP_ONLY", "COOKIE_SAME_SITE", "COOKIE_PATH", "COOKIE_DOMAIN",
+	"STATE_DRIVER", "REDIS_URL", "LISTEN_READ_HEADER_TIMEOUT", "LISTEN_READ_TIMEOUT", "LISTEN_WRITE_TIMEOUT", "LISTEN_IDLE_TIMEOUT",
+	"LISTEN_MAX_HEADER_BYTES", "HTTP2", "WEB_DIR",
+}
+
+// setConfigEnv replaces the configuration environment with env and returns a function restoring it
+func setConfigEnv(env map[string]string) func() {
+	saved := map[string]string{}
+	for _, name := range configEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = value
+		}
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for _, name := range configEnv {
+			os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// TestLoadConfig ensures the file is applied over the defaults and the environment over the file
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(yamlPath, []byte("database:\n  host: db.internal\n  maxOpen: 40\nlisten:\n  addr: \":9000\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	jsonPath := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(jsonPath, []byte(`{"database": {"port": "6432"}, "storage": {"driver": "s3"}}`), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	restore := setConfigEnv(nil)
+	defer restore()
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("failed to load default config: %v", err)
+	}
+	if config.Database.Host != DB_HOST || config.Database.MaxOpen != DB_MAX_OPEN || config.Listen.Addr != PORT || config.Storage.Driver != STORAGE_DRIVER {
+		t.Errorf("wrong default config: got %+v", config)
+	}
+
+	config, err = LoadConfig(yamlPath)
+	if err != nil {
+		t.Fatalf("failed to load yaml config: %v", err)
+	}
+	if config.Database.Host != "db.internal" || config.Database.MaxOpen != 40 || config.Database.Name != DB_NAME || config.Listen.Addr != ":9000" {
+		t.Errorf("wrong yaml config: got %+v", config)
+	}
+
+	config, err = LoadConfig(jsonPath)
+	if err != nil {
+		t.Fatalf("failed to load json config: %v", err)
+	}
+	if config.Database.Port != "6432" || config.Storage.Driver != "s3" {
+		t.Errorf("wrong json config: got %+v", config)
+	}
+
+	os.Setenv("DB_HOST", "db.override")
+	os.Setenv("GO_PORT", ":9100")
+	os.Setenv("LISTEN_ADDR", "127.0.0.1:9101")
+	config, err = LoadConfig(yamlPath)
+	if err != nil {
+		t.Fatalf("failed to load config with environment: %v", err)
+	}
+	if config.Database.Host != "db.override" || config.Database.MaxOpen != 40 || config.Listen.Addr != "127.0.0.1:9101" {
+		t.Errorf("environment not applied over file: got %+v", config)
+	}
+
+	// Misspelled keys and missing files are reported
+	err = ioutil.WriteFile(yamlPath, []byte("database:\n  hots: db.internal\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	if _, err = LoadConfig(yamlPath); err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Errorf("expected unknown key error: got %v", err)
+	}
+	if _, err = LoadConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("expected error for missing config file")
+	}
+}
+
+// TestConfigValidation ensures invalid and conflicting settings are refused naming each problem
+func TestConfigValidation(t *testing.T) {
+	tt := []struct {
+		Env      map[string]string
+		Problems []string
+	}{
+		{map[string]string{"DB_MAX_OPEN": "8", "DB_MAX_IDLE": "8", "DB_CONN_LIFETIME": "5"}, nil},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "HTTP_REDIRECT_ADDR": ":80"}, nil},
+		{map[string]string{"AUTOCERT_DOMAINS": "picto.example.com, cdn.example.com", "LISTEN_ADDR": ":443"}, nil},
+		{map[string]string{"DB_MAX_OPEN": "-1", "DB_MAX_IDLE": "many", "DB_CONN_LIFETIME": "0"}, []string{"DB_MAX_OPEN", "DB_MAX_IDLE", "DB_CONN_LIFETIME"}},
+		{map[string]string{"DB_MAX_OPEN": "8", "DB_MAX_IDLE": "12"}, []string{"DB_MAX_IDLE"}},
+		{map[string]string{"DB_PORT": "postgres", "DB_REPLICA_PORT": "70000"}, []string{"DB_PORT", "DB_REPLICA_PORT"}},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem"}, []string{"TLS_KEY_FILE"}},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "AUTOCERT_DOMAINS": "picto.example.com"}, []string{"AUTOCERT_DOMAINS"}},
+		{map[string]string{"HTTP_REDIRECT_ADDR": ":80"}, []string{"HTTP_REDIRECT_ADDR"}},
+		{map[string]string{"LISTEN_READ_TIMEOUT": "0", "LISTEN_WRITE_TIMEOUT": "60", "LISTEN_MAX_HEADER_BYTES": "8192", "HTTP2": "false"}, nil},
+		{map[string]string{"LISTEN_READ_HEADER_TIMEOUT": "0", "LISTEN_IDLE_TIMEOUT": "-5", "LISTEN_MAX_HEADER_BYTES": "512"}, []string{"LISTEN_READ_HEADER_TIMEOUT", "LISTEN_IDLE_TIMEOUT", "LISTEN_MAX_HEADER_BYTES"}},
+		{map[string]string{"LISTEN_READ_HEADER_TIMEOUT": "30", "LISTEN_READ_TIMEOUT": "10", "LISTEN_WRITE_TIMEOUT": "-1", "HTTP2": "maybe"}, []string{"LISTEN_READ_TIMEOUT", "LISTEN_WRITE_TIMEOUT", "HTTP2"}},
+		{map[string]string{"STORAGE_DRIVER": "ftp"}, []string{"STORAGE_DRIVER"}},
+		{map[string]string{"STORAGE_DRIVER": "local", "STORAGE_MIRROR": "s3"}, nil},
+		{map[string]string{"STORAGE_MIRROR": "local"}, []string{"STORAGE_MIRROR"}},
+		{map[string]string{"STORAGE_MIRROR": "tape"}, []string{"STORAGE_MIRROR"}},
+		{map[string]string{"ANALYTICS_SINK": "http", "ANALYTICS_URL": "https://collector.example.com/events", "ANALYTICS_USER_IDS": "raw"}, nil},
+		{map[string]string{"ANALYTICS_SINK": "http", "ANALYTICS_USER_IDS": "hashed"}, []string{"ANALYTICS_URL", "ANALYTICS_USER_IDS"}},
+		{map[string]string{"ANALYTICS_SINK": "kafka"}, []string{"ANALYTICS_SINK"}},
+		{map[string]string{"CORS_ORIGINS": "https://picto.example.com, http://localhost:3000", "CORS_CREDENTIALS": "true", "CORS_MAX_AGE": "0"}, nil},
+		{map[string]string{"CORS_CREDENTIALS": "true"}, []string{"CORS_CREDENTIALS"}},
+		{map[string]string{"CORS_ORIGINS": "picto.example.com,https://picto.example.com/app", "CORS_MAX_AGE": "-1"}, []string{"CORS_ORIGINS", "CORS_MAX_AGE"}},
+		{map[string]string{"CORS_CREDENTIALS": "sometimes"}, []string{"CORS_CREDENTIALS"}},
+		{map[string]string{"COOKIE_SECURE": "false", "COOKIE_SAME_SITE": "strict", "COOKIE_PATH": "/api", "COOKIE_DOMAIN": "example.com"}, nil},
+		{map[string]string{"COOKIE_SECURE": "false", "COOKIE_SAME_SITE": "none"}, []string{"COOKIE_SAME_SITE"}},
+		{map[string]string{"COOKIE_SAME_SITE": "sometimes", "COOKIE_PATH": "api", "COOKIE_HTTP_ONLY": "no"}, []string{"COOKIE_SAME_SITE", "COOKIE_PATH", "COOKIE_HTTP_ONLY"}},
+		{map[string]string{"STATE_DRIVER": "redis", "REDIS_URL": "rediss://:secret@redis.internal:6380/2"}, nil},
+		{map[string]string{"STATE_DRIVER": "redis"}, []string{"REDIS_URL"}},
+		{map[string]string{"STATE_DRIVER": "redis", "REDIS_URL": "http://redis.internal"}, []string{"REDIS_URL"}},
+		{map[string]string{"STATE_DRIVER": "memcached"}, []string{"STATE_DRIVER"}},
+		{map[string]string{"WEB_DIR": "/nonexistent/web"}, []string{"WEB_DIR"}},
+	}
+
+	for i, tc := range tt {
+		restore := setConfigEnv(tc.Env)
+		_, err := LoadConfig("")
+		restore()
+
+		if (err == nil) != (len(tc.Problems) == 0) {
+			t.Errorf("wrong validation for case %v: got %v", i, err)
+			continue
+		}
+		for _, problem := range tc.Problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("error for case %v doesn't name %s: got %v", i, problem, err)
+			}
+		}
+	}
+}
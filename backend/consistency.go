@@ -0,0 +1,76 @@
+package main
+
+/*
+	This file routes metadata queries to a read replica when a replica host is configured to take load
+	off the primary database. Replicas lag behind the primary so a client listing its images right
+	after an upload may not see them, requests with consistency=strong are always read from the
+	primary so upload-then-list flows see their own writes.
+*/
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	CONSISTENCY_EVENTUAL = "eventual" // Default, reads may be served by a replica
+	CONSISTENCY_STRONG   = "strong"   // Reads are served by the primary and include every completed write
+)
+
+// replicaPool is the connection pool of the read replica, opened on first use by getReadDB
+var (
+	replicaPool *sql.DB
+	replicaLock sync.Mutex
+)
+
+// parseConsistency validates the consistency query parameter and reports whether strong consistency is requested
+func parseConsistency(params url.Values) (bool, error) {
+	switch params.Get("consistency") {
+	case "", CONSISTENCY_EVENTUAL:
+		return false, nil
+	case CONSISTENCY_STRONG:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid consistency %q, use %s or %s", params.Get("consistency"), CONSISTENCY_STRONG, CONSISTENCY_EVENTUAL)
+	}
+}
+
+// getReadDB returns the pool serving read only queries, the replica unless strong is set or no replica is configured
+// reads fall back to the primary while the replica is unreachable
+func getReadDB(strong bool) (*sql.DB, error) {
+	config := databaseConfig()
+	if strong || len(config.ReplicaHost) == 0 {
+		return getDB()
+	}
+
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	if replicaPool != nil {
+		return replicaPool, nil
+	}
+
+	db, err := openPool(config.replica())
+	if err != nil {
+		logger.Warning("read replica unavailable, reading from primary: %v", err)
+		return getDB()
+	}
+
+	replicaPool = db
+	return replicaPool, nil
+}
+
+// closeReplica closes the replica connection pool, the next read reopens it
+func closeReplica() {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	if replicaPool != nil {
+		replicaPool.Close()
+		replicaPool = nil
+	}
+}
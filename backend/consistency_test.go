@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestParseConsistency ensures only the strong and eventual consistency levels are accepted
+func TestParseConsistency(t *testing.T) {
+	tt := []struct {
+		Query  string
+		Strong bool
+		Valid  bool
+	}{
+		{"", false, true},
+		{"consistency=eventual", false, true},
+		{"consistency=strong", true, true},
+		{"consistency=STRONG", false, false},
+		{"consistency=linearizable", false, false},
+	}
+
+	for _, tc := range tt {
+		params, _ := url.ParseQuery(tc.Query)
+		strong, err := parseConsistency(params)
+		if (err == nil) != tc.Valid {
+			t.Errorf("wrong validation for %q: got %v", tc.Query, err)
+			continue
+		}
+		if strong != tc.Strong {
+			t.Errorf("wrong consistency for %q: got strong %v want %v", tc.Query, strong, tc.Strong)
+		}
+	}
+
+	// Invalid levels are refused before querying
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/image/meta?consistency=bogus", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	configureRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong code for invalid consistency: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestGetReadDB ensures strong reads use the primary and reads fall back to it while the replica is unreachable
+func TestGetReadDB(t *testing.T) {
+	primary, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+
+	config := databaseConfig()
+	defer configureDB(config)
+	config.ReplicaHost = "127.0.0.1"
+	config.ReplicaPort = "1"
+	configureDB(config)
+
+	for _, strong := range []bool{true, false} {
+		db, err := getReadDB(strong)
+		if err != nil {
+			t.Fatalf("failed to get read pool: %v", err)
+		}
+		if db != primary {
+			t.Errorf("expected primary pool for strong %v", strong)
+		}
+	}
+	if replicaPool != nil {
+		t.Errorf("expected unreachable replica not to be kept")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+/*
+	This file sets the cookie holding the auth token returned by registration and sign in. The
+	cookie is hidden from scripts, only sent over HTTPS and withheld from cross-site requests by
+	default. Local development over plain HTTP disables Secure, and web clients served from another
+	site than the API set SameSite to none alongside the CORS origins allowed to send credentials.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TOKEN_COOKIE = "token"
+
+	SAME_SITE_LAX    = "lax"
+	SAME_SITE_STRICT = "strict"
+	SAME_SITE_NONE   = "none"
+)
+
+// CookieConfig holds the attributes of the token cookie
+type CookieConfig struct {
+	Secure   bool   `yaml:"secure"`   // Only sent over HTTPS, disable for local development over HTTP
+	HttpOnly bool   `yaml:"httpOnly"` // Hidden from scripts
+	SameSite string `yaml:"sameSite"` // lax, strict or none
+	Path     string `yaml:"path"`
+	Domain   string `yaml:"domain"` // Empty limits the cookie to the host that set it
+}
+
+// cookieConfig is the configuration of the token cookie, the defaults until configureCookie is called
+var (
+	cookieConfig     = defaultConfig().Cookie
+	cookieConfigLock sync.RWMutex
+)
+
+// configureCookie sets the attributes of the token cookie
+func configureCookie(config CookieConfig) {
+	cookieConfigLock.Lock()
+	defer cookieConfigLock.Unlock()
+	cookieConfig = config
+}
+
+// currentCookie returns the attributes of the token cookie
+func currentCookie() CookieConfig {
+	cookieConfigLock.RLock()
+	defer cookieConfigLock.RUnlock()
+	return cookieConfig
+}
+
+// validate returns a problem for each invalid setting
+func (c CookieConfig) validate() []string {
+	var problems []string
+	switch c.SameSite {
+	case SAME_SITE_LAX, SAME_SITE_STRICT:
+	case SAME_SITE_NONE:
+		if !c.Secure {
+			problems = append(problems, "cookie.sameSite (COOKIE_SAME_SITE) none requires cookie.secure (COOKIE_SECURE)")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cookie.sameSite (COOKIE_SAME_SITE) must be lax, strict or none, got %q", c.SameSite))
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		problems = append(problems, fmt.Sprintf("cookie.path (COOKIE_PATH) must start with /, got %q", c.Path))
+	}
+	if strings.ContainsAny(c.Domain, " ;/:") {
+		problems = append(problems, fmt.Sprintf("cookie.domain (COOKIE_DOMAIN) must be a domain such as example.com, got %q", c.Domain))
+	}
+	return problems
+}
+
+// sameSite returns the SameSite mode of the setting
+func (c CookieConfig) sameSite() http.SameSite {
+	switch c.SameSite {
+	case SAME_SITE_STRICT:
+		return http.SameSiteStrictMode
+	case SAME_SITE_NONE:
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// setTokenCookie sets the cookie holding the auth token until it expires
+func setTokenCookie(w http.ResponseWriter, token string, exp int64) {
+	config := currentCookie()
+	http.SetCookie(w, &http.Cookie{
+		Name:     TOKEN_COOKIE,
+		Value:    token,
+		Expires:  time.Unix(exp, 0),
+		Path:     config.Path,
+		Domain:   config.Domain,
+		Secure:   config.Secure,
+		HttpOnly: config.HttpOnly,
+		SameSite: config.sameSite(),
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestTokenCookie ensures the token cookie carries the configured attributes with hardened defaults
+func TestTokenCookie(t *testing.T) {
+	defer configureCookie(currentCookie())
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tt := []struct {
+		Config   CookieConfig
+		Expected http.Cookie
+	}{
+		{defaultConfig().Cookie, http.Cookie{Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}},
+		{CookieConfig{SameSite: SAME_SITE_STRICT, Path: "/api", Domain: "example.com"}, http.Cookie{Path: "/api", Domain: "example.com", SameSite: http.SameSiteStrictMode}},
+		{CookieConfig{Secure: true, HttpOnly: true, SameSite: SAME_SITE_NONE, Path: "/"}, http.Cookie{Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteNoneMode}},
+	}
+
+	for _, tc := range tt {
+		configureCookie(tc.Config)
+		rr := httptest.NewRecorder()
+		setTokenCookie(rr, "jwt", exp)
+
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("expected one cookie: got %v", cookies)
+		}
+		cookie := cookies[0]
+		if cookie.Name != TOKEN_COOKIE || cookie.Value != "jwt" || cookie.Expires.Unix() != exp || cookie.Path != tc.Expected.Path || cookie.Domain != tc.Expected.Domain ||
+			cookie.Secure != tc.Expected.Secure || cookie.HttpOnly != tc.Expected.HttpOnly || cookie.SameSite != tc.Expected.SameSite {
+			t.Errorf("wrong cookie for %+v: got %+v", tc.Config, cookie)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+/*
+	This file implements the cross-origin policy of the API. Requests from browsers are answered
+	with CORS headers only when their origin is allowed, so deployments serving a web client from
+	its own domain can allow that domain alone and let it send cookies with credentials enabled.
+	Preflight requests are answered by the middleware with the methods of the routes at the path,
+	and browsers may cache the answer for the configured max age.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	CORS_ANY_ORIGIN  = "*"
+	CORS_MAX_AGE     = 600   // Seconds browsers may cache preflight responses by default
+	CORS_MAX_AGE_MAX = 86400 // Longest cache browsers honour
+
+	CORS_ALLOWED_HEADERS = "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-TOTP-Code, X-Request-Timeout"
+	CORS_EXPOSED_HEADERS = "Deprecation, Sunset, Link, Location, Retry-After, ETag, Content-Disposition"
+)
+
+// CorsConfig describes the origins allowed to call the API from browsers
+type CorsConfig struct {
+	Origins     []string `yaml:"origins"`     // Allowed origins such as https://picto.example.com, * allows any origin
+	Credentials bool     `yaml:"credentials"` // Let allowed origins send cookies, can't be used with *
+	MaxAge      int      `yaml:"maxAge"`      // Seconds
+}
+
+// corsConfig is the policy applied by corsPolicy, the defaults until configureCors is called
+var (
+	corsConfig     = defaultConfig().Cors
+	corsConfigLock sync.RWMutex
+)
+
+// configureCors sets the cross-origin policy of the router
+func configureCors(config CorsConfig) {
+	corsConfigLock.Lock()
+	defer corsConfigLock.Unlock()
+	corsConfig = config
+}
+
+// currentCors returns the cross-origin policy of the router
+func currentCors() CorsConfig {
+	corsConfigLock.RLock()
+	defer corsConfigLock.RUnlock()
+	return corsConfig
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for the origin, empty if it isn't allowed
+func (c CorsConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.Origins {
+		if allowed == CORS_ANY_ORIGIN {
+			return CORS_ANY_ORIGIN
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// validate returns a problem for each invalid setting
+func (c CorsConfig) validate() []string {
+	var problems []string
+	for _, origin := range c.Origins {
+		if origin == CORS_ANY_ORIGIN {
+			if c.Credentials {
+				problems = append(problems, "cors.credentials (CORS_CREDENTIALS) requires cors.origins (CORS_ORIGINS) to list origins instead of *")
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 || len(u.Path) > 0 || len(u.RawQuery) > 0 {
+			problems = append(problems, fmt.Sprintf("cors.origins (CORS_ORIGINS) must list * or origins such as https://picto.example.com, got %q", origin))
+		}
+	}
+	if c.MaxAge < 0 || c.MaxAge > CORS_MAX_AGE_MAX {
+		problems = append(problems, fmt.Sprintf("cors.maxAge (CORS_MAX_AGE) must be from 0 to %v seconds, got %v", CORS_MAX_AGE_MAX, c.MaxAge))
+	}
+	return problems
+}
+
+// routeMethods returns the methods of the routes matching the path of the request
+func routeMethods(router *mux.Router, req *http.Request) []string {
+	methods := []string{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range routeMethods {
+			if method == "OPTIONS" || containsString(methods, method) {
+				continue
+			}
+			probe := req.Clone(req.Context())
+			probe.Method = method
+			if route.Match(probe, &mux.RouteMatch{}) {
+				methods = append(methods, method)
+			}
+		}
+		return nil
+	})
+	sort.Strings(methods)
+	return append(methods, "OPTIONS")
+}
+
+// corsPolicy returns router middleware applying the cross-origin policy and answering OPTIONS requests
+// with the methods the router accepts at the path
+func corsPolicy(router *mux.Router) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			config := currentCors()
+			origin := req.Header.Get("Origin")
+			allowed := ""
+			if len(origin) > 0 {
+				allowed = config.allowOrigin(origin)
+			}
+
+			// Responses that depend on the origin must not be shared between origins by caches
+			if allowed != CORS_ANY_ORIGIN {
+				w.Header().Add("Vary", "Origin")
+			}
+			if len(allowed) > 0 {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+				if config.Credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if req.Method != "OPTIONS" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			methods := strings.Join(routeMethods(router, req), ", ")
+			w.Header().Set("Allow", methods)
+
+			// Preflights from origins that aren't allowed are refused, other OPTIONS requests describe the route
+			if len(origin) > 0 && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+				if len(allowed) == 0 {
+					logger.Error("preflight from origin %q not allowed sending 403", origin)
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("403 - Forbidden, requests from this origin are not allowed"))
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCorsPolicy ensures only allowed origins receive CORS headers and that preflights list the methods of
+// the routes at the path. None of the evaluated requests reach the database
+func TestCorsPolicy(t *testing.T) {
+	defer configureCors(currentCors())
+	router := configureRoutes()
+
+	tt := []struct {
+		Config      CorsConfig
+		Method      string
+		Path        string
+		Origin      string
+		Expected    int
+		AllowOrigin string
+		Methods     string
+	}{
+		{CorsConfig{Origins: []string{CORS_ANY_ORIGIN}, MaxAge: 600}, "GET", "/ping", "https://any.example.com", http.StatusOK, "*", ""},
+		{CorsConfig{Origins: []string{CORS_ANY_ORIGIN}, MaxAge: 600}, "OPTIONS", "/user", "https://any.example.com", http.StatusOK, "*", "GET, PUT, OPTIONS"},
+		{CorsConfig{Origins: []string{"https://picto.example.com"}, Credentials: true, MaxAge: 600}, "GET", "/ping", "https://picto.example.com", http.StatusOK, "https://picto.example.com", ""},
+		{CorsConfig{Origins: []string{"https://picto.example.com"}, Credentials: true, MaxAge: 600}, "GET", "/ping", "https://evil.example.com", http.StatusOK, "", ""},
+		{CorsConfig{Origins: []string{"https://picto.example.com"}, Credentials: true, MaxAge: 600}, "OPTIONS", "/image/1/1.png", "https://picto.example.com", http.StatusOK, "https://picto.example.com", "DELETE, GET, HEAD, PUT, OPTIONS"},
+		{CorsConfig{Origins: []string{"https://picto.example.com"}, Credentials: true, MaxAge: 600}, "OPTIONS", "/image/1/1.png", "https://evil.example.com", http.StatusForbidden, "", ""},
+	}
+
+	for _, tc := range tt {
+		configureCors(tc.Config)
+		req := httptest.NewRequest(tc.Method, tc.Path, nil)
+		req.Header.Set("Origin", tc.Origin)
+		if tc.Method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		header := rr.Header()
+		if rr.Code != tc.Expected || header.Get("Access-Control-Allow-Origin") != tc.AllowOrigin || header.Get("Access-Control-Allow-Methods") != tc.Methods {
+			t.Errorf("wrong response to %s %s from %s: got %v %v", tc.Method, tc.Path, tc.Origin, rr.Code, header)
+		}
+		if credentials := header.Get("Access-Control-Allow-Credentials") == "true"; credentials != (tc.Config.Credentials && len(tc.AllowOrigin) > 0) {
+			t.Errorf("wrong credentials for %s %s from %s: got %v", tc.Method, tc.Path, tc.Origin, credentials)
+		}
+		if len(tc.Methods) > 0 && header.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("wrong max age for %s %s: got %q", tc.Method, tc.Path, header.Get("Access-Control-Max-Age"))
+		}
+		if tc.AllowOrigin != CORS_ANY_ORIGIN && header.Get("Vary") != "Origin" {
+			t.Errorf("expected responses to %s to vary by origin: got %v", tc.Origin, header)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+/*
+	This file handles images whose metadata remains but whose file is lost. Mirrored storage
+	restores a missing primary copy from the mirror when it is read, see mirror.go. When no copy
+	remains the image is marked damaged, requests for it are answered with 410 so clients can tell
+	a lost file from a server error, and an integrity check of the owner's files is scheduled the
+	first time so the loss appears in the storage reports operators review.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	DAMAGED_IMAGE_TABLE = "damaged_image"
+)
+
+// DamagedImage records an image whose file was found missing tagged for sql serialization
+type DamagedImage struct {
+	ImageId  int32     `sql:"image_id" opt:"PRIMARY KEY"`
+	FileKey  string    `sql:"file_key"`
+	CheckId  int32     `sql:"check_id"` // Integrity check scheduled when the damage was found
+	Detected time.Time `sql:"detected"`
+}
+
+// reportDamagedImage marks the image as damaged and schedules an integrity check of its owner's files
+// unless the image was already marked, read-only instances only log the damage
+func reportDamagedImage(image Image) {
+	if readOnly() {
+		logger.Error("File of image %v is missing, read-only instances can't mark it damaged", image.Id)
+		return
+	}
+
+	damaged := DamagedImage{ImageId: image.Id, FileKey: imageKey(image), Detected: time.Now().UTC()}
+	check := StorageCheck{
+		Uid:     image.Uid,
+		Prefix:  fmt.Sprintf("%v/", image.Uid),
+		Status:  CHECK_PENDING,
+		Created: damaged.Detected,
+	}
+	marked, err := MarkImageDamaged(damaged, check)
+	if err != nil {
+		logger.Error("failed to mark image %v damaged: %v", image.Id, err)
+		return
+	}
+	if marked {
+		logger.Error("File %s of image %v is missing, marked damaged and scheduled an integrity check", damaged.FileKey, image.Id)
+	}
+}
+
+// writeImageGone reports the file of the image as lost
+func writeImageGone(w http.ResponseWriter, req *http.Request, image Image) {
+	reportDamagedImage(image)
+	w.WriteHeader(http.StatusGone)
+	w.Write([]byte("410 - Gone, the file of this image was lost and has been reported to the administrators"))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDamagedImage ensures an image whose file is lost is reported as gone, marked damaged once and checked
+func TestDamagedImage(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), image)
+
+	err := storage.Delete(context.Background(), imageKey(image))
+	if err != nil {
+		t.Fatalf("failed to delete file: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", strings.TrimPrefix(image.Ref, REF_URL), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusGone {
+			t.Errorf("wrong status for lost file: got %v want %v", rr.Code, http.StatusGone)
+		}
+	}
+
+	db, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	rows, err := selectWhere(db, DamagedImage{}, DAMAGED_IMAGE_TABLE, "image_id = $1", image.Id)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("image not marked damaged: got %v rows: %v", len(rows), err)
+	}
+	damaged := rows[0].(DamagedImage)
+	check, found, err := GetStorageCheck(damaged.CheckId)
+	if err != nil || !found || check.Uid != image.Uid || damaged.FileKey != imageKey(image) {
+		t.Errorf("integrity check not scheduled: got %+v %+v %v", damaged, check, err)
+	}
+
+	checks, err := selectWhere(db, StorageCheck{}, STORAGE_CHECK_TABLE, "uid = $1", image.Uid)
+	if err != nil || len(checks) != 1 {
+		t.Errorf("wrong checks scheduled for repeated reads: got %v: %v", len(checks), err)
+	}
+}
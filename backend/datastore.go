@@ -0,0 +1,145 @@
+package main
+
+/*
+	This file defines the store operations the image handlers depend on. The handlers are methods
+	of a Server holding its DataStore, they read and write image metadata, tags, shares, blocks
+	and re-encoded formats, and look up the policies, duplicates and titles applied to uploads,
+	through it rather than the store functions directly, so their tests may serve from an
+	in-memory store the way storage drivers are replaced with a temporary directory.
+	serve uses the SQL store calling the store functions of store.go, as do the background jobs
+	saving and purging images outside requests. Every operation takes the context of the request
+	so it stops at the request deadline.
+*/
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DataStore records image metadata and the relations deciding who may view an image
+type DataStore interface {
+	// AddImageData records a new image assigning its id, ref derives its reference from the id. The content of
+	// images stored under a blob key is put in place first, the image is only recorded once its file is stored
+	AddImageData(ctx context.Context, imgData Image, ref func(id int32) string, content imageContent) (Image, error)
+	// GetImageMeta returns the image outside the trash with its relations, an error containing 404 - Not found if there is none
+	GetImageMeta(ctx context.Context, id int32) (Image, error)
+	// UpdateImageData writes the image returning it with the title the title policy gave it
+	UpdateImageData(ctx context.Context, imgData Image) (Image, error)
+	DeleteImageData(ctx context.Context, imgData Image) error
+	// SetImageTags replaces the tags of the image
+	SetImageTags(ctx context.Context, imageId int32, tags []string) error
+	// TrashImageData moves the image to the trash, reporting false if it doesn't exist or is already trashed
+	TrashImageData(ctx context.Context, id int32, fileKey string, deleted time.Time) (bool, error)
+	// FindImageByHash returns the oldest image with the content hash owned by a user other than uid
+	FindImageByHash(ctx context.Context, hash string, uid int32) (Image, bool, error)
+	// FindOwnImageByHash returns the oldest image of the user with the content hash
+	FindOwnImageByHash(ctx context.Context, uid int32, hash string) (Image, bool, error)
+	// ImageTitles returns the titles of the user's images that equal base+ext or number it, ignoring the image exclude
+	ImageTitles(ctx context.Context, uid int32, base string, ext string, exclude int32) ([]string, error)
+	// HasImageShare reports whether the user was granted view access to the image
+	HasImageShare(ctx context.Context, imageId int32, uid int32) (bool, error)
+	// IsBlocked reports whether the user uid blocked the account blockedUid
+	IsBlocked(ctx context.Context, uid int32, blockedUid int32) (bool, error)
+	// ImageFormats returns the stored files of the image in other encodings
+	ImageFormats(ctx context.Context, imageId int32) ([]ImageFormat, error)
+	// ModerationRestricted reports whether a moderation case prevents the image from being shared
+	ModerationRestricted(ctx context.Context, imageId int32) (bool, error)
+	// InShareableAlbum reports whether the image belongs to an album marked shareable
+	InShareableAlbum(ctx context.Context, imageId int32) (bool, error)
+	// IngestPolicy returns the ingest policy of the organisation
+	IngestPolicy(ctx context.Context) (IngestPolicy, error)
+	// SharingPolicy returns the sharing policy of the organisation
+	SharingPolicy(ctx context.Context) (SharingPolicy, error)
+}
+
+// imageContent is the file of an image being recorded, staged under the staging key unless the image
+// links a file that is already stored, in which case content is written if the stored file went missing
+type imageContent struct {
+	staged  string
+	content io.Reader
+	size    int64
+}
+
+// Server handles the image requests with the data store it was created with
+type Server struct {
+	store DataStore
+}
+
+// sqlStore keeps the data in the PostgreSQL database of the store
+type sqlStore struct{}
+
+// AddImageData moves the file of images stored under a blob key into place within the transaction recording the image
+func (sqlStore) AddImageData(ctx context.Context, imgData Image, ref func(id int32) string, content imageContent) (Image, error) {
+	return AddImageData(ctx, imgData, ref, func(tx dbtx) error {
+		if !isBlobKey(imgData.FileKey) {
+			return nil
+		}
+		err := placeBlob(ctx, tx, imgData.FileKey, content.staged, content.content, content.size, imgData.Encoding)
+		if err != nil {
+			return &uploadError{http.StatusInternalServerError, "500 - Failed to save file, try again later", err}
+		}
+		return nil
+	})
+}
+
+func (sqlStore) GetImageMeta(ctx context.Context, id int32) (Image, error) {
+	return GetImageMeta(ctx, id)
+}
+
+func (sqlStore) UpdateImageData(ctx context.Context, imgData Image) (Image, error) {
+	return UpdateImageData(ctx, imgData)
+}
+
+func (sqlStore) DeleteImageData(ctx context.Context, imgData Image) error {
+	return DeleteImageData(ctx, imgData)
+}
+
+func (sqlStore) HasImageShare(ctx context.Context, imageId int32, uid int32) (bool, error) {
+	return HasImageShare(ctx, imageId, uid)
+}
+
+func (sqlStore) IsBlocked(ctx context.Context, uid int32, blockedUid int32) (bool, error) {
+	return IsBlocked(ctx, uid, blockedUid)
+}
+
+func (sqlStore) ImageFormats(ctx context.Context, imageId int32) ([]ImageFormat, error) {
+	return ImageFormats(ctx, imageId)
+}
+
+func (sqlStore) SetImageTags(ctx context.Context, imageId int32, tags []string) error {
+	return SetImageTags(ctx, imageId, tags)
+}
+
+func (sqlStore) TrashImageData(ctx context.Context, id int32, fileKey string, deleted time.Time) (bool, error) {
+	return TrashImageData(ctx, id, fileKey, deleted)
+}
+
+func (sqlStore) FindImageByHash(ctx context.Context, hash string, uid int32) (Image, bool, error) {
+	return FindImageByHash(ctx, hash, uid)
+}
+
+func (sqlStore) FindOwnImageByHash(ctx context.Context, uid int32, hash string) (Image, bool, error) {
+	return FindOwnImageByHash(ctx, uid, hash)
+}
+
+func (sqlStore) ImageTitles(ctx context.Context, uid int32, base string, ext string, exclude int32) ([]string, error) {
+	return ImageTitles(ctx, uid, base, ext, exclude)
+}
+
+func (sqlStore) ModerationRestricted(ctx context.Context, imageId int32) (bool, error) {
+	return ModerationRestricted(ctx, imageId)
+}
+
+func (sqlStore) InShareableAlbum(ctx context.Context, imageId int32) (bool, error) {
+	return InShareableAlbum(ctx, imageId)
+}
+
+func (sqlStore) IngestPolicy(ctx context.Context) (IngestPolicy, error) {
+	return GetIngestPolicy(ctx)
+}
+
+func (sqlStore) SharingPolicy(ctx context.Context) (SharingPolicy, error) {
+	return GetSharingPolicy(ctx)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps image data in maps so handlers can be tested without a database
+type memoryStore struct {
+	lock       sync.Mutex
+	nextId     int32
+	images     map[int32]Image
+	shares     map[[2]int32]bool // Keyed by image id and uid
+	blocks     map[[2]int32]bool // Keyed by blocking and blocked uid
+	formats    map[int32][]ImageFormat
+	restricted map[int32]bool // Images unshared by moderation
+	albums     map[int32]bool // Images in a shareable album
+	ingest     IngestPolicy
+	sharing    SharingPolicy
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		images:     map[int32]Image{},
+		shares:     map[[2]int32]bool{},
+		blocks:     map[[2]int32]bool{},
+		formats:    map[int32][]ImageFormat{},
+		restricted: map[int32]bool{},
+		albums:     map[int32]bool{},
+		ingest:     IngestPolicy{Id: 1},
+		sharing:    defaultSharingPolicy(),
+	}
+}
+
+// AddImageData moves a staged file into place without a transaction, the memory store has none
+func (m *memoryStore) AddImageData(ctx context.Context, imgData Image, ref func(id int32) string, content imageContent) (Image, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	title, err := applyTitlePolicy(getTitlePolicy(), imgData.Title, m.titles(imgData.Uid, imgData.Title, 0))
+	if err != nil {
+		return Image{}, err
+	}
+	if isBlobKey(imgData.FileKey) && len(content.staged) > 0 {
+		err := renameObject(ctx, content.staged, imgData.FileKey, imgData.Encoding)
+		if err != nil {
+			return Image{}, err
+		}
+	}
+	m.nextId++
+	imgData.Id = m.nextId
+	imgData.Ref = ref(imgData.Id)
+	imgData.Title = title
+	m.images[imgData.Id] = imgData
+	return imgData, nil
+}
+
+// titles returns the titles of the user's images that may conflict with the title, see ImageTitles
+func (m *memoryStore) titles(uid int32, title string, exclude int32) []string {
+	base, ext := splitTitle(title)
+	titles := []string{}
+	for _, image := range m.images {
+		if image.Uid != uid || image.Id == exclude {
+			continue
+		}
+		if image.Title == base+ext || strings.HasPrefix(image.Title, base+" (") && strings.HasSuffix(image.Title, ")"+ext) {
+			titles = append(titles, image.Title)
+		}
+	}
+	return titles
+}
+
+func (m *memoryStore) GetImageMeta(ctx context.Context, id int32) (Image, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	image, ok := m.images[id]
+	if !ok || !image.DeletedAt.IsZero() {
+		return Image{}, fmt.Errorf("404 - Not found")
+	}
+	return image, nil
+}
+
+func (m *memoryStore) UpdateImageData(ctx context.Context, imgData Image) (Image, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.images[imgData.Id]; !ok {
+		return Image{}, fmt.Errorf("no image %v", imgData.Id)
+	}
+	title, err := applyTitlePolicy(getTitlePolicy(), imgData.Title, m.titles(imgData.Uid, imgData.Title, imgData.Id))
+	if err != nil {
+		return Image{}, err
+	}
+	imgData.Title = title
+	m.images[imgData.Id] = imgData
+	return imgData, nil
+}
+
+func (m *memoryStore) DeleteImageData(ctx context.Context, imgData Image) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.images, imgData.Id)
+	delete(m.formats, imgData.Id)
+	return nil
+}
+
+func (m *memoryStore) SetImageTags(ctx context.Context, imageId int32, tags []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	image, ok := m.images[imageId]
+	if !ok {
+		return fmt.Errorf("no image %v", imageId)
+	}
+	image.Tags = tags
+	m.images[imageId] = image
+	return nil
+}
+
+func (m *memoryStore) TrashImageData(ctx context.Context, id int32, fileKey string, deleted time.Time) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	image, ok := m.images[id]
+	if !ok || !image.DeletedAt.IsZero() {
+		return false, nil
+	}
+	image.FileKey = fileKey
+	image.DeletedAt = deleted
+	m.images[id] = image
+	return true, nil
+}
+
+func (m *memoryStore) FindImageByHash(ctx context.Context, hash string, uid int32) (Image, bool, error) {
+	return m.findByHash(hash, func(owner int32) bool { return owner != uid })
+}
+
+func (m *memoryStore) FindOwnImageByHash(ctx context.Context, uid int32, hash string) (Image, bool, error) {
+	return m.findByHash(hash, func(owner int32) bool { return owner == uid })
+}
+
+// findByHash returns the oldest image outside the trash with the content hash whose owner matches
+func (m *memoryStore) findByHash(hash string, match func(owner int32) bool) (Image, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	found := Image{}
+	for _, image := range m.images {
+		if image.Hash != hash || !match(image.Uid) || !image.DeletedAt.IsZero() {
+			continue
+		}
+		if found.Id == 0 || image.Id < found.Id {
+			found = image
+		}
+	}
+	return found, found.Id != 0, nil
+}
+
+func (m *memoryStore) ImageTitles(ctx context.Context, uid int32, base string, ext string, exclude int32) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.titles(uid, base+ext, exclude), nil
+}
+
+func (m *memoryStore) HasImageShare(ctx context.Context, imageId int32, uid int32) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.shares[[2]int32{imageId, uid}], nil
+}
+
+func (m *memoryStore) IsBlocked(ctx context.Context, uid int32, blockedUid int32) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.blocks[[2]int32{uid, blockedUid}], nil
+}
+
+func (m *memoryStore) ImageFormats(ctx context.Context, imageId int32) ([]ImageFormat, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.formats[imageId], nil
+}
+
+func (m *memoryStore) ModerationRestricted(ctx context.Context, imageId int32) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.restricted[imageId], nil
+}
+
+func (m *memoryStore) InShareableAlbum(ctx context.Context, imageId int32) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.albums[imageId], nil
+}
+
+func (m *memoryStore) IngestPolicy(ctx context.Context) (IngestPolicy, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.ingest, nil
+}
+
+func (m *memoryStore) SharingPolicy(ctx context.Context) (SharingPolicy, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sharing, nil
+}
+
+// TestGetImageMemoryStore ensures images are served to their owner and the users they were shared with
+// while other users are refused, with the database replaced by the memory store
+func TestGetImageMemoryStore(t *testing.T) {
+	dir := t.TempDir()
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &localStorage{root: dir}
+	store := newMemoryStore()
+
+	content := []byte("not decoded")
+	image, err := store.AddImageData(context.Background(), Image{Uid: 1, Title: "stored.gif", Encoding: "image/gif"}, func(id int32) string {
+		return fmt.Sprintf("/image/1/%v.gif", id)
+	}, imageContent{})
+	if err != nil {
+		t.Fatalf("failed to add image: %v", err)
+	}
+	storage.Put(context.Background(), imageKey(image), bytes.NewReader(content), int64(len(content)), image.Encoding)
+	store.shares[[2]int32{image.Id, 2}] = true
+	store.shares[[2]int32{image.Id, 4}] = true
+	store.blocks[[2]int32{1, 4}] = true
+
+	router := (&Server{store: store}).routes()
+	get := func(uid int, path string) *httptest.ResponseRecorder {
+		token, _, err := generateJWT(uid, fmt.Sprintf("user%v@mail.com", uid))
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tt := []struct {
+		Uid  int
+		Path string
+		Code int
+	}{
+		{1, image.Ref, http.StatusOK},
+		{2, image.Ref, http.StatusOK},
+		{3, image.Ref, http.StatusUnauthorized},
+		{4, image.Ref, http.StatusUnauthorized},
+		{1, "/image/1/99.gif", http.StatusNotFound},
+		{1, "/image/1/bad.gif", http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		rr := get(tc.Uid, tc.Path)
+		if rr.Code != tc.Code {
+			t.Errorf("wrong status code for user %v requesting %s: got %v want %v", tc.Uid, tc.Path, rr.Code, tc.Code)
+		}
+		if tc.Code == http.StatusOK && !bytes.Equal(rr.Body.Bytes(), content) {
+			t.Errorf("wrong body for user %v: got %q", tc.Uid, rr.Body.String())
+		}
+	}
+
+	rr := get(2, image.Ref+"?meta=true")
+	var meta Image
+	if err := json.Unmarshal(rr.Body.Bytes(), &meta); err != nil || meta.Id != image.Id || meta.Title != image.Title {
+		t.Errorf("wrong image meta: got %v %q", rr.Code, rr.Body.String())
+	}
+
+	store.DeleteImageData(context.Background(), image)
+	if rr := get(1, image.Ref); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong status code for deleted image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
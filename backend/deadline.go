@@ -0,0 +1,112 @@
+package main
+
+/*
+	This file lets latency sensitive clients bound how long a request may take. The X-Request-Timeout
+	header sets a deadline on the request context, capped by REQUEST_TIMEOUT_MAX, which cancels the
+	database and storage calls made with it. Requests that fail because their deadline expired are
+	answered with 504 so clients can tell a timeout from a server error.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	REQUEST_TIMEOUT_HEADER = "X-Request-Timeout"
+	REQUEST_TIMEOUT_MAX    = 30 // Seconds, default if REQUEST_TIMEOUT_MAX env variable is not defined
+)
+
+// requestDeadline is router middleware applying the deadline requested by the X-Request-Timeout header
+// requests without the header have no deadline
+func requestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		value := req.Header.Get(REQUEST_TIMEOUT_HEADER)
+		if len(value) == 0 || req.Method == "OPTIONS" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		timeout, err := parseRequestTimeout(value)
+		if err != nil {
+			logger.Error("invalid request timeout sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(&deadlineWriter{ResponseWriter: w, ctx: ctx, timeout: timeout}, req.WithContext(ctx))
+	})
+}
+
+// parseRequestTimeout parses a timeout given in milliseconds or as a duration such as 500ms or 2s
+// timeouts longer than the server maximum are reduced to it
+func parseRequestTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		millis, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid %s %q, use milliseconds or a duration such as 500ms", REQUEST_TIMEOUT_HEADER, value)
+		}
+		timeout = time.Duration(millis) * time.Millisecond
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive", REQUEST_TIMEOUT_HEADER)
+	}
+
+	if max := getRequestTimeoutMax(); timeout > max {
+		timeout = max
+	}
+	return timeout, nil
+}
+
+// getRequestTimeoutMax returns the longest deadline a request may set
+func getRequestTimeoutMax() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("REQUEST_TIMEOUT_MAX"))
+	if err != nil || seconds <= 0 {
+		seconds = REQUEST_TIMEOUT_MAX
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// deadlineWriter replaces server errors caused by the expired deadline of the request with 504
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	timeout  time.Duration
+	timedOut bool
+}
+
+func (d *deadlineWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError && d.ctx.Err() == context.DeadlineExceeded {
+		d.timedOut = true
+		d.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+		d.ResponseWriter.Write([]byte(fmt.Sprintf("504 - Request timeout of %v exceeded", d.timeout)))
+		return
+	}
+	d.ResponseWriter.WriteHeader(code)
+}
+
+// Write discards the body of server errors replaced by the timeout response
+func (d *deadlineWriter) Write(b []byte) (int, error) {
+	if d.timedOut {
+		return len(b), nil
+	}
+	return d.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through to streaming handlers
+func (d *deadlineWriter) Flush() {
+	if flusher, ok := d.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestParseRequestTimeout ensures timeouts are accepted in milliseconds or as durations and capped by the server maximum
+func TestParseRequestTimeout(t *testing.T) {
+	defer os.Unsetenv("REQUEST_TIMEOUT_MAX")
+	os.Setenv("REQUEST_TIMEOUT_MAX", "5")
+
+	tt := []struct {
+		Value    string
+		Expected time.Duration
+		Valid    bool
+	}{
+		{"250", 250 * time.Millisecond, true},
+		{"500ms", 500 * time.Millisecond, true},
+		{"2s", 2 * time.Second, true},
+		{"1m", 5 * time.Second, true},
+		{"0", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tc := range tt {
+		timeout, err := parseRequestTimeout(tc.Value)
+		if (err == nil) != tc.Valid {
+			t.Errorf("wrong validation for %q: got %v", tc.Value, err)
+			continue
+		}
+		if timeout != tc.Expected {
+			t.Errorf("wrong timeout for %q: got %v want %v", tc.Value, timeout, tc.Expected)
+		}
+	}
+}
+
+// TestRequestDeadline ensures the deadline reaches handlers and server errors caused by it are reported as 504
+func TestRequestDeadline(t *testing.T) {
+	handler := requestDeadline(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Deadline(); !ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		<-req.Context().Done()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to complete query, try again later"))
+	}))
+
+	tt := []struct {
+		Timeout  string
+		Expected int
+	}{
+		{"", http.StatusOK},
+		{"10ms", http.StatusGatewayTimeout},
+		{"never", http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest("GET", "/image/meta", nil)
+		if len(tc.Timeout) > 0 {
+			req.Header.Set(REQUEST_TIMEOUT_HEADER, tc.Timeout)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for timeout %q: got %v want %v", tc.Timeout, rr.Code, tc.Expected)
+		}
+	}
+
+	// Server errors unrelated to the deadline are passed through
+	rr := httptest.NewRecorder()
+	writer := &deadlineWriter{ResponseWriter: rr, ctx: context.Background(), timeout: time.Second}
+	writer.WriteHeader(http.StatusInternalServerError)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("wrong code for error before deadline: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+}
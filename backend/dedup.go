@@ -0,0 +1,44 @@
+package main
+
+/*
+	This file implements deduplication of uploads across the organisation. When a member uploads
+	content identical to an image already stored by another member the upload may link the
+	existing file instead of storing another copy. Linked images share a storage key and the
+	file is deleted only once no image references it. Each image still counts its full size
+	against its owner's quota. Content stored since content addressing shares a blob regardless of
+	the mode, linking only avoids another copy of files stored before it.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	DEDUP_MODE = DEDUP_STORE // Default if ORG_DEDUP env variable is not defined
+
+	// Handling of uploads identical to another member's image
+	DEDUP_STORE  = "store"  // Store as an image of its own
+	DEDUP_PROMPT = "prompt" // Reject with 409 so the client can choose to link or store
+	DEDUP_LINK   = "link"   // Link the existing file
+)
+
+// ErrDuplicateContent is returned by saveImage in prompt mode when another member stored identical content
+var ErrDuplicateContent = errors.New("identical content already stored by another member")
+
+// resolveDedup returns the deduplication mode requested by the upload, the ORG_DEDUP environment variable
+// applies when the upload doesn't specify one
+func resolveDedup(requested string) (string, error) {
+	mode := requested
+	if len(mode) == 0 {
+		mode = os.Getenv("ORG_DEDUP")
+	}
+	if len(mode) == 0 {
+		mode = DEDUP_MODE
+	}
+	if mode != DEDUP_STORE && mode != DEDUP_PROMPT && mode != DEDUP_LINK {
+		return "", fmt.Errorf("invalid dedup %q, use %s, %s or %s", mode, DEDUP_STORE, DEDUP_PROMPT, DEDUP_LINK)
+	}
+	return mode, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestResolveDedup ensures the requested mode takes precedence over ORG_DEDUP
+func TestResolveDedup(t *testing.T) {
+	defer os.Unsetenv("ORG_DEDUP")
+
+	tt := []struct {
+		Env       string
+		Requested string
+		Expected  string
+		Error     bool
+	}{
+		{"", "", DEDUP_STORE, false},
+		{DEDUP_PROMPT, "", DEDUP_PROMPT, false},
+		{DEDUP_PROMPT, DEDUP_LINK, DEDUP_LINK, false},
+		{"", "copy", "", true},
+		{"copy", "", "", true},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("ORG_DEDUP", tc.Env)
+		mode, err := resolveDedup(tc.Requested)
+		if (err != nil) != tc.Error || mode != tc.Expected {
+			t.Errorf("wrong mode for env %q requested %q: got %q, %v want %q", tc.Env, tc.Requested, mode, err, tc.Expected)
+		}
+	}
+}
+
+// TestDedup uploads identical content as two members and ensures the linked file outlives the original image
+func TestDedup(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	member := createTestUser(t, "member")
+	memberToken, _, err := generateJWT(int(member.Uid), member.Email)
+	if err != nil {
+		t.Fatalf("failed to generate member jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	content := testImage(t, "png", 24)
+	original := dedupUpload(t, router, token, DEDUP_STORE, content, http.StatusOK)
+	dedupUpload(t, router, memberToken, DEDUP_PROMPT, content, http.StatusConflict)
+	linked := dedupUpload(t, router, memberToken, DEDUP_LINK, content, http.StatusOK)
+
+	linkedMeta, err := GetImageMeta(context.Background(), linked.Id)
+	if err != nil {
+		t.Fatalf("failed to retrieve linked image: %v", err)
+	}
+	if imageKey(linkedMeta) != blobKey(original.Hash, original.Encoding) {
+		t.Errorf("image not linked: got key %v want %v", imageKey(linkedMeta), blobKey(original.Hash, original.Encoding))
+	}
+
+	// The file remains while the linked image references it
+	deleteDedupImage(router, token, original)
+	file, err := storage.Open(context.Background(), imageKey(linkedMeta))
+	if err != nil {
+		t.Fatalf("linked file deleted with the original image: %v", err)
+	}
+	file.Close()
+
+	deleteDedupImage(router, memberToken, linked)
+	if _, err := storage.Open(context.Background(), imageKey(linkedMeta)); err != ErrObjectNotFound {
+		t.Errorf("file not deleted with its last reference: %v", err)
+	}
+}
+
+// dedupUpload uploads the content with the dedup mode and compares the status
+func dedupUpload(t *testing.T, router http.Handler, token string, dedup string, content []byte, expected int) Image {
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	writer.WriteField("dedup", dedup)
+	part, err := writer.CreateFormFile("image", "dedup.png")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/image", form)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != expected {
+		t.Fatalf("handler returned wrong code for dedup %s: got %v want %v", dedup, status, expected)
+	}
+
+	image := Image{}
+	if expected == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &image); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+	}
+	return image
+}
+
+// deleteDedupImage deletes the image as the owner of the token and purges it from the trash
+func deleteDedupImage(router http.Handler, token string, image Image) {
+	req, _ := http.NewRequest("DELETE", strings.TrimPrefix(image.Ref, REF_URL), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if trashed, found, err := GetTrashedImage(context.Background(), image.Id); err == nil && found {
+		purgeImage(context.Background(), trashed)
+	}
+}
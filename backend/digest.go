@@ -0,0 +1,27 @@
+package main
+
+/*
+	This file adds integrity headers to image downloads. The sha256 recorded when an image is
+	stored is sent as Repr-Digest (RFC 9530) and the older Digest header (RFC 3230) so downstream
+	systems can verify the bytes they received without a separate metadata request.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// setDigest adds the integrity headers for the hex sha256 of the stored file
+// images stored before hashes were recorded have no digest and are left without the headers
+func setDigest(header http.Header, hash string) {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) == 0 {
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	header.Set("Repr-Digest", fmt.Sprintf("sha-256=:%s:", encoded))
+	header.Set("Digest", fmt.Sprintf("SHA-256=%s", encoded))
+}
@@ -0,0 +1,204 @@
+package main
+
+/*
+	This file monitors free space on the volume holding locally stored images. Uploads are refused
+	with 507 Insufficient Storage when storing them would leave less than MIN_FREE_BYTES free, the
+	free space is exported on /metrics and a scheduled check emits an outbox event when the volume
+	runs low and again once it recovers so notification and webhook handlers can alert operators.
+	Images kept in remote storage such as s3 are not subject to these checks.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	MIN_FREE_BYTES      = 1 << 30          // Default if MIN_FREE_BYTES env variable is not defined
+	DISK_CHECK_INTERVAL = 30 * time.Second // Interval between checks alerting on low disk space
+
+	// Event topics
+	EVENT_DISK_LOW       = "storage.disk_low"
+	EVENT_DISK_RECOVERED = "storage.disk_recovered"
+)
+
+// ErrInsufficientStorage is returned when storing an upload would leave too little free disk space
+var ErrInsufficientStorage = errors.New("insufficient free disk space")
+
+// DiskUsage describes the space of the volume holding a path in bytes
+type DiskUsage struct {
+	Path  string
+	Free  int64
+	Total int64
+}
+
+// DiskSpaceEvent is the payload of EVENT_DISK_LOW and EVENT_DISK_RECOVERED
+type DiskSpaceEvent struct {
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Free      int64     `json:"free"`
+	Total     int64     `json:"total"`
+	Threshold int64     `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// diskSpaceLow records whether the last check found the volume low so events are only emitted on changes
+var diskSpaceLow = false
+
+// statVolume returns the usage of the volume holding the path, replaced by tests
+var statVolume = func(path string) (DiskUsage, error) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{Path: path, Free: int64(stat.Bavail) * int64(stat.Bsize), Total: int64(stat.Blocks) * int64(stat.Bsize)}, nil
+}
+
+func init() {
+	RegisterJob("disk-space", DISK_CHECK_INTERVAL, monitorDiskSpace)
+}
+
+// getMinFreeBytes returns the free space uploads must leave defined by the MIN_FREE_BYTES environment variable, 0 disables the check
+func getMinFreeBytes() int64 {
+	bytes, err := strconv.ParseInt(os.Getenv("MIN_FREE_BYTES"), 10, 64)
+	if err != nil || bytes < 0 {
+		bytes = MIN_FREE_BYTES
+	}
+	return bytes
+}
+
+// localRoot returns the directory files are written to when the storage driver keeps them on a local volume
+func localRoot(s Storage) (string, bool) {
+	switch s := s.(type) {
+	case *localStorage:
+		return s.root, true
+	case *faultStorage:
+		return localRoot(s.Storage)
+	case *mirrorStorage:
+		if root, ok := localRoot(s.primary); ok {
+			return root, true
+		}
+		return localRoot(s.mirror)
+	}
+	return "", false
+}
+
+// imageVolumeUsage returns the usage of the volume images are stored on, reporting false when images aren't stored locally
+// the nearest existing parent is checked while the storage directory hasn't been created yet
+func imageVolumeUsage() (DiskUsage, bool, error) {
+	root, ok := localRoot(storage)
+	if !ok {
+		return DiskUsage{}, false, nil
+	}
+
+	path := root
+	for {
+		_, err := os.Stat(path)
+		if err == nil || !os.IsNotExist(err) || path == filepath.Dir(path) {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	usage, err := statVolume(path)
+	if err != nil {
+		return DiskUsage{}, true, fmt.Errorf("failed to read free space of %s: %v", path, err)
+	}
+	return usage, true, nil
+}
+
+// checkDiskSpace returns ErrInsufficientStorage if storing size bytes would leave less than the minimum free space
+// uploads are accepted when the free space can't be determined so a failing check doesn't stop the service
+func checkDiskSpace(size int64) error {
+	usage, local, err := imageVolumeUsage()
+	if err != nil {
+		logger.Warning("unable to check free disk space, accepting upload: %v", err)
+		return nil
+	}
+	if !local {
+		return nil
+	}
+
+	if usage.Free-size < getMinFreeBytes() {
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// monitorDiskSpace emits EVENT_DISK_LOW when the image volume drops below the minimum free space
+// and EVENT_DISK_RECOVERED once it rises above it again
+func monitorDiskSpace(ctx context.Context) error {
+	usage, local, err := imageVolumeUsage()
+	if err != nil {
+		return err
+	}
+	if !local {
+		return nil
+	}
+
+	threshold := getMinFreeBytes()
+	low := usage.Free < threshold
+	if low == diskSpaceLow {
+		return nil
+	}
+
+	topic := EVENT_DISK_RECOVERED
+	if low {
+		topic = EVENT_DISK_LOW
+		logger.Warning("Image volume %s is low on space with %v of %v bytes free, uploads are refused until %v bytes are free", usage.Path, usage.Free, usage.Total, threshold)
+	} else {
+		logger.Info("Image volume %s recovered with %v of %v bytes free", usage.Path, usage.Free, usage.Total)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	event := DiskSpaceEvent{Host: host, Path: usage.Path, Free: usage.Free, Total: usage.Total, Threshold: threshold, Time: time.Now().UTC()}
+
+	// The state only changes once the event is recorded so a failed insert is retried on the next check
+	err = AddEvent(topic, event)
+	if err != nil {
+		return err
+	}
+	diskSpaceLow = low
+	return nil
+}
+
+// metrics exports the image volume's disk space and the image meta cache statistics in the prometheus text format
+func metrics(w http.ResponseWriter, req *http.Request) {
+	usage, local, err := imageVolumeUsage()
+	if err != nil {
+		logger.Error("failed to read disk space sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to collect metrics, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+	writeMetaCacheMetrics(w)
+	if !local {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP picto_disk_free_bytes Bytes available on the image volume.\n")
+	fmt.Fprintf(w, "# TYPE picto_disk_free_bytes gauge\n")
+	fmt.Fprintf(w, "picto_disk_free_bytes %v\n", usage.Free)
+	fmt.Fprintf(w, "# HELP picto_disk_total_bytes Size of the image volume in bytes.\n")
+	fmt.Fprintf(w, "# TYPE picto_disk_total_bytes gauge\n")
+	fmt.Fprintf(w, "picto_disk_total_bytes %v\n", usage.Total)
+	fmt.Fprintf(w, "# HELP picto_disk_min_free_bytes Free bytes below which uploads are refused.\n")
+	fmt.Fprintf(w, "# TYPE picto_disk_min_free_bytes gauge\n")
+	fmt.Fprintf(w, "picto_disk_min_free_bytes %v\n", getMinFreeBytes())
+}
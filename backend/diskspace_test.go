@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCheckDiskSpace ensures uploads are refused when they would leave less than the minimum free space
+func TestCheckDiskSpace(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	defer func(stat func(path string) (DiskUsage, error)) { statVolume = stat }(statVolume)
+	defer os.Setenv("MIN_FREE_BYTES", os.Getenv("MIN_FREE_BYTES"))
+	os.Setenv("MIN_FREE_BYTES", "1000")
+
+	storage = &localStorage{root: "missing/image"}
+	statVolume = func(path string) (DiskUsage, error) {
+		if path != "." {
+			t.Errorf("wrong path checked: got %s want the nearest existing parent", path)
+		}
+		return DiskUsage{Path: path, Free: 1500, Total: 4000}, nil
+	}
+
+	tt := []struct {
+		Size     int64
+		Expected error
+	}{
+		{100, nil},
+		{500, nil},
+		{501, ErrInsufficientStorage},
+	}
+	for _, tc := range tt {
+		if err := checkDiskSpace(tc.Size); err != tc.Expected {
+			t.Errorf("wrong result for %v bytes: got %v want %v", tc.Size, err, tc.Expected)
+		}
+	}
+
+	// Uploads are accepted when free space can't be read
+	statVolume = func(path string) (DiskUsage, error) { return DiskUsage{}, errors.New("unsupported") }
+	if err := checkDiskSpace(501); err != nil {
+		t.Errorf("expected upload accepted when free space is unknown: got %v", err)
+	}
+
+	// Remote storage is never checked
+	storage = &s3Storage{}
+	if err := checkDiskSpace(1 << 40); err != nil {
+		t.Errorf("expected remote storage unchecked: got %v", err)
+	}
+	if _, local, _ := imageVolumeUsage(); local {
+		t.Errorf("expected remote storage to report no local volume")
+	}
+}
+
+// TestLocalRoot ensures the local directory is found through wrapping drivers
+func TestLocalRoot(t *testing.T) {
+	local := &localStorage{root: "image"}
+	tt := []struct {
+		Storage Storage
+		Local   bool
+	}{
+		{local, true},
+		{&faultStorage{local}, true},
+		{&mirrorStorage{primary: &s3Storage{}, mirror: local}, true},
+		{&faultStorage{&mirrorStorage{primary: local, mirror: &s3Storage{}}}, true},
+		{&s3Storage{}, false},
+	}
+	for _, tc := range tt {
+		root, ok := localRoot(tc.Storage)
+		if ok != tc.Local || (ok && root != "image") {
+			t.Errorf("wrong local root of %s: got %q %v", tc.Storage.Name(), root, ok)
+		}
+	}
+}
+
+// TestMetrics ensures free disk space and image meta cache statistics are exported
+func TestMetrics(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	defer func(stat func(path string) (DiskUsage, error)) { statVolume = stat }(statVolume)
+
+	storage = &localStorage{root: "."}
+	statVolume = func(path string) (DiskUsage, error) { return DiskUsage{Path: path, Free: 1500, Total: 4000}, nil }
+
+	rr := httptest.NewRecorder()
+	configureRoutes().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	for _, line := range []string{"picto_disk_free_bytes 1500\n", "picto_disk_total_bytes 4000\n", "# TYPE picto_meta_cache_hits_total counter\n"} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("metric %q missing from %s", line, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+/*
+	This file detects duplicate images of a user. Uploads identical to an image the user already
+	uploaded, by the sha256 hash of their content, are handled by DUPLICATE_POLICY: stored again,
+	rejected or answered with the existing image. The phash processor additionally records a
+	perceptual hash of each image so /image/duplicates can group images that look alike, such as
+	the same photo exported at another size or quality, for the user to clean up.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	DUPLICATE_POLICY   = DUPLICATE_ALLOW // Default if DUPLICATE_POLICY env variable is not defined
+	DUPLICATE_DISTANCE = 6               // Default bits perceptual hashes of near duplicates may differ by if DUPLICATE_DISTANCE env variable is not defined
+
+	// Policies applied when a user uploads content identical to one of their images
+	DUPLICATE_ALLOW  = "allow"  // Store the upload as another image
+	DUPLICATE_REJECT = "reject" // Refuse the upload
+	DUPLICATE_REUSE  = "reuse"  // Respond with the existing image without storing the upload
+
+	phashWidth  = 9 // Columns sampled by the perceptual hash, adjacent columns are compared
+	phashHeight = 8 // Rows sampled by the perceptual hash
+)
+
+// ErrDuplicateImage is returned by saveImage when the reject policy refuses an upload the user already uploaded
+var ErrDuplicateImage = errors.New("identical image already uploaded")
+
+// DuplicateGroup is a set of a user's images that are identical or look alike
+type DuplicateGroup struct {
+	Exact  bool    `json:"exact"` // Every image of the group has identical content
+	Images []Image `json:"images"`
+}
+
+// DuplicatesResp is a page of the user's duplicate groups
+type DuplicatesResp struct {
+	Page         int              `json:"page"`
+	PageSize     int              `json:"pageSize"`
+	TotalResults int              `json:"totalResults"`
+	Groups       []DuplicateGroup `json:"groups"`
+}
+
+func init() {
+	RegisterProcessor(phashProcessor{})
+}
+
+// getDuplicatePolicy returns the policy defined by the DUPLICATE_POLICY environment variable
+func getDuplicatePolicy() string {
+	policy := os.Getenv("DUPLICATE_POLICY")
+	if len(policy) == 0 {
+		return DUPLICATE_POLICY
+	}
+	if policy != DUPLICATE_ALLOW && policy != DUPLICATE_REJECT && policy != DUPLICATE_REUSE {
+		logger.Warning("unknown DUPLICATE_POLICY %q, using %s", policy, DUPLICATE_POLICY)
+		return DUPLICATE_POLICY
+	}
+	return policy
+}
+
+// getDuplicateDistance returns the bits perceptual hashes of near duplicates may differ by defined by the DUPLICATE_DISTANCE environment variable
+func getDuplicateDistance() int {
+	distance, err := strconv.Atoi(os.Getenv("DUPLICATE_DISTANCE"))
+	if err != nil || distance < 0 || distance > 64 {
+		distance = DUPLICATE_DISTANCE
+	}
+	return distance
+}
+
+// resolveDuplicate applies the duplicate policy to an upload of the user with the content hash,
+// returning the existing image the upload should be answered with under the reuse policy
+func (s *Server) resolveDuplicate(ctx context.Context, uid int32, hash string) (Image, bool, error) {
+	policy := getDuplicatePolicy()
+	if policy == DUPLICATE_ALLOW {
+		return Image{}, false, nil
+	}
+
+	existing, found, err := s.store.FindOwnImageByHash(ctx, uid, hash)
+	if err != nil || !found {
+		return Image{}, false, err
+	}
+	if policy == DUPLICATE_REJECT {
+		return existing, false, ErrDuplicateImage
+	}
+
+	existing, err = s.store.GetImageMeta(ctx, existing.Id)
+	if err != nil {
+		return Image{}, false, err
+	}
+	return existing, true, nil
+}
+
+// perceptualHash returns the difference hash of the image: the image is reduced to a grid of
+// average brightness and each bit records whether a cell is brighter than its right neighbour.
+// Resizing or recompressing an image changes few bits while different images differ in about half
+func perceptualHash(src image.Image) uint64 {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return 0
+	}
+
+	// Average the brightness of the block of source pixels covered by each cell
+	var grid [phashHeight][phashWidth]float64
+	for row := 0; row < phashHeight; row++ {
+		y0, y1 := bounds.Min.Y+row*srcH/phashHeight, bounds.Min.Y+(row+1)*srcH/phashHeight
+		if y1 == y0 {
+			y1++
+		}
+		for col := 0; col < phashWidth; col++ {
+			x0, x1 := bounds.Min.X+col*srcW/phashWidth, bounds.Min.X+(col+1)*srcW/phashWidth
+			if x1 == x0 {
+				x1++
+			}
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := src.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grid[row][col] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < phashHeight; row++ {
+		for col := 0; col < phashWidth-1; col++ {
+			hash <<= 1
+			if grid[row][col] > grid[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// duplicateGroups groups images with identical content or perceptual hashes within distance bits,
+// images without a perceptual hash only group by content. Groups are ordered by their newest image
+func duplicateGroups(images []Image, distance int) []DuplicateGroup {
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	phashes := make([]uint64, len(images))
+	hashed := make([]bool, len(images))
+	for i, image := range images {
+		phash, err := strconv.ParseUint(image.PHash, 16, 64)
+		phashes[i], hashed[i] = phash, err == nil
+	}
+
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			exact := len(images[i].Hash) > 0 && images[i].Hash == images[j].Hash
+			similar := hashed[i] && hashed[j] && bits.OnesCount64(phashes[i]^phashes[j]) <= distance
+			if exact || similar {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := map[int][]Image{}
+	for i, image := range images {
+		root := find(i)
+		members[root] = append(members[root], image)
+	}
+
+	groups := []DuplicateGroup{}
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(a, b int) bool { return group[a].Id < group[b].Id })
+		exact := true
+		for _, image := range group {
+			exact = exact && image.Hash == group[0].Hash
+		}
+		groups = append(groups, DuplicateGroup{Exact: exact, Images: group})
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		return groups[a].Images[len(groups[a].Images)-1].Id > groups[b].Images[len(groups[b].Images)-1].Id
+	})
+	return groups
+}
+
+// phashProcessor records the perceptual hash of images that can be decoded
+type phashProcessor struct{}
+
+func (phashProcessor) Name() string {
+	return "phash"
+}
+
+func (phashProcessor) Process(ctx context.Context, img *Image) error {
+	if !canDecode(img.Encoding) {
+		return nil
+	}
+
+	file, err := storage.Open(ctx, imageKey(*img))
+	if err != nil {
+		return fmt.Errorf("failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	img.PHash = fmt.Sprintf("%016x", perceptualHash(src))
+	return UpdateImagePHash(img.Id, img.PHash)
+}
+
+// listDuplicates returns a page of groups of the authenticated user's images that are identical or look alike
+func listDuplicates(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for duplicates sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	page := 0
+	if param := req.URL.Query().Get("page"); len(param) > 0 {
+		page, err = strconv.Atoi(param)
+		if err != nil || page < 0 {
+			logger.Error("invalid duplicates page %q sending 400", param)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - page must be a non negative integer"))
+			return
+		}
+	}
+
+	images, err := UserImageHashes(req.Context(), int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to find duplicates, try again later"))
+		return
+	}
+
+	groups := duplicateGroups(images, getDuplicateDistance())
+	resp := DuplicatesResp{Page: page, PageSize: PAGE_SIZE, TotalResults: len(groups), Groups: []DuplicateGroup{}}
+	if page*PAGE_SIZE < len(groups) {
+		end := page*PAGE_SIZE + PAGE_SIZE
+		if end > len(groups) {
+			end = len(groups)
+		}
+		resp.Groups = groups[page*PAGE_SIZE : end]
+	}
+
+	// Tags are only retrieved for the images in the page
+	paged := []Image{}
+	for _, group := range resp.Groups {
+		paged = append(paged, group.Images...)
+	}
+	err = AttachImageRelations(req.Context(), paged)
+	if err != nil {
+		logger.Error("failed to retrieve tags and mentions sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to find duplicates, try again later"))
+		return
+	}
+	for _, group := range resp.Groups {
+		paged = paged[copy(group.Images, paged):]
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal duplicates sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to find duplicates, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPattern renders a size pixel square image of diagonal bands, inverted images have the opposite bands
+func testPattern(size int, inverted bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			level := uint8((x*3 + y) * 255 / (size * 4))
+			if (x*4/size)%2 == 1 {
+				level = 255 - level
+			}
+			if inverted {
+				level = 255 - level
+			}
+			img.SetGray(x, y, color.Gray{Y: level})
+		}
+	}
+	return img
+}
+
+// TestPerceptualHash ensures resized copies hash alike while different images do not
+func TestPerceptualHash(t *testing.T) {
+	original := perceptualHash(testPattern(64, false))
+	resized := perceptualHash(testPattern(200, false))
+	inverted := perceptualHash(testPattern(64, true))
+
+	if distance := bits.OnesCount64(original ^ resized); distance > DUPLICATE_DISTANCE {
+		t.Errorf("resized image too far from the original: got %v bits", distance)
+	}
+	if distance := bits.OnesCount64(original ^ inverted); distance <= DUPLICATE_DISTANCE {
+		t.Errorf("different image too close to the original: got %v bits", distance)
+	}
+	if hash := perceptualHash(image.NewGray(image.Rect(0, 0, 0, 0))); hash != 0 {
+		t.Errorf("wrong hash for empty image: got %x", hash)
+	}
+}
+
+// TestDuplicateGroups ensures images are grouped by content or perceptual hash and groups are ordered by their newest image
+func TestDuplicateGroups(t *testing.T) {
+	images := []Image{
+		{Id: 1, Hash: "a", PHash: "00000000000000ff"},
+		{Id: 2, Hash: "b", PHash: "00000000000000fe"}, // Near duplicate of 1
+		{Id: 3, Hash: "c", PHash: "ffffffffffffff00"},
+		{Id: 4, Hash: "d"},
+		{Id: 5, Hash: "d"}, // Identical to 4 without perceptual hashes
+		{Id: 6, Hash: "a", PHash: "00000000000000ff"},
+	}
+
+	groups := duplicateGroups(images, 2)
+	expected := []DuplicateGroup{
+		{Exact: false, Images: []Image{images[0], images[1], images[5]}},
+		{Exact: true, Images: []Image{images[3], images[4]}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("wrong groups: got %+v want %+v", groups, expected)
+	}
+
+	// Only identical content is grouped without tolerance
+	groups = duplicateGroups(images[:3], 0)
+	if len(groups) != 0 {
+		t.Errorf("wrong groups without tolerance: got %+v", groups)
+	}
+}
+
+// TestDuplicatePolicy ensures unknown policies fall back to the default
+func TestDuplicatePolicy(t *testing.T) {
+	defer os.Unsetenv("DUPLICATE_POLICY")
+
+	for configured, expected := range map[string]string{"": DUPLICATE_POLICY, "reject": DUPLICATE_REJECT, "reuse": DUPLICATE_REUSE, "discard": DUPLICATE_POLICY} {
+		os.Setenv("DUPLICATE_POLICY", configured)
+		if policy := getDuplicatePolicy(); policy != expected {
+			t.Errorf("wrong policy for %q: got %q want %q", configured, policy, expected)
+		}
+	}
+}
+
+// TestDuplicates ensures repeated uploads follow the duplicate policy and are listed for cleanup
+func TestDuplicates(t *testing.T) {
+	token, _ := getTestToken(t)
+	defer os.Unsetenv("DUPLICATE_POLICY")
+
+	router := configureRoutes()
+	first := uploadTestImage(t, router, token, false)
+	second := uploadTestImage(t, router, token, false)
+
+	os.Setenv("DUPLICATE_POLICY", DUPLICATE_REJECT)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, testUploadRequest(t, token, false))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("wrong code for rejected duplicate: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	os.Setenv("DUPLICATE_POLICY", DUPLICATE_REUSE)
+	if reused := uploadTestImage(t, router, token, false); reused.Id != first.Id && reused.Id != second.Id {
+		t.Errorf("duplicate not reused: got image %v", reused.Id)
+	}
+
+	req := httptest.NewRequest("GET", "/image/duplicates", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to list duplicates: got %v", rr.Code)
+	}
+	resp := DuplicatesResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TotalResults != 1 || len(resp.Groups) != 1 || !resp.Groups[0].Exact || len(resp.Groups[0].Images) != 2 {
+		t.Errorf("wrong duplicate groups: got %+v", resp)
+	}
+
+	req = httptest.NewRequest("GET", "/image/duplicates?page=x", strings.NewReader(""))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid page: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
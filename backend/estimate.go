@@ -0,0 +1,156 @@
+package main
+
+/*
+	This file projects the monthly cost of storing and serving images so self-hosters can budget
+	and billing tiers can be priced. Stored bytes are read from the tracked usage of users and
+	served bytes from the view counters, spreading the views of each image over the months since
+	its upload. Unit prices are configured through the environment and default to common object
+	storage prices. Estimates are projections from counters, not metered bills.
+*/
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	STORAGE_PRICE_GB   = 0.023 // Price of storing a GiB for a month if STORAGE_PRICE_GB env variable is not defined
+	BANDWIDTH_PRICE_GB = 0.09  // Price of serving a GiB if BANDWIDTH_PRICE_GB env variable is not defined
+	PRICE_CURRENCY     = "USD" // Default if PRICE_CURRENCY env variable is not defined
+	ESTIMATE_MONTH     = 30    // Days in a month of the estimate
+	GIB                = 1 << 30
+)
+
+// StorageUsage holds the counters an estimate is projected from
+type StorageUsage struct {
+	Users  int64 // Users the usage was summed over
+	Images int64
+	Stored int64 // Bytes stored including trashed images
+	Served int64 // Bytes projected to be served in a month
+}
+
+// UnitPrices are the configured prices an estimate is projected with
+type UnitPrices struct {
+	Currency  string  `json:"currency"`
+	Storage   float64 `json:"storagePerGb"`   // Price of storing a GiB for a month
+	Bandwidth float64 `json:"bandwidthPerGb"` // Price of serving a GiB
+}
+
+// EstimateResp reports the projected monthly cost of the usage
+type EstimateResp struct {
+	Users         int64      `json:"users,omitempty"` // Only reported for the organisation
+	Images        int64      `json:"images"`
+	StoredBytes   int64      `json:"storedBytes"`
+	ServedBytes   int64      `json:"servedBytes"`
+	Prices        UnitPrices `json:"prices"`
+	StorageCost   float64    `json:"storageCost"`
+	BandwidthCost float64    `json:"bandwidthCost"`
+	Total         float64    `json:"total"`
+}
+
+// getUnitPrices retrieves the unit prices from the STORAGE_PRICE_GB, BANDWIDTH_PRICE_GB and PRICE_CURRENCY
+// environment variables, invalid or negative prices fall back to the defaults
+func getUnitPrices() UnitPrices {
+	prices := UnitPrices{
+		Currency:  strings.ToUpper(strings.TrimSpace(os.Getenv("PRICE_CURRENCY"))),
+		Storage:   envPrice("STORAGE_PRICE_GB", STORAGE_PRICE_GB),
+		Bandwidth: envPrice("BANDWIDTH_PRICE_GB", BANDWIDTH_PRICE_GB),
+	}
+	if len(prices.Currency) == 0 {
+		prices.Currency = PRICE_CURRENCY
+	}
+	return prices
+}
+
+// envPrice parses the price of the environment variable, returning fallback if it isn't a valid price
+func envPrice(name string, fallback float64) float64 {
+	price, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || price < 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return fallback
+	}
+	return price
+}
+
+// roundCost rounds the cost to cents
+func roundCost(cost float64) float64 {
+	return math.Round(cost*100) / 100
+}
+
+// estimateCost projects the monthly cost of the usage with the prices
+func estimateCost(usage StorageUsage, prices UnitPrices) EstimateResp {
+	estimate := EstimateResp{
+		Images:        usage.Images,
+		StoredBytes:   usage.Stored,
+		ServedBytes:   usage.Served,
+		Prices:        prices,
+		StorageCost:   roundCost(float64(usage.Stored) / GIB * prices.Storage),
+		BandwidthCost: roundCost(float64(usage.Served) / GIB * prices.Bandwidth),
+	}
+	estimate.Total = roundCost(estimate.StorageCost + estimate.BandwidthCost)
+	return estimate
+}
+
+// userStorageEstimate returns the projected monthly cost of the authenticated user's images
+func userStorageEstimate(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for storage estimate sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	usage, err := GetStorageUsage(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve storage usage sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to estimate storage cost, try again later"))
+		return
+	}
+
+	writeEstimate(w, estimateCost(usage, getUnitPrices()))
+}
+
+// orgStorageEstimate returns the projected monthly cost of the images of every user
+func orgStorageEstimate(w http.ResponseWriter, req *http.Request) {
+	_, err := authRole(req, ROLE_STORAGE)
+	if err != nil {
+		logger.Error("Unauthorized request for organisation storage estimate: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	usage, err := GetStorageUsage(0)
+	if err != nil {
+		logger.Error("failed to retrieve storage usage sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to estimate storage cost, try again later"))
+		return
+	}
+
+	estimate := estimateCost(usage, getUnitPrices())
+	estimate.Users = usage.Users
+	writeEstimate(w, estimate)
+}
+
+// writeEstimate writes the estimate as json
+func writeEstimate(w http.ResponseWriter, estimate EstimateResp) {
+	js, err := json.Marshal(estimate)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(js)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestUnitPrices ensures configured prices are applied and invalid prices fall back to the defaults
+func TestUnitPrices(t *testing.T) {
+	defer os.Unsetenv("STORAGE_PRICE_GB")
+	defer os.Unsetenv("BANDWIDTH_PRICE_GB")
+	defer os.Unsetenv("PRICE_CURRENCY")
+
+	prices := getUnitPrices()
+	if prices.Storage != STORAGE_PRICE_GB || prices.Bandwidth != BANDWIDTH_PRICE_GB || prices.Currency != PRICE_CURRENCY {
+		t.Errorf("wrong default prices: got %+v", prices)
+	}
+
+	os.Setenv("STORAGE_PRICE_GB", "0.01")
+	os.Setenv("BANDWIDTH_PRICE_GB", "-1")
+	os.Setenv("PRICE_CURRENCY", "eur")
+	prices = getUnitPrices()
+	if prices.Storage != 0.01 || prices.Bandwidth != BANDWIDTH_PRICE_GB || prices.Currency != "EUR" {
+		t.Errorf("wrong configured prices: got %+v", prices)
+	}
+}
+
+// TestEstimateCost ensures costs are priced per GiB and rounded to cents
+func TestEstimateCost(t *testing.T) {
+	prices := UnitPrices{Currency: "USD", Storage: 0.023, Bandwidth: 0.09}
+	tt := []struct {
+		Usage              StorageUsage
+		Storage, Bandwidth float64
+		Total              float64
+	}{
+		{StorageUsage{}, 0, 0, 0},
+		{StorageUsage{Stored: 100 * GIB, Served: 10 * GIB}, 2.3, 0.9, 3.2},
+		{StorageUsage{Stored: GIB / 2, Served: 3 * GIB}, 0.01, 0.27, 0.28},
+	}
+
+	for _, tc := range tt {
+		estimate := estimateCost(tc.Usage, prices)
+		if estimate.StorageCost != tc.Storage || estimate.BandwidthCost != tc.Bandwidth || estimate.Total != tc.Total {
+			t.Errorf("wrong estimate of %+v: got %+v", tc.Usage, estimate)
+		}
+	}
+}
+
+// TestStorageEstimate ensures users receive the estimate of their own usage and only storage administrators the organisation's
+func TestStorageEstimate(t *testing.T) {
+	token, uid := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), image)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("/user/storage/estimate")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to estimate storage cost: got %v %s", rr.Code, rr.Body.String())
+	}
+	estimate := EstimateResp{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("failed to unmarshal estimate: %v", err)
+	}
+	if estimate.Images != 1 || estimate.StoredBytes != int64(image.Size) || estimate.Users != 0 {
+		t.Errorf("wrong estimate of the user: got %+v", estimate)
+	}
+
+	if rr := send("/admin/storage/estimate"); rr.Code != http.StatusForbidden {
+		t.Errorf("organisation estimate of a user: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	os.Setenv("STORAGE_ADMIN_UIDS", fmt.Sprintf("%v", uid))
+	defer os.Unsetenv("STORAGE_ADMIN_UIDS")
+	rr = send("/admin/storage/estimate")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to estimate organisation storage cost: got %v %s", rr.Code, rr.Body.String())
+	}
+	estimate = EstimateResp{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("failed to unmarshal estimate: %v", err)
+	}
+	if estimate.Users < 1 || estimate.Images < 1 || estimate.StoredBytes < int64(image.Size) {
+		t.Errorf("wrong estimate of the organisation: got %+v", estimate)
+	}
+}
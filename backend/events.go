@@ -0,0 +1,268 @@
+package main
+
+/*
+	This file streams changes to the images a user can see so gallery clients update live instead
+	of polling. GET /events is a Server-Sent Events stream notifying the owner of an image and the
+	accounts it is shared with when it is added, updated, shared, unshared, deleted or restored.
+	Events are published when the action is recorded in the audit log and delivered to the streams
+	of this instance directly and to those of other instances through PostgreSQL NOTIFY. Delivery
+	is best effort: events raised while a client is disconnected or too slow to keep up are dropped,
+	clients reload the library when they reconnect.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+	"github.com/lib/pq"
+)
+
+const (
+	EVENTS_CHANNEL   = "library_events" // PostgreSQL channel relaying events between instances
+	EVENTS_BUFFER    = 32               // Events held for each stream before further events are dropped
+	EVENTS_KEEPALIVE = 25 * time.Second // Interval of comments keeping idle streams open through proxies
+	EVENTS_RETRY     = 5000             // Milliseconds clients wait before reconnecting
+
+	// Library event types
+	LIBRARY_ADDED    = "image.added"
+	LIBRARY_UPDATED  = "image.updated"
+	LIBRARY_SHARED   = "image.shared"
+	LIBRARY_UNSHARED = "image.unshared"
+	LIBRARY_DELETED  = "image.deleted"
+	LIBRARY_RESTORED = "image.restored"
+)
+
+// libraryEventTypes maps the audited actions changing an image to the event notifying of them
+var libraryEventTypes = map[string]string{
+	AUDIT_UPLOAD:       LIBRARY_ADDED,
+	AUDIT_GUEST_UPLOAD: LIBRARY_ADDED,
+	AUDIT_UPDATE:       LIBRARY_UPDATED,
+	AUDIT_SHARE:        LIBRARY_SHARED,
+	AUDIT_UNSHARE:      LIBRARY_UNSHARED,
+	AUDIT_DELETE:       LIBRARY_DELETED,
+	AUDIT_RESTORE:      LIBRARY_RESTORED,
+}
+
+// LibraryEvent notifies of a change to an image
+type LibraryEvent struct {
+	Type    string    `json:"type"`
+	ImageId int32     `json:"imageId"`
+	Owner   int32     `json:"owner"`
+	Time    Timestamp `json:"time"`
+}
+
+// relayedEvent is an event relayed between instances with the users it is delivered to
+type relayedEvent struct {
+	Origin   string       `json:"origin"`
+	Event    LibraryEvent `json:"event"`
+	Audience []int32      `json:"audience"`
+}
+
+// eventHub holds the open streams of each user
+type eventHub struct {
+	lock    sync.Mutex
+	streams map[int32]map[chan LibraryEvent]bool
+}
+
+// libraryEvents are the streams open on this instance
+var libraryEvents = &eventHub{streams: map[int32]map[chan LibraryEvent]bool{}}
+
+// instanceId identifies events published by this instance so relayed copies aren't delivered twice
+var instanceId = func() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}()
+
+// subscribe opens a stream of the events delivered to the user
+func (h *eventHub) subscribe(uid int32) chan LibraryEvent {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stream := make(chan LibraryEvent, EVENTS_BUFFER)
+	if h.streams[uid] == nil {
+		h.streams[uid] = map[chan LibraryEvent]bool{}
+	}
+	h.streams[uid][stream] = true
+	return stream
+}
+
+// unsubscribe closes the stream of the user
+func (h *eventHub) unsubscribe(uid int32, stream chan LibraryEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.streams[uid], stream)
+	if len(h.streams[uid]) == 0 {
+		delete(h.streams, uid)
+	}
+}
+
+// deliver sends the event to the streams of each user in the audience, dropping it for streams that are full
+func (h *eventHub) deliver(event LibraryEvent, audience []int32) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, uid := range audience {
+		for stream := range h.streams[uid] {
+			select {
+			case stream <- event:
+			default:
+				logger.Warning("event stream of user %v is full, dropping %s of image %v", uid, event.Type, event.ImageId)
+			}
+		}
+	}
+}
+
+// publishAudited publishes the event of an audited action to the owner of the image and the accounts
+// it is shared with and queues it for the owner's webhooks, actions not changing an image are ignored
+func publishAudited(uid int, action string, imageId int32) {
+	eventType, ok := libraryEventTypes[action]
+	if !ok || imageId == 0 {
+		return
+	}
+
+	audience := []int32{int32(uid)}
+	shares, _, err := ImageShares(imageId)
+	if err != nil {
+		logger.Error("failed to retrieve the shares of image %v, notifying the owner only: %v", imageId, err)
+	}
+	for _, share := range shares {
+		audience = append(audience, share.Uid)
+	}
+
+	event := LibraryEvent{Type: eventType, ImageId: imageId, Owner: int32(uid), Time: Timestamp(time.Now().UTC())}
+	publishLibraryEvent(event, audience)
+	queueWebhooks(event)
+}
+
+// publishLibraryEvent delivers the event to the streams of the audience on every instance
+func publishLibraryEvent(event LibraryEvent, audience []int32) {
+	libraryEvents.deliver(event, audience)
+
+	js, err := json.Marshal(relayedEvent{Origin: instanceId, Event: event, Audience: audience})
+	if err != nil {
+		logger.Error("failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	db, err := getDB()
+	if err != nil {
+		logger.Error("failed to relay %s event due to connection error: %v", event.Type, err)
+		return
+	}
+	_, err = db.Exec("SELECT pg_notify($1, $2)", EVENTS_CHANNEL, string(js))
+	if err != nil {
+		logger.Error("failed to relay %s event: %v", event.Type, err)
+	}
+}
+
+// relayLibraryEvent delivers an event relayed by another instance
+func relayLibraryEvent(payload string) {
+	relayed := relayedEvent{}
+	err := json.Unmarshal([]byte(payload), &relayed)
+	if err != nil {
+		logger.Error("failed to decode relayed event: %v", err)
+		return
+	}
+	if relayed.Origin == instanceId {
+		return
+	}
+	libraryEvents.deliver(relayed.Event, relayed.Audience)
+}
+
+// startEventRelay listens for events and image meta invalidations published by other instances until the context is cancelled
+func startEventRelay(ctx context.Context) error {
+	listener := pq.NewListener(dataSourceName(databaseConfig()), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Error("event relay connection error: %v", err)
+		}
+	})
+	err := listener.Listen(EVENTS_CHANNEL)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen for library events: %v", err)
+	}
+	err = listener.Listen(META_CACHE_CHANNEL)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen for image meta invalidations: %v", err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-listener.Notify:
+				// A nil notification follows a reconnection, events sent meanwhile are lost
+				// and the cached metadata they may have invalidated is cleared
+				if notification == nil {
+					imageMetas.Purge()
+					continue
+				}
+				if notification.Channel == META_CACHE_CHANNEL {
+					applyMetaInvalidation(notification.Extra)
+					continue
+				}
+				relayLibraryEvent(notification.Extra)
+			}
+		}
+	}()
+	return nil
+}
+
+// streamEvents streams the library events of the authenticated user as Server-Sent Events
+func streamEvents(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for events sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Error("response writer can't stream events sending 500")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Streaming is not supported"))
+		return
+	}
+
+	stream := libraryEvents.subscribe(int32(claims.Uid))
+	defer libraryEvents.unsubscribe(int32(claims.Uid), stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // Stops proxies such as nginx from buffering the stream
+	fmt.Fprintf(w, "retry: %v\n\n", EVENTS_RETRY)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(EVENTS_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-stream:
+			js, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("failed to marshal %s event: %v", event.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, js)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventHub ensures events reach the streams of their audience only and relayed copies of own events are ignored
+func TestEventHub(t *testing.T) {
+	hub := &eventHub{streams: map[int32]map[chan LibraryEvent]bool{}}
+	first := hub.subscribe(1)
+	second := hub.subscribe(1)
+	other := hub.subscribe(2)
+	defer hub.unsubscribe(2, other)
+
+	hub.deliver(LibraryEvent{Type: LIBRARY_ADDED, ImageId: 7}, []int32{1})
+	for _, stream := range []chan LibraryEvent{first, second} {
+		select {
+		case event := <-stream:
+			if event.ImageId != 7 {
+				t.Errorf("wrong event delivered: got %+v", event)
+			}
+		default:
+			t.Errorf("event not delivered to a stream of the audience")
+		}
+	}
+	if len(other) != 0 {
+		t.Errorf("event delivered outside of the audience")
+	}
+
+	hub.unsubscribe(1, first)
+	hub.unsubscribe(1, second)
+	if _, ok := hub.streams[1]; ok {
+		t.Errorf("streams of the user kept after unsubscribing")
+	}
+
+	// Full streams drop events rather than blocking the publisher
+	for i := 0; i < EVENTS_BUFFER+1; i++ {
+		hub.deliver(LibraryEvent{Type: LIBRARY_UPDATED, ImageId: int32(i)}, []int32{2})
+	}
+	if len(other) != EVENTS_BUFFER {
+		t.Errorf("wrong events held by a full stream: got %v want %v", len(other), EVENTS_BUFFER)
+	}
+
+	stream := libraryEvents.subscribe(3)
+	defer libraryEvents.unsubscribe(3, stream)
+	for _, origin := range []string{instanceId, "other"} {
+		js, _ := json.Marshal(relayedEvent{Origin: origin, Event: LibraryEvent{Type: LIBRARY_DELETED, ImageId: 9}, Audience: []int32{3}})
+		relayLibraryEvent(string(js))
+	}
+	if len(stream) != 1 {
+		t.Errorf("wrong relayed events delivered: got %v want 1", len(stream))
+	}
+}
+
+// TestStreamEvents ensures uploads and updates of the user's images are streamed to them
+func TestStreamEvents(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	server := httptest.NewServer(configureRoutes())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("wrong event stream response: got %v %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+				events <- strings.TrimPrefix(line, "event: ")
+			}
+		}
+	}()
+
+	image := uploadTestImage(t, configureRoutes(), token, false)
+	defer DeleteImageData(context.Background(), image)
+
+	select {
+	case event := <-events:
+		if event != LIBRARY_ADDED {
+			t.Errorf("wrong event streamed: got %v want %v", event, LIBRARY_ADDED)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("upload not streamed")
+	}
+
+	rr := httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/events", nil)
+	configureRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("events streamed without authentication: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+/*
+	This file reads EXIF metadata embedded in JPEG uploads. EXIF data is a TIFF structure stored
+	in the APP1 segment: a header naming the byte order followed by directories (IFDs) of tagged
+	entries. Only the tags used by the server are read, malformed data is treated as missing.
+*/
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	EXIF_READ_LIMIT = 256 * 1024 // Bytes read from the start of an upload looking for EXIF data
+
+	exifDateTimeTag          = 0x0132 // Last modification, in IFD0
+	exifIFDPointerTag        = 0x8769 // Offset of the Exif sub IFD, in IFD0
+	exifDateTimeOriginalTag  = 0x9003 // Moment the photo was taken, in the Exif sub IFD
+	exifDateTimeDigitizedTag = 0x9004 // Moment the photo was digitized, in the Exif sub IFD
+
+	exifDateFormat = "2006:01:02 15:04:05"
+	tiffTypeASCII  = 2
+	tiffTypeLong   = 4
+)
+
+// exifTIFF returns the TIFF structure of the APP1 Exif segment of the JPEG data, nil if it has none
+func exifTIFF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	// Walk the segments before the image data looking for the APP1 Exif segment
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil // Start of scan, metadata segments precede it
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+
+	return nil
+}
+
+// tiffEntry is an entry of a TIFF directory, small values are stored within the entry
+// and larger values at an offset from the start of the TIFF structure
+type tiffEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Value []byte // The four value bytes of the entry
+}
+
+// tiffDirectory returns the entries of the directory at the offset, nil if it is out of bounds
+func tiffDirectory(tiff []byte, order binary.ByteOrder, offset uint32) []tiffEntry {
+	ifd := int(offset)
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	entries := []tiffEntry{}
+	for e := 0; e < count; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		entries = append(entries, tiffEntry{
+			Tag:   order.Uint16(tiff[entry:]),
+			Type:  order.Uint16(tiff[entry+2:]),
+			Count: order.Uint32(tiff[entry+4:]),
+			Value: tiff[entry+8 : entry+12],
+		})
+	}
+	return entries
+}
+
+// tiffOrder returns the byte order named by the TIFF header, nil if the header is invalid
+func tiffOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// tiffString returns the value of an ASCII entry without its terminating NUL
+func tiffString(tiff []byte, order binary.ByteOrder, entry tiffEntry) (string, bool) {
+	if entry.Type != tiffTypeASCII || entry.Count == 0 {
+		return "", false
+	}
+
+	if entry.Count <= 4 {
+		return strings.TrimRight(string(entry.Value[:entry.Count]), "\x00 "), true
+	}
+
+	offset := int(order.Uint32(entry.Value))
+	if offset < 0 || offset+int(entry.Count) > len(tiff) {
+		return "", false
+	}
+	return strings.TrimRight(string(tiff[offset:offset+int(entry.Count)]), "\x00 "), true
+}
+
+// exifTakenDate returns the moment the photo in the JPEG data was taken, preferring the original
+// date of the Exif sub IFD over the modification date of IFD0. EXIF dates have no time zone so
+// the camera's wall clock is returned as UTC, keeping photos on the day they were taken locally
+func exifTakenDate(data []byte) (time.Time, bool) {
+	tiff := exifTIFF(data)
+	order := tiffOrder(tiff)
+	if order == nil {
+		return time.Time{}, false
+	}
+
+	dates := map[uint16]string{}
+	readDates := func(entries []tiffEntry) {
+		for _, entry := range entries {
+			switch entry.Tag {
+			case exifDateTimeTag, exifDateTimeOriginalTag, exifDateTimeDigitizedTag:
+				if value, ok := tiffString(tiff, order, entry); ok {
+					dates[entry.Tag] = value
+				}
+			}
+		}
+	}
+
+	ifd0 := tiffDirectory(tiff, order, order.Uint32(tiff[4:]))
+	readDates(ifd0)
+	for _, entry := range ifd0 {
+		if entry.Tag == exifIFDPointerTag && entry.Type == tiffTypeLong {
+			readDates(tiffDirectory(tiff, order, order.Uint32(entry.Value)))
+		}
+	}
+
+	for _, tag := range []uint16{exifDateTimeOriginalTag, exifDateTimeDigitizedTag, exifDateTimeTag} {
+		taken, err := time.Parse(exifDateFormat, dates[tag])
+		// Cameras without a set clock record zero dates
+		if err == nil && taken.Year() > 1900 {
+			return taken, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readTakenDate returns the EXIF taken date of a JPEG upload, the fallback if the upload has none
+// the reader is returned to the start of the file
+func readTakenDate(file io.ReadSeeker, encoding string, fallback time.Time) time.Time {
+	if encoding != "image/jpeg" {
+		return fallback
+	}
+	defer file.Seek(0, io.SeekStart)
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, EXIF_READ_LIMIT))
+	if err != nil {
+		return fallback
+	}
+	taken, ok := exifTakenDate(data)
+	if !ok {
+		return fallback
+	}
+	return taken
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// exifDateSegment returns an APP1 segment holding the modification date in IFD0 and
+// the original date in the Exif sub IFD, both stored after the directories
+func exifDateSegment(order binary.ByteOrder, modified string, original string) []byte {
+	tiff := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8)) // IFD0 follows the header
+
+	// IFD0 spans 30 bytes to 38, the Exif sub IFD 18 bytes to 56 followed by the dates
+	binary.Write(tiff, order, uint16(2))
+	binary.Write(tiff, order, uint16(exifDateTimeTag))
+	binary.Write(tiff, order, uint16(tiffTypeASCII))
+	binary.Write(tiff, order, uint32(20))
+	binary.Write(tiff, order, uint32(56))
+	binary.Write(tiff, order, uint16(exifIFDPointerTag))
+	binary.Write(tiff, order, uint16(tiffTypeLong))
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, uint32(38))
+	binary.Write(tiff, order, uint32(0))
+
+	binary.Write(tiff, order, uint16(1))
+	binary.Write(tiff, order, uint16(exifDateTimeOriginalTag))
+	binary.Write(tiff, order, uint16(tiffTypeASCII))
+	binary.Write(tiff, order, uint32(20))
+	binary.Write(tiff, order, uint32(76))
+	binary.Write(tiff, order, uint32(0))
+
+	tiff.WriteString(modified + "\x00")
+	tiff.WriteString(original + "\x00")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	return append(header, segment...)
+}
+
+// TestExifTakenDate ensures the original date is preferred and unset or missing dates are ignored
+func TestExifTakenDate(t *testing.T) {
+	jpg := testImage(t, "jpeg", 8)
+	original := time.Date(2019, 7, 14, 18, 30, 5, 0, time.UTC)
+	modified := time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		Name     string
+		Data     []byte
+		Expected time.Time
+	}{
+		{"little endian", withSegment(jpg, exifDateSegment(binary.LittleEndian, "2020:01:02 09:00:00", "2019:07:14 18:30:05")), original},
+		{"big endian", withSegment(jpg, exifDateSegment(binary.BigEndian, "2020:01:02 09:00:00", "2019:07:14 18:30:05")), original},
+		{"unset original", withSegment(jpg, exifDateSegment(binary.BigEndian, "2020:01:02 09:00:00", "0000:00:00 00:00:00")), modified},
+		{"unset dates", withSegment(jpg, exifDateSegment(binary.BigEndian, "0000:00:00 00:00:00", "                   ")), time.Time{}},
+		{"orientation only", withSegment(jpg, exifSegment(binary.LittleEndian, 6)), time.Time{}},
+		{"no exif", jpg, time.Time{}},
+		{"truncated", withSegment(jpg, exifDateSegment(binary.BigEndian, "2020:01:02 09:00:00", "2019:07:14 18:30:05"))[:60], time.Time{}},
+	}
+
+	for _, tc := range tt {
+		taken, ok := exifTakenDate(tc.Data)
+		if ok != !tc.Expected.IsZero() || !taken.Equal(tc.Expected) {
+			t.Errorf("wrong taken date for %s: got %v %v want %v", tc.Name, taken, ok, tc.Expected)
+		}
+	}
+
+	// Uploads are returned to their start after reading the date
+	upload := bytes.NewReader(tt[0].Data)
+	if taken := readTakenDate(upload, "image/jpeg", modified); !taken.Equal(original) {
+		t.Errorf("wrong upload taken date: got %v want %v", taken, original)
+	}
+	if upload.Len() != len(tt[0].Data) {
+		t.Errorf("upload not returned to its start")
+	}
+	if taken := readTakenDate(bytes.NewReader(tt[0].Data), "image/png", modified); !taken.Equal(modified) {
+		t.Errorf("wrong fallback for non jpeg upload: got %v want %v", taken, modified)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+/*
+	This file serves the complete EXIF metadata of an image for photo forensics. Unlike the taken
+	date summarized on the image meta, every entry of every directory is returned with its raw tag,
+	type and decoded value. The orient processor rewrites rotated JPEGs without EXIF data so it
+	keeps the original EXIF structure beneath EXIF_DIR, other images are read from the stored file.
+	Only the owner and accounts the image is explicitly shared with may read it, as EXIF data may
+	reveal locations and devices that the shareable flag never published.
+*/
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	EXIF_DIR = "exif" // Sub directory of the user image directory holding EXIF data removed from rewritten images
+
+	exifGPSPointerTag     = 0x8825 // Offset of the GPS IFD, in IFD0
+	exifInteropPointerTag = 0xA005 // Offset of the Interoperability IFD, in the Exif sub IFD
+)
+
+// tiffTypes names each TIFF entry type and the size in bytes of its values
+var tiffTypes = map[uint16]struct {
+	Name string
+	Size uint64
+}{
+	1: {"BYTE", 1}, 2: {"ASCII", 1}, 3: {"SHORT", 2}, 4: {"LONG", 4}, 5: {"RATIONAL", 8}, 6: {"SBYTE", 1},
+	7: {"UNDEFINED", 1}, 8: {"SSHORT", 2}, 9: {"SLONG", 4}, 10: {"SRATIONAL", 8}, 11: {"FLOAT", 4}, 12: {"DOUBLE", 8},
+}
+
+// exifTagNames holds the names of well known tags of each directory, GPS tags reuse the numbers of other directories
+var exifTagNames = map[string]map[uint16]string{
+	"IFD0": {
+		0x010E: "ImageDescription", 0x010F: "Make", 0x0110: "Model", 0x0112: "Orientation", 0x011A: "XResolution",
+		0x011B: "YResolution", 0x0128: "ResolutionUnit", 0x0131: "Software", 0x0132: "DateTime", 0x013B: "Artist",
+		0x0213: "YCbCrPositioning", 0x8298: "Copyright", 0x8769: "ExifIFDPointer", 0x8825: "GPSInfoIFDPointer",
+	},
+	"Exif": {
+		0x829A: "ExposureTime", 0x829D: "FNumber", 0x8822: "ExposureProgram", 0x8827: "ISOSpeedRatings",
+		0x9000: "ExifVersion", 0x9003: "DateTimeOriginal", 0x9004: "DateTimeDigitized", 0x9010: "OffsetTime",
+		0x9011: "OffsetTimeOriginal", 0x9201: "ShutterSpeedValue", 0x9202: "ApertureValue", 0x9204: "ExposureBiasValue",
+		0x9207: "MeteringMode", 0x9209: "Flash", 0x920A: "FocalLength", 0x927C: "MakerNote", 0x9286: "UserComment",
+		0x9290: "SubSecTime", 0x9291: "SubSecTimeOriginal", 0xA001: "ColorSpace", 0xA002: "PixelXDimension",
+		0xA003: "PixelYDimension", 0xA005: "InteroperabilityIFDPointer", 0xA402: "ExposureMode", 0xA403: "WhiteBalance",
+		0xA405: "FocalLengthIn35mmFilm", 0xA406: "SceneCaptureType", 0xA420: "ImageUniqueID", 0xA431: "BodySerialNumber",
+		0xA433: "LensMake", 0xA434: "LensModel", 0xA435: "LensSerialNumber",
+	},
+	"GPS": {
+		0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude", 0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude", 0x0005: "GPSAltitudeRef", 0x0006: "GPSAltitude", 0x0007: "GPSTimeStamp",
+		0x0010: "GPSImgDirectionRef", 0x0011: "GPSImgDirection", 0x0012: "GPSMapDatum", 0x001D: "GPSDateStamp",
+	},
+	"Interop": {
+		0x0001: "InteroperabilityIndex", 0x0002: "InteroperabilityVersion",
+	},
+	"IFD1": {
+		0x0103: "Compression", 0x011A: "XResolution", 0x011B: "YResolution", 0x0128: "ResolutionUnit",
+		0x0201: "JPEGInterchangeFormat", 0x0202: "JPEGInterchangeFormatLength",
+	},
+}
+
+// ExifField is a decoded entry of an EXIF directory
+type ExifField struct {
+	Tag   string      `json:"tag"` // Tag number in hexadecimal such as 0x010F
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Count uint32      `json:"count"`
+	Value interface{} `json:"value"` // Numbers, lists of numbers, [numerator, denominator] rationals, text or hex encoded bytes
+}
+
+// ExifResp holds every directory of an image's EXIF data by name, empty if it has none
+type ExifResp struct {
+	ImageId     int32                  `json:"imageId"`
+	ByteOrder   string                 `json:"byteOrder,omitempty"`
+	Directories map[string][]ExifField `json:"directories"`
+}
+
+// exifKey returns the storage key of the EXIF data kept for an image whose file was rewritten without it
+func exifKey(image Image) string {
+	return fmt.Sprintf("%v/%s/%v.tiff", image.Uid, EXIF_DIR, image.Id)
+}
+
+// tiffValue decodes the values of an entry, single values are returned alone
+func tiffValue(tiff []byte, order binary.ByteOrder, entry tiffEntry) (interface{}, bool) {
+	typ, ok := tiffTypes[entry.Type]
+	if !ok || entry.Count == 0 {
+		return nil, false
+	}
+	if entry.Type == tiffTypeASCII {
+		return tiffString(tiff, order, entry)
+	}
+
+	size := typ.Size * uint64(entry.Count)
+	data := entry.Value
+	if size > 4 {
+		offset := uint64(order.Uint32(entry.Value))
+		if offset+size > uint64(len(tiff)) {
+			return nil, false
+		}
+		data = tiff[offset : offset+size]
+	}
+	data = data[:size]
+	if entry.Type == 7 {
+		return hex.EncodeToString(data), true
+	}
+
+	values := make([]interface{}, entry.Count)
+	for i := range values {
+		value := data[uint64(i)*typ.Size:]
+		switch entry.Type {
+		case 1:
+			values[i] = value[0]
+		case 3:
+			values[i] = order.Uint16(value)
+		case 4:
+			values[i] = order.Uint32(value)
+		case 5:
+			values[i] = []uint32{order.Uint32(value), order.Uint32(value[4:])}
+		case 6:
+			values[i] = int8(value[0])
+		case 8:
+			values[i] = int16(order.Uint16(value))
+		case 9:
+			values[i] = int32(order.Uint32(value))
+		case 10:
+			values[i] = []int32{int32(order.Uint32(value)), int32(order.Uint32(value[4:]))}
+		case 11:
+			values[i] = jsonFloat(float64(math.Float32frombits(order.Uint32(value))))
+		case 12:
+			values[i] = jsonFloat(math.Float64frombits(order.Uint64(value)))
+		}
+	}
+	if len(values) == 1 {
+		return values[0], true
+	}
+	return values, true
+}
+
+// jsonFloat returns the value unless json can't represent it
+func jsonFloat(value float64) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return value
+}
+
+// nextDirectory returns the offset of the directory linked after the directory at the offset, 0 if there is none
+func nextDirectory(tiff []byte, order binary.ByteOrder, offset uint32) uint32 {
+	ifd := int(offset)
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	next := ifd + 2 + int(order.Uint16(tiff[ifd:]))*12
+	if next+4 > len(tiff) {
+		return 0
+	}
+	return order.Uint32(tiff[next:])
+}
+
+// parseExif decodes every entry of the directories of the TIFF structure, directories are visited once
+// so malformed data pointing back at earlier directories can't loop
+func parseExif(tiff []byte) (string, map[string][]ExifField) {
+	directories := map[string][]ExifField{}
+	order := tiffOrder(tiff)
+	if order == nil {
+		return "", directories
+	}
+	byteOrder := "big-endian"
+	if order == binary.LittleEndian {
+		byteOrder = "little-endian"
+	}
+
+	visited := map[uint32]bool{}
+	var visit func(name string, offset uint32)
+	visit = func(name string, offset uint32) {
+		if visited[offset] {
+			return
+		}
+		visited[offset] = true
+
+		entries := tiffDirectory(tiff, order, offset)
+		if entries == nil {
+			return
+		}
+		fields := []ExifField{}
+		pointers := map[string]uint32{}
+		for _, entry := range entries {
+			field := ExifField{
+				Tag:   fmt.Sprintf("0x%04X", entry.Tag),
+				Name:  exifTagNames[name][entry.Tag],
+				Type:  tiffTypes[entry.Type].Name,
+				Count: entry.Count,
+			}
+			if len(field.Type) == 0 {
+				field.Type = strconv.Itoa(int(entry.Type))
+			}
+			field.Value, _ = tiffValue(tiff, order, entry)
+			fields = append(fields, field)
+
+			if entry.Type == tiffTypeLong {
+				switch {
+				case name == "IFD0" && entry.Tag == exifIFDPointerTag:
+					pointers["Exif"] = order.Uint32(entry.Value)
+				case name == "IFD0" && entry.Tag == exifGPSPointerTag:
+					pointers["GPS"] = order.Uint32(entry.Value)
+				case name == "Exif" && entry.Tag == exifInteropPointerTag:
+					pointers["Interop"] = order.Uint32(entry.Value)
+				}
+			}
+		}
+		directories[name] = fields
+
+		for _, sub := range []string{"Exif", "GPS", "Interop"} {
+			if offset, ok := pointers[sub]; ok {
+				visit(sub, offset)
+			}
+		}
+	}
+
+	ifd0 := order.Uint32(tiff[4:])
+	visit("IFD0", ifd0)
+	if next := nextDirectory(tiff, order, ifd0); next != 0 {
+		visit("IFD1", next)
+	}
+	return byteOrder, directories
+}
+
+// readExif returns the EXIF TIFF structure of the image, nil if it has none. The EXIF data kept when
+// the file was rewritten is preferred over the stored file
+func readExif(ctx context.Context, image Image) ([]byte, error) {
+	object, err := storage.Open(ctx, exifKey(image))
+	if err == nil {
+		defer object.Close()
+		return ioutil.ReadAll(io.LimitReader(object, EXIF_READ_LIMIT))
+	}
+	if err != ErrObjectNotFound {
+		return nil, fmt.Errorf("failed to open exif data: %v", err)
+	}
+
+	if image.Encoding != "image/jpeg" {
+		return nil, nil
+	}
+	object, err = storage.Open(ctx, imageKey(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %v", err)
+	}
+	defer object.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(object, EXIF_READ_LIMIT))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	return exifTIFF(data), nil
+}
+
+// getImageExif returns the complete EXIF data of an image the authenticated user owns or was granted access to
+func (s *Server) getImageExif(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for exif data sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		logger.Error("invalid image id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	image, err := s.store.GetImageMeta(req.Context(), int32(id))
+	if err != nil && !strings.Contains(err.Error(), "404 - Not found") {
+		logger.Error("failed to retrieve image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve exif data, try again later"))
+		return
+	}
+	allowed := false
+	if err == nil {
+		allowed, err = s.canViewImage(req.Context(), claims.Uid, image)
+		if err != nil {
+			logger.Error("failed to authorize exif request sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to retrieve exif data, try again later"))
+			return
+		}
+	}
+
+	// Images the user may not view are reported as not found
+	if !allowed {
+		logger.Error("image %v not viewable by user %v sending 404", id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
+		return
+	}
+
+	tiff, err := readExif(req.Context(), image)
+	if err != nil {
+		logger.Error("failed to read exif data of image %v sending 500: %v", image.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve exif data, try again later"))
+		return
+	}
+
+	resp := ExifResp{ImageId: image.Id}
+	resp.ByteOrder, resp.Directories = parseExif(tiff)
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal exif data sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve exif data, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private")
+	w.Write(js)
+}
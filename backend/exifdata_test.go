@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseExif ensures every directory is decoded in either byte order
+func TestParseExif(t *testing.T) {
+	jpg := testImage(t, "jpeg", 8)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		tiff := exifTIFF(withSegment(jpg, exifDateSegment(order, "2020:01:02 09:00:00", "2019:07:14 18:30:05")))
+		_, directories := parseExif(tiff)
+
+		expected := map[string][]ExifField{
+			"IFD0": {
+				{Tag: "0x0132", Name: "DateTime", Type: "ASCII", Count: 20, Value: "2020:01:02 09:00:00"},
+				{Tag: "0x8769", Name: "ExifIFDPointer", Type: "LONG", Count: 1, Value: uint32(38)},
+			},
+			"Exif": {
+				{Tag: "0x9003", Name: "DateTimeOriginal", Type: "ASCII", Count: 20, Value: "2019:07:14 18:30:05"},
+			},
+		}
+		if !reflect.DeepEqual(directories, expected) {
+			t.Errorf("wrong directories in %v order: got %+v", order, directories)
+		}
+	}
+
+	// Data without EXIF has no directories
+	if byteOrder, directories := parseExif(exifTIFF(jpg)); len(byteOrder) > 0 || len(directories) != 0 {
+		t.Errorf("expected no directories: got %s %+v", byteOrder, directories)
+	}
+
+	// A directory linking back to itself is only read once
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 0, 0, 8, 0, 0, 0}
+	if _, directories := parseExif(tiff); len(directories) != 1 {
+		t.Errorf("wrong directories for looping data: got %+v", directories)
+	}
+}
+
+// TestTiffValue ensures values of each type are decoded and out of bounds values are refused
+func TestTiffValue(t *testing.T) {
+	order := binary.BigEndian
+	tiff := make([]byte, 8, 32)
+	tiff = append(tiff, 0, 0, 0, 72, 0, 0, 0, 1) // Rational at offset 8
+	tiff = append(tiff, 0xFF, 0xFF, 0xFF, 0xFE)  // Negative long at offset 16
+	value := func(v uint32) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, v)
+		return b
+	}
+
+	tt := []struct {
+		Entry    tiffEntry
+		Expected interface{}
+		Valid    bool
+	}{
+		{tiffEntry{Type: 3, Count: 2, Value: []byte{0, 1, 0, 2}}, []interface{}{uint16(1), uint16(2)}, true},
+		{tiffEntry{Type: 1, Count: 1, Value: []byte{7, 0, 0, 0}}, uint8(7), true},
+		{tiffEntry{Type: 5, Count: 1, Value: value(8)}, []uint32{72, 1}, true},
+		{tiffEntry{Type: 9, Count: 1, Value: tiff[16:20]}, int32(-2), true},
+		{tiffEntry{Type: 7, Count: 3, Value: []byte{0x30, 0x32, 0x33, 0}}, "303233", true},
+		{tiffEntry{Type: 11, Count: 1, Value: value(math.Float32bits(float32(math.NaN())))}, nil, true},
+		{tiffEntry{Type: 5, Count: 2, Value: value(16)}, nil, false},
+		{tiffEntry{Type: 4, Count: math.MaxUint32, Value: value(8)}, nil, false},
+		{tiffEntry{Type: 13, Count: 1, Value: value(1)}, nil, false},
+	}
+	for _, tc := range tt {
+		got, ok := tiffValue(tiff, order, tc.Entry)
+		if ok != tc.Valid || !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("wrong value of %+v: got %#v %v want %#v", tc.Entry, got, ok, tc.Expected)
+		}
+	}
+}
+
+// TestReadExif ensures EXIF data kept for rewritten images is preferred over the stored file
+func TestReadExif(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	dir := t.TempDir()
+	storage = &localStorage{root: dir}
+
+	ctx := context.Background()
+	image := Image{Id: 3, Uid: 1, Encoding: "image/jpeg"}
+	jpg := withSegment(testImage(t, "jpeg", 8), exifDateSegment(binary.LittleEndian, "2020:01:02 09:00:00", "2019:07:14 18:30:05"))
+	storage.Put(ctx, imageKey(image), bytes.NewReader(jpg), int64(len(jpg)), image.Encoding)
+
+	tiff, err := readExif(ctx, image)
+	if err != nil || !bytes.Equal(tiff, exifTIFF(jpg)) {
+		t.Errorf("wrong exif data from file: got %v %v", len(tiff), err)
+	}
+
+	kept := exifTIFF(withSegment(jpg, exifSegment(binary.BigEndian, 6)))
+	storage.Put(ctx, exifKey(image), bytes.NewReader(kept), int64(len(kept)), "image/tiff")
+	tiff, err = readExif(ctx, image)
+	if err != nil || !bytes.Equal(tiff, kept) {
+		t.Errorf("kept exif data not preferred: got %v %v", len(tiff), err)
+	}
+}
+
+// TestImageExif ensures only the owner and accounts the image is shared with read its EXIF data
+func TestImageExif(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "friend")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, true)
+	exifPath := fmt.Sprintf("/image/%v/exif.json", image.Id)
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("GET", exifPath, "", token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to retrieve exif data: got %v", rr.Code)
+	}
+	resp := ExifResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.ImageId != image.Id || len(resp.Directories) != 0 {
+		t.Errorf("wrong exif data for image without exif: got %+v", resp)
+	}
+
+	// Shareable images are public but their EXIF data is not
+	if rr := send("GET", exifPath, "", friendToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for image not shared with the user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", fmt.Sprintf("/image/%v/shares", image.Id), `{"email": "friend@mail.com"}`, token); rr.Code != http.StatusCreated {
+		t.Fatalf("failed to share image: got %v", rr.Code)
+	}
+	if rr := send("GET", exifPath, "", friendToken); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for image shared with the user: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("GET", "/image/999999999/exif.json", "", token); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for missing image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	EXPORT_BATCH = 500 // Rows read from the database and written to the response at a time
+
+	// Formats of metadata exports
+	EXPORT_CSV    = "csv"
+	EXPORT_NDJSON = "ndjson"
+)
+
+// exportHeader is the header row of csv exports
+var exportHeader = []string{"id", "uid", "title", "ref", "size", "encoding", "shareable", "hash", "tags", "description", "alt_text"}
+
+// exportWriter encodes image metadata to an export format
+type exportWriter interface {
+	Write(images []Image) error
+	Flush() error
+	Close() error
+}
+
+// csvExport writes image metadata as csv rows, tags are comma separated within a single column
+type csvExport struct {
+	writer *csv.Writer
+}
+
+// newCSVExport returns a csv exportWriter and writes the header row
+func newCSVExport(w io.Writer) (*csvExport, error) {
+	export := &csvExport{writer: csv.NewWriter(w)}
+	err := export.writer.Write(exportHeader)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// Write writes a row for each image
+func (e *csvExport) Write(images []Image) error {
+	for _, image := range images {
+		err := e.writer.Write([]string{
+			strconv.Itoa(int(image.Id)),
+			strconv.Itoa(int(image.Uid)),
+			image.Title,
+			image.Ref,
+			strconv.Itoa(int(image.Size)),
+			image.Encoding,
+			strconv.FormatBool(image.Shareable),
+			image.Hash,
+			strings.Join(image.Tags, ","),
+			image.Description,
+			image.AltText,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
+
+// Flush writes buffered rows to the underlying writer
+func (e *csvExport) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// Close flushes the remaining rows, writing the header of exports without rows
+func (e *csvExport) Close() error {
+	return e.Flush()
+}
+
+// ndjsonExport writes image metadata as a json object per line
+type ndjsonExport struct {
+	encoder *json.Encoder
+}
+
+// Write writes a line for each image
+func (e *ndjsonExport) Write(images []Image) error {
+	for _, image := range images {
+		err := e.encoder.Encode(image)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush is a no-op as every line is written as it is encoded
+func (e *ndjsonExport) Flush() error {
+	return nil
+}
+
+// Close is a no-op as every line is written as it is encoded
+func (e *ndjsonExport) Close() error {
+	return nil
+}
+
+// exportFormat returns the requested export format from the format parameter or Accept header
+func exportFormat(req *http.Request) (string, error) {
+	format := strings.ToLower(req.URL.Query().Get("format"))
+	if len(format) == 0 {
+		if strings.Contains(req.Header.Get("Accept"), "text/csv") {
+			return EXPORT_CSV, nil
+		}
+		if strings.Contains(req.Header.Get("Accept"), "application/zip") {
+			return EXPORT_ZIP, nil
+		}
+		return EXPORT_NDJSON, nil
+	}
+	if format != EXPORT_CSV && format != EXPORT_NDJSON && format != EXPORT_ZIP {
+		return "", fmt.Errorf("invalid format %q, use %s, %s or %s", format, EXPORT_CSV, EXPORT_NDJSON, EXPORT_ZIP)
+	}
+	return format, nil
+}
+
+// exportImageMeta streams the metadata of every image of the authenticated user matching
+// the same filters as /image/meta as csv or newline delimited json, or their files as a zip bundle
+func exportImageMeta(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to export sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	format, err := exportFormat(req)
+	if err != nil {
+		logger.Error("invalid export format sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	// Exports are not paged, only filters apply
+	params := req.URL.Query()
+	params.Del("format")
+	params.Del("page")
+
+	// Validate query parameters before the response is started
+	if _, err := imageQueryCondition(claims.Uid, params); err != nil {
+		logger.Error("invalid export query sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	var export exportWriter
+	filename := fmt.Sprintf("image-meta.%s", format)
+	switch format {
+	case EXPORT_CSV:
+		w.Header().Set("Content-Type", "text/csv")
+		export, err = newCSVExport(w)
+	case EXPORT_ZIP:
+		w.Header().Set("Content-Type", "application/zip")
+		export = newZipExport(req.Context(), w, int32(claims.Uid))
+		filename = "images.zip"
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		export = &ndjsonExport{encoder: json.NewEncoder(w)}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	if err != nil {
+		logger.Error("failed to start export sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to export, try again later"))
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	exported := 0
+	err = ExportImageMeta(claims.Uid, params, func(images []Image) error {
+		err := export.Write(images)
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		exported += len(images)
+		return nil
+	})
+	if err != nil && exported == 0 {
+		logger.Error("export for user %v failed sending 500: %v", claims.Uid, err)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to export, try again later"))
+		return
+	}
+	if err != nil {
+		// The status is sent with the first rows so a later failure can only end the stream early
+		logger.Error("export for user %v failed after %v rows: %v", claims.Uid, exported, err)
+		return
+	}
+
+	// Send the csv header of exports without rows and the manifest of zip bundles
+	err = export.Close()
+	if err != nil {
+		logger.Error("failed to complete export for user %v: %v", claims.Uid, err)
+		return
+	}
+
+	logger.Info("Exported %v image meta rows for user %v", exported, claims.Uid)
+}
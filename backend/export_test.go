@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var exportImages = []Image{
+	{Id: 1, Uid: 2, Title: "beach, day.png", Ref: "ref/1", Size: 10, Encoding: "image/png", Shareable: true, Hash: "abc", Tags: []string{"beach", "summer"},
+		Description: "Sunset over the bay,\nfrom the pier", AltText: "Orange sky above calm water"},
+	{Id: 3, Uid: 2, Title: "quote\".jpg", Ref: "ref/3", Size: 20, Encoding: "image/jpeg", Tags: []string{}},
+}
+
+// TestCSVExport ensures csv exports round trip titles, tags and descriptions containing separators
+func TestCSVExport(t *testing.T) {
+	var buf bytes.Buffer
+	export, err := newCSVExport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := export.Write(exportImages); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	expected := [][]string{
+		exportHeader,
+		{"1", "2", "beach, day.png", "ref/1", "10", "image/png", "true", "abc", "beach,summer", "Sunset over the bay,\nfrom the pier", "Orange sky above calm water"},
+		{"3", "2", "quote\".jpg", "ref/3", "20", "image/jpeg", "false", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("wrong csv export: got %v want %v", records, expected)
+	}
+
+	// The header is written for exports without rows
+	buf.Reset()
+	export, _ = newCSVExport(&buf)
+	export.Flush()
+	if got := buf.String(); got != strings.Join(exportHeader, ",")+"\n" {
+		t.Errorf("wrong empty export: got %q", got)
+	}
+}
+
+// TestNDJSONExport ensures each image is written as a json object on its own line
+func TestNDJSONExport(t *testing.T) {
+	var buf bytes.Buffer
+	export := &ndjsonExport{encoder: json.NewEncoder(&buf)}
+	if err := export.Write(exportImages); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(exportImages) {
+		t.Fatalf("wrong number of lines: got %v want %v", len(lines), len(exportImages))
+	}
+	for i, line := range lines {
+		image := Image{}
+		if err := json.Unmarshal([]byte(line), &image); err != nil {
+			t.Fatalf("failed to unmarshal line %v: %v", i, err)
+		}
+		if !reflect.DeepEqual(image, exportImages[i]) {
+			t.Errorf("wrong line %v: got %+v want %+v", i, image, exportImages[i])
+		}
+	}
+}
+
+// TestZipExport ensures bundles hold the file of each image and a manifest with the digest of every
+// file, images whose file is missing are listed as missing
+func TestZipExport(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &localStorage{root: t.TempDir()}
+
+	content := []byte("the file of image 1")
+	err := storage.Put(context.Background(), imageKey(exportImages[0]), bytes.NewReader(content), int64(len(content)), "image/png")
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	export := newZipExport(context.Background(), &buf, 2)
+	if err := export.Write(exportImages); err != nil {
+		t.Fatal(err)
+	}
+	if err := export.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, file := range bundle.File {
+		r, _ := file.Open()
+		files[file.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	if len(files) != 2 || !bytes.Equal(files["images/1-beach, day.png"], content) {
+		t.Fatalf("wrong bundle files: got %v", files)
+	}
+
+	manifest := BundleManifest{}
+	if err := json.Unmarshal(files[BUNDLE_MANIFEST], &manifest); err != nil {
+		t.Fatalf("failed to unmarshal manifest: %v", err)
+	}
+	digest := sha256.Sum256(content)
+	if manifest.Version != BUNDLE_VERSION || manifest.Uid != 2 || manifest.Bytes != int64(len(content)) || len(manifest.Files) != 1 {
+		t.Fatalf("wrong manifest: got %+v", manifest)
+	}
+	file := manifest.Files[0]
+	if file.Path != "images/1-beach, day.png" || file.Size != int64(len(content)) || file.Sha256 != hex.EncodeToString(digest[:]) || file.Image.Id != 1 || file.Image.Description != exportImages[0].Description {
+		t.Errorf("wrong manifest file: got %+v", file)
+	}
+	if len(manifest.Missing) != 1 || manifest.Missing[0].Id != 3 {
+		t.Errorf("wrong missing images: got %+v", manifest.Missing)
+	}
+}
+
+// TestExportFormat ensures the format parameter takes precedence over the Accept header
+func TestExportFormat(t *testing.T) {
+	tt := []struct {
+		Query    string
+		Accept   string
+		Expected string
+		Error    bool
+	}{
+		{"", "", EXPORT_NDJSON, false},
+		{"", "text/csv", EXPORT_CSV, false},
+		{"?format=CSV", "", EXPORT_CSV, false},
+		{"?format=ndjson", "text/csv", EXPORT_NDJSON, false},
+		{"", "application/zip", EXPORT_ZIP, false},
+		{"?format=zip", "text/csv", EXPORT_ZIP, false},
+		{"?format=xml", "", "", true},
+	}
+
+	for _, tc := range tt {
+		req, _ := http.NewRequest("GET", "/image/meta/export"+tc.Query, nil)
+		req.Header.Set("Accept", tc.Accept)
+		format, err := exportFormat(req)
+		if (err != nil) != tc.Error || format != tc.Expected {
+			t.Errorf("wrong format for %q %q: got %q, %v want %q", tc.Query, tc.Accept, format, err, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	FACET_LIMIT = 50 // Maximum values reported for each facet
+)
+
+// FacetCount is the number of matching images with a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"` // Title of the album for album facets whose value is the album id
+	Count int64  `json:"count"`
+}
+
+// Facets summarizes the images matching a query so clients can render filters without extra queries
+// values are ordered by descending count
+type Facets struct {
+	Tags      []FacetCount `json:"tags"`
+	Encodings []FacetCount `json:"encodings"`
+	Albums    []FacetCount `json:"albums"`
+}
+
+// imageFacets counts the tags, encodings and albums of every image matching the condition, not only the current page.
+// Albums are counted if the user owns them or they are shareable so private albums of other users stay hidden
+func imageFacets(db dbtx, uid int, where *whereBuilder) (Facets, error) {
+	tags, err := facetCounts(db, fmt.Sprintf("SELECT tag, '', COUNT(*) FROM %s WHERE image_id IN (SELECT id FROM %s WHERE %s) GROUP BY tag", TAG_TABLE, IMAGE_TABLE, where.String()), where.Args())
+	if err != nil {
+		return Facets{}, fmt.Errorf("failed to count tags: %v", err)
+	}
+
+	encodings, err := facetCounts(db, fmt.Sprintf("SELECT encoding, '', COUNT(*) FROM %s WHERE %s GROUP BY encoding", IMAGE_TABLE, where.String()), where.Args())
+	if err != nil {
+		return Facets{}, fmt.Errorf("failed to count encodings: %v", err)
+	}
+
+	// The uid is bound after the arguments of the condition, which is reused by the query of the page
+	args := append(append([]interface{}{}, where.Args()...), uid)
+	albums, err := facetCounts(db, fmt.Sprintf("SELECT a.id::text, a.title, COUNT(*) FROM %s ai JOIN %s a ON a.id = ai.album_id WHERE ai.image_id IN (SELECT id FROM %s WHERE %s) AND (a.uid = $%d OR a.shareable = true) GROUP BY a.id, a.title",
+		ALBUM_IMAGE_TABLE, ALBUM_TABLE, IMAGE_TABLE, where.String(), len(args)), args)
+	if err != nil {
+		return Facets{}, fmt.Errorf("failed to count albums: %v", err)
+	}
+
+	return Facets{Tags: tags, Encodings: encodings, Albums: albums}, nil
+}
+
+// facetCounts runs a query grouping by a value and its label and returns the most frequent values
+func facetCounts(db dbtx, query string, args []interface{}) ([]FacetCount, error) {
+	rows, err := db.Query(fmt.Sprintf("%s ORDER BY COUNT(*) DESC, 1 LIMIT %v", query, FACET_LIMIT), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		count := FacetCount{}
+		err = rows.Scan(&count.Value, &count.Label, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
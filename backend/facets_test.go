@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestImageFacets ensures facets count every image matching the filters and the albums holding them
+func TestImageFacets(t *testing.T) {
+	token, uid := getTestToken(t)
+
+	router := configureRoutes()
+	images := []Image{uploadTestImage(t, router, token, false), uploadTestImage(t, router, token, false)}
+	defer func() {
+		for _, image := range images {
+			req, _ := http.NewRequest("DELETE", strings.TrimPrefix(image.Ref, REF_URL), nil)
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}()
+	SetImageTags(context.Background(), images[0].Id, []string{"beach", "summer"})
+	SetImageTags(context.Background(), images[1].Id, []string{"beach"})
+
+	now := time.Now().UTC()
+	album := Album{Uid: int32(uid), Title: "Holiday", Created: now, Updated: now}
+	albumId, err := AddAlbum(album)
+	if err != nil {
+		t.Fatalf("failed to add album: %v", err)
+	}
+	album.Id = albumId
+	if err = AddAlbumImages(album, []int32{images[0].Id}, 0); err != nil {
+		t.Fatalf("failed to add album images: %v", err)
+	}
+	albumFacet := FacetCount{Value: strconv.Itoa(int(albumId)), Label: "Holiday", Count: 1}
+
+	tt := []struct {
+		Query    string
+		Expected Facets
+	}{
+		{"", Facets{
+			Tags:      []FacetCount{{Value: "beach", Count: 2}, {Value: "summer", Count: 1}},
+			Encodings: []FacetCount{{Value: "image/png", Count: 2}},
+			Albums:    []FacetCount{albumFacet},
+		}},
+		{"?tags=summer", Facets{
+			Tags:      []FacetCount{{Value: "beach", Count: 1}, {Value: "summer", Count: 1}},
+			Encodings: []FacetCount{{Value: "image/png", Count: 1}},
+			Albums:    []FacetCount{albumFacet},
+		}},
+		{fmt.Sprintf("?id=%v", images[1].Id), Facets{
+			Tags:      []FacetCount{{Value: "beach", Count: 1}},
+			Encodings: []FacetCount{{Value: "image/png", Count: 1}},
+			Albums:    []FacetCount{},
+		}},
+	}
+
+	for _, tc := range tt {
+		req, _ := http.NewRequest("GET", "/image/meta"+tc.Query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong code for %q: got %v want %v", tc.Query, status, http.StatusOK)
+		}
+
+		resp := QueryResp{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if !reflect.DeepEqual(resp.Facets, tc.Expected) {
+			t.Errorf("wrong facets for %q: got %+v want %+v", tc.Query, resp.Facets, tc.Expected)
+		}
+	}
+}
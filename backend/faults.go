@@ -0,0 +1,199 @@
+package main
+
+/*
+	This file implements fault injection for resilience testing. When FAULT_INJECTION is true
+	administrators can configure the database and storage layers to fail or slow down a percentage
+	of calls, letting client retry behavior be exercised against a running instance. Fault rules
+	are kept in memory by each instance and injection is unavailable unless explicitly enabled,
+	it must never be enabled in production.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	FAULT_DATABASE = "database" // Faults injected when store functions connect to the database
+	FAULT_STORAGE  = "storage"  // Faults injected into every storage driver call
+
+	FAULT_MAX_LATENCY = 30000 // Milliseconds of latency that may be injected
+)
+
+// ErrInjectedFault is returned by calls failed by fault injection
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultRule describes the faults injected into a layer, percentages apply to each call independently
+type FaultRule struct {
+	ErrorPercent   int `json:"errorPercent"`
+	LatencyMs      int `json:"latencyMs"`
+	LatencyPercent int `json:"latencyPercent"`
+}
+
+// faults holds the rules of each layer, empty unless configured by an administrator
+var faults = struct {
+	sync.RWMutex
+	rules map[string]FaultRule
+}{rules: map[string]FaultRule{}}
+
+// faultInjectionEnabled reports whether fault injection may be configured on this instance
+func faultInjectionEnabled() bool {
+	return os.Getenv("FAULT_INJECTION") == "true"
+}
+
+// injectFault applies the rule of the layer to a call, delaying it or returning ErrInjectedFault
+// latency ends early if the context is cancelled
+func injectFault(ctx context.Context, layer string) error {
+	faults.RLock()
+	rule, ok := faults.rules[layer]
+	faults.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if rule.LatencyMs > 0 && rand.Intn(100) < rule.LatencyPercent {
+		timer := time.NewTimer(time.Duration(rule.LatencyMs) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if rand.Intn(100) < rule.ErrorPercent {
+		return fmt.Errorf("%s: %v", layer, ErrInjectedFault)
+	}
+	return nil
+}
+
+// validate ensures percentages are between 0 and 100 and latency within FAULT_MAX_LATENCY
+func (r FaultRule) validate() error {
+	if r.ErrorPercent < 0 || r.ErrorPercent > 100 || r.LatencyPercent < 0 || r.LatencyPercent > 100 {
+		return fmt.Errorf("percentages must be from 0 to 100")
+	}
+	if r.LatencyMs < 0 || r.LatencyMs > FAULT_MAX_LATENCY {
+		return fmt.Errorf("latencyMs must be from 0 to %v", FAULT_MAX_LATENCY)
+	}
+	return nil
+}
+
+// faultStorage injects the storage faults into every call of the wrapped driver
+type faultStorage struct {
+	Storage
+}
+
+func (s *faultStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := injectFault(ctx, FAULT_STORAGE); err != nil {
+		return err
+	}
+	return s.Storage.Put(ctx, key, r, size, contentType)
+}
+
+func (s *faultStorage) Open(ctx context.Context, key string) (Object, error) {
+	if err := injectFault(ctx, FAULT_STORAGE); err != nil {
+		return nil, err
+	}
+	return s.Storage.Open(ctx, key)
+}
+
+func (s *faultStorage) Delete(ctx context.Context, key string) error {
+	if err := injectFault(ctx, FAULT_STORAGE); err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+// getFaults responds with the fault rules of each layer
+func getFaults(w http.ResponseWriter, req *http.Request) {
+	if !authFaultRequest(w, req) {
+		return
+	}
+
+	writeFaults(w)
+}
+
+// updateFaults accepts a json object of fault rules keyed by layer, database or storage,
+// and replaces the rules of the instance. Layers that are omitted have no faults
+func updateFaults(w http.ResponseWriter, req *http.Request) {
+	if !authFaultRequest(w, req) {
+		return
+	}
+
+	rules := map[string]FaultRule{}
+	if req.Method == "PUT" {
+		err := json.NewDecoder(req.Body).Decode(&rules)
+		if err != nil {
+			logger.Error("failed to demarshal json body sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - unable to parse json, check your request"))
+			return
+		}
+	}
+
+	for layer, rule := range rules {
+		err := rule.validate()
+		if layer != FAULT_DATABASE && layer != FAULT_STORAGE {
+			err = fmt.Errorf("unknown layer %q, use %s or %s", layer, FAULT_DATABASE, FAULT_STORAGE)
+		}
+		if err != nil {
+			logger.Error("invalid fault rules sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+			return
+		}
+	}
+
+	faults.Lock()
+	faults.rules = rules
+	faults.Unlock()
+
+	logger.Warning("Fault injection rules replaced: %+v", rules)
+	writeFaults(w)
+}
+
+// authFaultRequest authenticates the administrator and ensures fault injection is enabled
+// writes the error response and returns false otherwise
+func authFaultRequest(w http.ResponseWriter, req *http.Request) bool {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for fault injection: %v", err)
+		writeAdminAuthError(w, err)
+		return false
+	}
+
+	if !faultInjectionEnabled() {
+		logger.Error("fault injection is disabled sending 404")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, fault injection is disabled on this instance"))
+		return false
+	}
+
+	return true
+}
+
+// writeFaults writes the fault rules as the json response body
+func writeFaults(w http.ResponseWriter) {
+	faults.RLock()
+	js, err := json.Marshal(faults.rules)
+	faults.RUnlock()
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestInjectFault ensures rules fail or delay calls to their layer only
+func TestInjectFault(t *testing.T) {
+	defer func() { faults.rules = map[string]FaultRule{} }()
+	faults.rules = map[string]FaultRule{
+		FAULT_STORAGE:  {ErrorPercent: 100},
+		FAULT_DATABASE: {LatencyMs: 20, LatencyPercent: 100},
+	}
+
+	if err := injectFault(context.Background(), FAULT_STORAGE); err == nil {
+		t.Errorf("expected storage fault")
+	}
+
+	start := time.Now()
+	if err := injectFault(context.Background(), FAULT_DATABASE); err != nil {
+		t.Errorf("expected database call to succeed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected database latency of 20ms: got %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := injectFault(ctx, FAULT_DATABASE); err != context.Canceled {
+		t.Errorf("expected latency to end with the context: got %v", err)
+	}
+
+	storage := &faultStorage{&localStorage{root: "unused"}}
+	if _, err := storage.Open(context.Background(), "1/1.png"); err == nil {
+		t.Errorf("expected storage driver call to fail")
+	}
+}
+
+// TestUpdateFaults ensures rules are only configurable by administrators on instances with fault injection enabled
+// None of the evaluated requests reach the database
+func TestUpdateFaults(t *testing.T) {
+	defer func() { faults.rules = map[string]FaultRule{} }()
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+	defer os.Unsetenv("FAULT_INJECTION")
+
+	router := configureRoutes()
+	serve := func(uid int, method string, body string) int {
+		token, _, err := generateJWT(uid, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req := httptest.NewRequest(method, "/admin/faults", bytes.NewBufferString(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	valid := `{"storage": {"errorPercent": 10, "latencyMs": 200, "latencyPercent": 50}}`
+	if code := serve(7, "PUT", valid); code != http.StatusNotFound {
+		t.Errorf("wrong code while disabled: got %v want %v", code, http.StatusNotFound)
+	}
+
+	os.Setenv("FAULT_INJECTION", "true")
+	tt := []struct {
+		Uid      int
+		Method   string
+		Body     string
+		Expected int
+	}{
+		{1, "PUT", valid, http.StatusForbidden},
+		{7, "PUT", `{"cache": {"errorPercent": 10}}`, http.StatusBadRequest},
+		{7, "PUT", `{"database": {"errorPercent": 101}}`, http.StatusBadRequest},
+		{7, "PUT", `{"database": {"latencyMs": 60000, "latencyPercent": 10}}`, http.StatusBadRequest},
+		{7, "PUT", valid, http.StatusOK},
+		{7, "GET", "", http.StatusOK},
+	}
+	for _, tc := range tt {
+		if code := serve(tc.Uid, tc.Method, tc.Body); code != tc.Expected {
+			t.Errorf("wrong code for %s %s by %v: got %v want %v", tc.Method, tc.Body, tc.Uid, code, tc.Expected)
+		}
+	}
+	if rule := faults.rules[FAULT_STORAGE]; rule.ErrorPercent != 10 || rule.LatencyMs != 200 {
+		t.Errorf("wrong storage rule: got %+v", rule)
+	}
+
+	if code := serve(7, "DELETE", ""); code != http.StatusOK || len(faults.rules) > 0 {
+		t.Errorf("expected rules to be cleared: got %v with %v", code, faults.rules)
+	}
+}
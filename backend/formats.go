@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/inflowml/logger"
+	_ "golang.org/x/image/webp" // Registers the webp decoder with image.Decode
+)
+
+const (
+	UPLOAD_TYPES = "image/jpeg,image/png,image/webp,image/gif" // Default if UPLOAD_TYPES env variable is not defined
+)
+
+// ErrUnsupportedEncoding is returned when an image must be rewritten in an encoding processors cannot write
+var ErrUnsupportedEncoding = errors.New("image encoding cannot be processed")
+
+// SUPPORTED_TYPES are the image encodings the server can identify and store
+// the types accepted for upload are the subset listed in UPLOAD_TYPES
+var SUPPORTED_TYPES = []string{"image/jpeg", "image/png", "image/webp", "image/gif", "image/avif"}
+
+// DECODABLE_TYPES are the encodings processors can read, ENCODABLE_TYPES those they can write
+// no encoder is available for webp so webp images can be converted to other formats but not produced
+var DECODABLE_TYPES = []string{"image/jpeg", "image/png", "image/webp", "image/gif"}
+var ENCODABLE_TYPES = []string{"image/jpeg", "image/png", "image/gif"}
+
+// acceptedTypes returns the supported types listed in the comma separated UPLOAD_TYPES environment variable
+func acceptedTypes() []string {
+	types := os.Getenv("UPLOAD_TYPES")
+	if len(types) == 0 {
+		types = UPLOAD_TYPES
+	}
+
+	accepted := []string{}
+	for _, typ := range strings.Split(types, ",") {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		if len(typ) == 0 || containsString(accepted, typ) {
+			continue
+		}
+		if !containsString(SUPPORTED_TYPES, typ) {
+			logger.Warning("ignoring unsupported upload type %s", typ)
+			continue
+		}
+		accepted = append(accepted, typ)
+	}
+
+	return accepted
+}
+
+// detectImageType returns the media type of the file from its first 512 bytes
+// AVIF is identified by its ISO BMFF brand as http.DetectContentType does not recognize it
+func detectImageType(header []byte) string {
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		brand := string(header[8:12])
+		if brand == "avif" || brand == "avis" {
+			return "image/avif"
+		}
+	}
+	return http.DetectContentType(header)
+}
+
+// canDecode reports whether processors can decode images of the encoding
+func canDecode(encoding string) bool {
+	return containsString(DECODABLE_TYPES, encoding)
+}
+
+// canEncode reports whether processors can write images of the encoding
+func canEncode(encoding string) bool {
+	return containsString(ENCODABLE_TYPES, encoding)
+}
+
+// formatEncoding returns the supported encoding named by a format such as webp or jpg
+func formatEncoding(format string) (string, error) {
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	encoding := "image/" + format
+	if !containsString(SUPPORTED_TYPES, encoding) {
+		return "", fmt.Errorf("unknown format %s", format)
+	}
+	return encoding, nil
+}
+
+// encodeImage writes the image in the encoding, which must satisfy canEncode
+// animated gifs are written as their first frame and transparency is written white in jpeg
+func encodeImage(w io.Writer, img image.Image, encoding string) error {
+	switch encoding {
+	case "image/png":
+		return png.Encode(w, img)
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, flattenImage(img), nil)
+	}
+}
+
+// flattenImage draws images with transparency over a white background
+func flattenImage(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestAcceptedTypes ensures UPLOAD_TYPES configures the accepted types ignoring unsupported ones
+func TestAcceptedTypes(t *testing.T) {
+	defer os.Unsetenv("UPLOAD_TYPES")
+
+	tt := []struct {
+		Env      string
+		Expected []string
+	}{
+		{"", []string{"image/jpeg", "image/png", "image/webp", "image/gif"}},
+		{"image/png", []string{"image/png"}},
+		{" image/JPEG , image/avif,image/jpeg", []string{"image/jpeg", "image/avif"}},
+		{"image/png,image/tiff", []string{"image/png"}},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("UPLOAD_TYPES", tc.Env)
+		if types := acceptedTypes(); !reflect.DeepEqual(types, tc.Expected) {
+			t.Errorf("wrong accepted types for %q: got %v want %v", tc.Env, types, tc.Expected)
+		}
+	}
+}
+
+// TestDetectImageType ensures each supported encoding is identified from the file header
+func TestDetectImageType(t *testing.T) {
+	tt := []struct {
+		Header   []byte
+		Expected string
+	}{
+		{[]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00"), "image/gif"},
+		{[]byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{[]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"), "image/avif"},
+		{[]byte("\x00\x00\x00\x1cftypavis\x00\x00\x00\x00"), "image/avif"},
+		{[]byte("\x00\x00\x00\x1cftypisom\x00\x00\x00\x00"), "application/octet-stream"},
+		{[]byte("not an image"), "text/plain; charset=utf-8"},
+	}
+
+	for _, tc := range tt {
+		if typ := detectImageType(tc.Header); typ != tc.Expected {
+			t.Errorf("wrong type for header %q: got %s want %s", tc.Header, typ, tc.Expected)
+		}
+	}
+}
+
+// TestEncodeImage ensures images are written in each encoding processors can write
+func TestEncodeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	src.Set(1, 1, color.RGBA{255, 0, 0, 255})
+
+	for _, encoding := range []string{"image/jpeg", "image/png", "image/gif"} {
+		if !canDecode(encoding) || !canEncode(encoding) {
+			t.Errorf("expected %s to be decodable and encodable", encoding)
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		if err := encodeImage(buf, src, encoding); err != nil {
+			t.Errorf("failed to encode %s: %v", encoding, err)
+			continue
+		}
+		if typ := detectImageType(buf.Bytes()); typ != encoding {
+			t.Errorf("wrong encoding written: got %s want %s", typ, encoding)
+		}
+		if _, _, err := image.Decode(buf); err != nil {
+			t.Errorf("failed to decode %s: %v", encoding, err)
+		}
+	}
+
+	if canEncode("image/webp") || canDecode("image/avif") {
+		t.Errorf("expected webp to be read only and avif unsupported by processors")
+	}
+}
+
+// TestEncodeImageTransparency ensures transparent images converted to jpeg are drawn over white
+func TestEncodeImageTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	buf := new(bytes.Buffer)
+	if err := encodeImage(buf, src, "image/jpeg"); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	decoded, _, err := image.Decode(buf)
+	if err != nil {
+		t.Fatalf("failed to decode jpeg: %v", err)
+	}
+	if r, g, b, _ := decoded.At(4, 4).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("expected transparent pixels to be white, got %v %v %v", r>>8, g>>8, b>>8)
+	}
+}
@@ -4,10 +4,10 @@ go 1.12
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
 	github.com/gorilla/mux v1.8.0
 	github.com/inflowml/logger v0.0.0-20200116190108-13c1a230c7d2
-	github.com/inflowml/structql v0.0.0-20210920052100-bd0dd24c8915
-	github.com/lib/pq v1.10.3 // indirect
-	golang.org/x/crypto v0.0.0-20210915214749-c084706c2272
+	github.com/lib/pq v1.10.3
+	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
 )
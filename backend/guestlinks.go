@@ -0,0 +1,456 @@
+package main
+
+/*
+	This file implements guest upload links, drop boxes letting people without an account add
+	photos to an album, for example the guests of an event. The album owner creates a link limiting
+	the number of uploads, the size of each file and how long the link stays valid. Anyone holding
+	the link may upload until a limit is reached, uploads belong to the owner, count against the
+	owner's quota and are appended to the album. Only the sha256 hash of the link token is stored
+	so the token is returned once when the link is created.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	GUEST_LINK_TABLE = "guest_link"
+
+	GUEST_LINK_UPLOADS     = 50          // Uploads allowed by a link unless the owner chooses otherwise
+	GUEST_LINK_UPLOADS_MAX = 1000        // Most uploads a single link may allow
+	GUEST_LINK_TTL         = 72          // Hours a link is valid unless the owner chooses otherwise
+	GUEST_LINK_TTL_MAX     = 30 * 24     // Most hours a link may be valid
+	GUEST_LINK_MAX         = 20          // Links an album may have at once
+	GUEST_LINK_LABEL_MAX   = 100         // Characters allowed in the label of a link
+	GUEST_LINK_RETENTION   = 7 * 24      // Hours expired links are listed before they are purged
+	GUEST_TOKEN_BYTES      = 32          // Random bytes of a link token
+	GUEST_PURGE_INTERVAL   = time.Hour   // Interval between purges of expired links
+	GUEST_PATH             = "/guest/%s" // Path of the drop box of a token
+)
+
+var (
+	// ErrGuestLinkExhausted is returned when a link expired or its uploads are used up
+	ErrGuestLinkExhausted = errors.New("guest link expired or used up")
+)
+
+// GuestLink lets unauthenticated guests upload into an album tagged for sql serialization
+type GuestLink struct {
+	Id         int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid        int32     `sql:"uid"`
+	AlbumId    int32     `sql:"album_id"`
+	TokenHash  string    `sql:"token_hash" opt:"UNIQUE"`
+	Label      string    `sql:"label"`
+	MaxUploads int32     `sql:"max_uploads"`
+	Uploads    int32     `sql:"uploads"`
+	MaxBytes   int64     `sql:"max_bytes"` // Largest file accepted
+	Expires    time.Time `sql:"expires"`
+	Created    time.Time `sql:"created"`
+}
+
+// GuestLinkParams are the limits requested for a new link, omitted limits use the defaults
+type GuestLinkParams struct {
+	Label      string `json:"label"`
+	MaxUploads int32  `json:"maxUploads"`
+	MaxBytes   int64  `json:"maxBytes"`
+	ExpiresIn  int    `json:"expiresIn"` // Hours
+}
+
+// GuestLinkResp describes a link to its owner, the token and url are only returned when it is created
+type GuestLinkResp struct {
+	Id         int32     `json:"id"`
+	AlbumId    int32     `json:"albumId"`
+	Label      string    `json:"label"`
+	Url        string    `json:"url,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	MaxUploads int32     `json:"maxUploads"`
+	Uploads    int32     `json:"uploads"`
+	MaxBytes   int64     `json:"maxBytes"`
+	Active     bool      `json:"active"`
+	Expires    Timestamp `json:"expires"`
+	Created    Timestamp `json:"created"`
+}
+
+// DropBoxResp describes a link to the guests holding it without identifying the owner
+type DropBoxResp struct {
+	Album     string    `json:"album"`
+	Label     string    `json:"label"`
+	Remaining int32     `json:"remaining"`
+	MaxBytes  int64     `json:"maxBytes"`
+	Types     []string  `json:"types"`
+	Expires   Timestamp `json:"expires"`
+}
+
+// GuestUploadResp describes an image uploaded by a guest
+type GuestUploadResp struct {
+	Id        int32  `json:"id"`
+	Title     string `json:"title"`
+	Size      int32  `json:"size"`
+	Encoding  string `json:"encoding"`
+	Remaining int32  `json:"remaining"`
+}
+
+func init() {
+	RegisterPurgeJob("guest-links", GUEST_PURGE_INTERVAL, GUEST_LINK_TABLE, "expires < $1", func() []interface{} {
+		return []interface{}{time.Now().UTC().Add(-GUEST_LINK_RETENTION * time.Hour)}
+	})
+}
+
+// active reports whether guests may still upload through the link
+func (l GuestLink) active(now time.Time) bool {
+	return l.Uploads < l.MaxUploads && now.Before(l.Expires)
+}
+
+// guestLinkResp returns the response describing the link to its owner
+func guestLinkResp(link GuestLink) GuestLinkResp {
+	return GuestLinkResp{
+		Id:         link.Id,
+		AlbumId:    link.AlbumId,
+		Label:      link.Label,
+		MaxUploads: link.MaxUploads,
+		Uploads:    link.Uploads,
+		MaxBytes:   link.MaxBytes,
+		Active:     link.active(time.Now().UTC()),
+		Expires:    Timestamp(link.Expires),
+		Created:    Timestamp(link.Created),
+	}
+}
+
+// newGuestLink validates the requested limits and returns the link they describe,
+// limits are never raised above the server upload limit or the maxima above
+func (p GuestLinkParams) newGuestLink(album Album, now time.Time) (GuestLink, error) {
+	link := GuestLink{
+		Uid:        album.Uid,
+		AlbumId:    album.Id,
+		Label:      strings.TrimSpace(cleanText(p.Label, false)),
+		MaxUploads: GUEST_LINK_UPLOADS,
+		MaxBytes:   getMaxUploadBytes(),
+		Expires:    now.Add(GUEST_LINK_TTL * time.Hour),
+		Created:    now,
+	}
+
+	if utf8.RuneCountInString(link.Label) > GUEST_LINK_LABEL_MAX {
+		return GuestLink{}, fmt.Errorf("label may not exceed %v characters", GUEST_LINK_LABEL_MAX)
+	}
+	if p.MaxUploads != 0 {
+		if p.MaxUploads < 0 || p.MaxUploads > GUEST_LINK_UPLOADS_MAX {
+			return GuestLink{}, fmt.Errorf("maxUploads must be from 1 to %v", GUEST_LINK_UPLOADS_MAX)
+		}
+		link.MaxUploads = p.MaxUploads
+	}
+	if p.MaxBytes != 0 {
+		if p.MaxBytes < 0 || p.MaxBytes > link.MaxBytes {
+			return GuestLink{}, fmt.Errorf("maxBytes must be from 1 to %v", link.MaxBytes)
+		}
+		link.MaxBytes = p.MaxBytes
+	}
+	if p.ExpiresIn != 0 {
+		if p.ExpiresIn < 0 || p.ExpiresIn > GUEST_LINK_TTL_MAX {
+			return GuestLink{}, fmt.Errorf("expiresIn must be from 1 to %v hours", GUEST_LINK_TTL_MAX)
+		}
+		link.Expires = now.Add(time.Duration(p.ExpiresIn) * time.Hour)
+	}
+
+	return link, nil
+}
+
+// newGuestToken returns a random link token and the hash stored in its place
+func newGuestToken() (string, string, error) {
+	buf := make([]byte, GUEST_TOKEN_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate guest token: %v", err)
+	}
+	token := hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// guestUrl returns the url of the drop box of the token in the API version of the request
+func guestUrl(req *http.Request, token string) string {
+	refUrl := os.Getenv("REF_URL")
+	if len(refUrl) == 0 {
+		refUrl = REF_URL
+	}
+	return refUrl + versionPrefix(req) + fmt.Sprintf(GUEST_PATH, token)
+}
+
+// createGuestLink accepts a json body with the limits of a new guest link to the album
+func createGuestLink(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	// Limits are optional, an empty body receives the defaults
+	params := GuestLinkParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	link, err := params.newGuestLink(album, time.Now().UTC())
+	if err != nil {
+		logger.Error("invalid guest link sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	links, err := AlbumGuestLinks(album.Id)
+	if err != nil {
+		logger.Error("failed to retrieve guest links sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create guest link, try again later"))
+		return
+	}
+	if len(links) >= GUEST_LINK_MAX {
+		logger.Error("album %v has %v guest links sending 409", album.Id, len(links))
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - An album may have at most %v guest links, delete one first", GUEST_LINK_MAX)))
+		return
+	}
+
+	token, hash, err := newGuestToken()
+	if err == nil {
+		link.TokenHash = hash
+		link.Id, err = AddGuestLink(link)
+	}
+	if err != nil {
+		logger.Error("failed to add guest link sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create guest link, try again later"))
+		return
+	}
+
+	resp := guestLinkResp(link)
+	resp.Token = token
+	resp.Url = guestUrl(req, token)
+	writeAlbumJSON(w, http.StatusCreated, resp)
+	logger.Info("Created guest link %v to album %v", link.Id, album.Id)
+}
+
+// listGuestLinks returns the guest links of the album, expired links are listed until they are purged
+func listGuestLinks(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	links, err := AlbumGuestLinks(album.Id)
+	if err != nil {
+		logger.Error("failed to retrieve guest links sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve guest links, try again later"))
+		return
+	}
+
+	resp := []GuestLinkResp{}
+	for _, link := range links {
+		resp = append(resp, guestLinkResp(link))
+	}
+	writeAlbumJSON(w, http.StatusOK, resp)
+}
+
+// deleteGuestLink revokes a guest link of the album, images uploaded through it are kept
+func deleteGuestLink(w http.ResponseWriter, req *http.Request) {
+	album, ok := ownedAlbum(w, req)
+	if !ok {
+		return
+	}
+
+	linkId, err := strconv.Atoi(mux.Vars(req)["linkId"])
+	if err != nil {
+		logger.Error("invalid guest link id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	deleted, err := DeleteGuestLink(album.Id, int32(linkId))
+	if err != nil {
+		logger.Error("failed to delete guest link sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete guest link, try again later"))
+		return
+	}
+	if !deleted {
+		logger.Error("guest link %v not found in album %v sending 404", linkId, album.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no guest link with that id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Deleted guest link %v of album %v", linkId, album.Id)
+}
+
+// getDropBox describes the guest link in the url to the guests holding it
+func getDropBox(w http.ResponseWriter, req *http.Request) {
+	link, album, ok := findGuestLink(w, req)
+	if !ok {
+		return
+	}
+
+	writeAlbumJSON(w, http.StatusOK, DropBoxResp{
+		Album:     album.Title,
+		Label:     link.Label,
+		Remaining: link.MaxUploads - link.Uploads,
+		MaxBytes:  link.MaxBytes,
+		Types:     acceptedTypes(),
+		Expires:   Timestamp(link.Expires),
+	})
+}
+
+// guestUpload accepts multipart form-data with an image and optional title from a guest holding
+// the link in the url. The image is stored for the album owner and appended to the album
+func (s *Server) guestUpload(w http.ResponseWriter, req *http.Request) {
+	link, album, ok := findGuestLink(w, req)
+	if !ok {
+		return
+	}
+
+	// Validate Content-Type of the request
+	contentType := req.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		logger.Error("request content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to upload, please use multipart form data with a supported image type"))
+		return
+	}
+
+	// Refuse to read bodies that could not satisfy the link
+	req.Body = http.MaxBytesReader(w, req.Body, link.MaxBytes+UPLOAD_FORM_OVERHEAD)
+	img, imgHeader, err := req.FormFile("image")
+	if err != nil || imgHeader.Size > link.MaxBytes {
+		if err == nil || strings.Contains(err.Error(), "request body too large") {
+			logger.Error("guest upload exceeds link size sending 413: %v", err)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("413 - Upload exceeds the limit of %v bytes", link.MaxBytes)))
+			return
+		}
+		logger.Error("failed to read file sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to read file, ensure the image is attached as the image field"))
+		return
+	}
+	defer img.Close()
+
+	// The album is checked before the upload is counted so guests aren't charged for a full album
+	count, err := AlbumImageCount(album.Id)
+	if err != nil {
+		logger.Error("failed to count album images sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to save file, try again later"))
+		return
+	}
+	if count >= ALBUM_MAX_IMAGES {
+		logger.Error("album %v of guest link %v is full sending 409", album.Id, link.Id)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - The album is full"))
+		return
+	}
+
+	// Concurrent uploads each reserve one of the remaining uploads
+	reserved, err := ReserveGuestUpload(link.Id)
+	if err == ErrGuestLinkExhausted {
+		writeGuestLinkGone(w, link)
+		return
+	}
+	if err != nil {
+		logger.Error("failed to reserve guest upload sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to save file, try again later"))
+		return
+	}
+	link = reserved
+
+	// Guests neither share, tag nor describe the owner's images
+	imageData, err := s.saveImage(req.Context(), int(link.Uid), img, imgHeader, req.FormValue("title"), imageText{}, "", "", nil, acceptedTypes())
+	if err != nil {
+		logger.Error("failed to save guest image: %v", err)
+		if err := ReleaseGuestUpload(link.Id); err != nil {
+			logger.Error("failed to release guest upload of link %v: %v", link.Id, err)
+		}
+		writeUploadError(w, err)
+		return
+	}
+	recordAudit(req, int(link.Uid), AUDIT_GUEST_UPLOAD, imageData.Id)
+
+	err = AddAlbumImages(album, []int32{imageData.Id}, -1)
+	if err != nil {
+		logger.Error("failed to add guest image %v to album %v sending 500: %v", imageData.Id, album.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - The image was saved but could not be added to the album"))
+		return
+	}
+
+	writeAlbumJSON(w, http.StatusCreated, GuestUploadResp{
+		Id:        imageData.Id,
+		Title:     imageData.Title,
+		Size:      imageData.Size,
+		Encoding:  imageData.Encoding,
+		Remaining: link.MaxUploads - link.Uploads,
+	})
+	logger.Info("Guest uploaded image %v through link %v", imageData.Id, link.Id)
+}
+
+// findGuestLink retrieves the link of the token in the url with its album, writing the error response
+// and returning false if the link doesn't exist or no longer accepts uploads
+func findGuestLink(w http.ResponseWriter, req *http.Request) (GuestLink, Album, bool) {
+	link, ok, err := GuestLinkByToken(hashToken(mux.Vars(req)["token"]))
+	if err != nil {
+		logger.Error("failed to retrieve guest link sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve guest link, try again later"))
+		return GuestLink{}, Album{}, false
+	}
+	if !ok {
+		logger.Error("unknown guest link sending 404")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the guest link is invalid or was deleted"))
+		return GuestLink{}, Album{}, false
+	}
+	if !link.active(time.Now().UTC()) {
+		writeGuestLinkGone(w, link)
+		return GuestLink{}, Album{}, false
+	}
+
+	album, ok, err := GetAlbum(link.AlbumId)
+	if err != nil {
+		logger.Error("failed to retrieve album sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve guest link, try again later"))
+		return GuestLink{}, Album{}, false
+	}
+	if !ok {
+		logger.Error("album %v of guest link %v does not exist sending 404", link.AlbumId, link.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the guest link is invalid or was deleted"))
+		return GuestLink{}, Album{}, false
+	}
+
+	return link, album, true
+}
+
+// writeGuestLinkGone reports a link that expired or whose uploads are used up
+func writeGuestLinkGone(w http.ResponseWriter, link GuestLink) {
+	logger.Error("guest link %v no longer accepts uploads sending 410", link.Id)
+	w.WriteHeader(http.StatusGone)
+	w.Write([]byte("410 - Gone, the guest link expired or reached its upload limit"))
+}
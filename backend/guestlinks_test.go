@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewGuestLink ensures omitted limits use the defaults and limits beyond the maxima are refused
+func TestNewGuestLink(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	album := Album{Id: 3, Uid: 7}
+
+	link, err := GuestLinkParams{Label: " Wedding\n"}.newGuestLink(album, now)
+	if err != nil || link.Uid != 7 || link.AlbumId != 3 || link.Label != "Wedding" || link.MaxUploads != GUEST_LINK_UPLOADS ||
+		link.MaxBytes != getMaxUploadBytes() || !link.Expires.Equal(now.Add(GUEST_LINK_TTL*time.Hour)) {
+		t.Errorf("wrong default link: got %+v %v", link, err)
+	}
+
+	link, err = GuestLinkParams{MaxUploads: 5, MaxBytes: 1024, ExpiresIn: 2}.newGuestLink(album, now)
+	if err != nil || link.MaxUploads != 5 || link.MaxBytes != 1024 || !link.Expires.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("wrong limited link: got %+v %v", link, err)
+	}
+
+	for _, params := range []GuestLinkParams{
+		{MaxUploads: -1},
+		{MaxUploads: GUEST_LINK_UPLOADS_MAX + 1},
+		{MaxBytes: getMaxUploadBytes() + 1},
+		{ExpiresIn: GUEST_LINK_TTL_MAX + 1},
+		{Label: strings.Repeat("a", GUEST_LINK_LABEL_MAX+1)},
+	} {
+		if _, err := params.newGuestLink(album, now); err == nil {
+			t.Errorf("expected %+v to be refused", params)
+		}
+	}
+
+	if !(GuestLink{MaxUploads: 1, Expires: now.Add(time.Second)}).active(now) {
+		t.Errorf("expected unused link to be active")
+	}
+	if (GuestLink{MaxUploads: 1, Uploads: 1, Expires: now.Add(time.Second)}).active(now) || (GuestLink{MaxUploads: 1, Expires: now}).active(now) {
+		t.Errorf("expected used up and expired links to be inactive")
+	}
+}
+
+// TestGuestLinks ensures guests upload into the album without an account until the link is used up
+// and that deleted links stop accepting uploads
+func TestGuestLinks(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+	router := configureRoutes()
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	guestUpload := func(path string) *httptest.ResponseRecorder {
+		req := testUploadRequest(t, "", false)
+		req.URL, _ = url.Parse(path)
+		req.Header.Del("Authorization")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/album", `{"title": "Wedding"}`, token)
+	album := AlbumResp{}
+	json.Unmarshal(rr.Body.Bytes(), &album)
+	linksPath := fmt.Sprintf("/album/%v/guest-links", album.Id)
+
+	if rr := send("POST", linksPath, `{"maxUploads": 1}`, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code creating link without signing in: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	rr = send("POST", linksPath, `{"label": "Guests", "maxUploads": 1}`, token)
+	link := GuestLinkResp{}
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	if rr.Code != http.StatusCreated || len(link.Token) == 0 || !strings.HasSuffix(link.Url, "/guest/"+link.Token) || !link.Active {
+		t.Fatalf("failed to create link: got %v %+v", rr.Code, link)
+	}
+	guestPath := "/guest/" + link.Token
+
+	rr = send("GET", guestPath, "", "")
+	dropBox := DropBoxResp{}
+	json.Unmarshal(rr.Body.Bytes(), &dropBox)
+	if rr.Code != http.StatusOK || dropBox.Album != "Wedding" || dropBox.Label != "Guests" || dropBox.Remaining != 1 {
+		t.Errorf("wrong drop box: got %v %+v", rr.Code, dropBox)
+	}
+
+	rr = guestUpload(guestPath)
+	uploaded := GuestUploadResp{}
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	if rr.Code != http.StatusCreated || uploaded.Id == 0 || uploaded.Remaining != 0 {
+		t.Fatalf("failed guest upload: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := guestUpload(guestPath); rr.Code != http.StatusGone {
+		t.Errorf("wrong code uploading through a used up link: got %v want %v", rr.Code, http.StatusGone)
+	}
+
+	detail := AlbumDetailResp{}
+	json.Unmarshal(send("GET", fmt.Sprintf("/album/%v", album.Id), "", token).Body.Bytes(), &detail)
+	if len(detail.Images) != 1 || detail.Images[0].Id != uploaded.Id {
+		t.Errorf("guest image not added to album: got %+v", detail.Images)
+	}
+
+	links := []GuestLinkResp{}
+	json.Unmarshal(send("GET", linksPath, "", token).Body.Bytes(), &links)
+	if len(links) != 1 || links[0].Uploads != 1 || links[0].Active || len(links[0].Token) > 0 {
+		t.Errorf("wrong links: got %+v", links)
+	}
+
+	if rr := send("DELETE", fmt.Sprintf("%s/%v", linksPath, link.Id), "", token); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to delete link: got %v", rr.Code)
+	}
+	if rr := send("GET", guestPath, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for deleted link: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+/*
+	This file implements the probes used by orchestrators and load balancers. /healthz reports that
+	the process is serving requests while /readyz additionally verifies the database is reachable and
+	image storage accepts writes, so traffic is only routed to instances that can complete requests.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	READY_TIMEOUT    = 2 * time.Second // Time allowed for each readiness check
+	HEALTH_PROBE_DIR = ".health"       // Storage prefix of the objects written by readiness checks
+
+	CHECK_OK     = "ok"
+	CHECK_FAILED = "failed"
+)
+
+// ReadyResp reports the result of every readiness check
+type ReadyResp struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readinessChecks are run by /readyz, each must succeed for the instance to receive traffic
+var readinessChecks = map[string]func(ctx context.Context) error{
+	"database": CheckDB,
+	"storage":  checkStorage,
+}
+
+// healthz responds while the process is able to serve requests
+func healthz(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("200 - OK"))
+}
+
+// readyz runs every readiness check and responds with 503 if any of them fail
+func readyz(w http.ResponseWriter, req *http.Request) {
+	resp := ReadyResp{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, check := range readinessChecks {
+		ctx, cancel := context.WithTimeout(req.Context(), READY_TIMEOUT)
+		err := check(ctx)
+		cancel()
+
+		// Failure details are only logged as the probe is unauthenticated
+		if err != nil {
+			logger.Error("readiness check %s failed: %v", name, err)
+			resp.Checks[name] = CHECK_FAILED
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = CHECK_OK
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// checkStorage verifies image storage accepts writes by storing and removing a probe object
+// each host writes its own probe so concurrent checks by several instances don't interfere
+func checkStorage(ctx context.Context) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return probeStorage(ctx, storage, fmt.Sprintf("%s/%s", HEALTH_PROBE_DIR, host))
+}
+
+// probeStorage writes, reads back and removes a probe object at key
+func probeStorage(ctx context.Context, driver Storage, key string) error {
+	err := driver.Put(ctx, key, strings.NewReader("ok"), 2, "text/plain")
+	if err != nil {
+		return fmt.Errorf("%s storage is not writable: %v", driver.Name(), err)
+	}
+
+	object, err := driver.Open(ctx, key)
+	if err != nil {
+		driver.Delete(ctx, key)
+		return fmt.Errorf("unable to read probe from %s storage: %v", driver.Name(), err)
+	}
+	object.Close()
+
+	err = driver.Delete(ctx, key)
+	if err != nil {
+		return fmt.Errorf("unable to remove probe from %s storage: %v", driver.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestReadyz ensures the instance is only reported ready when every check succeeds
+func TestReadyz(t *testing.T) {
+	defer func(checks map[string]func(ctx context.Context) error) { readinessChecks = checks }(readinessChecks)
+
+	router := configureRoutes()
+	if rr := serveProbe(router, "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("wrong liveness code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	pass := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return fmt.Errorf("unreachable") }
+
+	tt := []struct {
+		Checks   map[string]func(ctx context.Context) error
+		Expected int
+		Failed   string
+	}{
+		{map[string]func(ctx context.Context) error{"database": pass, "storage": pass}, http.StatusOK, ""},
+		{map[string]func(ctx context.Context) error{"database": fail, "storage": pass}, http.StatusServiceUnavailable, "database"},
+		{map[string]func(ctx context.Context) error{"database": pass, "storage": fail}, http.StatusServiceUnavailable, "storage"},
+	}
+
+	for _, tc := range tt {
+		readinessChecks = tc.Checks
+		rr := serveProbe(router, "/readyz")
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong readiness code with %s failing: got %v want %v", tc.Failed, rr.Code, tc.Expected)
+		}
+
+		resp := ReadyResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		for name := range tc.Checks {
+			expected := CHECK_OK
+			if name == tc.Failed {
+				expected = CHECK_FAILED
+			}
+			if resp.Checks[name] != expected {
+				t.Errorf("wrong result for check %s: got %q want %q", name, resp.Checks[name], expected)
+			}
+		}
+	}
+}
+
+// TestCheckStorage ensures the storage check leaves no probe behind and fails when storage is not writable
+func TestCheckStorage(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+
+	root := t.TempDir()
+	storage = &localStorage{root: root}
+
+	if err := checkStorage(context.Background()); err != nil {
+		t.Errorf("expected writable storage to pass: %v", err)
+	}
+	entries, _ := ioutil.ReadDir(fmt.Sprintf("%s/%s", root, HEALTH_PROBE_DIR))
+	if len(entries) > 0 {
+		t.Errorf("expected probe to be removed: got %v entries", len(entries))
+	}
+
+	// A file where the probe directory belongs prevents writes
+	os.RemoveAll(fmt.Sprintf("%s/%s", root, HEALTH_PROBE_DIR))
+	ioutil.WriteFile(fmt.Sprintf("%s/%s", root, HEALTH_PROBE_DIR), []byte("x"), 0644)
+	if err := checkStorage(context.Background()); err == nil {
+		t.Errorf("expected unwritable storage to fail")
+	}
+}
+
+// serveProbe requests the probe from the router
+func serveProbe(router http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+
+	"github.com/inflowml/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MAX_IMPORT_ROWS  = 10000    // Maximum users accepted by a single import
+	MAX_IMPORT_BYTES = 10 << 20 // Maximum size of an import body
+)
+
+// ImportUser is a single user of an import, exactly one of Password or HashedPass must be set
+// HashedPass accepts bcrypt hashes exported from another product so users keep their password
+type ImportUser struct {
+	Email      string `json:"email"`
+	Firstname  string `json:"firstname"`
+	Lastname   string `json:"lastname"`
+	Password   string `json:"password"`
+	HashedPass string `json:"hashedPass"`
+}
+
+// ImportResult reports the outcome of a single row of an import, rows are numbered from 1
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Status int    `json:"status"`
+	Uid    int32  `json:"uid,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportResp summarizes an import with the result of every row
+type ImportResp struct {
+	DryRun   bool           `json:"dryRun"`
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// importUsers creates accounts from a JSON array or CSV file of users for administrators migrating
+// from another gallery. CSV files require a header row naming the columns. Every row is validated
+// and reported individually, with dryRun=true rows are validated without creating accounts
+func importUsers(w http.ResponseWriter, req *http.Request) {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to import users: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, MAX_IMPORT_BYTES)
+
+	var users []ImportUser
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	case strings.Contains(contentType, "application/json"):
+		err = json.NewDecoder(req.Body).Decode(&users)
+	case strings.Contains(contentType, "text/csv"):
+		users, err = parseImportCSV(req.Body)
+	default:
+		logger.Error("import content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Content-Type must be application/json or text/csv"))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to parse import sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Unable to parse import: %v", err)))
+		return
+	}
+
+	if len(users) == 0 || len(users) > MAX_IMPORT_ROWS {
+		logger.Error("import of %v users sending 400", len(users))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Imports must include between 1 and %v users", MAX_IMPORT_ROWS)))
+		return
+	}
+
+	resp := ImportResp{
+		DryRun:  req.URL.Query().Get("dryRun") == "true",
+		Results: make([]ImportResult, len(users)),
+	}
+
+	// Validate every row before creating accounts so duplicates within the import are caught
+	seen := map[string]bool{}
+	valid := []int{}
+	for i, user := range users {
+		result := validateImportUser(user, seen)
+		result.Row = i + 1
+		resp.Results[i] = result
+		if result.Status == http.StatusOK {
+			valid = append(valid, i)
+		}
+	}
+
+	// Hashing dominates the cost of an import so accounts are created by a pool of workers
+	if !resp.DryRun {
+		indexes := make(chan int)
+		wg := sync.WaitGroup{}
+		for i := 0; i < BATCH_WORKERS; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range indexes {
+					createImportUser(users[index], &resp.Results[index])
+				}
+			}()
+		}
+		for _, index := range valid {
+			indexes <- index
+		}
+		close(indexes)
+		wg.Wait()
+	}
+
+	for _, result := range resp.Results {
+		if result.Status == http.StatusOK {
+			resp.Imported++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	logger.Info("Imported %v users with %v failures (dry run: %v)", resp.Imported, resp.Failed, resp.DryRun)
+	return
+}
+
+// parseImportCSV reads users from a CSV file with a header row naming the columns
+// columns are matched to the json names of ImportUser ignoring case, unknown columns are ignored
+func parseImportCSV(r io.Reader) ([]ImportUser, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header row: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("header row must include an email column")
+	}
+
+	value := func(record []string, name string) string {
+		if i, ok := columns[strings.ToLower(name)]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	users := []ImportUser{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, ImportUser{
+			Email:      value(record, "email"),
+			Firstname:  value(record, "firstname"),
+			Lastname:   value(record, "lastname"),
+			Password:   value(record, "password"),
+			HashedPass: value(record, "hashedPass"),
+		})
+		if len(users) > MAX_IMPORT_ROWS {
+			break
+		}
+	}
+
+	return users, nil
+}
+
+// validateImportUser checks the fields of an imported user and that the email is unused
+// by existing accounts and earlier rows recorded in seen
+func validateImportUser(user ImportUser, seen map[string]bool) ImportResult {
+	result := ImportResult{Email: user.Email, Status: http.StatusBadRequest}
+
+	if len(user.Email) == 0 || len(user.Firstname) == 0 || len(user.Lastname) == 0 {
+		result.Error = "400 - email, firstname and lastname are required"
+		return result
+	}
+	if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+		result.Error = "400 - Invalid email address"
+		return result
+	}
+	if (len(user.Password) == 0) == (len(user.HashedPass) == 0) {
+		result.Error = "400 - Exactly one of password or hashedPass is required"
+		return result
+	}
+	if len(user.HashedPass) > 0 {
+		if _, err := bcrypt.Cost([]byte(user.HashedPass)); err != nil {
+			result.Error = "400 - hashedPass must be a bcrypt hash"
+			return result
+		}
+	}
+
+	email := strings.ToLower(user.Email)
+	if seen[email] {
+		result.Status = http.StatusConflict
+		result.Error = "409 - Email appears earlier in the import"
+		return result
+	}
+	seen[email] = true
+
+	unique, err := UniqueEmail(user.Email)
+	if err != nil {
+		logger.Error("failed to validate imported email: %v", err)
+		result.Status = http.StatusInternalServerError
+		result.Error = "500 - Failed to validate email, try again later"
+		return result
+	}
+	if !unique {
+		result.Status = http.StatusConflict
+		result.Error = "409 - Email is already registered"
+		return result
+	}
+
+	result.Status = http.StatusOK
+	return result
+}
+
+// createImportUser hashes the password if required and registers the user recording the outcome in result
+func createImportUser(user ImportUser, result *ImportResult) {
+	hashedPass := user.HashedPass
+	if len(hashedPass) == 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			logger.Error("failed to hash imported password: %v", err)
+			result.Status = http.StatusInternalServerError
+			result.Error = "500 - Unable to hash password"
+			return
+		}
+		hashedPass = string(hash)
+	}
+
+	uid, err := RegisterUser(User{Email: user.Email, Firstname: user.Firstname, Lastname: user.Lastname}, hashedPass)
+	if err != nil {
+		logger.Error("failed to register imported user: %v", err)
+		result.Status = http.StatusInternalServerError
+		result.Error = "500 - Failed to create account"
+		return
+	}
+
+	result.Uid = uid
+}
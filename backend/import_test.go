@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParseImportCSV ensures columns are matched by the header row in any order
+func TestParseImportCSV(t *testing.T) {
+	users, err := parseImportCSV(strings.NewReader("LastName,email,hashedPass,firstname,extra\nDoe, jane@mail.com,$2a$10$abc,Jane,x\nRoe,sam@mail.com,,Sam\n"))
+	if err != nil {
+		t.Fatalf("failed to parse csv: %v", err)
+	}
+	expected := []ImportUser{
+		{Email: "jane@mail.com", Firstname: "Jane", Lastname: "Doe", HashedPass: "$2a$10$abc"},
+		{Email: "sam@mail.com", Firstname: "Sam", Lastname: "Roe"},
+	}
+	if fmt.Sprint(users) != fmt.Sprint(expected) {
+		t.Errorf("wrong users: got %v want %v", users, expected)
+	}
+
+	if _, err = parseImportCSV(strings.NewReader("firstname,lastname\nJane,Doe\n")); err == nil {
+		t.Errorf("expected error for csv without email column")
+	}
+}
+
+// TestImportUsers ensures imports are restricted to administrators and invalid rows are reported individually
+// None of the evaluated rows reach the database
+func TestImportUsers(t *testing.T) {
+	router := configureRoutes()
+
+	os.Setenv("ADMIN_UIDS", "7, 9")
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	importRequest := func(uid int, body string) *httptest.ResponseRecorder {
+		token, _, err := generateJWT(uid, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req, _ := http.NewRequest("POST", "/admin/users/import?dryRun=true", bytes.NewBufferString(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := importRequest(1, "[]"); rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong code for non administrator: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := importRequest(9, "[]"); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong code for empty import: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := importRequest(7, `[
+		{"email": "", "firstname": "No", "lastname": "Email", "password": "pass"},
+		{"email": "Jane <jane@mail.com>", "firstname": "Jane", "lastname": "Doe", "password": "pass"},
+		{"email": "sam@mail.com", "firstname": "Sam", "lastname": "Roe"},
+		{"email": "sam@mail.com", "firstname": "Sam", "lastname": "Roe", "password": "pass", "hashedPass": "$2a$10$abc"},
+		{"email": "lee@mail.com", "firstname": "Lee", "lastname": "Poe", "hashedPass": "plaintext"}
+	]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	resp := ImportResp{}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !resp.DryRun || resp.Imported != 0 || resp.Failed != 5 || len(resp.Results) != 5 {
+		t.Fatalf("wrong import summary: got %+v", resp)
+	}
+	for i, result := range resp.Results {
+		if result.Row != i+1 || result.Status != http.StatusBadRequest || len(result.Error) == 0 {
+			t.Errorf("wrong result for row %v: got %+v", i+1, result)
+		}
+	}
+}
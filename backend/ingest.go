@@ -0,0 +1,357 @@
+package main
+
+/*
+	This file implements the organisation ingest policy. The instance accepts the types listed in
+	UPLOAD_TYPES and may require every upload to be stripped of metadata or scanned for malware.
+	Administrators can tighten these rules for their organisation, narrowing the accepted types
+	and making stripping or scanning mandatory, but never loosen them. The rules are enforced by
+	saveImage so every upload path is covered.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	INGEST_TABLE = "ingest_policy"
+
+	UPLOAD_SCAN_COMMAND = "clamdscan" // Default if UPLOAD_SCAN_COMMAND env variable is not defined
+	UPLOAD_SCAN_TIMEOUT = 60          // Seconds a scan may take before the upload is refused
+)
+
+var (
+	// ErrUploadInfected is returned by the scanner when it finds malware in an upload
+	ErrUploadInfected = errors.New("upload rejected by the malware scanner")
+	// ErrScanUnavailable is returned by the scanner when the scan command isn't installed
+	ErrScanUnavailable = errors.New("upload scanner is not installed")
+)
+
+// strippableTypes are the types metadata can be removed from, other types are refused while stripping is required
+var strippableTypes = []string{"image/jpeg", "image/png"}
+
+// IngestPolicy is the organisation ingest policy tagged for sql serialization
+// the table holds at most a single row with id 1, uploads follow the instance rules until it is set
+type IngestPolicy struct {
+	Id          int32  `sql:"id" opt:"PRIMARY KEY"`
+	Types       string `sql:"types"`        // Comma separated types members may upload, empty allows every type the instance accepts
+	StripExif   bool   `sql:"strip_exif"`   // Metadata is removed from uploads before they are stored
+	RequireScan bool   `sql:"require_scan"` // Uploads are refused unless the scanner finds them clean
+}
+
+// IngestRules are the rules enforced on uploads, the instance rules tightened by the organisation policy
+type IngestRules struct {
+	Types       []string `json:"types"`
+	StripExif   bool     `json:"stripExif"`
+	RequireScan bool     `json:"requireScan"`
+}
+
+// IngestPolicyResp is the json representation of the organisation policy and the rules it results in
+type IngestPolicyResp struct {
+	IngestRules
+	Enforced IngestRules `json:"enforced"`
+}
+
+// Rules returns the organisation policy as requested by administrators
+func (p IngestPolicy) Rules() IngestRules {
+	types := []string{}
+	for _, typ := range strings.Split(p.Types, ",") {
+		if len(typ) > 0 {
+			types = append(types, typ)
+		}
+	}
+	return IngestRules{Types: types, StripExif: p.StripExif, RequireScan: p.RequireScan}
+}
+
+// Enforced combines the policy with the instance rules defined by UPLOAD_TYPES, UPLOAD_STRIP_EXIF
+// and UPLOAD_REQUIRE_SCAN, the stricter of each rule applies
+func (p IngestPolicy) Enforced() IngestRules {
+	requested := p.Rules()
+	rules := IngestRules{
+		Types:       []string{},
+		StripExif:   requested.StripExif || envBool("UPLOAD_STRIP_EXIF", false),
+		RequireScan: requested.RequireScan || envBool("UPLOAD_REQUIRE_SCAN", false),
+	}
+	for _, typ := range acceptedTypes() {
+		if len(requested.Types) > 0 && !containsString(requested.Types, typ) {
+			continue
+		}
+		if rules.StripExif && !containsString(strippableTypes, typ) {
+			continue
+		}
+		rules.Types = append(rules.Types, typ)
+	}
+	return rules
+}
+
+// Accept returns the types of accepted the rules allow
+func (r IngestRules) Accept(accepted []string) []string {
+	allowed := []string{}
+	for _, typ := range accepted {
+		if containsString(r.Types, typ) {
+			allowed = append(allowed, typ)
+		}
+	}
+	return allowed
+}
+
+// validate ensures every type of the policy is accepted by the instance
+func (r IngestRules) validate() error {
+	accepted := acceptedTypes()
+	for _, typ := range r.Types {
+		if !containsString(accepted, typ) {
+			return fmt.Errorf("unsupported type %s, accepted types are %s", typ, strings.Join(accepted, ", "))
+		}
+	}
+	return nil
+}
+
+// ingestFile is an upload held in memory after the ingest rules rewrote it
+type ingestFile struct {
+	*bytes.Reader
+}
+
+func (ingestFile) Close() error {
+	return nil
+}
+
+// applyIngestRules scans the upload and strips its metadata as the rules require, returning the file
+// and header to store in place of the upload. Errors are returned as *uploadError
+func applyIngestRules(ctx context.Context, rules IngestRules, img multipart.File, imgHeader *multipart.FileHeader, fileType string) (multipart.File, *multipart.FileHeader, error) {
+	if rules.RequireScan {
+		err := scanUpload(ctx, img)
+		img.Seek(0, io.SeekStart)
+		if err == ErrUploadInfected {
+			return nil, nil, &uploadError{http.StatusUnprocessableEntity, "422 - Upload was rejected by the malware scanner", err}
+		}
+		if err != nil {
+			return nil, nil, &uploadError{http.StatusServiceUnavailable, "503 - Uploads can't be scanned right now, try again later", fmt.Errorf("failed to scan upload: %v", err)}
+		}
+	}
+
+	if !rules.StripExif {
+		return img, imgHeader, nil
+	}
+
+	data, err := ioutil.ReadAll(img)
+	if err != nil {
+		return nil, nil, &uploadError{http.StatusBadRequest, "400 - Failed to read file, try again", fmt.Errorf("failed to read upload: %v", err)}
+	}
+	stripped, err := stripMetadata(data, fileType)
+	if err != nil {
+		return nil, nil, &uploadError{http.StatusBadRequest, "400 - Failed to remove image metadata, ensure the file is a correctly formatted image", err}
+	}
+
+	header := *imgHeader
+	header.Size = int64(len(stripped))
+	return ingestFile{bytes.NewReader(stripped)}, &header, nil
+}
+
+// stripMetadata returns the image without EXIF, XMP and text metadata. JPEG images whose EXIF
+// orientation requires rotation are rewritten upright since the orientation is removed with the EXIF data
+func stripMetadata(data []byte, fileType string) ([]byte, error) {
+	switch fileType {
+	case "image/jpeg":
+		if orientation := exifOrientation(data); orientation > 1 && orientation <= 8 {
+			src, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode image: %v", err)
+			}
+			buf := new(bytes.Buffer)
+			err = jpeg.Encode(buf, orientImage(src, orientation), &jpeg.Options{Quality: ORIENT_QUALITY})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode image: %v", err)
+			}
+			return buf.Bytes(), nil
+		}
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	}
+	return nil, fmt.Errorf("metadata can't be removed from %s images", fileType)
+}
+
+// stripJPEGMetadata removes the APP1 (EXIF and XMP) and APP13 (IPTC) segments preceding the image data
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("missing start of image marker")
+	}
+
+	stripped := append([]byte{}, data[:2]...)
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errors.New("malformed segment")
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			return append(stripped, data[i:]...), nil // Start of scan, the image data is copied as is
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("truncated segment")
+		}
+		if marker != 0xE1 && marker != 0xED {
+			stripped = append(stripped, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+}
+
+// stripPNGMetadata removes the eXIf and text chunks of the PNG data
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("missing png signature")
+	}
+
+	stripped := append([]byte{}, data[:8]...)
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("truncated chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("truncated chunk")
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			stripped = append(stripped, data[i:end]...)
+		}
+		i = end
+	}
+
+	return stripped, nil
+}
+
+// scanUpload runs the scanner named by UPLOAD_SCAN_COMMAND on the upload, a clamdscan compatible
+// command reading the file from stdin and exiting with 1 when malware is found
+var scanUpload = func(ctx context.Context, file io.Reader) error {
+	name := os.Getenv("UPLOAD_SCAN_COMMAND")
+	if len(name) == 0 {
+		name = UPLOAD_SCAN_COMMAND
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		logger.Error("upload scanner %s is not installed: %v", name, err)
+		return ErrScanUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, UPLOAD_SCAN_TIMEOUT*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, "--no-summary", "-")
+	cmd.Stdin = file
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		logger.Warning("upload scanner found malware: %s", bytes.TrimSpace(output))
+		return ErrUploadInfected
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// ingestPolicy returns the organisation ingest policy and the rules enforced on uploads to administrators
+func ingestPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for ingest policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetIngestPolicy(req.Context())
+	if err != nil {
+		logger.Error("failed to retrieve ingest policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve ingest policy, try again later"))
+		return
+	}
+
+	writeIngestPolicy(w, policy)
+}
+
+// updateIngestPolicy accepts a json body with any of types, stripExif and requireScan and updates
+// the ingest policy, an empty list of types allows every type the instance accepts. Images that are
+// already stored are not changed
+func updateIngestPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update ingest policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetIngestPolicy(req.Context())
+	if err != nil {
+		logger.Error("failed to retrieve ingest policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve ingest policy, try again later"))
+		return
+	}
+
+	// Decode over the current policy so omitted fields are unchanged
+	rules := policy.Rules()
+	err = json.NewDecoder(req.Body).Decode(&rules)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	for i := range rules.Types {
+		rules.Types[i] = strings.ToLower(strings.TrimSpace(rules.Types[i]))
+	}
+	err = rules.validate()
+	if err != nil {
+		logger.Error("invalid ingest policy sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	policy = IngestPolicy{Id: 1, Types: strings.Join(rules.Types, ","), StripExif: rules.StripExif, RequireScan: rules.RequireScan}
+	err = SetIngestPolicy(policy)
+	if err != nil {
+		logger.Error("failed to update ingest policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update ingest policy, try again later"))
+		return
+	}
+
+	writeIngestPolicy(w, policy)
+	logger.Info("Ingest policy updated by user %v: %+v", claims.Uid, policy)
+}
+
+// writeIngestPolicy writes the policy as the json response body
+func writeIngestPolicy(w http.ResponseWriter, policy IngestPolicy) {
+	js, err := json.Marshal(IngestPolicyResp{IngestRules: policy.Rules(), Enforced: policy.Enforced()})
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
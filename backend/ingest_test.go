@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withChunk inserts the chunk after the IHDR chunk of the png, its crc is left zero
+func withChunk(data []byte, typ string, content string) []byte {
+	chunk := make([]byte, 8, 12+len(content))
+	binary.BigEndian.PutUint32(chunk, uint32(len(content)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, content...)
+	chunk = append(chunk, 0, 0, 0, 0)
+
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	withChunk := append([]byte{}, data[:ihdrEnd]...)
+	withChunk = append(withChunk, chunk...)
+	return append(withChunk, data[ihdrEnd:]...)
+}
+
+// TestIngestRules ensures the organisation policy only tightens the instance rules
+func TestIngestRules(t *testing.T) {
+	defer os.Unsetenv("UPLOAD_TYPES")
+	defer os.Unsetenv("UPLOAD_REQUIRE_SCAN")
+	os.Setenv("UPLOAD_TYPES", "image/jpeg,image/png,image/webp")
+
+	tt := []struct {
+		Name     string
+		Policy   IngestPolicy
+		Scan     string
+		Expected IngestRules
+	}{
+		{"unset", IngestPolicy{}, "", IngestRules{Types: []string{"image/jpeg", "image/png", "image/webp"}}},
+		{"narrowed", IngestPolicy{Types: "image/png,image/gif"}, "", IngestRules{Types: []string{"image/png"}}},
+		{"stripped", IngestPolicy{StripExif: true}, "", IngestRules{Types: []string{"image/jpeg", "image/png"}, StripExif: true}},
+		{"scanned", IngestPolicy{RequireScan: true}, "", IngestRules{Types: []string{"image/jpeg", "image/png", "image/webp"}, RequireScan: true}},
+		{"instance scan", IngestPolicy{RequireScan: false}, "true", IngestRules{Types: []string{"image/jpeg", "image/png", "image/webp"}, RequireScan: true}},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("UPLOAD_REQUIRE_SCAN", tc.Scan)
+		if rules := tc.Policy.Enforced(); !reflect.DeepEqual(rules, tc.Expected) {
+			t.Errorf("wrong rules for %s: got %+v want %+v", tc.Name, rules, tc.Expected)
+		}
+	}
+
+	rules := IngestRules{Types: []string{"image/png"}}
+	if allowed := rules.Accept([]string{"image/jpeg", "image/png"}); !reflect.DeepEqual(allowed, []string{"image/png"}) {
+		t.Errorf("wrong accepted types: got %v", allowed)
+	}
+	if err := (IngestRules{Types: []string{"image/tiff"}}).validate(); err == nil {
+		t.Errorf("type the instance doesn't accept allowed by the policy")
+	}
+}
+
+// TestStripMetadata ensures EXIF segments and text chunks are removed while the image still decodes
+func TestStripMetadata(t *testing.T) {
+	jpg := testImage(t, "jpeg", 8)
+	stripped, err := stripMetadata(withSegment(jpg, exifSegment(binary.BigEndian, 1)), "image/jpeg")
+	if err != nil {
+		t.Fatalf("failed to strip jpeg: %v", err)
+	}
+	if !bytes.Equal(stripped, jpg) {
+		t.Errorf("exif segment not removed: got %v bytes want %v", len(stripped), len(jpg))
+	}
+
+	// Rotated images are rewritten upright as the orientation is removed
+	stripped, err = stripMetadata(withSegment(testImage(t, "jpeg", 8), exifSegment(binary.LittleEndian, 6)), "image/jpeg")
+	if err != nil {
+		t.Fatalf("failed to strip rotated jpeg: %v", err)
+	}
+	if exifTIFF(stripped) != nil {
+		t.Errorf("exif data kept in rotated jpeg")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped jpeg doesn't decode: %v", err)
+	}
+
+	pngData := testImage(t, "png", 8)
+	stripped, err = stripMetadata(withChunk(withChunk(pngData, "tEXt", "Author\x00me"), "eXIf", "MM"), "image/png")
+	if err != nil {
+		t.Fatalf("failed to strip png: %v", err)
+	}
+	if !bytes.Equal(stripped, pngData) {
+		t.Errorf("png chunks not removed: got %v bytes want %v", len(stripped), len(pngData))
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped png doesn't decode: %v", err)
+	}
+
+	for _, tc := range []struct {
+		Data     []byte
+		FileType string
+	}{
+		{jpg[:3], "image/jpeg"},
+		{withSegment(jpg, exifSegment(binary.BigEndian, 1))[:30], "image/jpeg"},
+		{pngData[:20], "image/png"},
+		{jpg, "image/webp"},
+	} {
+		if _, err := stripMetadata(tc.Data, tc.FileType); err == nil {
+			t.Errorf("malformed %s of %v bytes stripped", tc.FileType, len(tc.Data))
+		}
+	}
+}
+
+// TestApplyIngestRules ensures scans refuse infected uploads and stripping replaces the upload
+func TestApplyIngestRules(t *testing.T) {
+	defer func(scan func(context.Context, io.Reader) error) { scanUpload = scan }(scanUpload)
+
+	jpg := testImage(t, "jpeg", 8)
+	upload := withSegment(jpg, exifSegment(binary.BigEndian, 1))
+	header := &multipart.FileHeader{Filename: "photo.jpg", Size: int64(len(upload))}
+
+	tt := []struct {
+		Name    string
+		Rules   IngestRules
+		ScanErr error
+		Status  int
+		Size    int64
+	}{
+		{"no rules", IngestRules{}, nil, 0, int64(len(upload))},
+		{"clean", IngestRules{RequireScan: true}, nil, 0, int64(len(upload))},
+		{"infected", IngestRules{RequireScan: true}, ErrUploadInfected, http.StatusUnprocessableEntity, 0},
+		{"scanner missing", IngestRules{RequireScan: true}, ErrScanUnavailable, http.StatusServiceUnavailable, 0},
+		{"stripped", IngestRules{StripExif: true, RequireScan: true}, nil, 0, int64(len(jpg))},
+	}
+
+	for _, tc := range tt {
+		scanned := []byte{}
+		scanUpload = func(ctx context.Context, file io.Reader) error {
+			scanned, _ = io.ReadAll(file)
+			return tc.ScanErr
+		}
+
+		file, fileHeader, err := applyIngestRules(context.Background(), tc.Rules, ingestFile{bytes.NewReader(upload)}, header, "image/jpeg")
+		if tc.Rules.RequireScan && !bytes.Equal(scanned, upload) {
+			t.Errorf("upload not scanned for %s", tc.Name)
+		}
+		if tc.Status != 0 {
+			if status, _ := uploadErrorStatus(err); status != tc.Status {
+				t.Errorf("wrong status for %s: got %v want %v", tc.Name, status, tc.Status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to apply rules for %s: %v", tc.Name, err)
+			continue
+		}
+		data, _ := io.ReadAll(file)
+		if fileHeader.Size != tc.Size || int64(len(data)) != tc.Size {
+			t.Errorf("wrong upload for %s: got %v bytes of size %v want %v", tc.Name, len(data), fileHeader.Size, tc.Size)
+		}
+	}
+	if header.Size != int64(len(upload)) {
+		t.Errorf("header of the upload modified: got size %v", header.Size)
+	}
+}
+
+// TestIngestPolicy ensures administrators narrow the accepted types and uploads of other types are refused
+func TestIngestPolicy(t *testing.T) {
+	token, uid := getTestToken(t)
+	os.Setenv("ADMIN_UIDS", fmt.Sprintf("%v", uid))
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	previous, err := GetIngestPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve ingest policy: %v", err)
+	}
+	defer SetIngestPolicy(previous)
+
+	router := configureRoutes()
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/admin/ingest-policy", strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send(`{"types":["image/tiff"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("unsupported type accepted: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := send(`{"types":["image/gif"],"stripExif":false,"requireScan":false}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to update ingest policy: got %v %s", rr.Code, rr.Body.String())
+	}
+	resp := IngestPolicyResp{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Enforced.Types, []string{"image/gif"}) {
+		t.Errorf("wrong enforced types: got %v", resp.Enforced.Types)
+	}
+
+	// The test upload is a png, which the organisation no longer accepts
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, testUploadRequest(t, token, false))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("upload of refused type accepted: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
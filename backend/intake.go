@@ -0,0 +1,447 @@
+package main
+
+/*
+	This file implements the upload intake queue for burst imports. Files posted to the intake are
+	staged in storage and recorded as queued uploads before the request returns 202 with a status
+	URL for each file, so clients sending hundreds of files at once aren't held open while every
+	image is validated and saved. A scheduled job saves the queued files in turns taking the next
+	file of each user with queued uploads, so one large import doesn't hold up everyone else.
+	Uploads that fail with a server error are retried before they're reported as failed.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	INTAKE_TABLE          = "upload_intake"
+	INTAKE_DIR            = "intake"         // Storage prefix of staged files
+	INTAKE_MAX_FILES      = 500              // Maximum number of files accepted by a single request
+	INTAKE_QUEUE_MAX      = 2000             // Default maximum number of unfinished uploads per user
+	INTAKE_WORKERS        = 4                // Number of queued files saved concurrently
+	INTAKE_INTERVAL       = time.Second      // How often the queue is checked for files
+	INTAKE_PURGE_INTERVAL = time.Hour        // How often finished uploads past their retention are purged
+	INTAKE_STALE          = 10 * time.Minute // Files processing for longer were interrupted and are queued again
+	INTAKE_ATTEMPTS       = 3                // Attempts at saving a file failing with a server error
+	INTAKE_RETENTION      = 24 * time.Hour   // How long finished uploads can be looked up
+	INTAKE_RETRY          = 60               // Seconds clients are asked to wait when their queue is full
+	INTAKE_MEMORY         = 32 << 20         // Bytes of a request held in memory, the remainder is buffered to temporary files
+	INTAKE_STAGE_TYPE     = "application/octet-stream"
+
+	// Intake statuses
+	INTAKE_QUEUED     = "queued"
+	INTAKE_PROCESSING = "processing"
+	INTAKE_COMPLETE   = "complete"
+	INTAKE_FAILED     = "failed"
+)
+
+// UploadIntake is a queued upload tagged for sql serialization
+type UploadIntake struct {
+	Id         int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid        int32     `sql:"uid"`
+	Filename   string    `sql:"filename"`
+	Size       int64     `sql:"size"`
+	StagingKey string    `sql:"staging_key"`
+	Shareable  string    `sql:"shareable"` // Requested values applied when the file is saved
+	Dedup      string    `sql:"dedup"`
+	Tags       string    `sql:"tags"` // Comma separated
+	Ip         string    `sql:"ip"`   // Address the file was sent from for the audit log
+	Status     string    `sql:"status"`
+	Attempts   int32     `sql:"attempts"`
+	Code       int32     `sql:"code"` // HTTP status the upload would have been answered with
+	Error      string    `sql:"error"`
+	ImageId    int32     `sql:"image_id"`
+	Created    time.Time `sql:"created"`
+	Started    time.Time `sql:"started"`
+	Completed  time.Time `sql:"completed"`
+}
+
+// IntakeResp is the json representation of a queued upload
+// Files refused before they were queued have no id or status URL
+type IntakeResp struct {
+	Id        int32      `json:"id,omitempty"`
+	Filename  string     `json:"filename"`
+	Status    string     `json:"status"`
+	StatusUrl string     `json:"statusUrl,omitempty"`
+	Code      int32      `json:"code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ImageId   int32      `json:"imageId,omitempty"`
+	Created   *Timestamp `json:"created,omitempty"`
+	Completed *Timestamp `json:"completed,omitempty"`
+}
+
+func init() {
+	RegisterJob("upload-intake", INTAKE_INTERVAL, processIntake)
+	RegisterPurgeJob("upload-intake-purge", INTAKE_PURGE_INTERVAL, INTAKE_TABLE, "status IN ($1, $2) AND completed < $3", func() []interface{} {
+		return []interface{}{INTAKE_COMPLETE, INTAKE_FAILED, time.Now().UTC().Add(-INTAKE_RETENTION)}
+	})
+}
+
+// getIntakeQueueMax returns the maximum number of unfinished uploads per user
+func getIntakeQueueMax() int {
+	max, err := strconv.Atoi(os.Getenv("INTAKE_QUEUE_MAX"))
+	if err != nil || max <= 0 {
+		max = INTAKE_QUEUE_MAX
+	}
+	return max
+}
+
+// Resp returns the json representation of the queued upload
+func (u UploadIntake) Resp() IntakeResp {
+	created := Timestamp(u.Created)
+	resp := IntakeResp{
+		Id:        u.Id,
+		Filename:  u.Filename,
+		Status:    u.Status,
+		StatusUrl: fmt.Sprintf("/image/intake/%v", u.Id),
+		Code:      u.Code,
+		Error:     u.Error,
+		ImageId:   u.ImageId,
+		Created:   &created,
+	}
+	if u.Status == INTAKE_COMPLETE || u.Status == INTAKE_FAILED {
+		completed := Timestamp(u.Completed)
+		resp.Completed = &completed
+	}
+	return resp
+}
+
+// intakeUpload accepts multipart form-data with any number of files in the images field and queues
+// them to be saved. shareable, dedup and tags apply to every file. The response is 202 with an array
+// describing each file in the order they were sent, files that can't be queued are reported as failed
+func intakeUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to upload intake sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	// Validate Content-Type of the request
+	contentType := req.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		logger.Error("request content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to upload, please use multipart form data with images of supported types"))
+		return
+	}
+
+	// Refuse to read bodies larger than the maximum number of maximum size files
+	maxBytes := getMaxUploadBytes()
+	req.Body = http.MaxBytesReader(w, req.Body, INTAKE_MAX_FILES*(maxBytes+UPLOAD_FORM_OVERHEAD))
+
+	err = req.ParseMultipartForm(INTAKE_MEMORY)
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			logger.Error("intake exceeds size limit sending 413: %v", err)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("413 - Upload exceeds the limit of %v files of %v bytes", INTAKE_MAX_FILES, maxBytes)))
+			return
+		}
+		logger.Error("failed to parse multipart form sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to read files, ensure the images are attached as the images field"))
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	files := req.MultipartForm.File["images"]
+	if len(files) == 0 || len(files) > INTAKE_MAX_FILES {
+		logger.Error("intake of %v files sending 400", len(files))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Uploads must include between 1 and %v files in the images field", INTAKE_MAX_FILES)))
+		return
+	}
+
+	// Tags are optional and provided as a comma separated list
+	tags, err := parseTags(req.FormValue("tags"))
+	if err != nil {
+		logger.Error("invalid tags sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	// The dedup mode is checked now rather than failing every file once they're saved
+	dedup := req.FormValue("dedup")
+	if _, err := resolveDedup(dedup); err != nil {
+		logger.Error("invalid dedup mode sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	// Each user may only have so many unfinished uploads so a single import can't fill the storage
+	unfinished, err := CountUnfinishedIntake(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to count queued uploads sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to queue uploads, try again later"))
+		return
+	}
+	queueMax := getIntakeQueueMax()
+	if unfinished+len(files) > queueMax {
+		logger.Error("user %v has %v unfinished uploads sending 429", claims.Uid, unfinished)
+		w.Header().Set("Retry-After", strconv.Itoa(INTAKE_RETRY))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(fmt.Sprintf("429 - Too many queued uploads, at most %v may be unfinished, retry once some are saved", queueMax)))
+		return
+	}
+
+	// Stage each file, those that can't be staged are reported without being queued
+	resps := make([]IntakeResp, len(files))
+	staged := []UploadIntake{}
+	indexes := []int{}
+	for i, imgHeader := range files {
+		item, err := stageIntakeFile(req.Context(), int32(claims.Uid), imgHeader, maxBytes)
+		if err != nil {
+			uerr := err.(*uploadError)
+			logger.Error("failed to stage file %v of intake: %v", i, uerr.Err)
+			resps[i] = IntakeResp{Filename: imgHeader.Filename, Status: INTAKE_FAILED, Code: int32(uerr.Status), Error: uerr.Message}
+			continue
+		}
+		item.Shareable = req.FormValue("shareable")
+		item.Dedup = dedup
+		item.Tags = strings.Join(tags, ",")
+		item.Ip = clientIP(req)
+		staged = append(staged, item)
+		indexes = append(indexes, i)
+	}
+
+	staged, err = AddUploadIntakes(staged)
+	if err != nil {
+		logger.Error("failed to queue uploads sending 500: %v", err)
+		for _, item := range staged {
+			removeStagedFile(req.Context(), item)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to queue uploads, try again later"))
+		return
+	}
+	for i, item := range staged {
+		resps[indexes[i]] = item.Resp()
+		resps[indexes[i]].StatusUrl = versionPrefix(req) + resps[indexes[i]].StatusUrl
+	}
+
+	// marshal response in json
+	js, err := json.Marshal(resps)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	if len(staged) == 1 && len(files) == 1 {
+		w.Header().Set("Location", resps[0].StatusUrl)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(js)
+	logger.Info("Successfully queued %v of %v uploads for user %v", len(staged), len(files), claims.Uid)
+}
+
+// intakeStatus returns the queued upload with the id if it belongs to the authenticated user
+func intakeStatus(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for upload status sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	item, ok, err := GetUploadIntake(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve queued upload sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve upload, try again later"))
+		return
+	}
+	if !ok || item.Uid != int32(claims.Uid) {
+		logger.Error("queued upload %v not found for user %v sending 404", id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no queued upload with that id"))
+		return
+	}
+
+	resp := item.Resp()
+	resp.StatusUrl = versionPrefix(req) + resp.StatusUrl
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// stageIntakeFile writes the file to storage beneath the intake prefix and returns the queued upload
+// to record, errors are returned as *uploadError
+func stageIntakeFile(ctx context.Context, uid int32, imgHeader *multipart.FileHeader, maxBytes int64) (UploadIntake, error) {
+	if imgHeader.Size > maxBytes {
+		return UploadIntake{}, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("413 - Upload exceeds the limit of %v bytes", maxBytes), fmt.Errorf("file of %v bytes", imgHeader.Size)}
+	}
+
+	img, err := imgHeader.Open()
+	if err != nil {
+		return UploadIntake{}, &uploadError{http.StatusBadRequest, "400 - Failed to read file", err}
+	}
+	defer img.Close()
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return UploadIntake{}, &uploadError{http.StatusInternalServerError, "500 - Failed to queue upload, try again later", err}
+	}
+	key := fmt.Sprintf("%s/%v/%s", INTAKE_DIR, uid, hex.EncodeToString(buf))
+
+	err = storage.Put(ctx, key, img, imgHeader.Size, INTAKE_STAGE_TYPE)
+	if err != nil {
+		return UploadIntake{}, &uploadError{http.StatusInternalServerError, "500 - Failed to queue upload, try again later", fmt.Errorf("failed to stage %s: %v", key, err)}
+	}
+
+	return UploadIntake{
+		Uid:        uid,
+		Filename:   imgHeader.Filename,
+		Size:       imgHeader.Size,
+		StagingKey: key,
+		Status:     INTAKE_QUEUED,
+		Created:    time.Now().UTC(),
+	}, nil
+}
+
+// removeStagedFile deletes the staged file of the upload, failures are logged as the file is only orphaned
+func removeStagedFile(ctx context.Context, item UploadIntake) {
+	err := storage.Delete(ctx, item.StagingKey)
+	if err != nil && err != ErrObjectNotFound {
+		logger.Error("failed to delete staged file %s: %v", item.StagingKey, err)
+	}
+}
+
+// processIntake saves queued files until the queue is empty, each round claims a file per worker
+// taking the next file of each user in turn so users with fewer queued files aren't held up
+func processIntake(ctx context.Context) error {
+	// Queued files are saved outside any request into the database
+	s := &Server{store: sqlStore{}}
+	for ctx.Err() == nil {
+		items, err := ClaimUploadIntakes(INTAKE_WORKERS, time.Now().UTC().Add(-INTAKE_STALE))
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+
+		wg := sync.WaitGroup{}
+		for _, item := range items {
+			wg.Add(1)
+			go func(item UploadIntake) {
+				defer wg.Done()
+				s.saveIntakeFile(ctx, item)
+			}(item)
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// saveIntakeFile saves the staged file of the queued upload as an image of the user and records the
+// outcome, files failing with a server error are queued again until they run out of attempts
+func (s *Server) saveIntakeFile(ctx context.Context, item UploadIntake) {
+	image, err := s.saveStagedFile(ctx, item)
+	if err != nil {
+		status, message := uploadErrorStatus(err)
+		if status >= http.StatusInternalServerError && item.Attempts < INTAKE_ATTEMPTS {
+			logger.Error("failed attempt %v at saving queued upload %v, retrying: %v", item.Attempts, item.Id, err)
+			item.Status = INTAKE_QUEUED
+			item.Error = message
+			if err := UpdateUploadIntake(item); err != nil {
+				logger.Error("failed to queue upload %v again: %v", item.Id, err)
+			}
+			return
+		}
+		logger.Error("failed to save queued upload %v: %v", item.Id, err)
+		item.Status = INTAKE_FAILED
+		item.Code, item.Error = int32(status), message
+	} else {
+		item.Status = INTAKE_COMPLETE
+		item.Code, item.Error = http.StatusOK, ""
+		item.ImageId = image.Id
+		recordAuditFrom(item.Ip, int(item.Uid), AUDIT_UPLOAD, image.Id)
+	}
+
+	item.Completed = time.Now().UTC()
+	err = UpdateUploadIntake(item)
+	if err != nil {
+		// The file is kept so the upload can be reclaimed once it's stale
+		logger.Error("failed to record outcome of queued upload %v: %v", item.Id, err)
+		return
+	}
+	removeStagedFile(ctx, item)
+}
+
+// saveStagedFile copies the staged file of the upload to a temporary file and saves it as an image
+// of the user, errors are returned as *uploadError
+func (s *Server) saveStagedFile(ctx context.Context, item UploadIntake) (Image, error) {
+	object, err := storage.Open(ctx, item.StagingKey)
+	if err == ErrObjectNotFound {
+		return Image{}, &uploadError{http.StatusGone, "410 - Gone, the queued file is no longer available, upload it again", err}
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to read queued file, try again later", fmt.Errorf("failed to open %s: %v", item.StagingKey, err)}
+	}
+	defer object.Close()
+
+	tmp, err := os.CreateTemp("", "intake-*")
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to read queued file, try again later", fmt.Errorf("failed to create temporary file: %v", err)}
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, object)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to read queued file, try again later", fmt.Errorf("failed to copy %s: %v", item.StagingKey, err)}
+	}
+
+	tags := []string{}
+	if len(item.Tags) > 0 {
+		tags = strings.Split(item.Tags, ",")
+	}
+	return s.saveImage(ctx, int(item.Uid), tmp, &multipart.FileHeader{Filename: item.Filename, Size: size}, "", imageText{}, item.Shareable, item.Dedup, tags, acceptedTypes())
+}
+
+// discardUserIntake deletes the queued uploads of the user with their staged files
+func discardUserIntake(ctx context.Context, uid int32) error {
+	items, err := DeleteUserIntake(uid)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		removeStagedFile(ctx, item)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestIntakeResp ensures queued uploads link to their status and report completion once finished
+func TestIntakeResp(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	resp := UploadIntake{Id: 5, Filename: "a.png", Status: INTAKE_PROCESSING, Created: now}.Resp()
+	if resp.StatusUrl != "/image/intake/5" || resp.Completed != nil || resp.Created == nil {
+		t.Errorf("wrong processing upload: got %+v", resp)
+	}
+
+	for _, status := range []string{INTAKE_COMPLETE, INTAKE_FAILED} {
+		resp = UploadIntake{Id: 5, Status: status, Created: now, Completed: now.Add(time.Minute)}.Resp()
+		if resp.Completed == nil || !time.Time(*resp.Completed).Equal(now.Add(time.Minute)) {
+			t.Errorf("wrong %s upload: got %+v", status, resp)
+		}
+	}
+}
+
+// TestIntakeUpload ensures queued files are answered with 202 and status URLs reporting the image
+// each file was saved as once the queue is processed
+func TestIntakeUpload(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+	router := configureRoutes()
+
+	req := batchUploadRequest(t, token, map[string][]byte{
+		"small.png": testImage(t, "png", 16),
+		"large.png": testImage(t, "png", 24),
+		"notes.txt": []byte("not an image"),
+	})
+	req.URL, _ = url.Parse("/image/intake")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("wrong code queuing uploads: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	queued := []IntakeResp{}
+	json.Unmarshal(rr.Body.Bytes(), &queued)
+	if len(queued) != 3 {
+		t.Fatalf("wrong number of queued uploads: got %+v", queued)
+	}
+	for _, resp := range queued {
+		if resp.Id == 0 || resp.Status != INTAKE_QUEUED || len(resp.StatusUrl) == 0 {
+			t.Errorf("wrong queued upload: got %+v", resp)
+		}
+	}
+
+	err := processIntake(context.Background())
+	if err != nil {
+		t.Fatalf("failed to process queued uploads: %v", err)
+	}
+
+	for _, resp := range queued {
+		req := httptest.NewRequest("GET", resp.StatusUrl, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		status := IntakeResp{}
+		json.Unmarshal(rr.Body.Bytes(), &status)
+		if resp.Filename == "notes.txt" {
+			if status.Status != INTAKE_FAILED || status.Code != http.StatusBadRequest || status.ImageId != 0 {
+				t.Errorf("expected %s to fail: got %v %+v", resp.Filename, rr.Code, status)
+			}
+			continue
+		}
+		if rr.Code != http.StatusOK || status.Status != INTAKE_COMPLETE || status.ImageId == 0 || status.Completed == nil {
+			t.Errorf("expected %s to be saved: got %v %+v", resp.Filename, rr.Code, status)
+		}
+	}
+
+	// Uploads of other users aren't found
+	stranger := createTestUser(t, "stranger")
+	other, _, _ := generateJWT(int(stranger.Uid), stranger.Email)
+	req = httptest.NewRequest("GET", queued[0].StatusUrl, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", other))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for upload of another user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+/*
+	This file accepts access tokens issued by an external OAuth2 authorization server. Bearer tokens
+	that aren't signed by the server are sent to the introspection endpoint of the authorization
+	server (RFC 7662) named by OAUTH_INTROSPECTION_URL, and active tokens act for the user linked to
+	their subject through the identities of the login provider named by OAUTH_INTROSPECTION_PROVIDER.
+	Results are cached briefly so the authorization server isn't asked on every request, revoked
+	tokens are refused once their cached result expires.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	INTROSPECTION_PROVIDER  = "introspection" // Default if OAUTH_INTROSPECTION_PROVIDER env variable is not defined
+	INTROSPECTION_CACHE_TTL = time.Minute     // Longest an introspection result is reused
+	INTROSPECTION_CACHE_MAX = 1000            // Results cached at once
+)
+
+// Introspection is the response of an introspection endpoint
+type Introspection struct {
+	Active   bool   `json:"active"`
+	Subject  string `json:"sub"`
+	Scope    string `json:"scope"`
+	ClientId string `json:"client_id"`
+	Exp      int64  `json:"exp"`
+}
+
+// introspectionEntry is a cached introspection result
+type introspectionEntry struct {
+	result  Introspection
+	expires time.Time
+}
+
+// introspections caches results by the hash of the token
+var (
+	introspections     = map[string]introspectionEntry{}
+	introspectionsLock sync.Mutex
+)
+
+// getIntrospectionProvider returns the login provider whose identities the subjects of introspected
+// tokens are linked to defined by the OAUTH_INTROSPECTION_PROVIDER environment variable
+func getIntrospectionProvider() string {
+	provider := os.Getenv("OAUTH_INTROSPECTION_PROVIDER")
+	if len(provider) == 0 {
+		provider = INTROSPECTION_PROVIDER
+	}
+	return provider
+}
+
+// introspect returns the introspection of the token by the authorization server, cached results are
+// reused until they expire or the token does
+func introspect(ctx context.Context, endpoint string, token string) (Introspection, error) {
+	key := hashToken(token)
+	now := time.Now()
+
+	introspectionsLock.Lock()
+	entry, ok := introspections[key]
+	introspectionsLock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.result, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return Introspection{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id := os.Getenv("OAUTH_INTROSPECTION_CLIENT_ID"); len(id) > 0 {
+		req.SetBasicAuth(id, os.Getenv("OAUTH_INTROSPECTION_SECRET"))
+	}
+
+	result := Introspection{}
+	err = doProviderRequest(req, &result)
+	if err != nil {
+		return Introspection{}, fmt.Errorf("failed to introspect token: %v", err)
+	}
+
+	expires := now.Add(INTROSPECTION_CACHE_TTL)
+	if result.Exp > 0 && time.Unix(result.Exp, 0).Before(expires) {
+		expires = time.Unix(result.Exp, 0)
+	}
+	introspectionsLock.Lock()
+	defer introspectionsLock.Unlock()
+	if len(introspections) >= INTROSPECTION_CACHE_MAX {
+		introspections = map[string]introspectionEntry{}
+	}
+	introspections[key] = introspectionEntry{result, expires}
+	return result, nil
+}
+
+// introspectionResolver authenticates bearer tokens of the authorization server named by OAUTH_INTROSPECTION_URL
+type introspectionResolver struct{}
+
+func (introspectionResolver) Name() string {
+	return AUTH_INTROSPECTION
+}
+
+func (introspectionResolver) Resolve(req *http.Request) (Principal, bool, error) {
+	endpoint := os.Getenv("OAUTH_INTROSPECTION_URL")
+	token := bearerToken(req)
+	if len(endpoint) == 0 || len(token) == 0 || signedToken(token) {
+		return Principal{}, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), LOGIN_TIMEOUT)
+	defer cancel()
+	result, err := introspect(ctx, endpoint, token)
+	if err != nil {
+		return Principal{}, true, err
+	}
+	if !result.Active || (result.Exp > 0 && time.Now().Unix() >= result.Exp) {
+		return Principal{}, true, fmt.Errorf("token is not active")
+	}
+
+	identity, found, err := GetUserIdentity(getIntrospectionProvider(), result.Subject)
+	if err != nil {
+		return Principal{}, true, err
+	}
+	if !found {
+		return Principal{}, true, fmt.Errorf("subject %q is not linked to a user", result.Subject)
+	}
+	user, err := GetUserById(identity.Uid)
+	if err != nil {
+		return Principal{}, true, err
+	}
+
+	return Principal{Uid: int(user.Uid), Email: user.Email}, true, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// testIntrospectionServer serves introspections of the tokens, counting the requests it receives
+func testIntrospectionServer(t *testing.T, tokens map[string]Introspection, requests *int) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*requests++
+		if id, secret, _ := req.BasicAuth(); id != "api" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(tokens[req.FormValue("token")])
+	}))
+	t.Cleanup(server.Close)
+	os.Setenv("OAUTH_INTROSPECTION_CLIENT_ID", "api")
+	os.Setenv("OAUTH_INTROSPECTION_SECRET", "secret")
+	t.Cleanup(func() {
+		os.Unsetenv("OAUTH_INTROSPECTION_CLIENT_ID")
+		os.Unsetenv("OAUTH_INTROSPECTION_SECRET")
+	})
+	return server.URL
+}
+
+// TestIntrospect ensures introspections are cached no longer than the token is valid
+func TestIntrospect(t *testing.T) {
+	requests := 0
+	endpoint := testIntrospectionServer(t, map[string]Introspection{
+		"active":   {Active: true, Subject: "abc", Exp: time.Now().Add(time.Hour).Unix()},
+		"expiring": {Active: true, Subject: "abc", Exp: time.Now().Unix()},
+	}, &requests)
+
+	for i := 0; i < 3; i++ {
+		result, err := introspect(context.Background(), endpoint, "active")
+		if err != nil || !result.Active || result.Subject != "abc" {
+			t.Fatalf("wrong introspection: got %+v %v", result, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected introspection to be cached: got %v requests", requests)
+	}
+
+	introspect(context.Background(), endpoint, "expiring")
+	introspect(context.Background(), endpoint, "expiring")
+	if requests != 3 {
+		t.Errorf("expected introspection of expired token not to be cached: got %v requests", requests)
+	}
+
+	if result, err := introspect(context.Background(), endpoint, "unknown"); err != nil || result.Active {
+		t.Errorf("expected unknown token to be inactive: got %+v %v", result, err)
+	}
+	os.Setenv("OAUTH_INTROSPECTION_SECRET", "wrong")
+	if _, err := introspect(context.Background(), endpoint, "refused"); err == nil {
+		t.Errorf("expected refused introspection to fail")
+	}
+}
+
+// TestIntrospectedTokens ensures active tokens of the authorization server act for the user linked to their subject
+func TestIntrospectedTokens(t *testing.T) {
+	defer os.Unsetenv("OAUTH_INTROSPECTION_URL")
+	user := createTestUser(t, "user")
+	err := AddUserIdentity(UserIdentity{Uid: user.Uid, Provider: INTROSPECTION_PROVIDER, Subject: testEmail(t, "subject"), Created: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("failed to link identity: %v", err)
+	}
+
+	requests := 0
+	os.Setenv("OAUTH_INTROSPECTION_URL", testIntrospectionServer(t, map[string]Introspection{
+		"linked":   {Active: true, Subject: testEmail(t, "subject")},
+		"unlinked": {Active: true, Subject: testEmail(t, "unlinked")},
+		"revoked":  {Active: false, Subject: testEmail(t, "subject")},
+	}, &requests))
+
+	router := configureRoutes()
+	for token, want := range map[string]int{"linked": http.StatusOK, "unlinked": http.StatusUnauthorized, "revoked": http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/user", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("wrong code for %s token: got %v want %v", token, rr.Code, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	JWKS_MAX_AGE = 300 // Seconds verifiers may cache the published keys
+)
+
+// JWK is the public key of an asymmetric signing key as a JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"` // RSA modulus
+	E   string `json:"e,omitempty"` // RSA exponent
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"` // EC coordinates
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the set of keys published at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwkEncode encodes the unsigned integer big endian in base64url without padding, left padded to size bytes
+func jwkEncode(value *big.Int, size int) string {
+	buf := value.Bytes()
+	if len(buf) < size {
+		buf = append(make([]byte, size-len(buf)), buf...)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// jwk returns the public key of the key, reporting false for HMAC keys which are never published
+func (k signingKey) jwk() (JWK, bool) {
+	key := JWK{Kid: k.Id, Use: "sig", Alg: k.Method.Alg()}
+	switch public := k.Verifier.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = jwkEncode(public.N, 0)
+		key.E = jwkEncode(big.NewInt(int64(public.E)), 0)
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = public.Curve.Params().Name
+		key.X = jwkEncode(public.X, size)
+		key.Y = jwkEncode(public.Y, size)
+	default:
+		return JWK{}, false
+	}
+	return key, true
+}
+
+// jwks returns the public keys of the asymmetric keys of the keyring
+func (k keyring) jwks() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if jwk, ok := key.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// publishJWKS responds with the public keys verifying tokens signed with RS256 or ES256
+// so other services can verify tokens, no sign in is required
+func publishJWKS(w http.ResponseWriter, req *http.Request) {
+	js, err := json.Marshal(currentKeyring().jwks())
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", JWKS_MAX_AGE))
+	w.Write(js)
+}
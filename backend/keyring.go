@@ -0,0 +1,319 @@
+package main
+
+/*
+	This file implements the keyring of the keys signing auth tokens, client access tokens and
+	upload policies. Each key has an id written to the kid header of the tokens it signs. The first
+	key of the keyring signs new tokens and every key verifies them, so keys are rotated by adding a
+	new key after the current one, reloading each replica, moving the new key first and removing the
+	old key once the tokens it signed have expired. Keys are read from SIGNING_KEYS_FILE or
+	SIGNING_KEYS and reloaded on SIGHUP or by administrators without restarting. Tokens without a
+	kid were signed before key ids were introduced and are verified with the key whose id is
+	default, the id of the SIGNING_KEY key used when no keyring is configured.
+
+	Keys are HMAC secrets shared by every replica or RSA and ECDSA P-256 private keys read from PEM
+	files, signing tokens with RS256 and ES256. The public keys of asymmetric keys are published at
+	/.well-known/jwks.json so other services can verify tokens without holding a secret.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/inflowml/logger"
+)
+
+const (
+	LEGACY_KEY_ID      = "default" // Id of the SIGNING_KEY key when no keyring is configured
+	SIGNING_KEY_MIN    = 16        // Minimum length of keyring secrets
+	SIGNING_KEYS_LIMIT = 10        // Maximum number of keys in the keyring
+	RSA_KEY_BITS_MIN   = 2048      // Minimum size of RSA signing keys
+	PEM_KEY_PREFIX     = "@"       // Prefix of keyring entries naming a PEM file instead of a secret
+)
+
+// keyIdPattern matches valid key ids
+var keyIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// signingKey is a key of the keyring, its id and the algorithm of the tokens it signs
+type signingKey struct {
+	Id       string
+	Method   jwt.SigningMethod
+	Signer   interface{} // Secret of HMAC keys, otherwise the private key
+	Verifier interface{} // Secret of HMAC keys, otherwise the public key
+}
+
+// hmacKey returns the HS256 key of the secret
+func hmacKey(id string, secret []byte) signingKey {
+	return signingKey{Id: id, Method: jwt.SigningMethodHS256, Signer: secret, Verifier: secret}
+}
+
+// pemKey returns the RS256 or ES256 key of the RSA or ECDSA P-256 private key encoded in the PEM file
+func pemKey(id string, path string) (signingKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("failed to read key %s: %v", id, err)
+	}
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return signingKey{}, fmt.Errorf("key %s is not PEM encoded", id)
+	}
+
+	var private interface{}
+	private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("key %s is not an RSA or ECDSA private key", id)
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < RSA_KEY_BITS_MIN {
+			return signingKey{}, fmt.Errorf("RSA key %s must be at least %v bits", id, RSA_KEY_BITS_MIN)
+		}
+		return signingKey{Id: id, Method: jwt.SigningMethodRS256, Signer: private, Verifier: &private.PublicKey}, nil
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return signingKey{}, fmt.Errorf("ECDSA key %s must use the P-256 curve", id)
+		}
+		return signingKey{Id: id, Method: jwt.SigningMethodES256, Signer: private, Verifier: &private.PublicKey}, nil
+	default:
+		return signingKey{}, fmt.Errorf("key %s is not an RSA or ECDSA private key", id)
+	}
+}
+
+// keyring holds the keys verifying tokens, the first key signs new tokens
+type keyring struct {
+	keys []signingKey
+}
+
+// KeyringResp lists the ids of the keys of the keyring, secrets are never listed
+type KeyringResp struct {
+	Current string   `json:"current"` // Key signing new tokens
+	Keys    []string `json:"keys"`    // Every key verifying tokens
+}
+
+// signingKeys is the keyring used to sign and verify tokens, the SIGNING_KEY key until reloadKeyring is called
+var (
+	signingKeys     = keyring{keys: []signingKey{hmacKey(LEGACY_KEY_ID, getSigningKey())}}
+	signingKeysLock sync.RWMutex
+)
+
+// currentKeyring returns the keyring used to sign and verify tokens
+func currentKeyring() keyring {
+	signingKeysLock.RLock()
+	defer signingKeysLock.RUnlock()
+	return signingKeys
+}
+
+// configureKeyring sets the keyring used to sign and verify tokens
+func configureKeyring(ring keyring) {
+	signingKeysLock.Lock()
+	defer signingKeysLock.Unlock()
+	signingKeys = ring
+}
+
+// parseKeyring parses keys listed as kid:secret or kid:@path of a PEM file separated by commas or
+// new lines, lines starting with # are ignored. The first key signs new tokens
+func parseKeyring(list string) (keyring, error) {
+	ring := keyring{}
+	entries := strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || !keyIdPattern.MatchString(parts[0]) {
+			return keyring{}, fmt.Errorf("keys must be listed as kid:secret with ids of letters, digits, '.', '_' and '-'")
+		}
+		if _, ok := ring.find(parts[0]); ok {
+			return keyring{}, fmt.Errorf("key %s is listed more than once", parts[0])
+		}
+		if strings.HasPrefix(parts[1], PEM_KEY_PREFIX) {
+			key, err := pemKey(parts[0], strings.TrimPrefix(parts[1], PEM_KEY_PREFIX))
+			if err != nil {
+				return keyring{}, err
+			}
+			ring.keys = append(ring.keys, key)
+			continue
+		}
+		if len(parts[1]) < SIGNING_KEY_MIN {
+			return keyring{}, fmt.Errorf("secret of key %s must be at least %v characters", parts[0], SIGNING_KEY_MIN)
+		}
+		ring.keys = append(ring.keys, hmacKey(parts[0], []byte(parts[1])))
+	}
+
+	if len(ring.keys) == 0 {
+		return keyring{}, fmt.Errorf("no keys listed")
+	}
+	if len(ring.keys) > SIGNING_KEYS_LIMIT {
+		return keyring{}, fmt.Errorf("at most %v keys may be listed", SIGNING_KEYS_LIMIT)
+	}
+	return ring, nil
+}
+
+// loadKeyring reads the keyring from the file named by SIGNING_KEYS_FILE or the SIGNING_KEYS
+// environment variable, falling back to the SIGNING_KEY key when neither is set
+func loadKeyring() (keyring, error) {
+	if path := os.Getenv("SIGNING_KEYS_FILE"); len(path) > 0 {
+		list, err := os.ReadFile(path)
+		if err != nil {
+			return keyring{}, fmt.Errorf("failed to read SIGNING_KEYS_FILE: %v", err)
+		}
+		ring, err := parseKeyring(string(list))
+		if err != nil {
+			return keyring{}, fmt.Errorf("invalid SIGNING_KEYS_FILE: %v", err)
+		}
+		return ring, nil
+	}
+
+	if list := os.Getenv("SIGNING_KEYS"); len(list) > 0 {
+		ring, err := parseKeyring(list)
+		if err != nil {
+			return keyring{}, fmt.Errorf("invalid SIGNING_KEYS: %v", err)
+		}
+		return ring, nil
+	}
+
+	return keyring{keys: []signingKey{hmacKey(LEGACY_KEY_ID, getSigningKey())}}, nil
+}
+
+// reloadKeyring reads the keyring and uses it to sign and verify tokens, the current keyring
+// is kept if the keyring can't be read
+func reloadKeyring() (keyring, error) {
+	ring, err := loadKeyring()
+	if err != nil {
+		return keyring{}, err
+	}
+	configureKeyring(ring)
+	logger.Info("Signing tokens with key %s, verifying keys %s", ring.current().Id, strings.Join(ring.ids(), ", "))
+	return ring, nil
+}
+
+// watchKeyring reloads the keyring whenever the process receives SIGHUP
+func watchKeyring() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			_, err := reloadKeyring()
+			if err != nil {
+				logger.Error("failed to reload signing keys, the current keys remain in use: %v", err)
+			}
+		}
+	}()
+}
+
+// current returns the key signing new tokens
+func (k keyring) current() signingKey {
+	return k.keys[0]
+}
+
+// find returns the key with the id, reporting false if the keyring has no such key
+func (k keyring) find(id string) (signingKey, bool) {
+	for _, key := range k.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// ids returns the ids of the keys of the keyring
+func (k keyring) ids() []string {
+	ids := []string{}
+	for _, key := range k.keys {
+		ids = append(ids, key.Id)
+	}
+	return ids
+}
+
+// signToken signs the claims with the current key of the keyring, naming the key in the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	key := currentKeyring().current()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.Signer)
+}
+
+// verificationKey returns the key of the keyring named by the kid header of the token for jwt parsing,
+// tokens without a kid are verified with the default key. Tokens must use the algorithm of their key
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	if len(id) == 0 {
+		id = LEGACY_KEY_ID
+	}
+	key, ok := currentKeyring().find(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], id)
+	}
+	return key.Verifier, nil
+}
+
+// listSigningKeys responds with the ids of the keys of the keyring
+func listSigningKeys(w http.ResponseWriter, req *http.Request) {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list signing keys: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	writeKeyring(w, currentKeyring())
+}
+
+// reloadSigningKeys reloads the keyring of this replica and responds with the ids of its keys
+func reloadSigningKeys(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to reload signing keys: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	ring, err := reloadKeyring()
+	if err != nil {
+		logger.Error("failed to reload signing keys sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("500 - Failed to reload signing keys, the current keys remain in use: %v", err)))
+		return
+	}
+
+	logger.Info("Administrator %v reloaded the signing keys", claims.Uid)
+	writeKeyring(w, ring)
+}
+
+// writeKeyring writes the ids of the keys of the keyring as the json response body
+func writeKeyring(w http.ResponseWriter, ring keyring) {
+	js, err := json.Marshal(KeyringResp{Current: ring.current().Id, Keys: ring.ids()})
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
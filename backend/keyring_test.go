@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestParseKeyring ensures keys are listed in order and invalid lists are refused
+func TestParseKeyring(t *testing.T) {
+	ring, err := parseKeyring("2026-10:0123456789abcdef0123, 2026-04:fedcba9876543210fedc:with:colons\n# retired\n")
+	if err != nil || !reflect.DeepEqual(ring.ids(), []string{"2026-10", "2026-04"}) || ring.current().Id != "2026-10" {
+		t.Fatalf("wrong keyring: got %v %v", ring.ids(), err)
+	}
+	if key, _ := ring.find("2026-04"); string(key.Signer.([]byte)) != "fedcba9876543210fedc:with:colons" || key.Method != jwt.SigningMethodHS256 {
+		t.Errorf("wrong secret: got %+v", key)
+	}
+
+	for _, list := range []string{
+		"",
+		"# only comments",
+		"0123456789abcdef0123",
+		"a:short",
+		"bad id:0123456789abcdef0123",
+		"a:0123456789abcdef0123,a:fedcba9876543210fedc",
+	} {
+		if _, err := parseKeyring(list); err == nil {
+			t.Errorf("expected %q to be refused", list)
+		}
+	}
+}
+
+// TestLoadKeyring ensures the keyring file takes precedence over SIGNING_KEYS and the SIGNING_KEY
+// key is used when neither is set
+func TestLoadKeyring(t *testing.T) {
+	defer os.Unsetenv("SIGNING_KEYS")
+	defer os.Unsetenv("SIGNING_KEYS_FILE")
+
+	ring, err := loadKeyring()
+	if err != nil || !reflect.DeepEqual(ring.ids(), []string{LEGACY_KEY_ID}) || string(ring.current().Signer.([]byte)) != string(getSigningKey()) {
+		t.Errorf("wrong default keyring: got %v %v", ring.ids(), err)
+	}
+
+	os.Setenv("SIGNING_KEYS", "env:0123456789abcdef0123")
+	if ring, err := loadKeyring(); err != nil || ring.current().Id != "env" {
+		t.Errorf("wrong keyring from SIGNING_KEYS: got %v %v", ring.ids(), err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte("file:0123456789abcdef0123\n"), 0600)
+	os.Setenv("SIGNING_KEYS_FILE", path)
+	if ring, err := loadKeyring(); err != nil || ring.current().Id != "file" {
+		t.Errorf("wrong keyring from SIGNING_KEYS_FILE: got %v %v", ring.ids(), err)
+	}
+
+	os.Setenv("SIGNING_KEYS_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := loadKeyring(); err == nil {
+		t.Errorf("expected missing SIGNING_KEYS_FILE to be refused")
+	}
+}
+
+// TestKeyRotation ensures tokens signed by an older key verify while it remains in the keyring and new
+// tokens are signed with the current key
+func TestKeyRotation(t *testing.T) {
+	defer configureKeyring(currentKeyring())
+
+	old, _ := parseKeyring("old:0123456789abcdef0123")
+	configureKeyring(old)
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	// Rotate to a new key keeping the old key for verification
+	rotated, _ := parseKeyring("new:fedcba9876543210fedc,old:0123456789abcdef0123")
+	configureKeyring(rotated)
+	if _, err := parseToken(token); err != nil {
+		t.Errorf("expected token of the old key to verify: %v", err)
+	}
+	next, _, _ := generateJWT(1, testUser.Email)
+	parsed, _ := jwt.Parse(next, verificationKey)
+	if parsed == nil || !parsed.Valid || parsed.Header["kid"] != "new" {
+		t.Errorf("expected token to be signed by the new key: got %+v", parsed)
+	}
+
+	// Retire the old key
+	retired, _ := parseKeyring("new:fedcba9876543210fedc")
+	configureKeyring(retired)
+	if _, err := parseToken(token); err == nil {
+		t.Errorf("expected token of the retired key to be refused")
+	}
+	if _, err := parseToken(next); err != nil {
+		t.Errorf("expected token of the new key to verify: %v", err)
+	}
+
+	// Tokens signed before key ids were introduced are verified with the default key
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &JWTClaims{Uid: 1}).SignedString([]byte("0123456789abcdef0123"))
+	if _, err := parseToken(legacy); err == nil {
+		t.Errorf("expected token without a kid to be refused without a default key")
+	}
+	withDefault, _ := parseKeyring("new:fedcba9876543210fedc,default:0123456789abcdef0123")
+	configureKeyring(withDefault)
+	if _, err := parseToken(legacy); err != nil {
+		t.Errorf("expected token without a kid to verify with the default key: %v", err)
+	}
+}
+
+// TestAsymmetricKeys ensures RSA and ECDSA keys read from PEM files sign tokens verified with their
+// public keys, which are published at /.well-known/jwks.json while HMAC secrets never are
+func TestAsymmetricKeys(t *testing.T) {
+	defer configureKeyring(currentKeyring())
+	dir := t.TempDir()
+	writePEM := func(name string, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+		return path
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDer, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDer, _ := x509.MarshalECPrivateKey(ecKey)
+	rsaPath := writePEM("rsa.pem", "PRIVATE KEY", rsaDer)
+	ecPath := writePEM("ec.pem", "EC PRIVATE KEY", ecDer)
+
+	ring, err := parseKeyring("rsa:@" + rsaPath + ",ec:@" + ecPath + ",hmac:0123456789abcdef0123")
+	if err != nil {
+		t.Fatalf("failed to parse keyring: %v", err)
+	}
+
+	tokens := map[string]string{}
+	for _, key := range ring.keys {
+		// Sign with each key in turn by listing it first
+		rotated := keyring{keys: []signingKey{key}}
+		for _, other := range ring.keys {
+			if other.Id != key.Id {
+				rotated.keys = append(rotated.keys, other)
+			}
+		}
+		configureKeyring(rotated)
+		tokens[key.Id], _, err = generateJWT(1, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to sign with %s: %v", key.Id, err)
+		}
+	}
+	for id, token := range tokens {
+		parsed, err := jwt.Parse(token, verificationKey)
+		if err != nil || parsed.Header["kid"] != id || parsed.Method.Alg() != map[string]string{"rsa": "RS256", "ec": "ES256", "hmac": "HS256"}[id] {
+			t.Errorf("wrong token of %s: got %+v %v", id, parsed, err)
+		}
+	}
+
+	// Tokens can't switch the algorithm of a key, an HMAC token naming the RSA key is refused
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &JWTClaims{Uid: 1})
+	forged.Header["kid"] = "rsa"
+	forgedStr, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	if _, err := parseToken(forgedStr); err == nil {
+		t.Errorf("expected HMAC token naming the RSA key to be refused")
+	}
+
+	// The public keys are published and verify tokens without the keyring
+	rr := httptest.NewRecorder()
+	configureRoutes().ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	set := JWKSet{}
+	json.Unmarshal(rr.Body.Bytes(), &set)
+	if rr.Code != http.StatusOK || len(set.Keys) != 2 || set.Keys[0].Kid != "rsa" || set.Keys[1].Kid != "ec" || set.Keys[1].Crv != "P-256" {
+		t.Fatalf("wrong published keys: got %v %+v", rr.Code, set)
+	}
+	decode := func(value string) *big.Int {
+		buf, _ := base64.RawURLEncoding.DecodeString(value)
+		return new(big.Int).SetBytes(buf)
+	}
+	published := &rsa.PublicKey{N: decode(set.Keys[0].N), E: int(decode(set.Keys[0].E).Int64())}
+	if _, err := jwt.Parse(tokens["rsa"], func(*jwt.Token) (interface{}, error) { return published, nil }); err != nil {
+		t.Errorf("expected published RSA key to verify token: %v", err)
+	}
+	publishedEC := &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(set.Keys[1].X), Y: decode(set.Keys[1].Y)}
+	if _, err := jwt.Parse(tokens["ec"], func(*jwt.Token) (interface{}, error) { return publishedEC, nil }); err != nil {
+		t.Errorf("expected published ECDSA key to verify token: %v", err)
+	}
+
+	// Weak and unsupported keys are refused
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p384Der, _ := x509.MarshalECPrivateKey(p384Key)
+	for _, path := range []string{
+		writePEM("small.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallKey)),
+		writePEM("p384.pem", "EC PRIVATE KEY", p384Der),
+		writePEM("public.pem", "PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+		filepath.Join(dir, "missing.pem"),
+	} {
+		if _, err := parseKeyring("key:@" + path); err == nil {
+			t.Errorf("expected %s to be refused", filepath.Base(path))
+		}
+	}
+}
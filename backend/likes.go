@@ -0,0 +1,137 @@
+package main
+
+/*
+	This file lets users like the images they can see so clients can build a favorites view.
+	Any image the user may view can be liked once, including their own, and the metadata of an
+	image reports how many users like it. /image/meta?liked=true lists the liked images that
+	remain visible to the user.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	LIKE_TABLE = "image_like"
+)
+
+// ImageLike records that a user likes an image tagged for sql serialization
+type ImageLike struct {
+	Id      int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId int32     `sql:"image_id"`
+	Uid     int32     `sql:"uid"`
+	Created time.Time `sql:"created"`
+}
+
+// LikeResp reports whether the user likes the image and how many users do
+type LikeResp struct {
+	ImageId   int32 `json:"imageId"`
+	Liked     bool  `json:"liked"`
+	LikeCount int64 `json:"likeCount"`
+}
+
+// viewableImage retrieves the image in the url if the user owns it, was granted access or it is publicly
+// shared by an owner who hasn't blocked the user, writes the error response and returns false otherwise
+func (s *Server) viewableImage(w http.ResponseWriter, req *http.Request, uid int) (Image, bool) {
+	image, err := s.validateVars(req.Context(), mux.Vars(req))
+	if err != nil {
+		if strings.Contains(err.Error(), "404 - Not found") {
+			logger.Error("image data does not exist sending 404: %v", err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not found, no image with that information available"))
+			return Image{}, false
+		}
+		logger.Error("Failed to validate vars sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return Image{}, false
+	}
+
+	visible, err := s.canViewImage(req.Context(), uid, image)
+	if err == nil && !visible {
+		visible, err = s.publiclyShared(req.Context(), image)
+		if err == nil && visible {
+			visible, err = s.canInteract(req.Context(), uid, image.Uid)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to authorize image access sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve image, try again later"))
+		return Image{}, false
+	}
+
+	// Images the user can't view are reported as not found
+	if !visible {
+		logger.Error("image %v not visible to user %v sending 404", image.Id, uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
+		return Image{}, false
+	}
+
+	return image, true
+}
+
+// likeImage records that the authenticated user likes the image in the url, liking an image twice has no effect
+func (s *Server) likeImage(w http.ResponseWriter, req *http.Request) {
+	s.setImageLike(w, req, true)
+}
+
+// unlikeImage removes the like of the authenticated user from the image in the url
+func (s *Server) unlikeImage(w http.ResponseWriter, req *http.Request) {
+	s.setImageLike(w, req, false)
+}
+
+// setImageLike adds or removes the like of the authenticated user and responds with the like count of the image
+func (s *Server) setImageLike(w http.ResponseWriter, req *http.Request, liked bool) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to like image sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	image, ok := s.viewableImage(w, req, claims.Uid)
+	if !ok {
+		return
+	}
+
+	if liked {
+		err = AddImageLike(ImageLike{ImageId: image.Id, Uid: int32(claims.Uid), Created: time.Now().UTC()})
+	} else {
+		err = DeleteImageLike(image.Id, int32(claims.Uid))
+	}
+	if err != nil {
+		logger.Error("failed to update like sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update like, try again later"))
+		return
+	}
+
+	counts, err := ImageLikeCounts([]int32{image.Id})
+	if err != nil {
+		logger.Error("failed to count likes sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve likes, try again later"))
+		return
+	}
+
+	js, err := json.Marshal(LikeResp{ImageId: image.Id, Liked: liked, LikeCount: counts[image.Id]})
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestLikes ensures users like images they can view once and list the images they like
+func TestLikes(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "liker")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	private := uploadTestImage(t, router, token, false)
+	public := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), private)
+	defer DeleteImageData(context.Background(), public)
+
+	send := func(method string, path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	like := func(method string, image Image, token string) (int, LikeResp) {
+		rr := send(method, strings.TrimPrefix(image.Ref, REF_URL)+"/like", token)
+		resp := LikeResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	if code, _ := like("POST", private, friendToken); code != http.StatusNotFound {
+		t.Errorf("wrong code for liking a private image: got %v want %v", code, http.StatusNotFound)
+	}
+	for i := 0; i < 2; i++ {
+		if code, resp := like("POST", public, friendToken); code != http.StatusOK || !resp.Liked || resp.LikeCount != 1 {
+			t.Errorf("failed to like image: got %v %+v", code, resp)
+		}
+	}
+	if code, resp := like("POST", public, token); code != http.StatusOK || resp.LikeCount != 2 {
+		t.Errorf("owner failed to like image: got %v %+v", code, resp)
+	}
+
+	rr := send("GET", "/image/meta?liked=true", friendToken)
+	resp := QueryResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.TotalResults != 1 || resp.ImageMeta[0].Id != public.Id || resp.ImageMeta[0].LikeCount != 2 {
+		t.Fatalf("wrong liked images: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("GET", "/image/meta?liked=maybe", friendToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid liked: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if code, resp := like("DELETE", public, friendToken); code != http.StatusOK || resp.Liked || resp.LikeCount != 1 {
+		t.Errorf("failed to remove like: got %v %+v", code, resp)
+	}
+	rr = send("GET", "/image/meta?liked=true", friendToken)
+	resp = QueryResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TotalResults != 0 {
+		t.Errorf("unliked image still listed: got %s", rr.Body.String())
+	}
+}
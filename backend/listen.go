@@ -0,0 +1,156 @@
+package main
+
+/*
+	This file configures how the server accepts connections. The bind address and TLS are part of
+	the server configuration: certificates are either loaded from files or obtained from Let's Encrypt with
+	autocert for the listed domains. When TLS is enabled a second plain HTTP listener may redirect
+	clients to HTTPS, it also answers the ACME challenges used by autocert. HTTPS listeners negotiate
+	HTTP/2 unless it is disabled. Every listener bounds the time a client may take to send its
+	request and how long idle connections are kept, so slow clients can't hold connections open.
+*/
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	AUTOCERT_CACHE = "certs" // Directory caching certificates if AUTOCERT_CACHE env variable is not defined
+
+	READ_HEADER_TIMEOUT = 10      // Seconds a client may take to send the request headers
+	READ_TIMEOUT        = 300     // Seconds a client may take to send the whole request including uploads
+	WRITE_TIMEOUT       = 0       // Seconds a response may take to be written, 0 lets event streams and exports run
+	IDLE_TIMEOUT        = 120     // Seconds an idle keep-alive connection is kept open
+	MAX_HEADER_BYTES    = 1 << 16 // Largest request headers accepted
+	MIN_HEADER_BYTES    = 4096    // Smallest header limit allowed, browsers send headers of a few KB with cookies
+)
+
+// ListenConfig describes the listeners of the server
+type ListenConfig struct {
+	Addr         string   `yaml:"addr"`     // Address of the main listener
+	CertFile     string   `yaml:"certFile"` // TLS certificate and key files, empty unless TLS uses files
+	KeyFile      string   `yaml:"keyFile"`
+	Domains      []string `yaml:"domains"` // Domains served with Let's Encrypt certificates, empty unless autocert is used
+	CacheDir     string   `yaml:"cacheDir"`
+	Email        string   `yaml:"email"`
+	RedirectAddr string   `yaml:"redirectAddr"` // Address of the plain HTTP listener redirecting to HTTPS, empty disables it
+
+	ReadHeaderTimeout int  `yaml:"readHeaderTimeout"` // Seconds
+	ReadTimeout       int  `yaml:"readTimeout"`       // Seconds, 0 disables the limit
+	WriteTimeout      int  `yaml:"writeTimeout"`      // Seconds, 0 disables the limit
+	IdleTimeout       int  `yaml:"idleTimeout"`       // Seconds
+	MaxHeaderBytes    int  `yaml:"maxHeaderBytes"`
+	HTTP2             bool `yaml:"http2"` // Negotiate HTTP/2 on HTTPS listeners
+}
+
+// TLS reports whether the main listener serves HTTPS
+func (c ListenConfig) TLS() bool {
+	return len(c.CertFile) > 0 || len(c.Domains) > 0
+}
+
+// validate returns a problem for each invalid listener limit
+func (c ListenConfig) validate() []string {
+	var problems []string
+	if c.ReadHeaderTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("listen.readHeaderTimeout (LISTEN_READ_HEADER_TIMEOUT) must be a positive number of seconds, got %v", c.ReadHeaderTimeout))
+	}
+	if c.ReadTimeout < 0 || c.ReadTimeout > 0 && c.ReadTimeout < c.ReadHeaderTimeout {
+		problems = append(problems, fmt.Sprintf("listen.readTimeout (LISTEN_READ_TIMEOUT) must be 0 or at least readHeaderTimeout (%v) seconds, got %v", c.ReadHeaderTimeout, c.ReadTimeout))
+	}
+	if c.WriteTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("listen.writeTimeout (LISTEN_WRITE_TIMEOUT) must be 0 or a positive number of seconds, got %v", c.WriteTimeout))
+	}
+	if c.IdleTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("listen.idleTimeout (LISTEN_IDLE_TIMEOUT) must be a positive number of seconds, got %v", c.IdleTimeout))
+	}
+	if c.MaxHeaderBytes < MIN_HEADER_BYTES {
+		problems = append(problems, fmt.Sprintf("listen.maxHeaderBytes (LISTEN_MAX_HEADER_BYTES) must be at least %v, got %v", MIN_HEADER_BYTES, c.MaxHeaderBytes))
+	}
+	return problems
+}
+
+// newServer returns a server of the handler on addr with the timeouts and limits of the configuration
+func newServer(config ListenConfig, addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	// A non-nil empty map stops HTTPS listeners from negotiating HTTP/2
+	if !config.HTTP2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return server
+}
+
+// listen serves the handler on the configured listeners until the main listener fails
+func listen(config ListenConfig, handler http.Handler) error {
+	server := newServer(config, config.Addr, handler)
+	redirect := httpsRedirect(config.Addr)
+
+	var manager *autocert.Manager
+	if len(config.Domains) > 0 {
+		manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.Domains...),
+			Cache:      autocert.DirCache(config.CacheDir),
+			Email:      config.Email,
+		}
+		server.TLSConfig = manager.TLSConfig()
+
+		// ACME http-01 challenges are answered by the redirect listener
+		redirect = manager.HTTPHandler(redirect)
+	}
+
+	if len(config.RedirectAddr) > 0 {
+		go func() {
+			logger.Info("Redirecting HTTP on %v to HTTPS", config.RedirectAddr)
+			err := newServer(config, config.RedirectAddr, redirect).ListenAndServe()
+			logger.Error("HTTP redirect listener stopped: %v", err)
+		}()
+	}
+
+	switch {
+	case manager != nil:
+		logger.Info("Initiating HTTPS Server on %v with certificates for %v", config.Addr, strings.Join(config.Domains, ", "))
+		return server.ListenAndServeTLS("", "")
+	case config.TLS():
+		logger.Info("Initiating HTTPS Server on %v", config.Addr)
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if config.HTTP2 {
+			server.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+		return server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+	default:
+		logger.Info("Initiating HTTP Server on %v", config.Addr)
+		return server.ListenAndServe()
+	}
+}
+
+// httpsRedirect returns a handler permanently redirecting requests to the same url on the HTTPS listener
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = h
+		}
+		if len(tlsPort) > 0 && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+
+		http.Redirect(w, req, fmt.Sprintf("https://%s%s", host, req.URL.RequestURI()), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHTTPSRedirect ensures plain HTTP requests are redirected to the same url on the HTTPS listener
+func TestHTTPSRedirect(t *testing.T) {
+	tt := []struct {
+		TLSAddr  string
+		Host     string
+		Expected string
+	}{
+		{":443", "picto.example.com", "https://picto.example.com/image/meta?page=2"},
+		{":443", "picto.example.com:80", "https://picto.example.com/image/meta?page=2"},
+		{":8443", "picto.example.com:8080", "https://picto.example.com:8443/image/meta?page=2"},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest("POST", "http://"+tc.Host+"/image/meta?page=2", nil)
+		rr := httptest.NewRecorder()
+		httpsRedirect(tc.TLSAddr).ServeHTTP(rr, req)
+		if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != tc.Expected {
+			t.Errorf("wrong redirect for %s to %s: got %v %s want %s", tc.Host, tc.TLSAddr, rr.Code, rr.Header().Get("Location"), tc.Expected)
+		}
+	}
+}
+
+// TestNewServer ensures listeners apply the configured timeouts and only negotiate HTTP/2 when enabled
+func TestNewServer(t *testing.T) {
+	config := defaultConfig().Listen
+	handler := http.NotFoundHandler()
+
+	server := newServer(config, ":8443", handler)
+	if server.Addr != ":8443" || server.ReadHeaderTimeout != READ_HEADER_TIMEOUT*time.Second || server.ReadTimeout != READ_TIMEOUT*time.Second ||
+		server.WriteTimeout != 0 || server.IdleTimeout != IDLE_TIMEOUT*time.Second || server.MaxHeaderBytes != MAX_HEADER_BYTES {
+		t.Errorf("wrong server limits: got %+v", server)
+	}
+	if server.TLSNextProto != nil {
+		t.Errorf("expected HTTP/2 to be negotiated by default")
+	}
+
+	config.HTTP2 = false
+	config.WriteTimeout = 60
+	server = newServer(config, ":8443", handler)
+	if server.TLSNextProto == nil || len(server.TLSNextProto) != 0 || server.WriteTimeout != time.Minute {
+		t.Errorf("expected HTTP/2 disabled with a write timeout: got %v %v", server.TLSNextProto, server.WriteTimeout)
+	}
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"os"
+
 	"github.com/inflowml/logger"
 )
 
 func main() {
 
-	// Initialize connection to SQL and establish tables
-	err := InitSQL()
+	// Load the configuration once, the file named by CONFIG_FILE is optional
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"))
 	if err != nil {
-		logger.Fatal("failed to init db: %v", err)
+		logger.Fatal("failed to load configuration: %v", err)
+	}
+	configureDB(config.Database)
+
+	// Initialize connection to SQL and establish tables
+	// read-only instances use the tables established by the primary
+	if readOnly() {
+		logger.Info("Read-only mode, changes are refused and tables are not initialized")
+	} else {
+		err = InitSQL()
+		if err != nil {
+			logger.Fatal("failed to init db: %v", err)
+		}
 	}
 
 	// Serve HTTP server and report fatal errors
-	logger.Fatal("Server encountered unrecoverable error: %v", serve())
+	logger.Fatal("Server encountered unrecoverable error: %v", serve(config))
 }
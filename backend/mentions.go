@@ -0,0 +1,286 @@
+package main
+
+/*
+	This file implements @username mentions in image captions. Users choose a username on their
+	profile and descriptions mentioning it link to them: mentions are resolved when the description
+	is saved and returned with the image as entities giving the mentioned user and the position of
+	the mention so clients can render links. Users newly mentioned in an image they can view are
+	notified, notifications are listed on /user/notifications and added to the outbox as
+	user.mentioned events for handlers delivering them by email or push.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	MENTION_TABLE      = "mention"
+	NOTIFICATION_TABLE = "notification"
+	MENTION_MAX        = 20 // Mentions resolved per text, later mentions are left as plain text
+
+	// Notification types
+	NOTIFY_MENTION = "mention"
+
+	// Event topics
+	EVENT_USER_MENTIONED = "user.mentioned"
+)
+
+// usernamePattern matches valid usernames, mentionPattern an @username preceded by the start of the text or a character that can't end a username or email
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+	mentionPattern  = regexp.MustCompile(`(?:^|[^A-Za-z0-9_@.])@([A-Za-z0-9_]{3,30})\b`)
+)
+
+// Mention is a user mentioned in the description of an image tagged for sql serialization
+type Mention struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId  int32     `sql:"image_id"`
+	Uid      int32     `sql:"uid"`
+	Username string    `sql:"username"`     // Username as written in the description
+	Offset   int32     `sql:"start_offset"` // Characters preceding the @ of the mention
+	Length   int32     `sql:"length"`       // Characters of the mention including the @
+	Created  time.Time `sql:"created"`
+}
+
+// MentionEntity is the json representation of a mention, offsets count unicode code points
+type MentionEntity struct {
+	Uid      int32  `json:"uid"`
+	Username string `json:"username"`
+	Offset   int32  `json:"offset"`
+	Length   int32  `json:"length"`
+}
+
+// Notification informs a user of an action of another user tagged for sql serialization
+type Notification struct {
+	Id       int32     `json:"id" sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32     `json:"-" sql:"uid"` // Notified user
+	Type     string    `json:"type" sql:"type"`
+	ActorUid int32     `json:"actorUid" sql:"actor_uid"`
+	ImageId  int32     `json:"imageId" sql:"image_id"`
+	Read     bool      `json:"read" sql:"read"`
+	Created  time.Time `json:"-" sql:"created"`
+}
+
+// NotificationResp is a notification with its time encoded for the API
+type NotificationResp struct {
+	Notification
+	Created Timestamp `json:"created"`
+}
+
+// NotificationsResp is a page of the user's notifications, newest first
+type NotificationsResp struct {
+	Page          int                `json:"page"`
+	PageSize      int                `json:"pageSize"`
+	TotalResults  int                `json:"totalResults"`
+	Unread        int                `json:"unread"`
+	Notifications []NotificationResp `json:"notifications"`
+}
+
+// MentionEvent is the payload of EVENT_USER_MENTIONED
+type MentionEvent struct {
+	NotificationId int32 `json:"notificationId"`
+	Uid            int32 `json:"uid"`
+	ActorUid       int32 `json:"actorUid"`
+	ImageId        int32 `json:"imageId"`
+}
+
+// validUsername reports whether the username may be chosen, an empty username clears it
+func validUsername(username string) bool {
+	return len(username) == 0 || usernamePattern.MatchString(username)
+}
+
+// parseMentions returns the mentions written in the text with their positions in code points,
+// at most MENTION_MAX are returned
+func parseMentions(text string) []MentionEntity {
+	mentions := []MentionEntity{}
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, MENTION_MAX) {
+		at := match[2] - 1
+		mentions = append(mentions, MentionEntity{
+			Username: text[match[2]:match[3]],
+			Offset:   int32(utf8.RuneCountInString(text[:at])),
+			Length:   int32(utf8.RuneCountInString(text[at:match[3]])),
+		})
+	}
+	return mentions
+}
+
+// resolveMentions returns the mentions of the text naming existing users
+func resolveMentions(text string) ([]MentionEntity, error) {
+	parsed := parseMentions(text)
+	if len(parsed) == 0 {
+		return parsed, nil
+	}
+
+	usernames := []string{}
+	for _, mention := range parsed {
+		usernames = append(usernames, mention.Username)
+	}
+	users, err := UsersByUsername(usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := []MentionEntity{}
+	for _, mention := range parsed {
+		if user, ok := users[strings.ToLower(mention.Username)]; ok {
+			mention.Uid = user.Uid
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions, nil
+}
+
+// updateMentions resolves the mentions of the image description replacing the recorded mentions
+// and notifies users mentioned for the first time who can view the image
+func (s *Server) updateMentions(ctx context.Context, image Image) ([]MentionEntity, error) {
+	mentions, err := resolveMentions(image.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := ReplaceImageMentions(image.Id, mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	return mentions, s.notifyMentions(ctx, image, mentions, previous)
+}
+
+// notifyMentions notifies the mentioned users who can view the image unless they were previously mentioned
+func (s *Server) notifyMentions(ctx context.Context, image Image, mentions []MentionEntity, previous []int32) error {
+	notified := map[int32]bool{image.Uid: true}
+	for _, uid := range previous {
+		notified[uid] = true
+	}
+	for _, mention := range mentions {
+		if notified[mention.Uid] {
+			continue
+		}
+		notified[mention.Uid] = true
+
+		visible, err := s.mentionVisible(ctx, mention.Uid, image)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			continue
+		}
+		err = AddNotification(Notification{Uid: mention.Uid, Type: NOTIFY_MENTION, ActorUid: image.Uid, ImageId: image.Id, Created: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mentionVisible reports whether the mentioned user can view the image, users the owner blocked can't view public images
+func (s *Server) mentionVisible(ctx context.Context, uid int32, image Image) (bool, error) {
+	shared, err := s.publiclyShared(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	if shared {
+		return s.canInteract(ctx, int(uid), image.Uid)
+	}
+	return s.canViewImage(ctx, int(uid), image)
+}
+
+// listNotifications returns a page of the authenticated user's notifications newest first,
+// only unread notifications are listed when unread=true
+func listNotifications(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for notifications sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	page := 0
+	if param := req.URL.Query().Get("page"); len(param) > 0 {
+		page, err = strconv.Atoi(param)
+		if err != nil || page < 0 {
+			logger.Error("invalid notifications page %q sending 400", param)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - page must be a non negative integer"))
+			return
+		}
+	}
+	unread := req.URL.Query().Get("unread") == "true"
+
+	notifications, total, unreadCount, err := UserNotifications(int32(claims.Uid), unread, page)
+	if err != nil {
+		logger.Error("failed to retrieve notifications sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve notifications, try again later"))
+		return
+	}
+
+	resp := NotificationsResp{Page: page, PageSize: PAGE_SIZE, TotalResults: total, Unread: unreadCount, Notifications: []NotificationResp{}}
+	for _, notification := range notifications {
+		resp.Notifications = append(resp.Notifications, NotificationResp{Notification: notification, Created: Timestamp(notification.Created)})
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal notifications sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve notifications, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// readNotifications accepts a json body with the ids of notifications to mark read,
+// every notification of the authenticated user is marked read when ids are omitted
+func readNotifications(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to read notifications sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var body struct {
+		Ids []int32 `json:"ids"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	if len(body.Ids) > PAGE_SIZE {
+		logger.Error("too many notifications to mark read sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - at most %v ids may be marked read at once", PAGE_SIZE)))
+		return
+	}
+
+	err = MarkNotificationsRead(int32(claims.Uid), body.Ids)
+	if err != nil {
+		logger.Error("failed to mark notifications read sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update notifications, try again later"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseMentions ensures mentions are found with their positions in code points and emails are ignored
+func TestParseMentions(t *testing.T) {
+	tt := []struct {
+		Text     string
+		Expected []MentionEntity
+	}{
+		{"@alice at the beach", []MentionEntity{{Username: "alice", Offset: 0, Length: 6}}},
+		{"Café with @Bob_1, @carol!", []MentionEntity{{Username: "Bob_1", Offset: 10, Length: 6}, {Username: "carol", Offset: 18, Length: 6}}},
+		{"(@dave)\n@erin's", []MentionEntity{{Username: "dave", Offset: 1, Length: 5}, {Username: "erin", Offset: 8, Length: 5}}},
+		{"mail bob@alice.com or @al", []MentionEntity{}},
+		{"@" + strings.Repeat("a", 31), []MentionEntity{}},
+	}
+	for _, tc := range tt {
+		if got := parseMentions(tc.Text); !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("wrong mentions in %q: got %+v want %+v", tc.Text, got, tc.Expected)
+		}
+	}
+
+	if got := parseMentions(strings.Repeat("@someone ", MENTION_MAX+5)); len(got) != MENTION_MAX {
+		t.Errorf("wrong number of mentions: got %v want %v", len(got), MENTION_MAX)
+	}
+}
+
+// TestValidUsername ensures usernames are 3 to 30 letters, digits or underscores
+func TestValidUsername(t *testing.T) {
+	for username, expected := range map[string]bool{"": true, "ann": true, "Jo_Doe_42": true, "al": false, "jo doe": false, "jo.doe": false, strings.Repeat("a", 31): false} {
+		if got := validUsername(username); got != expected {
+			t.Errorf("wrong validity of %q: got %v want %v", username, got, expected)
+		}
+	}
+}
+
+// TestMentions ensures mentions in descriptions resolve to users, are returned as entities and
+// notify users newly mentioned in images they can view
+func TestMentions(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "friend")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("PUT", "/user", `{"username": "Friend_1"}`, friendToken); rr.Code != http.StatusOK {
+		t.Fatalf("failed to choose username: got %v", rr.Code)
+	}
+	if rr := send("PUT", "/user", `{"username": "friend_1"}`, token); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code for taken username: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := send("PUT", "/user", `{"username": "no"}`, token); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid username: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	image := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), image)
+	path := fmt.Sprintf("/image/%v/%v", image.Uid, image.Id)
+	rr := send("PUT", path, `{"description": "With @friend_1 and @nobody"}`, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to update description: got %v", rr.Code)
+	}
+	updated := Image{}
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	expected := []MentionEntity{{Uid: friend.Uid, Username: "friend_1", Offset: 5, Length: 9}}
+	if !reflect.DeepEqual(updated.Mentions, expected) {
+		t.Errorf("wrong mentions: got %+v want %+v", updated.Mentions, expected)
+	}
+	stored, err := GetImageMeta(context.Background(), image.Id)
+	if err != nil || !reflect.DeepEqual(stored.Mentions, expected) {
+		t.Errorf("wrong stored mentions: got %+v %v", stored.Mentions, err)
+	}
+
+	// Editing the description doesn't notify users mentioned before again
+	send("PUT", path, `{"description": "Still with @Friend_1"}`, token)
+
+	rr = send("GET", "/user/notifications?unread=true", "", friendToken)
+	resp := NotificationsResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.TotalResults != 1 || resp.Unread != 1 || resp.Notifications[0].ImageId != image.Id || resp.Notifications[0].Type != NOTIFY_MENTION {
+		t.Fatalf("wrong notifications: got %v %+v", rr.Code, resp)
+	}
+
+	if rr := send("POST", "/user/notifications/read", `{}`, friendToken); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to mark notifications read: got %v", rr.Code)
+	}
+	rr = send("GET", "/user/notifications", "", friendToken)
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TotalResults != 1 || resp.Unread != 0 || !resp.Notifications[0].Read {
+		t.Errorf("wrong notifications after reading: got %+v", resp)
+	}
+}
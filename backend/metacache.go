@@ -0,0 +1,249 @@
+package main
+
+/*
+	This file caches image metadata in memory. GetImageMeta runs on every file request so the
+	metadata of recently requested images is kept in a least recently used cache of META_CACHE_SIZE
+	images for up to META_CACHE_TTL seconds. The store drops an image from the cache whenever it
+	changes the image, its tags, mentions, likes or ratings, and relays the change to the other
+	instances through PostgreSQL NOTIFY so they drop it too. View and download counts don't
+	invalidate the cache and may lag behind by up to the TTL. Hits and misses are exported at /metrics.
+*/
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	META_CACHE_SIZE    = 10000                    // Default if META_CACHE_SIZE env variable is not defined
+	META_CACHE_TTL     = 60                       // Default if META_CACHE_TTL env variable is not defined, in seconds
+	META_CACHE_CHANNEL = "image_meta_invalidated" // PostgreSQL channel relaying invalidations between instances
+	META_NOTIFY_MAX    = 7000                     // Bytes of ids relayed in a notification, larger invalidations clear the whole cache
+)
+
+// imageMetas caches the metadata returned by GetImageMeta
+var imageMetas = newMetaCache(getMetaCacheSize(), time.Duration(getMetaCacheTTL())*time.Second)
+
+// getMetaCacheSize returns the number of images whose metadata is cached, 0 disables the cache
+func getMetaCacheSize() int {
+	size, err := strconv.Atoi(os.Getenv("META_CACHE_SIZE"))
+	if err != nil || size < 0 {
+		size = META_CACHE_SIZE
+	}
+	return size
+}
+
+// getMetaCacheTTL returns the seconds cached metadata is served for
+func getMetaCacheTTL() int {
+	ttl, err := strconv.Atoi(os.Getenv("META_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = META_CACHE_TTL
+	}
+	return ttl
+}
+
+// metaCache is a least recently used cache of image metadata by image id whose entries expire after the ttl.
+// Every invalidation advances the generation so metadata read before it is not cached after it
+type metaCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	generation uint64
+	hits       uint64
+	misses     uint64
+	order      *list.List
+	entries    map[int32]*list.Element
+}
+
+type metaEntry struct {
+	image   Image
+	expires time.Time
+}
+
+func newMetaCache(maxEntries int, ttl time.Duration) *metaCache {
+	return &metaCache{maxEntries: maxEntries, ttl: ttl, order: list.New(), entries: map[int32]*list.Element{}}
+}
+
+// enabled reports whether metadata is cached
+func (c *metaCache) enabled() bool {
+	return c.maxEntries > 0
+}
+
+// Get returns a copy of the cached metadata of the image marking it as recently used, expired entries are dropped
+func (c *metaCache) Get(id int32, now time.Time) (Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if ok && now.After(elem.Value.(*metaEntry).expires) {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return Image{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return copyImage(elem.Value.(*metaEntry).image), true
+}
+
+// Generation returns the current generation, read it before retrieving the metadata passed to Add
+func (c *metaCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Add caches a copy of the metadata evicting the least recently used image when full,
+// metadata read before an invalidation of the given generation is not cached
+func (c *metaCache) Add(image Image, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxEntries <= 0 || generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[image.Id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, image.Id)
+	}
+
+	for c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*metaEntry).image.Id)
+	}
+
+	c.entries[image.Id] = c.order.PushFront(&metaEntry{image: copyImage(image), expires: now.Add(c.ttl)})
+}
+
+// Remove drops the images from the cache
+func (c *metaCache) Remove(ids ...int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, id := range ids {
+		if elem, ok := c.entries[id]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, id)
+		}
+	}
+}
+
+// Purge drops every image from the cache
+func (c *metaCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	c.entries = map[int32]*list.Element{}
+}
+
+// Stats returns the hits, misses and number of cached images
+func (c *metaCache) Stats() (uint64, uint64, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.order.Len()
+}
+
+// copyImage copies the image and its relations so callers may modify them without changing the cache
+func copyImage(image Image) Image {
+	if image.Tags != nil {
+		image.Tags = append([]string{}, image.Tags...)
+	}
+	if image.Mentions != nil {
+		image.Mentions = append([]MentionEntity{}, image.Mentions...)
+	}
+	return image
+}
+
+// metaInvalidation is an invalidation relayed between instances, no ids clears the whole cache
+type metaInvalidation struct {
+	Origin   string  `json:"origin"`
+	ImageIds []int32 `json:"imageIds"`
+}
+
+// invalidateImageMeta drops the images from the cache of every instance
+func invalidateImageMeta(ids ...int32) {
+	if !imageMetas.enabled() || len(ids) == 0 {
+		return
+	}
+	imageMetas.Remove(ids...)
+	relayMetaInvalidation(metaInvalidation{Origin: instanceId, ImageIds: ids})
+}
+
+// purgeImageMeta clears the cache of every instance
+func purgeImageMeta() {
+	if !imageMetas.enabled() {
+		return
+	}
+	imageMetas.Purge()
+	relayMetaInvalidation(metaInvalidation{Origin: instanceId})
+}
+
+// relayMetaInvalidation notifies the other instances of the invalidation,
+// too many ids to fit in a notification clear their whole cache instead
+func relayMetaInvalidation(invalidation metaInvalidation) {
+	js, err := json.Marshal(invalidation)
+	if err == nil && len(js) > META_NOTIFY_MAX {
+		js, err = json.Marshal(metaInvalidation{Origin: invalidation.Origin})
+	}
+	if err != nil {
+		logger.Error("failed to encode image meta invalidation: %v", err)
+		return
+	}
+	db, err := getDB()
+	if err != nil {
+		logger.Error("failed to relay image meta invalidation due to connection error: %v", err)
+		return
+	}
+	_, err = db.Exec("SELECT pg_notify($1, $2)", META_CACHE_CHANNEL, string(js))
+	if err != nil {
+		logger.Error("failed to relay image meta invalidation: %v", err)
+	}
+}
+
+// applyMetaInvalidation drops the images invalidated by another instance from the cache
+func applyMetaInvalidation(payload string) {
+	invalidation := metaInvalidation{}
+	err := json.Unmarshal([]byte(payload), &invalidation)
+	if err != nil {
+		logger.Error("failed to decode image meta invalidation, clearing the cache: %v", err)
+		imageMetas.Purge()
+		return
+	}
+	if invalidation.Origin == instanceId {
+		return
+	}
+	if len(invalidation.ImageIds) == 0 {
+		imageMetas.Purge()
+		return
+	}
+	imageMetas.Remove(invalidation.ImageIds...)
+}
+
+// writeMetaCacheMetrics writes the hits, misses and size of the image meta cache in the prometheus text format
+func writeMetaCacheMetrics(w io.Writer) {
+	hits, misses, size := imageMetas.Stats()
+	fmt.Fprintf(w, "# HELP picto_meta_cache_hits_total Image metadata lookups served from the cache.\n")
+	fmt.Fprintf(w, "# TYPE picto_meta_cache_hits_total counter\n")
+	fmt.Fprintf(w, "picto_meta_cache_hits_total %v\n", hits)
+	fmt.Fprintf(w, "# HELP picto_meta_cache_misses_total Image metadata lookups read from the database.\n")
+	fmt.Fprintf(w, "# TYPE picto_meta_cache_misses_total counter\n")
+	fmt.Fprintf(w, "picto_meta_cache_misses_total %v\n", misses)
+	fmt.Fprintf(w, "# HELP picto_meta_cache_entries Images whose metadata is cached.\n")
+	fmt.Fprintf(w, "# TYPE picto_meta_cache_entries gauge\n")
+	fmt.Fprintf(w, "picto_meta_cache_entries %v\n", size)
+}
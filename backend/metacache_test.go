@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestMetaCache ensures the least recently used and expired metadata is dropped and invalidations win over concurrent reads
+func TestMetaCache(t *testing.T) {
+	now := time.Now()
+	cache := newMetaCache(2, time.Minute)
+
+	for _, id := range []int32{1, 2} {
+		cache.Add(Image{Id: id, Tags: []string{"a"}}, cache.Generation(), now)
+	}
+	// Reading the first image makes the second the least recently used
+	image, ok := cache.Get(1, now)
+	if !ok || image.Id != 1 {
+		t.Fatalf("cached image missing: got %+v %v", image, ok)
+	}
+	image.Tags[0] = "changed"
+
+	cache.Add(Image{Id: 3}, cache.Generation(), now)
+	if _, ok := cache.Get(2, now); ok {
+		t.Errorf("least recently used image not evicted")
+	}
+	if image, ok := cache.Get(1, now); !ok || image.Tags[0] != "a" {
+		t.Errorf("cached image changed by caller: got %+v %v", image, ok)
+	}
+
+	if _, ok := cache.Get(3, now.Add(2*time.Minute)); ok {
+		t.Errorf("expired image served")
+	}
+
+	// Metadata read before an invalidation isn't cached
+	generation := cache.Generation()
+	cache.Remove(1)
+	cache.Add(Image{Id: 1}, generation, now)
+	if _, ok := cache.Get(1, now); ok {
+		t.Errorf("image read before invalidation cached")
+	}
+
+	hits, misses, size := cache.Stats()
+	if hits != 2 || misses != 3 || size != 0 {
+		t.Errorf("wrong stats: got %v hits %v misses %v images", hits, misses, size)
+	}
+
+	disabled := newMetaCache(0, time.Minute)
+	disabled.Add(Image{Id: 1}, disabled.Generation(), now)
+	if _, ok := disabled.Get(1, now); ok {
+		t.Errorf("image cached while the cache is disabled")
+	}
+}
+
+// TestApplyMetaInvalidation ensures invalidations relayed by other instances drop the images from the cache
+func TestApplyMetaInvalidation(t *testing.T) {
+	defer func(configured *metaCache) { imageMetas = configured }(imageMetas)
+	imageMetas = newMetaCache(10, time.Minute)
+
+	now := time.Now()
+	for _, id := range []int32{1, 2, 3} {
+		imageMetas.Add(Image{Id: id}, imageMetas.Generation(), now)
+	}
+
+	applyMetaInvalidation(fmt.Sprintf(`{"origin": %q, "imageIds": [1]}`, instanceId))
+	if _, ok := imageMetas.Get(1, now); !ok {
+		t.Errorf("invalidation of this instance applied twice")
+	}
+	applyMetaInvalidation(`{"origin": "other", "imageIds": [1]}`)
+	if _, ok := imageMetas.Get(1, now); ok {
+		t.Errorf("invalidated image still cached")
+	}
+	if _, ok := imageMetas.Get(2, now); !ok {
+		t.Errorf("image that wasn't invalidated dropped")
+	}
+
+	applyMetaInvalidation(`{"origin": "other"}`)
+	if _, _, size := imageMetas.Stats(); size != 0 {
+		t.Errorf("cache not cleared: got %v images", size)
+	}
+}
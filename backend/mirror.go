@@ -0,0 +1,155 @@
+package main
+
+/*
+	This file implements mirrored storage for redundancy. When a mirror driver is configured every
+	file is written to the primary and the mirror in parallel and both copies are verified before
+	the write succeeds, so an upload is only acknowledged once it is stored twice. Reads fall back
+	to the mirror when the primary errors and deletes remove both copies. A primary copy found
+	missing on read is restored from the mirror so the damage heals without operator action.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/inflowml/logger"
+)
+
+// mirrorStorage writes to both drivers and reads from the mirror when the primary fails
+type mirrorStorage struct {
+	primary Storage
+	mirror  Storage
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func (s *mirrorStorage) Name() string {
+	return fmt.Sprintf("%s mirrored to %s", s.primary.Name(), s.mirror.Name())
+}
+
+// Put streams the content to both drivers at once, the mirror reads what the primary reads through a pipe.
+// If either write or the verification of either copy fails both copies are removed
+func (s *mirrorStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	pipeReader, pipeWriter := io.Pipe()
+	mirrored := make(chan error, 1)
+	go func() {
+		err := s.mirror.Put(ctx, key, pipeReader, size, contentType)
+		// Unblock the primary if the mirror stopped reading early
+		pipeReader.CloseWithError(err)
+		mirrored <- err
+	}()
+
+	counter := &countingReader{Reader: r}
+	err := s.primary.Put(ctx, key, io.TeeReader(counter, pipeWriter), size, contentType)
+	// A nil error ends the mirror's content, otherwise the mirror write fails with it
+	pipeWriter.CloseWithError(err)
+	mirrorErr := <-mirrored
+
+	if err == nil && mirrorErr != nil {
+		err = fmt.Errorf("mirror write failed: %v", mirrorErr)
+	}
+	if err == nil {
+		err = s.verify(ctx, key, counter.count)
+	}
+	if err != nil {
+		s.primary.Delete(ctx, key)
+		s.mirror.Delete(ctx, key)
+		return err
+	}
+	return nil
+}
+
+// verify ensures both drivers hold a copy of the key with the number of bytes written
+func (s *mirrorStorage) verify(ctx context.Context, key string, size int64) error {
+	for _, driver := range []Storage{s.primary, s.mirror} {
+		object, err := driver.Open(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to verify %s copy: %v", driver.Name(), err)
+		}
+		stored := object.Size()
+		object.Close()
+		if stored != size {
+			return fmt.Errorf("%s copy has %v bytes, wrote %v", driver.Name(), stored, size)
+		}
+	}
+	return nil
+}
+
+// Open reads from the primary, falling back to the mirror when the primary errors
+// a missing primary copy is restored from the mirror before it is read
+func (s *mirrorStorage) Open(ctx context.Context, key string) (Object, error) {
+	object, err := s.primary.Open(ctx, key)
+	if err == nil {
+		return object, nil
+	}
+
+	mirrorObject, mirrorErr := s.mirror.Open(ctx, key)
+	if mirrorErr != nil {
+		return nil, err
+	}
+	if err == ErrObjectNotFound {
+		restored, restoreErr := s.restore(ctx, key, mirrorObject)
+		if restoreErr == nil {
+			logger.Info("Restored missing %s copy of %s from the %s mirror", s.primary.Name(), key, s.mirror.Name())
+			return restored, nil
+		}
+		logger.Error("failed to restore missing %s copy of %s from the mirror: %v", s.primary.Name(), key, restoreErr)
+		// The failed restore read part of the mirror copy
+		if _, seekErr := mirrorObject.Seek(0, io.SeekStart); seekErr != nil {
+			mirrorObject.Close()
+			return nil, err
+		}
+	}
+	logger.Warning("Reading %s from the %s mirror as the primary failed: %v", key, s.mirror.Name(), err)
+	return mirrorObject, nil
+}
+
+// restore writes the mirror copy of the key to the primary and opens the restored copy, the mirror
+// copy is closed once restored
+func (s *mirrorStorage) restore(ctx context.Context, key string, mirrorObject Object) (Object, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(mirrorObject, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	_, err = mirrorObject.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.primary.Put(ctx, key, mirrorObject, mirrorObject.Size(), detectImageType(header[:n]))
+	if err != nil {
+		return nil, err
+	}
+	restored, err := s.primary.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	mirrorObject.Close()
+	return restored, nil
+}
+
+// Delete removes both copies, a copy missing from one driver is not an error if the other was removed
+func (s *mirrorStorage) Delete(ctx context.Context, key string) error {
+	err := s.primary.Delete(ctx, key)
+	mirrorErr := s.mirror.Delete(ctx, key)
+
+	switch {
+	case err == ErrObjectNotFound && mirrorErr == nil:
+		return nil
+	case err == nil && mirrorErr != nil && mirrorErr != ErrObjectNotFound:
+		return fmt.Errorf("failed to delete mirror copy: %v", mirrorErr)
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// brokenStorage fails every write after reading part of the content
+type brokenStorage struct {
+	localStorage
+}
+
+func (s *brokenStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	r.Read(make([]byte, 4))
+	return errors.New("disk unavailable")
+}
+
+// TestMirrorStorage ensures both copies are written and removed and reads fall back to the mirror
+func TestMirrorStorage(t *testing.T) {
+	primaryDir := t.TempDir()
+	mirrorDir := t.TempDir()
+
+	ctx := context.Background()
+	primary := &localStorage{root: primaryDir}
+	mirror := &localStorage{root: mirrorDir}
+	mirrored := &mirrorStorage{primary: primary, mirror: mirror}
+	content := bytes.Repeat([]byte("picto"), 20000)
+
+	err := mirrored.Put(ctx, "1/1.png", bytes.NewReader(content), int64(len(content)), "image/png")
+	if err != nil {
+		t.Fatalf("failed to write mirrored file: %v", err)
+	}
+	for _, driver := range []Storage{primary, mirror} {
+		object, err := driver.Open(ctx, "1/1.png")
+		if err != nil {
+			t.Fatalf("copy missing: %v", err)
+		}
+		data, _ := ioutil.ReadAll(object)
+		object.Close()
+		if !bytes.Equal(data, content) {
+			t.Errorf("copy differs from the content: got %v bytes", len(data))
+		}
+	}
+
+	// Reads fall back to the mirror when the primary copy is missing and restore it
+	primary.Delete(ctx, "1/1.png")
+	object, err := mirrored.Open(ctx, "1/1.png")
+	if err != nil {
+		t.Fatalf("read did not fall back to the mirror: %v", err)
+	}
+	data, _ := ioutil.ReadAll(object)
+	object.Close()
+	if !bytes.Equal(data, content) {
+		t.Errorf("read differs from the content: got %v bytes", len(data))
+	}
+	object, err = primary.Open(ctx, "1/1.png")
+	if err != nil {
+		t.Fatalf("missing primary copy not restored: %v", err)
+	}
+	object.Close()
+
+	err = mirrored.Delete(ctx, "1/1.png")
+	if err != nil {
+		t.Errorf("failed to delete with a missing primary copy: %v", err)
+	}
+	if _, err := mirror.Open(ctx, "1/1.png"); err != ErrObjectNotFound {
+		t.Errorf("mirror copy not deleted: %v", err)
+	}
+	if _, err := mirrored.Open(ctx, "1/1.png"); err != ErrObjectNotFound {
+		t.Errorf("wrong error for missing file: %v", err)
+	}
+
+	// A failed mirror write fails the upload without leaving the primary copy
+	broken := &mirrorStorage{primary: primary, mirror: &brokenStorage{localStorage{root: mirrorDir}}}
+	err = broken.Put(ctx, "1/2.png", bytes.NewReader(content), int64(len(content)), "image/png")
+	if err == nil {
+		t.Fatalf("expected error for failed mirror write")
+	}
+	if _, err := primary.Open(ctx, "1/2.png"); err != ErrObjectNotFound {
+		t.Errorf("primary copy kept after failed mirror write: %v", err)
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+/*
+	This file implements abuse reports and moderation cases. Users report images they can view
+	under a category (copyright, nsfw or spam) and reports of an image in the same category are
+	collected in a moderation case. Once a case collects the number of reports set for its category
+	by the moderation policy the image is unshared, restricted from being shared again and the
+	owner is notified through the outbox. Owners may appeal an unshared case which reopens it for
+	administrators, who dismiss it, lifting the restriction, or uphold it.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	REPORT_TABLE            = "image_report"
+	MODERATION_CASE_TABLE   = "moderation_case"
+	MODERATION_POLICY_TABLE = "moderation_policy"
+
+	REPORT_REASON_MAX = 1000 // Characters allowed in the reason of a report or appeal
+
+	// Report categories
+	REPORT_COPYRIGHT = "copyright"
+	REPORT_NSFW      = "nsfw"
+	REPORT_SPAM      = "spam"
+
+	// Reports unsharing an image by default, used if the REPORT_THRESHOLD_<CATEGORY> env variable is not defined
+	REPORT_THRESHOLD_COPYRIGHT = 3
+	REPORT_THRESHOLD_NSFW      = 3
+	REPORT_THRESHOLD_SPAM      = 5
+
+	// Moderation case statuses
+	CASE_OPEN      = "open"      // Collecting reports, or appealed and awaiting an administrator
+	CASE_UNSHARED  = "unshared"  // Image unshared after reaching the threshold, may be appealed
+	CASE_DISMISSED = "dismissed" // Reports rejected by an administrator
+	CASE_UPHELD    = "upheld"    // Reports confirmed by an administrator
+
+	// Event topics, handlers are expected to notify the owner of the image
+	EVENT_IMAGE_UNSHARED = "moderation.image_unshared"
+	EVENT_CASE_RESOLVED  = "moderation.case_resolved"
+)
+
+var (
+	// ErrAlreadyReported is returned when a user reports an image again in the same category
+	ErrAlreadyReported = errors.New("image already reported")
+
+	// ErrNotAppealable is returned when appealing a case that wasn't unshared automatically
+	ErrNotAppealable = errors.New("case cannot be appealed")
+)
+
+// reportCategories lists the categories reports may be filed under
+var reportCategories = []string{REPORT_COPYRIGHT, REPORT_NSFW, REPORT_SPAM}
+
+// ImageReport is a report of an image by a user tagged for sql serialization
+type ImageReport struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	CaseId   int32     `sql:"case_id"`
+	ImageId  int32     `sql:"image_id"`
+	Reporter int32     `sql:"reporter"`
+	Category string    `sql:"category"`
+	Reason   string    `sql:"reason"`
+	Created  time.Time `sql:"created"`
+}
+
+// ModerationCase collects the reports of an image in a category tagged for sql serialization
+type ModerationCase struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId  int32     `sql:"image_id"`
+	Uid      int32     `sql:"uid"` // Owner of the image
+	Category string    `sql:"category"`
+	Status   string    `sql:"status"`
+	Reports  int32     `sql:"reports"`
+	Unshared bool      `sql:"unshared"` // The image may not be shared while set
+	Appealed bool      `sql:"appealed"`
+	Appeal   string    `sql:"appeal"` // Reason given by the owner
+	Created  time.Time `sql:"created"`
+	Updated  time.Time `sql:"updated"`
+}
+
+// ModerationPolicy sets the reports unsharing an image in each category tagged for sql serialization
+// the table holds at most a single row with id 1, the environment provides defaults until it is set
+// a threshold of 0 disables unsharing for the category
+type ModerationPolicy struct {
+	Id        int32 `json:"-" sql:"id" opt:"PRIMARY KEY"`
+	Copyright int32 `json:"copyright" sql:"copyright"`
+	Nsfw      int32 `json:"nsfw" sql:"nsfw"`
+	Spam      int32 `json:"spam" sql:"spam"`
+}
+
+// ModerationEvent is the payload of EVENT_IMAGE_UNSHARED and EVENT_CASE_RESOLVED
+type ModerationEvent struct {
+	CaseId   int32  `json:"caseId"`
+	ImageId  int32  `json:"imageId"`
+	Uid      int32  `json:"uid"`
+	Email    string `json:"email"`
+	Category string `json:"category"`
+	Status   string `json:"status"`
+}
+
+// ReportReq is the body of a report or appeal, appeals have no category
+type ReportReq struct {
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+}
+
+// ModerationCaseResp describes a moderation case, reporters are never revealed
+type ModerationCaseResp struct {
+	Id       int32     `json:"id"`
+	ImageId  int32     `json:"imageId"`
+	Uid      int32     `json:"uid"`
+	Category string    `json:"category"`
+	Status   string    `json:"status"`
+	Reports  int32     `json:"reports"`
+	Unshared bool      `json:"unshared"`
+	Appealed bool      `json:"appealed"`
+	Appeal   string    `json:"appeal,omitempty"`
+	Created  Timestamp `json:"created"`
+	Updated  Timestamp `json:"updated"`
+}
+
+// defaultModerationPolicy returns the policy defined by the REPORT_THRESHOLD_<CATEGORY> environment variables
+func defaultModerationPolicy() ModerationPolicy {
+	return ModerationPolicy{
+		Id:        1,
+		Copyright: envThreshold("REPORT_THRESHOLD_COPYRIGHT", REPORT_THRESHOLD_COPYRIGHT),
+		Nsfw:      envThreshold("REPORT_THRESHOLD_NSFW", REPORT_THRESHOLD_NSFW),
+		Spam:      envThreshold("REPORT_THRESHOLD_SPAM", REPORT_THRESHOLD_SPAM),
+	}
+}
+
+// envThreshold parses the threshold environment variable returning fallback if it is unset or invalid
+func envThreshold(name string, fallback int32) int32 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 32)
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return int32(value)
+}
+
+// Threshold returns the reports unsharing an image in the category, 0 if they never do
+func (p ModerationPolicy) Threshold(category string) int32 {
+	switch category {
+	case REPORT_COPYRIGHT:
+		return p.Copyright
+	case REPORT_NSFW:
+		return p.Nsfw
+	case REPORT_SPAM:
+		return p.Spam
+	}
+	return 0
+}
+
+// validate ensures every threshold is positive or 0
+func (p ModerationPolicy) validate() error {
+	for _, category := range reportCategories {
+		if p.Threshold(category) < 0 {
+			return fmt.Errorf("%s threshold must not be negative", category)
+		}
+	}
+	return nil
+}
+
+// validate ensures the category is known and the reason isn't too long
+func (r ReportReq) validate() error {
+	if !containsString(reportCategories, r.Category) {
+		return fmt.Errorf("category must be one of %s", strings.Join(reportCategories, ", "))
+	}
+	if len(r.Reason) > REPORT_REASON_MAX {
+		return fmt.Errorf("reason may not exceed %v characters", REPORT_REASON_MAX)
+	}
+	return nil
+}
+
+// reportImage files a report of the image in the url by the authenticated user
+func (s *Server) reportImage(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to report image sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	image, ok := s.reportableImage(w, req, claims.Uid)
+	if !ok {
+		return
+	}
+
+	var report ReportReq
+	err = json.NewDecoder(req.Body).Decode(&report)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	report.Reason = strings.TrimSpace(report.Reason)
+	err = report.validate()
+	if err != nil {
+		logger.Error("invalid report sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	policy, err := GetModerationPolicy()
+	if err != nil {
+		logger.Error("failed to retrieve moderation policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to report image, try again later"))
+		return
+	}
+
+	modCase, err := AddImageReport(image, int32(claims.Uid), report, policy.Threshold(report.Category))
+	if err == ErrAlreadyReported {
+		logger.Error("user %v already reported image %v sending 409", claims.Uid, image.Id)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - You already reported this image in this category"))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to add report sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to report image, try again later"))
+		return
+	}
+
+	// Reporters only learn that the report was received
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("202 - Report received"))
+	logger.Info("User %v reported image %v as %s, case %v has %v reports", claims.Uid, image.Id, report.Category, modCase.Id, modCase.Reports)
+}
+
+// reportableImage retrieves the image in the url if the user may view it and doesn't own it
+// writes the error response and returns false otherwise
+func (s *Server) reportableImage(w http.ResponseWriter, req *http.Request, uid int) (Image, bool) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		logger.Error("invalid image id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return Image{}, false
+	}
+
+	image, err := s.store.GetImageMeta(req.Context(), int32(id))
+	if err != nil && !strings.Contains(err.Error(), "404 - Not found") {
+		logger.Error("failed to retrieve image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve image, try again later"))
+		return Image{}, false
+	}
+
+	visible := false
+	if err == nil && image.Uid != int32(uid) {
+		visible, err = s.canViewImage(req.Context(), uid, image)
+		if err == nil && !visible {
+			visible, err = s.publiclyShared(req.Context(), image)
+			if err == nil && visible {
+				visible, err = s.canInteract(req.Context(), uid, image.Uid)
+			}
+		}
+		if err != nil {
+			logger.Error("failed to authorize report sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to retrieve image, try again later"))
+			return Image{}, false
+		}
+	}
+
+	// Owners can't report their own images, images the user can't view are reported as not found
+	if !visible {
+		logger.Error("image %v not reportable by user %v sending 404", id, uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
+		return Image{}, false
+	}
+
+	return image, true
+}
+
+// listOwnModerationCases returns the moderation cases about images of the authenticated user
+func listOwnModerationCases(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for moderation cases sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	cases, err := ModerationCases("uid = $1 ORDER BY id", claims.Uid)
+	if err != nil {
+		logger.Error("failed to retrieve moderation cases sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve moderation cases, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, moderationCaseResps(cases))
+}
+
+// appealModerationCase reopens an unshared case about an image of the authenticated user
+// for review by an administrator, the body may give the reason of the appeal
+func appealModerationCase(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to appeal sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	modCase, ok := findModerationCase(w, req)
+	if !ok {
+		return
+	}
+	if modCase.Uid != int32(claims.Uid) {
+		logger.Error("case %v not about an image of user %v sending 404", modCase.Id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no moderation case with that id"))
+		return
+	}
+
+	var appeal ReportReq
+	err = json.NewDecoder(req.Body).Decode(&appeal)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	appeal.Reason = strings.TrimSpace(appeal.Reason)
+	if len(appeal.Reason) > REPORT_REASON_MAX {
+		logger.Error("appeal reason too long sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - reason may not exceed %v characters", REPORT_REASON_MAX)))
+		return
+	}
+
+	modCase, err = AppealModerationCase(modCase.Id, appeal.Reason)
+	if err == ErrNotAppealable {
+		logger.Error("case %v is %s and cannot be appealed sending 409", modCase.Id, modCase.Status)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - Only cases that unshared an image and weren't appealed yet can be appealed"))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to appeal case sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to appeal, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, moderationCaseResp(modCase))
+	logger.Info("User %v appealed moderation case %v", claims.Uid, modCase.Id)
+}
+
+// listModerationCases returns the moderation cases with the status in the query, open cases by default
+func listModerationCases(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for moderation cases: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	status := req.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = CASE_OPEN
+	}
+	if !containsString([]string{CASE_OPEN, CASE_UNSHARED, CASE_DISMISSED, CASE_UPHELD}, status) {
+		logger.Error("invalid case status %q sending 400", status)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - status must be %s, %s, %s or %s", CASE_OPEN, CASE_UNSHARED, CASE_DISMISSED, CASE_UPHELD)))
+		return
+	}
+
+	// Appealed cases are reviewed first
+	cases, err := ModerationCases("status = $1 ORDER BY appealed DESC, id", status)
+	if err != nil {
+		logger.Error("failed to retrieve moderation cases sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve moderation cases, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, moderationCaseResps(cases))
+}
+
+// resolveModerationCase accepts a json body with the status dismissed or upheld, dismissing a
+// case lifts the sharing restriction of the image while upholding it restricts the image
+func resolveModerationCase(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to resolve moderation case: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	modCase, ok := findModerationCase(w, req)
+	if !ok {
+		return
+	}
+
+	var resolution struct {
+		Status string `json:"status"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&resolution)
+	if err != nil || (resolution.Status != CASE_DISMISSED && resolution.Status != CASE_UPHELD) {
+		logger.Error("invalid resolution sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - status must be %s or %s", CASE_DISMISSED, CASE_UPHELD)))
+		return
+	}
+
+	modCase, err = ResolveModerationCase(modCase.Id, resolution.Status)
+	if err != nil {
+		logger.Error("failed to resolve moderation case sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to resolve moderation case, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, moderationCaseResp(modCase))
+	logger.Info("Moderation case %v %s by user %v", modCase.Id, modCase.Status, claims.Uid)
+}
+
+// moderationPolicy returns the current moderation policy
+func moderationPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for moderation policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetModerationPolicy()
+	if err != nil {
+		logger.Error("failed to retrieve moderation policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve moderation policy, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, policy)
+}
+
+// updateModerationPolicy accepts a json body with the thresholds of any categories and updates
+// the moderation policy, images that were already unshared are not changed
+func updateModerationPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update moderation policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetModerationPolicy()
+	if err != nil {
+		logger.Error("failed to retrieve moderation policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve moderation policy, try again later"))
+		return
+	}
+
+	// Decode over the current policy so omitted categories are unchanged
+	err = json.NewDecoder(req.Body).Decode(&policy)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	err = policy.validate()
+	if err != nil {
+		logger.Error("invalid moderation policy sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	err = SetModerationPolicy(policy)
+	if err != nil {
+		logger.Error("failed to update moderation policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update moderation policy, try again later"))
+		return
+	}
+
+	writeModerationJSON(w, http.StatusOK, policy)
+	logger.Info("Moderation policy updated by user %v: %+v", claims.Uid, policy)
+}
+
+// findModerationCase retrieves the case in the url, writing the error response and returning false if it doesn't exist
+func findModerationCase(w http.ResponseWriter, req *http.Request) (ModerationCase, bool) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		logger.Error("invalid case id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return ModerationCase{}, false
+	}
+
+	cases, err := ModerationCases("id = $1", id)
+	if err != nil {
+		logger.Error("failed to retrieve moderation case sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve moderation case, try again later"))
+		return ModerationCase{}, false
+	}
+	if len(cases) == 0 {
+		logger.Error("moderation case %v does not exist sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no moderation case with that id"))
+		return ModerationCase{}, false
+	}
+
+	return cases[0], true
+}
+
+// moderationCaseResp describes the case
+func moderationCaseResp(modCase ModerationCase) ModerationCaseResp {
+	return ModerationCaseResp{
+		Id:       modCase.Id,
+		ImageId:  modCase.ImageId,
+		Uid:      modCase.Uid,
+		Category: modCase.Category,
+		Status:   modCase.Status,
+		Reports:  modCase.Reports,
+		Unshared: modCase.Unshared,
+		Appealed: modCase.Appealed,
+		Appeal:   modCase.Appeal,
+		Created:  Timestamp(modCase.Created),
+		Updated:  Timestamp(modCase.Updated),
+	}
+}
+
+// moderationCaseResps describes the cases in order
+func moderationCaseResps(cases []ModerationCase) []ModerationCaseResp {
+	resp := []ModerationCaseResp{}
+	for _, modCase := range cases {
+		resp = append(resp, moderationCaseResp(modCase))
+	}
+	return resp
+}
+
+// writeModerationJSON writes the response as json with the status code
+func writeModerationJSON(w http.ResponseWriter, status int, resp interface{}) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestModerationPolicy ensures thresholds are configured per category and reports are validated
+func TestModerationPolicy(t *testing.T) {
+	defer os.Unsetenv("REPORT_THRESHOLD_SPAM")
+
+	policy := defaultModerationPolicy()
+	if policy.Threshold(REPORT_COPYRIGHT) != REPORT_THRESHOLD_COPYRIGHT || policy.Threshold(REPORT_SPAM) != REPORT_THRESHOLD_SPAM {
+		t.Errorf("wrong default policy: got %+v", policy)
+	}
+	if threshold := policy.Threshold("other"); threshold != 0 {
+		t.Errorf("wrong threshold of unknown category: got %v want 0", threshold)
+	}
+
+	os.Setenv("REPORT_THRESHOLD_SPAM", "0")
+	if threshold := defaultModerationPolicy().Threshold(REPORT_SPAM); threshold != 0 {
+		t.Errorf("wrong configured threshold: got %v want 0", threshold)
+	}
+	os.Setenv("REPORT_THRESHOLD_SPAM", "-2")
+	if threshold := defaultModerationPolicy().Threshold(REPORT_SPAM); threshold != REPORT_THRESHOLD_SPAM {
+		t.Errorf("wrong threshold for invalid configuration: got %v want %v", threshold, REPORT_THRESHOLD_SPAM)
+	}
+	if err := (ModerationPolicy{Nsfw: -1}).validate(); err == nil {
+		t.Errorf("negative threshold accepted")
+	}
+
+	tt := []struct {
+		Report ReportReq
+		Valid  bool
+	}{
+		{ReportReq{Category: REPORT_NSFW}, true},
+		{ReportReq{Category: REPORT_COPYRIGHT, Reason: "my photo"}, true},
+		{ReportReq{Category: "violence"}, false},
+		{ReportReq{}, false},
+		{ReportReq{Category: REPORT_SPAM, Reason: strings.Repeat("a", REPORT_REASON_MAX+1)}, false},
+	}
+	for _, tc := range tt {
+		if err := tc.Report.validate(); (err == nil) != tc.Valid {
+			t.Errorf("wrong validation of %+v: got %v", tc.Report, err)
+		}
+	}
+}
+
+// TestModeration ensures reports unshare an image at the threshold and appeals reopen the case
+// until an administrator dismisses it
+func TestModeration(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	reporter := createTestUser(t, "reporter")
+	reporterToken, _, err := generateJWT(int(reporter.Uid), reporter.Email)
+	if err != nil {
+		t.Fatalf("failed to generate reporting user jwt token: %v", err)
+	}
+
+	// The reporting user also moderates
+	os.Setenv("ADMIN_UIDS", fmt.Sprintf("%v", reporter.Uid))
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	previous, err := GetModerationPolicy()
+	if err != nil {
+		t.Fatalf("failed to retrieve moderation policy: %v", err)
+	}
+	defer SetModerationPolicy(previous)
+	err = SetModerationPolicy(ModerationPolicy{Copyright: 0, Nsfw: 1, Spam: 5})
+	if err != nil {
+		t.Fatalf("failed to set moderation policy: %v", err)
+	}
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), image)
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	reportPath := fmt.Sprintf("/image/%v/reports", image.Id)
+	publicPath := fmt.Sprintf("/public/image/%v/%v", image.Uid, image.Id)
+	imagePath := strings.TrimPrefix(image.Ref, REF_URL)
+
+	if rr := send("POST", reportPath, `{"category": "nsfw"}`, token); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for owner report: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", reportPath, `{"category": "violence"}`, reporterToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for unknown category: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send("POST", reportPath, `{"category": "copyright"}`, reporterToken); rr.Code != http.StatusAccepted {
+		t.Errorf("failed to report image: got %v", rr.Code)
+	}
+	if rr := send("GET", publicPath, "", ""); rr.Code != http.StatusOK {
+		t.Errorf("wrong code below threshold: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if rr := send("POST", reportPath, `{"category": "nsfw", "reason": "explicit"}`, reporterToken); rr.Code != http.StatusAccepted {
+		t.Fatalf("failed to report image: got %v", rr.Code)
+	}
+	if rr := send("POST", reportPath, `{"category": "nsfw"}`, reporterToken); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code for repeated report: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := send("GET", publicPath, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for unshared image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("PUT", imagePath, `{"shareable": "true"}`, token); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code for sharing restricted image: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	rr := send("GET", "/moderation/cases", "", token)
+	cases := []ModerationCaseResp{}
+	json.Unmarshal(rr.Body.Bytes(), &cases)
+	if len(cases) != 2 || cases[1].Category != REPORT_NSFW || cases[1].Status != CASE_UNSHARED || !cases[1].Unshared {
+		t.Fatalf("wrong moderation cases: got %+v", cases)
+	}
+	caseId := cases[1].Id
+
+	if rr := send("POST", fmt.Sprintf("/moderation/cases/%v/appeal", cases[0].Id), `{}`, token); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code for appealing open case: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := send("POST", fmt.Sprintf("/moderation/cases/%v/appeal", caseId), `{"reason": "artwork"}`, reporterToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for appeal by other user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", fmt.Sprintf("/moderation/cases/%v/appeal", caseId), `{"reason": "artwork"}`, token); rr.Code != http.StatusOK {
+		t.Fatalf("failed to appeal: got %v", rr.Code)
+	}
+
+	rr = send("GET", "/admin/moderation/cases", "", reporterToken)
+	cases = []ModerationCaseResp{}
+	json.Unmarshal(rr.Body.Bytes(), &cases)
+	if len(cases) == 0 || cases[0].Id != caseId || !cases[0].Appealed || cases[0].Appeal != "artwork" {
+		t.Fatalf("appealed case not listed first: got %+v", cases)
+	}
+	if rr := send("GET", publicPath, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for appealed image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := send("PUT", fmt.Sprintf("/admin/moderation/cases/%v", caseId), `{"status": "dismissed"}`, token); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong code for resolution by non administrator: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := send("PUT", fmt.Sprintf("/admin/moderation/cases/%v", caseId), `{"status": "dismissed"}`, reporterToken); rr.Code != http.StatusOK {
+		t.Fatalf("failed to dismiss case: got %v", rr.Code)
+	}
+	if rr := send("PUT", imagePath, `{"shareable": "true"}`, token); rr.Code != http.StatusOK {
+		t.Errorf("failed to share image after dismissal: got %v", rr.Code)
+	}
+	if rr := send("GET", publicPath, "", ""); rr.Code != http.StatusOK {
+		t.Errorf("wrong code after dismissal: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
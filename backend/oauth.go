@@ -0,0 +1,667 @@
+package main
+
+/*
+	This file lets third party applications act on behalf of users. Users register applications
+	as clients and receive a client id and secret. A user grants a client scopes through the
+	consent endpoints, after which the client exchanges its credentials for an access token
+	limited to the granted scopes of that user. Users may revoke a grant at any time and every
+	request made with a client token is checked against the current grant and rate limited.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	OAUTH_CLIENT_TABLE = "oauth_client"
+	OAUTH_GRANT_TABLE  = "oauth_grant"
+
+	OAUTH_TOKEN_TTL       = 60  // Minutes an access token is valid if OAUTH_TOKEN_TTL env variable is not defined
+	OAUTH_RATE_LIMIT      = 120 // Requests per minute allowed to each client if OAUTH_RATE_LIMIT env variable is not defined
+	OAUTH_CLIENT_ID_BYTES = 12
+	OAUTH_SECRET_BYTES    = 32
+	OAUTH_NAME_LENGTH     = 100
+	OAUTH_TOKEN_SUBJECT   = "oauth" // Subject of access tokens issued to clients
+
+	// Scopes that may be granted to clients
+	SCOPE_IMAGES_READ  = "images:read"
+	SCOPE_IMAGES_WRITE = "images:write"
+	SCOPE_PROFILE_READ = "profile:read"
+)
+
+// OAUTH_SCOPES describes each scope for display on consent screens
+var OAUTH_SCOPES = map[string]string{
+	SCOPE_IMAGES_READ:  "View your images and their details",
+	SCOPE_IMAGES_WRITE: "Upload, edit and delete your images",
+	SCOPE_PROFILE_READ: "View your name, email and storage usage",
+}
+
+// OAuthClient is a registered third party application tagged for sql serialization
+// only the sha256 hash of the client secret is stored
+type OAuthClient struct {
+	Id         int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ClientId   string    `sql:"client_id" opt:"UNIQUE"`
+	SecretHash string    `sql:"secret_hash"`
+	Name       string    `sql:"name"`
+	Owner      int32     `sql:"owner"`
+	Created    time.Time `sql:"created"`
+}
+
+// OAuthGrant records the scopes a user granted a client as a space separated list
+type OAuthGrant struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32     `sql:"uid"`
+	ClientId string    `sql:"client_id"`
+	Scope    string    `sql:"scope"`
+	Created  time.Time `sql:"created"`
+}
+
+// OAuthClientResp describes a registered client, the secret is only included when the client is registered
+type OAuthClientResp struct {
+	ClientId     string    `json:"clientId"`
+	ClientSecret string    `json:"clientSecret,omitempty"`
+	Name         string    `json:"name"`
+	Created      Timestamp `json:"created"`
+}
+
+// OAuthGrantResp describes a client the user has granted access
+type OAuthGrantResp struct {
+	ClientId   string    `json:"clientId"`
+	ClientName string    `json:"clientName"`
+	Scopes     []string  `json:"scopes"`
+	Created    Timestamp `json:"created"`
+}
+
+// OAuthScopeResp describes a scope for consent screens
+type OAuthScopeResp struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// OAuthConsentResp is the information shown to a user asked to grant a client access
+type OAuthConsentResp struct {
+	ClientId   string           `json:"clientId"`
+	ClientName string           `json:"clientName"`
+	Scopes     []OAuthScopeResp `json:"scopes"`
+	Granted    []string         `json:"granted"`
+}
+
+// OAuthTokenResp is the access token response defined by RFC 6749
+type OAuthTokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// clientAuthorizedKey marks request contexts whose client token was checked by authorizeClients
+type clientAuthorizedKey struct{}
+
+// clientLimiter limits the requests made with the tokens of each client
+var clientLimiter = newSharedRateLimiter("OAUTH_RATE_LIMIT", getOAuthRateLimit(), getOAuthRateLimit())
+
+// getOAuthTokenTTL returns the lifetime of access tokens defined by the OAUTH_TOKEN_TTL environment variable
+func getOAuthTokenTTL() time.Duration {
+	ttl, err := strconv.Atoi(os.Getenv("OAUTH_TOKEN_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = OAUTH_TOKEN_TTL
+	}
+	return time.Duration(ttl) * time.Minute
+}
+
+// getOAuthRateLimit returns the requests per minute allowed to each client
+func getOAuthRateLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("OAUTH_RATE_LIMIT"))
+	if err != nil || limit <= 0 {
+		limit = OAUTH_RATE_LIMIT
+	}
+	return limit
+}
+
+// parseScopes returns the distinct scopes of a space separated list, every scope must be known
+func parseScopes(scope string) ([]string, error) {
+	scopes := []string{}
+	for _, name := range strings.Fields(scope) {
+		if _, ok := OAUTH_SCOPES[name]; !ok {
+			return nil, fmt.Errorf("unknown scope %s", name)
+		}
+		if !containsString(scopes, name) {
+			scopes = append(scopes, name)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// hasScope reports whether the space separated list of scopes includes the scope
+func hasScope(scopes string, scope string) bool {
+	return containsString(strings.Fields(scopes), scope)
+}
+
+// requiredScope returns the scope a client token needs for the request
+// an empty scope means the request is restricted to users, such as account and client management
+func requiredScope(req *http.Request) string {
+	path := apiPath(req.URL.Path)
+	read := req.Method == "GET" || req.Method == "HEAD"
+	switch {
+	case path == "/image" || strings.HasPrefix(path, "/image/") || path == "/album" || strings.HasPrefix(path, "/album/"):
+		if read {
+			return SCOPE_IMAGES_READ
+		}
+		return SCOPE_IMAGES_WRITE
+	case path == "/timeline" && read:
+		return SCOPE_IMAGES_READ
+	case (path == "/user" || path == "/user/quota") && read:
+		return SCOPE_PROFILE_READ
+	}
+	return ""
+}
+
+// parseToken parses and verifies a jwt signed by the server
+func parseToken(tokenStr string) (JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, verificationKey)
+	if err != nil || !token.Valid {
+		return JWTClaims{}, fmt.Errorf("failed to parse jwt/invalid token, unauthorized")
+	}
+	return *claims, nil
+}
+
+// clientAuthorized reports whether authorizeClients checked the client token of the request
+func clientAuthorized(req *http.Request) bool {
+	authorized, _ := req.Context().Value(clientAuthorizedKey{}).(bool)
+	return authorized
+}
+
+// authorizeClients is router middleware checking requests made with client access tokens
+// the client must be within its rate limit and the user's current grant must include the required scope
+func authorizeClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "OPTIONS" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		claims, err := requestPrincipal(req)
+		if err != nil || len(claims.ClientId) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		allowed, wait := clientLimiter.Allow(claims.ClientId)
+		if !allowed {
+			logger.Warning("client %s exceeded rate limit sending 429", claims.ClientId)
+			writeRateLimited(w, wait)
+			return
+		}
+
+		scope := requiredScope(req)
+		if len(scope) == 0 || !hasScope(claims.Scope, scope) {
+			logger.Error("client %s lacks scope %q for %s %s sending 403", claims.ClientId, scope, req.Method, req.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 - Forbidden, the access token does not grant this request"))
+			return
+		}
+
+		// Revoking or narrowing the grant takes effect before the token expires
+		grant, ok, err := GetOAuthGrant(int32(claims.Uid), claims.ClientId)
+		if err != nil {
+			logger.Error("failed to retrieve grant sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Something went wrong on our end"))
+			return
+		}
+		if !ok || !hasScope(grant.Scope, scope) {
+			logger.Error("client %s grant revoked for user %v sending 401", claims.ClientId, claims.Uid)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 - Unauthorized request, access was revoked by the user"))
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientAuthorizedKey{}, true)))
+	})
+}
+
+// newClientCredentials returns a random client id and secret with the hash stored in place of the secret
+func newClientCredentials() (string, string, string, error) {
+	id := make([]byte, OAUTH_CLIENT_ID_BYTES)
+	secret := make([]byte, OAUTH_SECRET_BYTES)
+	for _, buf := range [][]byte{id, secret} {
+		if _, err := rand.Read(buf); err != nil {
+			return "", "", "", err
+		}
+	}
+	secretStr := hex.EncodeToString(secret)
+	return hex.EncodeToString(id), secretStr, hashToken(secretStr), nil
+}
+
+// registerOAuthClient accepts a json body with the name of an application and registers it
+// as a client owned by the authenticated user. The secret is only returned in this response
+func registerOAuthClient(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to register client sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	body.Name = strings.TrimSpace(body.Name)
+	if err != nil || len(body.Name) == 0 || len(body.Name) > OAUTH_NAME_LENGTH {
+		logger.Error("invalid client registration sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - name is required and may not exceed %v characters", OAUTH_NAME_LENGTH)))
+		return
+	}
+
+	clientId, secret, secretHash, err := newClientCredentials()
+	if err != nil {
+		logger.Error("failed to generate client credentials sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to register client, try again later"))
+		return
+	}
+
+	client := OAuthClient{
+		ClientId:   clientId,
+		SecretHash: secretHash,
+		Name:       body.Name,
+		Owner:      int32(claims.Uid),
+		Created:    time.Now().UTC(),
+	}
+	_, err = AddOAuthClient(client)
+	if err != nil {
+		logger.Error("failed to add client sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to register client, try again later"))
+		return
+	}
+
+	resp := clientResp(client)
+	resp.ClientSecret = secret
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(js)
+	logger.Info("Registered client %s for user %v", clientId, claims.Uid)
+}
+
+// clientResp returns the response describing the client without its secret
+func clientResp(client OAuthClient) OAuthClientResp {
+	return OAuthClientResp{ClientId: client.ClientId, Name: client.Name, Created: Timestamp(client.Created)}
+}
+
+// listOAuthClients returns the clients registered by the authenticated user
+func listOAuthClients(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for clients sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	clients, err := GetOAuthClients(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve clients sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve clients, try again later"))
+		return
+	}
+
+	resp := []OAuthClientResp{}
+	for _, client := range clients {
+		resp = append(resp, clientResp(client))
+	}
+	writeOAuthJSON(w, resp)
+}
+
+// deleteOAuthClient deletes a client registered by the authenticated user and every grant to it
+func deleteOAuthClient(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to delete client sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	clientId := mux.Vars(req)["clientId"]
+	deleted, err := DeleteOAuthClient(clientId, int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to delete client sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete client, try again later"))
+		return
+	}
+	if !deleted {
+		logger.Error("client %s not owned by user %v sending 404", clientId, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no client with that id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Deleted client %s", clientId)
+}
+
+// oauthConsent returns the client and scopes named by the client_id and scope query parameters
+// for display on a consent screen along with the scopes the user already granted the client
+func oauthConsent(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for consent sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	scopes, err := parseScopes(req.URL.Query().Get("scope"))
+	if err != nil {
+		logger.Error("invalid consent scope sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Invalid scope, %v", err)))
+		return
+	}
+
+	client, ok := findConsentClient(w, req.URL.Query().Get("client_id"))
+	if !ok {
+		return
+	}
+
+	grant, _, err := GetOAuthGrant(int32(claims.Uid), client.ClientId)
+	if err != nil {
+		logger.Error("failed to retrieve grant sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	resp := OAuthConsentResp{
+		ClientId:   client.ClientId,
+		ClientName: client.Name,
+		Scopes:     []OAuthScopeResp{},
+		Granted:    strings.Fields(grant.Scope),
+	}
+	for _, scope := range scopes {
+		resp.Scopes = append(resp.Scopes, OAuthScopeResp{scope, OAUTH_SCOPES[scope]})
+	}
+	writeOAuthJSON(w, resp)
+}
+
+// grantOAuthConsent accepts a json body with clientId and a space separated scope and grants
+// the client those scopes for the authenticated user, replacing any previous grant
+func grantOAuthConsent(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to grant consent sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var body struct {
+		ClientId string `json:"clientId"`
+		Scope    string `json:"scope"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	scopes, err := parseScopes(body.Scope)
+	if err != nil {
+		logger.Error("invalid consent scope sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Invalid scope, %v", err)))
+		return
+	}
+
+	client, ok := findConsentClient(w, body.ClientId)
+	if !ok {
+		return
+	}
+
+	grant := OAuthGrant{
+		Uid:      int32(claims.Uid),
+		ClientId: client.ClientId,
+		Scope:    strings.Join(scopes, " "),
+		Created:  time.Now().UTC(),
+	}
+	err = GrantOAuthClient(grant)
+	if err != nil {
+		logger.Error("failed to grant client sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to grant access, try again later"))
+		return
+	}
+
+	writeOAuthJSON(w, OAuthGrantResp{client.ClientId, client.Name, scopes, Timestamp(grant.Created)})
+	logger.Info("User %v granted client %s %s", claims.Uid, client.ClientId, grant.Scope)
+}
+
+// findConsentClient retrieves the client a user is asked to grant access
+// writes the error response and returns false if it does not exist
+func findConsentClient(w http.ResponseWriter, clientId string) (OAuthClient, bool) {
+	client, ok, err := GetOAuthClient(clientId)
+	if err != nil {
+		logger.Error("failed to retrieve client sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return OAuthClient{}, false
+	}
+	if !ok {
+		logger.Error("unknown client %q sending 404", clientId)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no client with that id"))
+		return OAuthClient{}, false
+	}
+	return client, true
+}
+
+// listOAuthGrants returns the clients the authenticated user has granted access
+func listOAuthGrants(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for grants sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	grants, err := GetOAuthGrants(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve grants sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve grants, try again later"))
+		return
+	}
+
+	writeOAuthJSON(w, grants)
+}
+
+// revokeOAuthGrant revokes the access the authenticated user granted a client
+// tokens already issued to the client stop working immediately
+func revokeOAuthGrant(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to revoke grant sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	clientId := mux.Vars(req)["clientId"]
+	revoked, err := RevokeOAuthGrant(int32(claims.Uid), clientId)
+	if err != nil {
+		logger.Error("failed to revoke grant sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to revoke access, try again later"))
+		return
+	}
+	if !revoked {
+		logger.Error("no grant to client %s sending 404", clientId)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the client has not been granted access"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("User %v revoked client %s", claims.Uid, clientId)
+}
+
+// issueOAuthToken implements the client credentials grant of RFC 6749 for a user who granted the client access
+// the client authenticates with basic auth or the client_id and client_secret form fields and names the
+// user with user_id. The token is limited to scope if provided, otherwise to every scope granted
+func issueOAuthToken(w http.ResponseWriter, req *http.Request) {
+	if req.FormValue("grant_type") != "client_credentials" {
+		logger.Error("unsupported grant type %q sending 400", req.FormValue("grant_type"))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Unsupported grant_type, use client_credentials"))
+		return
+	}
+
+	clientId, secret, ok := req.BasicAuth()
+	if !ok {
+		clientId, secret = req.FormValue("client_id"), req.FormValue("client_secret")
+	}
+	client, found, err := GetOAuthClient(clientId)
+	if err != nil {
+		logger.Error("failed to retrieve client sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+	if !found || subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(secret))) != 1 {
+		logger.Error("invalid credentials for client %q sending 401", clientId)
+		w.Header().Set("WWW-Authenticate", `Basic realm="picto-cache"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Invalid client credentials"))
+		return
+	}
+
+	uid, err := strconv.Atoi(req.FormValue("user_id"))
+	if err != nil {
+		logger.Error("invalid user_id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - user_id is required"))
+		return
+	}
+
+	grant, granted, err := GetOAuthGrant(int32(uid), client.ClientId)
+	if err != nil {
+		logger.Error("failed to retrieve grant sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+	if !granted {
+		logger.Error("user %v has not granted client %s sending 400", uid, client.ClientId)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - The user has not granted this client access"))
+		return
+	}
+
+	// Tokens may be narrowed to a subset of the granted scopes
+	scope := grant.Scope
+	if requested := req.FormValue("scope"); len(requested) > 0 {
+		scopes, err := parseScopes(requested)
+		if err == nil {
+			for _, name := range scopes {
+				if !hasScope(grant.Scope, name) {
+					err = fmt.Errorf("scope %s was not granted", name)
+					break
+				}
+			}
+		}
+		if err != nil {
+			logger.Error("invalid token scope sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - Invalid scope, %v", err)))
+			return
+		}
+		scope = strings.Join(scopes, " ")
+	}
+
+	user, err := GetUserById(int32(uid))
+	if err != nil {
+		logger.Error("failed to retrieve user sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	ttl := getOAuthTokenTTL()
+	claims := &JWTClaims{
+		Email:    user.Email,
+		Uid:      uid,
+		ClientId: client.ClientId,
+		Scope:    scope,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   OAUTH_TOKEN_SUBJECT,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+	tokenStr, err := signToken(claims)
+	if err != nil {
+		logger.Error("failed to sign token sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	// Token responses must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	writeOAuthJSON(w, OAuthTokenResp{tokenStr, "Bearer", int64(ttl.Seconds()), scope})
+	logger.Info("Issued token to client %s for user %v", client.ClientId, uid)
+}
+
+// writeOAuthJSON writes the value as the json response body
+func writeOAuthJSON(w http.ResponseWriter, value interface{}) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
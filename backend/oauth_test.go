@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// TestParseScopes ensures only known scopes may be requested
+func TestParseScopes(t *testing.T) {
+	tt := []struct {
+		Scope    string
+		Expected []string
+		Valid    bool
+	}{
+		{"images:read", []string{SCOPE_IMAGES_READ}, true},
+		{" images:read  profile:read images:read", []string{SCOPE_IMAGES_READ, SCOPE_PROFILE_READ}, true},
+		{"images:read admin", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tc := range tt {
+		scopes, err := parseScopes(tc.Scope)
+		if (err == nil) != tc.Valid || strings.Join(scopes, " ") != strings.Join(tc.Expected, " ") {
+			t.Errorf("wrong scopes for %q: got %v, %v want %v", tc.Scope, scopes, err, tc.Expected)
+		}
+	}
+}
+
+// TestRequiredScope ensures client tokens are restricted to image and profile requests
+func TestRequiredScope(t *testing.T) {
+	tt := []struct {
+		Method   string
+		Path     string
+		Expected string
+	}{
+		{"GET", "/image/1/2.png", SCOPE_IMAGES_READ},
+		{"GET", "/image/meta", SCOPE_IMAGES_READ},
+		{"POST", "/image", SCOPE_IMAGES_WRITE},
+		{"DELETE", "/image/1/2.png", SCOPE_IMAGES_WRITE},
+		{"GET", "/timeline", SCOPE_IMAGES_READ},
+		{"GET", "/user", SCOPE_PROFILE_READ},
+		{"GET", "/user/quota", SCOPE_PROFILE_READ},
+		{"PUT", "/user", ""},
+		{"PUT", "/user/password", ""},
+		{"GET", "/admin/sharing-policy", ""},
+		{"POST", "/oauth/clients", ""},
+		{"GET", "/imagesomething", ""},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.Method, tc.Path, nil)
+		if scope := requiredScope(req); scope != tc.Expected {
+			t.Errorf("wrong scope for %s %s: got %q want %q", tc.Method, tc.Path, scope, tc.Expected)
+		}
+	}
+}
+
+// TestClientTokenChecks ensures client tokens are rate limited, limited to their scopes
+// and rejected by handlers unless checked by the middleware
+func TestClientTokenChecks(t *testing.T) {
+	defer func(limiter *rateLimiter) { clientLimiter = limiter }(clientLimiter)
+	clientLimiter = newRateLimiter(1, 1)
+
+	token := testClientToken(t, 1, "testclient", SCOPE_PROFILE_READ)
+
+	// Handlers called without the middleware reject client tokens
+	req := httptest.NewRequest("GET", "/user", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	if _, err := authRequest(req); err == nil {
+		t.Errorf("expected unchecked client token to be rejected")
+	}
+
+	router := configureRoutes()
+	for _, expected := range []int{http.StatusForbidden, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/image/meta", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("wrong code for client request: got %v want %v", rr.Code, expected)
+		}
+		if expected == http.StatusTooManyRequests && len(rr.Header().Get("Retry-After")) == 0 {
+			t.Errorf("expected Retry-After header")
+		}
+	}
+}
+
+// TestOAuthClientFlow registers a client, grants it access, uses its token and revokes the grant
+func TestOAuthClientFlow(t *testing.T) {
+	t.Parallel()
+
+	token, uid := getTestToken(t)
+
+	router := configureRoutes()
+	serveJSON := func(method string, path string, body string, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", bearer))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serveJSON("POST", "/oauth/clients", `{"name": "Test App"}`, token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to register client: got %v", rr.Code)
+	}
+	client := OAuthClientResp{}
+	json.Unmarshal(rr.Body.Bytes(), &client)
+	defer DeleteOAuthClient(client.ClientId, int32(uid))
+	if len(client.ClientId) == 0 || len(client.ClientSecret) == 0 {
+		t.Fatalf("expected client credentials: got %+v", client)
+	}
+
+	requestToken := func(secret string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"client_credentials"}, "user_id": {fmt.Sprint(uid)}}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientId, secret)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Tokens require the user's consent and the client secret
+	if rr := requestToken(client.ClientSecret); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected token without consent to be refused: got %v", rr.Code)
+	}
+	rr = serveJSON("POST", "/oauth/consent", fmt.Sprintf(`{"clientId": %q, "scope": "images:read"}`, client.ClientId), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to grant consent: got %v", rr.Code)
+	}
+	if rr := requestToken("wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong secret to be refused: got %v", rr.Code)
+	}
+
+	rr = requestToken(client.ClientSecret)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to issue token: got %v", rr.Code)
+	}
+	issued := OAuthTokenResp{}
+	json.Unmarshal(rr.Body.Bytes(), &issued)
+	if issued.Scope != SCOPE_IMAGES_READ || issued.TokenType != "Bearer" {
+		t.Errorf("wrong token response: got %+v", issued)
+	}
+
+	tt := []struct {
+		Method   string
+		Path     string
+		Expected int
+	}{
+		{"GET", "/image/meta", http.StatusOK},
+		{"GET", "/user", http.StatusForbidden},
+		{"POST", "/oauth/clients", http.StatusForbidden},
+	}
+	for _, tc := range tt {
+		if rr := serveJSON(tc.Method, tc.Path, `{"name": "Other"}`, issued.AccessToken); rr.Code != tc.Expected {
+			t.Errorf("wrong code for client request %s %s: got %v want %v", tc.Method, tc.Path, rr.Code, tc.Expected)
+		}
+	}
+
+	// Revoking the grant invalidates issued tokens
+	if rr := serveJSON("DELETE", "/oauth/grants/"+client.ClientId, "", token); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to revoke grant: got %v", rr.Code)
+	}
+	if rr := serveJSON("GET", "/image/meta", "", issued.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked token to be refused: got %v", rr.Code)
+	}
+}
+
+// testClientToken signs an access token for the client as issued by issueOAuthToken
+func testClientToken(t *testing.T, uid int, clientId string, scope string) string {
+	claims := &JWTClaims{
+		Uid:      uid,
+		ClientId: clientId,
+		Scope:    scope,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   OAUTH_TOKEN_SUBJECT,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getSigningKey())
+	if err != nil {
+		t.Fatalf("failed to sign client token: %v", err)
+	}
+	return token
+}
@@ -0,0 +1,78 @@
+package main
+
+/*
+	This file serves the OpenAPI document of the API for client developers. The document is
+	maintained in devops/swagger/api-spec.yaml and generated into openapi_spec.go by go generate,
+	which must be run after editing the specification. GET /api/openapi.json serves the document
+	and /api/docs a Swagger UI page browsing it, whose scripts are loaded from SWAGGER_UI_URL so
+	deployments without internet access can host them themselves.
+*/
+
+//go:generate go run ./cmd/openapigen -in ../devops/swagger/api-spec.yaml -out openapi_spec.go
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	OPENAPI_MAX_AGE = 300                                   // Seconds clients may cache the document
+	SWAGGER_UI_URL  = "https://unpkg.com/swagger-ui-dist@5" // Default if SWAGGER_UI_URL env variable is not defined
+)
+
+// openapiETag identifies the version of the document for conditional requests
+var openapiETag = fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(openapiSpec)))
+
+// swaggerPage renders the Swagger UI for the document at SpecUrl with the scripts beneath AssetUrl
+var swaggerPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Picto Cache API</title>
+	<link rel="stylesheet" href="{{.AssetUrl}}/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="{{.AssetUrl}}/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({url: "{{.SpecUrl}}", dom_id: "#swagger-ui"});
+	</script>
+</body>
+</html>
+`))
+
+// getSwaggerUIUrl retrieves the location of the Swagger UI scripts from the SWAGGER_UI_URL environment variable
+func getSwaggerUIUrl() string {
+	url := os.Getenv("SWAGGER_UI_URL")
+	if len(url) == 0 {
+		url = SWAGGER_UI_URL
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// openapiDocument serves the OpenAPI document of the API
+func openapiDocument(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", OPENAPI_MAX_AGE))
+	w.Header().Set("ETag", openapiETag)
+	http.ServeContent(w, req, "", time.Time{}, strings.NewReader(openapiSpec))
+}
+
+// apiDocs serves the Swagger UI page browsing the OpenAPI document
+func apiDocs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := swaggerPage.Execute(w, struct {
+		AssetUrl string
+		SpecUrl  string
+	}{getSwaggerUIUrl(), "/api/openapi.json"})
+	if err != nil {
+		logger.Error("failed to render api docs: %v", err)
+	}
+}
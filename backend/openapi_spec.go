@@ -0,0 +1,12092 @@
+// Code generated by openapigen from devops/swagger/api-spec.yaml. DO NOT EDIT.
+
+package main
+
+// openapiSpec is the OpenAPI document served at /api/openapi.json
+const openapiSpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "description": "API For Shopify 2022 Winter Backend Intern Position by Jacoby Joukema\n\nInstances running in read-only mode refuse requests that modify data with 503 and a Retry-After header.\n\nEndpoints of the API are served under /api/v1, service endpoints such as health checks, metrics and the changelog are served at the root. The unversioned paths of the API are deprecated aliases of /api/v1, their responses carry the Deprecation header, a Link header to the versioned path and the Sunset header once their removal is scheduled.\n\nAny request may set the X-Request-Timeout header to milliseconds or a duration such as 500ms, capped by the server. Database and storage work for the request is canceled once the timeout passes and the request fails with 504.\n\nJSON and other text responses of at least 1 KB are compressed with gzip or deflate when the Accept-Encoding header of the request accepts it, images are sent as stored. Compressed responses carry weak entity tags.\n",
+    "version": "1.0.0-oas3",
+    "title": "Picto Cache API",
+    "contact": {
+      "email": "jacobyjoukema@gmail.com"
+    },
+    "license": {
+      "name": "MIT License",
+      "url": "https://github.com/JacobyJoukema/picto-cache/blob/main/LICENSE"
+    }
+  },
+  "tags": [
+    {
+      "name": "Open",
+      "description": "Open calls that do not require valid jwt"
+    },
+    {
+      "name": "JWT",
+      "description": "Closed calls that require authenticaion via jwt"
+    },
+    {
+      "name": "Admin",
+      "description": "Closed calls restricted to administrators listed in ADMIN_UIDS"
+    }
+  ],
+  "paths": {
+    "/": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Test server connectivity",
+        "operationId": "home",
+        "description": "This open endpoint allows clients to test availability of the server without authentication.",
+        "responses": {
+          "200": {
+            "description": "responsive server call"
+          },
+          "400": {
+            "description": "bad input parameter",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "405": {
+            "description": "bad request method",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Test server connectivity with a POST request",
+        "responses": {
+          "200": {
+            "description": "responsive server call"
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Test server connectivity with a PUT request",
+        "responses": {
+          "200": {
+            "description": "responsive server call"
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Test server connectivity with a DELETE request",
+        "responses": {
+          "200": {
+            "description": "responsive server call"
+          }
+        }
+      }
+    },
+    "/ping": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Test server connectivity",
+        "operationId": "ping",
+        "description": "This open endpoint allows clients to test availability of the server without authentication.",
+        "responses": {
+          "200": {
+            "description": "responsive server call",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PingResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad input parameter",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "405": {
+            "description": "bad request method",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/healthz": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Liveness probe",
+        "description": "Responds while the process is serving requests, probes are never rate limited.",
+        "responses": {
+          "200": {
+            "description": "server is live"
+          }
+        }
+      }
+    },
+    "/readyz": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Readiness probe",
+        "description": "Verifies the database is reachable and image storage accepts writes. Failure details are logged by the server rather than returned.",
+        "responses": {
+          "200": {
+            "description": "every check passed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReadyResp"
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "a check failed, the instance should not receive traffic",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReadyResp"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/metrics": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Server metrics",
+        "description": "Exports the free space of the image volume in the prometheus text format. No disk metrics are reported when images are kept in remote storage.",
+        "responses": {
+          "200": {
+            "description": "metrics such as picto_disk_free_bytes, picto_disk_total_bytes and picto_disk_min_free_bytes",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/.well-known/jwks.json": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "summary": "Public keys verifying tokens signed with RS256 or ES256",
+        "description": "Lists the public keys of the RSA and ECDSA keys of the keyring by the kid tokens name in their header. HMAC keys are never published. Does not require signing in and may be cached for five minutes.",
+        "responses": {
+          "200": {
+            "description": "JSON Web Key Set",
+            "content": {
+              "application/jwk-set+json": {
+                "schema": {
+                  "$ref": "#/components/schemas/JWKSet"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/announcements": {
+      "get": {
+        "summary": "List the announcements shown to clients, most severe first",
+        "description": "Does not require signing in. Responses may be cached for a minute.",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "upcoming",
+            "schema": {
+              "type": "boolean"
+            },
+            "description": "include announcements that haven't started yet"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "active announcements",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Announcement"
+                  }
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve announcements",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/changelog": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Machine readable list of API changes",
+        "description": "Lists additions, changes, deprecations and removals newest first. Responses of deprecated endpoints carry a Deprecation header with the moment of deprecation, a Sunset header once removal is scheduled and Link headers to the migration notes (rel deprecation) and the replacement (rel successor-version).",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "since",
+            "description": "omit changes before the date",
+            "schema": {
+              "type": "string",
+              "format": "date",
+              "example": "2026-10-01"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the changes",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Changelog"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, since is not a date formatted as YYYY-MM-DD",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/openapi.json": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "This OpenAPI document as json",
+        "description": "Generated from devops/swagger/api-spec.yaml with servers relative to the host serving it, so requests made from the Swagger UI reach that host.",
+        "responses": {
+          "200": {
+            "description": "the OpenAPI 3 document",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/docs": {
+      "servers": [
+        {
+          "url": "/"
+        }
+      ],
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Swagger UI browsing this document",
+        "description": "The page loads the Swagger UI scripts and styles from SWAGGER_UI_URL.",
+        "responses": {
+          "200": {
+            "description": "the Swagger UI page",
+            "content": {
+              "text/html": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/register": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Register a Picto Cache account",
+        "operationId": "register",
+        "description": "This open endpoint allows clients to register an account with the required parameters",
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "$ref": "#/components/schemas/RegisterReq"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "registration successfull, jwt token return via cookie",
+            "headers": {
+              "Set-Cookie": {
+                "description": "attributes are set by the cookie configuration, HttpOnly, Secure and SameSite=Lax by default",
+                "schema": {
+                  "type": "string",
+                  "example": "token=abc123; Path=/; Expires=Fri, 16 Oct 2026 12:30:00 GMT; HttpOnly; Secure; SameSite=Lax"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TokenResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad input parameters, email may already be registered, or the password fails the password policy with the failed rules listed as json",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PasswordPolicyResp"
+                }
+              }
+            }
+          },
+          "429": {
+            "description": "too many attempts from this address, retry after the Retry-After header seconds",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/auth": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Authenticate using basic auth",
+        "description": "Users with two-factor authentication enabled must send a code, or recovery code, in the X-TOTP-Code header. Without it a challenge is returned to complete the sign in with at /auth/totp.",
+        "security": [
+          {
+            "basicAuth": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "header",
+            "name": "X-TOTP-Code",
+            "schema": {
+              "type": "string",
+              "example": "287082"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "authentication successfull, jwt token return via cookie",
+            "headers": {
+              "Set-Cookie": {
+                "description": "attributes are set by the cookie configuration, HttpOnly, Secure and SameSite=Lax by default",
+                "schema": {
+                  "type": "string",
+                  "example": "token=abc123; Path=/; Expires=Fri, 16 Oct 2026 12:30:00 GMT; HttpOnly; Secure; SameSite=Lax"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TokenResp"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, check credentials and try again. When the password is correct but a second factor code is required the body holds the challenge",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TotpRequired"
+                }
+              }
+            }
+          },
+          "429": {
+            "description": "too many attempts from this address, retry after the Retry-After header seconds",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/auth/totp": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Complete a sign in with a second factor code",
+        "description": "Accepts the challenge returned by /auth or the totpChallenge query parameter of a login provider redirect with a code, or a recovery code which is used up. Codes and challenges are accepted once and attempts are limited to 5 per minute for each user.",
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "challenge",
+                  "code"
+                ],
+                "properties": {
+                  "challenge": {
+                    "type": "string"
+                  },
+                  "code": {
+                    "type": "string",
+                    "example": "287082"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "authentication successfull, jwt token return via cookie",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TokenResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "challenge or code missing",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "challenge expired or already used, or code invalid",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "429": {
+            "description": "too many attempts from this address, retry after the Retry-After header seconds",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/auth/signout": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Sign out",
+        "description": "Revokes the auth token of the request, the token cookie when it is set, until the token expires and clears the cookie. The revocation applies to every replica when the redis state driver is configured. Tokens issued before sign out was supported remain valid until they expire.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "signed out"
+          },
+          "401": {
+            "description": "unauthorized, the token is missing, invalid or already revoked",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/auth/{provider}/login": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Sign in with a login provider",
+        "description": "Redirects to the provider, google or github when their credentials are configured. A short lived login_state cookie holds the state of the login until the provider returns the user to the callback. Signed in users link the provider account to themselves.",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "provider",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "example": "github"
+            }
+          },
+          {
+            "in": "query",
+            "name": "redirect",
+            "schema": {
+              "type": "string",
+              "example": "/gallery"
+            },
+            "description": "path or CORS allowed origin the user is sent to once signed in, the token is returned as json when omitted"
+          }
+        ],
+        "responses": {
+          "302": {
+            "description": "redirect to the provider"
+          },
+          "400": {
+            "description": "redirect isn't a path or an allowed origin",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "unknown provider or provider not configured",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/auth/{provider}/callback": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Complete signing in with a login provider",
+        "description": "Exchanges the code returned by the provider for the account and signs in its linked user. Accounts are linked to the user signed in when the login started, otherwise to the user with the same verified email, otherwise a new user without a password is registered.",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "provider",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "code",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "state",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "signed in, jwt token returned via cookie",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TokenResp"
+                }
+              }
+            }
+          },
+          "303": {
+            "description": "signed in, redirected to the redirect of the login"
+          },
+          "400": {
+            "description": "login expired, was started elsewhere or the account has no verified email",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "the user declined signing in",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "unknown provider or provider not configured",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the account is linked to another user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "502": {
+            "description": "the provider couldn't be reached or refused the code",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the profile of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "user profile",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/User"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "user not found",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Update the firstname, lastname, email or username of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/UpdateUser"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "updated user profile",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/User"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid body, empty name, invalid email, email already exists or invalid username",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "user not found",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the username is taken",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/password": {
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Change the password of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/ChangePassword"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "password changed"
+          },
+          "400": {
+            "description": "currentPassword and newPassword are required, or the new password fails the password policy with the failed rules listed as json",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PasswordPolicyResp"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "current password is incorrect",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to change password",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/password/reset-request": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Request a password reset token",
+        "description": "Issues a time limited reset token delivered to the account email. The response is the same whether or not the email is registered.",
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "email": {
+                    "type": "string",
+                    "example": "jane@mail.com"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "reset requested"
+          },
+          "400": {
+            "description": "email is required",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to request reset",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/password/reset": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Reset a password with a reset token",
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "token": {
+                    "type": "string"
+                  },
+                  "password": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "password reset, the token and any other outstanding tokens are revoked"
+          },
+          "400": {
+            "description": "token and password are required, the token is invalid or expired, or the password fails the password policy with the failed rules listed as json",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PasswordPolicyResp"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to reset password",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/quota": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the storage quota and usage of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "quota and usage in bytes",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/QuotaResp"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve quota",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/storage/estimate": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Project the monthly cost of storing and serving the authenticated user's images",
+        "description": "Stored bytes are the tracked usage including trashed images. Served bytes are projected from the views of each image spread over the months since its upload. Costs are priced per GiB with STORAGE_PRICE_GB and BANDWIDTH_PRICE_GB in PRICE_CURRENCY and rounded to cents.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "projected monthly cost",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/StorageEstimate"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to estimate cost",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/activity": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Review the activity of the authenticated user's account",
+        "description": "Lists sign ins, registration and changes to the user's images such as uploads, updates, deletes, restores and shares, most recent first, with the address each was made from.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "a page of the account activity",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Activity"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, page is not a non negative integer",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve activity",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/notifications": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the notifications of the authenticated user, newest first",
+        "description": "Users are notified when another user mentions their @username in the description of an image they can view. Each notification is also published as a user.mentioned event for delivery by email or push.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "in": "query",
+            "name": "unread",
+            "description": "only list unread notifications",
+            "schema": {
+              "type": "boolean"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "a page of notifications",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Notifications"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, page is not a non negative integer",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve notifications",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/notifications/read": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Mark notifications of the authenticated user read",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "ids": {
+                    "description": "notifications to mark read, every notification when omitted",
+                    "type": "array",
+                    "items": {
+                      "type": "integer"
+                    },
+                    "example": [
+                      4,
+                      7
+                    ]
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "notifications marked read"
+          },
+          "400": {
+            "description": "invalid body or more ids than a page holds",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update notifications",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/blocks": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Block the account with the email from viewing and interacting with the authenticated user's content",
+        "description": "Blocked users can't view shared or shareable images and albums of the user when authenticated and no longer find them in image meta queries.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "email": {
+                    "type": "string",
+                    "example": "someone@mail.com"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the account was already blocked",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/UserBlock"
+                }
+              }
+            }
+          },
+          "201": {
+            "description": "account blocked",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/UserBlock"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "email is required and may not be the user's own",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no account with that email",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to block user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the accounts blocked by the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "blocks in the order they were made",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/UserBlock"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve blocked users",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/blocks/{uid}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Unblock an account",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "uid of the blocked account"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "account unblocked"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the account is not blocked",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to unblock user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/totp": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Two-factor authentication status of the user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          },
+          {
+            "apiKey": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "status of the second factor",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "enabled": {
+                      "type": "boolean"
+                    },
+                    "recoveryCodes": {
+                      "type": "integer",
+                      "description": "unused recovery codes"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Enroll a TOTP secret",
+        "description": "Returns a new secret and its otpauth provisioning URI to show as a QR code, replacing a pending enrollment. Two-factor authentication is enabled once a code is verified at /user/totp/verify.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "201": {
+            "description": "enrollment started",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "secret": {
+                      "type": "string",
+                      "example": "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+                    },
+                    "uri": {
+                      "type": "string",
+                      "example": "otpauth://totp/PictoCache:user@mail.com?algorithm=SHA1\u0026digits=6\u0026issuer=PictoCache\u0026period=30\u0026secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "two-factor authentication is already enabled",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Disable two-factor authentication",
+        "description": "Requires a code or recovery code, pending enrollments are removed without one. Removes the recovery codes.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "code": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "two-factor authentication disabled"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "invalid code or request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user hasn't enrolled",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/totp/verify": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Verify a code of the pending enrollment to enable two-factor authentication",
+        "description": "Returns 10 recovery codes once, each accepted in place of a code a single time. Only their hashes are stored.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "code"
+                ],
+                "properties": {
+                  "code": {
+                    "type": "string",
+                    "example": "287082"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "two-factor authentication enabled",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "recoveryCodes": {
+                      "type": "array",
+                      "items": {
+                        "type": "string",
+                        "example": "3f9a-21c0-77b4-e815"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "code missing",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "invalid code or request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "no pending enrollment",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/api-keys": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Create an API key",
+        "description": "The key acts for the user when sent in the X-API-Key header until it is deleted. It is only returned once, store it safely. Keys can't create or delete keys.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "name"
+                ],
+                "properties": {
+                  "name": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "backup script"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "key created, the response includes the key",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ApiKey"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "name missing or too long",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the user already has 20 keys",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the API keys of the user in order of creation",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          },
+          {
+            "apiKey": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the keys without the keys themselves",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/ApiKey"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/api-keys/{id}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Delete an API key, requests made with it are refused immediately",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "key deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no key with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/webhooks": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Register a webhook receiving lifecycle events of the user's images",
+        "description": "Events are posted as a WebhookEvent json body with the X-Picto-Event, X-Picto-Delivery and X-Picto-Timestamp headers. X-Picto-Signature holds sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the body keyed with the secret, which is only returned once. Responses other than 2xx are retried with exponential backoff. Callbacks to loopback and private addresses are refused unless WEBHOOK_ALLOW_PRIVATE is set and redirects are not followed. Webhooks can't be managed with an API key.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "url"
+                ],
+                "properties": {
+                  "url": {
+                    "type": "string",
+                    "maxLength": 2048,
+                    "example": "https://hooks.example.com/picto"
+                  },
+                  "events": {
+                    "type": "array",
+                    "description": "event types to receive, every event when omitted or empty",
+                    "items": {
+                      "type": "string",
+                      "enum": [
+                        "image.added",
+                        "image.updated",
+                        "image.shared",
+                        "image.unshared",
+                        "image.deleted",
+                        "image.restored"
+                      ]
+                    }
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "webhook created, the response includes the secret",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Webhook"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "url isn't an absolute http or https url or an event is unknown",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the user already has 10 webhooks",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the webhooks of the user in order of creation",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          },
+          {
+            "apiKey": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the webhooks without their secrets",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Webhook"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/user/webhooks/{id}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Delete a webhook, its queued events are dropped",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "webhook deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "request made with an API key",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no webhook with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/sharing-policy": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the organisation sharing policy",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "current sharing policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/SharingPolicy"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Update the organisation sharing policy, omitted fields are unchanged",
+        "description": "The policy applies whenever an image is marked shareable. Images that are already shared are not changed, when watermark is enabled every public image is served with a watermark.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/SharingPolicy"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "updated sharing policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/SharingPolicy"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/ingest-policy": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the organisation ingest policy and the rules enforced on uploads",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "current ingest policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/IngestPolicy"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Update the organisation ingest policy, omitted fields are unchanged",
+        "description": "The policy tightens the instance rules for every upload. Types must be accepted by the instance and an empty list allows all of them, stripping and scanning required by the instance stay enforced. Images that are already stored are not changed.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/IngestRules"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "updated ingest policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/IngestPolicy"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json or type not accepted by the instance",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/moderation-policy": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the reports unsharing an image in each category",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "current moderation policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ModerationPolicy"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Update the moderation policy, omitted categories are unchanged",
+        "description": "Thresholds apply to later reports, images that were already unshared are not changed. A threshold of 0 never unshares images.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/ModerationPolicy"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "updated moderation policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ModerationPolicy"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json or negative threshold",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/moderation/cases": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "List moderation cases with a status, appealed cases first",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "status",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "open",
+                "unshared",
+                "dismissed",
+                "upheld"
+              ],
+              "default": "open"
+            },
+            "required": false
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "moderation cases",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/ModerationCase"
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unknown status",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve cases",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/moderation/cases/{id}": {
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Resolve a moderation case",
+        "description": "Dismissing a case lets the owner share the image again, upholding it unshares the image and keeps it from being shared. The owner is notified by handlers of the moderation.case_resolved outbox event.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the moderation case"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "status": {
+                    "type": "string",
+                    "enum": [
+                      "dismissed",
+                      "upheld"
+                    ]
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "resolved case",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ModerationCase"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "status must be dismissed or upheld",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no moderation case with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to resolve case",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/users/import": {
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Import users from another gallery product",
+        "description": "Accepts a JSON array or a CSV file with a header row. Each user requires either a password or a bcrypt hashedPass. Every row is validated and reported individually.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "dryRun",
+            "schema": {
+              "type": "boolean"
+            },
+            "required": false,
+            "description": "Validate rows without creating accounts"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "array",
+                "items": {
+                  "$ref": "#/components/schemas/ImportUser"
+                }
+              }
+            },
+            "text/csv": {
+              "schema": {
+                "type": "string",
+                "example": "email,firstname,lastname,hashedPass\njane@mail.com,Jane,Doe,$2a$10$..."
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "result of every row",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ImportResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, unparsable body or too many rows",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/users/{uid}/purge": {
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Delete or anonymize all content and personal data of a user",
+        "description": "The purge runs asynchronously. Every image of the user is deleted along with its credentials, password resets, clients and grants. The delete mode removes the account while anonymize keeps its id with the name and email replaced. Poll the Location of the accepted purge for its completion report.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the user to purge"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "mode",
+                  "reason"
+                ],
+                "properties": {
+                  "mode": {
+                    "type": "string",
+                    "enum": [
+                      "delete",
+                      "anonymize"
+                    ]
+                  },
+                  "reason": {
+                    "type": "string",
+                    "maxLength": 500,
+                    "example": "legal erasure request"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "purge scheduled",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "path of the purge report"
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PurgeReport"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, unparsable body, invalid mode or missing reason",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no user with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to schedule purge",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/purges/{id}": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the progress or completion report of a purge",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the purge"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "purge report",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PurgeReport"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no purge with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve purge",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/reencode-campaigns": {
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Start a campaign storing AVIF and WebP files of the library",
+        "description": "The campaign walks every image, most viewed first, storing each requested encoding that is smaller than the original. Files are written by the cwebp and avifenc commands, formats whose command isn't installed are refused. Progress and savings are reported by the location returned.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "required": false,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "formats": {
+                    "type": "array",
+                    "items": {
+                      "type": "string",
+                      "enum": [
+                        "avif",
+                        "webp"
+                      ]
+                    },
+                    "description": "encodings to produce, avif and webp when omitted"
+                  },
+                  "limit": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "description": "images examined before the campaign stops, 0 or omitted for the whole library"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "campaign scheduled",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "path of the campaign report"
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReencodeReport"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, unparsable body, negative limit or a format that can't be produced",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to schedule campaign",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/reencode-campaigns/{id}": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the progress or savings report of a re-encode campaign",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the campaign"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "campaign report",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReencodeReport"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no campaign with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve campaign",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/storage": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Inspect the health, latency and objects of every storage driver",
+        "description": "Each driver, the primary and the mirror when storage is mirrored, is probed by writing, reading and deleting an object. Open to administrators and the uids in STORAGE_ADMIN_UIDS.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "status of each driver",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/StorageDriver"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator or storage administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/storage/estimate": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Project the monthly cost of storing and serving the images of every user",
+        "description": "Sums the usage of every user, projected and priced as for /user/storage/estimate. Open to administrators and the uids in STORAGE_ADMIN_UIDS.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "projected monthly cost of the organisation",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/StorageEstimate"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator or storage administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to estimate cost",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/storage/checks": {
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Check the files of a user or beneath a key prefix against the database",
+        "description": "The check runs asynchronously. Stored files no image or blob references are reported as orphans and images or blobs whose file is absent as missing. Open to administrators and the uids in STORAGE_ADMIN_UIDS.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "description": "provide either uid or prefix",
+                "properties": {
+                  "uid": {
+                    "type": "integer",
+                    "example": 12
+                  },
+                  "prefix": {
+                    "type": "string",
+                    "example": "blobs/"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "check scheduled, its report is at the Location header",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "path of the check report"
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/StorageCheckReport"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid body, neither or both of uid and prefix or a prefix with dot segments",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator or storage administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no user with that uid",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to schedule check",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/storage/checks/{id}": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the progress or report of a storage check",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "check report",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/StorageCheckReport"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator or storage administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no check with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve check",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/storage/checks/{id}/{kind}": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "List the orphaned or missing files found by a storage check ordered by key",
+        "description": "At most 5000 findings are recorded per check, the report counts every finding.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          },
+          {
+            "in": "path",
+            "name": "kind",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "orphans",
+                "missing"
+              ]
+            },
+            "required": true
+          },
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "a page of findings",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/StorageFindings"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, page is not a non negative integer",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator or storage administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no check with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve findings",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/announcements": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "List every announcement including scheduled and ended announcements",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "announcements, most recently started first",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Announcement"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Publish an announcement to clients",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AnnouncementParams"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "the published announcement",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Announcement"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, invalid title, message, severity or schedule",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/announcements/{id}": {
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Replace the content and schedule of an announcement",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "required": true,
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AnnouncementParams"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the updated announcement",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Announcement"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, invalid title, message, severity or schedule",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "not found, no announcement with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Withdraw an announcement",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "required": true,
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "announcement deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "not found, no announcement with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/signing-keys": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "List the ids of the keys signing and verifying tokens",
+        "description": "Secrets are never listed. The current key signs new tokens, every key verifies tokens naming it in their kid header.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "keys of the keyring",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Keyring"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/signing-keys/reload": {
+      "post": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Reload the signing keys of the replica from SIGNING_KEYS_FILE or SIGNING_KEYS",
+        "description": "Only the replica answering the request is reloaded, send SIGHUP to or call each replica when rotating keys. An invalid keyring is refused and the current keys remain in use.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "keys of the reloaded keyring",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Keyring"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, the keyring could not be read or is invalid",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/faults": {
+      "get": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Retrieve the fault injection rules of the instance",
+        "description": "Only available when FAULT_INJECTION is enabled on the instance, rules are kept in memory by each instance.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "rules keyed by layer",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/FaultRules"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "fault injection is disabled on this instance",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Replace the fault injection rules of the instance, omitted layers have no faults",
+        "description": "Database faults apply whenever a store function connects to the database, storage faults apply to every storage driver call. Each call is delayed and failed independently with the configured percentages.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/FaultRules"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "rules now in effect",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/FaultRules"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unparsable body, unknown layer or out of range value",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "fault injection is disabled on this instance",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "Admin"
+        ],
+        "summary": "Remove every fault injection rule of the instance",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "rules cleared, responds with the empty rules"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "forbidden, user is not an administrator",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "fault injection is disabled on this instance",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Upload an image to the repository",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "$ref": "#/components/schemas/CreateImage"
+              },
+              "encoding": {
+                "image": {
+                  "contentType": "image/png, image/jpeg, image/webp, image/gif, image/avif"
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "image upload successfull"
+          },
+          "400": {
+            "description": "bad request, an image type not listed in UPLOAD_TYPES or refused by the ingest policy, or an invalid title",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "shareable requested while public sharing is disabled by the sharing policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "conflict, title already used and TITLE_POLICY is reject, identical content is stored by another member and dedup is prompt, or the user already uploaded identical content and DUPLICATE_POLICY is reject",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "413": {
+            "description": "upload exceeds the user's storage quota",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "upload rejected by the malware scanner required by the ingest policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to upload",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "the ingest policy requires scanning and the scanner is unavailable",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "507": {
+            "description": "the server is low on disk space, try again later",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/batch": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Upload multiple images in a single request",
+        "description": "Files are saved concurrently and reported individually, a failed file does not fail the batch. shareable and tags apply to every file.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "$ref": "#/components/schemas/CreateBatch"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "result of each file in the order sent",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/BatchResult"
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, no files or too many files",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "413": {
+            "description": "batch exceeds the size limit",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/bulk": {
+      "patch": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Change the shareable flag, tags or album of many images at once",
+        "description": "The changes are applied to every image the user owns in a single transaction and reported per image, images that don't exist, belong to another user or are in the trash are reported as 404 and images restricted by moderation as 403 when sharing. Omitted fields are left unchanged, tags replace the tags of each image and an empty list clears them. Images are added to the end of the album, images already in it keep their position.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "ids"
+                ],
+                "properties": {
+                  "ids": {
+                    "type": "array",
+                    "minItems": 1,
+                    "maxItems": 100,
+                    "items": {
+                      "type": "integer"
+                    }
+                  },
+                  "shareable": {
+                    "type": "boolean"
+                  },
+                  "tags": {
+                    "type": "array",
+                    "items": {
+                      "type": "string",
+                      "example": "holiday"
+                    }
+                  },
+                  "album": {
+                    "type": "integer",
+                    "description": "id of an album of the user the images are added to"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "result of each image in the order requested",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/BulkResult"
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, no ids, too many ids, invalid tags or no change specified",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "public sharing is disabled by the sharing policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the album would exceed 5000 images, no image was changed",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, no image was changed",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Move many images to the trash at once",
+        "description": "Every image the user owns is trashed in a single transaction and reported per image, images that don't exist, belong to another user or are already in the trash are reported as 404.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "ids"
+                ],
+                "properties": {
+                  "ids": {
+                    "type": "array",
+                    "minItems": 1,
+                    "maxItems": 100,
+                    "items": {
+                      "type": "integer"
+                    }
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "result of each image in the order requested",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/BulkResult"
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, no ids or too many ids",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, no image was moved to the trash",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/intake": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Queue images to be saved in the background",
+        "description": "For burst imports. Files are staged and answered with 202 before they are saved, each with a URL reporting its outcome. Queued files are saved taking turns between users so one large import doesn't hold up others. shareable, dedup and tags apply to every file, files refused before they are queued are reported as failed without an id.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "$ref": "#/components/schemas/CreateBatch"
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "status of each file in the order sent",
+            "headers": {
+              "Location": {
+                "description": "status URL of the upload when a single file was sent",
+                "schema": {
+                  "type": "string"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/IntakeUpload"
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, no files, too many files or invalid tags or dedup",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "413": {
+            "description": "request exceeds the size limit",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "429": {
+            "description": "too many uploads of the user are waiting to be saved, retry after Retry-After seconds",
+            "headers": {
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                }
+              }
+            },
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to queue the files",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/intake/{id}": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the status of a queued upload",
+        "description": "Finished uploads can be looked up for a day.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "required": true,
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "status of the upload",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/IntakeUpload"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "not found, the user has no queued upload with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/duplicates": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List groups of the authenticated user's images that are identical or look alike",
+        "description": "Images are grouped when their content is identical or their perceptual hashes differ by at most DUPLICATE_DISTANCE bits, so copies exported at another size or quality can be cleaned up. Groups are ordered by their newest image.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "a page of duplicate groups",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Duplicates"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, page is not a non negative integer",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to find duplicates",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/compare": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Compare two images the authenticated user can view",
+        "description": "Both images are sampled onto a common grid of the smaller of each dimension, at most 1024 pixels wide or high, and compared by the structural similarity of their luminance, the mean squared error and PSNR of their colors and the pixels whose color visibly changed. Public images served with a watermark can only be compared by their owner and accounts they are shared with.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "a",
+            "required": true,
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "in": "query",
+            "name": "b",
+            "required": true,
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "in": "query",
+            "name": "diff",
+            "description": "set to true to respond with a png of the first image faded to gray and changed pixels highlighted in red",
+            "schema": {
+              "type": "boolean"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "similarity metrics, or the diff rendering with diff=true",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Comparison"
+                }
+              },
+              "image/png": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, a or b is missing or an image can't be decoded",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image the user can view with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to compare images",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Stream changes to the images the authenticated user can see",
+        "description": "A Server-Sent Events stream notifying the owner of an image and the accounts it is shared with when it is added, updated, shared, unshared, deleted or restored. Each event is named by its type and carries a LibraryEvent as data, idle streams receive keepalive comments. Events are not replayed, clients reload the library after reconnecting. Browsers authenticate EventSource requests with the token cookie.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "stream of library events",
+            "content": {
+              "text/event-stream": {
+                "schema": {
+                  "$ref": "#/components/schemas/LibraryEvent"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/policy": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Issue a signed upload policy for direct uploads",
+        "description": "The policy embeds the owner, maximum size and allowed types which are enforced when the upload is redeemed at the returned url. Requested constraints are clamped to the server limits.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/UploadPolicyReq"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "signed upload policy",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/UploadPolicyResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, unsupported type requested",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/upload": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Upload an image authorized by an upload policy",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "policy",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Signed upload policy issued by /image/policy"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "$ref": "#/components/schemas/CreateImage"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "image upload successfull"
+          },
+          "400": {
+            "description": "bad request, type not allowed by policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, policy invalid or expired",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "shareable requested while public sharing is disabled by the sharing policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "413": {
+            "description": "upload exceeds the policy size limit",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "507": {
+            "description": "the server is low on disk space, try again later",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{id}/shares": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Grant the account with the email view access to an image of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "email": {
+                    "type": "string",
+                    "example": "friend@mail.com"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the image was already shared with the account",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ImageShare"
+                }
+              }
+            }
+          },
+          "201": {
+            "description": "image shared",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ImageShare"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "email is required and may not be the owner's",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image of the user with that id or no account with that email",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the image is already shared with 500 accounts",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to share image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the accounts an image of the authenticated user is shared with",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "grants in the order they were made",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/ImageShare"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image of the user with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve shares",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{id}/shares/{uid}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Revoke the view access of an account to an image of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          },
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "uid of the account the image is shared with"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "share revoked"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image of the user with that id or the image is not shared with the account",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to revoke share",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{id}/reports": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Report an image the authenticated user can view",
+        "description": "Reports of an image in the same category are collected in a moderation case. Once the case reaches the threshold of its category set by the moderation policy the image is unshared and the owner is notified by handlers of the moderation.image_unshared outbox event.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "category": {
+                    "type": "string",
+                    "enum": [
+                      "copyright",
+                      "nsfw",
+                      "spam"
+                    ]
+                  },
+                  "reason": {
+                    "type": "string",
+                    "maxLength": 1000
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {
+            "description": "report received"
+          },
+          "400": {
+            "description": "unknown category or reason too long",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that id the user can view, owners can't report their images",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the user already reported the image in the category",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to report image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{id}/exif.json": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the complete EXIF data of an image",
+        "description": "Returns every entry of every EXIF directory with its tag, type and decoded value for photo forensics. Only the owner and accounts the image is explicitly shared with may read it, shareable images don't publish their EXIF data. The EXIF data of JPEG images rewritten upright by the orient processor is kept from the original upload.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "EXIF directories of the image, empty if it has no EXIF data",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Exif"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, invalid image id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that id owned by or shared with the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve exif data",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/moderation/cases": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the moderation cases about images of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "moderation cases in the order they were opened",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/ModerationCase"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve cases",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/moderation/cases/{id}/appeal": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Appeal a case that unshared an image of the authenticated user",
+        "description": "The case is reopened for review by an administrator, the image remains unshared until the case is dismissed. Each case may be appealed once.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the moderation case"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "reason": {
+                    "type": "string",
+                    "maxLength": 1000
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "reopened case",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ModerationCase"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json or reason too long",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no moderation case about an image of the user with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the case did not unshare the image or was already appealed",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to appeal",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve an image from the server, HEAD requests return the same headers without the image",
+        "description": "Images are served to their owner and to users the owner shared them with. Without w, h or format the image is scaled down for the Client Hints Sec-CH-Width, Sec-CH-Viewport-Width, Sec-CH-DPR and Save-Data to the nearest standard width of 160, 320, 480, 640, 960, 1280, 1920, 2560 or 3840 pixels, responses request the hints with Accept-CH and vary on them. Otherwise clients listing image/avif or image/webp in Accept are served the smallest file a re-encode campaign stored in an accepted encoding, such responses vary on Accept.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "header",
+            "name": "Range",
+            "schema": {
+              "type": "string"
+            },
+            "required": false,
+            "description": "Byte range of the image to retrieve such as bytes=0-1023"
+          },
+          {
+            "in": "query",
+            "name": "w",
+            "schema": {
+              "type": "integer",
+              "example": 400
+            },
+            "required": false,
+            "description": "Width of a resized variant, images are never enlarged"
+          },
+          {
+            "in": "query",
+            "name": "h",
+            "schema": {
+              "type": "integer",
+              "example": 300
+            },
+            "required": false,
+            "description": "Height of a resized variant, images are never enlarged"
+          },
+          {
+            "in": "query",
+            "name": "fit",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "contain",
+                "cover"
+              ],
+              "default": "contain"
+            },
+            "required": false,
+            "description": "contain scales the image within w and h, cover crops the center to fill both and requires w and h"
+          },
+          {
+            "in": "query",
+            "name": "format",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "jpeg",
+                "jpg",
+                "png",
+                "gif",
+                "webp",
+                "avif"
+              ]
+            },
+            "required": false,
+            "description": "Convert the image to this format, webp images may be converted to other formats but webp and avif output is not available"
+          },
+          {
+            "in": "query",
+            "name": "meta",
+            "schema": {
+              "type": "boolean"
+            },
+            "required": false,
+            "description": "true returns the metadata of the image as json instead of the file, not counted as a view"
+          },
+          {
+            "in": "query",
+            "name": "download",
+            "schema": {
+              "type": "boolean"
+            },
+            "required": false,
+            "description": "true serves the image as an attachment named after its title and counts it as a download instead of a view"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The image in the format uploaded by the user, with ETag and Last-Modified headers for conditional requests. Browsers may keep the image for IMAGE_CACHE_MAX_AGE seconds, by default they must revalidate. HEAD requests receive the Content-Type, Content-Length, ETag and Last-Modified headers only",
+            "headers": {
+              "ETag": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "strong entity tag, the quoted content hash of the stored image or a tag of the resized, converted or re-encoded file"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string",
+                  "example": "private, no-cache"
+                },
+                "description": "private with the max-age of IMAGE_CACHE_MAX_AGE, or no-cache when it is 0"
+              },
+              "Expires": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "moment the response becomes stale, for HTTP/1.0 caches"
+              },
+              "Last-Modified": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "moment the stored file was written"
+              },
+              "Repr-Digest": {
+                "schema": {
+                  "type": "string",
+                  "example": "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+                },
+                "description": "sha256 of the stored image, absent for resized, converted or watermarked responses and images stored before hashes were recorded"
+              },
+              "Digest": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "the same sha256 in the RFC 3230 format for older clients"
+              }
+            },
+            "content": {
+              "image/jpeg": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/png": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/webp": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/gif": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/avif": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              }
+            }
+          },
+          "206": {
+            "description": "The requested byte range of the image"
+          },
+          "304": {
+            "description": "not modified, the image matches If-None-Match or If-Modified-Since"
+          },
+          "400": {
+            "description": "bad request or invalid resize or format parameters",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token and have permissions to view specified image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "406": {
+            "description": "conversion to the requested format is not available",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "gone, the metadata remains but the file was lost from every storage copy. The image is marked damaged and an integrity check of the owner's files is scheduled",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to upload",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Moves an image to the trash",
+        "description": "Trashed images are hidden from every other endpoint and keep counting towards the storage quota. They can be restored until they are permanently purged TRASH_RETENTION days after deletion.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "image moved to the trash"
+          },
+          "400": {
+            "description": "bad request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token and have permissions to delete specified image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to delete",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Updates image metadata on the server",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/UpdateImage"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "successfull update of image data, returns new image meta",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request or an invalid title",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token and have permissions to delete specified image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "shareable requested while public sharing is disabled by the sharing policy or while a moderation case restricts the image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "conflict, title already used and TITLE_POLICY is reject",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to delete",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/trash": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the authenticated user's trashed images, most recently deleted first",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            },
+            "required": false
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "page of the trash",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Trash"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid page",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve trash",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/restore": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Restore a trashed image of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "restored image",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference in the user's trash",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to restore",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/stats": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the daily views and downloads of an image of the authenticated user",
+        "description": "Counts are recorded periodically and may lag behind requests by up to a minute. Days are UTC.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "query",
+            "name": "days",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 366,
+              "default": 30
+            },
+            "required": false,
+            "description": "days of the series ending today"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "total views and downloads of the image and their daily series oldest first",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ImageStats"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, days must be between 1 and 366",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference owned by the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve stats",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/like": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Like an image the authenticated user can view",
+        "description": "Owners, users the image is shared with and users viewing a publicly shared image may like it. Liking an image twice has no effect.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "like count of the image after the like was added",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Like"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update the like",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Remove the like of the authenticated user from an image",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "like count of the image after the like was removed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Like"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update the like",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/rating": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Rate an image the authenticated user can view with 1 to 5 stars",
+        "description": "Each user holds a single rating of an image, rating it again replaces the rating.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "stars"
+                ],
+                "properties": {
+                  "stars": {
+                    "type": "integer",
+                    "minimum": 1,
+                    "maximum": 5
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the user's rating and the aggregate rating of the image",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Rating"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, stars must be between 1 and 5",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to rate the image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/comments": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Comment on an image the authenticated user can view",
+        "description": "Owners, users the image is shared with and users viewing a publicly shared image may comment unless the owner blocked them.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "body"
+                ],
+                "properties": {
+                  "body": {
+                    "type": "string",
+                    "maxLength": 2000,
+                    "example": "Great light in this one"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "comment added",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Comment"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, the comment is empty or longer than 2000 characters",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to add the comment",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the comments of an image the authenticated user can view oldest first",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "in": "query",
+            "name": "pageSize",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 200
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "page of comments",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/CommentPage"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, invalid page or pageSize",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/{uid}/{img}/comments/{commentId}": {
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Edit a comment of the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "path",
+            "name": "commentId",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "body"
+                ],
+                "properties": {
+                  "body": {
+                    "type": "string",
+                    "maxLength": 2000,
+                    "example": "Great light in this one"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "comment edited",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Comment"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "bad request, the comment is empty or longer than 2000 characters",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user or no comment with that id on the image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "the comment was written by another user",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Delete a comment of the authenticated user or any comment on an image they own",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "path",
+            "name": "commentId",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "comment deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no image with that reference visible to the user or no comment with that id on the image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "the comment was written by another user on an image the user doesn't own",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/public/image/{uid}/{img}": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Retrieve a shareable image without authentication, HEAD requests return the same headers without the image",
+        "description": "Images marked shareable or belonging to a shareable album are served, private images are reported as not found. Images that aren't watermarked are scaled down for the Client Hints Sec-CH-Width, Sec-CH-Viewport-Width, Sec-CH-DPR and Save-Data or served in a re-encoded format the client accepts like authenticated image requests.",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "User ID of the photo owner"
+          },
+          {
+            "in": "path",
+            "name": "img",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "Image reference as defined by server"
+          },
+          {
+            "in": "header",
+            "name": "Range",
+            "schema": {
+              "type": "string"
+            },
+            "required": false,
+            "description": "Byte range of the image to retrieve such as bytes=0-1023"
+          },
+          {
+            "in": "query",
+            "name": "v",
+            "schema": {
+              "type": "string"
+            },
+            "required": false,
+            "description": "Content version from publicRef, the current version is served with an immutable Cache-Control"
+          },
+          {
+            "in": "query",
+            "name": "download",
+            "schema": {
+              "type": "boolean"
+            },
+            "required": false,
+            "description": "true serves the image as an attachment named after its title and counts it as a download instead of a view"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The image in the format uploaded by the user, with ETag and Last-Modified headers for conditional requests. Versioned requests are cached for a year, others for PUBLIC_CACHE_MAX_AGE seconds and by default must revalidate",
+            "headers": {
+              "ETag": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "strong entity tag, the quoted content hash of the stored image or a tag of the resized, converted or watermarked file"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string",
+                  "example": "public, max-age=31536000, immutable"
+                },
+                "description": "immutable for the current version, otherwise public with the max-age of PUBLIC_CACHE_MAX_AGE or no-cache when it is 0"
+              },
+              "Expires": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "moment the response becomes stale, for HTTP/1.0 caches"
+              },
+              "Repr-Digest": {
+                "schema": {
+                  "type": "string",
+                  "example": "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+                },
+                "description": "sha256 of the stored image, absent for resized, converted or watermarked responses and images stored before hashes were recorded"
+              },
+              "Digest": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "the same sha256 in the RFC 3230 format for older clients"
+              }
+            },
+            "content": {
+              "image/jpeg": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/png": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/webp": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/gif": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/avif": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              }
+            }
+          },
+          "206": {
+            "description": "The requested byte range of the image"
+          },
+          "304": {
+            "description": "not modified, the image matches If-None-Match or If-Modified-Since"
+          },
+          "400": {
+            "description": "bad request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no shareable image with that reference",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "gone, the file of the image was lost from every storage copy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/meta": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieves image metadata based on supplied parameters and user permission all parameters are optional. When query parameters are not set returns all image metadata belonging to the user",
+        "description": "This is the search endpoint of the API, there is no separate /search route. The parameters filter the images searched and the response counts the matching images per facet so clients can render filter sidebars without extra queries",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "description": "specifies the id of the image of interest"
+          },
+          {
+            "in": "query",
+            "name": "uid",
+            "schema": {
+              "type": "integer"
+            },
+            "description": "specifies the uid of the images of interest"
+          },
+          {
+            "in": "query",
+            "name": "title",
+            "schema": {
+              "type": "string"
+            },
+            "description": "specifies the title of the images of interest"
+          },
+          {
+            "in": "query",
+            "name": "description",
+            "schema": {
+              "type": "string"
+            },
+            "description": "words that must all appear in the description of the images of interest, in any order"
+          },
+          {
+            "in": "query",
+            "name": "encoding",
+            "schema": {
+              "type": "string"
+            },
+            "description": "specifies the encoding tipe of the images of interest"
+          },
+          {
+            "in": "query",
+            "name": "shareable",
+            "schema": {
+              "type": "boolean"
+            },
+            "description": "specifies the sharable status of the images of interest"
+          },
+          {
+            "in": "query",
+            "name": "sharedWithMe",
+            "schema": {
+              "type": "boolean"
+            },
+            "description": "true limits results to images other users shared with the requester. Results always include the requester's images, shareable images and images shared with the requester"
+          },
+          {
+            "in": "query",
+            "name": "liked",
+            "schema": {
+              "type": "boolean"
+            },
+            "description": "true limits results to images the requester likes that remain visible to them"
+          },
+          {
+            "in": "query",
+            "name": "tags",
+            "schema": {
+              "type": "string"
+            },
+            "description": "comma separated list of tags the images of interest are tagged with"
+          },
+          {
+            "in": "query",
+            "name": "tagMode",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "and",
+                "or"
+              ]
+            },
+            "description": "defaults to and, requiring every tag. Use or to match images with any of the tags"
+          },
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer"
+            },
+            "description": "defaults to 0. For generic queries paginated requests are required."
+          },
+          {
+            "in": "query",
+            "name": "pageSize",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 200
+            },
+            "description": "defaults to 50 results per page"
+          },
+          {
+            "in": "query",
+            "name": "sort",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "title",
+                "size",
+                "date",
+                "rating"
+              ]
+            },
+            "description": "orders results by title ignoring case, size in bytes, upload date or average rating, unrated images averaging 0. Results are in upload order when omitted"
+          },
+          {
+            "in": "query",
+            "name": "order",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "asc",
+                "desc"
+              ]
+            },
+            "description": "defaults to asc"
+          },
+          {
+            "in": "query",
+            "name": "consistency",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "eventual",
+                "strong"
+              ]
+            },
+            "description": "defaults to eventual, results may be served by a read replica that lags behind recent uploads. Use strong to read from the primary database, for example when listing right after an upload"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "successfull query returns query results and array of image meta",
+            "headers": {
+              "Link": {
+                "description": "with PRELOAD_HINTS enabled, rel=preload hints for the thumbnails of the first 12 images of the first page images, also sent as 103 Early Hints when PRELOAD_HINTS is early",
+                "schema": {
+                  "type": "string",
+                  "example": "\u003c/image/7/1.png?w=256\u0026h=256\u003e; rel=preload; as=image"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/QueryResp"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse query, page size out of bounds or unknown sort or order",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized ensure you have a valid jwt",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error unable to complete request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/image/meta/export": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Streams the metadata of every image belonging to the user matching the /image/meta filters as CSV or newline delimited JSON, or their files as a zip bundle, results are not paginated",
+        "description": "Zip bundles hold the original file of each image beneath images/ named by id and title, followed by manifest.json listing the metadata, size and SHA-256 of every file and the images whose file is missing from storage.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "format",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "csv",
+                "ndjson",
+                "zip"
+              ]
+            },
+            "description": "defaults to csv when the Accept header includes text/csv, zip when it includes application/zip, otherwise ndjson"
+          },
+          {
+            "in": "query",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "in": "query",
+            "name": "title",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "encoding",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "shareable",
+            "schema": {
+              "type": "boolean"
+            }
+          },
+          {
+            "in": "query",
+            "name": "tags",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "tagMode",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "and",
+                "or"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "image metadata, csv has a header row and tags comma separated in a single column",
+            "content": {
+              "text/csv": {
+                "schema": {
+                  "type": "string"
+                }
+              },
+              "application/x-ndjson": {
+                "schema": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              },
+              "application/zip": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid format or unable to parse query",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized ensure you have a valid jwt",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error unable to complete request",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Create an album",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AlbumParams"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "album created",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Album"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "title is required and may not exceed 100 characters, descriptions may not exceed 2000 characters",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "public sharing is disabled by the sharing policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to create album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the albums of the authenticated user in order of creation",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "albums of the user",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Album"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve albums",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve an album with its images in order",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the album and its images",
+            "headers": {
+              "Link": {
+                "description": "with PRELOAD_HINTS enabled, rel=preload hints for the thumbnails of the first 12 images, also sent as 103 Early Hints when PRELOAD_HINTS is early",
+                "schema": {
+                  "type": "string",
+                  "example": "\u003c/image/7/1.png?w=256\u0026h=256\u003e; rel=preload; as=image"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Update the title, description or shareable flag of an album",
+        "description": "Properties omitted from the body are unchanged. Sharing an album serves every image it contains publicly.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AlbumParams"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the updated album and its images",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid title or description",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "public sharing is disabled by the sharing policy",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Delete an album, the images it contained are kept",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "album deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to delete album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/images": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Add images to an album or move images within it",
+        "description": "Images are inserted in order at position, or appended when position is omitted. Images already in the album are moved to the new position.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "imageIds"
+                ],
+                "properties": {
+                  "imageIds": {
+                    "type": "array",
+                    "items": {
+                      "type": "integer"
+                    },
+                    "example": [
+                      12,
+                      14
+                    ],
+                    "description": "ids of the user's images, at most 100"
+                  },
+                  "position": {
+                    "type": "integer",
+                    "example": 0,
+                    "description": "zero based position of the first image"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the album and its images",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "imageIds must list 1 to 100 images and position may not be negative",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id or an image is not one of the user's images",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "albums may contain at most 5000 images",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/images/{imageId}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Remove an image from an album, the image is kept",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          },
+          {
+            "in": "path",
+            "name": "imageId",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the image"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "image removed from the album"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id or the image is not in it",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/order": {
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Replace the order of the images of an album",
+        "description": "imageIds must list every image of the album exactly once, images in the trash keep their place after the listed images.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "imageIds"
+                ],
+                "properties": {
+                  "imageIds": {
+                    "type": "array",
+                    "items": {
+                      "type": "integer"
+                    },
+                    "example": [
+                      14,
+                      12
+                    ]
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the album and its images in the new order",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "imageIds lists an image more than once or more than 5000 images",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "imageIds doesn't list every image of the album, retrieve the album and try again",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/cover": {
+      "put": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Choose the image of the album used as its cover",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "imageId"
+                ],
+                "properties": {
+                  "imageId": {
+                    "type": "integer",
+                    "example": 14
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "the album with its new cover",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id or the image is not in it",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Reset the cover of the album to its first image",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the album with the first image as cover",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to update album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/public/album/{id}": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Retrieve a shareable album with its images without authentication",
+        "description": "Private albums are reported as not found.",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the album and its images",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AlbumDetail"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no shareable album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve album",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/guest-links": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Create a link letting guests without an account upload into an album",
+        "description": "Omitted limits use the defaults. The token and url are only returned once, the server stores a hash of the token.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/GuestLinkParams"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "the link with its token and url",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/GuestLink"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json or a limit is out of bounds",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "albums may have at most 20 guest links",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to create guest link",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the guest links of an album, expired links are listed for a week before they are purged",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the links of the album newest first, without their tokens",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/GuestLink"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve guest links",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/album/{id}/guest-links/{linkId}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Revoke a guest link, images uploaded through it are kept",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "id",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the album"
+          },
+          {
+            "in": "path",
+            "name": "linkId",
+            "schema": {
+              "type": "integer"
+            },
+            "required": true,
+            "description": "id of the guest link"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "link revoked"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no album with that id or the album has no such link",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to delete guest link",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/guest/{token}": {
+      "get": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Describe the drop box of a guest link without authentication",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "token",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "token of the guest link"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "the album title and the limits of the link",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/DropBox"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the link is invalid or was deleted",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "the link expired or reached its upload limit",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve guest link",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Upload an image into the album of a guest link without authentication",
+        "description": "The image belongs to the album owner, counts against the owner's quota and is appended to the album.",
+        "parameters": [
+          {
+            "in": "path",
+            "name": "token",
+            "schema": {
+              "type": "string"
+            },
+            "required": true,
+            "description": "token of the guest link"
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "image"
+                ],
+                "properties": {
+                  "image": {
+                    "type": "string",
+                    "format": "binary"
+                  },
+                  "title": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "the uploaded image and the uploads remaining",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/GuestUpload"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "not multipart form data or the image is missing or not a supported type",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the link is invalid or was deleted",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "the album is full",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "the link expired or reached its upload limit",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "413": {
+            "description": "the image exceeds the size limit of the link",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to save image",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/timeline": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Retrieve the images of the authenticated user grouped by the day or month they were taken",
+        "description": "Images are dated by their EXIF taken date, falling back to the upload date. Buckets are returned newest first with their number of images and thumbnails of the most recently taken images.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "day",
+                "month"
+              ],
+              "default": "day"
+            }
+          },
+          {
+            "in": "query",
+            "name": "page",
+            "schema": {
+              "type": "integer",
+              "default": 0
+            },
+            "description": "page of 30 buckets"
+          },
+          {
+            "in": "query",
+            "name": "consistency",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "eventual",
+                "strong"
+              ]
+            },
+            "description": "strong reads from the primary database instead of a replica"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "a page of timeline buckets",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Timeline"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid group or page",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve timeline",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/clients": {
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Register a third party application as an OAuth client",
+        "description": "The client secret is only returned in this response.",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "name": {
+                    "type": "string",
+                    "example": "Photo Printer"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "client registered",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/OAuthClient"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "name is required and may not exceed 100 characters",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to register client",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the OAuth clients registered by the authenticated user",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "registered clients without their secrets",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/OAuthClient"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve clients",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/clients/{clientId}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Delete an OAuth client and every grant to it",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "clientId",
+            "schema": {
+              "type": "string"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "client deleted"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the user has no client with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to delete client",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/consent": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Describe a client and the scopes it requests for a consent screen",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "schema": {
+              "type": "string"
+            },
+            "required": true
+          },
+          {
+            "in": "query",
+            "name": "scope",
+            "schema": {
+              "type": "string",
+              "example": "images:read profile:read"
+            },
+            "required": true,
+            "description": "Space separated scopes from images:read, images:write and profile:read"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "client, requested scopes and scopes already granted",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/OAuthConsent"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "invalid scope",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no client with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Grant a client scopes on behalf of the authenticated user, replacing any previous grant",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "clientId": {
+                    "type": "string"
+                  },
+                  "scope": {
+                    "type": "string",
+                    "example": "images:read"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "access granted",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/OAuthGrant"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unable to parse json or invalid scope",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "no client with that id",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to grant access",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/grants": {
+      "get": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "List the clients the authenticated user has granted access",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "granted clients",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/OAuthGrant"
+                  }
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to retrieve grants",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/grants/{clientId}": {
+      "delete": {
+        "tags": [
+          "JWT"
+        ],
+        "summary": "Revoke the access granted to a client, tokens already issued stop working immediately",
+        "security": [
+          {
+            "jwt": []
+          },
+          {
+            "bearer": []
+          }
+        ],
+        "parameters": [
+          {
+            "in": "path",
+            "name": "clientId",
+            "schema": {
+              "type": "string"
+            },
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "access revoked"
+          },
+          "401": {
+            "description": "unauthorized, must have valid auth token",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "the client has not been granted access",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error, unable to revoke access",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/oauth/token": {
+      "post": {
+        "tags": [
+          "Open"
+        ],
+        "summary": "Issue an access token to a client for a user who granted it access",
+        "description": "Client credentials grant. The client authenticates with basic auth or the client_id and client_secret fields. Access tokens are used as bearer tokens for image and profile requests permitted by their scopes, requests outside the scopes are refused with 403 and clients exceeding OAUTH_RATE_LIMIT receive 429 with Retry-After.",
+        "security": [
+          {
+            "basicAuth": []
+          }
+        ],
+        "requestBody": {
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "grant_type",
+                  "user_id"
+                ],
+                "properties": {
+                  "grant_type": {
+                    "type": "string",
+                    "enum": [
+                      "client_credentials"
+                    ]
+                  },
+                  "user_id": {
+                    "type": "integer"
+                  },
+                  "scope": {
+                    "type": "string",
+                    "description": "Subset of the granted scopes, defaults to every granted scope"
+                  },
+                  "client_id": {
+                    "type": "string"
+                  },
+                  "client_secret": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "access token",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/OAuthToken"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "unsupported grant_type, missing user_id, scope not granted or the user has not granted the client access",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "401": {
+            "description": "invalid client credentials",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "internal server error",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "servers": [
+    {
+      "url": "/api/v1"
+    }
+  ],
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {
+        "type": "http",
+        "scheme": "basic"
+      },
+      "jwt": {
+        "type": "apiKey",
+        "in": "cookie",
+        "name": "token"
+      },
+      "bearer": {
+        "type": "http",
+        "scheme": "bearer",
+        "bearerFormat": "JWT",
+        "description": "auth tokens and client access tokens, or tokens of the authorization server named by OAUTH_INTROSPECTION_URL"
+      },
+      "apiKey": {
+        "type": "apiKey",
+        "in": "header",
+        "name": "X-API-Key"
+      }
+    },
+    "schemas": {
+      "QueryResp": {
+        "type": "object",
+        "required": [
+          "page",
+          "pageSize",
+          "totalResults",
+          "imageMeta"
+        ],
+        "properties": {
+          "page": {
+            "type": "integer",
+            "example": 0,
+            "description": "page of request"
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 50,
+            "description": "size of page, the pageSize parameter or 50"
+          },
+          "totalResults": {
+            "type": "integer",
+            "example": 212,
+            "description": "total number of results for query, requires pagination"
+          },
+          "imageMeta": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Image"
+            }
+          },
+          "facets": {
+            "$ref": "#/components/schemas/Facets"
+          },
+          "prev": {
+            "type": "string",
+            "example": "/image/meta?page=0\u0026pageSize=20\u0026sort=date",
+            "description": "previous page of the query with the other parameters preserved, omitted on the first page"
+          },
+          "next": {
+            "type": "string",
+            "example": "/image/meta?page=2\u0026pageSize=20\u0026sort=date",
+            "description": "next page of the query with the other parameters preserved, omitted on the last page"
+          }
+        }
+      },
+      "Facets": {
+        "type": "object",
+        "description": "counts across every page of the query ordered by descending count, at most 50 values per facet",
+        "properties": {
+          "tags": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/FacetCount"
+            }
+          },
+          "encodings": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/FacetCount"
+            }
+          },
+          "albums": {
+            "type": "array",
+            "description": "albums the requester owns or that are shareable, the value is the album id",
+            "items": {
+              "$ref": "#/components/schemas/FacetCount"
+            }
+          }
+        }
+      },
+      "FacetCount": {
+        "type": "object",
+        "properties": {
+          "value": {
+            "type": "string",
+            "example": "beach"
+          },
+          "label": {
+            "type": "string",
+            "example": "Summer holiday",
+            "description": "title of the album, omitted for other facets"
+          },
+          "count": {
+            "type": "integer",
+            "example": 12
+          }
+        }
+      },
+      "Image": {
+        "type": "object",
+        "required": [
+          "id",
+          "uid",
+          "title",
+          "ref",
+          "size",
+          "encoding",
+          "shareable"
+        ],
+        "properties": {
+          "id": {
+            "type": "integer",
+            "example": 1
+          },
+          "uid": {
+            "type": "integer",
+            "example": 1
+          },
+          "title": {
+            "type": "string",
+            "example": "myimage.png"
+          },
+          "ref": {
+            "type": "string",
+            "example": "localhost:8000/1/6.png"
+          },
+          "size": {
+            "type": "integer",
+            "example": 2950
+          },
+          "encoding": {
+            "type": "string",
+            "example": "image/png"
+          },
+          "shareable": {
+            "type": "boolean",
+            "example": true
+          },
+          "description": {
+            "type": "string",
+            "example": "Lighthouse at dusk from the northern cape"
+          },
+          "altText": {
+            "type": "string",
+            "example": "A white lighthouse against a purple sky"
+          },
+          "tags": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "beach",
+              "sunset"
+            ]
+          },
+          "mentions": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Mention"
+            }
+          },
+          "likeCount": {
+            "type": "integer",
+            "description": "number of users liking the image",
+            "example": 3
+          },
+          "rating": {
+            "type": "number",
+            "description": "average of the 1 to 5 star ratings of the image rounded to 2 decimals, 0 if unrated",
+            "example": 4.5
+          },
+          "ratingCount": {
+            "type": "integer",
+            "description": "number of users who rated the image",
+            "example": 2
+          },
+          "viewCount": {
+            "type": "integer",
+            "description": "number of times the image was viewed, recorded periodically",
+            "example": 120
+          },
+          "downloadCount": {
+            "type": "integer",
+            "description": "number of times the image was downloaded, recorded periodically",
+            "example": 8
+          },
+          "hash": {
+            "type": "string",
+            "description": "hex sha256 of the image content"
+          },
+          "publicRef": {
+            "type": "string",
+            "description": "versioned public url of shareable images, may be cached indefinitely",
+            "example": "https://pictocache.jacobyjoukema.com/public/image/1/1.png?v=9f86d081884c7d65"
+          },
+          "uploaded": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "taken": {
+            "type": "string",
+            "format": "date-time",
+            "description": "EXIF taken date of JPEG images, the upload date if the image has none"
+          }
+        }
+      },
+      "CreateImage": {
+        "type": "object",
+        "required": [
+          "image"
+        ],
+        "properties": {
+          "title": {
+            "type": "string",
+            "example": "photo.png",
+            "description": "at most 255 characters without path separators, .. or null bytes, control characters are removed"
+          },
+          "description": {
+            "type": "string",
+            "example": "Lighthouse at dusk from the northern cape",
+            "description": "at most 2000 characters over any number of lines, searched by the description query parameter"
+          },
+          "altText": {
+            "type": "string",
+            "example": "A white lighthouse against a purple sky",
+            "description": "alternative text read by screen readers, a single line of at most 500 characters"
+          },
+          "shareable": {
+            "type": "string",
+            "example": "true"
+          },
+          "tags": {
+            "type": "string",
+            "example": "beach,sunset"
+          },
+          "dedup": {
+            "type": "string",
+            "enum": [
+              "store",
+              "prompt",
+              "link"
+            ],
+            "description": "handling of content identical to another member's image, defaults to ORG_DEDUP. prompt responds 409, link references the existing file"
+          },
+          "image": {
+            "type": "string",
+            "format": "base64"
+          }
+        }
+      },
+      "CreateBatch": {
+        "type": "object",
+        "required": [
+          "images"
+        ],
+        "properties": {
+          "shareable": {
+            "type": "string",
+            "example": "true"
+          },
+          "dedup": {
+            "type": "string",
+            "enum": [
+              "store",
+              "prompt",
+              "link"
+            ],
+            "description": "handling of content identical to another member's image, defaults to ORG_DEDUP. prompt responds 409, link references the existing file"
+          },
+          "tags": {
+            "type": "string",
+            "example": "beach,sunset"
+          },
+          "images": {
+            "type": "array",
+            "items": {
+              "type": "string",
+              "format": "binary"
+            }
+          }
+        }
+      },
+      "BatchResult": {
+        "type": "object",
+        "properties": {
+          "index": {
+            "type": "integer",
+            "example": 0
+          },
+          "filename": {
+            "type": "string",
+            "example": "photo.png"
+          },
+          "status": {
+            "type": "integer",
+            "example": 200
+          },
+          "image": {
+            "$ref": "#/components/schemas/Image"
+          },
+          "error": {
+            "type": "string",
+            "example": "400 - Unsupported image type image/tiff, accepted types are image/jpeg, image/png, image/webp, image/gif"
+          }
+        }
+      },
+      "ImageStats": {
+        "type": "object",
+        "properties": {
+          "imageId": {
+            "type": "integer",
+            "example": 42
+          },
+          "views": {
+            "type": "integer",
+            "example": 120
+          },
+          "downloads": {
+            "type": "integer",
+            "example": 8
+          },
+          "days": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "date": {
+                  "type": "string",
+                  "format": "date",
+                  "example": "2026-10-16"
+                },
+                "views": {
+                  "type": "integer",
+                  "example": 12
+                },
+                "downloads": {
+                  "type": "integer",
+                  "example": 1
+                }
+              }
+            }
+          }
+        }
+      },
+      "Rating": {
+        "type": "object",
+        "properties": {
+          "imageId": {
+            "type": "integer",
+            "example": 42
+          },
+          "stars": {
+            "type": "integer",
+            "description": "rating of the user",
+            "example": 4
+          },
+          "rating": {
+            "type": "number",
+            "description": "average rating of the image rounded to 2 decimals",
+            "example": 4.33
+          },
+          "ratingCount": {
+            "type": "integer",
+            "description": "number of users who rated the image",
+            "example": 3
+          }
+        }
+      },
+      "Comment": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "imageId": {
+            "type": "integer"
+          },
+          "uid": {
+            "type": "integer",
+            "description": "author of the comment"
+          },
+          "username": {
+            "type": "string",
+            "description": "username of the author, empty if they haven't chosen one"
+          },
+          "body": {
+            "type": "string",
+            "example": "Great light in this one"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "edited": {
+            "type": "string",
+            "format": "date-time",
+            "description": "omitted unless the author edited the comment"
+          }
+        }
+      },
+      "CommentPage": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer"
+          },
+          "pageSize": {
+            "type": "integer"
+          },
+          "totalResults": {
+            "type": "integer"
+          },
+          "comments": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Comment"
+            }
+          },
+          "prev": {
+            "type": "string",
+            "description": "previous page, omitted on the first page"
+          },
+          "next": {
+            "type": "string",
+            "description": "next page, omitted on the last page"
+          }
+        }
+      },
+      "Like": {
+        "type": "object",
+        "properties": {
+          "imageId": {
+            "type": "integer",
+            "example": 42
+          },
+          "liked": {
+            "type": "boolean",
+            "description": "whether the user likes the image"
+          },
+          "likeCount": {
+            "type": "integer",
+            "description": "number of users liking the image",
+            "example": 3
+          }
+        }
+      },
+      "BulkResult": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer",
+            "example": 42
+          },
+          "status": {
+            "type": "integer",
+            "example": 200
+          },
+          "image": {
+            "$ref": "#/components/schemas/Image",
+            "description": "the updated image, only set by bulk updates"
+          },
+          "error": {
+            "type": "string",
+            "example": "404 - Not found, no image with that id"
+          }
+        }
+      },
+      "AnnouncementParams": {
+        "type": "object",
+        "required": [
+          "title"
+        ],
+        "properties": {
+          "title": {
+            "type": "string",
+            "example": "Scheduled maintenance",
+            "description": "at most 200 characters"
+          },
+          "message": {
+            "type": "string",
+            "example": "Uploads are paused from 02:00 to 03:00 UTC",
+            "description": "at most 2000 characters"
+          },
+          "severity": {
+            "type": "string",
+            "enum": [
+              "info",
+              "warning",
+              "critical"
+            ],
+            "default": "info"
+          },
+          "starts": {
+            "type": "string",
+            "format": "date-time",
+            "description": "defaults to now"
+          },
+          "ends": {
+            "type": "string",
+            "format": "date-time",
+            "description": "omit to show the announcement until it is deleted"
+          }
+        }
+      },
+      "Announcement": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "title": {
+            "type": "string"
+          },
+          "message": {
+            "type": "string"
+          },
+          "severity": {
+            "type": "string",
+            "enum": [
+              "info",
+              "warning",
+              "critical"
+            ]
+          },
+          "starts": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "ends": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "active": {
+            "type": "boolean"
+          }
+        }
+      },
+      "JWKSet": {
+        "type": "object",
+        "properties": {
+          "keys": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "kty": {
+                  "type": "string",
+                  "enum": [
+                    "RSA",
+                    "EC"
+                  ]
+                },
+                "kid": {
+                  "type": "string"
+                },
+                "use": {
+                  "type": "string",
+                  "example": "sig"
+                },
+                "alg": {
+                  "type": "string",
+                  "enum": [
+                    "RS256",
+                    "ES256"
+                  ]
+                },
+                "n": {
+                  "type": "string"
+                },
+                "e": {
+                  "type": "string"
+                },
+                "crv": {
+                  "type": "string",
+                  "example": "P-256"
+                },
+                "x": {
+                  "type": "string"
+                },
+                "y": {
+                  "type": "string"
+                }
+              }
+            }
+          }
+        }
+      },
+      "Keyring": {
+        "type": "object",
+        "properties": {
+          "current": {
+            "type": "string",
+            "example": "2026-10"
+          },
+          "keys": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "2026-10",
+              "2026-04"
+            ]
+          }
+        }
+      },
+      "BundleManifest": {
+        "type": "object",
+        "description": "manifest.json of zip bundles",
+        "properties": {
+          "version": {
+            "type": "integer",
+            "example": 1
+          },
+          "uid": {
+            "type": "integer"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "files": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "path": {
+                  "type": "string",
+                  "example": "images/12-beach.png"
+                },
+                "size": {
+                  "type": "integer"
+                },
+                "sha256": {
+                  "type": "string",
+                  "description": "hex digest of the file in the bundle"
+                },
+                "image": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              }
+            }
+          },
+          "missing": {
+            "type": "array",
+            "description": "images whose file is missing from storage",
+            "items": {
+              "$ref": "#/components/schemas/Image"
+            }
+          },
+          "bytes": {
+            "type": "integer",
+            "description": "total size of the files"
+          }
+        }
+      },
+      "IntakeUpload": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer",
+            "example": 42
+          },
+          "filename": {
+            "type": "string",
+            "example": "photo.png"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "queued",
+              "processing",
+              "complete",
+              "failed"
+            ]
+          },
+          "statusUrl": {
+            "type": "string",
+            "example": "/image/intake/42"
+          },
+          "code": {
+            "type": "integer",
+            "description": "status the upload would have been answered with once finished",
+            "example": 200
+          },
+          "error": {
+            "type": "string",
+            "example": "400 - Unsupported image type image/tiff, accepted types are image/jpeg, image/png, image/webp, image/gif"
+          },
+          "imageId": {
+            "type": "integer",
+            "description": "image the file was saved as",
+            "example": 7
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "completed": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "UpdateImage": {
+        "type": "object",
+        "properties": {
+          "title": {
+            "type": "string",
+            "example": "photo.png",
+            "description": "at most 255 characters without path separators, .. or null bytes, control characters are removed"
+          },
+          "description": {
+            "type": "string",
+            "example": "Lighthouse at dusk from the northern cape",
+            "description": "at most 2000 characters over any number of lines, searched by the description query parameter"
+          },
+          "altText": {
+            "type": "string",
+            "example": "A white lighthouse against a purple sky",
+            "description": "alternative text read by screen readers, a single line of at most 500 characters"
+          },
+          "shareable": {
+            "type": "string",
+            "example": "true"
+          },
+          "tags": {
+            "type": "string",
+            "example": "beach,sunset",
+            "description": "replaces the existing tags, an empty string removes all tags"
+          }
+        }
+      },
+      "RegisterReq": {
+        "type": "object",
+        "required": [
+          "firstname",
+          "lastname",
+          "email",
+          "password"
+        ],
+        "properties": {
+          "firstname": {
+            "type": "string",
+            "example": "Jacoby"
+          },
+          "lastname": {
+            "type": "string",
+            "example": "Joukema"
+          },
+          "email": {
+            "type": "string",
+            "example": "jacoby.joukema@gmail.com"
+          },
+          "password": {
+            "type": "string",
+            "example": "testpass"
+          }
+        }
+      },
+      "TokenResp": {
+        "type": "object",
+        "required": [
+          "name",
+          "token",
+          "expiration"
+        ],
+        "properties": {
+          "name": {
+            "type": "string",
+            "example": "token"
+          },
+          "token": {
+            "type": "string",
+            "example": "abc123"
+          },
+          "expiration": {
+            "type": "string",
+            "format": "date-time",
+            "description": "RFC 3339 UTC timestamp, Go time.String in the server time zone when TIMESTAMP_FORMAT is legacy",
+            "example": "2021-09-20T09:04:28Z"
+          }
+        }
+      },
+      "UploadPolicyReq": {
+        "type": "object",
+        "properties": {
+          "maxBytes": {
+            "type": "integer",
+            "example": 1048576
+          },
+          "types": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "image/png"
+            ]
+          }
+        }
+      },
+      "UploadPolicyResp": {
+        "type": "object",
+        "properties": {
+          "url": {
+            "type": "string",
+            "example": "localhost:8000/image/upload?policy=abc123"
+          },
+          "policy": {
+            "type": "string",
+            "example": "abc123"
+          },
+          "maxBytes": {
+            "type": "integer",
+            "example": 1048576
+          },
+          "types": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "image/png"
+            ]
+          },
+          "expiration": {
+            "type": "string",
+            "format": "date-time",
+            "description": "RFC 3339 UTC timestamp, Go time.String in the server time zone when TIMESTAMP_FORMAT is legacy",
+            "example": "2021-09-20T09:04:28Z"
+          }
+        }
+      },
+      "Mention": {
+        "type": "object",
+        "description": "a user mentioned in the description, offset and length count unicode code points including the @",
+        "properties": {
+          "uid": {
+            "type": "integer",
+            "example": 2
+          },
+          "username": {
+            "type": "string",
+            "example": "jane_doe"
+          },
+          "offset": {
+            "type": "integer",
+            "example": 12
+          },
+          "length": {
+            "type": "integer",
+            "example": 9
+          }
+        }
+      },
+      "Notifications": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer",
+            "example": 0
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 50
+          },
+          "totalResults": {
+            "type": "integer",
+            "example": 1
+          },
+          "unread": {
+            "type": "integer",
+            "example": 1
+          },
+          "notifications": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "id": {
+                  "type": "integer",
+                  "example": 4
+                },
+                "type": {
+                  "type": "string",
+                  "example": "mention"
+                },
+                "actorUid": {
+                  "type": "integer",
+                  "example": 1
+                },
+                "imageId": {
+                  "type": "integer",
+                  "example": 6
+                },
+                "read": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "created": {
+                  "type": "string",
+                  "format": "date-time"
+                }
+              }
+            }
+          }
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "uid": {
+            "type": "integer",
+            "example": 1
+          },
+          "firstname": {
+            "type": "string",
+            "example": "Jane"
+          },
+          "lastname": {
+            "type": "string",
+            "example": "Doe"
+          },
+          "email": {
+            "type": "string",
+            "example": "jane@mail.com"
+          },
+          "username": {
+            "type": "string",
+            "example": "jane_doe"
+          }
+        }
+      },
+      "UpdateUser": {
+        "type": "object",
+        "properties": {
+          "firstname": {
+            "type": "string",
+            "example": "Jane"
+          },
+          "lastname": {
+            "type": "string",
+            "example": "Doe"
+          },
+          "email": {
+            "type": "string",
+            "example": "jane@mail.com"
+          },
+          "username": {
+            "description": "3 to 30 letters, digits or underscores unique ignoring case, empty to clear",
+            "type": "string",
+            "example": "jane_doe"
+          }
+        }
+      },
+      "ChangePassword": {
+        "type": "object",
+        "required": [
+          "currentPassword",
+          "newPassword"
+        ],
+        "properties": {
+          "currentPassword": {
+            "type": "string"
+          },
+          "newPassword": {
+            "type": "string"
+          }
+        }
+      },
+      "SharingPolicy": {
+        "type": "object",
+        "properties": {
+          "defaultShareable": {
+            "type": "boolean",
+            "description": "shareable value of uploads that don't specify one"
+          },
+          "allowPublic": {
+            "type": "boolean",
+            "description": "images may be marked shareable"
+          },
+          "watermark": {
+            "type": "boolean",
+            "description": "public images are served with a watermark"
+          }
+        }
+      },
+      "IngestRules": {
+        "type": "object",
+        "properties": {
+          "types": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "description": "image types that may be uploaded, empty allows every type the instance accepts"
+          },
+          "stripExif": {
+            "type": "boolean",
+            "description": "metadata is removed from uploads before they are stored"
+          },
+          "requireScan": {
+            "type": "boolean",
+            "description": "uploads are refused unless the malware scanner finds them clean"
+          }
+        }
+      },
+      "IngestPolicy": {
+        "allOf": [
+          {
+            "$ref": "#/components/schemas/IngestRules"
+          },
+          {
+            "type": "object",
+            "properties": {
+              "enforced": {
+                "$ref": "#/components/schemas/IngestRules"
+              }
+            }
+          }
+        ]
+      },
+      "PurgeReport": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "uid": {
+            "type": "integer",
+            "description": "purged user"
+          },
+          "mode": {
+            "type": "string",
+            "enum": [
+              "delete",
+              "anonymize"
+            ]
+          },
+          "reason": {
+            "type": "string"
+          },
+          "requestedBy": {
+            "type": "integer",
+            "description": "administrator who requested the purge"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "pending",
+              "running",
+              "complete"
+            ]
+          },
+          "images": {
+            "type": "integer",
+            "description": "images deleted so far"
+          },
+          "bytes": {
+            "type": "integer",
+            "description": "size of the deleted images"
+          },
+          "error": {
+            "type": "string",
+            "description": "last failure, the purge is retried until it completes"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "completed": {
+            "type": "string",
+            "format": "date-time",
+            "description": "only present once the purge is complete"
+          }
+        }
+      },
+      "StorageDriver": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string",
+            "example": "s3"
+          },
+          "role": {
+            "type": "string",
+            "enum": [
+              "primary",
+              "mirror"
+            ]
+          },
+          "healthy": {
+            "type": "boolean"
+          },
+          "error": {
+            "type": "string"
+          },
+          "latencyMs": {
+            "type": "integer",
+            "example": 42
+          },
+          "objects": {
+            "type": "integer",
+            "description": "omitted when the driver can't list its objects",
+            "example": 18342
+          },
+          "bytes": {
+            "type": "integer",
+            "example": 9823748123
+          }
+        }
+      },
+      "StorageCheckReport": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "uid": {
+            "type": "integer",
+            "description": "user checked, omitted for prefix checks"
+          },
+          "prefix": {
+            "type": "string",
+            "example": "12/"
+          },
+          "requestedBy": {
+            "type": "integer"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "pending",
+              "running",
+              "complete"
+            ]
+          },
+          "objects": {
+            "type": "integer"
+          },
+          "bytes": {
+            "type": "integer"
+          },
+          "orphans": {
+            "type": "integer"
+          },
+          "orphanBytes": {
+            "type": "integer"
+          },
+          "missing": {
+            "type": "integer"
+          },
+          "error": {
+            "type": "string",
+            "description": "last failure, the check is retried"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "completed": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "StorageFindings": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer"
+          },
+          "pageSize": {
+            "type": "integer"
+          },
+          "totalResults": {
+            "type": "integer"
+          },
+          "findings": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "kind": {
+                  "type": "string",
+                  "enum": [
+                    "orphan",
+                    "missing"
+                  ]
+                },
+                "key": {
+                  "type": "string",
+                  "example": "12/thumb/88.png"
+                },
+                "size": {
+                  "type": "integer",
+                  "description": "size of orphans"
+                },
+                "imageId": {
+                  "type": "integer",
+                  "description": "image of missing files, 0 for blobs"
+                },
+                "modified": {
+                  "type": "string",
+                  "format": "date-time",
+                  "description": "last modification of orphans"
+                }
+              }
+            }
+          }
+        }
+      },
+      "ReencodeReport": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "formats": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "image/avif",
+              "image/webp"
+            ]
+          },
+          "limit": {
+            "type": "integer",
+            "description": "images examined before the campaign stops, omitted for the whole library"
+          },
+          "requestedBy": {
+            "type": "integer",
+            "description": "administrator who started the campaign"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "pending",
+              "running",
+              "complete"
+            ]
+          },
+          "images": {
+            "type": "integer",
+            "description": "images examined so far"
+          },
+          "files": {
+            "type": "integer",
+            "description": "files stored in the requested encodings"
+          },
+          "fileBytes": {
+            "type": "integer",
+            "description": "size of the stored files"
+          },
+          "originalBytes": {
+            "type": "integer",
+            "description": "size of the images a smaller file was stored for"
+          },
+          "encodedBytes": {
+            "type": "integer",
+            "description": "size of the smallest file stored for each of those images"
+          },
+          "spaceSaved": {
+            "type": "integer",
+            "description": "bytes saved if those images were kept only in their smallest encoding"
+          },
+          "bandwidthSaved": {
+            "type": "integer",
+            "description": "bytes the smallest files would have saved over the views recorded for the images"
+          },
+          "byFormat": {
+            "type": "array",
+            "description": "files stored in each encoding, only listed by the report endpoint",
+            "items": {
+              "type": "object",
+              "properties": {
+                "encoding": {
+                  "type": "string"
+                },
+                "files": {
+                  "type": "integer"
+                },
+                "bytes": {
+                  "type": "integer"
+                }
+              }
+            }
+          },
+          "error": {
+            "type": "string",
+            "description": "last failure, the campaign is retried until it completes"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "completed": {
+            "type": "string",
+            "format": "date-time",
+            "description": "only present once the campaign is complete"
+          }
+        }
+      },
+      "ReadyResp": {
+        "type": "object",
+        "properties": {
+          "status": {
+            "type": "string",
+            "enum": [
+              "ready",
+              "unavailable"
+            ]
+          },
+          "checks": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "string",
+              "enum": [
+                "ok",
+                "failed"
+              ]
+            },
+            "example": {
+              "database": "ok",
+              "storage": "ok"
+            }
+          }
+        }
+      },
+      "FaultRules": {
+        "type": "object",
+        "properties": {
+          "database": {
+            "$ref": "#/components/schemas/FaultRule"
+          },
+          "storage": {
+            "$ref": "#/components/schemas/FaultRule"
+          }
+        }
+      },
+      "FaultRule": {
+        "type": "object",
+        "properties": {
+          "errorPercent": {
+            "type": "integer",
+            "minimum": 0,
+            "maximum": 100,
+            "description": "percentage of calls failed"
+          },
+          "latencyMs": {
+            "type": "integer",
+            "minimum": 0,
+            "maximum": 30000,
+            "description": "latency added to delayed calls"
+          },
+          "latencyPercent": {
+            "type": "integer",
+            "minimum": 0,
+            "maximum": 100,
+            "description": "percentage of calls delayed"
+          }
+        }
+      },
+      "QuotaResp": {
+        "type": "object",
+        "properties": {
+          "quota": {
+            "type": "integer",
+            "example": 1073741824
+          },
+          "used": {
+            "type": "integer",
+            "example": 52428800
+          },
+          "available": {
+            "type": "integer",
+            "example": 1021313024
+          }
+        }
+      },
+      "LibraryEvent": {
+        "type": "object",
+        "properties": {
+          "type": {
+            "type": "string",
+            "enum": [
+              "image.added",
+              "image.updated",
+              "image.shared",
+              "image.unshared",
+              "image.deleted",
+              "image.restored"
+            ]
+          },
+          "imageId": {
+            "type": "integer",
+            "example": 42
+          },
+          "owner": {
+            "type": "integer",
+            "description": "uid of the image owner",
+            "example": 7
+          },
+          "time": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "StorageEstimate": {
+        "type": "object",
+        "properties": {
+          "users": {
+            "type": "integer",
+            "description": "users the usage was summed over, only reported for the organisation",
+            "example": 42
+          },
+          "images": {
+            "type": "integer",
+            "example": 1280
+          },
+          "storedBytes": {
+            "type": "integer",
+            "example": 52428800
+          },
+          "servedBytes": {
+            "type": "integer",
+            "description": "bytes projected to be served in a month",
+            "example": 314572800
+          },
+          "prices": {
+            "type": "object",
+            "properties": {
+              "currency": {
+                "type": "string",
+                "example": "USD"
+              },
+              "storagePerGb": {
+                "type": "number",
+                "example": 0.023
+              },
+              "bandwidthPerGb": {
+                "type": "number",
+                "example": 0.09
+              }
+            }
+          },
+          "storageCost": {
+            "type": "number",
+            "example": 0
+          },
+          "bandwidthCost": {
+            "type": "number",
+            "example": 0.03
+          },
+          "total": {
+            "type": "number",
+            "example": 0.03
+          }
+        }
+      },
+      "ImportUser": {
+        "type": "object",
+        "required": [
+          "email",
+          "firstname",
+          "lastname"
+        ],
+        "properties": {
+          "email": {
+            "type": "string",
+            "example": "jane@mail.com"
+          },
+          "firstname": {
+            "type": "string",
+            "example": "Jane"
+          },
+          "lastname": {
+            "type": "string",
+            "example": "Doe"
+          },
+          "password": {
+            "type": "string"
+          },
+          "hashedPass": {
+            "type": "string",
+            "description": "bcrypt hash used instead of password"
+          }
+        }
+      },
+      "ImportResp": {
+        "type": "object",
+        "properties": {
+          "dryRun": {
+            "type": "boolean"
+          },
+          "imported": {
+            "type": "integer",
+            "example": 1
+          },
+          "failed": {
+            "type": "integer",
+            "example": 1
+          },
+          "results": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "row": {
+                  "type": "integer",
+                  "example": 2
+                },
+                "email": {
+                  "type": "string",
+                  "example": "jane@mail.com"
+                },
+                "status": {
+                  "type": "integer",
+                  "example": 409
+                },
+                "uid": {
+                  "type": "integer"
+                },
+                "error": {
+                  "type": "string",
+                  "example": "409 - Email is already registered"
+                }
+              }
+            }
+          }
+        }
+      },
+      "UserBlock": {
+        "type": "object",
+        "properties": {
+          "uid": {
+            "type": "integer",
+            "example": 9
+          },
+          "email": {
+            "type": "string",
+            "example": "someone@mail.com"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "ImageShare": {
+        "type": "object",
+        "properties": {
+          "uid": {
+            "type": "integer",
+            "example": 4
+          },
+          "email": {
+            "type": "string",
+            "example": "friend@mail.com"
+          },
+          "firstname": {
+            "type": "string"
+          },
+          "lastname": {
+            "type": "string"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "ModerationPolicy": {
+        "type": "object",
+        "properties": {
+          "copyright": {
+            "type": "integer",
+            "example": 3
+          },
+          "nsfw": {
+            "type": "integer",
+            "example": 3
+          },
+          "spam": {
+            "type": "integer",
+            "example": 5
+          }
+        }
+      },
+      "ModerationCase": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "imageId": {
+            "type": "integer"
+          },
+          "uid": {
+            "type": "integer",
+            "description": "owner of the image"
+          },
+          "category": {
+            "type": "string",
+            "enum": [
+              "copyright",
+              "nsfw",
+              "spam"
+            ]
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "open",
+              "unshared",
+              "dismissed",
+              "upheld"
+            ]
+          },
+          "reports": {
+            "type": "integer"
+          },
+          "unshared": {
+            "type": "boolean",
+            "description": "the image can't be shared while set"
+          },
+          "appealed": {
+            "type": "boolean"
+          },
+          "appeal": {
+            "type": "string"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "updated": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Trash": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer"
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 50
+          },
+          "totalResults": {
+            "type": "integer"
+          },
+          "images": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "image": {
+                  "$ref": "#/components/schemas/Image"
+                },
+                "deleted": {
+                  "type": "string",
+                  "format": "date-time"
+                },
+                "expires": {
+                  "type": "string",
+                  "format": "date-time",
+                  "description": "moment the image is permanently purged"
+                }
+              }
+            }
+          }
+        }
+      },
+      "Exif": {
+        "type": "object",
+        "properties": {
+          "imageId": {
+            "type": "integer"
+          },
+          "byteOrder": {
+            "type": "string",
+            "enum": [
+              "little-endian",
+              "big-endian"
+            ]
+          },
+          "directories": {
+            "type": "object",
+            "description": "entries of each directory found such as IFD0, Exif, GPS, Interop and IFD1",
+            "additionalProperties": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "properties": {
+                  "tag": {
+                    "type": "string",
+                    "example": "0x010F"
+                  },
+                  "name": {
+                    "type": "string",
+                    "example": "Make"
+                  },
+                  "type": {
+                    "type": "string",
+                    "example": "ASCII"
+                  },
+                  "count": {
+                    "type": "integer"
+                  },
+                  "value": {
+                    "description": "a number, a list of values, a [numerator, denominator] rational, text or hex encoded bytes of UNDEFINED entries"
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "Activity": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer"
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 50
+          },
+          "totalResults": {
+            "type": "integer"
+          },
+          "activity": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "action": {
+                  "type": "string",
+                  "enum": [
+                    "register",
+                    "login",
+                    "upload",
+                    "update",
+                    "delete",
+                    "restore",
+                    "share",
+                    "unshare",
+                    "guest_upload"
+                  ]
+                },
+                "objectId": {
+                  "type": "integer",
+                  "description": "image affected by the action, omitted for account actions"
+                },
+                "ip": {
+                  "type": "string"
+                },
+                "time": {
+                  "type": "string",
+                  "format": "date-time"
+                }
+              }
+            }
+          }
+        }
+      },
+      "Comparison": {
+        "type": "object",
+        "properties": {
+          "a": {
+            "type": "integer"
+          },
+          "b": {
+            "type": "integer"
+          },
+          "identical": {
+            "type": "boolean",
+            "description": "the files have the same content"
+          },
+          "sameSize": {
+            "type": "boolean"
+          },
+          "width": {
+            "type": "integer",
+            "description": "width the images were compared at"
+          },
+          "height": {
+            "type": "integer"
+          },
+          "ssim": {
+            "type": "number",
+            "description": "structural similarity from -1 to 1, 1 when the pixels are equal"
+          },
+          "mse": {
+            "type": "number"
+          },
+          "psnr": {
+            "type": "number",
+            "description": "peak signal to noise ratio in dB, omitted when the pixels are equal"
+          },
+          "changedPixels": {
+            "type": "integer"
+          },
+          "changedRatio": {
+            "type": "number"
+          },
+          "perceptualDistance": {
+            "type": "integer",
+            "description": "bits the perceptual hashes differ by, omitted unless both are recorded"
+          }
+        }
+      },
+      "Duplicates": {
+        "type": "object",
+        "properties": {
+          "page": {
+            "type": "integer"
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 50
+          },
+          "totalResults": {
+            "type": "integer"
+          },
+          "groups": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "exact": {
+                  "type": "boolean",
+                  "description": "every image of the group has identical content"
+                },
+                "images": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Image"
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "Album": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer",
+            "example": 3
+          },
+          "title": {
+            "type": "string",
+            "example": "Holiday"
+          },
+          "description": {
+            "type": "string"
+          },
+          "shareable": {
+            "type": "boolean",
+            "description": "every image of a shareable album is served publicly"
+          },
+          "imageCount": {
+            "type": "integer",
+            "example": 24
+          },
+          "imageIds": {
+            "type": "array",
+            "items": {
+              "type": "integer"
+            },
+            "example": [
+              12,
+              14
+            ],
+            "description": "ids of the images in the order chosen by the owner"
+          },
+          "coverId": {
+            "type": "integer",
+            "example": 14,
+            "description": "the image chosen as cover or the first image, 0 if the album is empty"
+          },
+          "cover": {
+            "nullable": true,
+            "allOf": [
+              {
+                "$ref": "#/components/schemas/Image"
+              }
+            ]
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "updated": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "AlbumDetail": {
+        "allOf": [
+          {
+            "$ref": "#/components/schemas/Album"
+          },
+          {
+            "type": "object",
+            "properties": {
+              "images": {
+                "type": "array",
+                "description": "images of the album in order",
+                "items": {
+                  "$ref": "#/components/schemas/Image"
+                }
+              }
+            }
+          }
+        ]
+      },
+      "AlbumParams": {
+        "type": "object",
+        "properties": {
+          "title": {
+            "type": "string",
+            "example": "Holiday",
+            "description": "required when creating an album"
+          },
+          "description": {
+            "type": "string"
+          },
+          "shareable": {
+            "type": "boolean"
+          }
+        }
+      },
+      "GuestLinkParams": {
+        "type": "object",
+        "properties": {
+          "label": {
+            "type": "string",
+            "example": "Wedding guests",
+            "description": "at most 100 characters"
+          },
+          "maxUploads": {
+            "type": "integer",
+            "example": 50,
+            "description": "uploads allowed, 1 to 1000, defaults to 50"
+          },
+          "maxBytes": {
+            "type": "integer",
+            "description": "largest file accepted, defaults to and may not exceed the server upload limit"
+          },
+          "expiresIn": {
+            "type": "integer",
+            "example": 72,
+            "description": "hours the link is valid, 1 to 720, defaults to 72"
+          }
+        }
+      },
+      "TotpRequired": {
+        "type": "object",
+        "properties": {
+          "totpRequired": {
+            "type": "boolean",
+            "example": true
+          },
+          "challenge": {
+            "type": "string",
+            "description": "completes the sign in at /auth/totp within 5 minutes"
+          }
+        }
+      },
+      "PasswordPolicyResp": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "string",
+            "example": "400 - Password does not meet the password policy"
+          },
+          "failed": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "rule": {
+                  "type": "string",
+                  "enum": [
+                    "minLength",
+                    "entropy",
+                    "denylist",
+                    "breached",
+                    "email"
+                  ]
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Password must be at least 8 characters"
+                }
+              }
+            }
+          }
+        }
+      },
+      "ApiKey": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "name": {
+            "type": "string"
+          },
+          "prefix": {
+            "type": "string",
+            "description": "first characters of the key identifying it",
+            "example": "pk_3f9a21c0"
+          },
+          "key": {
+            "type": "string",
+            "description": "only returned when the key is created"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "lastUsed": {
+            "type": "string",
+            "format": "date-time",
+            "description": "omitted until the key is used, updated at most every 5 minutes"
+          }
+        }
+      },
+      "Webhook": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "url": {
+            "type": "string",
+            "example": "https://hooks.example.com/picto"
+          },
+          "events": {
+            "type": "array",
+            "description": "subscribed event types, empty for every event",
+            "items": {
+              "type": "string"
+            }
+          },
+          "secret": {
+            "type": "string",
+            "description": "only returned when the webhook is created",
+            "example": "whsec_5d0c1e..."
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "lastStatus": {
+            "type": "integer",
+            "description": "status of the last delivery attempt, omitted before the first attempt and when the callback couldn't be reached",
+            "example": 200
+          },
+          "lastAttempt": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "WebhookEvent": {
+        "type": "object",
+        "description": "body posted to webhooks",
+        "properties": {
+          "delivery": {
+            "type": "integer",
+            "description": "id shared by repeated deliveries of the event"
+          },
+          "type": {
+            "type": "string",
+            "enum": [
+              "image.added",
+              "image.updated",
+              "image.shared",
+              "image.unshared",
+              "image.deleted",
+              "image.restored"
+            ]
+          },
+          "imageId": {
+            "type": "integer"
+          },
+          "owner": {
+            "type": "integer"
+          },
+          "time": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "GuestLink": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "albumId": {
+            "type": "integer"
+          },
+          "label": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string",
+            "description": "only returned when the link is created"
+          },
+          "token": {
+            "type": "string",
+            "description": "only returned when the link is created"
+          },
+          "maxUploads": {
+            "type": "integer"
+          },
+          "uploads": {
+            "type": "integer"
+          },
+          "maxBytes": {
+            "type": "integer"
+          },
+          "active": {
+            "type": "boolean",
+            "description": "false once the link expired or its uploads are used up"
+          },
+          "expires": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "DropBox": {
+        "type": "object",
+        "properties": {
+          "album": {
+            "type": "string",
+            "description": "title of the album"
+          },
+          "label": {
+            "type": "string"
+          },
+          "remaining": {
+            "type": "integer",
+            "description": "uploads remaining"
+          },
+          "maxBytes": {
+            "type": "integer"
+          },
+          "types": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "image/png",
+              "image/jpeg"
+            ]
+          },
+          "expires": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "GuestUpload": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer"
+          },
+          "title": {
+            "type": "string"
+          },
+          "size": {
+            "type": "integer"
+          },
+          "encoding": {
+            "type": "string"
+          },
+          "remaining": {
+            "type": "integer",
+            "description": "uploads remaining"
+          }
+        }
+      },
+      "Timeline": {
+        "type": "object",
+        "properties": {
+          "group": {
+            "type": "string",
+            "example": "day"
+          },
+          "page": {
+            "type": "integer",
+            "example": 0
+          },
+          "pageSize": {
+            "type": "integer",
+            "example": 30
+          },
+          "more": {
+            "type": "boolean",
+            "description": "whether older buckets follow this page"
+          },
+          "buckets": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "date": {
+                  "type": "string",
+                  "example": "2019-07-14",
+                  "description": "YYYY-MM-DD for days, YYYY-MM for months"
+                },
+                "count": {
+                  "type": "integer",
+                  "example": 12
+                },
+                "thumbnails": {
+                  "type": "array",
+                  "items": {
+                    "type": "object",
+                    "properties": {
+                      "imageId": {
+                        "type": "integer",
+                        "example": 6
+                      },
+                      "url": {
+                        "type": "string",
+                        "example": "localhost:8000/image/1/6.jpeg?w=256\u0026h=256"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "OAuthClient": {
+        "type": "object",
+        "properties": {
+          "clientId": {
+            "type": "string",
+            "example": "4f1c2b9e8a7d6c5b4a3f2e1d"
+          },
+          "clientSecret": {
+            "type": "string",
+            "description": "Only included when the client is registered"
+          },
+          "name": {
+            "type": "string",
+            "example": "Photo Printer"
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "OAuthConsent": {
+        "type": "object",
+        "properties": {
+          "clientId": {
+            "type": "string"
+          },
+          "clientName": {
+            "type": "string"
+          },
+          "scopes": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "name": {
+                  "type": "string",
+                  "example": "images:read"
+                },
+                "description": {
+                  "type": "string",
+                  "example": "View your images and their details"
+                }
+              }
+            }
+          },
+          "granted": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          }
+        }
+      },
+      "OAuthGrant": {
+        "type": "object",
+        "properties": {
+          "clientId": {
+            "type": "string"
+          },
+          "clientName": {
+            "type": "string"
+          },
+          "scopes": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "example": [
+              "images:read"
+            ]
+          },
+          "created": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "OAuthToken": {
+        "type": "object",
+        "properties": {
+          "access_token": {
+            "type": "string"
+          },
+          "token_type": {
+            "type": "string",
+            "example": "Bearer"
+          },
+          "expires_in": {
+            "type": "integer",
+            "example": 3600
+          },
+          "scope": {
+            "type": "string",
+            "example": "images:read"
+          }
+        }
+      },
+      "Changelog": {
+        "type": "object",
+        "properties": {
+          "changes": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "date": {
+                  "type": "string",
+                  "format": "date"
+                },
+                "type": {
+                  "type": "string",
+                  "enum": [
+                    "added",
+                    "changed",
+                    "deprecated",
+                    "removed"
+                  ]
+                },
+                "method": {
+                  "type": "string",
+                  "example": "GET"
+                },
+                "path": {
+                  "type": "string",
+                  "example": "/image/{id}/exif.json"
+                },
+                "description": {
+                  "type": "string"
+                },
+                "sunset": {
+                  "type": "string",
+                  "format": "date-time",
+                  "description": "moment a deprecated endpoint stops being served"
+                },
+                "link": {
+                  "type": "string",
+                  "description": "migration notes of a deprecation"
+                },
+                "successor": {
+                  "type": "string",
+                  "description": "endpoint replacing a deprecated one"
+                }
+              }
+            }
+          }
+        }
+      },
+      "Error": {
+        "type": "string",
+        "description": "error responses are plain text starting with the status code",
+        "example": "404 - Not found, no image with that information available"
+      },
+      "PingResp": {
+        "type": "object",
+        "required": [
+          "message"
+        ],
+        "properties": {
+          "message": {
+            "type": "string",
+            "example": "pong"
+          }
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// pathVariable matches the variables of path templates with or without their pattern
+var pathVariable = regexp.MustCompile(`\{[^}]*\}`)
+
+// TestOpenAPIDocument ensures every route is documented and error responses describe their body
+func TestOpenAPIDocument(t *testing.T) {
+	doc := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal([]byte(openapiSpec), &doc); err != nil {
+		t.Fatalf("failed to unmarshal document: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			documented[strings.ToUpper(method)+" "+pathVariable.ReplaceAllString(path, "{}")] = true
+
+			resp := struct {
+				Responses map[string]struct {
+					Content map[string]json.RawMessage `json:"content"`
+				} `json:"responses"`
+			}{}
+			json.Unmarshal(operation, &resp)
+			for status, response := range resp.Responses {
+				if status >= "400" && len(response.Content) == 0 {
+					t.Errorf("%s %s response %s has no content", method, path, status)
+				}
+			}
+		}
+	}
+
+	router := configureRoutes()
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		path := pathVariable.ReplaceAllString(strings.TrimPrefix(template, API_V1_PREFIX), "{}")
+		for _, method := range methods {
+			if method != "OPTIONS" && method != "HEAD" && !documented[method+" "+path] {
+				t.Errorf("route %s %s is not documented", method, template)
+			}
+		}
+		return nil
+	})
+}
+
+// TestServeOpenAPI ensures the document and the Swagger UI browsing it are served
+func TestServeOpenAPI(t *testing.T) {
+	router := configureRoutes()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" || !json.Valid(rr.Body.Bytes()) {
+		t.Fatalf("failed to serve document: got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("wrong status of conditional request: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/docs", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), SWAGGER_UI_URL+"/swagger-ui-bundle.js") {
+		t.Errorf("failed to serve docs: got %v %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	ORIENT_QUALITY = 95 // JPEG quality of images rewritten upright
+
+	exifOrientationTag = 0x0112
+)
+
+func init() {
+	RegisterProcessor(orientProcessor{})
+}
+
+// orientProcessor rewrites JPEG images whose EXIF orientation tag requires rotation or mirroring
+// so the stored pixels are upright. The rewritten file carries no EXIF data so it is not reoriented
+// by clients a second time, the original EXIF data is kept at exifKey. The size, content hash and blob
+// of the image are updated to match
+type orientProcessor struct{}
+
+func (orientProcessor) Name() string {
+	return "orient"
+}
+
+func (orientProcessor) Process(ctx context.Context, img *Image) error {
+	// Linked files stored before content addressing are oriented by the pipeline of the image that stored them
+	key := imageKey(*img)
+	if img.Encoding != "image/jpeg" || (!isBlobKey(key) && key != ownImageKey(*img)) {
+		return nil
+	}
+
+	file, err := storage.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %v", err)
+	}
+	data, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read image: %v", err)
+	}
+
+	orientation := exifOrientation(data)
+	if orientation <= 1 || orientation > 8 {
+		return nil
+	}
+
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = jpeg.Encode(buf, orientImage(src, orientation), &jpeg.Options{Quality: ORIENT_QUALITY})
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %v", err)
+	}
+
+	hash, err := hashFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to hash image: %v", err)
+	}
+
+	// Keep the EXIF data the rewritten file drops for the exif endpoint
+	tiff := exifTIFF(data)
+	err = storage.Put(ctx, exifKey(*img), bytes.NewReader(tiff), int64(len(tiff)), "image/tiff")
+	if err != nil {
+		return fmt.Errorf("failed to keep exif data: %v", err)
+	}
+
+	// Blobs are shared by content so the upright file is stored as the blob of its own content,
+	// other images referencing the previous blob are oriented by their own pipeline
+	fileKey := key
+	if isBlobKey(key) {
+		fileKey = blobKey(hash, img.Encoding)
+		err = acquireBlob(ctx, fileKey, bytes.NewReader(buf.Bytes()), int64(buf.Len()), img.Encoding)
+	} else {
+		err = storage.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), img.Encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store upright image: %v", err)
+	}
+
+	// Watermarked copies were made from the previous pixels
+	storage.Delete(ctx, watermarkKey(*img))
+
+	err = UpdateImageContent(img.Id, img.Uid, int32(buf.Len())-img.Size, hash, fileKey)
+	if err != nil {
+		if fileKey != key {
+			releaseBlob(ctx, fileKey)
+		}
+		return fmt.Errorf("failed to update image meta: %v", err)
+	}
+	img.Size = int32(buf.Len())
+	img.Hash = hash
+	img.FileKey = fileKey
+
+	if fileKey != key {
+		err = releaseBlob(ctx, key)
+		if err != nil {
+			logger.Error("failed to release blob of image %v, clean orphaned files via automated data integrity check: %v", img.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// exifOrientation returns the EXIF orientation of the JPEG data, 1 (upright) if it has none
+func exifOrientation(data []byte) int {
+	tiff := exifTIFF(data)
+	if tiff == nil {
+		return 1
+	}
+	return tiffOrientation(tiff)
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF structure embedded in EXIF data
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
+
+// orientImage returns the image transformed so an image with the EXIF orientation displays upright
+// orientations 5 through 8 swap the width and height
+func orientImage(src image.Image, orientation int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// Source pixel displayed at x, y
+			sx, sy := x, y
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx = w - 1 - x
+			case 3: // Rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sy = h - 1 - y
+			case 5: // Mirrored along the main diagonal
+				sx, sy = y, x
+			case 6: // Requires rotating 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // Mirrored along the anti diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // Requires rotating 90 counter clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
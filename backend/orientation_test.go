@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment holding a TIFF structure with the orientation tag
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8)) // First IFD follows the header
+	binary.Write(tiff, order, uint16(2)) // Entries
+
+	// An unrelated tag precedes the orientation
+	binary.Write(tiff, order, uint16(0x010F))
+	binary.Write(tiff, order, uint16(2))
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, uint32(0))
+
+	binary.Write(tiff, order, uint16(exifOrientationTag))
+	binary.Write(tiff, order, uint16(3)) // SHORT
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, orientation)
+	binary.Write(tiff, order, uint16(0))
+	binary.Write(tiff, order, uint32(0)) // No further IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	return append(header, segment...)
+}
+
+// withSegment inserts the segment after the start of image marker of the jpeg
+func withSegment(jpg []byte, segment []byte) []byte {
+	data := append([]byte{}, jpg[:2]...)
+	data = append(data, segment...)
+	return append(data, jpg[2:]...)
+}
+
+// TestExifOrientation ensures the orientation is read in either byte order and defaults to upright
+func TestExifOrientation(t *testing.T) {
+	jpg := testImage(t, "jpeg", 8)
+
+	tt := []struct {
+		Name     string
+		Data     []byte
+		Expected int
+	}{
+		{"no exif", jpg, 1},
+		{"little endian", withSegment(jpg, exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", withSegment(jpg, exifSegment(binary.BigEndian, 8)), 8},
+		{"png", testImage(t, "png", 8), 1},
+		{"truncated", withSegment(jpg, exifSegment(binary.BigEndian, 3))[:20], 1},
+		{"empty", []byte{}, 1},
+	}
+
+	for _, tc := range tt {
+		if orientation := exifOrientation(tc.Data); orientation != tc.Expected {
+			t.Errorf("wrong orientation for %s: got %v want %v", tc.Name, orientation, tc.Expected)
+		}
+	}
+}
+
+// TestOrientImage ensures each orientation moves the top left pixel of the stored image to the expected corner
+func TestOrientImage(t *testing.T) {
+	marker := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, marker)
+
+	tt := []struct {
+		Orientation int
+		Width       int
+		Height      int
+		X, Y        int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+
+	for _, tc := range tt {
+		dst := orientImage(src, tc.Orientation)
+		if bounds := dst.Bounds(); bounds.Dx() != tc.Width || bounds.Dy() != tc.Height {
+			t.Errorf("wrong bounds for orientation %v: got %v", tc.Orientation, bounds)
+			continue
+		}
+		if c := color.RGBAModel.Convert(dst.At(tc.X, tc.Y)); c != marker {
+			t.Errorf("marker not at %v,%v for orientation %v", tc.X, tc.Y, tc.Orientation)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+/*
+	This file implements a transactional outbox for side effects of database writes such as
+	emails and webhooks. Events are written in the same transaction as the change that causes
+	them so they are never lost, and are removed only once every handler of the topic succeeds.
+	Delivery is at least once: an event is redelivered if a handler fails or the server stops
+	before the event is removed, handlers must use the event id to ignore repeated deliveries.
+	Events exhausting their attempts are kept for OUTBOX_RETENTION days for inspection.
+	Drains lease the events they deliver instead of holding a transaction open, so handlers may
+	take their time and use the database while other drains skip the leased events.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	OUTBOX_TABLE        = "outbox"
+	OUTBOX_INTERVAL     = 5   // Seconds between outbox drains
+	OUTBOX_BATCH        = 50  // Maximum events delivered by a single drain
+	OUTBOX_MAX_ATTEMPTS = 10  // Events failing this many times are kept for inspection but no longer delivered
+	OUTBOX_LEASE        = 600 // Seconds a drain has to deliver the events it claimed before they are claimed again
+	OUTBOX_RETENTION    = 30  // Default days events that exhausted their attempts are kept if the OUTBOX_RETENTION env variable is not defined
+
+	OUTBOX_PURGE_INTERVAL = time.Hour // Interval between purges of exhausted events
+
+	// Event topics
+	EVENT_USER_REGISTERED = "user.registered"
+)
+
+// OutboxEvent is a pending side effect tagged for sql serialization
+type OutboxEvent struct {
+	Id          int32     `json:"id" sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Topic       string    `json:"topic" sql:"topic"`
+	Payload     string    `json:"payload" sql:"payload"`
+	Attempts    int32     `json:"attempts" sql:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt" sql:"next_attempt"`
+	Created     time.Time `json:"created" sql:"created"`
+}
+
+// OutboxHandler performs the side effect of an event
+type OutboxHandler func(ctx context.Context, event OutboxEvent) error
+
+// outboxHandlers holds the handlers of each topic
+var outboxHandlers = map[string][]OutboxHandler{}
+
+func init() {
+	RegisterJob("outbox", OUTBOX_INTERVAL*time.Second, drainOutbox)
+	// Exhausted events are rescheduled after their last attempt, their next attempt dates the failure
+	RegisterPurgeJob("outbox-exhausted", OUTBOX_PURGE_INTERVAL, OUTBOX_TABLE, "attempts >= $1 AND next_attempt < $2", func() []interface{} {
+		return []interface{}{OUTBOX_MAX_ATTEMPTS, time.Now().UTC().Add(-getOutboxRetention())}
+	})
+}
+
+// getOutboxRetention returns how long exhausted events are kept defined by the OUTBOX_RETENTION environment variable in days
+func getOutboxRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("OUTBOX_RETENTION"))
+	if err != nil || days <= 0 {
+		days = OUTBOX_RETENTION
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RegisterOutboxHandler adds a handler run for every event of the topic
+func RegisterOutboxHandler(topic string, handler OutboxHandler) {
+	outboxHandlers[topic] = append(outboxHandlers[topic], handler)
+}
+
+// insertEvent writes an event with the payload encoded as json, db should be the
+// transaction of the change causing the event
+func insertEvent(db dbtx, topic string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %v", topic, err)
+	}
+
+	now := time.Now().UTC()
+	_, err = insertObject(db, OUTBOX_TABLE, OutboxEvent{Topic: topic, Payload: string(js), NextAttempt: now, Created: now})
+	if err != nil {
+		return fmt.Errorf("failed to add %s event to outbox: %v", topic, err)
+	}
+
+	return nil
+}
+
+// drainOutbox delivers the events that are due
+func drainOutbox(ctx context.Context) error {
+	delivered, err := ProcessOutbox(func(event OutboxEvent) error {
+		return dispatchEvent(ctx, event)
+	})
+	if delivered > 0 {
+		logger.Info("Delivered %v outbox events", delivered)
+	}
+	return err
+}
+
+// dispatchEvent runs every handler of the event topic stopping at the first failure
+func dispatchEvent(ctx context.Context, event OutboxEvent) error {
+	for _, handler := range outboxHandlers[event.Topic] {
+		err := handler(ctx, event)
+		if err != nil {
+			return fmt.Errorf("handler for %s event %v failed: %v", event.Topic, event.Id, err)
+		}
+	}
+	return nil
+}
+
+// outboxBackoff returns the delay before an event that failed the number of attempts is retried
+func outboxBackoff(attempts int32) time.Duration {
+	if attempts > 10 {
+		attempts = 10
+	}
+	return time.Duration(1<<uint(attempts)) * OUTBOX_INTERVAL * time.Second
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestDispatchEvent ensures every handler of the topic runs and failures are reported
+func TestDispatchEvent(t *testing.T) {
+	calls := []string{}
+	RegisterOutboxHandler("test.dispatch", func(ctx context.Context, event OutboxEvent) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	RegisterOutboxHandler("test.dispatch", func(ctx context.Context, event OutboxEvent) error {
+		calls = append(calls, "second")
+		if event.Attempts == 0 {
+			return fmt.Errorf("temporary failure")
+		}
+		return nil
+	})
+	defer delete(outboxHandlers, "test.dispatch")
+
+	if err := dispatchEvent(context.Background(), OutboxEvent{Topic: "test.dispatch"}); err == nil {
+		t.Errorf("expected handler failure to be reported")
+	}
+	if err := dispatchEvent(context.Background(), OutboxEvent{Topic: "test.dispatch", Attempts: 1}); err != nil {
+		t.Errorf("unexpected failure: %v", err)
+	}
+	if len(calls) != 4 {
+		t.Errorf("wrong handler calls: got %v", calls)
+	}
+
+	// Topics without handlers are delivered trivially
+	if err := dispatchEvent(context.Background(), OutboxEvent{Topic: "test.none"}); err != nil {
+		t.Errorf("unexpected failure for topic without handlers: %v", err)
+	}
+
+	if outboxBackoff(1) >= outboxBackoff(2) || outboxBackoff(50) != outboxBackoff(10) {
+		t.Errorf("backoff must grow and be capped: got %v %v %v", outboxBackoff(1), outboxBackoff(2), outboxBackoff(50))
+	}
+}
+
+// TestOutboxRetention ensures exhausted events are kept for the configured days, invalid values use the default
+func TestOutboxRetention(t *testing.T) {
+	defer os.Unsetenv("OUTBOX_RETENTION")
+
+	if retention := getOutboxRetention(); retention != OUTBOX_RETENTION*24*time.Hour {
+		t.Errorf("wrong default retention: got %v", retention)
+	}
+	os.Setenv("OUTBOX_RETENTION", "7")
+	if retention := getOutboxRetention(); retention != 7*24*time.Hour {
+		t.Errorf("wrong configured retention: got %v", retention)
+	}
+	os.Setenv("OUTBOX_RETENTION", "-1")
+	if retention := getOutboxRetention(); retention != OUTBOX_RETENTION*24*time.Hour {
+		t.Errorf("negative retention should use the default: got %v", retention)
+	}
+}
+
+// TestProcessOutbox ensures failed events are retried later, delivered events are removed and events
+// being delivered aren't claimed by concurrent drains
+func TestProcessOutbox(t *testing.T) {
+	db, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	err = insertEvent(db, "test.outbox", map[string]string{"key": "value"})
+	if err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+
+	deliver := func(fail bool) func(event OutboxEvent) error {
+		return func(event OutboxEvent) error {
+			if event.Topic != "test.outbox" {
+				return nil
+			}
+			if event.Payload != `{"key":"value"}` {
+				t.Errorf("wrong payload: got %s", event.Payload)
+			}
+			// Events are leased rather than locked while delivered, other drains skip them
+			_, err := ProcessOutbox(func(leased OutboxEvent) error {
+				if leased.Id == event.Id {
+					t.Errorf("leased event %v delivered by a concurrent drain", event.Id)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("failed to process outbox while delivering: %v", err)
+			}
+			if fail {
+				return fmt.Errorf("temporary failure")
+			}
+			return nil
+		}
+	}
+
+	if _, err = ProcessOutbox(deliver(true)); err != nil {
+		t.Fatalf("failed to process outbox: %v", err)
+	}
+
+	// The failed event is not due until its backoff passes
+	rows, err := selectWhere(db, OutboxEvent{}, OUTBOX_TABLE, "topic = $1", "test.outbox")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected failed event to remain: got %v %v", rows, err)
+	}
+	event := rows[0].(OutboxEvent)
+	if event.Attempts != 1 || !event.NextAttempt.After(time.Now().UTC()) {
+		t.Errorf("failed event not rescheduled: got %+v", event)
+	}
+
+	event.NextAttempt = time.Now().UTC().Add(-time.Second)
+	if err = updateObject(db, OUTBOX_TABLE, event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ProcessOutbox(deliver(false)); err != nil {
+		t.Fatalf("failed to process outbox: %v", err)
+	}
+	if count, _ := countWhere(db, OUTBOX_TABLE, "topic = $1", "test.outbox"); count != 0 {
+		t.Errorf("delivered event was not removed")
+	}
+}
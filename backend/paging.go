@@ -0,0 +1,113 @@
+package main
+
+/*
+	This file implements sorting and paging of image meta queries. Clients choose the order of the
+	results with sort=title|size|date|rating and order=asc|desc and the number of results per page with
+	pageSize, responses link the previous and next pages with every other parameter preserved so
+	clients can walk the results without rebuilding queries. Results without a sort keep the
+	insertion order, ties are broken by id so pages never overlap.
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	PAGE_SIZE_MAX = 200 // Largest pageSize accepted by image meta queries
+
+	// Sort orders
+	ORDER_ASC  = "asc" // Default order
+	ORDER_DESC = "desc"
+)
+
+// sortColumns maps the sort parameter to the column it orders by, date is the upload date
+// and rating the average rating
+var sortColumns = map[string]string{
+	"title":  "lower(title)",
+	"size":   "size",
+	"date":   "uploaded",
+	"rating": ratingAverage,
+}
+
+// pagingParams are the query parameters arranging the results of a query rather than filtering them
+var pagingParams = []string{"page", "pageSize", "sort", "order"}
+
+// MetaPage is the page, page size and order requested by an image meta query
+type MetaPage struct {
+	Page     int
+	PageSize int
+	Sort     string // Key of sortColumns, empty for insertion order
+	Order    string
+}
+
+// parseMetaPage validates the paging parameters of the query applying the defaults of omitted parameters
+func parseMetaPage(params url.Values) (MetaPage, error) {
+	page := MetaPage{PageSize: PAGE_SIZE, Sort: params.Get("sort"), Order: strings.ToLower(params.Get("order"))}
+
+	var err error
+	if params.Has("page") {
+		page.Page, err = strconv.Atoi(params.Get("page"))
+		if err != nil || page.Page < 0 {
+			return MetaPage{}, fmt.Errorf("invalid page %q, must be a non negative integer", params.Get("page"))
+		}
+	}
+	if params.Has("pageSize") {
+		page.PageSize, err = strconv.Atoi(params.Get("pageSize"))
+		if err != nil || page.PageSize < 1 || page.PageSize > PAGE_SIZE_MAX {
+			return MetaPage{}, fmt.Errorf("invalid pageSize %q, must be between 1 and %v", params.Get("pageSize"), PAGE_SIZE_MAX)
+		}
+	}
+	if _, ok := sortColumns[page.Sort]; len(page.Sort) > 0 && !ok {
+		return MetaPage{}, fmt.Errorf("invalid sort %q, use title, size, date or rating", page.Sort)
+	}
+	if len(page.Order) == 0 {
+		page.Order = ORDER_ASC
+	}
+	if page.Order != ORDER_ASC && page.Order != ORDER_DESC {
+		return MetaPage{}, fmt.Errorf("invalid order %q, use asc or desc", params.Get("order"))
+	}
+
+	return page, nil
+}
+
+// orderBy returns the ORDER BY clause of the page, columns come from sortColumns and are never taken from the request
+func (p MetaPage) orderBy() string {
+	direction := strings.ToUpper(p.Order)
+	if column, ok := sortColumns[p.Sort]; ok {
+		return fmt.Sprintf("ORDER BY %s %s, id %s", column, direction, direction)
+	}
+	return "ORDER BY id " + direction
+}
+
+// condition appends the order, limit and offset of the page to the condition of the query binding their values
+func (p MetaPage) condition(where *whereBuilder) string {
+	return fmt.Sprintf("%s %s LIMIT %s OFFSET %s", where.String(), p.orderBy(), where.bind(p.PageSize), where.bind(p.Page*p.PageSize))
+}
+
+// links returns the urls of the previous and next pages of the query, empty when there is no such page
+func (p MetaPage) links(path string, params url.Values, total int) (prev string, next string) {
+	link := func(page int) string {
+		query := url.Values{}
+		for key, values := range params {
+			query[key] = values
+		}
+		query.Set("page", strconv.Itoa(page))
+		return path + "?" + query.Encode()
+	}
+
+	if p.Page > 0 {
+		prev = link(p.Page - 1)
+	}
+	if (p.Page+1)*p.PageSize < total {
+		next = link(p.Page + 1)
+	}
+	return prev, next
+}
+
+// isPagingParam reports whether the query parameter arranges results rather than filtering them
+func isPagingParam(name string) bool {
+	return containsString(pagingParams, name)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestParseMetaPage ensures paging parameters default when omitted and are refused outside their bounds
+func TestParseMetaPage(t *testing.T) {
+	tt := []struct {
+		Query    string
+		Expected MetaPage
+		Valid    bool
+	}{
+		{"", MetaPage{PageSize: PAGE_SIZE, Order: ORDER_ASC}, true},
+		{"page=2&pageSize=200&sort=date&order=DESC", MetaPage{Page: 2, PageSize: 200, Sort: "date", Order: ORDER_DESC}, true},
+		{"sort=title", MetaPage{PageSize: PAGE_SIZE, Sort: "title", Order: ORDER_ASC}, true},
+		{"sort=rating&order=desc", MetaPage{PageSize: PAGE_SIZE, Sort: "rating", Order: ORDER_DESC}, true},
+		{"page=-1", MetaPage{}, false},
+		{"page=one", MetaPage{}, false},
+		{"pageSize=0", MetaPage{}, false},
+		{"pageSize=201", MetaPage{}, false},
+		{"sort=id%3B+DROP+TABLE+image_meta", MetaPage{}, false},
+		{"sort=id", MetaPage{}, false},
+		{"order=sideways", MetaPage{}, false},
+	}
+	for _, tc := range tt {
+		params, _ := url.ParseQuery(tc.Query)
+		page, err := parseMetaPage(params)
+		if (err == nil) != tc.Valid || page != tc.Expected {
+			t.Errorf("wrong page for %q: got %+v %v want %+v", tc.Query, page, err, tc.Expected)
+		}
+	}
+}
+
+// TestMetaPageOrderBy ensures sorts order by their column with id breaking ties
+func TestMetaPageOrderBy(t *testing.T) {
+	tt := []struct {
+		Page     MetaPage
+		Expected string
+	}{
+		{MetaPage{Order: ORDER_ASC}, "ORDER BY id ASC"},
+		{MetaPage{Sort: "title", Order: ORDER_ASC}, "ORDER BY lower(title) ASC, id ASC"},
+		{MetaPage{Sort: "size", Order: ORDER_DESC}, "ORDER BY size DESC, id DESC"},
+		{MetaPage{Sort: "date", Order: ORDER_DESC}, "ORDER BY uploaded DESC, id DESC"},
+	}
+	for _, tc := range tt {
+		if got := tc.Page.orderBy(); got != tc.Expected {
+			t.Errorf("wrong order for %+v: got %q want %q", tc.Page, got, tc.Expected)
+		}
+	}
+}
+
+// TestMetaPageLinks ensures links preserve the query and are omitted past the first and last pages
+func TestMetaPageLinks(t *testing.T) {
+	params := url.Values{"tags": {"beach"}, "pageSize": {"10"}, "page": {"1"}}
+	tt := []struct {
+		Page  int
+		Total int
+		Prev  string
+		Next  string
+	}{
+		{0, 5, "", ""},
+		{0, 25, "", "/image/meta?page=1&pageSize=10&tags=beach"},
+		{1, 25, "/image/meta?page=0&pageSize=10&tags=beach", "/image/meta?page=2&pageSize=10&tags=beach"},
+		{2, 25, "/image/meta?page=1&pageSize=10&tags=beach", ""},
+	}
+	for _, tc := range tt {
+		prev, next := MetaPage{Page: tc.Page, PageSize: 10}.links("/image/meta", params, tc.Total)
+		if prev != tc.Prev || next != tc.Next {
+			t.Errorf("wrong links for page %v of %v: got %q %q want %q %q", tc.Page, tc.Total, prev, next, tc.Prev, tc.Next)
+		}
+	}
+	if params.Get("page") != "1" {
+		t.Errorf("links modified the query parameters")
+	}
+}
+
+// TestMetaSorting ensures image meta queries return the requested page in the requested order
+func TestMetaSorting(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	images := []Image{}
+	for i := 0; i < 3; i++ {
+		image := uploadTestImage(t, router, token, false)
+		defer DeleteImageData(context.Background(), image)
+		images = append(images, image)
+	}
+
+	tt := []struct {
+		Query    string
+		Expected []int32
+		Next     bool
+	}{
+		{"", []int32{images[0].Id, images[1].Id, images[2].Id}, false},
+		{"?sort=date&order=desc&pageSize=2", []int32{images[2].Id, images[1].Id}, true},
+		{"?sort=date&order=desc&pageSize=2&page=1", []int32{images[0].Id}, false},
+	}
+	for _, tc := range tt {
+		req := httptest.NewRequest("GET", "/image/meta"+tc.Query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong code for %q: got %v want %v", tc.Query, rr.Code, http.StatusOK)
+		}
+
+		resp := QueryResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		ids := []int32{}
+		for _, image := range resp.ImageMeta {
+			ids = append(ids, image.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tc.Expected) || resp.TotalResults != 3 || (len(resp.Next) > 0) != tc.Next {
+			t.Errorf("wrong page for %q: got %v of %v next %q want %v", tc.Query, ids, resp.TotalResults, resp.Next, tc.Expected)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/image/meta?pageSize=1000", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for oversized page: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
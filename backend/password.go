@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/inflowml/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RESET_TABLE          = "password_reset"
+	RESET_TOKEN_TTL      = 60 // Minutes a reset token is valid if RESET_TOKEN_TTL env variable is not defined
+	RESET_PURGE_INTERVAL = time.Hour
+	RESET_TOKEN_BYTES    = 32
+
+	// Event topics
+	EVENT_PASSWORD_RESET = "user.password_reset"
+)
+
+// ErrInvalidResetToken is returned for reset tokens that do not exist, were used or have expired
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordReset is a pending reset token tagged for sql serialization
+// only the sha256 hash of the token is stored so the table cannot be used to reset passwords
+type PasswordReset struct {
+	Id        int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid       int32     `sql:"uid"`
+	TokenHash string    `sql:"token_hash" opt:"UNIQUE"`
+	Expires   time.Time `sql:"expires"`
+}
+
+// PasswordResetEvent is the payload of EVENT_PASSWORD_RESET, a handler is expected
+// to deliver the token to the user's email
+type PasswordResetEvent struct {
+	Uid     int32     `json:"uid"`
+	Email   string    `json:"email"`
+	Token   string    `json:"token"`
+	Expires Timestamp `json:"expires"`
+}
+
+func init() {
+	RegisterPurgeJob("password-reset", RESET_PURGE_INTERVAL, RESET_TABLE, "expires <= $1", func() []interface{} {
+		return []interface{}{time.Now().UTC()}
+	})
+}
+
+// getResetTokenTTL returns the lifetime of reset tokens defined by the RESET_TOKEN_TTL environment variable
+func getResetTokenTTL() time.Duration {
+	ttl, err := strconv.Atoi(os.Getenv("RESET_TOKEN_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = RESET_TOKEN_TTL
+	}
+	return time.Duration(ttl) * time.Minute
+}
+
+// newResetToken returns a random reset token and the hash stored in its place
+func newResetToken() (string, string, error) {
+	buf := make([]byte, RESET_TOKEN_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex encoded sha256 hash of a random token
+// tokens are too long to guess so they are stored hashed without a salt
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// changePassword accepts a json body with currentPassword and newPassword and updates the
+// password of the authenticated user after verifying the current password
+func changePassword(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to change password sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil || len(body.CurrentPassword) == 0 || len(body.NewPassword) == 0 {
+		logger.Error("invalid password change body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - currentPassword and newPassword are required"))
+		return
+	}
+
+	pass, err := GetUserPass(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve password sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to change password, try again later"))
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(pass.HashedPass), []byte(body.CurrentPassword))
+	if err != nil {
+		logger.Error("incorrect current password for user %v sending 403", claims.Uid)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Current password is incorrect"))
+		return
+	}
+
+	if !enforcePassword(w, req, body.NewPassword, claims.Email) {
+		return
+	}
+
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error("Failed to hash password sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Unable to use that password, choose another"))
+		return
+	}
+
+	pass.HashedPass = string(hashedPass)
+	err = UpdateUserPass(pass)
+	if err != nil {
+		logger.Error("failed to update password sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to change password, try again later"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Successfully changed password of user: %v", claims.Uid)
+}
+
+// requestPasswordReset accepts a json body with an email and issues a reset token for the
+// account, the token is delivered through the EVENT_PASSWORD_RESET outbox event. The response
+// is the same whether or not the email is registered so accounts cannot be discovered
+func requestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil || len(body.Email) == 0 {
+		logger.Error("invalid reset request body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - email is required"))
+		return
+	}
+
+	user, err := GetUserData(body.Email)
+	if err != nil {
+		logger.Info("Password reset requested for unknown email")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		logger.Error("failed to generate reset token sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to request reset, try again later"))
+		return
+	}
+
+	expires := time.Now().UTC().Add(getResetTokenTTL())
+	err = AddPasswordReset(PasswordReset{Uid: user.Uid, TokenHash: tokenHash, Expires: expires}, PasswordResetEvent{
+		Uid:     user.Uid,
+		Email:   user.Email,
+		Token:   token,
+		Expires: Timestamp(expires),
+	})
+	if err != nil {
+		logger.Error("failed to store reset token sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to request reset, try again later"))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	logger.Info("Issued password reset token for user: %v", user.Uid)
+}
+
+// resetPassword accepts a json body with a reset token and the new password
+// the token is consumed and every other token of the user is revoked
+func resetPassword(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil || len(body.Token) == 0 || len(body.Password) == 0 {
+		logger.Error("invalid reset body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - token and password are required"))
+		return
+	}
+
+	if !enforcePassword(w, req, body.Password, "") {
+		return
+	}
+
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error("Failed to hash password sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Unable to use that password, choose another"))
+		return
+	}
+
+	uid, err := ResetPassword(hashToken(body.Token), string(hashedPass))
+	if err == ErrInvalidResetToken {
+		logger.Error("invalid reset token sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Reset token is invalid or expired, request a new one"))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to reset password sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to reset password, try again later"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Successfully reset password of user: %v", uid)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestResetToken ensures tokens are random and only their hash is stored
+func TestResetToken(t *testing.T) {
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != RESET_TOKEN_BYTES*2 || tokenHash == token || tokenHash != hashToken(token) {
+		t.Errorf("wrong token %q with hash %q", token, tokenHash)
+	}
+
+	other, _, _ := newResetToken()
+	if other == token {
+		t.Errorf("reset tokens repeated")
+	}
+
+	defer os.Unsetenv("RESET_TOKEN_TTL")
+	if ttl := getResetTokenTTL(); ttl != RESET_TOKEN_TTL*time.Minute {
+		t.Errorf("wrong default ttl: got %v", ttl)
+	}
+	os.Setenv("RESET_TOKEN_TTL", "5")
+	if ttl := getResetTokenTTL(); ttl != 5*time.Minute {
+		t.Errorf("wrong configured ttl: got %v", ttl)
+	}
+}
+
+// TestPasswordChange changes and resets the password of the test user and signs in with each password
+func TestPasswordChange(t *testing.T) {
+	t.Parallel()
+
+	token, uid := getTestToken(t)
+
+	router := configureRoutes()
+
+	tt := []struct {
+		Method   string
+		Route    string
+		Body     string
+		Expected int
+	}{
+		{"PUT", "/user/password", `{"currentPassword": "wrong", "newPassword": "Quiet-Lantern-Orbit-7"}`, http.StatusForbidden},
+		{"PUT", "/user/password", `{"currentPassword": "` + userPass + `"}`, http.StatusBadRequest},
+		{"PUT", "/user/password", `{"currentPassword": "` + userPass + `", "newPassword": "Quiet-Lantern-Orbit-7"}`, http.StatusNoContent},
+		{"POST", "/user/password/reset-request", `{"email": "unknown@mail.com"}`, http.StatusAccepted},
+		{"POST", "/user/password/reset-request", `{"email": "` + testEmail(t, "user") + `"}`, http.StatusAccepted},
+		{"POST", "/user/password/reset", `{"token": "invalid", "password": "Amber-Falcon-Meadow-9"}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		req, _ := http.NewRequest(tc.Method, tc.Route, strings.NewReader(tc.Body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.Expected {
+			t.Errorf("handler returned wrong code for %s %s: got %v want %v", tc.Method, tc.Route, status, tc.Expected)
+		}
+	}
+	checkTestLogin(t, router, "Quiet-Lantern-Orbit-7", http.StatusOK)
+
+	// An expired token is rejected and a valid token may only be used once
+	expired, expiredHash, _ := newResetToken()
+	resetToken, resetHash, _ := newResetToken()
+	AddPasswordReset(PasswordReset{Uid: int32(uid), TokenHash: expiredHash, Expires: time.Now().UTC().Add(-time.Minute)}, PasswordResetEvent{})
+	AddPasswordReset(PasswordReset{Uid: int32(uid), TokenHash: resetHash, Expires: time.Now().UTC().Add(time.Minute)}, PasswordResetEvent{})
+
+	for _, tc := range []struct {
+		Token    string
+		Expected int
+	}{{expired, http.StatusBadRequest}, {resetToken, http.StatusNoContent}, {resetToken, http.StatusBadRequest}} {
+		req, _ := http.NewRequest("POST", "/user/password/reset", strings.NewReader(`{"token": "`+tc.Token+`", "password": "Amber-Falcon-Meadow-9"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.Expected {
+			t.Errorf("handler returned wrong code for reset: got %v want %v", status, tc.Expected)
+		}
+	}
+	checkTestLogin(t, router, "Quiet-Lantern-Orbit-7", http.StatusUnauthorized)
+	checkTestLogin(t, router, "Amber-Falcon-Meadow-9", http.StatusOK)
+}
+
+// checkTestLogin signs in as the test user with the password and compares the status
+func checkTestLogin(t *testing.T, router http.Handler, password string, expected int) {
+	req, _ := http.NewRequest("GET", "/auth", nil)
+	req.SetBasicAuth(testEmail(t, "user"), password)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != expected {
+		t.Errorf("wrong code signing in with %q: got %v want %v", password, status, expected)
+	}
+}
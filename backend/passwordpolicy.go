@@ -0,0 +1,275 @@
+package main
+
+/*
+	This file enforces the password policy on every password chosen by a user, at registration,
+	when changing it and when resetting it. A password must be long enough, must not be too
+	predictable, must not appear on the denylist of common passwords and must not contain the
+	user's email. Deployments may also refuse passwords found in known breaches through the range
+	API of Have I Been Pwned, which only receives the first characters of the SHA-1 hash of the
+	password. Every failed rule is returned together so clients can show all of them at once.
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	PASSWORD_MIN_LENGTH       = 8  // Characters if PASSWORD_MIN_LENGTH env variable is not defined
+	PASSWORD_MIN_ENTROPY      = 30 // Estimated bits if PASSWORD_MIN_ENTROPY env variable is not defined
+	PASSWORD_BREACHED_URL     = "https://api.pwnedpasswords.com/range/"
+	PASSWORD_BREACHED_TIMEOUT = 3 * time.Second
+	PASSWORD_PREFIX_LENGTH    = 5 // Characters of the hash sent to the range API
+
+	// Rules of the policy
+	RULE_MIN_LENGTH = "minLength"
+	RULE_ENTROPY    = "entropy"
+	RULE_DENYLIST   = "denylist"
+	RULE_BREACHED   = "breached"
+	RULE_EMAIL      = "email"
+)
+
+// COMMON_PASSWORDS are always denied, the file named by PASSWORD_DENYLIST extends them
+var COMMON_PASSWORDS = []string{
+	"123456", "12345678", "123456789", "1234567890", "111111", "000000", "123123", "654321",
+	"password", "password1", "password123", "passw0rd", "p@ssw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "1q2w3e4r", "1qaz2wsx", "zaq12wsx", "abc123", "abcd1234", "iloveyou",
+	"letmein", "welcome", "welcome1", "admin", "admin123", "administrator", "monkey", "dragon",
+	"football", "baseball", "superman", "sunshine", "princess", "trustno1", "starwars", "master",
+	"shadow", "michael", "whatever", "freedom", "changeme", "secret", "login", "picture",
+	"pictures", "pictocache", "picto-cache",
+}
+
+// PasswordConfig holds the rules passwords are checked against
+type PasswordConfig struct {
+	MinLength   int    `yaml:"minLength"`
+	MinEntropy  int    `yaml:"minEntropy"`  // Estimated bits, 0 disables the check
+	Denylist    string `yaml:"denylist"`    // File of denied passwords, one per line, extending COMMON_PASSWORDS
+	Breached    bool   `yaml:"breached"`    // Refuse passwords found in known breaches
+	BreachedURL string `yaml:"breachedUrl"` // Range API the hash prefix is appended to
+}
+
+// PasswordFailure is a rule of the policy that a password failed
+type PasswordFailure struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyResp is the body of 400 responses to passwords failing the policy
+type PasswordPolicyResp struct {
+	Error  string            `json:"error"`
+	Failed []PasswordFailure `json:"failed"`
+}
+
+// passwordConfig is the password policy, the defaults until configurePassword is called
+var (
+	passwordConfig     = defaultConfig().Password
+	passwordDenylist   = denylistOf(COMMON_PASSWORDS)
+	passwordConfigLock sync.RWMutex
+)
+
+var breachedClient = &http.Client{Timeout: PASSWORD_BREACHED_TIMEOUT}
+
+// configurePassword sets the password policy and loads its denylist
+func configurePassword(config PasswordConfig) error {
+	denylist := denylistOf(COMMON_PASSWORDS)
+	if len(config.Denylist) > 0 {
+		file, err := os.Open(config.Denylist)
+		if err != nil {
+			return fmt.Errorf("unable to read password denylist: %v", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+				denylist[strings.ToLower(line)] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("unable to read password denylist: %v", err)
+		}
+	}
+
+	passwordConfigLock.Lock()
+	defer passwordConfigLock.Unlock()
+	passwordConfig = config
+	passwordDenylist = denylist
+	return nil
+}
+
+// currentPassword returns the password policy and its denylist
+func currentPassword() (PasswordConfig, map[string]bool) {
+	passwordConfigLock.RLock()
+	defer passwordConfigLock.RUnlock()
+	return passwordConfig, passwordDenylist
+}
+
+// denylistOf returns the set of the lowercase passwords
+func denylistOf(passwords []string) map[string]bool {
+	denylist := map[string]bool{}
+	for _, password := range passwords {
+		denylist[strings.ToLower(password)] = true
+	}
+	return denylist
+}
+
+// validate returns a problem for each invalid setting
+func (c PasswordConfig) validate() []string {
+	var problems []string
+	if c.MinLength < 1 {
+		problems = append(problems, fmt.Sprintf("password.minLength (PASSWORD_MIN_LENGTH) must be positive, got %v", c.MinLength))
+	}
+	if c.MinEntropy < 0 {
+		problems = append(problems, fmt.Sprintf("password.minEntropy (PASSWORD_MIN_ENTROPY) must not be negative, got %v", c.MinEntropy))
+	}
+	if c.Breached {
+		if u, err := url.Parse(c.BreachedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("password.breachedUrl (PASSWORD_BREACHED_URL) must be an http or https url, got %q", c.BreachedURL))
+		}
+	}
+	return problems
+}
+
+// passwordEntropy estimates the bits of entropy of a password from the classes of characters it
+// uses, characters repeating or continuing a sequence of the previous one add a single bit
+func passwordEntropy(password string) float64 {
+	pool := 0
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	for _, class := range []struct {
+		Used bool
+		Size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.Used {
+			pool += class.Size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	bits := 0.0
+	perChar := math.Log2(float64(pool))
+	var prev rune = -1
+	for _, r := range password {
+		if diff := r - prev; prev >= 0 && diff >= -1 && diff <= 1 {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
+
+// checkPassword returns the rules of the policy the password of the account with the email fails,
+// the email may be empty when it isn't known
+func checkPassword(ctx context.Context, password string, email string) []PasswordFailure {
+	config, denylist := currentPassword()
+	failed := []PasswordFailure{}
+
+	if len([]rune(password)) < config.MinLength {
+		failed = append(failed, PasswordFailure{RULE_MIN_LENGTH, fmt.Sprintf("Password must be at least %v characters", config.MinLength)})
+	}
+	if config.MinEntropy > 0 && passwordEntropy(password) < float64(config.MinEntropy) {
+		failed = append(failed, PasswordFailure{RULE_ENTROPY, "Password is too predictable, use a longer mix of letters, digits and symbols"})
+	}
+	if denylist[strings.ToLower(password)] {
+		failed = append(failed, PasswordFailure{RULE_DENYLIST, "Password is too common"})
+	}
+	if local := strings.ToLower(strings.SplitN(email, "@", 2)[0]); len(local) >= 3 && strings.Contains(strings.ToLower(password), local) {
+		failed = append(failed, PasswordFailure{RULE_EMAIL, "Password must not contain your email"})
+	}
+
+	// Breached passwords are only looked up once the local rules pass
+	if config.Breached && len(failed) == 0 {
+		breached, err := passwordBreached(ctx, config.BreachedURL, password)
+		if err != nil {
+			logger.Warning("unable to check breached passwords, accepting password: %v", err)
+		} else if breached {
+			failed = append(failed, PasswordFailure{RULE_BREACHED, "Password appears in a known data breach"})
+		}
+	}
+	return failed
+}
+
+// passwordBreached reports whether the range API lists the SHA-1 hash of the password
+// only the first PASSWORD_PREFIX_LENGTH characters of the hash are sent
+func passwordBreached(ctx context.Context, rangeURL string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:PASSWORD_PREFIX_LENGTH], hash[PASSWORD_PREFIX_LENGTH:]
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rangeURL+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padded responses hide the number of suffixes sharing the prefix
+	req.Header.Set("Add-Padding", "true")
+	resp, err := breachedClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("range API responded %v", resp.StatusCode)
+	}
+
+	// Each line is a suffix and its count, padding suffixes have a count of 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], suffix) && strings.TrimSpace(parts[1]) != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// enforcePassword checks the password against the policy and writes a 400 response listing the
+// failed rules, it returns false if the password was refused
+func enforcePassword(w http.ResponseWriter, req *http.Request, password string, email string) bool {
+	failed := checkPassword(req.Context(), password, email)
+	if len(failed) == 0 {
+		return true
+	}
+
+	logger.Error("password fails %v rules of the policy sending 400", len(failed))
+	js, err := json.Marshal(PasswordPolicyResp{Error: "400 - Password does not meet the password policy", Failed: failed})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Password does not meet the password policy"))
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(js)
+	return false
+}
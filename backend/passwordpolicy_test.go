@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckPassword ensures every failed rule of the policy is reported
+func TestCheckPassword(t *testing.T) {
+	config, denylist := currentPassword()
+	defer func() {
+		passwordConfigLock.Lock()
+		passwordConfig, passwordDenylist = config, denylist
+		passwordConfigLock.Unlock()
+	}()
+
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	ioutil.WriteFile(path, []byte("Blue-Heron-Canvas-42\n\n"), 0600)
+	err := configurePassword(PasswordConfig{MinLength: 8, MinEntropy: 30, Denylist: path})
+	if err != nil {
+		t.Fatalf("failed to configure policy: %v", err)
+	}
+
+	tt := []struct {
+		Password string
+		Email    string
+		Expected []string
+	}{
+		{"a", "", []string{RULE_MIN_LENGTH, RULE_ENTROPY}},
+		{"aaaaaaaaaaaa", "", []string{RULE_ENTROPY}},
+		{"abcdefghijkl", "", []string{RULE_ENTROPY}},
+		{"Password1", "", []string{RULE_DENYLIST}},
+		{"blue-heron-canvas-42", "", []string{RULE_DENYLIST}},
+		{"Quiet-Lantern-Orbit-7", "", nil},
+		{"Quiet-Lantern-Orbit-7", "lantern@mail.com", []string{RULE_EMAIL}},
+		{"Quiet-Lantern-Orbit-7", "jo@mail.com", nil},
+	}
+
+	for _, tc := range tt {
+		rules := []string{}
+		for _, failure := range checkPassword(context.Background(), tc.Password, tc.Email) {
+			rules = append(rules, failure.Rule)
+		}
+		if fmt.Sprint(rules) != fmt.Sprint(tc.Expected) && !(len(rules) == 0 && len(tc.Expected) == 0) {
+			t.Errorf("wrong failed rules for %q: got %v want %v", tc.Password, rules, tc.Expected)
+		}
+	}
+
+	if err := configurePassword(PasswordConfig{MinLength: 8, Denylist: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Errorf("expected error for missing denylist")
+	}
+}
+
+// TestPasswordBreached ensures breached passwords are found by the suffix of their hash without
+// sending the password, and that the policy accepts passwords when the range API is unreachable
+func TestPasswordBreached(t *testing.T) {
+	config, denylist := currentPassword()
+	defer func() {
+		passwordConfigLock.Lock()
+		passwordConfig, passwordDenylist = config, denylist
+		passwordConfigLock.Unlock()
+	}()
+
+	sum := sha1.Sum([]byte("Quiet-Lantern-Orbit-7"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		prefix := strings.TrimPrefix(req.URL.Path, "/range/")
+		if len(prefix) != PASSWORD_PREFIX_LENGTH || req.Header.Get("Add-Padding") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "0000000000000000000000000000000000A:0\r\n")
+		if prefix == hash[:PASSWORD_PREFIX_LENGTH] {
+			fmt.Fprintf(w, "%s:12\r\n", hash[PASSWORD_PREFIX_LENGTH:])
+		}
+	}))
+	defer server.Close()
+
+	configurePassword(PasswordConfig{MinLength: 8, Breached: true, BreachedURL: server.URL + "/range/"})
+	failed := checkPassword(context.Background(), "Quiet-Lantern-Orbit-7", "")
+	if len(failed) != 1 || failed[0].Rule != RULE_BREACHED {
+		t.Errorf("expected breached password to be refused: got %v", failed)
+	}
+	if failed := checkPassword(context.Background(), "Amber-Falcon-Meadow-9", ""); len(failed) != 0 {
+		t.Errorf("expected password to be accepted: got %v", failed)
+	}
+
+	server.Close()
+	if failed := checkPassword(context.Background(), "Quiet-Lantern-Orbit-7", ""); len(failed) != 0 {
+		t.Errorf("expected password to be accepted when the range API is unreachable: got %v", failed)
+	}
+}
+
+// TestEnforcePassword ensures refused passwords are answered with the failed rules
+func TestEnforcePassword(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/register", nil)
+	if enforcePassword(rr, req, "a", "") || rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected password to be refused: got %v", rr.Code)
+	}
+	body := rr.Body.String()
+	if rr.Header().Get("Content-Type") != "application/json" || !strings.Contains(body, `"rule":"minLength"`) || !strings.Contains(body, `"error":"400 - Password does not meet the password policy"`) {
+		t.Errorf("wrong response body: got %s", body)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+/*
+	This file defines the image processing pipeline. Processors are registered by name
+	and assembled into an ordered pipeline from the IMAGE_PIPELINE environment variable.
+	Uploaded images are queued and processed by background workers so new processing
+	steps can be added without modifying the upload handlers.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	IMAGE_PIPELINE   = "orient,thumbnail,phash" // Default if IMAGE_PIPELINE env variable is not defined
+	PIPELINE_WORKERS = 2                        // Number of workers processing the upload queue
+	PIPELINE_QUEUE   = 256                      // Number of uploads that may wait for processing
+	PIPELINE_TIMEOUT = 30                       // Seconds allowed to process a single image
+
+	THUMB_DIR  = "thumb" // Sub directory of the user image directory holding thumbnails
+	THUMB_SIZE = 256     // Max width and height of generated thumbnails
+)
+
+// Processor is a single step of the image processing pipeline
+type Processor interface {
+	Name() string
+	Process(ctx context.Context, image *Image) error
+}
+
+// Pipeline runs its processors in order over an image
+type Pipeline struct {
+	processors []Processor
+}
+
+// processors holds every processor that may be named in IMAGE_PIPELINE
+var processors = map[string]Processor{}
+
+// uploadQueue feeds newly uploaded images to the pipeline workers
+// it is nil until startPipeline is called
+var uploadQueue chan Image
+
+func init() {
+	RegisterProcessor(thumbnailProcessor{})
+}
+
+// RegisterProcessor makes a processor available to the pipeline under its name
+func RegisterProcessor(processor Processor) {
+	processors[processor.Name()] = processor
+}
+
+// NewPipeline assembles a pipeline from registered processor names in the order provided
+func NewPipeline(names []string) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		processor, ok := processors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown image processor %q", name)
+		}
+		pipeline.processors = append(pipeline.processors, processor)
+	}
+
+	return pipeline, nil
+}
+
+// Run applies each processor to the image in order stopping at the first failure
+func (p *Pipeline) Run(ctx context.Context, image *Image) error {
+	for _, processor := range p.processors {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pipeline cancelled before %s: %v", processor.Name(), err)
+		}
+		if err := processor.Process(ctx, image); err != nil {
+			return fmt.Errorf("processor %s failed: %v", processor.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// startPipeline builds the pipeline defined by the IMAGE_PIPELINE environment variable
+// and starts the workers that process queued uploads
+func startPipeline() error {
+	names := os.Getenv("IMAGE_PIPELINE")
+	if len(names) == 0 {
+		names = IMAGE_PIPELINE
+	}
+
+	pipeline, err := NewPipeline(strings.Split(names, ","))
+	if err != nil {
+		return fmt.Errorf("failed to build image pipeline: %v", err)
+	}
+
+	uploadQueue = make(chan Image, PIPELINE_QUEUE)
+	for i := 0; i < PIPELINE_WORKERS; i++ {
+		go pipelineWorker(pipeline, uploadQueue)
+	}
+
+	logger.Info("Image pipeline started with processors: %v", names)
+	return nil
+}
+
+// pipelineWorker processes images from the queue until it is closed
+func pipelineWorker(pipeline *Pipeline, queue chan Image) {
+	for image := range queue {
+		ctx, cancel := context.WithTimeout(context.Background(), PIPELINE_TIMEOUT*time.Second)
+		err := pipeline.Run(ctx, &image)
+		cancel()
+		if err != nil {
+			logger.Error("failed to process image %v: %v", image.Id, err)
+			continue
+		}
+		logger.Info("Successfully processed image: %v", image.Id)
+	}
+}
+
+// enqueueImage submits an image for processing without blocking the caller
+// images are dropped when the pipeline isn't running or the queue is full
+func enqueueImage(image Image) {
+	if uploadQueue == nil {
+		return
+	}
+
+	select {
+	case uploadQueue <- image:
+	default:
+		logger.Warning("image pipeline queue full, skipping processing for image %v", image.Id)
+	}
+}
+
+// thumbnailProcessor writes a downscaled copy of the image that fits within THUMB_SIZE
+type thumbnailProcessor struct{}
+
+func (thumbnailProcessor) Name() string {
+	return "thumbnail"
+}
+
+func (thumbnailProcessor) Process(ctx context.Context, img *Image) error {
+	// Thumbnails are written in the encoding of the image
+	if !canDecode(img.Encoding) || !canEncode(img.Encoding) {
+		return nil
+	}
+
+	file, err := storage.Open(ctx, imageKey(*img))
+	if err != nil {
+		return fmt.Errorf("failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	thumb := scaleImage(src, THUMB_SIZE, THUMB_SIZE)
+	buf := new(bytes.Buffer)
+	err = encodeImage(buf, thumb, img.Encoding)
+	if err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+
+	return storage.Put(ctx, thumbKey(*img), buf, int64(buf.Len()), img.Encoding)
+}
+
+// scaleImage downscales the image to fit within the width and height preserving its aspect ratio
+// each destination pixel averages the block of source pixels it covers
+func scaleImage(src image.Image, width int, height int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	// Images that already fit are returned as is
+	if srcW <= width && srcH <= height {
+		return src
+	}
+
+	// Fit the limiting dimension and scale the other to preserve aspect ratio
+	dstW, dstH := width, srcH*width/srcW
+	if dstH > height {
+		dstW, dstH = srcW*height/srcH, height
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := bounds.Min.Y + (y+1)*srcH/dstH
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := bounds.Min.X + (x+1)*srcW/dstW
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
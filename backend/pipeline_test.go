@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// recordProcessor appends its name to a shared log when run and fails when configured to
+type recordProcessor struct {
+	name string
+	fail bool
+	log  *[]string
+}
+
+func (p recordProcessor) Name() string {
+	return p.name
+}
+
+func (p recordProcessor) Process(ctx context.Context, img *Image) error {
+	*p.log = append(*p.log, p.name)
+	if p.fail {
+		return fmt.Errorf("%s failed", p.name)
+	}
+	return nil
+}
+
+// TestPipeline ensures processors run in the configured order and stop at the first failure
+func TestPipeline(t *testing.T) {
+	log := []string{}
+	for _, processor := range []recordProcessor{{name: "first"}, {name: "second", fail: true}, {name: "third"}} {
+		processor.log = &log
+		RegisterProcessor(processor)
+		name := processor.name
+		t.Cleanup(func() { delete(processors, name) })
+	}
+
+	_, err := NewPipeline([]string{"first", "missing"})
+	if err == nil {
+		t.Errorf("expected error for unknown processor")
+	}
+
+	pipeline, err := NewPipeline([]string{"third", " first", ""})
+	if err != nil {
+		t.Fatalf("failed to build pipeline: %v", err)
+	}
+	err = pipeline.Run(context.Background(), &Image{})
+	if err != nil {
+		t.Errorf("unexpected pipeline failure: %v", err)
+	}
+	if !reflect.DeepEqual(log, []string{"third", "first"}) {
+		t.Errorf("wrong processor order: got %v", log)
+	}
+
+	log = log[:0]
+	pipeline, _ = NewPipeline([]string{"first", "second", "third"})
+	err = pipeline.Run(context.Background(), &Image{})
+	if err == nil {
+		t.Errorf("expected pipeline failure")
+	}
+	if !reflect.DeepEqual(log, []string{"first", "second"}) {
+		t.Errorf("pipeline continued after failure: got %v", log)
+	}
+}
+
+// TestScaleImage ensures scaled images fit within the bounds and keep their aspect ratio
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	if got := scaleImage(src, 256, 256).Bounds(); got.Dx() != 256 || got.Dy() != 128 {
+		t.Errorf("wrong scaled bounds: got %v", got)
+	}
+
+	src = image.NewRGBA(image.Rect(0, 0, 100, 400))
+	if got := scaleImage(src, 256, 256).Bounds(); got.Dx() != 64 || got.Dy() != 256 {
+		t.Errorf("wrong scaled bounds: got %v", got)
+	}
+
+	src = image.NewRGBA(image.Rect(0, 0, 10, 10))
+	if got := scaleImage(src, 256, 256); got != image.Image(src) {
+		t.Errorf("small image should not be scaled")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/inflowml/logger"
+)
+
+const (
+	UPLOAD_POLICY_SUBJECT = "upload-policy" // Subject claim identifying upload policy tokens
+	UPLOAD_POLICY_TTL     = 15              // Minutes an upload policy remains valid
+	UPLOAD_FORM_OVERHEAD  = 64 << 10        // Allowance for multipart boundaries and fields beyond the file
+
+	MAX_UPLOAD_BYTES = 10 << 20 // Default if MAX_UPLOAD_BYTES env variable is not defined
+)
+
+// UploadPolicyReq are the optional constraints a client may request for an upload policy
+// constraints are clamped to the server limits
+type UploadPolicyReq struct {
+	MaxBytes int64    `json:"maxBytes"`
+	Types    []string `json:"types"`
+}
+
+// UploadPolicyResp describes a signed upload policy and the url it can be redeemed at
+type UploadPolicyResp struct {
+	Url        string    `json:"url"`
+	Policy     string    `json:"policy"`
+	MaxBytes   int64     `json:"maxBytes"`
+	Types      []string  `json:"types"`
+	Expiration Timestamp `json:"expiration"`
+}
+
+// UploadPolicyClaims are the constraints embedded in a signed upload policy
+// these are enforced when the upload is finalized
+type UploadPolicyClaims struct {
+	Owner    int      `json:"owner"`
+	MaxBytes int64    `json:"maxBytes"`
+	Types    []string `json:"types"`
+	jwt.StandardClaims
+}
+
+// issueUploadPolicy generates a signed upload policy for the authenticated user
+// that allows a direct upload without the user's auth token
+func issueUploadPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for upload policy sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	// Requested constraints are optional, an empty body receives the server limits
+	policyReq := UploadPolicyReq{}
+	err = json.NewDecoder(req.Body).Decode(&policyReq)
+	if err != nil && err != io.EOF {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	// Clamp requested size to the server limit
+	maxBytes := getMaxUploadBytes()
+	if policyReq.MaxBytes > 0 && policyReq.MaxBytes < maxBytes {
+		maxBytes = policyReq.MaxBytes
+	}
+
+	// Only allow types the server accepts
+	accepted := acceptedTypes()
+	types := accepted
+	if len(policyReq.Types) > 0 {
+		types = []string{}
+		for _, typ := range policyReq.Types {
+			if !containsString(accepted, typ) {
+				logger.Error("requested policy type %s not accepted sending 400", typ)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("400 - Unsupported type %s, accepted types are %s", typ, strings.Join(accepted, ", "))))
+				return
+			}
+			types = append(types, typ)
+		}
+	}
+
+	policy, exp, err := generateUploadPolicy(claims.Uid, maxBytes, types)
+	if err != nil {
+		logger.Error("failed to generate upload policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to generate upload policy, try again later"))
+		return
+	}
+
+	// Get REF_URL
+	refUrl := os.Getenv("REF_URL")
+	if len(refUrl) == 0 {
+		refUrl = REF_URL
+	}
+
+	policyResp := UploadPolicyResp{
+		Url:        fmt.Sprintf("%s%s/image/upload?policy=%s", refUrl, versionPrefix(req), policy),
+		Policy:     policy,
+		MaxBytes:   maxBytes,
+		Types:      types,
+		Expiration: Timestamp(time.Unix(exp, 0)),
+	}
+
+	js, err := json.Marshal(policyResp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return
+}
+
+// policyUpload accepts multipart form-data in the same format as addImage authorized
+// by an upload policy rather than the user's jwt. The policy constraints are verified
+// against the uploaded file before it is stored
+func (s *Server) policyUpload(w http.ResponseWriter, req *http.Request) {
+	policy, err := parseUploadPolicy(req.URL.Query().Get("policy"))
+	if err != nil {
+		logger.Error("Unauthorized upload policy sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, upload policy is invalid or expired"))
+		return
+	}
+
+	// Validate Content-Type of the request
+	contentType := req.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		logger.Error("request content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to upload, please use multipart form data with a supported image type"))
+		return
+	}
+
+	// Refuse to read bodies that could not satisfy the policy
+	req.Body = http.MaxBytesReader(w, req.Body, policy.MaxBytes+UPLOAD_FORM_OVERHEAD)
+
+	// attempt to retrieve file from form
+	img, imgHeader, err := req.FormFile("image")
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			logger.Error("upload exceeds policy size sending 413: %v", err)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("413 - Upload exceeds the policy limit of %v bytes", policy.MaxBytes)))
+			return
+		}
+		logger.Error("failed to read file sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to read file, ensure the image is attached as the image field"))
+		return
+	}
+	defer img.Close()
+
+	if imgHeader.Size > policy.MaxBytes {
+		logger.Error("upload of %v bytes exceeds policy size sending 413", imgHeader.Size)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("413 - Upload exceeds the policy limit of %v bytes", policy.MaxBytes)))
+		return
+	}
+
+	// Policy types are revalidated in case the server configuration changed after issue
+	accepted := []string{}
+	for _, typ := range policy.Types {
+		if containsString(acceptedTypes(), typ) {
+			accepted = append(accepted, typ)
+		}
+	}
+
+	// Tags are optional and provided as a comma separated list
+	tags, err := parseTags(req.FormValue("tags"))
+	if err != nil {
+		logger.Error("invalid tags sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	imageData, err := s.saveImage(req.Context(), policy.Owner, img, imgHeader, req.FormValue("title"), uploadText(req), req.FormValue("shareable"), req.FormValue("dedup"), tags, accepted)
+	if err != nil {
+		logger.Error("failed to save image: %v", err)
+		writeUploadError(w, err)
+		return
+	}
+	recordAudit(req, policy.Owner, AUDIT_UPLOAD, imageData.Id)
+
+	// marshal response in json
+	js, err := json.Marshal(imageData)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	logger.Info("Successfully uploaded via policy (Title: %v - Size: %v - Type: %v)", imageData.Title, imageData.Size, imageData.Encoding)
+	return
+}
+
+// generateUploadPolicy signs the upload constraints for the provided owner
+// and returns the policy token and its expiration
+func generateUploadPolicy(uid int, maxBytes int64, types []string) (string, int64, error) {
+
+	exp := time.Now().Add(time.Minute * UPLOAD_POLICY_TTL).Unix()
+
+	claims := &UploadPolicyClaims{
+		Owner:    uid,
+		MaxBytes: maxBytes,
+		Types:    types,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: exp,
+			Subject:   UPLOAD_POLICY_SUBJECT,
+		},
+	}
+
+	tokenStr, err := signToken(claims)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign upload policy: %v", err)
+	}
+
+	return tokenStr, exp, nil
+}
+
+// parseUploadPolicy verifies the signature and expiry of an upload policy and returns its constraints
+func parseUploadPolicy(policy string) (UploadPolicyClaims, error) {
+
+	claims := &UploadPolicyClaims{}
+
+	token, err := jwt.ParseWithClaims(policy, claims, verificationKey)
+	if err != nil || !token.Valid {
+		return UploadPolicyClaims{}, fmt.Errorf("failed to parse upload policy/invalid policy")
+	}
+
+	// Ensure auth tokens can't be redeemed as upload policies
+	if claims.Subject != UPLOAD_POLICY_SUBJECT || claims.Owner == 0 || claims.MaxBytes <= 0 {
+		return UploadPolicyClaims{}, fmt.Errorf("token is not an upload policy")
+	}
+
+	return *claims, nil
+}
+
+// getMaxUploadBytes retrieves the maximum upload size from the MAX_UPLOAD_BYTES environment variable
+func getMaxUploadBytes() int64 {
+	maxBytes, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_BYTES"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = MAX_UPLOAD_BYTES
+	}
+
+	return maxBytes
+}
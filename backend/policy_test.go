@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestUploadPolicy issues an upload policy and ensures its constraints are enforced when redeemed
+// None of the evaluated requests reach the database
+func TestUploadPolicy(t *testing.T) {
+	router := configureRoutes()
+
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	// Unsupported types are refused when issuing
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/image/policy", bytes.NewBufferString(`{"types":["image/tiff"]}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Issue a small png only policy
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/image/policy", bytes.NewBufferString(`{"maxBytes":100,"types":["image/png"]}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong code: got %v want %v", status, http.StatusOK)
+	}
+
+	policyResp := UploadPolicyResp{}
+	err = json.Unmarshal(rr.Body.Bytes(), &policyResp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if policyResp.MaxBytes != 100 || len(policyResp.Types) != 1 || policyResp.Types[0] != "image/png" {
+		t.Errorf("wrong policy constraints: got %v", policyResp)
+	}
+
+	// Oversized uploads are rejected
+	rr = httptest.NewRecorder()
+	req = policyUploadRequest(t, policyResp.Policy, testImage(t, "png", 64))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong code for oversized upload: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+
+	// Types outside of the policy are rejected
+	policy, _, err := generateUploadPolicy(1, MAX_UPLOAD_BYTES, []string{"image/png"})
+	if err != nil {
+		t.Fatalf("failed to generate upload policy: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	req = policyUploadRequest(t, policy, testImage(t, "jpeg", 8))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong code for disallowed type: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Auth tokens can't be redeemed as policies
+	rr = httptest.NewRecorder()
+	req = policyUploadRequest(t, token, testImage(t, "png", 8))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong code for auth token policy: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	// Policies can't be used as auth tokens
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/image/policy", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", policy))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong code for policy auth: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+// policyUploadRequest prepares a multipart upload of the provided image redeeming the policy
+func policyUploadRequest(t *testing.T, policy string, img []byte) *http.Request {
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	part, err := writer.CreateFormFile("image", "upload")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(img)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/image/upload?policy=%s", policy), form)
+	if err != nil {
+		t.Fatalf("failed to generate request with form data: %v", err)
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// testImage encodes a noisy square image of the provided size as png or jpeg
+func testImage(t *testing.T, format string, size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 31), uint8(y * 17), uint8(x * y), 255})
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, img, nil)
+	} else {
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
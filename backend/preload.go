@@ -0,0 +1,84 @@
+package main
+
+/*
+	This file adds preload hints for the thumbnails of galleries so browsers start fetching the
+	first images while the gallery is still being rendered. The first page of image metadata and the
+	albums of the user list their first thumbnails in Link rel=preload headers, and may also send
+	them in a 103 Early Hints response ahead of the final response. Hints are disabled unless the
+	PRELOAD_HINTS environment variable enables them. Public albums are not hinted as their images
+	are served at full size.
+*/
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	PRELOAD_NONE  = "none"  // No hints, the default
+	PRELOAD_LINK  = "link"  // Link headers on the response
+	PRELOAD_EARLY = "early" // Link headers on the response and a 103 Early Hints response
+
+	PRELOAD_LIMIT = 12 // Thumbnails hinted per response
+)
+
+// getPreloadMode returns how thumbnails are hinted defined by the PRELOAD_HINTS environment variable
+func getPreloadMode() string {
+	switch mode := os.Getenv("PRELOAD_HINTS"); mode {
+	case PRELOAD_LINK, PRELOAD_EARLY:
+		return mode
+	case "", PRELOAD_NONE:
+		return PRELOAD_NONE
+	default:
+		logger.Error("invalid PRELOAD_HINTS %q, thumbnails are not hinted", mode)
+		return PRELOAD_NONE
+	}
+}
+
+// preloadTarget returns the url of the thumbnail of the image for a Link header, refs without a
+// scheme are reduced to their path so they are resolved against the host of the api
+func preloadTarget(image Image) string {
+	target := thumbnailUrl(image.Ref)
+	parsed, err := url.Parse(target)
+	if err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		return target
+	}
+	if i := strings.Index(target, "/"); i >= 0 {
+		return target[i:]
+	}
+	return "/" + target
+}
+
+// preloadLinks returns the Link header values preloading the thumbnails of the first images
+func preloadLinks(images []Image) []string {
+	links := []string{}
+	for i, image := range images {
+		if i == PRELOAD_LIMIT {
+			break
+		}
+		links = append(links, "<"+preloadTarget(image)+">; rel=preload; as=image")
+	}
+	return links
+}
+
+// hintPreloads adds Link headers preloading the thumbnails of the images to the response, sending
+// them as early hints first when enabled. It must be called before the response is written
+func hintPreloads(w http.ResponseWriter, req *http.Request, images []Image) {
+	mode := getPreloadMode()
+	if mode == PRELOAD_NONE || req.Method != "GET" || len(images) == 0 {
+		return
+	}
+
+	for _, link := range preloadLinks(images) {
+		w.Header().Add("Link", link)
+	}
+
+	// Informational responses are only understood by HTTP/1.1 and later clients
+	if mode == PRELOAD_EARLY && req.ProtoAtLeast(1, 1) {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestPreloadLinks ensures the first thumbnails are hinted with refs reduced to paths unless they have a scheme
+func TestPreloadLinks(t *testing.T) {
+	images := []Image{}
+	for i := 1; i <= PRELOAD_LIMIT+3; i++ {
+		images = append(images, Image{Ref: fmt.Sprintf("%s/image/7/%v.png", REF_URL, i)})
+	}
+
+	links := preloadLinks(images)
+	if len(links) != PRELOAD_LIMIT {
+		t.Fatalf("wrong number of links: got %v want %v", len(links), PRELOAD_LIMIT)
+	}
+	if want := fmt.Sprintf("</image/7/1.png?w=%v&h=%v>; rel=preload; as=image", THUMB_SIZE, THUMB_SIZE); links[0] != want {
+		t.Errorf("wrong link: got %q want %q", links[0], want)
+	}
+	if target := preloadTarget(Image{Ref: "https://cdn.example.com/image/7/1.png"}); target != fmt.Sprintf("https://cdn.example.com/image/7/1.png?w=%v&h=%v", THUMB_SIZE, THUMB_SIZE) {
+		t.Errorf("wrong target of absolute ref: got %q", target)
+	}
+}
+
+// TestHintPreloads ensures hints are only sent when enabled, as early hints ahead of the response in early mode
+func TestHintPreloads(t *testing.T) {
+	defer os.Unsetenv("PRELOAD_HINTS")
+	images := []Image{{Ref: REF_URL + "/image/7/1.png"}, {Ref: REF_URL + "/image/7/2.png"}}
+
+	for mode, want := range map[string]int{"": 0, PRELOAD_NONE: 0, "bogus": 0, PRELOAD_LINK: 2} {
+		os.Setenv("PRELOAD_HINTS", mode)
+		rr := httptest.NewRecorder()
+		hintPreloads(rr, httptest.NewRequest("GET", "/image/meta", nil), images)
+		if got := len(rr.Header().Values("Link")); got != want || rr.Code != http.StatusOK {
+			t.Errorf("wrong hints with %q: got %v links and %v want %v", mode, got, rr.Code, want)
+		}
+	}
+
+	os.Setenv("PRELOAD_HINTS", PRELOAD_EARLY)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hintPreloads(w, req, images)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	var early []int
+	var earlyLinks []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			early = append(early, code)
+			earlyLinks = header.Values("Link")
+			return nil
+		},
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		t.Fatalf("failed to request: %v", err)
+	}
+	resp.Body.Close()
+	if !reflect.DeepEqual(early, []int{http.StatusEarlyHints}) || len(earlyLinks) != 2 {
+		t.Errorf("wrong early hints: got %v %v", early, earlyLinks)
+	}
+	if resp.StatusCode != http.StatusOK || !reflect.DeepEqual(resp.Header.Values("Link"), earlyLinks) {
+		t.Errorf("expected final response to repeat the hints: got %v %v", resp.StatusCode, resp.Header.Values("Link"))
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+/*
+	This file implements the erasure of a user's content and personal data by administrators,
+	for abuse takedowns and legal erasure requests. A purge is recorded as a job and performed
+	asynchronously by an outbox handler so it survives restarts and is retried on failure.
+	The job doubles as the completion report retrieved by administrators.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	USER_PURGE_TABLE  = "user_purge"
+	PURGE_IMAGE_BATCH = 100 // Images deleted per database round trip while purging
+	PURGE_REASON_MAX  = 500
+
+	// Purge modes
+	PURGE_DELETE    = "delete"    // Remove the account entirely
+	PURGE_ANONYMIZE = "anonymize" // Keep the account id with its personal data replaced so references remain valid
+
+	// Purge job statuses
+	PURGE_PENDING  = "pending"
+	PURGE_RUNNING  = "running"
+	PURGE_COMPLETE = "complete"
+
+	// Event topics
+	EVENT_USER_PURGE = "user.purge"
+)
+
+// PurgeJob is a requested purge and its report tagged for sql serialization
+type PurgeJob struct {
+	Id          int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid         int32     `sql:"uid"`
+	Mode        string    `sql:"mode"`
+	Reason      string    `sql:"reason"`
+	RequestedBy int32     `sql:"requested_by"`
+	Status      string    `sql:"status"`
+	Images      int32     `sql:"images"` // Images deleted so far
+	Bytes       int64     `sql:"bytes"`  // Bytes of the deleted images
+	Error       string    `sql:"error"`  // Last failure, the purge is retried by the outbox
+	Created     time.Time `sql:"created"`
+	Completed   time.Time `sql:"completed"`
+}
+
+// PurgeEvent is the payload of EVENT_USER_PURGE
+type PurgeEvent struct {
+	JobId int32 `json:"jobId"`
+}
+
+// PurgeReport is the json representation of a purge job
+type PurgeReport struct {
+	Id          int32      `json:"id"`
+	Uid         int32      `json:"uid"`
+	Mode        string     `json:"mode"`
+	Reason      string     `json:"reason"`
+	RequestedBy int32      `json:"requestedBy"`
+	Status      string     `json:"status"`
+	Images      int32      `json:"images"`
+	Bytes       int64      `json:"bytes"`
+	Error       string     `json:"error,omitempty"`
+	Created     Timestamp  `json:"created"`
+	Completed   *Timestamp `json:"completed,omitempty"`
+}
+
+func init() {
+	RegisterOutboxHandler(EVENT_USER_PURGE, handlePurgeEvent)
+}
+
+// Report returns the json representation of the job
+func (j PurgeJob) Report() PurgeReport {
+	report := PurgeReport{
+		Id:          j.Id,
+		Uid:         j.Uid,
+		Mode:        j.Mode,
+		Reason:      j.Reason,
+		RequestedBy: j.RequestedBy,
+		Status:      j.Status,
+		Images:      j.Images,
+		Bytes:       j.Bytes,
+		Error:       j.Error,
+		Created:     Timestamp(j.Created),
+	}
+	if j.Status == PURGE_COMPLETE {
+		completed := Timestamp(j.Completed)
+		report.Completed = &completed
+	}
+	return report
+}
+
+// purgeUser accepts a json body with mode, delete or anonymize, and the reason for the purge
+// and schedules the erasure of every image and the personal data of the user
+func purgeUser(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to purge user: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	var body struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	if body.Mode != PURGE_DELETE && body.Mode != PURGE_ANONYMIZE {
+		logger.Error("invalid purge mode %q sending 400", body.Mode)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - mode must be %s or %s", PURGE_DELETE, PURGE_ANONYMIZE)))
+		return
+	}
+	if len(body.Reason) == 0 || len(body.Reason) > PURGE_REASON_MAX {
+		logger.Error("invalid purge reason sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - reason is required and may not exceed %v characters", PURGE_REASON_MAX)))
+		return
+	}
+
+	uid, _ := strconv.Atoi(mux.Vars(req)["uid"])
+	_, err = GetUserById(int32(uid))
+	if err != nil {
+		logger.Error("failed to retrieve user %v to purge sending 404: %v", uid, err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no user with that id"))
+		return
+	}
+
+	job := PurgeJob{
+		Uid:         int32(uid),
+		Mode:        body.Mode,
+		Reason:      body.Reason,
+		RequestedBy: int32(claims.Uid),
+		Status:      PURGE_PENDING,
+		Created:     time.Now().UTC(),
+	}
+	job.Id, err = AddPurgeJob(job)
+	if err != nil {
+		logger.Error("failed to schedule purge sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to schedule purge, try again later"))
+		return
+	}
+
+	logger.Info("Administrator %v scheduled %s purge %v of user %v", claims.Uid, job.Mode, job.Id, uid)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/purges/%v", versionPrefix(req), job.Id))
+	writePurgeReport(w, http.StatusAccepted, job)
+}
+
+// purgeStatus returns the report of the purge job with the id
+func purgeStatus(w http.ResponseWriter, req *http.Request) {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for purge status: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	job, ok, err := GetPurgeJob(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve purge sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve purge, try again later"))
+		return
+	}
+	if !ok {
+		logger.Error("purge %v not found sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no purge with that id"))
+		return
+	}
+
+	writePurgeReport(w, http.StatusOK, job)
+}
+
+// writePurgeReport writes the report of the job as the json response body
+func writePurgeReport(w http.ResponseWriter, status int, job PurgeJob) {
+	js, err := json.Marshal(job.Report())
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// handlePurgeEvent performs the purge job of the event, completed jobs are ignored
+// so redelivered events are harmless and failed purges resume where they stopped
+func handlePurgeEvent(ctx context.Context, event OutboxEvent) error {
+	var payload PurgeEvent
+	err := json.Unmarshal([]byte(event.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("invalid purge event payload: %v", err)
+	}
+
+	job, ok, err := GetPurgeJob(payload.JobId)
+	if err != nil {
+		return err
+	}
+	if !ok || job.Status == PURGE_COMPLETE {
+		return nil
+	}
+
+	job.Status = PURGE_RUNNING
+	err = runPurge(ctx, &job)
+	if err != nil {
+		job.Error = err.Error()
+	} else {
+		job.Status = PURGE_COMPLETE
+		job.Error = ""
+		job.Completed = time.Now().UTC()
+		logger.Info("Completed purge %v of user %v: %v images, %v bytes", job.Id, job.Uid, job.Images, job.Bytes)
+	}
+
+	updateErr := UpdatePurgeJob(job)
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// runPurge deletes every image of the user then deletes or anonymizes the account
+// the report counts are updated as images are deleted
+func runPurge(ctx context.Context, job *PurgeJob) error {
+	// Uploads the user queued are discarded so they aren't saved after their images are deleted
+	err := discardUserIntake(ctx, job.Uid)
+	if err != nil {
+		return err
+	}
+
+	for {
+		images, err := UserImageBatch(job.Uid, PURGE_IMAGE_BATCH)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, image := range images {
+			err = DeleteImageData(ctx, image)
+			if err != nil {
+				return fmt.Errorf("failed to delete image %v: %v", image.Id, err)
+			}
+			removeImageFiles(ctx, image)
+			job.Images++
+			job.Bytes += int64(image.Size)
+		}
+	}
+
+	return PurgeUserAccount(job.Uid, job.Mode == PURGE_ANONYMIZE)
+}
+
+// anonymizedEmail returns the placeholder email of an anonymized user, unique per user and undeliverable
+func anonymizedEmail(uid int32) string {
+	return fmt.Sprintf("deleted-%v@invalid", uid)
+}
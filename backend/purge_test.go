@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPurgeUserValidation ensures purges are restricted to administrators and require a mode and reason
+// None of the evaluated requests reach the database
+func TestPurgeUserValidation(t *testing.T) {
+	router := configureRoutes()
+
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	tt := []struct {
+		Uid      int
+		Body     string
+		Expected int
+	}{
+		{1, `{"mode": "delete", "reason": "takedown"}`, http.StatusForbidden},
+		{7, `not json`, http.StatusBadRequest},
+		{7, `{"mode": "archive", "reason": "takedown"}`, http.StatusBadRequest},
+		{7, `{"mode": "delete"}`, http.StatusBadRequest},
+		{7, fmt.Sprintf(`{"mode": "anonymize", "reason": %q}`, strings.Repeat("a", PURGE_REASON_MAX+1)), http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		token, _, err := generateJWT(tc.Uid, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/admin/users/42/purge", strings.NewReader(tc.Body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for %s by %v: got %v want %v", tc.Body, tc.Uid, rr.Code, tc.Expected)
+		}
+	}
+}
+
+// TestPurgeReport ensures the completion time is only reported once a purge is complete
+func TestPurgeReport(t *testing.T) {
+	job := PurgeJob{Id: 3, Uid: 42, Mode: PURGE_DELETE, Status: PURGE_RUNNING, Images: 2, Created: time.Now()}
+	if report := job.Report(); report.Completed != nil {
+		t.Errorf("expected no completion time for running purge: got %v", report.Completed)
+	}
+
+	job.Status = PURGE_COMPLETE
+	job.Completed = time.Now()
+	report := job.Report()
+	if report.Completed == nil || time.Time(*report.Completed) != job.Completed {
+		t.Errorf("wrong completion time: got %v want %v", report.Completed, job.Completed)
+	}
+	if report.Id != 3 || report.Uid != 42 || report.Images != 2 {
+		t.Errorf("wrong report: got %+v", report)
+	}
+}
+
+// TestPurgeUser ensures an anonymizing purge removes the user's images and personal data
+// and that redelivered purge events are ignored
+func TestPurgeUser(t *testing.T) {
+	token, uid := getTestToken(t)
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+	adminToken, _, err := generateJWT(7, "admin@mail.com")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/admin/users/%v/purge", uid), strings.NewReader(`{"mode": "anonymize", "reason": "erasure request"}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("failed to schedule purge: got %v", rr.Code)
+	}
+	report := PurgeReport{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.Status != PURGE_PENDING || rr.Header().Get("Location") != fmt.Sprintf("/admin/purges/%v", report.Id) {
+		t.Fatalf("wrong scheduled purge: got %+v at %s", report, rr.Header().Get("Location"))
+	}
+
+	payload, _ := json.Marshal(PurgeEvent{JobId: report.Id})
+	for i := 0; i < 2; i++ {
+		err = handlePurgeEvent(context.Background(), OutboxEvent{Topic: EVENT_USER_PURGE, Payload: string(payload)})
+		if err != nil {
+			t.Fatalf("failed to perform purge: %v", err)
+		}
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/admin/purges/%v", report.Id), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.Status != PURGE_COMPLETE || report.Images != 1 || report.Bytes != int64(image.Size) || report.Completed == nil {
+		t.Errorf("wrong purge report: got %+v", report)
+	}
+
+	if _, err := GetImageMeta(context.Background(), image.Id); err == nil {
+		t.Errorf("expected image %v to be deleted", image.Id)
+	}
+	user, err := GetUserById(int32(uid))
+	if err != nil {
+		t.Fatalf("expected anonymized user to remain: %v", err)
+	}
+	defer DeleteUserData(user)
+	if user.Email != anonymizedEmail(int32(uid)) || len(user.Firstname) > 0 || len(user.Lastname) > 0 {
+		t.Errorf("wrong anonymized user: got %+v", user)
+	}
+	if _, err := GetUserPass(int32(uid)); err == nil {
+		t.Errorf("expected credentials of user to be deleted")
+	}
+}
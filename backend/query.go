@@ -0,0 +1,395 @@
+package main
+
+/*
+	This file maps structs tagged for structql onto parameterized SQL statements.
+	Values are always bound as statement arguments and never formatted into the SQL text,
+	conditions are built from trusted fragments using ? placeholders via whereBuilder.
+	The helpers accept a dbtx so they run against either a database handle or a transaction.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// dbtx is satisfied by *sql.DB and *sql.Tx
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// contextDB binds a database handle or transaction to a context so statements are canceled
+// when the context is done, for example when the deadline of the request expires
+type contextDB struct {
+	ctx context.Context
+	db  interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+}
+
+// withContext returns the database handle as a dbtx running every statement with the context
+func withContext(ctx context.Context, db *sql.DB) dbtx {
+	return contextDB{ctx, db}
+}
+
+func (c contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// whereBuilder joins conditions with AND binding each ? placeholder to the next argument
+type whereBuilder struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends a condition where each ? is bound, in order, to the provided arguments
+func (b *whereBuilder) add(cond string, args ...interface{}) {
+	parts := strings.Split(cond, "?")
+	if len(parts)-1 != len(args) {
+		panic(fmt.Sprintf("condition %q expects %v arguments, got %v", cond, len(parts)-1, len(args)))
+	}
+
+	bound := parts[0]
+	for i, arg := range args {
+		bound += b.bind(arg) + parts[i+1]
+	}
+	b.conds = append(b.conds, bound)
+}
+
+// bind adds an argument and returns its placeholder for use in trusted SQL such as LIMIT
+func (b *whereBuilder) bind(arg interface{}) string {
+	b.args = append(b.args, arg)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// placeholders binds each argument and returns the comma separated placeholders for use with IN
+func (b *whereBuilder) placeholders(args []interface{}) string {
+	refs := []string{}
+	for _, arg := range args {
+		refs = append(refs, b.bind(arg))
+	}
+	return strings.Join(refs, ", ")
+}
+
+// String returns the conditions joined with AND
+func (b *whereBuilder) String() string {
+	return strings.Join(b.conds, " AND ")
+}
+
+// Args returns the arguments bound to the placeholders in order
+func (b *whereBuilder) Args() []interface{} {
+	return b.args
+}
+
+// escapeLike escapes the wildcards of a value so it matches literally in a LIKE pattern escaped with backslash
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// sqlFields returns the sql column names of the object and their field indexes
+func sqlFields(template reflect.Type) ([]string, []int) {
+	cols := []string{}
+	indexes := []int{}
+	for i := 0; i < template.NumField(); i++ {
+		if col, ok := template.Field(i).Tag.Lookup("sql"); ok {
+			cols = append(cols, col)
+			indexes = append(indexes, i)
+		}
+	}
+	return cols, indexes
+}
+
+// selectWhere returns the rows of the table matching the condition as objects of the provided type
+// the condition may include ORDER BY and LIMIT clauses. An empty condition selects every row
+func selectWhere(db dbtx, object interface{}, table string, cond string, args ...interface{}) ([]interface{}, error) {
+	template := reflect.TypeOf(object)
+	if template.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type %T is not a structure", object)
+	}
+	cols, indexes := sqlFields(template)
+
+	stmt := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), table)
+	if len(cond) > 0 {
+		stmt = fmt.Sprintf("%s WHERE %s", stmt, cond)
+	}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query %q: %v", stmt, err)
+	}
+	defer rows.Close()
+
+	objects := []interface{}{}
+	for rows.Next() {
+		vessel := reflect.New(template).Elem()
+		fields := []interface{}{}
+		for _, i := range indexes {
+			fields = append(fields, vessel.Field(i).Addr().Interface())
+		}
+
+		err = rows.Scan(fields...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		objects = append(objects, vessel.Interface())
+	}
+
+	return objects, rows.Err()
+}
+
+// countWhere returns the number of rows of the table matching the condition
+func countWhere(db dbtx, table string, cond string, args ...interface{}) (int64, error) {
+	stmt := fmt.Sprintf("SELECT COUNT(*) FROM %s", table)
+	if len(cond) > 0 {
+		stmt = fmt.Sprintf("%s WHERE %s", stmt, cond)
+	}
+
+	var count int64
+	err := db.QueryRow(stmt, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows with query %q: %v", stmt, err)
+	}
+
+	return count, nil
+}
+
+// insertObject inserts the object into the table and returns the assigned id
+// SERIAL columns are assigned by the database. Returns 0 if the row conflicts with an existing row
+func insertObject(db dbtx, table string, object interface{}) (int32, error) {
+	template := reflect.TypeOf(object)
+	value := reflect.ValueOf(object)
+
+	cols := []string{}
+	refs := []string{}
+	vals := []interface{}{}
+	for i := 0; i < template.NumField(); i++ {
+		field := template.Field(i)
+		col, ok := field.Tag.Lookup("sql")
+		if !ok || strings.Contains(strings.ToUpper(field.Tag.Get("typ")), "SERIAL") {
+			continue
+		}
+		cols = append(cols, col)
+		vals = append(vals, value.Field(i).Interface())
+		refs = append(refs, fmt.Sprintf("$%d", len(vals)))
+	}
+
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING RETURNING id", table, strings.Join(cols, ", "), strings.Join(refs, ", "))
+
+	var id int32
+	err := db.QueryRow(stmt, vals...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert into %s: %v", table, err)
+	}
+
+	return id, nil
+}
+
+// updateObject updates the row of the table with the object's id to match the object
+func updateObject(db dbtx, table string, object interface{}) error {
+	template := reflect.TypeOf(object)
+	value := reflect.ValueOf(object)
+
+	sets := []string{}
+	vals := []interface{}{}
+	var id interface{}
+	for i := 0; i < template.NumField(); i++ {
+		col, ok := template.Field(i).Tag.Lookup("sql")
+		if !ok {
+			continue
+		}
+		if col == "id" {
+			id = value.Field(i).Interface()
+			continue
+		}
+		vals = append(vals, value.Field(i).Interface())
+		sets = append(sets, fmt.Sprintf("%s = $%d", col, len(vals)))
+	}
+	if id == nil {
+		return fmt.Errorf("type %T does not have an id column", object)
+	}
+	vals = append(vals, id)
+
+	stmt := fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d", table, strings.Join(sets, ", "), len(vals))
+	_, err := db.Exec(stmt, vals...)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %v", table, err)
+	}
+
+	return nil
+}
+
+// deleteObject deletes the row of the table with the object's id
+func deleteObject(db dbtx, table string, object interface{}) error {
+	template := reflect.TypeOf(object)
+	value := reflect.ValueOf(object)
+
+	for i := 0; i < template.NumField(); i++ {
+		if template.Field(i).Tag.Get("sql") == "id" {
+			_, err := deleteWhere(db, table, "id = $1", value.Field(i).Interface())
+			return err
+		}
+	}
+
+	return fmt.Errorf("type %T does not have an id column", object)
+}
+
+// deleteWhere deletes the rows of the table matching the condition and returns the number deleted
+func deleteWhere(db dbtx, table string, cond string, args ...interface{}) (int64, error) {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s", table, cond)
+	result, err := db.Exec(stmt, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete from %s: %v", table, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// withTx runs fn in a transaction committing if it succeeds and rolling back if it returns an error
+func withTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	return withTxContext(context.Background(), db, fn)
+}
+
+// withTxContext runs fn in a transaction bound to ctx, the transaction is rolled back when ctx is done
+// before it commits, for example when the deadline of the request expires
+func withTxContext(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// tableDefinition returns the statement creating the table of the object with a column for each field tagged sql
+func tableDefinition(table string, object interface{}) (string, error) {
+	template := reflect.TypeOf(object)
+	cols := []string{}
+	for i := 0; i < template.NumField(); i++ {
+		field := template.Field(i)
+		col, ok := field.Tag.Lookup("sql")
+		if !ok {
+			continue
+		}
+
+		typ, err := columnType(field)
+		if err != nil {
+			return "", err
+		}
+		cols = append(cols, strings.TrimSpace(fmt.Sprintf("%s %s %s", col, typ, field.Tag.Get("opt"))))
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(cols, ", ")), nil
+}
+
+// createTable creates the table of the object unless it exists in the schema of the connection
+func createTable(db dbtx, table string, object interface{}) error {
+	stmt, err := tableDefinition(table, object)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(stmt)
+	return err
+}
+
+// addMissingColumns adds the columns of the object that don't exist in the table and returns their names
+// tables created by an older version of the server gain new fields this way. New columns must have a
+// default in their opt tag such as NOT NULL DEFAULT 0 as existing rows can't be scanned with NULL values
+func addMissingColumns(db dbtx, table string, object interface{}) ([]string, error) {
+	rows, err := db.Query("SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve columns of %s: %v", table, err)
+	}
+	existing := []string{}
+	for rows.Next() {
+		var col string
+		if err = rows.Scan(&col); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan column: %v", err)
+		}
+		existing = append(existing, col)
+	}
+	rows.Close()
+
+	added := []string{}
+	template := reflect.TypeOf(object)
+	for i := 0; i < template.NumField(); i++ {
+		field := template.Field(i)
+		col, ok := field.Tag.Lookup("sql")
+		if !ok || containsString(existing, col) {
+			continue
+		}
+
+		typ, err := columnType(field)
+		if err != nil {
+			return added, err
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s %s", table, col, typ, field.Tag.Get("opt")))
+		if err != nil {
+			return added, fmt.Errorf("failed to add column %s to %s: %v", col, table, err)
+		}
+		added = append(added, col)
+	}
+
+	return added, nil
+}
+
+// columnType returns the PostgreSQL type of the field matching the types used by structql
+func columnType(field reflect.StructField) (string, error) {
+	if typ, ok := field.Tag.Lookup("typ"); ok {
+		return typ, nil
+	}
+
+	switch field.Type {
+	case reflect.TypeOf(false):
+		return "BOOL", nil
+	case reflect.TypeOf(int16(0)):
+		return "INT2", nil
+	case reflect.TypeOf(int32(0)), reflect.TypeOf(int(0)):
+		return "INT4", nil
+	case reflect.TypeOf(int64(0)):
+		return "INT8", nil
+	case reflect.TypeOf(float32(0)):
+		return "FLOAT4", nil
+	case reflect.TypeOf(float64(0)):
+		return "FLOAT8", nil
+	case reflect.TypeOf(""):
+		return "TEXT", nil
+	case reflect.TypeOf(time.Time{}):
+		return "TIMESTAMP", nil
+	case reflect.TypeOf([]byte{}):
+		return "BYTEA", nil
+	}
+
+	return "", fmt.Errorf("field %s has unsupported type %s", field.Name, field.Type)
+}
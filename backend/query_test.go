@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// maliciousInputs are values commonly used to escape string literals and alter queries
+var maliciousInputs = []string{
+	"' OR '1'='1",
+	"'; DROP TABLE image_meta; --",
+	"x' UNION SELECT email, email, email, email, true, email FROM user_meta --",
+	`\'; DELETE FROM user_pass; --`,
+	"100%' OR title LIKE '%",
+	"$1",
+}
+
+// TestWhereBuilder ensures placeholders are numbered in the order arguments are bound
+func TestWhereBuilder(t *testing.T) {
+	where := &whereBuilder{}
+	where.add("uid = ?", 1)
+	where.add("(title = ? OR encoding = ?)", "a", "b")
+	where.add("id IN (" + where.placeholders([]interface{}{4, 5}) + ")")
+	limit := where.bind(50)
+
+	if where.String() != "uid = $1 AND (title = $2 OR encoding = $3) AND id IN ($4, $5)" {
+		t.Errorf("wrong condition: got %s", where.String())
+	}
+	if limit != "$6" {
+		t.Errorf("wrong placeholder: got %s", limit)
+	}
+	if !reflect.DeepEqual(where.Args(), []interface{}{1, "a", "b", 4, 5, 50}) {
+		t.Errorf("wrong arguments: got %v", where.Args())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for mismatched arguments")
+		}
+	}()
+	where.add("title = ?")
+}
+
+// TestEscapeLike ensures LIKE wildcards in values match literally
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`100%_off\`); got != `100\%\_off\\` {
+		t.Errorf("wrong escaped value: got %s", got)
+	}
+}
+
+// TestImageQueryCondition ensures user supplied values are only ever bound as arguments
+func TestImageQueryCondition(t *testing.T) {
+	for _, input := range maliciousInputs {
+		params := url.Values{"title": {input}, "encoding": {input}}
+		where, err := imageQueryCondition(1, params)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", input, err)
+		}
+
+		if where.String() != NOT_TRASHED+" AND lower(title) = lower($1) AND title = $2 AND encoding = $3 AND (uid = $4 OR shareable = true OR id IN (SELECT image_id FROM image_shares WHERE uid = $5)) AND uid NOT IN (SELECT uid FROM user_blocks WHERE blocked_uid = $6)" {
+			t.Errorf("input %q altered the condition: got %s", input, where.String())
+		}
+		if !reflect.DeepEqual(where.Args(), []interface{}{input, input, input, 1, 1, 1}) {
+			t.Errorf("input %q was not bound verbatim: got %v", input, where.Args())
+		}
+	}
+
+	// Description searches bind the words to the indexed text search
+	where, err := imageQueryCondition(1, url.Values{"description": {maliciousInputs[0]}})
+	if err != nil || !strings.HasPrefix(where.String(), NOT_TRASHED+" AND "+DESCRIPTION_SEARCH+" @@ plainto_tsquery('simple', $1) AND") || where.Args()[0] != maliciousInputs[0] {
+		t.Errorf("wrong description condition: got %s %v %v", where.String(), where.Args(), err)
+	}
+
+	// Typed parameters reject anything that doesn't parse strictly
+	for _, key := range []string{"id", "uid", "shareable", "sharedWithMe"} {
+		for _, input := range append(maliciousInputs, "1 OR 1=1", "true OR 1=1") {
+			if _, err := imageQueryCondition(1, url.Values{key: {input}}); err == nil {
+				t.Errorf("expected error for %s=%q", key, input)
+			}
+		}
+	}
+
+	// Tags are validated before being bound
+	if _, err := imageQueryCondition(1, url.Values{"tags": {maliciousInputs[0]}}); err == nil {
+		t.Errorf("expected error for malicious tag")
+	}
+	if _, err := imageQueryCondition(1, url.Values{"tags": {"a"}, "tagMode": {"or 1=1"}}); err == nil {
+		t.Errorf("expected error for malicious tag mode")
+	}
+
+	// Default query lists the user's own images outside the trash
+	where, err = imageQueryCondition(7, url.Values{"page": {"2"}})
+	if err != nil || where.String() != NOT_TRASHED+" AND uid = $1" || !reflect.DeepEqual(where.Args(), []interface{}{7}) {
+		t.Errorf("wrong default condition: got %s %v %v", where.String(), where.Args(), err)
+	}
+	where, err = imageQueryCondition(7, url.Values{"sort": {"size"}, "order": {"desc"}, "pageSize": {"10"}})
+	if err != nil || where.String() != NOT_TRASHED+" AND uid = $1" {
+		t.Errorf("paging parameters filtered the default query: got %s %v", where.String(), err)
+	}
+
+	// Queries of the user's own images need no visibility condition, other owners' images do
+	where, err = imageQueryCondition(7, url.Values{"uid": {"7"}, "encoding": {"image/png"}})
+	if err != nil || where.String() != NOT_TRASHED+" AND uid = $1 AND encoding = $2" {
+		t.Errorf("wrong condition for own images: got %s %v", where.String(), err)
+	}
+	where, err = imageQueryCondition(7, url.Values{"uid": {"8"}})
+	if err != nil || !strings.Contains(where.String(), "shareable = true") || !strings.Contains(where.String(), "blocked_uid") {
+		t.Errorf("missing visibility condition for another owner's images: got %s %v", where.String(), err)
+	}
+}
+
+// TestImageQueryPlan ensures the indexes can serve image meta queries. Sequential scans are disabled
+// so the planner only falls back to them when no index applies, whatever the size of the test tables
+func TestImageQueryPlan(t *testing.T) {
+	db, err := getDB()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+
+	for _, query := range []string{
+		"",
+		"sort=date&order=desc",
+		"uid=7&sort=title",
+		"title=beach.png",
+		"uid=7&encoding=image/png",
+		"shareable=true&sort=size",
+		"tags=beach,summer",
+		"sharedWithMe=true",
+	} {
+		params, _ := url.ParseQuery(query)
+		where, err := imageQueryCondition(7, params)
+		if err != nil {
+			t.Fatalf("invalid query %q: %v", query, err)
+		}
+		page, err := parseMetaPage(params)
+		if err != nil {
+			t.Fatalf("invalid page %q: %v", query, err)
+		}
+		stmt := fmt.Sprintf("EXPLAIN SELECT id FROM %s WHERE %s", IMAGE_TABLE, page.condition(where))
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		_, err = tx.Exec("SET LOCAL enable_seqscan = off")
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("failed to disable sequential scans: %v", err)
+		}
+		rows, err := tx.Query(stmt, where.Args()...)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("failed to explain %q: %v", query, err)
+		}
+		plan := []string{}
+		for rows.Next() {
+			var line string
+			rows.Scan(&line)
+			plan = append(plan, line)
+		}
+		rows.Close()
+		tx.Rollback()
+
+		if strings.Contains(strings.Join(plan, "\n"), "Seq Scan on "+IMAGE_TABLE) {
+			t.Errorf("query %q scans every image:\n%s", query, strings.Join(plan, "\n"))
+		}
+	}
+}
+
+// TestDataSourceName ensures configuration values can't inject connection parameters
+func TestDataSourceName(t *testing.T) {
+	config := defaultConfig().Database
+	config.Password = `pass' sslmode='disable`
+	dsn := dataSourceName(config)
+	if !strings.Contains(dsn, `password='pass\' sslmode=\'disable'`) || strings.Contains(dsn, "search_path") {
+		t.Errorf("password was not quoted: got %s", dsn)
+	}
+
+	config.Schema = "picto_test"
+	if dsn := dataSourceName(config); !strings.HasSuffix(dsn, ` search_path='picto_test'`) {
+		t.Errorf("schema was not set as the search path: got %s", dsn)
+	}
+}
+
+// TestTableDefinitions ensures every table has a column type for each of its fields
+func TestTableDefinitions(t *testing.T) {
+	for _, table := range tables {
+		if _, err := tableDefinition(table.Name, table.Object); err != nil {
+			t.Errorf("failed to define %s: %v", table.Name, err)
+		}
+	}
+
+	stmt, err := tableDefinition(TAG_TABLE, ImageTag{})
+	expected := "CREATE TABLE IF NOT EXISTS image_tags (id SERIAL PRIMARY KEY, image_id INT4, tag TEXT)"
+	if err != nil || stmt != expected {
+		t.Errorf("wrong definition: got %q %v want %q", stmt, err, expected)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	USER_QUOTA = 1 << 30 // Default if USER_QUOTA env variable is not defined
+)
+
+// ErrQuotaExceeded is returned when an upload would exceed the user's storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// UserUsage holds the storage quota columns of the user_meta table tagged for sql serialization
+// it is kept apart from User so profile updates never overwrite the tracked usage
+type UserUsage struct {
+	Uid   int32 `sql:"id"`
+	Quota int64 `sql:"quota" opt:"NOT NULL DEFAULT 0"` // Bytes the user may store, 0 applies USER_QUOTA
+	Used  int64 `sql:"used_bytes" opt:"NOT NULL DEFAULT 0"`
+}
+
+// QuotaResp reports a user's storage quota and usage in bytes
+type QuotaResp struct {
+	Quota     int64 `json:"quota"`
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+// Limit returns the quota that applies to the user
+func (u UserUsage) Limit() int64 {
+	if u.Quota > 0 {
+		return u.Quota
+	}
+	return getUserQuota()
+}
+
+// getUserQuota returns the default quota defined by the USER_QUOTA environment variable
+func getUserQuota() int64 {
+	quota, err := strconv.ParseInt(os.Getenv("USER_QUOTA"), 10, 64)
+	if err != nil || quota <= 0 {
+		quota = USER_QUOTA
+	}
+	return quota
+}
+
+// userQuota returns the storage quota and current usage of the authenticated user
+func userQuota(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for quota sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	usage, err := GetUserUsage(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve usage sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve quota, try again later"))
+		return
+	}
+
+	quotaResp := QuotaResp{
+		Quota: usage.Limit(),
+		Used:  usage.Used,
+	}
+	quotaResp.Available = quotaResp.Quota - quotaResp.Used
+	if quotaResp.Available < 0 {
+		quotaResp.Available = 0
+	}
+
+	js, err := json.Marshal(quotaResp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestUserUsageLimit ensures users without a quota receive the configured default
+func TestUserUsageLimit(t *testing.T) {
+	defer os.Unsetenv("USER_QUOTA")
+
+	if limit := (UserUsage{}).Limit(); limit != USER_QUOTA {
+		t.Errorf("wrong default quota: got %v want %v", limit, USER_QUOTA)
+	}
+
+	os.Setenv("USER_QUOTA", "2048")
+	if limit := (UserUsage{}).Limit(); limit != 2048 {
+		t.Errorf("wrong configured quota: got %v want %v", limit, 2048)
+	}
+	if limit := (UserUsage{Quota: 10}).Limit(); limit != 10 {
+		t.Errorf("user quota not applied: got %v want %v", limit, 10)
+	}
+}
+
+// TestQuota uploads images until the quota is reached and ensures usage is tracked through deletes
+func TestQuota(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+
+	// Allow only one more byte than the uploaded image
+	os.Setenv("USER_QUOTA", fmt.Sprintf("%v", image.Size+1))
+	defer os.Unsetenv("USER_QUOTA")
+
+	quota := getTestQuota(t, router, token)
+	if quota.Used != int64(image.Size) || quota.Available != 1 {
+		t.Errorf("wrong usage after upload: got %+v", quota)
+	}
+
+	req := testUploadRequest(t, token, false)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong code for upload over quota: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+
+	// Trashed images keep counting until they are purged
+	req, _ = http.NewRequest("DELETE", strings.TrimPrefix(image.Ref, REF_URL), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if quota = getTestQuota(t, router, token); quota.Used != int64(image.Size) {
+		t.Errorf("wrong usage after delete: got %+v", quota)
+	}
+
+	trashed, found, err := GetTrashedImage(context.Background(), image.Id)
+	if err != nil || !found {
+		t.Fatalf("deleted image not in the trash: %v", err)
+	}
+	err = purgeImage(context.Background(), trashed)
+	if err != nil {
+		t.Fatalf("failed to purge image: %v", err)
+	}
+	if quota = getTestQuota(t, router, token); quota.Used != 0 {
+		t.Errorf("wrong usage after purge: got %+v", quota)
+	}
+}
+
+// getTestQuota retrieves the quota of the token owner
+func getTestQuota(t *testing.T, router http.Handler, token string) QuotaResp {
+	req, _ := http.NewRequest("GET", "/user/quota", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong code for quota: got %v want %v", status, http.StatusOK)
+	}
+
+	quota := QuotaResp{}
+	err := json.Unmarshal(rr.Body.Bytes(), &quota)
+	if err != nil {
+		t.Fatalf("failed to unmarshal quota: %v", err)
+	}
+	return quota
+}
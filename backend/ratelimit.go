@@ -0,0 +1,213 @@
+package main
+
+/*
+	This file protects the server from floods of requests. Every request is limited per client
+	address and, when it carries a user's token, per user with token buckets that allow short
+	bursts. Sign in and registration are limited more strictly to slow password guessing. The
+	buckets of these limits are kept in the shared state store so replicas enforce them together.
+*/
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	RATE_LIMIT      = 300 // Requests per minute from each address if RATE_LIMIT env variable is not defined
+	USER_RATE_LIMIT = 600 // Requests per minute by each user if USER_RATE_LIMIT env variable is not defined
+	AUTH_RATE_LIMIT = 10  // Sign in and registration attempts per minute from each address if AUTH_RATE_LIMIT env variable is not defined
+
+	RATE_PRUNE_INTERVAL = time.Minute // Interval between removing buckets that have refilled
+)
+
+// Limiters applied by the rateLimit middleware, a nil limiter is disabled
+var (
+	ipLimiter   = newEnvRateLimiter("RATE_LIMIT", RATE_LIMIT)
+	userLimiter = newEnvRateLimiter("USER_RATE_LIMIT", USER_RATE_LIMIT)
+	authLimiter = newEnvRateLimiter("AUTH_RATE_LIMIT", AUTH_RATE_LIMIT)
+)
+
+// rateLimiter is a token bucket per key, each bucket holds up to burst tokens and refills at rate per second.
+// Named limiters keep their buckets in the shared state store, others in the limiter
+type rateLimiter struct {
+	mu      sync.Mutex
+	name    string
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time elapsed and takes a token
+// if the bucket is empty it returns false and the time until a token is available
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) (bool, time.Duration) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// full reports whether the bucket has refilled, making it equivalent to a new bucket
+func (b *tokenBucket) full(now time.Time, rate float64, burst float64) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*rate >= burst
+}
+
+// newRateLimiter returns a limiter allowing perMinute requests per key with bursts of up to burst requests
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		pruned:  time.Now(),
+	}
+}
+
+// newSharedRateLimiter returns a limiter like newRateLimiter keeping its buckets in the shared state store under the name
+func newSharedRateLimiter(name string, perMinute int, burst int) *rateLimiter {
+	limiter := newRateLimiter(perMinute, burst)
+	limiter.name = name
+	return limiter
+}
+
+// newEnvRateLimiter returns a shared limiter allowing the requests per minute defined by the environment variable
+// with bursts of the same size. Returns nil, disabling the limit, if the variable is 0
+func newEnvRateLimiter(name string, fallback int) *rateLimiter {
+	limit, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || limit < 0 {
+		limit = fallback
+	}
+	if limit == 0 {
+		return nil
+	}
+	return newSharedRateLimiter(name, limit, limit)
+}
+
+// Allow takes a token from the bucket of the key
+// if the bucket is empty it returns false and the time until a token is available
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	if len(l.name) > 0 {
+		return takeToken("rate:"+l.name+":"+key, l.rate, l.burst)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(now, l.rate, l.burst)
+}
+
+// prune removes the buckets that have refilled since they are equivalent to new buckets
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < RATE_PRUNE_INTERVAL {
+		return
+	}
+	l.pruned = now
+
+	for key, bucket := range l.buckets {
+		if bucket.full(now, l.rate, l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit is router middleware applying the address and user rate limits
+// sign in and registration are limited by authLimiter instead of the general address limit
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Probes are made frequently from the same addresses and must never be refused
+		if req.Method == "OPTIONS" || req.URL.Path == "/healthz" || req.URL.Path == "/readyz" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ip := clientIP(req)
+		limiter := ipLimiter
+		if path := apiPath(req.URL.Path); path == "/auth" || path == "/auth/totp" || path == "/register" {
+			limiter = authLimiter
+		}
+		if limiter != nil {
+			if allowed, wait := limiter.Allow(ip); !allowed {
+				logger.Warning("address %s exceeded rate limit for %s sending 429", ip, req.URL.Path)
+				writeRateLimited(w, wait)
+				return
+			}
+		}
+
+		// Requests signed in as a user share the user's limit across addresses
+		if userLimiter != nil {
+			if uid := requestUid(req); uid != 0 {
+				if allowed, wait := userLimiter.Allow(strconv.Itoa(uid)); !allowed {
+					logger.Warning("user %v exceeded rate limit sending 429", uid)
+					writeRateLimited(w, wait)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// writeRateLimited reports that a rate limit was exceeded and when the client may retry
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("429 - Too many requests, slow down and retry later"))
+}
+
+// clientIP returns the address of the client making the request
+// behind a proxy setting TRUST_PROXY to true uses the address the proxy appended to X-Forwarded-For
+func clientIP(req *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); len(ip) > 0 {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// requestUid returns the uid of the user whose token signs the request, 0 if there is none
+// client access tokens are limited per client by authorizeClients instead
+func requestUid(req *http.Request) int {
+	tokenStr := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if cookie, err := req.Cookie(TOKEN_COOKIE); err == nil {
+		tokenStr = cookie.Value
+	}
+
+	claims, err := parseToken(tokenStr)
+	if err != nil || claims.Subject == OAUTH_TOKEN_SUBJECT {
+		return 0
+	}
+	return claims.Uid
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter ensures each key may burst up to the limit and refills at the configured rate
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(60, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("expected request %v within the burst to be allowed", i)
+		}
+	}
+
+	ok, wait := limiter.Allow("a")
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("expected request beyond the burst to wait up to a second: got %v, %v", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Errorf("expected other keys to have their own bucket")
+	}
+
+	// A second refills one token at 60 requests per minute
+	limiter.buckets["a"].updated = limiter.buckets["a"].updated.Add(-time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Errorf("expected the bucket to refill")
+	}
+
+	// Refilled buckets are pruned
+	limiter.pruned = time.Now().Add(-2 * RATE_PRUNE_INTERVAL)
+	limiter.buckets["b"].updated = time.Now().Add(-time.Minute)
+	limiter.Allow("a")
+	if _, ok := limiter.buckets["b"]; ok {
+		t.Errorf("expected refilled bucket to be pruned")
+	}
+}
+
+// TestRateLimit ensures the middleware limits addresses, users and sign in attempts independently
+func TestRateLimit(t *testing.T) {
+	defer func() { ipLimiter, userLimiter, authLimiter = nil, nil, nil }()
+	ipLimiter, userLimiter, authLimiter = newRateLimiter(3, 3), newRateLimiter(2, 2), newRateLimiter(1, 1)
+
+	token, _, err := generateJWT(42, "ratelimit@mail.com")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	router := configureRoutes()
+	serve := func(method string, path string, addr string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = addr
+		if len(token) > 0 {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tt := []struct {
+		Method   string
+		Path     string
+		Addr     string
+		Token    string
+		Expected int
+	}{
+		// Sign in is limited separately and more strictly
+		{"GET", "/auth", "10.0.0.1:1000", "", http.StatusUnauthorized},
+		{"GET", "/auth", "10.0.0.1:1001", "", http.StatusTooManyRequests},
+		{"OPTIONS", "/auth", "10.0.0.1:1002", "", http.StatusOK},
+		{"GET", "/auth", "10.0.0.2:1000", "", http.StatusUnauthorized},
+
+		// Users are limited across addresses
+		{"GET", "/ping", "10.0.0.1:1000", token, http.StatusOK},
+		{"GET", "/ping", "10.0.0.3:1000", token, http.StatusOK},
+		{"GET", "/ping", "10.0.0.4:1000", token, http.StatusTooManyRequests},
+
+		// Addresses are limited regardless of user
+		{"GET", "/ping", "10.0.0.1:1000", "", http.StatusOK},
+		{"GET", "/ping", "10.0.0.1:1000", "", http.StatusOK},
+		{"GET", "/ping", "10.0.0.1:1000", "", http.StatusTooManyRequests},
+		{"GET", "/ping", "10.0.0.5:1000", "", http.StatusOK},
+
+		// Probes are never limited
+		{"GET", "/healthz", "10.0.0.1:1000", "", http.StatusOK},
+	}
+
+	for i, tc := range tt {
+		rr := serve(tc.Method, tc.Path, tc.Addr, tc.Token)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for request %v %s %s from %s: got %v want %v", i, tc.Method, tc.Path, tc.Addr, rr.Code, tc.Expected)
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("expected Retry-After header for request %v", i)
+		}
+	}
+}
+
+// TestClientIP ensures forwarded addresses are only used when the proxy is trusted
+func TestClientIP(t *testing.T) {
+	defer os.Unsetenv("TRUST_PROXY")
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	if ip := clientIP(req); ip != "10.0.0.1" {
+		t.Errorf("wrong address without trusted proxy: got %s", ip)
+	}
+
+	os.Setenv("TRUST_PROXY", "true")
+	if ip := clientIP(req); ip != "198.51.100.7" {
+		t.Errorf("wrong address with trusted proxy: got %s", ip)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+/*
+	This file lets users rate the images they can view with 1 to 5 stars. Each user holds a single
+	rating of an image which is replaced when they rate it again. The metadata of an image reports
+	the average of its ratings and how many users rated it, and image meta queries sort by the
+	average with sort=rating, unrated images averaging 0.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	RATING_TABLE = "image_rating"
+
+	RATING_MIN = 1 // Fewest stars of a rating
+	RATING_MAX = 5 // Most stars of a rating
+)
+
+// ratingAverage is the sql expression averaging the ratings of the image of the row, 0 when it has none
+const ratingAverage = "(SELECT COALESCE(AVG(stars), 0) FROM " + RATING_TABLE + " WHERE image_id = " + IMAGE_TABLE + ".id)"
+
+// ImageRating is the rating a user gave an image tagged for sql serialization
+type ImageRating struct {
+	Id      int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId int32     `sql:"image_id"`
+	Uid     int32     `sql:"uid"`
+	Stars   int32     `sql:"stars"`
+	Rated   time.Time `sql:"rated"`
+}
+
+// RatingReq is the body of a rating
+type RatingReq struct {
+	Stars int32 `json:"stars"`
+}
+
+// RatingResp reports the rating of the user and the aggregate rating of the image
+type RatingResp struct {
+	ImageId     int32   `json:"imageId"`
+	Stars       int32   `json:"stars"`       // Rating of the user
+	Rating      float64 `json:"rating"`      // Average rating of the image
+	RatingCount int64   `json:"ratingCount"` // Users who rated the image
+}
+
+// RatingSummary is the aggregate rating of an image
+type RatingSummary struct {
+	Average float64
+	Count   int64
+}
+
+// rateImage records the stars in the json body as the authenticated user's rating of the image in the url,
+// replacing an earlier rating
+func (s *Server) rateImage(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to rate image sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	image, ok := s.viewableImage(w, req, claims.Uid)
+	if !ok {
+		return
+	}
+
+	var ratingReq RatingReq
+	err = json.NewDecoder(req.Body).Decode(&ratingReq)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	if ratingReq.Stars < RATING_MIN || ratingReq.Stars > RATING_MAX {
+		logger.Error("invalid rating of %v stars sending 400", ratingReq.Stars)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - stars must be between %v and %v", RATING_MIN, RATING_MAX)))
+		return
+	}
+
+	err = SetImageRating(ImageRating{ImageId: image.Id, Uid: int32(claims.Uid), Stars: ratingReq.Stars, Rated: time.Now().UTC()})
+	if err != nil {
+		logger.Error("failed to rate image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to rate image, try again later"))
+		return
+	}
+
+	summaries, err := ImageRatingSummaries([]int32{image.Id})
+	if err != nil {
+		logger.Error("failed to retrieve ratings sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve ratings, try again later"))
+		return
+	}
+
+	summary := summaries[image.Id]
+	js, err := json.Marshal(RatingResp{ImageId: image.Id, Stars: ratingReq.Stars, Rating: summary.Average, RatingCount: summary.Count})
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRatings ensures viewers rate images once with 1 to 5 stars and images sort by their average rating
+func TestRatings(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "rater")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	private := uploadTestImage(t, router, token, false)
+	public := uploadTestImage(t, router, token, true)
+	unrated := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), private)
+	defer DeleteImageData(context.Background(), public)
+	defer DeleteImageData(context.Background(), unrated)
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rate := func(image Image, stars int, token string) (int, RatingResp) {
+		rr := send("POST", strings.TrimPrefix(image.Ref, REF_URL)+"/rating", fmt.Sprintf(`{"stars": %v}`, stars), token)
+		resp := RatingResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	if code, _ := rate(private, 4, friendToken); code != http.StatusNotFound {
+		t.Errorf("wrong code for rating a private image: got %v want %v", code, http.StatusNotFound)
+	}
+	for _, stars := range []int{0, 6} {
+		if code, _ := rate(public, stars, friendToken); code != http.StatusBadRequest {
+			t.Errorf("wrong code for %v stars: got %v want %v", stars, code, http.StatusBadRequest)
+		}
+	}
+
+	if code, resp := rate(public, 2, friendToken); code != http.StatusOK || resp.Stars != 2 || resp.RatingCount != 1 {
+		t.Errorf("failed to rate image: got %v %+v", code, resp)
+	}
+	// Rating again replaces the earlier rating
+	if code, resp := rate(public, 5, friendToken); code != http.StatusOK || resp.Rating != 5 || resp.RatingCount != 1 {
+		t.Errorf("failed to replace rating: got %v %+v", code, resp)
+	}
+	if code, resp := rate(public, 4, token); code != http.StatusOK || resp.Rating != 4.5 || resp.RatingCount != 2 {
+		t.Errorf("owner failed to rate image: got %v %+v", code, resp)
+	}
+
+	rr := send("GET", "/image/meta?sort=rating&order=desc", "", token)
+	resp := QueryResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp.ImageMeta) < 2 || resp.ImageMeta[0].Id != public.Id {
+		t.Fatalf("wrong images sorted by rating: got %v %s", rr.Code, rr.Body.String())
+	}
+	if resp.ImageMeta[0].Rating != 4.5 || resp.ImageMeta[0].RatingCount != 2 {
+		t.Errorf("wrong rating in metadata: got %+v", resp.ImageMeta[0])
+	}
+	for _, image := range resp.ImageMeta {
+		if image.Id == unrated.Id && (image.Rating != 0 || image.RatingCount != 0) {
+			t.Errorf("wrong rating of unrated image: got %+v", image)
+		}
+	}
+}
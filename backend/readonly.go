@@ -0,0 +1,58 @@
+package main
+
+/*
+	This file implements the read-only mode of an instance, enabled by setting READ_ONLY to true.
+	A read-only instance serves reads while refusing every request that could modify data with 503,
+	so it can take traffic while the primary is under maintenance or run as a disaster recovery
+	mirror against a replicated database. It neither creates tables nor runs background jobs.
+*/
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	READ_ONLY_RETRY_AFTER = 300 // Seconds clients are asked to wait before retrying refused writes
+)
+
+// readOnlyAllowed lists the routes accepting POST in read-only mode as they don't modify data
+var readOnlyAllowed = []string{
+	"/oauth/token",
+	"/auth/signout",
+}
+
+// readOnly reports whether the instance is in read-only mode
+func readOnly() bool {
+	return os.Getenv("READ_ONLY") == "true"
+}
+
+// rejectWrites is router middleware refusing requests that modify data while the instance is read-only
+func rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !readOnly() || !modifiesData(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		logger.Warning("refusing %s %s in read-only mode sending 503", req.Method, req.URL.Path)
+		w.Header().Set("Retry-After", strconv.Itoa(READ_ONLY_RETRY_AFTER))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - Service is read-only for maintenance, changes cannot be made until it ends"))
+	})
+}
+
+// modifiesData reports whether the request may modify data
+func modifiesData(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	case "POST":
+		return !containsString(readOnlyAllowed, apiPath(req.URL.Path))
+	default:
+		return true
+	}
+}
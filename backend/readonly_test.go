@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestReadOnly ensures read-only instances refuse changes while serving reads
+// None of the evaluated requests reach the database
+func TestReadOnly(t *testing.T) {
+	os.Setenv("READ_ONLY", "true")
+	defer os.Unsetenv("READ_ONLY")
+
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	router := configureRoutes()
+
+	tt := []struct {
+		Method   string
+		Path     string
+		Expected int
+	}{
+		{"POST", "/register", http.StatusServiceUnavailable},
+		{"POST", "/image", http.StatusServiceUnavailable},
+		{"PUT", "/image/1/1.png", http.StatusServiceUnavailable},
+		{"DELETE", "/image/1/1.png", http.StatusServiceUnavailable},
+		{"PUT", "/admin/sharing-policy", http.StatusServiceUnavailable},
+		{"OPTIONS", "/image", http.StatusOK},
+		{"GET", "/ping", http.StatusOK},
+		{"GET", "/image/meta?consistency=bogus", http.StatusBadRequest},
+		{"POST", "/oauth/token", http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(""))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for %s %s: got %v want %v", tc.Method, tc.Path, rr.Code, tc.Expected)
+		}
+		if rr.Code == http.StatusServiceUnavailable && len(rr.Header().Get("Retry-After")) == 0 {
+			t.Errorf("expected Retry-After header for %s %s", tc.Method, tc.Path)
+		}
+	}
+}
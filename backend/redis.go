@@ -0,0 +1,297 @@
+package main
+
+/*
+	This file implements the redis state driver with a minimal client of the Redis serialization
+	protocol (RESP2). The client keeps a small pool of connections, authenticates with the password
+	and username of the url and selects the database of its path. rediss:// urls connect over TLS.
+	Rate limit buckets are updated by a script so concurrent requests of every replica take their
+	tokens atomically.
+*/
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	REDIS_PREFIX  = "picto:"        // Prefix of every key written to redis
+	REDIS_POOL    = 8               // Idle connections kept for reuse
+	REDIS_TIMEOUT = 2 * time.Second // Time allowed to connect and for each command
+)
+
+// redisTakeToken refills the bucket of KEYS[1] for the time elapsed and takes a token, ARGV holds the
+// rate per second, the burst and the time in milliseconds. Returns whether a token was taken and the
+// milliseconds until one is available. The bucket expires once it would have refilled
+const redisTakeToken = `
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens, updated = tonumber(bucket[1]), tonumber(bucket[2])
+if not tokens then
+	tokens, updated = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed, wait = 0, 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate * 1000)
+else
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, wait}
+`
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient sends commands to a redis server over pooled connections
+type redisClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config // nil connects without TLS
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisClient returns a client of the server of a redis:// or rediss:// url
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid redis url %q", rawURL)
+	}
+
+	client := &redisClient{addr: u.Host, pool: make(chan *redisConn, REDIS_POOL)}
+	if len(u.Port()) == 0 {
+		client.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		client.password, _ = u.User.Password()
+		client.username = u.User.Username()
+		// redis://:password@host names no user
+		if _, set := u.User.Password(); !set {
+			client.password, client.username = client.username, ""
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		client.db, err = strconv.Atoi(db)
+		if err != nil || client.db < 0 {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		client.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	return client, nil
+}
+
+// dial opens an authenticated connection to the database of the client
+func (c *redisClient) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: REDIS_TIMEOUT}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if len(c.password) > 0 {
+		args := []string{"AUTH", c.password}
+		if len(c.username) > 0 {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err = rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db > 0 {
+		if _, err = rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Do sends the command and returns its reply, strings, integers, nil or slices of them
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.pool:
+	default:
+		var err error
+		rc, err = c.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.do(args...)
+	if _, replied := err.(redisError); err != nil && !replied {
+		// The connection may hold part of a reply and can't be reused
+		rc.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// Ping checks that the server is reachable
+func (c *redisClient) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// do writes the command as an array of bulk strings and reads the reply
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(REDIS_TIMEOUT))
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(rc.conn, cmd.String())
+	if err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+// read parses a reply, error replies are returned as redisError
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis bulk length %q", value)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(rc.reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis array length %q", value)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			items[i], err = rc.read()
+			if _, replied := err.(redisError); err != nil && !replied {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply %q", line)
+}
+
+// redisState keeps the shared state in redis
+type redisState struct {
+	client *redisClient
+}
+
+// ttlMillis returns the milliseconds until the time, at least 1 so keys always expire
+func ttlMillis(until time.Time) string {
+	ms := int64(math.Ceil(time.Until(until).Seconds() * 1000))
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (s *redisState) TakeToken(key string, rate float64, burst float64) (bool, time.Duration, error) {
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	reply, err := s.client.Do("EVAL", redisTakeToken, "1", REDIS_PREFIX+key,
+		strconv.FormatFloat(rate, 'f', -1, 64), strconv.FormatFloat(burst, 'f', -1, 64), now)
+	if err != nil {
+		return false, 0, err
+	}
+
+	result, ok := reply.([]interface{})
+	if !ok || len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+	allowed, ok := result[0].(int64)
+	wait, ok2 := result[1].(int64)
+	if !ok || !ok2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s *redisState) Revoke(key string, until time.Time) error {
+	_, err := s.client.Do("SET", REDIS_PREFIX+key, "1", "PX", ttlMillis(until))
+	return err
+}
+
+func (s *redisState) Revoked(key string) (bool, error) {
+	reply, err := s.client.Do("EXISTS", REDIS_PREFIX+key)
+	if err != nil {
+		return false, err
+	}
+	count, _ := reply.(int64)
+	return count > 0, nil
+}
+
+func (s *redisState) ClaimNonce(key string, until time.Time) (bool, error) {
+	// SET NX replies nil when the key is already set
+	reply, err := s.client.Do("SET", REDIS_PREFIX+key, "1", "NX", "PX", ttlMillis(until))
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
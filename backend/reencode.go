@@ -0,0 +1,598 @@
+package main
+
+/*
+	This file implements re-encode campaigns converting the library to modern formats. An
+	administrator starts a campaign for AVIF and WebP and it walks every image, most viewed first,
+	storing the encodings that are smaller than the original beneath REENCODE_DIR. Image requests
+	accepting one of the encodings are then served the smallest file. A campaign is performed a
+	batch at a time by outbox events so it survives restarts and doesn't hold up other events, and
+	its record doubles as the report of the space and bandwidth saved.
+
+	Neither the standard library nor x/image can write WebP or AVIF so the files are produced by the
+	cwebp and avifenc commands, campaigns for formats whose command isn't installed are refused.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	REENCODE_TABLE     = "reencode_campaign"
+	IMAGE_FORMAT_TABLE = "image_format"
+	REENCODE_DIR       = "formats"   // Directory of each user's re-encoded files
+	REENCODE_FORMATS   = "avif,webp" // Formats of campaigns that don't name any
+	REENCODE_QUALITY   = 75          // Default if REENCODE_QUALITY env variable is not defined
+	REENCODE_BATCH     = 20          // Images encoded per outbox event, the next batch is encoded by another event
+	REENCODE_TIMEOUT   = 60          // Seconds an encoder may take for one image
+
+	// Campaign statuses
+	REENCODE_PENDING  = "pending"
+	REENCODE_RUNNING  = "running"
+	REENCODE_COMPLETE = "complete"
+
+	// Event topics
+	EVENT_REENCODE = "image.reencode"
+)
+
+// reencodeCommands names the environment variable and default of the command writing each encoding campaigns produce
+var reencodeCommands = map[string][2]string{
+	"image/avif": {"REENCODE_AVIF_COMMAND", "avifenc"},
+	"image/webp": {"REENCODE_WEBP_COMMAND", "cwebp"},
+}
+
+// ReencodeCampaign is a requested campaign and its report tagged for sql serialization
+type ReencodeCampaign struct {
+	Id             int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Formats        string    `sql:"formats"`     // Comma separated encodings produced
+	Limit          int32     `sql:"image_limit"` // Images examined before the campaign stops, 0 for the whole library
+	RequestedBy    int32     `sql:"requested_by"`
+	Status         string    `sql:"status"`
+	Step           int32     `sql:"step"`            // Batches started, events of earlier steps are ignored
+	Images         int32     `sql:"images"`          // Images examined so far
+	Files          int32     `sql:"files"`           // Files stored in other encodings
+	FileBytes      int64     `sql:"file_bytes"`      // Bytes of the stored files
+	OriginalBytes  int64     `sql:"original_bytes"`  // Bytes of the images a smaller file was stored for
+	EncodedBytes   int64     `sql:"encoded_bytes"`   // Bytes of the smallest file stored for each of those images
+	BandwidthSaved int64     `sql:"bandwidth_saved"` // Bytes the smallest files would have saved over the recorded views
+	Error          string    `sql:"error"`           // Last failure, the campaign is retried by the outbox
+	Created        time.Time `sql:"created"`
+	Completed      time.Time `sql:"completed"`
+}
+
+// ImageFormat is a file of an image in another encoding tagged for sql serialization, images whose
+// encoding is larger than the original are recorded without a file so campaigns don't encode them again
+type ImageFormat struct {
+	Id         int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId    int32     `sql:"image_id"`
+	CampaignId int32     `sql:"campaign_id"`
+	Encoding   string    `sql:"encoding"`
+	SourceHash string    `sql:"source_hash"` // Content hash of the image the file was encoded from
+	FileKey    string    `sql:"file_key"`    // Empty when no file was stored
+	Size       int64     `sql:"size"`
+	Created    time.Time `sql:"created"`
+}
+
+// ReencodeEvent is the payload of EVENT_REENCODE
+type ReencodeEvent struct {
+	CampaignId int32 `json:"campaignId"`
+	Step       int32 `json:"step"`
+}
+
+// FormatTotal counts the files a campaign stored in an encoding
+type FormatTotal struct {
+	Encoding string `json:"encoding"`
+	Files    int32  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// ReencodeReport is the json representation of a campaign
+type ReencodeReport struct {
+	Id             int32         `json:"id"`
+	Formats        []string      `json:"formats"`
+	Limit          int32         `json:"limit,omitempty"`
+	RequestedBy    int32         `json:"requestedBy"`
+	Status         string        `json:"status"`
+	Images         int32         `json:"images"`
+	Files          int32         `json:"files"`
+	FileBytes      int64         `json:"fileBytes"`
+	OriginalBytes  int64         `json:"originalBytes"`
+	EncodedBytes   int64         `json:"encodedBytes"`
+	SpaceSaved     int64         `json:"spaceSaved"`
+	BandwidthSaved int64         `json:"bandwidthSaved"`
+	ByFormat       []FormatTotal `json:"byFormat"`
+	Error          string        `json:"error,omitempty"`
+	Created        Timestamp     `json:"created"`
+	Completed      *Timestamp    `json:"completed,omitempty"`
+}
+
+// formatEncoder writes the png encoded image in another encoding
+type formatEncoder func(ctx context.Context, png []byte) ([]byte, error)
+
+// lookupEncoder returns the encoder of the encoding, an error if its command isn't installed
+var lookupEncoder = func(encoding string) (formatEncoder, error) {
+	command, ok := reencodeCommands[encoding]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+	name := os.Getenv(command[0])
+	if len(name) == 0 {
+		name = command[1]
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s encoder %s is not installed", encoding, name)
+	}
+	return commandEncoder(path, encoding), nil
+}
+
+func init() {
+	RegisterOutboxHandler(EVENT_REENCODE, handleReencodeEvent)
+}
+
+// getReencodeQuality returns the encoder quality from 1 to 100 defined by the REENCODE_QUALITY environment variable
+func getReencodeQuality() int {
+	quality, err := strconv.Atoi(os.Getenv("REENCODE_QUALITY"))
+	if err != nil || quality < 1 || quality > 100 {
+		quality = REENCODE_QUALITY
+	}
+	return quality
+}
+
+// formatKey returns the storage key of the file of the image in the encoding
+func formatKey(image Image, encoding string) string {
+	return fmt.Sprintf("%v/%s/%v.%v", image.Uid, REENCODE_DIR, image.Id, strings.Split(encoding, "/")[1])
+}
+
+// commandEncoder returns an encoder running the cwebp or avifenc command at path
+func commandEncoder(path string, encoding string) formatEncoder {
+	return func(ctx context.Context, data []byte) ([]byte, error) {
+		dir, err := ioutil.TempDir("", "reencode")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out."+strings.Split(encoding, "/")[1])
+		err = ioutil.WriteFile(in, data, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, REENCODE_TIMEOUT*time.Second)
+		defer cancel()
+
+		quality := strconv.Itoa(getReencodeQuality())
+		args := []string{"-q", quality, in, out}
+		if encoding == "image/webp" {
+			args = []string{"-quiet", "-q", quality, in, "-o", out}
+		}
+		output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+		}
+		return ioutil.ReadFile(out)
+	}
+}
+
+// Report returns the json representation of the campaign without its totals by format
+func (c ReencodeCampaign) Report() ReencodeReport {
+	report := ReencodeReport{
+		Id:             c.Id,
+		Formats:        strings.Split(c.Formats, ","),
+		Limit:          c.Limit,
+		RequestedBy:    c.RequestedBy,
+		Status:         c.Status,
+		Images:         c.Images,
+		Files:          c.Files,
+		FileBytes:      c.FileBytes,
+		OriginalBytes:  c.OriginalBytes,
+		EncodedBytes:   c.EncodedBytes,
+		SpaceSaved:     c.OriginalBytes - c.EncodedBytes,
+		BandwidthSaved: c.BandwidthSaved,
+		ByFormat:       []FormatTotal{},
+		Error:          c.Error,
+		Created:        Timestamp(c.Created),
+	}
+	if c.Status == REENCODE_COMPLETE {
+		completed := Timestamp(c.Completed)
+		report.Completed = &completed
+	}
+	return report
+}
+
+// startReencode accepts an optional json body with the formats to produce, avif and webp by default,
+// and the limit of images to examine, then schedules a campaign encoding the most viewed images first
+func startReencode(w http.ResponseWriter, req *http.Request) {
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to start re-encode campaign: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	var body struct {
+		Formats []string `json:"formats"`
+		Limit   int32    `json:"limit"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	if body.Limit < 0 {
+		logger.Error("invalid re-encode limit %v sending 400", body.Limit)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - limit must be a non negative integer"))
+		return
+	}
+
+	encodings, err := reencodeEncodings(body.Formats)
+	if err != nil {
+		logger.Error("invalid re-encode formats sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	campaign := ReencodeCampaign{
+		Formats:     strings.Join(encodings, ","),
+		Limit:       body.Limit,
+		RequestedBy: int32(claims.Uid),
+		Status:      REENCODE_PENDING,
+		Created:     time.Now().UTC(),
+	}
+	campaign.Id, err = AddReencodeCampaign(campaign)
+	if err != nil {
+		logger.Error("failed to schedule re-encode campaign sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to schedule re-encode campaign, try again later"))
+		return
+	}
+
+	logger.Info("Administrator %v scheduled re-encode campaign %v to %s", claims.Uid, campaign.Id, campaign.Formats)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/reencode-campaigns/%v", versionPrefix(req), campaign.Id))
+	writeReencodeReport(w, http.StatusAccepted, campaign.Report())
+}
+
+// reencodeEncodings returns the encodings of the requested formats, REENCODE_FORMATS when none are
+// requested, ensuring campaigns can produce them on this server
+func reencodeEncodings(formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		formats = strings.Split(REENCODE_FORMATS, ",")
+	}
+
+	encodings := []string{}
+	for _, format := range formats {
+		encoding, err := formatEncoding(format)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := reencodeCommands[encoding]; !ok {
+			return nil, fmt.Errorf("format %s is not produced by re-encode campaigns", format)
+		}
+		if containsString(encodings, encoding) {
+			continue
+		}
+		_, err = lookupEncoder(encoding)
+		if err != nil {
+			return nil, err
+		}
+		encodings = append(encodings, encoding)
+	}
+	return encodings, nil
+}
+
+// reencodeStatus returns the report of the campaign with the id
+func reencodeStatus(w http.ResponseWriter, req *http.Request) {
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for re-encode campaign: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	campaign, ok, err := GetReencodeCampaign(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve re-encode campaign sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve re-encode campaign, try again later"))
+		return
+	}
+	if !ok {
+		logger.Error("re-encode campaign %v not found sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no re-encode campaign with that id"))
+		return
+	}
+
+	report := campaign.Report()
+	report.ByFormat, err = ReencodeTotals(campaign.Id)
+	if err != nil {
+		logger.Error("failed to total re-encode campaign sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve re-encode campaign, try again later"))
+		return
+	}
+
+	writeReencodeReport(w, http.StatusOK, report)
+}
+
+// writeReencodeReport writes the report as the json response body
+func writeReencodeReport(w http.ResponseWriter, status int, report ReencodeReport) {
+	js, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// handleReencodeEvent encodes the next batch of the campaign of the event scheduling another event
+// for the batch after, events of completed campaigns or earlier steps are ignored so redelivery is harmless
+func handleReencodeEvent(ctx context.Context, event OutboxEvent) error {
+	var payload ReencodeEvent
+	err := json.Unmarshal([]byte(event.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("invalid re-encode event payload: %v", err)
+	}
+
+	campaign, ok, err := GetReencodeCampaign(payload.CampaignId)
+	if err != nil {
+		return err
+	}
+	if !ok || campaign.Status == REENCODE_COMPLETE || campaign.Step != payload.Step {
+		return nil
+	}
+
+	campaign.Status = REENCODE_RUNNING
+	done, err := runReencodeBatch(ctx, &campaign)
+	if err != nil {
+		campaign.Error = err.Error()
+		updateErr := UpdateReencodeCampaign(campaign, false)
+		if updateErr != nil {
+			logger.Error("failed to record re-encode campaign %v failure: %v", campaign.Id, updateErr)
+		}
+		return err
+	}
+
+	campaign.Error = ""
+	if done {
+		campaign.Status = REENCODE_COMPLETE
+		campaign.Completed = time.Now().UTC()
+		logger.Info("Completed re-encode campaign %v: %v images, %v files saving %v bytes", campaign.Id, campaign.Images, campaign.Files, campaign.OriginalBytes-campaign.EncodedBytes)
+	} else {
+		campaign.Step++
+	}
+	return UpdateReencodeCampaign(campaign, !done)
+}
+
+// runReencodeBatch encodes the next batch of most viewed images lacking the campaign encodings adding
+// them to the report, reporting true once no image is left or the limit of the campaign is reached
+func runReencodeBatch(ctx context.Context, campaign *ReencodeCampaign) (bool, error) {
+	encodings := strings.Split(campaign.Formats, ",")
+	encoders := map[string]formatEncoder{}
+	for _, encoding := range encodings {
+		encoder, err := lookupEncoder(encoding)
+		if err != nil {
+			return false, err
+		}
+		encoders[encoding] = encoder
+	}
+
+	batch := REENCODE_BATCH
+	if campaign.Limit > 0 && int(campaign.Limit-campaign.Images) < batch {
+		batch = int(campaign.Limit - campaign.Images)
+	}
+	if batch <= 0 {
+		return true, nil
+	}
+
+	images, err := NextReencodeImages(encodings, batch)
+	if err != nil {
+		return false, err
+	}
+	ids := []int32{}
+	for _, image := range images {
+		ids = append(ids, image.Id)
+	}
+	views, err := ImageViewCounts(ids)
+	if err != nil {
+		return false, err
+	}
+
+	for _, image := range images {
+		formats, err := reencodeImage(ctx, image, encodings, encoders)
+		if err != nil {
+			return false, fmt.Errorf("failed to re-encode image %v: %v", image.Id, err)
+		}
+		for i := range formats {
+			formats[i].CampaignId = campaign.Id
+		}
+		err = AddImageFormats(formats)
+		if err != nil {
+			return false, err
+		}
+		tallyReencode(campaign, image, formats, views[image.Id])
+	}
+
+	return len(images) < batch || (campaign.Limit > 0 && campaign.Images >= campaign.Limit), nil
+}
+
+// tallyReencode adds the files stored for the image with the views to the report of the campaign
+func tallyReencode(campaign *ReencodeCampaign, image Image, formats []ImageFormat, views int64) {
+	campaign.Images++
+	smallest := int64(image.Size)
+	for _, format := range formats {
+		if len(format.FileKey) == 0 {
+			continue
+		}
+		campaign.Files++
+		campaign.FileBytes += format.Size
+		if format.Size < smallest {
+			smallest = format.Size
+		}
+	}
+
+	if smallest < int64(image.Size) {
+		campaign.OriginalBytes += int64(image.Size)
+		campaign.EncodedBytes += smallest
+		campaign.BandwidthSaved += views * (int64(image.Size) - smallest)
+	}
+}
+
+// reencodeImage writes the image in each encoding storing the files smaller than the original.
+// Images that can't be decoded or encoded are recorded without files so they aren't attempted again
+func reencodeImage(ctx context.Context, img Image, encodings []string, encoders map[string]formatEncoder) ([]ImageFormat, error) {
+	formats := []ImageFormat{}
+	for _, encoding := range encodings {
+		formats = append(formats, ImageFormat{ImageId: img.Id, Encoding: encoding, SourceHash: img.Hash, Created: time.Now().UTC()})
+	}
+	if !canDecode(img.Encoding) {
+		return formats, nil
+	}
+
+	file, err := storage.Open(ctx, imageKey(img))
+	if err == ErrObjectNotFound {
+		logger.Warning("file missing for image %v, skipping re-encode", img.Id)
+		return formats, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %v", err)
+	}
+	src, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		logger.Warning("failed to decode image %v, skipping re-encode: %v", img.Id, err)
+		return formats, nil
+	}
+
+	// Encoders read the decoded pixels losslessly
+	buf := new(bytes.Buffer)
+	err = png.Encode(buf, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare image: %v", err)
+	}
+
+	for i := range formats {
+		if formats[i].Encoding == img.Encoding {
+			continue
+		}
+
+		data, err := encoders[formats[i].Encoding](ctx, buf.Bytes())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Warning("failed to encode image %v as %s, skipping: %v", img.Id, formats[i].Encoding, err)
+			continue
+		}
+
+		formats[i].Size = int64(len(data))
+		if formats[i].Size >= int64(img.Size) {
+			continue
+		}
+		key := formatKey(img, formats[i].Encoding)
+		err = storage.Put(ctx, key, bytes.NewReader(data), formats[i].Size, formats[i].Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store %s file: %v", formats[i].Encoding, err)
+		}
+		formats[i].FileKey = key
+	}
+
+	return formats, nil
+}
+
+// acceptsType reports whether the Accept header explicitly lists the media type without refusing it with q=0
+func acceptsType(accept string, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), mediaType) {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				weight, err := strconv.ParseFloat(q[2:], 64)
+				return err == nil && weight > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// bestFormat returns the smallest file the client accepts that was encoded from the current content of the image
+func bestFormat(formats []ImageFormat, imageMeta Image, accept string) (ImageFormat, bool) {
+	best, found := ImageFormat{}, false
+	for _, format := range formats {
+		if len(format.FileKey) == 0 || format.SourceHash != imageMeta.Hash || format.Size >= int64(imageMeta.Size) || !acceptsType(accept, format.Encoding) {
+			continue
+		}
+		if !found || format.Size < best.Size {
+			best, found = format, true
+		}
+	}
+	return best, found
+}
+
+// writeReencodedImage serves the smallest re-encoded file of the image the client accepts, reporting
+// false without writing a response when there is none so the image is served as usual
+func (s *Server) writeReencodedImage(w http.ResponseWriter, req *http.Request, imageMeta Image) bool {
+	accept := req.Header.Get("Accept")
+	acceptable := false
+	for encoding := range reencodeCommands {
+		acceptable = acceptable || acceptsType(accept, encoding)
+	}
+	if !acceptable {
+		return false
+	}
+
+	formats, err := s.store.ImageFormats(req.Context(), imageMeta.Id)
+	if err != nil {
+		logger.Warning("failed to retrieve re-encoded files of image %v, serving the original: %v", imageMeta.Id, err)
+		return false
+	}
+	if len(formats) > 0 {
+		w.Header().Add("Vary", "Accept")
+	}
+	format, ok := bestFormat(formats, imageMeta, accept)
+	if !ok {
+		return false
+	}
+
+	file, err := storage.Open(req.Context(), format.FileKey)
+	if err != nil {
+		logger.Warning("failed to open re-encoded file of image %v, serving the original: %v", imageMeta.Id, err)
+		return false
+	}
+	defer file.Close()
+
+	variant := imageMeta
+	variant.Encoding = format.Encoding
+	serveImageFile(w, req, variant, format.FileKey, file)
+	return true
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEncoders replaces the encoder commands, webp files are 4 bytes and avif files larger than any test image
+func fakeEncoders() func() {
+	lookup := lookupEncoder
+	lookupEncoder = func(encoding string) (formatEncoder, error) {
+		switch encoding {
+		case "image/webp":
+			return func(ctx context.Context, png []byte) ([]byte, error) { return []byte("RIFF"), nil }, nil
+		case "image/avif":
+			return func(ctx context.Context, png []byte) ([]byte, error) { return make([]byte, 1<<20), nil }, nil
+		}
+		return nil, ErrUnsupportedEncoding
+	}
+	return func() { lookupEncoder = lookup }
+}
+
+// TestAcceptsType ensures only explicitly accepted media types not refused with q=0 are accepted
+func TestAcceptsType(t *testing.T) {
+	tt := []struct {
+		Accept   string
+		Expected bool
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", true},
+		{"image/png, image/WebP;q=0.5", true},
+		{"image/webp;q=0", false},
+		{"image/*,*/*;q=0.8", false},
+		{"", false},
+	}
+	for _, tc := range tt {
+		if got := acceptsType(tc.Accept, "image/webp"); got != tc.Expected {
+			t.Errorf("wrong acceptance of webp by %q: got %v want %v", tc.Accept, got, tc.Expected)
+		}
+	}
+}
+
+// TestBestFormat ensures the smallest accepted file encoded from the current content is chosen
+func TestBestFormat(t *testing.T) {
+	image := Image{Id: 3, Hash: "abc", Size: 1000}
+	formats := []ImageFormat{
+		{Encoding: "image/avif", SourceHash: "abc", FileKey: "1/formats/3.avif", Size: 400},
+		{Encoding: "image/webp", SourceHash: "abc", FileKey: "1/formats/3.webp", Size: 600},
+	}
+
+	tt := []struct {
+		Accept   string
+		Formats  []ImageFormat
+		Expected string
+	}{
+		{"image/avif,image/webp", formats, "image/avif"},
+		{"image/webp", formats, "image/webp"},
+		{"image/jpeg", formats, ""},
+		{"image/avif,image/webp", []ImageFormat{{Encoding: "image/webp", SourceHash: "old", FileKey: "1/formats/3.webp", Size: 600}}, ""},
+		{"image/avif,image/webp", []ImageFormat{{Encoding: "image/webp", SourceHash: "abc", Size: 600}}, ""},
+	}
+	for _, tc := range tt {
+		format, ok := bestFormat(tc.Formats, image, tc.Accept)
+		if ok != (len(tc.Expected) > 0) || format.Encoding != tc.Expected {
+			t.Errorf("wrong format for %q: got %+v %v want %q", tc.Accept, format, ok, tc.Expected)
+		}
+	}
+}
+
+// TestReencodeEncodings ensures campaigns default to avif and webp and refuse formats they can't produce
+func TestReencodeEncodings(t *testing.T) {
+	defer fakeEncoders()()
+
+	encodings, err := reencodeEncodings(nil)
+	if err != nil || strings.Join(encodings, ",") != "image/avif,image/webp" {
+		t.Errorf("wrong default encodings: got %v %v", encodings, err)
+	}
+	encodings, err = reencodeEncodings([]string{"webp", "WEBP"})
+	if err != nil || strings.Join(encodings, ",") != "image/webp" {
+		t.Errorf("wrong encodings: got %v %v", encodings, err)
+	}
+	for _, formats := range [][]string{{"jpeg"}, {"bmp"}} {
+		if _, err := reencodeEncodings(formats); err == nil {
+			t.Errorf("expected %v to be refused", formats)
+		}
+	}
+
+	lookupEncoder = func(encoding string) (formatEncoder, error) { return nil, errors.New("not installed") }
+	if _, err := reencodeEncodings([]string{"avif"}); err == nil {
+		t.Errorf("expected format without encoder to be refused")
+	}
+}
+
+// TestReencodeImage ensures only files smaller than the original are stored and
+// images that can't be decoded are recorded without files
+func TestReencodeImage(t *testing.T) {
+	defer fakeEncoders()()
+	defer func(configured Storage) { storage = configured }(storage)
+	dir := t.TempDir()
+	storage = &localStorage{root: dir}
+
+	ctx := context.Background()
+	png := testImage(t, "png", 16)
+	image := Image{Id: 3, Uid: 1, Encoding: "image/png", Hash: "abc", Size: int32(len(png))}
+	storage.Put(ctx, imageKey(image), bytes.NewReader(png), int64(len(png)), image.Encoding)
+
+	encodings := []string{"image/avif", "image/webp"}
+	encoders := map[string]formatEncoder{}
+	for _, encoding := range encodings {
+		encoders[encoding], _ = lookupEncoder(encoding)
+	}
+
+	formats, err := reencodeImage(ctx, image, encodings, encoders)
+	if err != nil || len(formats) != 2 {
+		t.Fatalf("failed to re-encode image: got %+v %v", formats, err)
+	}
+	if formats[0].FileKey != "" || formats[0].Size != 1<<20 {
+		t.Errorf("expected larger avif file not to be stored: got %+v", formats[0])
+	}
+	if formats[1].FileKey != formatKey(image, "image/webp") || formats[1].Size != 4 || formats[1].SourceHash != "abc" {
+		t.Errorf("wrong webp file: got %+v", formats[1])
+	}
+	if file, err := storage.Open(ctx, formats[1].FileKey); err != nil {
+		t.Errorf("webp file not stored: %v", err)
+	} else {
+		file.Close()
+	}
+
+	// Images that can't be decoded aren't attempted again
+	image.Encoding = "image/avif"
+	formats, err = reencodeImage(ctx, image, encodings, encoders)
+	if err != nil || len(formats) != 2 || formats[0].FileKey != "" || formats[1].FileKey != "" {
+		t.Errorf("wrong formats of undecodable image: got %+v %v", formats, err)
+	}
+}
+
+// TestTallyReencode ensures savings count the smallest stored file of each image weighted by its views
+func TestTallyReencode(t *testing.T) {
+	campaign := ReencodeCampaign{}
+	tallyReencode(&campaign, Image{Size: 1000}, []ImageFormat{{FileKey: "a", Size: 600}, {FileKey: "b", Size: 400}}, 10)
+	tallyReencode(&campaign, Image{Size: 500}, []ImageFormat{{Size: 700}}, 50)
+
+	expected := ReencodeCampaign{Images: 2, Files: 2, FileBytes: 1000, OriginalBytes: 1000, EncodedBytes: 400, BandwidthSaved: 6000}
+	if campaign != expected {
+		t.Errorf("wrong tally: got %+v want %+v", campaign, expected)
+	}
+	if report := campaign.Report(); report.SpaceSaved != 600 {
+		t.Errorf("wrong space saved: got %v", report.SpaceSaved)
+	}
+}
+
+// TestReencodeValidation ensures campaigns are restricted to administrators and validate their formats
+// None of the evaluated requests reach the database
+func TestReencodeValidation(t *testing.T) {
+	defer fakeEncoders()()
+	router := configureRoutes()
+
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+
+	tt := []struct {
+		Uid      int
+		Body     string
+		Expected int
+	}{
+		{1, `{}`, http.StatusForbidden},
+		{7, `not json`, http.StatusBadRequest},
+		{7, `{"formats": ["bmp"]}`, http.StatusBadRequest},
+		{7, `{"formats": ["png"]}`, http.StatusBadRequest},
+		{7, `{"limit": -1}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		token, _, err := generateJWT(tc.Uid, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/admin/reencode-campaigns", strings.NewReader(tc.Body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for %s by %v: got %v want %v", tc.Body, tc.Uid, rr.Code, tc.Expected)
+		}
+	}
+}
+
+// TestReencode ensures a campaign encodes the most viewed image, reports its savings and
+// that clients accepting the encoding are served the smaller file
+func TestReencode(t *testing.T) {
+	defer fakeEncoders()()
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), image)
+	err := AddImageViews(time.Now(), map[int32]int64{image.Id: 1 << 40}, nil)
+	if err != nil {
+		t.Fatalf("failed to add views: %v", err)
+	}
+
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+	adminToken, _, err := generateJWT(7, "admin@mail.com")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/reencode-campaigns", strings.NewReader(`{"formats": ["webp"], "limit": 1}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("failed to start campaign: got %v", rr.Code)
+	}
+	report := ReencodeReport{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.Status != REENCODE_PENDING || rr.Header().Get("Location") != fmt.Sprintf("/admin/reencode-campaigns/%v", report.Id) {
+		t.Fatalf("wrong campaign: got %+v at %s", report, rr.Header().Get("Location"))
+	}
+
+	// The redelivered event of the first step is ignored
+	payload, _ := json.Marshal(ReencodeEvent{CampaignId: report.Id})
+	for i := 0; i < 2; i++ {
+		err = handleReencodeEvent(context.Background(), OutboxEvent{Topic: EVENT_REENCODE, Payload: string(payload)})
+		if err != nil {
+			t.Fatalf("failed to run campaign: %v", err)
+		}
+	}
+
+	req = httptest.NewRequest("GET", rr.Header().Get("Location"), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	saved := int64(image.Size) - 4
+	if report.Status != REENCODE_COMPLETE || report.Images != 1 || report.Files != 1 || report.SpaceSaved != saved || report.BandwidthSaved != saved<<40 {
+		t.Errorf("wrong campaign report: got %+v", report)
+	}
+	if len(report.ByFormat) != 1 || report.ByFormat[0] != (FormatTotal{Encoding: "image/webp", Files: 1, Bytes: 4}) {
+		t.Errorf("wrong totals by format: got %+v", report.ByFormat)
+	}
+
+	path := fmt.Sprintf("/image/%v/%v", image.Uid, image.Id)
+	for accept, expected := range map[string]string{"image/webp,*/*": "image/webp", "image/png": "image/png"} {
+		req = httptest.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Add("Accept", accept)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != expected || rr.Header().Get("Vary") == "" {
+			t.Errorf("wrong response for %q: got %v %q vary %q", accept, rr.Code, rr.Header().Get("Content-Type"), rr.Header().Get("Vary"))
+		}
+	}
+}
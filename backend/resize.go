@@ -0,0 +1,289 @@
+package main
+
+/*
+	This file implements resizing and converting images on request. Clients request a variant
+	of an image with the w, h, fit and format query parameters and the variant is kept in a
+	memory cache keyed by the stored file and parameters so repeated requests skip decoding.
+	Replacing the stored file changes its entity tag so stale variants are never served.
+*/
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	RESIZE_MAX_DIMENSION = 4096             // Default if RESIZE_MAX_DIMENSION env variable is not defined
+	RESIZE_CACHE_BYTES   = 64 * 1024 * 1024 // Default if RESIZE_CACHE_BYTES env variable is not defined
+
+	FIT_CONTAIN = "contain" // Scale to fit within the requested size preserving aspect ratio
+	FIT_COVER   = "cover"   // Scale and crop the center to exactly fill the requested size
+)
+
+// variantOptions describe a requested image variant, zero leaves a dimension unbounded
+type variantOptions struct {
+	Width    int
+	Height   int
+	Fit      string
+	Encoding string
+}
+
+// String identifies the variant within the cache
+func (o variantOptions) String() string {
+	return fmt.Sprintf("%vx%v-%s-%s", o.Width, o.Height, o.Fit, strings.TrimPrefix(o.Encoding, "image/"))
+}
+
+// variants caches resized and converted images for every handler
+var variants = newVariantCache(getResizeCacheBytes())
+
+// variantRequested reports whether the request asks for a resized or converted variant of the image
+func variantRequested(req *http.Request) bool {
+	params := req.URL.Query()
+	return len(params.Get("w")) > 0 || len(params.Get("h")) > 0 || len(params.Get("format")) > 0
+}
+
+// parseVariant validates the w, h, fit and format query parameters
+// the variant keeps the encoding of the image unless a format is requested
+func parseVariant(params url.Values, encoding string) (variantOptions, error) {
+	maxDimension := getResizeMaxDimension()
+	opts := variantOptions{Fit: FIT_CONTAIN, Encoding: encoding}
+	for key, dimension := range map[string]*int{"w": &opts.Width, "h": &opts.Height} {
+		value := params.Get(key)
+		if len(value) == 0 {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDimension {
+			return opts, fmt.Errorf("%s must be an integer from 1 to %v", key, maxDimension)
+		}
+		*dimension = parsed
+	}
+
+	if fit := params.Get("fit"); len(fit) > 0 {
+		opts.Fit = fit
+	}
+	switch opts.Fit {
+	case FIT_CONTAIN:
+	case FIT_COVER:
+		if opts.Width == 0 || opts.Height == 0 {
+			return opts, fmt.Errorf("fit %s requires both w and h", FIT_COVER)
+		}
+	default:
+		return opts, fmt.Errorf("fit must be %s or %s", FIT_CONTAIN, FIT_COVER)
+	}
+
+	if format := params.Get("format"); len(format) > 0 {
+		converted, err := formatEncoding(format)
+		if err != nil {
+			return opts, err
+		}
+		opts.Encoding = converted
+	}
+
+	return opts, nil
+}
+
+// resizeImage returns the image scaled to the options, images are never enlarged
+func resizeImage(src image.Image, opts variantOptions) image.Image {
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = src.Bounds().Dx()
+	}
+	if height == 0 {
+		height = src.Bounds().Dy()
+	}
+
+	if opts.Fit == FIT_COVER {
+		src = cropToAspect(src, width, height)
+	}
+	return scaleImage(src, width, height)
+}
+
+// cropToAspect returns the largest centered region of the image with the aspect ratio of width by height
+func cropToAspect(src image.Image, width int, height int) image.Image {
+	bounds := src.Bounds()
+	cropW, cropH := bounds.Dx(), bounds.Dy()
+	if cropW*height > cropH*width {
+		cropW = cropH * width / height
+	} else {
+		cropH = cropW * height / width
+	}
+	if cropW < 1 {
+		cropW = 1
+	}
+	if cropH < 1 {
+		cropH = 1
+	}
+
+	x0 := bounds.Min.X + (bounds.Dx()-cropW)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-cropH)/2
+	rect := image.Rect(x0, y0, x0+cropW, y0+cropH)
+
+	// Images without SubImage are cropped by scaling from the full image
+	if sub, ok := src.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return src
+}
+
+// writeImageVariant serves the variant of the image requested by the query parameters
+func writeImageVariant(w http.ResponseWriter, req *http.Request, imageMeta Image) {
+	opts, err := parseVariant(req.URL.Query(), imageMeta.Encoding)
+	if err != nil {
+		logger.Error("invalid variant parameters sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Bad request, %v", err)))
+		return
+	}
+
+	// Requests for the image as stored are served directly
+	if opts == (variantOptions{Fit: FIT_CONTAIN, Encoding: imageMeta.Encoding}) {
+		writeImageFile(w, req, imageMeta, imageKey(imageMeta))
+		return
+	}
+
+	if !canEncode(opts.Encoding) {
+		logger.Error("conversion of image %v to %s unavailable sending 406", imageMeta.Id, opts.Encoding)
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(fmt.Sprintf("406 - Images cannot be converted to %s, available formats are %s", opts.Encoding, strings.Join(ENCODABLE_TYPES, ", "))))
+		return
+	}
+	if !canDecode(imageMeta.Encoding) {
+		logger.Error("image %v of type %s cannot be decoded sending 400", imageMeta.Id, imageMeta.Encoding)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Images of type %s cannot be resized or converted", imageMeta.Encoding)))
+		return
+	}
+
+	file, ok := openImageFile(w, req, imageMeta, imageKey(imageMeta))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	writeVariant(w, req, imageMeta, file, opts)
+}
+
+// writeVariant serves the variant of the opened image file described by the options from the cache,
+// preparing and caching it first if needed
+func writeVariant(w http.ResponseWriter, req *http.Request, imageMeta Image, file Object, opts variantOptions) {
+	// The entity tag of the stored file invalidates variants when the file is replaced
+	etag := fmt.Sprintf(`%s-%s"`, strings.TrimSuffix(imageETag(imageMeta, imageKey(imageMeta), file), `"`), opts)
+	cacheKey := fmt.Sprintf("%s %s", imageKey(imageMeta), etag)
+
+	data, ok := variants.Get(cacheKey)
+	if !ok {
+		src, _, err := image.Decode(file)
+		if err != nil {
+			logger.Error("failed to decode image %v sending 500: %v", imageMeta.Id, err)
+			dropCacheHeaders(w.Header())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to prepare image, try again later"))
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		err = encodeImage(buf, resizeImage(src, opts), opts.Encoding)
+		if err != nil {
+			logger.Error("failed to encode image %v sending 500: %v", imageMeta.Id, err)
+			dropCacheHeaders(w.Header())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to prepare image, try again later"))
+			return
+		}
+		data = buf.Bytes()
+		variants.Add(cacheKey, data)
+	}
+
+	w.Header().Set("Content-Type", opts.Encoding)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, req, imageMeta.Title, file.ModTime(), bytes.NewReader(data))
+}
+
+// getResizeMaxDimension returns the largest width or height that may be requested
+func getResizeMaxDimension() int {
+	dimension, err := strconv.Atoi(os.Getenv("RESIZE_MAX_DIMENSION"))
+	if err != nil || dimension <= 0 {
+		dimension = RESIZE_MAX_DIMENSION
+	}
+	return dimension
+}
+
+// getResizeCacheBytes returns the memory available to cached variants, 0 disables the cache
+func getResizeCacheBytes() int64 {
+	size, err := strconv.ParseInt(os.Getenv("RESIZE_CACHE_BYTES"), 10, 64)
+	if err != nil || size < 0 {
+		size = RESIZE_CACHE_BYTES
+	}
+	return size
+}
+
+// variantCache is a least recently used cache of encoded images bounded by their total size
+type variantCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type variantEntry struct {
+	key  string
+	data []byte
+}
+
+func newVariantCache(maxBytes int64) *variantCache {
+	return &variantCache{maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Get returns the cached data for the key marking it as recently used
+func (c *variantCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*variantEntry).data, true
+}
+
+// Add caches the data evicting the least recently used entries to stay within the size limit
+// data larger than the whole cache is not stored
+func (c *variantCache) Add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.size -= int64(len(elem.Value.(*variantEntry).data))
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+
+	for c.size+int64(len(data)) > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*variantEntry)
+		c.size -= int64(len(entry.data))
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+	}
+
+	c.entries[key] = c.order.PushFront(&variantEntry{key, data})
+	c.size += int64(len(data))
+}
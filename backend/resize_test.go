@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// TestParseVariant ensures the resize and format query parameters are validated
+func TestParseVariant(t *testing.T) {
+	defer os.Unsetenv("RESIZE_MAX_DIMENSION")
+	os.Setenv("RESIZE_MAX_DIMENSION", "1000")
+
+	tt := []struct {
+		Query    string
+		Expected variantOptions
+		Valid    bool
+	}{
+		{"w=400&h=300", variantOptions{400, 300, FIT_CONTAIN, "image/png"}, true},
+		{"w=400", variantOptions{400, 0, FIT_CONTAIN, "image/png"}, true},
+		{"h=300&fit=contain", variantOptions{0, 300, FIT_CONTAIN, "image/png"}, true},
+		{"w=400&h=300&fit=cover", variantOptions{400, 300, FIT_COVER, "image/png"}, true},
+		{"w=400&fit=cover", variantOptions{}, false},
+		{"w=400&fit=stretch", variantOptions{}, false},
+		{"w=0", variantOptions{}, false},
+		{"w=-5", variantOptions{}, false},
+		{"w=1001", variantOptions{}, false},
+		{"w=abc", variantOptions{}, false},
+		{"format=jpg", variantOptions{0, 0, FIT_CONTAIN, "image/jpeg"}, true},
+		{"w=400&format=GIF", variantOptions{400, 0, FIT_CONTAIN, "image/gif"}, true},
+		{"format=webp", variantOptions{0, 0, FIT_CONTAIN, "image/webp"}, true},
+		{"format=bmp", variantOptions{}, false},
+	}
+
+	for _, tc := range tt {
+		params, _ := url.ParseQuery(tc.Query)
+		opts, err := parseVariant(params, "image/png")
+		if (err == nil) != tc.Valid {
+			t.Errorf("wrong validation for %q: got %v", tc.Query, err)
+			continue
+		}
+		if tc.Valid && opts != tc.Expected {
+			t.Errorf("wrong options for %q: got %+v want %+v", tc.Query, opts, tc.Expected)
+		}
+	}
+}
+
+// TestResizeImage ensures variants have the requested dimensions without enlarging the image
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+
+	tt := []struct {
+		Opts   variantOptions
+		Width  int
+		Height int
+	}{
+		{variantOptions{400, 300, FIT_CONTAIN, "image/png"}, 400, 200},
+		{variantOptions{0, 100, FIT_CONTAIN, "image/png"}, 200, 100},
+		{variantOptions{1600, 0, FIT_CONTAIN, "image/png"}, 800, 400},
+		{variantOptions{400, 300, FIT_COVER, "image/png"}, 400, 300},
+		{variantOptions{100, 400, FIT_COVER, "image/png"}, 100, 400},
+		{variantOptions{1600, 1600, FIT_COVER, "image/png"}, 400, 400},
+	}
+
+	for _, tc := range tt {
+		bounds := resizeImage(src, tc.Opts).Bounds()
+		if bounds.Dx() != tc.Width || bounds.Dy() != tc.Height {
+			t.Errorf("wrong size for %v: got %vx%v want %vx%v", tc.Opts, bounds.Dx(), bounds.Dy(), tc.Width, tc.Height)
+		}
+	}
+}
+
+// TestVariantCache ensures the least recently used variants are evicted to stay within the size limit
+func TestVariantCache(t *testing.T) {
+	cache := newVariantCache(10)
+	cache.Add("a", make([]byte, 4))
+	cache.Add("b", make([]byte, 4))
+
+	// Reading a makes b the least recently used
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	cache.Add("c", make([]byte, 4))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	cache.Add("large", make([]byte, 11))
+	if _, ok := cache.Get("large"); ok {
+		t.Errorf("expected data larger than the cache to be skipped")
+	}
+	if cache.size != 8 {
+		t.Errorf("wrong cache size: got %v want 8", cache.size)
+	}
+}
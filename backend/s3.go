@@ -0,0 +1,370 @@
+package main
+
+/*
+	This file implements an S3 compatible storage driver. Requests are signed with AWS
+	Signature Version 4 so the driver works with AWS as well as self hosted object stores
+	such as MinIO or Ceph RGW through a custom endpoint, path-style addressing and
+	custom or self-signed TLS certificates.
+*/
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_REGION  = "us-east-1" // Default if S3_REGION env variable is not defined
+	S3_TIMEOUT = 60          // Seconds allowed for a single request to the object store
+
+	S3_UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+)
+
+// s3Config describes the object store used by the s3 storage driver
+type s3Config struct {
+	Endpoint  string // Base url of the object store, AWS is used when empty
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool   // Address buckets as endpoint/bucket/key instead of bucket.endpoint/key
+	Insecure  bool   // Skip TLS certificate verification, for self-signed test deployments only
+	CAFile    string // PEM encoded certificate authority trusted in addition to the system pool
+}
+
+// s3Storage stores objects in a bucket of an S3 compatible object store
+type s3Storage struct {
+	config s3Config
+	base   *url.URL
+	client *http.Client
+}
+
+// s3ConfigFromEnv reads the s3 storage configuration from environment variables
+// path-style addressing defaults to on when a custom endpoint is provided
+func s3ConfigFromEnv() (s3Config, error) {
+	config := s3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		Region:    os.Getenv("S3_REGION"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		PathStyle: len(os.Getenv("S3_ENDPOINT")) > 0,
+		Insecure:  os.Getenv("S3_INSECURE_SKIP_VERIFY") == "true",
+		CAFile:    os.Getenv("S3_CA_FILE"),
+	}
+
+	if pathStyle := os.Getenv("S3_PATH_STYLE"); len(pathStyle) > 0 {
+		config.PathStyle = pathStyle == "true"
+	}
+	if len(config.Region) == 0 {
+		config.Region = S3_REGION
+	}
+
+	if len(config.Bucket) == 0 {
+		return s3Config{}, fmt.Errorf("S3_BUCKET is required")
+	}
+	if len(config.AccessKey) == 0 || len(config.SecretKey) == 0 {
+		return s3Config{}, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY are required")
+	}
+
+	return config, nil
+}
+
+// newS3Storage validates the configuration and prepares the http client used by the driver
+func newS3Storage(config s3Config) (*s3Storage, error) {
+	endpoint := config.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || len(base.Host) == 0 {
+		return nil, fmt.Errorf("invalid s3 endpoint %q, include the http or https scheme", endpoint)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if len(config.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read s3 ca file: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in s3 ca file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &s3Storage{
+		config: config,
+		base:   base,
+		client: &http.Client{Transport: transport, Timeout: S3_TIMEOUT * time.Second},
+	}, nil
+}
+
+func (s *s3Storage) Name() string {
+	return "s3"
+}
+
+// objectURL returns the url of the key using path-style or virtual-hosted addressing
+func (s *s3Storage) objectURL(key string) *url.URL {
+	u := *s.base
+	path := "/" + strings.TrimPrefix(key, "/")
+	if s.config.PathStyle {
+		path = "/" + s.config.Bucket + path
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.base.Path, "/") + path
+	u.RawPath = s3Escape(u.Path, false)
+	return &u
+}
+
+// do signs and sends a request for the key returning the response for 2xx statuses
+func (s *s3Storage) do(ctx context.Context, method string, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare s3 request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.sign(req, S3_UNSIGNED_PAYLOAD, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s request failed: %v", method, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrObjectNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s request returned %v: %s", method, resp.StatusCode, msg)
+	}
+
+	return resp, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.objectURL(key).String(), r)
+	if err != nil {
+		return fmt.Errorf("failed to prepare s3 request: %v", err)
+	}
+	req.ContentLength = size
+	req.Header = header
+
+	s.sign(req, S3_UNSIGNED_PAYLOAD, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 PUT request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 PUT request returned %v: %s", resp.StatusCode, msg)
+	}
+
+	return nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, "HEAD", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &s3Object{
+		storage: s,
+		ctx:     ctx,
+		key:     key,
+		size:    resp.ContentLength,
+		modTime: modTime,
+	}, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical query string sorted by key then value
+	query := req.URL.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.config.Region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data using the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape URI encodes a value as required by Signature Version 4
+// every byte except unreserved characters is encoded, slashes are kept unless encodeSlash is set
+func s3Escape(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Object reads an object lazily with ranged GET requests so it can be seeked
+type s3Object struct {
+	storage *s3Storage
+	ctx     context.Context
+	key     string
+	size    int64
+	modTime time.Time
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Size() int64 {
+	return o.size
+}
+
+func (o *s3Object) ModTime() time.Time {
+	return o.modTime
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	// Request the remainder of the object from the current offset
+	if o.body == nil {
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%v-", o.offset))
+		resp, err := o.storage.do(o.ctx, "GET", o.key, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	next := offset
+	switch whence {
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	}
+	if next < 0 {
+		return o.offset, fmt.Errorf("invalid seek to negative offset %v", next)
+	}
+
+	// Discard the open body, the next read requests the new range
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+/*
+	This file runs periodic background jobs such as purging expired rows from auxiliary
+	tables. Features register their jobs at init and the scheduler starts them with the
+	server. Only one replica of a deployment should run the scheduler, set SCHEDULER=false
+	on the others.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	PURGE_BATCH = 500 // Maximum rows removed by a single run of a purge job
+)
+
+// Job is a task run by the scheduler every interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+	Table    string // Table purged by jobs registered with RegisterPurgeJob, empty for other jobs
+}
+
+// jobs holds every job registered with the scheduler
+var jobs = []Job{}
+
+// RegisterJob adds a job to be run by the scheduler every interval
+func RegisterJob(name string, interval time.Duration, run func(ctx context.Context) error) {
+	jobs = append(jobs, Job{Name: name, Interval: interval, Run: run})
+}
+
+// RegisterPurgeJob registers a job that removes rows of the table matching the condition
+// the condition uses $n placeholders and its arguments are generated on each run so they can depend on the current time
+func RegisterPurgeJob(name string, interval time.Duration, table string, cond string, args func() []interface{}) {
+	jobs = append(jobs, Job{Name: name, Interval: interval, Table: table, Run: func(ctx context.Context) error {
+		purged, err := PurgeRows(table, cond, args()...)
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %v", table, err)
+		}
+		if purged > 0 {
+			logger.Info("Purged %v rows from %s", purged, table)
+		}
+		return nil
+	}})
+}
+
+// startScheduler runs each registered job on its interval until the context is cancelled
+// the scheduler can be disabled with the SCHEDULER environment variable and never runs on read-only instances
+func startScheduler(ctx context.Context) {
+	if os.Getenv("SCHEDULER") == "false" || readOnly() {
+		logger.Info("Scheduler disabled, background jobs will not run on this instance")
+		return
+	}
+
+	for _, job := range jobs {
+		go runJob(ctx, job)
+	}
+
+	logger.Info("Scheduler started with %v jobs", len(jobs))
+}
+
+// runJob runs the job every interval reporting failures without stopping
+func runJob(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := job.Run(ctx)
+			if err != nil {
+				logger.Error("scheduled job %s failed: %v", job.Name, err)
+			}
+		}
+	}
+}
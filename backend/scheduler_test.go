@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRunJob ensures jobs repeat on their interval, survive failures and stop with the context
+func TestRunJob(t *testing.T) {
+	var runs int32
+	job := Job{
+		Name:     "test",
+		Interval: 5 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return fmt.Errorf("failures don't stop the job")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runJob(ctx, job)
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("job did not stop after cancellation")
+	}
+
+	if got := atomic.LoadInt32(&runs); got < 2 {
+		t.Errorf("job should have run repeatedly: got %v runs", got)
+	}
+}
+
+// TestPurgeJobs ensures purge jobs record the table they purge and that every auxiliary table
+// with expiring rows is purged
+func TestPurgeJobs(t *testing.T) {
+	purged := map[string]bool{}
+	for _, job := range jobs {
+		if len(job.Table) > 0 {
+			purged[job.Table] = true
+		}
+	}
+	for _, table := range []string{RESET_TABLE, AUDIT_TABLE, GUEST_LINK_TABLE, INTAKE_TABLE, ANNOUNCEMENT_TABLE, OUTBOX_TABLE} {
+		if !purged[table] {
+			t.Errorf("no purge job registered for %s", table)
+		}
+	}
+
+	defer func(registered []Job) { jobs = registered }(jobs)
+	RegisterPurgeJob("test-purge", time.Hour, TAG_TABLE, "image_id < $1", func() []interface{} {
+		return []interface{}{0}
+	})
+	job := jobs[len(jobs)-1]
+	if job.Name != "test-purge" || job.Table != TAG_TABLE || job.Interval != time.Hour {
+		t.Errorf("wrong purge job registered: got %v %v %v", job.Name, job.Table, job.Interval)
+	}
+}
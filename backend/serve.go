@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,7 +21,7 @@ import (
 )
 
 const (
-	PORT = ":8000" // Default if env var GO_PORT is not defined
+	PORT = ":8000" // Default if env vars LISTEN_ADDR and GO_PORT are not defined
 
 	IMAGE_DIR = "image"
 	REF_URL   = "localhost:8000" // Default if REF_URL env variable is not defined
@@ -35,14 +37,29 @@ type PingResp struct {
 
 // Used for managing Image metadata tagged for json and sql serialization
 type Image struct {
-	Id        int32  `json:"id" sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
-	Uid       int32  `json:"uid" sql:"uid"`
-	Title     string `json:"title" sql:"title"`
-	Ref       string `json:"ref" sql:"ref"`
-	Size      int32  `json:"size" sql:"size"`
-	Encoding  string `json:"encoding" sql:"encoding"`
-	Shareable bool   `json:"shareable" sql:"shareable"`
-	// UploadDate Expansion opportunity
+	Id          int32     `json:"id" sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid         int32     `json:"uid" sql:"uid"`
+	Title       string    `json:"title" sql:"title"`
+	Ref         string    `json:"ref" sql:"ref"`
+	Size        int32     `json:"size" sql:"size"`
+	Encoding    string    `json:"encoding" sql:"encoding"`
+	Shareable   bool      `json:"shareable" sql:"shareable"`
+	Description string    `json:"description" sql:"description" opt:"NOT NULL DEFAULT ''"`
+	AltText     string    `json:"altText" sql:"alt_text" opt:"NOT NULL DEFAULT ''"` // Alternative text read by screen readers
+	Hash        string    `json:"hash" sql:"hash" opt:"NOT NULL DEFAULT ''"`        // Hex sha256 of the file content
+	PHash       string    `json:"-" sql:"phash" opt:"NOT NULL DEFAULT ''"`          // Hex perceptual hash recorded by the phash processor
+	FileKey     string    `json:"-" sql:"file_key" opt:"NOT NULL DEFAULT ''"`       // Storage key of the file, the blob of its content or a file stored before content addressing
+	Uploaded    time.Time `json:"-" sql:"uploaded" opt:"NOT NULL DEFAULT now()"`
+	Taken       time.Time `json:"-" sql:"taken" opt:"NOT NULL DEFAULT now()"`             // EXIF taken date, the upload date if the file has none
+	DeletedAt   time.Time `json:"-" sql:"deleted_at" opt:"NOT NULL DEFAULT '0001-01-01'"` // Moment the image was moved to the trash, zero otherwise
+
+	Tags          []string        `json:"tags"`          // Stored in the image_tags table
+	Mentions      []MentionEntity `json:"mentions"`      // Users mentioned in the description, stored in the mention table
+	LikeCount     int64           `json:"likeCount"`     // Users liking the image, stored in the image_like table
+	Rating        float64         `json:"rating"`        // Average stars of the ratings stored in the image_rating table, 0 if unrated
+	RatingCount   int64           `json:"ratingCount"`   // Users who rated the image
+	ViewCount     int64           `json:"viewCount"`     // Views recorded in the image_view table
+	DownloadCount int64           `json:"downloadCount"` // Downloads recorded in the image_view table
 }
 
 type QueryResp struct {
@@ -50,15 +67,17 @@ type QueryResp struct {
 	PageSize     int     `json:"pageSize"`
 	TotalResults int     `json:"totalResults"`
 	ImageMeta    []Image `json:"imageMeta"`
+	Facets       Facets  `json:"facets"`         // Counts across every page of the query
+	Prev         string  `json:"prev,omitempty"` // Previous page of the query, omitted on the first page
+	Next         string  `json:"next,omitempty"` // Next page of the query, omitted on the last page
 }
 
 // ImageParams are mutable parameters that can be defined by users
-// these can be expanded to allow for more user defined features like tags, ratings, likes, prices
+// these can be expanded to allow for more user defined features like tags, prices
 type ImageParams struct {
 	Title     string `json:"title"`
 	Shareable string `json:"shareable"`
-	// Rating Expansion opportunity
-	// Tags     []byte `json:"tags" sql:"tags"` // Expansion opportunity, tagging images
+	Tags      string `json:"tags"` // Comma separated list of tags, replaces existing tags
 }
 
 // Used for managing User metadata tagged for json and sql serialization
@@ -68,6 +87,7 @@ type User struct {
 	Firstname string `json:"firstname" sql:"firstname"`
 	Lastname  string `json:"lastname" sql:"lastname"`
 	Email     string `json:"email" sql:"email"`
+	Username  string `json:"username" sql:"username" opt:"NOT NULL DEFAULT ''"` // Name mentioned with @, empty until chosen
 }
 
 // Used for managing User Passwords hashed passwords
@@ -78,36 +98,186 @@ type UserPassword struct {
 }
 
 type TokenResp struct {
-	Name       string `json:"name"`
-	Value      string `json:"token"`
-	Expiration string `json:"expiration"`
+	Name       string    `json:"name"`
+	Value      string    `json:"token"`
+	Expiration Timestamp `json:"expiration"`
 }
 
 type JWTClaims struct {
-	Email string
-	Uid   int
+	Email    string
+	Uid      int
+	ClientId string `json:",omitempty"` // Client acting on behalf of the user with an access token
+	Scope    string `json:",omitempty"` // Space separated scopes granted to the client
 	jwt.StandardClaims
 }
 
-// configureRoutes assigns all the routing parameters and returns a router for service
+// configureRoutes returns the router of a server keeping its data in the database
 func configureRoutes() *mux.Router {
+	return (&Server{store: sqlStore{}}).routes()
+}
+
+// routes assigns all the routing parameters and returns a router for service
+func (s *Server) routes() *mux.Router {
 	// establish router
 	router := mux.NewRouter()
 
-	// add routes
-	// Basic service endpoints
-	router.HandleFunc("/", home).Methods("GET", "OPTIONS", "POST", "PUT", "DELETE")
-	router.HandleFunc("/ping", ping).Methods("GET", "OPTIONS")
+	// Service endpoints outside of the versioned API
+	service := router.NewRoute().Subrouter()
+	service.HandleFunc("/", home).Methods("GET", "OPTIONS", "POST", "PUT", "DELETE")
+	service.HandleFunc("/ping", ping).Methods("GET", "OPTIONS")
+	service.HandleFunc("/healthz", healthz).Methods("GET", "OPTIONS")
+	service.HandleFunc("/readyz", readyz).Methods("GET", "OPTIONS")
+	service.HandleFunc("/metrics", metrics).Methods("GET", "OPTIONS")
+	service.HandleFunc("/api/changelog", changelog).Methods("GET", "OPTIONS")
+	service.HandleFunc("/api/openapi.json", openapiDocument).Methods("GET", "HEAD", "OPTIONS")
+	service.HandleFunc("/api/docs", apiDocs).Methods("GET", "OPTIONS")
+	service.HandleFunc("/.well-known/jwks.json", publishJWKS).Methods("GET", "OPTIONS")
+
+	// Version 1 of the API, later versions are mounted beside it under their own prefix
+	v1 := router.PathPrefix(API_V1_PREFIX).Subrouter()
+	s.apiV1Routes(v1)
+
+	// Unversioned aliases of version 1 for clients predating versioning, deprecated until removed
+	legacy := router.NewRoute().Subrouter()
+	legacy.Use(legacyAliases)
+	s.apiV1Routes(legacy)
+
+	for _, sub := range []*mux.Router{service, v1, legacy} {
+		useMiddleware(router, sub)
+	}
+
+	// Paths without a route serve the web client when one is configured
+	if dir := webDir(); len(dir) > 0 {
+		router.NotFoundHandler = compressResponses(webClient(dir))
+	}
+
+	return router
+}
+
+// apiV1Routes registers the endpoints of version 1 of the API on the router
+func (s *Server) apiV1Routes(router *mux.Router) {
+	// Open and authentication endpoints
+	router.HandleFunc("/announcements", listAnnouncements).Methods("GET", "OPTIONS")
 	router.HandleFunc("/register", register).Methods("POST", "OPTIONS")
 	router.HandleFunc("/auth", auth).Methods("GET", "OPTIONS")
+	router.HandleFunc("/auth/totp", completeTotpLogin).Methods("POST", "OPTIONS")
+	router.HandleFunc("/auth/signout", signOut).Methods("POST", "OPTIONS")
+	router.HandleFunc("/auth/{provider}/login", socialLogin).Methods("GET", "OPTIONS")
+	router.HandleFunc("/auth/{provider}/callback", socialCallback).Methods("GET", "OPTIONS")
+
+	// User account endpoints
+	router.HandleFunc("/user", getUser).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user", updateUser).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/user/quota", userQuota).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/storage/estimate", userStorageEstimate).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/activity", userActivity).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/password", changePassword).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/user/password/reset-request", requestPasswordReset).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/password/reset", resetPassword).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/notifications", listNotifications).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/notifications/read", readNotifications).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/blocks", blockUser).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/blocks", listBlocks).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/blocks/{uid:[0-9]+}", unblockUser).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/user/totp", getTotpStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/totp", enrollTotp).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/totp", disableTotp).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/user/totp/verify", verifyTotp).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/api-keys", createApiKey).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/api-keys", listApiKeys).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/api-keys/{id:[0-9]+}", deleteApiKey).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/user/webhooks", createWebhook).Methods("POST", "OPTIONS")
+	router.HandleFunc("/user/webhooks", listWebhooks).Methods("GET", "OPTIONS")
+	router.HandleFunc("/user/webhooks/{id:[0-9]+}", deleteWebhook).Methods("DELETE", "OPTIONS")
+
+	// Administration endpoints restricted to ADMIN_UIDS
+	router.HandleFunc("/admin/users/import", importUsers).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/sharing-policy", sharingPolicy).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/sharing-policy", updateSharingPolicy).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/admin/ingest-policy", ingestPolicy).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/ingest-policy", updateIngestPolicy).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/admin/moderation-policy", moderationPolicy).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/moderation-policy", updateModerationPolicy).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/admin/moderation/cases", listModerationCases).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/moderation/cases/{id:[0-9]+}", resolveModerationCase).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/admin/users/{uid:[0-9]+}/purge", purgeUser).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/purges/{id:[0-9]+}", purgeStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/reencode-campaigns", startReencode).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/reencode-campaigns/{id:[0-9]+}", reencodeStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/storage", storageStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/storage/estimate", orgStorageEstimate).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/storage/checks", startStorageCheck).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/storage/checks/{id:[0-9]+}", storageCheckStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/storage/checks/{id:[0-9]+}/{kind:orphans|missing}", listStorageFindings).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/announcements", adminListAnnouncements).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/announcements", createAnnouncement).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/announcements/{id:[0-9]+}", updateAnnouncement).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/admin/announcements/{id:[0-9]+}", deleteAnnouncement).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/admin/signing-keys", listSigningKeys).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/signing-keys/reload", reloadSigningKeys).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/faults", getFaults).Methods("GET", "OPTIONS")
+	router.HandleFunc("/admin/faults", updateFaults).Methods("PUT", "DELETE", "OPTIONS")
 
 	// Basic image creation endpoint
-	router.HandleFunc("/image", addImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image", s.addImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/batch", s.batchUpload).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/intake", intakeUpload).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/intake/{id:[0-9]+}", intakeStatus).Methods("GET", "OPTIONS")
+
+	// Direct upload endpoints authorized by signed upload policies
+	router.HandleFunc("/image/policy", issueUploadPolicy).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/upload", s.policyUpload).Methods("POST", "OPTIONS")
+
+	// Multi-select changes and deletion of images
+	router.HandleFunc("/image/bulk", bulkUpdate).Methods("PATCH", "OPTIONS")
+	router.HandleFunc("/image/bulk", bulkDelete).Methods("DELETE", "OPTIONS")
+
+	// Grants of view access to an image for other accounts, reports and EXIF data of an image, registered
+	// before the image data endpoints which would otherwise match them
+	router.HandleFunc("/image/{id:[0-9]+}/shares", s.shareImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/{id:[0-9]+}/shares", s.listImageShares).Methods("GET", "OPTIONS")
+	router.HandleFunc("/image/{id:[0-9]+}/shares/{uid:[0-9]+}", s.revokeImageShare).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/image/{id:[0-9]+}/reports", s.reportImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/{id:[0-9]+}/exif.json", s.getImageExif).Methods("GET", "OPTIONS")
+
+	// Moderation cases about the requester's images
+	router.HandleFunc("/moderation/cases", listOwnModerationCases).Methods("GET", "OPTIONS")
+	router.HandleFunc("/moderation/cases/{id:[0-9]+}/appeal", appealModerationCase).Methods("POST", "OPTIONS")
 
 	// Image data endpoints
-	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", getImage).Methods("GET", "OPTIONS")
-	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", delImage).Methods("DELETE", "OPTIONS")
-	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", updateImage).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", s.getImage).Methods("GET", "HEAD", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", s.delImage).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}", s.updateImage).Methods("PUT", "OPTIONS")
+
+	// Trash of deleted images
+	router.HandleFunc("/image/trash", listTrash).Methods("GET", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/restore", s.restoreImage).Methods("POST", "OPTIONS")
+
+	// Daily views and downloads of an image of the user
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/stats", s.getImageStats).Methods("GET", "OPTIONS")
+
+	// Likes of images the user can view
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/like", s.likeImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/like", s.unlikeImage).Methods("DELETE", "OPTIONS")
+
+	// Ratings and comments of images the user can view
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/rating", s.rateImage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/comments", s.postComment).Methods("POST", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/comments", s.listComments).Methods("GET", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/comments/{commentId:[0-9]+}", s.editComment).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/image/{uid:[0-9]+}/{fileId}/comments/{commentId:[0-9]+}", s.deleteComment).Methods("DELETE", "OPTIONS")
+
+	// Groups of identical or similar images of the user
+	router.HandleFunc("/image/duplicates", listDuplicates).Methods("GET", "OPTIONS")
+
+	// Similarity metrics and a rendering of the differences between two viewable images
+	router.HandleFunc("/image/compare", s.compareImage).Methods("GET", "OPTIONS")
+
+	// Live stream of changes to the images the user can see
+	router.HandleFunc("/events", streamEvents).Methods("GET", "OPTIONS")
+
+	// Public image data endpoint for shareable images, does not require authentication
+	router.HandleFunc("/public/image/{uid:[0-9]+}/{fileId}", s.getPublicImage).Methods("GET", "HEAD", "OPTIONS")
 
 	// Image meta query methods
 	router.HandleFunc("/image/meta?", imageMetaRequest).Queries(
@@ -118,47 +288,120 @@ func configureRoutes() *mux.Router {
 		"encoding", "{encoding}",
 		"shareable", "{shareable)").Methods("GET")
 	router.HandleFunc("/image/meta", imageMetaRequest).Methods("GET", "OPTIONS")
-
-	return router
+	router.HandleFunc("/image/meta/export", exportImageMeta).Methods("GET", "OPTIONS")
+
+	// Album endpoints, shareable albums are also served without authentication
+	router.HandleFunc("/album", createAlbum).Methods("POST", "OPTIONS")
+	router.HandleFunc("/album", listAlbums).Methods("GET", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}", getAlbum).Methods("GET", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}", updateAlbum).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}", deleteAlbum).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/images", addAlbumImages).Methods("POST", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/images/{imageId:[0-9]+}", removeAlbumImage).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/order", reorderAlbum).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/cover", setAlbumCover).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/cover", resetAlbumCover).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/public/album/{id:[0-9]+}", s.getPublicAlbum).Methods("GET", "OPTIONS")
+
+	// Guest upload links
+	router.HandleFunc("/album/{id:[0-9]+}/guest-links", createGuestLink).Methods("POST", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/guest-links", listGuestLinks).Methods("GET", "OPTIONS")
+	router.HandleFunc("/album/{id:[0-9]+}/guest-links/{linkId:[0-9]+}", deleteGuestLink).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/guest/{token:[0-9a-f]+}", getDropBox).Methods("GET", "OPTIONS")
+	router.HandleFunc("/guest/{token:[0-9a-f]+}", s.guestUpload).Methods("POST", "OPTIONS")
+
+	// Timeline of the user's images grouped by the date they were taken
+	router.HandleFunc("/timeline", getTimeline).Methods("GET", "OPTIONS")
+
+	// Third party client registration, consent and token endpoints
+	router.HandleFunc("/oauth/clients", registerOAuthClient).Methods("POST", "OPTIONS")
+	router.HandleFunc("/oauth/clients", listOAuthClients).Methods("GET", "OPTIONS")
+	router.HandleFunc("/oauth/clients/{clientId}", deleteOAuthClient).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/oauth/consent", oauthConsent).Methods("GET", "OPTIONS")
+	router.HandleFunc("/oauth/consent", grantOAuthConsent).Methods("POST", "OPTIONS")
+	router.HandleFunc("/oauth/grants", listOAuthGrants).Methods("GET", "OPTIONS")
+	router.HandleFunc("/oauth/grants/{clientId}", revokeOAuthGrant).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/oauth/token", issueOAuthToken).Methods("POST", "OPTIONS")
 }
 
 // serve starts the http server and listens on port assigned above
-func serve() error {
+func serve(config Config) error {
+
+	configureCors(config.Cors)
+	configureCookie(config.Cookie)
+	configureWeb(config.Web)
+
+	// Load the password policy and its denylist
+	err := configurePassword(config.Password)
+	if err != nil {
+		return err
+	}
+
+	// Load the keys signing tokens, reloaded on SIGHUP
+	_, err = reloadKeyring()
+	if err != nil {
+		return err
+	}
+	watchKeyring()
 
 	router := configureRoutes()
 
 	http.Handle("/", router)
 
-	// Define port and set to default if environment variable is not set
-	port := PORT
-	if len(os.Getenv("GO_PORT")) > 0 {
-		port = os.Getenv("GO_PORT")
+	// Configure file storage
+	err = initStorage(config.Storage.Driver, config.Storage.Mirror)
+	if err != nil {
+		return err
+	}
+
+	// Configure the store of rate limits, revoked tokens and nonces shared by replicas
+	err = initState(config.State)
+	if err != nil {
+		return err
+	}
+
+	// Start background processing of uploads
+	err = startPipeline()
+	if err != nil {
+		return err
 	}
 
-	logger.Info("Initiating HTTP Server on Port %v", port)
-	return (http.ListenAndServe(port, router))
+	// Start periodic background jobs
+	startScheduler(context.Background())
+
+	// Start recording counted image views
+	startViewCounter(context.Background())
+
+	// Start relaying library events and image meta invalidations between instances, without it streams only
+	// receive the events of this instance and cached metadata changed by other instances is served until it expires
+	err = startEventRelay(context.Background())
+	if err != nil {
+		logger.Error("library events and image meta invalidations are not relayed between instances: %v", err)
+	}
+
+	// Start delivering analytics events when a sink is configured
+	err = startAnalytics(config.Analytics)
+	if err != nil {
+		return err
+	}
+
+	return listen(config.Listen, router)
 }
 
+// home confirms the server is online, GET requests receive the web client when one is configured
 func home(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
+	// The web client is served at the root when configured
+	if dir := webDir(); len(dir) > 0 && req.Method == "GET" {
+		serveWebIndex(w, req, dir)
 		return
 	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("200 - OK Picto Cache server online"))
 }
 
 // ping responds to the url pattern /ping with a simple message to validate server
 func ping(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
 	resp := PingResp{
 		Message: "pong",
 	}
@@ -176,13 +419,6 @@ func ping(w http.ResponseWriter, req *http.Request) {
 }
 
 func register(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
 	// Ensure request is multipart/form-data
 	contentType := req.Header.Get("Content-Type")
 	if !strings.Contains(contentType, "multipart/form-data") {
@@ -225,39 +461,29 @@ func register(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Add user to database
-	user.Uid, err = AddUserData(user)
-	if err != nil {
-		logger.Error("Unable to add account to database sending 500")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to register account try again later"))
+	// Refuse passwords failing the password policy
+	if !enforcePassword(w, req, password, user.Email) {
 		return
 	}
 
 	// Attempt to hash password for storage
 	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		logger.Error("Failed to hash password cleaning user and sending 500: %v", err)
+		logger.Error("Failed to hash password sending 500: %v", err)
 		w.WriteHeader((http.StatusInternalServerError))
 		w.Write([]byte("500 - Unable to hash password try again later"))
-		DeleteUserData(user)
 		return
 	}
 
-	pass := UserPassword{
-		Uid:        user.Uid,
-		HashedPass: string(hashedPass),
-	}
-
-	// Add hashed password to password table
-	_, err = AddUserPass(pass)
+	// Add user, password and registration event to database together
+	user.Uid, err = RegisterUser(user, string(hashedPass))
 	if err != nil {
-		logger.Error("Failed to store hashed password cleaning user and sending 500: %v", err)
-		w.WriteHeader((http.StatusInternalServerError))
-		w.Write([]byte("500 - Unable to store hash password try again later"))
-		DeleteUserData(user)
+		logger.Error("Unable to add account to database sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to register account try again later"))
 		return
 	}
+	recordAudit(req, int(user.Uid), AUDIT_REGISTER, 0)
 
 	// Generate and set JWT
 	token, exp, err := generateJWT(int(user.Uid), user.Email)
@@ -269,17 +495,13 @@ func register(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Set JWT Cookie with the name token
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   token,
-		Expires: time.Unix(exp, 0),
-	})
+	setTokenCookie(w, token, exp)
 
 	// Prepare to marshal into json
 	tokenResp := TokenResp{
-		Name:       "token",
+		Name:       TOKEN_COOKIE,
 		Value:      token,
-		Expiration: time.Unix(exp, 0).String(),
+		Expiration: Timestamp(time.Unix(exp, 0)),
 	}
 
 	resp, err := json.Marshal(tokenResp)
@@ -295,13 +517,6 @@ func register(w http.ResponseWriter, req *http.Request) {
 }
 
 func auth(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
 	// Retrieve basic auth credentials
 	email, password, _ := req.BasicAuth()
 
@@ -321,7 +536,12 @@ func auth(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !requireSecondFactor(w, req, user, AUDIT_LOGIN, "") {
+		return
+	}
+
 	logger.Info("Successfull login for user: %v", email)
+	recordAudit(req, int(user.Uid), AUDIT_LOGIN, 0)
 
 	// Generate and set JWT
 	token, exp, err := generateJWT(int(user.Uid), user.Email)
@@ -333,17 +553,13 @@ func auth(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Set JWT Cookie with the name token
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   token,
-		Expires: time.Unix(exp, 0),
-	})
+	setTokenCookie(w, token, exp)
 
 	// Prepare to marshal into json
 	tokenResp := TokenResp{
-		Name:       "token",
+		Name:       TOKEN_COOKIE,
 		Value:      token,
-		Expiration: time.Unix(exp, 0).String(),
+		Expiration: Timestamp(time.Unix(exp, 0)),
 	}
 
 	resp, err := json.Marshal(tokenResp)
@@ -358,6 +574,32 @@ func auth(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// signOut revokes the auth token of the request until it expires and clears the token cookie,
+// tokens issued before revocation was supported carry no id and remain valid until they expire
+func signOut(w http.ResponseWriter, req *http.Request) {
+	_, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to sign out sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	// The cookie takes precedence over the bearer token as it does when authenticating
+	tokenStr := bearerToken(req)
+	if cookie, err := req.Cookie(TOKEN_COOKIE); err == nil {
+		tokenStr = cookie.Value
+	}
+	claims, err := parseToken(tokenStr)
+	if err == nil && len(claims.Id) > 0 {
+		revokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0))
+		logger.Info("User %v signed out", claims.Uid)
+	}
+
+	setTokenCookie(w, "", 0)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func generateJWT(uid int, email string) (string, int64, error) {
 
 	// Set expiration to 30 minutes from login
@@ -367,14 +609,11 @@ func generateJWT(uid int, email string) (string, int64, error) {
 		Email: email,
 		Uid:   uid,
 		StandardClaims: jwt.StandardClaims{
+			Id:        newTokenId(), // Identifies the token when it is revoked by signing out
 			ExpiresAt: exp,
 		},
 	}
-	signingKey := getSigningKey()
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenStr, err := token.SignedString(signingKey)
+	tokenStr, err := signToken(claims)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to sign jwt: %v", err)
 	}
@@ -382,9 +621,40 @@ func generateJWT(uid int, email string) (string, int64, error) {
 	return tokenStr, exp, err
 }
 
-// getSigningKey retrievs the secret key from the SIGNING_KEY environent variable
-// this function can be replaced with other methods for retrieving keys for example if
-// they are stored on disk as a PEM or similar file
+// issueToken generates an auth token for the user and sets it as the token cookie, responding
+// 401 and reporting false if it can't be generated
+func issueToken(w http.ResponseWriter, user User) (string, int64, bool) {
+	token, exp, err := generateJWT(int(user.Uid), user.Email)
+	if err != nil {
+		logger.Error("Failed to generate jwt, sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, unable to generate valid token"))
+		return "", 0, false
+	}
+	setTokenCookie(w, token, exp)
+	return token, exp, true
+}
+
+// writeTokenResp writes the auth token as the json response body
+func writeTokenResp(w http.ResponseWriter, token string, exp int64) {
+	resp, err := json.Marshal(TokenResp{
+		Name:       TOKEN_COOKIE,
+		Value:      token,
+		Expiration: Timestamp(time.Unix(exp, 0)),
+	})
+	if err != nil {
+		logger.Error("failed to marshal token, sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to marshal token, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// getSigningKey retrievs the secret key from the SIGNING_KEY environent variable, the key
+// signing tokens when no keyring is configured, see loadKeyring
 func getSigningKey() []byte {
 	// Get signing key
 	signingKey := []byte(os.Getenv("SIGNING_KEY"))
@@ -395,43 +665,25 @@ func getSigningKey() []byte {
 	return signingKey
 }
 
-// authRequest accepts the http request and parses the attached jwt token
-// and returns the JWTClaims for the assigned jwt which is stored
-// in a cookie. Users also have the opportunity to use the token as bearer token
+// authRequest returns the claims of the principal authenticated by the credentials of the request,
+// see authenticate for the credentials accepted
 func authRequest(req *http.Request) (JWTClaims, error) {
-
-	// init tokenStr
-	tokenStr := ""
-
-	// attempt to retrieve from cookie, if not assign the value of the authorization header
-	cookie, err := req.Cookie("token")
+	principal, err := requestPrincipal(req)
 	if err != nil {
-		tokenStr = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
-	} else {
-		tokenStr = cookie.Value
+		return JWTClaims{}, err
 	}
 
-	claims := &JWTClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return getSigningKey(), nil
-	})
-	if err != nil || !token.Valid {
-		return JWTClaims{}, fmt.Errorf("failed to parse jwt/invalid token, unauthorized")
+	// Client access tokens are only accepted once checked against the user's grant
+	if len(principal.ClientId) > 0 && !clientAuthorized(req) {
+		return JWTClaims{}, fmt.Errorf("client token not authorized for request, unauthorized")
 	}
 
-	return *claims, nil
+	return principal.Claims(), nil
 }
 
-// getImage returns the image defined in the url parameters if the user is authorized to view it
-func getImage(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
+// getImage returns the image defined in the url parameters if the user is authorized to view it,
+// its metadata as json with meta=true. HEAD requests receive the headers without the body
+func (s *Server) getImage(w http.ResponseWriter, req *http.Request) {
 	// Authorize request
 	claims, err := authRequest(req)
 	if err != nil {
@@ -445,7 +697,7 @@ func getImage(w http.ResponseWriter, req *http.Request) {
 
 	// validate url parameters and retrieve imageMeta
 	// returns a 404 if data cannot be found in the db otherwise assumes bad request
-	imageMeta, err := validateVars(vars)
+	imageMeta, err := s.validateVars(req.Context(), vars)
 	if err != nil {
 		if err != nil {
 			logger.Error("Failed to validate vars sending 400: %v", err)
@@ -460,174 +712,299 @@ func getImage(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	// Ensure user has access permissions
-	if claims.Uid != int(imageMeta.Uid) {
-		logger.Error("unauthorized user attempting to delete image")
+	// Ensure user owns the image or was granted access
+	allowed, err := s.canViewImage(req.Context(), claims.Uid, imageMeta)
+	if err != nil {
+		logger.Error("failed to retrieve image shares sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve file, try again later"))
+		return
+	}
+	if !allowed {
+		logger.Error("unauthorized user %v attempting to view image %v", claims.Uid, imageMeta.Id)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("401 - Unauthorized, this file is private and you do not have access"))
 		return
 	}
 
-	// prepare file for sending
-	fileBytes, err := ioutil.ReadFile(fmt.Sprintf("./%s/%s/%s", IMAGE_DIR, vars["uid"], vars["fileId"]))
-	if err != nil {
-		logger.Error("Failed to retrieve file: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to retrieve file, try again later"))
+	// Metadata requests return the image meta as json instead of the file
+	if metaRequested(req) {
+		writeImageMeta(w, req, imageMeta)
+		return
 	}
 
-	w.Header().Set("Content-Type", imageMeta.Encoding)
-	w.Write(fileBytes)
+	// Browsers may keep the image, shared caches must not
+	cacheControl, maxAge := privateCacheControl()
+	setCacheHeaders(w.Header(), cacheControl, maxAge, time.Now())
+
+	// HEAD requests only inspect the image and aren't counted as views
+	if req.Method == "GET" {
+		access := "private"
+		if claims.Uid != int(imageMeta.Uid) {
+			access = "granted"
+		}
+		emitAnalytics(ANALYTICS_VIEW, claims.Uid, imageMeta, map[string]string{"access": access, "variant": strconv.FormatBool(variantRequested(req))})
+	}
+	countImageRequest(w, req, imageMeta)
+
+	// Serve a resized or converted variant when requested
+	if variantRequested(req) {
+		writeImageVariant(w, req, imageMeta)
+		return
+	}
+
+	// Serve a smaller encoding the client accepts unless client hints ask for the image to be scaled
+	scaled := clientHintsApply(imageMeta) && hintedWidth(req) > 0
+	if !scaled && s.writeReencodedImage(w, req, imageMeta) {
+		return
+	}
+
+	// Otherwise size the image for the client hints of the request
+	if clientHintsApply(imageMeta) {
+		writeHintedImage(w, req, imageMeta)
+		return
+	}
+
+	writeImageFile(w, req, imageMeta, imageKey(imageMeta))
 	return
 }
 
-// addImage accepts multipart form-data with image metadata
-// this function checks to ensure the image is of type jpg or png
-func addImage(w http.ResponseWriter, req *http.Request) {
+// getPublicImage returns the image defined in the url parameters without authentication
+// only images marked as shareable are served, private images are reported as not found
+func (s *Server) getPublicImage(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
 
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
+	// validate url parameters and retrieve imageMeta
+	// returns a 404 if data cannot be found in the db otherwise assumes bad request
+	imageMeta, err := s.validateVars(req.Context(), vars)
+	if err != nil {
+		logger.Error("Failed to validate vars: %v", err)
+		if strings.Contains(err.Error(), "404 - Not found") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not found, no image with that information available"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
 		return
 	}
 
-	claims, err := authRequest(req)
-	if err != nil {
-		logger.Error("Unauthorized request to upload sending 401: %v", err)
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+	// Private images and mismatched owners are indistinguishable from missing images
+	uidVal, err := strconv.Atoi(vars["uid"])
+	if err != nil || uidVal != int(imageMeta.Uid) {
+		logger.Error("public request for mismatched image %v sending 404", imageMeta.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
 		return
 	}
 
-	// attempt to retrieve file from form
-	img, imgHeader, err := req.FormFile("image")
+	// Images are shared by their own flag or by belonging to a shareable album
+	shared, err := s.publiclyShared(req.Context(), imageMeta)
 	if err != nil {
-		logger.Error("failed to read file sending 500: %v", err)
+		logger.Error("failed to retrieve image albums sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to read file, try again later"))
+		w.Write([]byte("500 - Failed to retrieve file, try again later"))
+		return
+	}
+	if !shared {
+		logger.Error("public request for private image %v sending 404", imageMeta.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
 		return
 	}
-	defer img.Close()
 
-	// Read small part of file to ID content type
-	buffer := make([]byte, 512)
-	_, err = img.Read(buffer)
+	// Users blocked by the owner can't view the image, like private images
+	blocked, err := s.blockedViewer(req, imageMeta.Uid)
 	if err != nil {
-		logger.Error("failed to validate file type sending 400: %v", err)
+		logger.Error("failed to retrieve blocks sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("400 - Failed to validate file type, ensure the file is correctly formatted as a jpeg (jpg) or png"))
+		w.Write([]byte("500 - Failed to retrieve file, try again later"))
+		return
+	}
+	if blocked {
+		logger.Error("public request for image %v by blocked user sending 404", imageMeta.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
 		return
 	}
 
-	// Read enough of file to determine type
-	fileType := http.DetectContentType(buffer)
-
-	// Reset the pointer location for writing later
-	img.Seek(0, 0)
-
-	// Validate Content-Type and image type
-	contentType := req.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "multipart/form-data") || (fileType != "image/jpeg" && fileType != "image/png") {
-		logger.Error("file type failure not accepted sending 400: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("400 - Failed to upload, please use multipart form data with an image of type jpeg (jpg) or png"))
+	policy, err := s.store.SharingPolicy(req.Context())
+	if err != nil {
+		logger.Error("failed to retrieve sharing policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve file, try again later"))
 		return
 	}
 
-	// Generate file extension based on data type
-	fileExt := strings.Split(fileType, "/")[1]
+	cacheControl, maxAge := publicCacheControl(req, imageMeta, policy)
+	setCacheHeaders(w.Header(), cacheControl, maxAge, time.Now())
 
-	uid := claims.Uid
+	// Public viewers are anonymous
+	if req.Method == "GET" {
+		emitAnalytics(ANALYTICS_VIEW, 0, imageMeta, map[string]string{"access": "public", "variant": strconv.FormatBool(variantRequested(req))})
+	}
+	countImageRequest(w, req, imageMeta)
 
-	// default to not shareable unless explicitly false
-	shareable := false
-	if req.FormValue("shareable") == "true" {
-		shareable = true
+	// Serve the watermarked copy when required by the sharing policy, creating it on first request
+	if policy.Watermark {
+		err = ensureWatermark(req.Context(), imageMeta)
+		if err == ErrUnsupportedEncoding {
+			logger.Error("image %v of type %s cannot be watermarked sending 403", imageMeta.Id, imageMeta.Encoding)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 - Image cannot be shared publicly"))
+			return
+		}
+		if err != nil {
+			logger.Error("failed to watermark image %v sending 500: %v", imageMeta.Id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to retrieve file, try again later"))
+			return
+		}
+		writeImageFile(w, req, imageMeta, watermarkKey(imageMeta))
+		return
 	}
 
-	// ensure storage directory for the user exists
-	err = os.MkdirAll(fmt.Sprintf("./%s/%v", IMAGE_DIR, uid), os.ModePerm)
-	if err != nil {
-		logger.Error("failed to establish image directory: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to read file, try again later"))
+	// Serve a smaller encoding the client accepts unless client hints ask for the image to be scaled
+	scaled := clientHintsApply(imageMeta) && hintedWidth(req) > 0
+	if !scaled && s.writeReencodedImage(w, req, imageMeta) {
 		return
 	}
 
-	// Determine if filename exists
-	title := req.FormValue("title")
-	if len(title) == 0 {
-		title = imgHeader.Filename
+	// Otherwise size the image for the client hints of the request
+	if clientHintsApply(imageMeta) {
+		writeHintedImage(w, req, imageMeta)
+		return
 	}
 
-	// Manually assign extension even if one is already there
-	title = fmt.Sprintf("%s.%s", strings.Split(title, ".")[0], fileExt)
+	writeImageFile(w, req, imageMeta, imageKey(imageMeta))
+	return
+}
 
-	// Prepare image meta for SQL storage
-	imageData := Image{
-		Uid:       int32(uid),
-		Title:     title,
-		Size:      int32(imgHeader.Size),
-		Ref:       "", // placeholder reference for update after id is assigned to ensure unique filename
-		Shareable: shareable,
-		Encoding:  fileType,
+// writeImageFile serves the file stored at key for the image with support for range and conditional requests
+func writeImageFile(w http.ResponseWriter, req *http.Request, imageMeta Image, key string) {
+
+	// prepare file for sending
+	file, ok := openImageFile(w, req, imageMeta, key)
+	if !ok {
+		return
 	}
+	defer file.Close()
+
+	serveImageFile(w, req, imageMeta, key, file)
+}
+
+// metaRequested reports whether the request asks for the metadata of the image with meta=true
+func metaRequested(req *http.Request) bool {
+	meta, err := strconv.ParseBool(req.URL.Query().Get("meta"))
+	return err == nil && meta
+}
 
-	// Insert image data and retrieve unique id
-	imageData.Id, err = AddImageData(imageData)
+// writeImageMeta sends the metadata of the image as json, HEAD requests receive the headers only
+func writeImageMeta(w http.ResponseWriter, req *http.Request, imageMeta Image) {
+	js, err := json.Marshal(imageMeta)
 	if err != nil {
-		logger.Error("failed to add image meta: %v", err)
+		logger.Error("Failed to marshal image meta sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to add image meta, try again later"))
+		w.Write([]byte("500 - failed to marshal response, try again later"))
 		return
 	}
 
-	// Get REF_URL
-	refUrl := os.Getenv("REF_URL")
-	if len(refUrl) == 0 {
-		refUrl = REF_URL
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(js)))
+	if req.Method == "HEAD" {
+		return
+	}
+	w.Write(js)
+}
+
+// serveImageFile sends the opened file stored at key for the image
+func serveImageFile(w http.ResponseWriter, req *http.Request, imageMeta Image, key string, file Object) {
+	// The recorded hash only describes the stored image, not its thumbnail or watermarked copy
+	if key == imageKey(imageMeta) {
+		setDigest(w.Header(), imageMeta.Hash)
 	}
 
-	// Generate file reference string with unique file name in the format of IMAGE_DIR/UID/ID.ext
-	imageData.Ref = fmt.Sprintf("%s/%s/%v/%v.%v", refUrl, IMAGE_DIR, imageData.Uid, imageData.Id, fileExt)
+	// ServeContent handles Range, If-Range, If-None-Match and If-Modified-Since
+	w.Header().Set("Content-Type", imageMeta.Encoding)
+	w.Header().Set("ETag", imageETag(imageMeta, key, file))
+	http.ServeContent(w, req, imageMeta.Title, file.ModTime(), file)
+}
 
-	// Update table with dynamic image reference
-	// This is can be extended to support third party storage solutions
-	err = UpdateImageData(imageData)
+// openImageFile opens the file stored at key for the image
+// writes the error response and returns false if it cannot be opened, a lost original is reported as gone
+func openImageFile(w http.ResponseWriter, req *http.Request, imageMeta Image, key string) (Object, bool) {
+	file, err := storage.Open(req.Context(), key)
 	if err != nil {
-		logger.Error("failed to update metadata with image reference: %v", err)
+		// Errors must not be cached with the headers intended for the image
+		dropCacheHeaders(w.Header())
+	}
+	if err == ErrObjectNotFound && key == imageKey(imageMeta) {
+		logger.Error("File missing for image %v sending 410", imageMeta.Id)
+		writeImageGone(w, req, imageMeta)
+		return nil, false
+	}
+	if err == ErrObjectNotFound {
+		logger.Error("File missing for image %v sending 404", imageMeta.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found"))
+		return nil, false
+	}
+	if err != nil {
+		logger.Error("Failed to retrieve file: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to update file referece in database, try again later"))
+		w.Write([]byte("500 - Failed to retrieve file, try again later"))
+		return nil, false
+	}
 
-		DeleteImageData(imageData) // Clean DB for unsuccessful update
+	return file, true
+}
 
+// addImage accepts multipart form-data with image metadata
+// this function checks to ensure the image is of type jpg or png
+func (s *Server) addImage(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to upload sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
 		return
 	}
 
-	// Generate local file reference string
-	fileRefStr := fmt.Sprintf("./%s/%v/%v.%v", IMAGE_DIR, imageData.Uid, imageData.Id, fileExt)
-
-	// create file with reference string for writing
-	fileRef, err := os.Create(fileRefStr)
+	// attempt to retrieve file from form
+	img, imgHeader, err := req.FormFile("image")
 	if err != nil {
-		logger.Error("failed to create file reference: %v", err)
+		logger.Error("failed to read file sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to create file reference, try again later"))
+		w.Write([]byte("500 - Failed to read file, try again later"))
+		return
+	}
+	defer img.Close()
+
+	// Validate Content-Type of the request
+	contentType := req.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		logger.Error("request content type not accepted sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Failed to upload, please use multipart form data with a supported image type"))
+		return
+	}
 
-		DeleteImageData(imageData) // Clean DB for unsuccessful update
+	// Tags are optional and provided as a comma separated list
+	tags, err := parseTags(req.FormValue("tags"))
+	if err != nil {
+		logger.Error("invalid tags sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
 		return
 	}
 
-	// save the file at the reference
-	_, err = io.Copy(fileRef, img)
+	imageData, err := s.saveImage(req.Context(), claims.Uid, img, imgHeader, req.FormValue("title"), uploadText(req), req.FormValue("shareable"), req.FormValue("dedup"), tags, acceptedTypes())
 	if err != nil {
 		logger.Error("failed to save image: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Failed to save file reference, try again later"))
-
-		DeleteImageData(imageData) // Clean DB for unsuccessful update
+		writeUploadError(w, err)
 		return
 	}
+	recordAudit(req, claims.Uid, AUDIT_UPLOAD, imageData.Id)
 
 	// marshal response in json
 	js, err := json.Marshal(imageData)
@@ -640,20 +1017,257 @@ func addImage(w http.ResponseWriter, req *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(js)
-	logger.Info("Successfully uploaded (Title: %v - Size: %v - Type: %v)", title, imgHeader.Size, fileType)
+	logger.Info("Successfully uploaded (Title: %v - Size: %v - Type: %v)", imageData.Title, imageData.Size, imageData.Encoding)
 	return
 }
 
-// delImage accepts multipart form-data with image metadata and deletes the appropriate
-// image given the requesting person has the authorization to do so
-func delImage(w http.ResponseWriter, req *http.Request) {
+// uploadError describes a failed step of saveImage along with the status and message
+// that should be reported to the client
+type uploadError struct {
+	Status  int
+	Message string
+	Err     error
+}
 
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+// writeUploadError reports an error returned by saveImage to the client
+func writeUploadError(w http.ResponseWriter, err error) {
+	status, message := uploadErrorStatus(err)
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// uploadErrorStatus returns the status and message that should be reported for an error returned by saveImage
+func uploadErrorStatus(err error) (int, string) {
+	uerr, ok := err.(*uploadError)
+	if !ok {
+		return http.StatusInternalServerError, "500 - Failed to save file, try again later"
+	}
+	return uerr.Status, uerr.Message
+}
+
+// unsupportedType returns the upload error refusing a file of a type outside the accepted types
+func unsupportedType(fileType string, accepted []string) error {
+	return &uploadError{http.StatusBadRequest, fmt.Sprintf("400 - Unsupported image type %s, accepted types are %s", fileType, strings.Join(accepted, ", ")), fmt.Errorf("file type %s not accepted", fileType)}
+}
+
+// saveImage validates the file type of an uploaded image against the accepted types narrowed
+// by the ingest policy, scans and strips the upload as the policy requires, applies the sharing policy to the requested shareable value, stores the image meta
+// and writes the file to storage for the provided uid unless it links an identical file
+// of another member according to the requested dedup mode. Under the reuse duplicate policy
+// the user's existing image is returned for content they already uploaded.
+// The file is staged before the image meta is recorded and moved into place as the image commits,
+// a failed upload records nothing and removes its staged file. Errors are returned as *uploadError
+func (s *Server) saveImage(ctx context.Context, uid int, img multipart.File, imgHeader *multipart.FileHeader, title string, text imageText, requestedShareable string, requestedDedup string, tags []string, accepted []string) (Image, error) {
+
+	// Read small part of file to ID content type
+	buffer := make([]byte, 512)
+	_, err := img.Read(buffer)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusBadRequest, "400 - Failed to validate file type, ensure the file is a correctly formatted image", err}
+	}
+
+	// Read enough of file to determine type
+	fileType := detectImageType(buffer)
+
+	// Reset the pointer location for writing later
+	img.Seek(0, 0)
+
+	// Validate image type, types the server doesn't accept are refused without loading the ingest policy
+	if !containsString(accepted, fileType) {
+		return Image{}, unsupportedType(fileType, accepted)
+	}
+
+	// Apply the ingest policy of the organisation, which may only narrow the accepted types further
+	policy, err := s.store.IngestPolicy(ctx)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to apply ingest policy, try again later", fmt.Errorf("failed to retrieve ingest policy: %v", err)}
+	}
+	rules := policy.Enforced()
+	accepted = rules.Accept(accepted)
+	if !containsString(accepted, fileType) {
+		return Image{}, unsupportedType(fileType, accepted)
+	}
+
+	// Date the image by the EXIF taken date for the timeline, falling back to the upload date,
+	// before the ingest rules strip the EXIF data
+	uploaded := time.Now().UTC()
+	taken := readTakenDate(img, fileType, uploaded)
+
+	// Scan the upload and strip its metadata as the ingest rules require
+	img, imgHeader, err = applyIngestRules(ctx, rules, img, imgHeader, fileType)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Validate the title, untitled uploads are named by their file name
+	title, err = validateTitle(title)
+	if err == nil && len(title) == 0 {
+		title, err = validateTitle(filenameTitle(imgHeader.Filename))
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusBadRequest, fmt.Sprintf("400 - %v", err), err}
+	}
+	text, err = validateImageText(text)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusBadRequest, fmt.Sprintf("400 - %v", err), err}
+	}
+
+	// Hash the content to version public references
+	hash, err := hashFile(img)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusBadRequest, "400 - Failed to read file, try again", fmt.Errorf("failed to hash image: %v", err)}
+	}
+
+	// Apply the duplicate policy to content the user already uploaded
+	existing, reuse, err := s.resolveDuplicate(ctx, int32(uid), hash)
+	if err == ErrDuplicateImage {
+		return Image{}, &uploadError{http.StatusConflict, fmt.Sprintf("409 - An identical image is already uploaded as image %v", existing.Id), err}
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to add image meta, try again later", fmt.Errorf("failed to find duplicate image: %v", err)}
+	}
+	if reuse {
+		return existing, nil
+	}
+
+	// Apply the sharing policy, unspecified shareable values use the organisation default
+	shareable, _, err := resolveShareable(ctx, s.store, requestedShareable)
+	if err == ErrSharingDisabled {
+		return Image{}, &uploadError{http.StatusForbidden, "403 - Public sharing is disabled by your organisation", err}
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to apply sharing policy, try again later", fmt.Errorf("failed to retrieve sharing policy: %v", err)}
+	}
+
+	// Look for identical content stored by another member
+	dedup, err := resolveDedup(requestedDedup)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusBadRequest, fmt.Sprintf("400 - %v", err), err}
+	}
+	linkKey := ""
+	if dedup != DEDUP_STORE {
+		source, found, err := s.store.FindImageByHash(ctx, hash, int32(uid))
+		if err != nil {
+			return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to add image meta, try again later", fmt.Errorf("failed to find duplicate content: %v", err)}
+		}
+		if found && dedup == DEDUP_PROMPT {
+			return Image{}, &uploadError{http.StatusConflict, "409 - An identical image is already stored in your organisation, upload again with dedup=link to link it or dedup=store to store a copy", ErrDuplicateContent}
+		}
+		if found {
+			linkKey = imageKey(source)
+		}
+	}
+
+	// Refuse files that would leave the image volume without enough free space, linked files aren't written
+	if len(linkKey) == 0 && checkDiskSpace(imgHeader.Size) == ErrInsufficientStorage {
+		return Image{}, &uploadError{http.StatusInsufficientStorage, "507 - Insufficient storage on the server, try again later", ErrInsufficientStorage}
+	}
+
+	// Generate file extension based on data type
+	fileExt := strings.Split(fileType, "/")[1]
+
+	// Manually assign extension even if one is already there
+	title = fmt.Sprintf("%s.%s", strings.Split(title, ".")[0], fileExt)
+
+	// Refuse titles the policy rejects before the file is written, the policy is applied again as the image commits
+	_, err = s.resolveTitle(ctx, int32(uid), title, 0)
+	if err == ErrDuplicateTitle {
+		return Image{}, &uploadError{http.StatusConflict, fmt.Sprintf("409 - An image titled %s already exists", title), err}
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to add image meta, try again later", fmt.Errorf("failed to resolve title: %v", err)}
+	}
+
+	// Resolve the users mentioned in the description, recorded together with the image
+	mentions, err := resolveMentions(text.Description)
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to add image mentions, try again later", fmt.Errorf("failed to resolve image mentions: %v", err)}
+	}
+
+	// Prepare image meta for SQL storage
+	imageData := Image{
+		Uid:         int32(uid),
+		Title:       title,
+		Size:        int32(imgHeader.Size),
+		Ref:         "", // placeholder reference assigned with the id to ensure unique filename
+		Shareable:   shareable,
+		Description: text.Description,
+		AltText:     text.AltText,
+		Encoding:    fileType,
+		Hash:        hash,
+		Uploaded:    uploaded,
+		Taken:       taken,
+		Tags:        tags,
+		Mentions:    mentions,
+	}
+
+	// Record the storage key, files are stored under their content hash unless the upload links a file
+	// stored before content addressing
+	imageData.FileKey = linkKey
+	if len(imageData.FileKey) == 0 {
+		imageData.FileKey = blobKey(hash, fileType)
+	}
+
+	// Write the file under a staging key before recording the image, it is moved into place as the image commits.
+	// Uploads linking a file stored by another member have nothing to write
+	staged := ""
+	if len(linkKey) == 0 {
+		staged, err = stageUpload(ctx, img, imgHeader.Size, fileType)
+		if err != nil {
+			return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to save file, try again later", err}
+		}
+	}
+	defer discardStaged(ctx, staged)
+
+	// Get REF_URL
+	refUrl := os.Getenv("REF_URL")
+	if len(refUrl) == 0 {
+		refUrl = REF_URL
+	}
+
+	// Record the image with its tags and mentions in a single transaction, referencing the blob of the content
+	// last so the transaction only commits once the file is in place and rolls back entirely otherwise.
+	// The title policy is applied once the file is in place, duplicates are numbered or refused then
+	imageData, err = s.store.AddImageData(ctx, imageData, func(id int32) string {
+		// Generate file reference string with unique file name in the format of IMAGE_DIR/UID/ID.ext
+		return fmt.Sprintf("%s/%s/%v/%v.%v", refUrl, IMAGE_DIR, uid, id, fileExt)
+	}, imageContent{staged: staged, content: img, size: imgHeader.Size})
+	if err == ErrDuplicateTitle {
+		return Image{}, &uploadError{http.StatusConflict, fmt.Sprintf("409 - An image titled %s already exists", title), err}
+	}
+	if err == ErrQuotaExceeded {
+		return Image{}, &uploadError{http.StatusRequestEntityTooLarge, "413 - Upload exceeds your storage quota, delete images to free space", err}
+	}
+	if _, ok := err.(*uploadError); ok {
+		return Image{}, err
+	}
+	if err != nil {
+		return Image{}, &uploadError{http.StatusInternalServerError, "500 - Failed to add image meta, try again later", fmt.Errorf("failed to add image meta: %v", err)}
 	}
 
+	// Notifications don't undo a committed upload
+	err = s.notifyMentions(ctx, imageData, imageData.Mentions, nil)
+	if err != nil {
+		logger.Error("failed to notify users mentioned by image %v: %v", imageData.Id, err)
+	}
+
+	// Hand the stored image to the processing pipeline
+	enqueueImage(imageData)
+
+	emitAnalytics(ANALYTICS_UPLOAD, uid, imageData, map[string]string{"encoding": fileType, "bytes": strconv.FormatInt(imgHeader.Size, 10), "linked": strconv.FormatBool(len(linkKey) > 0)})
+	if imageData.Shareable {
+		emitAnalytics(ANALYTICS_SHARE, uid, imageData, map[string]string{"source": "upload"})
+	}
+
+	return imageData, nil
+}
+
+// delImage moves the image in the url to the trash given the requesting person has the authorization to do so
+func (s *Server) delImage(w http.ResponseWriter, req *http.Request) {
 	// Authenticate user
 	claims, err := authRequest(req)
 	if err != nil {
@@ -665,7 +1279,7 @@ func delImage(w http.ResponseWriter, req *http.Request) {
 
 	vars := mux.Vars(req)
 	// validate url parameters and retrieve imageMeta
-	imageMeta, err := validateVars(vars)
+	imageMeta, err := s.validateVars(req.Context(), vars)
 	if err != nil {
 		logger.Error("Failed to validate vars sending 400: %v", err)
 		if strings.Contains(err.Error(), "404 - Not found") {
@@ -695,40 +1309,53 @@ func delImage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Delete meta from database
-	err = DeleteImageData(imageMeta)
+	// Move the image to the trash, the reaper purges it once the retention period passes
+	_, err = s.trashImage(req.Context(), imageMeta)
 	if err != nil {
-		logger.Error("failed to delete image from database sending 500: %v", err)
+		logger.Error("failed to trash image sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - Unable to delete image from database, try again later"))
+		w.Write([]byte("500 - Unable to delete image, try again later"))
 		return
 	}
+	recordAudit(req, claims.Uid, AUDIT_DELETE, imageMeta.Id)
 
-	// Delete file from storage
-	fileRef := fmt.Sprintf("./%s/%s/%s", IMAGE_DIR, vars["uid"], vars["fileId"])
-	err = os.Remove(fileRef)
-	// Orphaned file is ok to leave as database entry is already deleted
-	// Automated data integrity checks or manual removal is recommended
-	// This will look like a successfull deletion from the users perspective
+	logger.Info("Moved image %v to the trash", imageMeta.Id)
+	return
+}
+
+// removeImageFiles deletes the files of an image whose metadata was deleted
+// the stored file is kept while other images reference it
+func removeImageFiles(ctx context.Context, imageMeta Image) {
+	// Release the blob or delete a file stored before content addressing once no linked image references it
+	var err error
+	if isBlobKey(imageKey(imageMeta)) {
+		err = releaseBlob(ctx, imageKey(imageMeta))
+	} else {
+		var refs int64
+		refs, err = FileReferences(imageKey(imageMeta))
+		if err == nil && refs == 0 {
+			err = storage.Delete(ctx, imageKey(imageMeta))
+		}
+	}
+	// Automated data integrity checks or manual removal is recommended for orphaned files
 	if err != nil {
 		logger.Error("failed to delete image data, clean orphaned files via automated data integrity check: %v", err)
 	} else {
 		logger.Info("Successfully deleted image: %v", imageMeta.Id)
 	}
 
-	return
+	// Remove derived files produced by the pipeline
+	storage.Delete(ctx, thumbKey(imageMeta))
+	storage.Delete(ctx, watermarkKey(imageMeta))
+	storage.Delete(ctx, exifKey(imageMeta))
+	for encoding := range reencodeCommands {
+		storage.Delete(ctx, formatKey(imageMeta, encoding))
+	}
 }
 
 // getImage accepts multipart form-data with image metadata and deletes the appropriate
 // image given the requesting person has the authorization to do so
 func imageMetaRequest(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
 	// Authenticate user
 	claims, err := authRequest(req)
 	if err != nil {
@@ -740,13 +1367,44 @@ func imageMetaRequest(w http.ResponseWriter, req *http.Request) {
 
 	params := req.URL.Query()
 
-	resp, err := ImageMetaQuery(claims.Uid, params)
+	// Consistency selects the database serving the query and is not a filter
+	strong, err := parseConsistency(params)
+	if err != nil {
+		logger.Error("invalid image meta query sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+	params.Del("consistency")
+
+	// Validate query parameters before querying
+	page, err := parseMetaPage(params)
+	if err == nil {
+		_, err = imageQueryCondition(claims.Uid, params)
+	}
+	if err != nil {
+		logger.Error("invalid image meta query sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	resp, err := ImageMetaQuery(req.Context(), claims.Uid, params, strong)
 	if err != nil {
 		logger.Error("failed to retrieve image metadata: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("500 - Failed to complete query, try again later"))
 		return
 	}
+	resp.Prev, resp.Next = page.links(req.URL.Path, req.URL.Query(), resp.TotalResults)
+
+	// Galleries open on the first page, hint its thumbnails
+	if page.Page == 0 {
+		hintPreloads(w, req, resp.ImageMeta)
+	}
+
+	// Only the names of the filters are recorded, their values may be personal
+	emitAnalytics(ANALYTICS_SEARCH, claims.Uid, Image{}, map[string]string{"filters": searchFilters(params), "results": strconv.Itoa(resp.TotalResults)})
 
 	// marshal data into json to prep the query response
 	js, err := json.Marshal(resp)
@@ -764,14 +1422,7 @@ func imageMetaRequest(w http.ResponseWriter, req *http.Request) {
 
 // getImage accepts multipart form-data with image metadata and deletes the appropriate
 // image given the requesting person has the authorization to do so
-func updateImage(w http.ResponseWriter, req *http.Request) {
-
-	// Manage Cors
-	setCors(&w)
-	if req.Method == "OPTIONS" {
-		return
-	}
-
+func (s *Server) updateImage(w http.ResponseWriter, req *http.Request) {
 	// Authenticate user
 	claims, err := authRequest(req)
 	if err != nil {
@@ -783,7 +1434,7 @@ func updateImage(w http.ResponseWriter, req *http.Request) {
 
 	vars := mux.Vars(req)
 	// validate url parameters and retrieve imageMeta
-	imageMeta, err := validateVars(vars)
+	imageMeta, err := s.validateVars(req.Context(), vars)
 	if err != nil {
 		if strings.Contains(err.Error(), "404 - Not found") {
 			logger.Error("image data does not exist sending 404: %v", err)
@@ -826,31 +1477,136 @@ func updateImage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// if request specified a new title that is at least one character update meta
-	if title, ok := newParams["title"]; ok && len(title) > 0 {
+	// if request specified a new title that is at least one character once validated update meta
+	title, err := validateTitle(newParams["title"])
+	if err != nil {
+		logger.Error("invalid title sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+	if len(title) > 0 {
 		fileExt := strings.Split(imageMeta.Encoding, "/")[1]
 
 		// Manually assign extension even if one is already there
-		imageMeta.Title = fmt.Sprintf("%s.%s", strings.Split(title, ".")[0], fileExt)
+		title = fmt.Sprintf("%s.%s", strings.Split(title, ".")[0], fileExt)
+
+		// Refuse titles the policy rejects before anything changes, the policy is applied again as the update is written
+		_, err = s.resolveTitle(req.Context(), imageMeta.Uid, title, imageMeta.Id)
+		if err == ErrDuplicateTitle {
+			logger.Error("duplicate title sending 409: %v", title)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprintf("409 - An image titled %s already exists", title)))
+			return
+		}
+		if err != nil {
+			logger.Error("failed to resolve title sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update database, try again later"))
+			return
+		}
+		imageMeta.Title = title
+	}
+
+	// if request specified a description or alternative text replace it, an empty value clears it
+	_, hasDescription := newParams["description"]
+	_, hasAltText := newParams["altText"]
+	text, err := validateImageText(imageText{Description: newParams["description"], AltText: newParams["altText"]})
+	if err != nil {
+		logger.Error("invalid image text sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+	if hasDescription {
+		imageMeta.Description = text.Description
+	}
+	if hasAltText {
+		imageMeta.AltText = text.AltText
 	}
 
 	// if request specified a new shareable value that is valid update meta
+	wasShareable := imageMeta.Shareable
 	if shareable, ok := newParams["shareable"]; ok {
 		if shareable == "true" {
+			_, _, err := resolveShareable(req.Context(), s.store, shareable)
+			if err != nil {
+				writeSharingError(w, err)
+				return
+			}
+			restricted, err := s.store.ModerationRestricted(req.Context(), imageMeta.Id)
+			if err != nil {
+				logger.Error("failed to retrieve moderation cases sending 500: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("500 - Failed to update image, try again later"))
+				return
+			}
+			if restricted {
+				logger.Error("image %v restricted by moderation sending 403", imageMeta.Id)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("403 - Image was unshared after reports, appeal the moderation case to share it again"))
+				return
+			}
 			imageMeta.Shareable = true
 		} else if shareable == "false" {
 			imageMeta.Shareable = false
 		}
 	}
 
-	err = UpdateImageData(imageMeta)
+	// if request specified tags replace the existing tags, an empty list clears them
+	if tagList, ok := newParams["tags"]; ok {
+		tags, err := parseTags(tagList)
+		if err != nil {
+			logger.Error("invalid tags sending 400: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+			return
+		}
+
+		err = s.store.SetImageTags(req.Context(), imageMeta.Id, tags)
+		if err != nil {
+			logger.Error("failed to update image tags sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update database, try again later"))
+			return
+		}
+		imageMeta.Tags = tags
+	}
+
+	imageMeta, err = s.store.UpdateImageData(req.Context(), imageMeta)
+	if err == ErrDuplicateTitle {
+		logger.Error("duplicate title sending 409: %v", title)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - An image titled %s already exists", title)))
+		return
+	}
 	if err != nil {
-		logger.Error("failed to update database with new meta sending 500: %v")
+		logger.Error("failed to update database with new meta sending 500: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("500 - Failed to update database, try again later"))
 		return
 	}
 
+	// Mentions are resolved again whenever the description is replaced
+	if hasDescription {
+		imageMeta.Mentions, err = s.updateMentions(req.Context(), imageMeta)
+		if err != nil {
+			logger.Error("failed to update image mentions sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update database, try again later"))
+			return
+		}
+	}
+
+	recordAudit(req, claims.Uid, AUDIT_UPDATE, imageMeta.Id)
+	if imageMeta.Shareable && !wasShareable {
+		recordAudit(req, claims.Uid, AUDIT_SHARE, imageMeta.Id)
+		emitAnalytics(ANALYTICS_SHARE, claims.Uid, imageMeta, map[string]string{"source": "update"})
+	}
+	if !imageMeta.Shareable && wasShareable {
+		recordAudit(req, claims.Uid, AUDIT_UNSHARE, imageMeta.Id)
+	}
+
 	// marshal data into json to prep the query response
 	js, err := json.Marshal(imageMeta)
 	if err != nil {
@@ -868,7 +1624,7 @@ func updateImage(w http.ResponseWriter, req *http.Request) {
 
 }
 
-func validateVars(vars map[string]string) (Image, error) {
+func (s *Server) validateVars(ctx context.Context, vars map[string]string) (Image, error) {
 
 	// Validate completeness of request
 	if len(vars["uid"]) == 0 || len(vars["fileId"]) == 0 {
@@ -882,7 +1638,7 @@ func validateVars(vars map[string]string) (Image, error) {
 	}
 
 	// Retreive image meta
-	imageMeta, err := GetImageMeta(int32(id))
+	imageMeta, err := s.store.GetImageMeta(ctx, int32(id))
 	if err != nil {
 		return Image{}, fmt.Errorf("unable to retreive image meta from database: %v", err)
 	}
@@ -890,8 +1646,24 @@ func validateVars(vars map[string]string) (Image, error) {
 	return imageMeta, nil
 }
 
-func setCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+// searchFilters returns the sorted, comma separated names of the query parameters filtering a search
+func searchFilters(params url.Values) string {
+	var names []string
+	for name := range params {
+		if !isPagingParam(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// containsString reports whether the string slice contains the provided value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -2,17 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -29,13 +33,76 @@ var testUser = User{
 	Lastname:  "Joukema",
 	Email:     "user@mail.com",
 }
-var userPass = "pass"
+var userPass = "Blue-Heron-Canvas-42"
+
+// TestMain loads the configuration of the test database and disables rate limits as every
+// test request comes from the same address, TestRateLimit configures its own limiters.
+// Unless DB_SCHEMA names a schema the tests run in a schema of their own that is dropped
+// once they finish, so concurrent runs never see each other's rows and nothing is left behind
+func TestMain(m *testing.M) {
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Run a disposable database container when the configured database can't be reached
+	stopDB := func() {}
+	if err = execTestDB(config.Database, "SELECT 1"); err != nil {
+		config.Database, stopDB, err = startTestPostgres(config.Database)
+		if err != nil {
+			fmt.Printf("tests requiring the database will fail: %v\n", err)
+		}
+	}
+
+	schema := ""
+	if len(config.Database.Schema) == 0 {
+		schema = fmt.Sprintf("picto_test_%v_%v", os.Getpid(), time.Now().Unix())
+		err = execTestDB(config.Database, "CREATE SCHEMA "+schema)
+		if err != nil {
+			fmt.Printf("tests requiring the database will fail: %v\n", err)
+			schema = ""
+		}
+		config.Database.Schema = schema
+	}
+	configureDB(config.Database)
+	if err := InitSQL(); err != nil {
+		fmt.Printf("tests requiring the database will fail: %v\n", err)
+	}
+
+	ipLimiter, userLimiter, authLimiter = nil, nil, nil
+	code := m.Run()
+
+	CloseDB()
+	if len(schema) > 0 {
+		config.Database.Schema = ""
+		err = execTestDB(config.Database, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		if err != nil {
+			fmt.Printf("failed to drop test schema %s: %v\n", schema, err)
+		}
+	}
+	stopDB()
+	os.Exit(code)
+}
+
+// execTestDB executes the statement on a connection of its own outside the schema of the tests
+func execTestDB(config DatabaseConfig, stmt string) error {
+	db, err := openPool(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(stmt)
+	return err
+}
 
 // TestRouting evaluates a number of endpoints without authentication and ensures the correct response headers
 // This is a catch all for routing detailed tests of endpoint edge cases are completed in
 // the appropriate test function.
 func TestRouting(t *testing.T) {
-	router := configureRoutes()
+	server := &Server{store: sqlStore{}}
+	router := server.routes()
 
 	// Setup testing parameters
 	routeTests := []RouteTest{
@@ -59,14 +126,34 @@ func TestRouting(t *testing.T) {
 			Func:     auth,
 			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
 			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/user",
+			Func:     getUser,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusUnauthorized, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/user/password",
+			Func:     changePassword,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusMethodNotAllowed, http.StatusOK, http.StatusMethodNotAllowed, http.StatusUnauthorized, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/admin/sharing-policy",
+			Func:     sharingPolicy,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusUnauthorized, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/user/quota",
+			Func:     userQuota,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
 		}, {
 			Route:    "/image",
-			Func:     addImage,
+			Func:     server.addImage,
 			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
 			Expected: []int{http.StatusMethodNotAllowed, http.StatusOK, http.StatusUnauthorized, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
 		}, {
 			Route:    "/image/1/1.png",
-			Func:     getImage,
+			Func:     server.getImage,
 			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
 			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusUnauthorized, http.StatusUnauthorized},
 		}, {
@@ -74,6 +161,21 @@ func TestRouting(t *testing.T) {
 			Func:     imageMetaRequest,
 			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
 			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/image/meta/export",
+			Func:     exportImageMeta,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/oauth/clients",
+			Func:     listOAuthClients,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusUnauthorized, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
+		}, {
+			Route:    "/oauth/grants",
+			Func:     listOAuthGrants,
+			Method:   []string{"GET", "OPTIONS", "POST", "PUT", "DELETE"},
+			Expected: []int{http.StatusUnauthorized, http.StatusOK, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed},
 		},
 	}
 
@@ -135,6 +237,7 @@ func TestPingHandler(t *testing.T) {
 // TestRegister sends valid and invalid multipart form-data to the /register endpoint
 // This test evaluates the response status and response body
 func TestRegister(t *testing.T) {
+	t.Parallel()
 
 	// Configure http message
 	router := configureRoutes()
@@ -191,7 +294,8 @@ func TestRegister(t *testing.T) {
 	}
 
 	// Complete request body and retry
-	err = writer.WriteField("email", testUser.Email)
+	email := testEmail(t, "registered")
+	err = writer.WriteField("email", email)
 	if err != nil {
 		t.Errorf("failed to create form field: %v", err)
 	}
@@ -214,6 +318,9 @@ func TestRegister(t *testing.T) {
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusOK)
 	}
+	if user, err := GetUserData(email); err == nil {
+		cleanupTestUser(t, user.Uid)
+	}
 
 	// Submit additional request with same user
 	rr = httptest.NewRecorder()
@@ -222,19 +329,14 @@ func TestRegister(t *testing.T) {
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusBadRequest)
 	}
-
-	err = deleteTestUser()
-
 }
 
 // TestAuth tests the /auth endpoint for a valid and an invalid credential
 func TestAuth(t *testing.T) {
+	t.Parallel()
 
 	// Create testUser
-	_, err := createTestUser()
-	if err != nil {
-		t.Errorf("failed to create test user: %v", err)
-	}
+	user := createTestUser(t, "user")
 
 	// Configure http message
 	router := configureRoutes()
@@ -248,7 +350,7 @@ func TestAuth(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Set valid auth header
-	auth := fmt.Sprintf("%s:%s", testUser.Email, userPass)
+	auth := fmt.Sprintf("%s:%s", user.Email, userPass)
 	auth = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(auth)))
 	req.Header.Add("Authorization", auth)
 
@@ -272,7 +374,7 @@ func TestAuth(t *testing.T) {
 	}
 
 	// Set invalid auth header
-	auth = fmt.Sprintf("%s:%s", testUser.Email, "badpass")
+	auth = fmt.Sprintf("%s:%s", user.Email, "badpass")
 	auth = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(auth)))
 	req.Header.Add("Authorization", auth)
 
@@ -282,21 +384,20 @@ func TestAuth(t *testing.T) {
 	if status := rr.Code; status != http.StatusUnauthorized {
 		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusUnauthorized)
 	}
-
-	// Cleanup database
-	err = deleteTestUser()
 }
 
 // TestUImage attempts to complete the full life cycle of images
 // Upon successfull create attempt to retrieve file with GET
 // Upon successfull get attempt to update the meta via PUT
-// Upon successfull update attempt query all images associated with GET
-// Upon successfull query attempt to delete image via DELETE
-// This test requires an image name test.png in the ./test/test.png directory
+// Upon successfull update attempt to delete image via DELETE
+// The database is replaced by the memory store, see TestImageMetaQuery for the query of images
 func TestImageLifecycle(t *testing.T) {
-	token, _, err := getTestToken()
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &localStorage{root: t.TempDir()}
+
+	token, _, err := generateJWT(1, testUser.Email)
 	if err != nil {
-		t.Errorf("failed to generate test user jwt token: %v", err)
+		t.Fatalf("failed to generate jwt: %v", err)
 	}
 
 	///////////////////// UPLOAD IMAGE /////////////////
@@ -314,12 +415,11 @@ func TestImageLifecycle(t *testing.T) {
 		t.Errorf("failed to create form field: %v", err)
 	}
 
-	file, err := os.Open("./test/test.png")
+	part, err := writer.CreateFormFile("image", "test.png")
 	if err != nil {
-		t.Errorf("failed to open ./test/test.png: %v", err)
+		t.Errorf("failed to create form file: %v", err)
 	}
-	part, _ := writer.CreateFormFile("image", "./test/test.png")
-	io.Copy(part, file)
+	part.Write(testImage(t, "png", 16))
 	writer.Close()
 
 	req, err := http.NewRequest("POST", "/image", bytes.NewReader(form.Bytes()))
@@ -330,7 +430,8 @@ func TestImageLifecycle(t *testing.T) {
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	// Configure http message
-	router := configureRoutes()
+	store := newMemoryStore()
+	router := (&Server{store: store}).routes()
 
 	// Request recorder init
 	rr := httptest.NewRecorder()
@@ -411,11 +512,11 @@ func TestImageLifecycle(t *testing.T) {
 		t.Errorf("wrong updated image meta: got %v want %v", newImageMeta, imageMeta)
 	}
 
-	///////////////////// QUERY IMAGES /////////////////
+	///////////////////// DELETE IMAGE /////////////////
 
-	req, err = http.NewRequest("GET", "/image/meta", nil)
+	req, err = http.NewRequest("DELETE", strings.TrimPrefix(imageMeta.Ref, REF_URL), nil)
 	if err != nil {
-		t.Errorf("failed to prepare get /image requests: %v", err)
+		t.Errorf("failed to prepare delete /image requests: %v", err)
 	}
 	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
@@ -430,67 +531,364 @@ func TestImageLifecycle(t *testing.T) {
 		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusOK)
 	}
 
+	// The image is trashed rather than deleted
+	if _, err := store.GetImageMeta(context.Background(), imageMeta.Id); err == nil {
+		t.Errorf("expected image %v to be in the trash", imageMeta.Id)
+	}
+}
+
+// TestImageMetaQuery uploads an image and ensures it is listed with its meta when querying all images with GET /image/meta
+func TestImageMetaQuery(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	imageMeta := uploadTestImage(t, router, token, true)
+
+	req := httptest.NewRequest("GET", "/image/meta", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Compare status codes expect OK request
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong code: got %v want %v", status, http.StatusOK)
+	}
+
 	// Read body to attempt to get image
 	queryResp := QueryResp{}
-	err = json.Unmarshal(rr.Body.Bytes(), &queryResp)
-	if err != nil {
-		t.Errorf("failed to unmarshal response: %v", err)
+	err := json.Unmarshal(rr.Body.Bytes(), &queryResp)
+	if err != nil || queryResp.TotalResults == 0 {
+		t.Fatalf("failed to unmarshal response: %v", err)
 	}
 
 	if !reflect.DeepEqual(imageMeta, queryResp.ImageMeta[queryResp.TotalResults-1]) {
-		t.Errorf("wrong updated image meta: got %v want %v", newImageMeta, imageMeta)
+		t.Errorf("wrong image meta: got %v want %v", queryResp.ImageMeta[queryResp.TotalResults-1], imageMeta)
 	}
+}
 
-	///////////////////// DELETE IMAGE /////////////////
+// TestPublicImage uploads a private and a shareable image and ensures only the
+// shareable image is served by the unauthenticated /public/image endpoint
+func TestPublicImage(t *testing.T) {
+	t.Parallel()
 
-	req, err = http.NewRequest("DELETE", strings.TrimPrefix(imageMeta.Ref, REF_URL), nil)
-	if err != nil {
-		t.Errorf("failed to prepare delete /image requests: %v", err)
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+
+	private := uploadTestImage(t, router, token, false)
+	shared := uploadTestImage(t, router, token, true)
+
+	if len(private.PublicRef()) != 0 || len(shared.PublicRef()) == 0 {
+		t.Errorf("wrong public references: got %q and %q", private.PublicRef(), shared.PublicRef())
 	}
-	req.Header.Add("Content-Type", writer.FormDataContentType())
+
+	publicTests := []struct {
+		Route        string
+		Expected     int
+		CacheControl string
+	}{
+		{strings.Replace(strings.TrimPrefix(private.Ref, REF_URL), "/image/", "/public/image/", 1), http.StatusNotFound, ""},
+		{strings.Replace(strings.TrimPrefix(shared.Ref, REF_URL), "/image/", "/public/image/", 1), http.StatusOK, CACHE_REVALIDATE},
+		{strings.TrimPrefix(shared.PublicRef(), REF_URL), http.StatusOK, fmt.Sprintf(CACHE_IMMUTABLE, PUBLIC_MAX_AGE)},
+		{fmt.Sprintf("/public/image/%v/%v.png", shared.Uid+1, shared.Id), http.StatusNotFound, ""},
+		{fmt.Sprintf("/public/image/%v/%v.png", shared.Uid, shared.Id+1000000), http.StatusNotFound, ""},
+	}
+
+	for _, publicTest := range publicTests {
+		req, err := http.NewRequest("GET", publicTest.Route, nil)
+		if err != nil {
+			t.Fatalf("failed to prepare get %s request: %v", publicTest.Route, err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != publicTest.Expected {
+			t.Errorf("handler returned wrong code for %s: got %v want %v", publicTest.Route, status, publicTest.Expected)
+		}
+		if cacheControl := rr.Header().Get("Cache-Control"); cacheControl != publicTest.CacheControl {
+			t.Errorf("wrong Cache-Control for %s: got %q want %q", publicTest.Route, cacheControl, publicTest.CacheControl)
+		}
+	}
+
+	// Clean uploaded images
+	for _, image := range []Image{private, shared} {
+		req, _ := http.NewRequest("DELETE", strings.TrimPrefix(image.Ref, REF_URL), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+// TestQueryInjection ensures malicious query values and credentials can't widen the results
+// of image meta queries or bypass authentication
+func TestQueryInjection(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+
+	for _, input := range maliciousInputs {
+		req, err := http.NewRequest("GET", "/image/meta?"+url.Values{"title": {input}, "encoding": {input}}.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong code for %q: got %v want %v", input, status, http.StatusOK)
+			continue
+		}
+
+		resp := QueryResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.TotalResults != 0 || len(resp.ImageMeta) != 0 {
+			t.Errorf("query with %q matched %v images", input, resp.TotalResults)
+		}
+
+		// Malicious emails must not authenticate
+		req, _ = http.NewRequest("GET", "/auth", nil)
+		auth := fmt.Sprintf("%s:%s", input, userPass)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(auth))))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("auth with email %q returned wrong code: got %v want %v", input, status, http.StatusUnauthorized)
+		}
+	}
+
+	// Tables survive the attempted drops
+	if _, err := GetImageMeta(context.Background(), image.Id); err != nil {
+		t.Errorf("failed to retrieve image after injection attempts: %v", err)
+	}
+
+	req, _ := http.NewRequest("DELETE", strings.TrimPrefix(image.Ref, REF_URL), nil)
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
 
-	// Request recorder init
+// TestWriteImageFile ensures stored images are served with range and conditional request support
+// Files are served from a temporary directory without the database
+func TestWriteImageFile(t *testing.T) {
+	dir := t.TempDir()
+
+	previous := storage
+	storage = &localStorage{root: dir}
+	defer func() { storage = previous }()
+
+	content := testImage(t, "png", 16)
+	hash, _ := hashFile(bytes.NewReader(content))
+	image := Image{Id: 1, Uid: 1, Title: "test.png", Encoding: "image/png", Hash: hash}
+	err := storage.Put(context.Background(), imageKey(image), bytes.NewReader(content), int64(len(content)), image.Encoding)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	serve := func(header string, value string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/image/1/1.png", nil)
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		writeImageFile(rr, req, image, imageKey(image))
+		return rr
+	}
+
+	rr := serve("", "")
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Fatalf("wrong full response: got %v with %v bytes", rr.Code, rr.Body.Len())
+	}
+	etag := rr.Header().Get("ETag")
+	if etag != `"`+hash+`"` || len(rr.Header().Get("Last-Modified")) == 0 || rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("missing caching headers: got %v", rr.Header())
+	}
+	if rr.Header().Get("Content-Length") != fmt.Sprintf("%v", len(content)) || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("wrong content headers: got %v", rr.Header())
+	}
+
+	rr = serve("Range", "bytes=4-9")
+	if rr.Code != http.StatusPartialContent || !bytes.Equal(rr.Body.Bytes(), content[4:10]) {
+		t.Errorf("wrong range response: got %v %q", rr.Code, rr.Body.Bytes())
+	}
+	if rr.Header().Get("Content-Range") != fmt.Sprintf("bytes 4-9/%v", len(content)) {
+		t.Errorf("wrong content range: got %s", rr.Header().Get("Content-Range"))
+	}
+
+	rr = serve("If-None-Match", etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("wrong conditional response: got %v with %v bytes", rr.Code, rr.Body.Len())
+	}
+
+	// Downloads carry the digest of the stored file, HEAD requests include it without the body
+	sum := sha256.Sum256(content)
+	digest := fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(sum[:]))
+	if rr = serve("", ""); rr.Header().Get("Repr-Digest") != digest || len(rr.Header().Get("Digest")) == 0 {
+		t.Errorf("wrong digest: got %q want %q", rr.Header().Get("Repr-Digest"), digest)
+	}
+	req, _ := http.NewRequest("HEAD", "/image/1/1.png", nil)
+	rr = httptest.NewRecorder()
+	writeImageFile(rr, req, image, imageKey(image))
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 || rr.Header().Get("Repr-Digest") != digest {
+		t.Errorf("wrong head response: got %v with %v bytes and digest %q", rr.Code, rr.Body.Len(), rr.Header().Get("Repr-Digest"))
+	}
+	if rr.Header().Get("Content-Type") != image.Encoding || rr.Header().Get("Content-Length") != fmt.Sprint(len(content)) || rr.Header().Get("ETag") != etag || len(rr.Header().Get("Last-Modified")) == 0 {
+		t.Errorf("wrong head headers: got %v", rr.Header())
+	}
+	storage.Put(context.Background(), thumbKey(image), bytes.NewReader(content), int64(len(content)), image.Encoding)
+	rr = httptest.NewRecorder()
+	writeImageFile(rr, req, image, thumbKey(image))
+	if len(rr.Header().Get("Repr-Digest")) > 0 {
+		t.Errorf("expected no digest for thumbnail: got %q", rr.Header().Get("Repr-Digest"))
+	}
+	// The content hash only tags the stored image
+	if thumbTag := rr.Header().Get("ETag"); len(thumbTag) == 0 || thumbTag == etag {
+		t.Errorf("wrong thumbnail entity tag: got %q", thumbTag)
+	}
+
+	// Lost files are gone while missing derived files such as thumbnails are not found
+	storage.Delete(context.Background(), thumbKey(image))
 	rr = httptest.NewRecorder()
+	writeImageFile(rr, httptest.NewRequest("GET", "/image/1/1.png", nil), image, thumbKey(image))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for missing thumbnail: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	image.Id = 2
+	if rr = serve("", ""); rr.Code != http.StatusGone {
+		t.Errorf("wrong code for missing file: got %v want %v", rr.Code, http.StatusGone)
+	}
+}
+
+// TestWriteImageMeta ensures metadata is sent as json and HEAD requests receive its headers only
+func TestWriteImageMeta(t *testing.T) {
+	image := Image{Id: 3, Uid: 1, Title: "beach.png", Encoding: "image/png", Description: "Low tide"}
+	for _, method := range []string{"GET", "HEAD"} {
+		req := httptest.NewRequest(method, "/image/1/3.png?meta=true", nil)
+		if !metaRequested(req) {
+			t.Errorf("expected metadata to be requested")
+		}
+		rr := httptest.NewRecorder()
+		writeImageMeta(rr, req, image)
+
+		js, _ := json.Marshal(image)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" || rr.Header().Get("Content-Length") != fmt.Sprint(len(js)) {
+			t.Errorf("wrong %s response: got %v %v", method, rr.Code, rr.Header())
+		}
+		if method == "GET" && !bytes.Equal(rr.Body.Bytes(), js) || method == "HEAD" && rr.Body.Len() > 0 {
+			t.Errorf("wrong %s body: got %s", method, rr.Body.String())
+		}
+	}
+
+	for _, query := range []string{"", "?meta=false", "?meta=yes"} {
+		if metaRequested(httptest.NewRequest("GET", "/image/1/3.png"+query, nil)) {
+			t.Errorf("expected file to be requested by %q", query)
+		}
+	}
+}
+
+// TestImageHeadAndMeta ensures HEAD requests describe the image file without sending it and
+// meta=true returns the metadata of the image in place of the file
+func TestImageHeadAndMeta(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	storage = &localStorage{root: t.TempDir()}
+
+	token, _, err := generateJWT(1, testUser.Email)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	router := (&Server{store: newMemoryStore()}).routes()
+	image := uploadTestImage(t, router, token, false)
+	path := strings.TrimPrefix(image.Ref, REF_URL)
 
+	req := httptest.NewRequest("HEAD", path, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 || rr.Header().Get("Content-Type") != image.Encoding || rr.Header().Get("Content-Length") != fmt.Sprint(image.Size) || len(rr.Header().Get("ETag")) == 0 || len(rr.Header().Get("Last-Modified")) == 0 {
+		t.Errorf("wrong head response: got %v with %v bytes %v", rr.Code, rr.Body.Len(), rr.Header())
+	}
 
-	// Compare status codes expect OK request
+	req = httptest.NewRequest("GET", path+"?meta=true", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	meta := Image{}
+	json.Unmarshal(rr.Body.Bytes(), &meta)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" || meta.Id != image.Id || meta.Title != image.Title {
+		t.Errorf("wrong meta response: got %v %+v", rr.Code, meta)
+	}
+}
+
+// uploadTestImage uploads a generated png image as the owner of the token and returns its meta
+func uploadTestImage(t *testing.T, router http.Handler, token string, shareable bool) Image {
+	req := testUploadRequest(t, token, shareable)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong code: got %v want %v", status, http.StatusOK)
+		t.Fatalf("failed to upload test image: got %v want %v", status, http.StatusOK)
 	}
 
-	// clean image meta from database if required
-	err = deleteTestUser()
+	image := Image{}
+	err := json.Unmarshal(rr.Body.Bytes(), &image)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return image
 }
 
-// getTestToken generates a token after creating a test user
-// must call delete test user at the end of the request
-func getTestToken() (string, int, error) {
-	uid, err := createTestUser()
+// testUploadRequest prepares an upload of a generated png image as the owner of the token
+func testUploadRequest(t *testing.T, token string, shareable bool) *http.Request {
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	writer.WriteField("shareable", fmt.Sprintf("%v", shareable))
+	part, err := writer.CreateFormFile("image", "test.png")
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to create test user: %v", err)
+		t.Fatalf("failed to create form file: %v", err)
 	}
-	token, _, err := generateJWT(uid, testUser.Email)
-	return token, uid, err
-}
+	part.Write(testImage(t, "png", 16))
+	writer.Close()
 
-// createTestUser is a helper function that populates the database with the default test user defined above
-func createTestUser() (int, error) {
+	req, err := http.NewRequest("POST", "/image", form)
+	if err != nil {
+		t.Fatalf("failed to generate request with form data: %v", err)
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	return req
+}
 
-	uid, err := AddUserData(testUser)
+// getTestToken creates the user of the test and returns a token authenticating it with its uid
+func getTestToken(t *testing.T) (string, int) {
+	user := createTestUser(t, "user")
+	token, _, err := generateJWT(int(user.Uid), user.Email)
 	if err != nil {
-		return 0, fmt.Errorf("unable to add test user: %v", err)
+		t.Fatalf("failed to generate test user jwt token: %v", err)
 	}
+	return token, int(user.Uid)
+}
 
+// createTestUser adds a user with the password userPass whose email is unique to the test and role
+// so parallel tests never share users. The user is purged when the test ends, see cleanupTestUser
+func createTestUser(t *testing.T, role string) User {
 	user := testUser
+	user.Email = testEmail(t, role)
+
+	uid, err := AddUserData(user)
+	if err != nil {
+		t.Fatalf("unable to add test user: %v", err)
+	}
 	user.Uid = uid
+	cleanupTestUser(t, uid)
 
 	// Attempt to hash password for storage
 	hashedPass, err := bcrypt.GenerateFromPassword([]byte(userPass), bcrypt.DefaultCost)
 	if err != nil {
-		DeleteUserData(user)
-		return 0, fmt.Errorf("Failed to hash password cleaning user and sending 500: %v", err)
+		t.Fatalf("failed to hash password: %v", err)
 	}
 
 	pass := UserPassword{
@@ -500,34 +898,41 @@ func createTestUser() (int, error) {
 
 	_, err = AddUserPass(pass)
 	if err != nil {
-		return 0, fmt.Errorf("unable to add test user: %v", err)
+		t.Fatalf("unable to add test user password: %v", err)
 	}
 
-	return int(uid), nil
+	return user
 }
 
-func deleteTestUser() error {
-	// Clean database
-	user, err := GetUserData(testUser.Email)
-	if err != nil {
-		return fmt.Errorf("failed to fetch created user data: %v", err)
-	}
-	err = DeleteUserData(user)
-	if err != nil {
-		return fmt.Errorf("failed to delete created user data: %v", err)
-	}
-	return nil
+// testEmail returns the email of the test's user with the role, the name of the test keeps it unique
+func testEmail(t *testing.T, role string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, t.Name())
+	return fmt.Sprintf("%s.%s@mail.com", name, role)
+}
+
+// cleanupTestUser purges the user with everything it owns once the test and its subtests end, even
+// when the test fails part way, so failing tests never leave rows or files behind
+func cleanupTestUser(t *testing.T, uid int32) {
+	t.Cleanup(func() {
+		err := runPurge(context.Background(), &PurgeJob{Uid: uid, Mode: PURGE_DELETE})
+		if err != nil {
+			t.Errorf("failed to clean up test user %v: %v", uid, err)
+		}
+	})
 }
 
 /*
 func testBody (t *testing.T) {
 	func TestAuth(t *testing.T) {
+	t.Parallel()
 
-	// Create testUser
-	_, err := createTestUser()
-	if err != nil {
-		t.Errorf("failed to create test user: %v", err)
-	}
+	// Create the test's user, it is purged when the test ends
+	token, uid := getTestToken(t)
 
 	// Configure http message
 	router := configureRoutes()
@@ -540,12 +945,6 @@ func testBody (t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Cleanup database
-	err = deleteTestUser()
-	if err != nil {
-		t.Errorf("failed to delete test user: %v", err)
-	}
 }
 }
 */
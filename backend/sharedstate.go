@@ -0,0 +1,220 @@
+package main
+
+/*
+	This file holds the short lived state replicas must agree on: rate limit buckets, auth tokens
+	revoked by signing out and nonces that may be used once, such as totp sign in challenges. The
+	memory driver keeps the state in each instance, which suits single instance deployments. The
+	redis driver keeps it in the Redis server named by REDIS_URL so every replica enforces the same
+	limits and refuses the same tokens and nonces. Requests are never refused because Redis is
+	unreachable, the state of the instance is used until it is reachable again.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	STATE_MEMORY = "memory"
+	STATE_REDIS  = "redis"
+
+	STATE_PRUNE_INTERVAL = time.Minute // Interval between removing expired entries from memory
+)
+
+// StateConfig names the driver holding the state shared by replicas
+type StateConfig struct {
+	Driver   string `yaml:"driver"`
+	RedisURL string `yaml:"redisUrl"` // redis:// or rediss:// url of the server, required by the redis driver
+}
+
+// validate returns a problem for each invalid setting
+func (c StateConfig) validate() []string {
+	var problems []string
+	switch c.Driver {
+	case STATE_MEMORY:
+	case STATE_REDIS:
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || len(u.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("state.redisUrl (REDIS_URL) must be a redis or rediss url for the redis driver, got %q", c.RedisURL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("state.driver (STATE_DRIVER) must be memory or redis, got %q", c.Driver))
+	}
+	return problems
+}
+
+// StateStore holds the state shared by replicas, keys of each kind of state are prefixed with its name
+type StateStore interface {
+	// TakeToken takes a token from the bucket of the key holding up to burst tokens refilled at rate per second,
+	// when the bucket is empty it returns false and the time until a token is available
+	TakeToken(key string, rate float64, burst float64) (bool, time.Duration, error)
+	// Revoke records the key as revoked until the time
+	Revoke(key string, until time.Time) error
+	// Revoked reports whether the key is revoked
+	Revoked(key string) (bool, error)
+	// ClaimNonce records the nonce as used until the time, reporting false if it was used before
+	ClaimNonce(key string, until time.Time) (bool, error)
+}
+
+// localState holds the state of this instance, used by the memory driver and whenever the shared store fails
+var localState = newMemoryState()
+
+// sharedState is the store configured at startup
+var sharedState StateStore = localState
+
+// initState selects the store of the configured driver, the redis driver must reach its server
+func initState(config StateConfig) error {
+	if config.Driver != STATE_REDIS {
+		sharedState = localState
+		return nil
+	}
+
+	client, err := newRedisClient(config.RedisURL)
+	if err != nil {
+		return err
+	}
+	err = client.Ping()
+	if err != nil {
+		return fmt.Errorf("unable to reach redis: %v", err)
+	}
+	sharedState = &redisState{client: client}
+	return nil
+}
+
+// takeToken takes a token from the bucket of the key in the shared store
+func takeToken(key string, rate float64, burst float64) (bool, time.Duration) {
+	allowed, wait, err := sharedState.TakeToken(key, rate, burst)
+	if err != nil {
+		logger.Error("failed to take token of %s from the shared store, limiting this instance only: %v", key, err)
+		allowed, wait, _ = localState.TakeToken(key, rate, burst)
+	}
+	return allowed, wait
+}
+
+// revokeToken refuses the auth token with the id until it expires
+func revokeToken(id string, expires time.Time) {
+	key := "token:" + id
+	err := sharedState.Revoke(key, expires)
+	if err != nil {
+		logger.Error("failed to revoke token in the shared store, revoking it on this instance only: %v", err)
+		localState.Revoke(key, expires)
+	}
+}
+
+// tokenRevoked reports whether the auth token with the id was revoked
+func tokenRevoked(id string) bool {
+	key := "token:" + id
+	revoked, err := sharedState.Revoked(key)
+	if err != nil {
+		logger.Error("failed to check token revocation in the shared store, checking this instance only: %v", err)
+		revoked, _ = localState.Revoked(key)
+	}
+	return revoked
+}
+
+// claimNonce records the nonce of the kind as used until the time, reporting false if it was used before
+func claimNonce(kind string, nonce string, until time.Time) bool {
+	key := "nonce:" + kind + ":" + nonce
+	claimed, err := sharedState.ClaimNonce(key, until)
+	if err != nil {
+		logger.Error("failed to claim nonce in the shared store, claiming it on this instance only: %v", err)
+		claimed, _ = localState.ClaimNonce(key, until)
+	}
+	return claimed
+}
+
+// newTokenId returns a random id identifying a token for revocation or single use
+func newTokenId() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// memoryState holds the state in the memory of this instance
+type memoryState struct {
+	mu      sync.Mutex
+	buckets map[string]*stateBucket
+	expiry  map[string]time.Time // Revoked keys and claimed nonces until they expire
+	pruned  time.Time
+}
+
+type stateBucket struct {
+	tokenBucket
+	rate  float64
+	burst float64
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{buckets: map[string]*stateBucket{}, expiry: map[string]time.Time{}, pruned: time.Now()}
+}
+
+func (s *memoryState) TakeToken(key string, rate float64, burst float64) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &stateBucket{tokenBucket: tokenBucket{tokens: burst, updated: now}}
+		s.buckets[key] = bucket
+	}
+	bucket.rate, bucket.burst = rate, burst
+	allowed, wait := bucket.take(now, rate, burst)
+	return allowed, wait, nil
+}
+
+func (s *memoryState) Revoke(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	s.expiry[key] = until
+	return nil
+}
+
+func (s *memoryState) Revoked(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.expiry[key]
+	return ok && time.Now().Before(until), nil
+}
+
+func (s *memoryState) ClaimNonce(key string, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+	if used, ok := s.expiry[key]; ok && now.Before(used) {
+		return false, nil
+	}
+	s.expiry[key] = until
+	return true, nil
+}
+
+// prune removes refilled buckets and expired entries every STATE_PRUNE_INTERVAL
+func (s *memoryState) prune(now time.Time) {
+	if now.Sub(s.pruned) < STATE_PRUNE_INTERVAL {
+		return
+	}
+	s.pruned = now
+
+	for key, bucket := range s.buckets {
+		if bucket.full(now, bucket.rate, bucket.burst) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, until := range s.expiry {
+		if !now.Before(until) {
+			delete(s.expiry, key)
+		}
+	}
+}
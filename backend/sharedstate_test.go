@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingState is a shared store that can't be reached
+type failingState struct{}
+
+func (failingState) TakeToken(key string, rate float64, burst float64) (bool, time.Duration, error) {
+	return false, 0, errors.New("unreachable")
+}
+func (failingState) Revoke(key string, until time.Time) error { return errors.New("unreachable") }
+func (failingState) Revoked(key string) (bool, error)         { return false, errors.New("unreachable") }
+func (failingState) ClaimNonce(key string, until time.Time) (bool, error) {
+	return false, errors.New("unreachable")
+}
+
+// TestMemoryState ensures buckets, revocations and nonces are kept per key until they expire
+func TestMemoryState(t *testing.T) {
+	state := newMemoryState()
+
+	if ok, _, _ := state.TakeToken("a", 1, 1); !ok {
+		t.Errorf("expected first token to be taken")
+	}
+	if ok, wait, _ := state.TakeToken("a", 1, 1); ok || wait <= 0 || wait > time.Second {
+		t.Errorf("expected empty bucket to wait up to a second: got %v, %v", ok, wait)
+	}
+	if ok, _, _ := state.TakeToken("b", 1, 1); !ok {
+		t.Errorf("expected other keys to have their own bucket")
+	}
+
+	state.Revoke("token:live", time.Now().Add(time.Minute))
+	state.Revoke("token:expired", time.Now().Add(-time.Second))
+	if revoked, _ := state.Revoked("token:live"); !revoked {
+		t.Errorf("expected token to be revoked")
+	}
+	if revoked, _ := state.Revoked("token:expired"); revoked {
+		t.Errorf("expected revocation to expire")
+	}
+
+	if claimed, _ := state.ClaimNonce("nonce:a", time.Now().Add(time.Minute)); !claimed {
+		t.Errorf("expected unused nonce to be claimed")
+	}
+	if claimed, _ := state.ClaimNonce("nonce:a", time.Now().Add(time.Minute)); claimed {
+		t.Errorf("expected used nonce to be refused")
+	}
+
+	// Refilled buckets and expired entries are pruned
+	state.pruned = time.Now().Add(-2 * STATE_PRUNE_INTERVAL)
+	state.buckets["b"].updated = time.Now().Add(-time.Minute)
+	state.ClaimNonce("nonce:b", time.Now().Add(time.Minute))
+	if _, ok := state.buckets["b"]; ok {
+		t.Errorf("expected refilled bucket to be pruned")
+	}
+	if _, ok := state.expiry["token:expired"]; ok {
+		t.Errorf("expected expired revocation to be pruned")
+	}
+}
+
+// TestSharedStateFallback ensures the state of the instance is used while the shared store fails
+func TestSharedStateFallback(t *testing.T) {
+	defer func(configured StateStore) { sharedState = configured }(sharedState)
+	sharedState = failingState{}
+
+	limiter := newSharedRateLimiter("fallback-test", 1, 1)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Errorf("expected token to be taken from the instance")
+	}
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Errorf("expected instance bucket to be empty")
+	}
+
+	revokeToken("fallback-test", time.Now().Add(time.Minute))
+	if !tokenRevoked("fallback-test") {
+		t.Errorf("expected token to be revoked on the instance")
+	}
+	if !claimNonce("test", "fallback-test", time.Now().Add(time.Minute)) || claimNonce("test", "fallback-test", time.Now().Add(time.Minute)) {
+		t.Errorf("expected nonce to be claimed once on the instance")
+	}
+}
+
+// TestSignOut ensures signing out revokes the auth token and clears the cookie
+func TestSignOut(t *testing.T) {
+	token, _, err := generateJWT(42, "signout@mail.com")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	if _, err := tokenPrincipal(token); err != nil {
+		t.Fatalf("expected token to be accepted before signing out: %v", err)
+	}
+
+	router := configureRoutes()
+	req := httptest.NewRequest("POST", "/auth/signout", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != TOKEN_COOKIE || len(cookies[0].Value) > 0 || cookies[0].Expires.After(time.Now()) {
+		t.Errorf("expected token cookie to be cleared: got %v", cookies)
+	}
+
+	if _, err := tokenPrincipal(token); err == nil {
+		t.Errorf("expected revoked token to be refused")
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code signing out twice: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+/*
+	This file lets owners grant other accounts access to view individual private images.
+	Grants are made by the email of the account and listed and revoked by its uid. Granted users
+	view the image through the authenticated image endpoint and find it in image meta queries,
+	while updating and deleting it remain restricted to the owner. Grants are independent of the
+	shareable flag and the sharing policy, which only govern public access.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	IMAGE_SHARE_TABLE = "image_shares"
+
+	IMAGE_SHARE_MAX = 500 // Accounts an image may be shared with
+)
+
+// ErrTooManyShares is returned when sharing an image with more than IMAGE_SHARE_MAX accounts
+var ErrTooManyShares = errors.New("image is shared with too many accounts")
+
+// ImageShare grants the user view access to an image tagged for sql serialization
+type ImageShare struct {
+	Id      int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId int32     `sql:"image_id"`
+	Uid     int32     `sql:"uid"` // User granted access, the owner is recorded on the image
+	Created time.Time `sql:"created"`
+}
+
+// ImageShareReq names the account an image is shared with
+type ImageShareReq struct {
+	Email string `json:"email"`
+}
+
+// ImageShareResp describes a user an image is shared with
+type ImageShareResp struct {
+	Uid       int32     `json:"uid"`
+	Email     string    `json:"email"`
+	Firstname string    `json:"firstname"`
+	Lastname  string    `json:"lastname"`
+	Created   Timestamp `json:"created"`
+}
+
+// canViewImage reports whether the user may view the image through the authenticated image
+// endpoints, owners always may and other users require a grant and not to be blocked by the owner
+func (s *Server) canViewImage(ctx context.Context, uid int, image Image) (bool, error) {
+	if uid == int(image.Uid) {
+		return true, nil
+	}
+	allowed, err := s.canInteract(ctx, uid, image.Uid)
+	if err != nil || !allowed {
+		return false, err
+	}
+	return s.store.HasImageShare(ctx, image.Id, int32(uid))
+}
+
+// shareImage grants the account with the email in the body view access to an image of the authenticated user
+func (s *Server) shareImage(w http.ResponseWriter, req *http.Request) {
+	image, ok := s.ownedImage(w, req)
+	if !ok {
+		return
+	}
+
+	var shareReq ImageShareReq
+	err := json.NewDecoder(req.Body).Decode(&shareReq)
+	if err != nil || len(strings.TrimSpace(shareReq.Email)) == 0 {
+		logger.Error("invalid share request sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, provide the email of the account to share with"))
+		return
+	}
+
+	user, found, err := GetUserByEmail(strings.TrimSpace(shareReq.Email))
+	if err != nil {
+		logger.Error("failed to retrieve user sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to share image, try again later"))
+		return
+	}
+	if !found {
+		logger.Error("no account to share image %v with sending 404", image.Id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no account with that email"))
+		return
+	}
+	if user.Uid == image.Uid {
+		logger.Error("user %v sharing image %v with themselves sending 400", image.Uid, image.Id)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Images are always visible to their owner"))
+		return
+	}
+
+	share, created, err := AddImageShare(image.Id, user.Uid)
+	if err == ErrTooManyShares {
+		logger.Error("image %v shared with too many accounts sending 409", image.Id)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - Images may be shared with at most %v accounts", IMAGE_SHARE_MAX)))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to add image share sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to share image, try again later"))
+		return
+	}
+
+	// Repeated grants succeed without creating another grant
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		logger.Info("Shared image %v with user %v", image.Id, user.Uid)
+		recordAudit(req, int(image.Uid), AUDIT_SHARE, image.Id)
+	}
+	writeShareJSON(w, status, imageShareResp(share, user))
+}
+
+// listImageShares returns the accounts an image of the authenticated user is shared with
+func (s *Server) listImageShares(w http.ResponseWriter, req *http.Request) {
+	image, ok := s.ownedImage(w, req)
+	if !ok {
+		return
+	}
+
+	shares, users, err := ImageShares(image.Id)
+	if err != nil {
+		logger.Error("failed to retrieve image shares sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve shares, try again later"))
+		return
+	}
+
+	resp := []ImageShareResp{}
+	for _, share := range shares {
+		resp = append(resp, imageShareResp(share, users[share.Uid]))
+	}
+	writeShareJSON(w, http.StatusOK, resp)
+}
+
+// revokeImageShare removes the grant of the user in the url to an image of the authenticated user
+func (s *Server) revokeImageShare(w http.ResponseWriter, req *http.Request) {
+	image, ok := s.ownedImage(w, req)
+	if !ok {
+		return
+	}
+
+	uid, err := strconv.Atoi(mux.Vars(req)["uid"])
+	if err != nil {
+		logger.Error("invalid uid sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	removed, err := DeleteImageShare(image.Id, int32(uid))
+	if err != nil {
+		logger.Error("failed to delete image share sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to revoke share, try again later"))
+		return
+	}
+	if !removed {
+		logger.Error("image %v not shared with user %v sending 404", image.Id, uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, the image is not shared with that account"))
+		return
+	}
+
+	recordAudit(req, int(image.Uid), AUDIT_UNSHARE, image.Id)
+	// The revoked account is no longer among the shares notified by the audit log
+	publishLibraryEvent(LibraryEvent{Type: LIBRARY_UNSHARED, ImageId: image.Id, Owner: image.Uid, Time: Timestamp(time.Now().UTC())}, []int32{int32(uid)})
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Revoked share of image %v with user %v", image.Id, uid)
+}
+
+// ownedImage authenticates the user and retrieves the image in the url owned by them
+// writes the error response and returns false otherwise, other users' images are reported as not found
+func (s *Server) ownedImage(w http.ResponseWriter, req *http.Request) (Image, bool) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for image shares sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return Image{}, false
+	}
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		logger.Error("invalid image id sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return Image{}, false
+	}
+
+	image, err := s.store.GetImageMeta(req.Context(), int32(id))
+	if err != nil && !strings.Contains(err.Error(), "404 - Not found") {
+		logger.Error("failed to retrieve image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve image, try again later"))
+		return Image{}, false
+	}
+	if err != nil || image.Uid != int32(claims.Uid) {
+		logger.Error("image %v not owned by user %v sending 404", id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
+		return Image{}, false
+	}
+
+	return image, true
+}
+
+// imageShareResp describes the grant to the user
+func imageShareResp(share ImageShare, user User) ImageShareResp {
+	return ImageShareResp{
+		Uid:       share.Uid,
+		Email:     user.Email,
+		Firstname: user.Firstname,
+		Lastname:  user.Lastname,
+		Created:   Timestamp(share.Created),
+	}
+}
+
+// writeShareJSON writes the response as json with the status code
+func writeShareJSON(w http.ResponseWriter, status int, resp interface{}) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestImageShares ensures granted users can view and find a private image until the grant is revoked
+func TestImageShares(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "friend")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+	imagePath := strings.TrimPrefix(image.Ref, REF_URL)
+	sharesPath := fmt.Sprintf("/image/%v/shares", image.Id)
+
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	sharedImages := func() int {
+		rr := send("GET", "/image/meta?sharedWithMe=true", "", friendToken)
+		resp := QueryResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.TotalResults
+	}
+
+	if rr := send("GET", imagePath, "", friendToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for private image: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("POST", sharesPath, `{"email": "friend@mail.com"}`, friendToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for sharing another user's image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", sharesPath, `{"email": "nobody@mail.com"}`, token); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for unknown email: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", sharesPath, fmt.Sprintf(`{"email": %q}`, testEmail(t, "user")), token); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for sharing with the owner: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := send("POST", sharesPath, `{"email": "friend@mail.com"}`, token); rr.Code != http.StatusCreated {
+		t.Fatalf("failed to share image: got %v", rr.Code)
+	}
+	if rr := send("POST", sharesPath, `{"email": "friend@mail.com"}`, token); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for repeated share: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := send("GET", sharesPath, "", token)
+	shares := []ImageShareResp{}
+	json.Unmarshal(rr.Body.Bytes(), &shares)
+	if len(shares) != 1 || shares[0].Uid != friend.Uid || shares[0].Email != friend.Email {
+		t.Errorf("wrong shares: got %+v", shares)
+	}
+
+	if rr := send("GET", imagePath, "", friendToken); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for shared image: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if count := sharedImages(); count != 1 {
+		t.Errorf("wrong number of images shared with friend: got %v want 1", count)
+	}
+	if rr := send("DELETE", imagePath, "", friendToken); rr.Code == http.StatusOK {
+		t.Errorf("shared image deleted by friend")
+	}
+
+	if rr := send("DELETE", fmt.Sprintf("%s/%v", sharesPath, friend.Uid), "", token); rr.Code != http.StatusNoContent {
+		t.Errorf("failed to revoke share: got %v", rr.Code)
+	}
+	if rr := send("GET", imagePath, "", friendToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code for revoked image: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if count := sharedImages(); count != 0 {
+		t.Errorf("wrong number of images shared with friend after revoking: got %v want 0", count)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+/*
+	This file implements the organisation wide sharing policy. Administrators choose whether
+	images are shared by default, whether images may be shared publicly at all and whether
+	shared images are served with a watermark. The policy is evaluated whenever the shareable
+	flag of an image is set, before it is persisted.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	SHARING_TABLE = "sharing_policy"
+
+	WATERMARK_DIR     = "watermark" // Sub directory of the user image directory holding watermarked copies
+	WATERMARK_STRIPE  = 24          // Width in pixels of the watermark stripes
+	WATERMARK_OPACITY = 96          // Opacity of the watermark stripes from 0 to 255
+)
+
+// ErrSharingDisabled is returned when an image is shared while the policy disallows public sharing
+var ErrSharingDisabled = errors.New("public sharing is disabled by the sharing policy")
+
+// SharingPolicy is the organisation wide sharing policy tagged for sql serialization
+// the table holds at most a single row with id 1, the environment provides defaults until it is set
+type SharingPolicy struct {
+	Id               int32 `json:"-" sql:"id" opt:"PRIMARY KEY"`
+	DefaultShareable bool  `json:"defaultShareable" sql:"default_shareable"` // Shareable value of uploads that don't specify one
+	AllowPublic      bool  `json:"allowPublic" sql:"allow_public"`           // Images may be marked shareable
+	Watermark        bool  `json:"watermark" sql:"watermark"`                // Shared images are served with a watermark
+}
+
+// defaultSharingPolicy returns the policy defined by the SHARE_DEFAULT, SHARE_PUBLIC and SHARE_WATERMARK environment variables
+func defaultSharingPolicy() SharingPolicy {
+	return SharingPolicy{
+		Id:               1,
+		DefaultShareable: envBool("SHARE_DEFAULT", false),
+		AllowPublic:      envBool("SHARE_PUBLIC", true),
+		Watermark:        envBool("SHARE_WATERMARK", false),
+	}
+}
+
+// envBool parses the boolean environment variable returning fallback if it is unset or invalid
+func envBool(name string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Shareable resolves the shareable flag of an image from the requested form value
+// an empty value applies the default, sharing is rejected with ErrSharingDisabled if the policy disallows it
+func (p SharingPolicy) Shareable(requested string) (bool, error) {
+	shareable := requested == "true"
+	if len(requested) == 0 {
+		shareable = p.DefaultShareable && p.AllowPublic
+	}
+	if shareable && !p.AllowPublic {
+		return false, ErrSharingDisabled
+	}
+	return shareable, nil
+}
+
+// resolveShareable applies the sharing policy kept by the store to the requested shareable value
+func resolveShareable(ctx context.Context, store DataStore, requested string) (bool, SharingPolicy, error) {
+	policy, err := store.SharingPolicy(ctx)
+	if err != nil {
+		return false, SharingPolicy{}, err
+	}
+	shareable, err := policy.Shareable(requested)
+	return shareable, policy, err
+}
+
+// writeSharingError reports a failure to resolve the shareable flag to the client
+func writeSharingError(w http.ResponseWriter, err error) {
+	if err == ErrSharingDisabled {
+		logger.Error("shareable image rejected by sharing policy sending 403")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Public sharing is disabled by your organisation"))
+		return
+	}
+	logger.Error("failed to retrieve sharing policy sending 500: %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("500 - Failed to apply sharing policy, try again later"))
+}
+
+// watermarkKey returns the storage key of the watermarked copy of the image
+func watermarkKey(image Image) string {
+	return fmt.Sprintf("%v/%s/%v.%v", image.Uid, WATERMARK_DIR, image.Id, strings.Split(image.Encoding, "/")[1])
+}
+
+// ensureWatermark writes the watermarked copy of the image unless it already exists
+// returns ErrUnsupportedEncoding for encodings that cannot be rewritten
+func ensureWatermark(ctx context.Context, img Image) error {
+	if !canDecode(img.Encoding) || !canEncode(img.Encoding) {
+		return ErrUnsupportedEncoding
+	}
+
+	existing, err := storage.Open(ctx, watermarkKey(img))
+	if err == nil {
+		existing.Close()
+		return nil
+	}
+	if err != ErrObjectNotFound {
+		return fmt.Errorf("failed to check watermark: %v", err)
+	}
+
+	file, err := storage.Open(ctx, imageKey(img))
+	if err != nil {
+		return fmt.Errorf("failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = encodeImage(buf, watermarkImage(src), img.Encoding)
+	if err != nil {
+		return fmt.Errorf("failed to encode watermark: %v", err)
+	}
+
+	return storage.Put(ctx, watermarkKey(img), buf, int64(buf.Len()), img.Encoding)
+}
+
+// watermarkImage returns a copy of the image overlaid with translucent white diagonal stripes
+func watermarkImage(src image.Image) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if ((x+y)/WATERMARK_STRIPE)%4 != 0 {
+				continue
+			}
+			// Blend premultiplied color channels towards the alpha of the pixel
+			i := dst.PixOffset(x, y)
+			a := uint32(dst.Pix[i+3])
+			for c := 0; c < 3; c++ {
+				v := uint32(dst.Pix[i+c])
+				dst.Pix[i+c] = uint8(v + (a-v)*WATERMARK_OPACITY/255)
+			}
+		}
+	}
+
+	return dst
+}
+
+// sharingPolicy returns the sharing policy to administrators
+func sharingPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	_, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request for sharing policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetSharingPolicy(req.Context())
+	if err != nil {
+		logger.Error("failed to retrieve sharing policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve sharing policy, try again later"))
+		return
+	}
+
+	writeSharingPolicy(w, policy)
+}
+
+// updateSharingPolicy accepts a json body with any of defaultShareable, allowPublic and watermark
+// and updates the sharing policy, images that are already shared are not changed
+func updateSharingPolicy(w http.ResponseWriter, req *http.Request) {
+	// Authenticate administrator
+	claims, err := authAdmin(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update sharing policy: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	policy, err := GetSharingPolicy(req.Context())
+	if err != nil {
+		logger.Error("failed to retrieve sharing policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve sharing policy, try again later"))
+		return
+	}
+
+	// Decode over the current policy so omitted fields are unchanged
+	err = json.NewDecoder(req.Body).Decode(&policy)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	err = SetSharingPolicy(policy)
+	if err != nil {
+		logger.Error("failed to update sharing policy sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update sharing policy, try again later"))
+		return
+	}
+
+	writeSharingPolicy(w, policy)
+	logger.Info("Sharing policy updated by user %v: %+v", claims.Uid, policy)
+}
+
+// writeSharingPolicy writes the policy as the json response body
+func writeSharingPolicy(w http.ResponseWriter, policy SharingPolicy) {
+	js, err := json.Marshal(policy)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
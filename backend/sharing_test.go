@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// TestSharingPolicyShareable ensures the requested shareable value is resolved according to the policy
+func TestSharingPolicyShareable(t *testing.T) {
+	tt := []struct {
+		Policy    SharingPolicy
+		Requested string
+		Expected  bool
+		Err       error
+	}{
+		{SharingPolicy{AllowPublic: true}, "", false, nil},
+		{SharingPolicy{AllowPublic: true}, "true", true, nil},
+		{SharingPolicy{AllowPublic: true, DefaultShareable: true}, "", true, nil},
+		{SharingPolicy{AllowPublic: true, DefaultShareable: true}, "false", false, nil},
+		{SharingPolicy{AllowPublic: false, DefaultShareable: true}, "", false, nil},
+		{SharingPolicy{AllowPublic: false}, "false", false, nil},
+		{SharingPolicy{AllowPublic: false}, "true", false, ErrSharingDisabled},
+	}
+
+	for _, tc := range tt {
+		shareable, err := tc.Policy.Shareable(tc.Requested)
+		if shareable != tc.Expected || err != tc.Err {
+			t.Errorf("wrong result for %+v %q: got %v, %v want %v, %v", tc.Policy, tc.Requested, shareable, err, tc.Expected, tc.Err)
+		}
+	}
+}
+
+// TestDefaultSharingPolicy ensures the environment configures the policy used until one is set
+func TestDefaultSharingPolicy(t *testing.T) {
+	defer os.Unsetenv("SHARE_PUBLIC")
+	defer os.Unsetenv("SHARE_WATERMARK")
+
+	if policy := defaultSharingPolicy(); !policy.AllowPublic || policy.DefaultShareable || policy.Watermark {
+		t.Errorf("wrong default policy: got %+v", policy)
+	}
+
+	os.Setenv("SHARE_PUBLIC", "false")
+	os.Setenv("SHARE_WATERMARK", "true")
+	if policy := defaultSharingPolicy(); policy.AllowPublic || !policy.Watermark {
+		t.Errorf("wrong configured policy: got %+v", policy)
+	}
+}
+
+// TestWatermarkImage ensures stripes lighten the image while the remainder is unchanged
+func TestWatermarkImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4*WATERMARK_STRIPE, 4*WATERMARK_STRIPE))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+		if i%4 != 3 {
+			src.Pix[i] = 0
+		}
+	}
+
+	marked := watermarkImage(src)
+	if marked.Bounds() != src.Bounds() {
+		t.Fatalf("wrong bounds: got %v want %v", marked.Bounds(), src.Bounds())
+	}
+	if c := color.RGBAModel.Convert(marked.At(0, 0)).(color.RGBA); c.R == 0 || c.A != 255 {
+		t.Errorf("stripe not drawn: got %v", c)
+	}
+	if c := color.RGBAModel.Convert(marked.At(WATERMARK_STRIPE, 0)).(color.RGBA); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("image changed outside stripes: got %v", c)
+	}
+	if c := src.RGBAAt(0, 0); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("source image modified: got %v", c)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+/*
+	This file lets users sign in with an account of an OAuth2 provider such as Google or GitHub
+	instead of a password. /auth/{provider}/login redirects to the provider with a random state kept
+	in a short lived signed cookie, and the provider returns the user to /auth/{provider}/callback
+	with a code exchanged for the profile of the account. The account is linked to a local user as
+	an identity: signed in users link the account to themselves, otherwise the user with the same
+	verified email is linked or a new user without a password is registered. The standard auth
+	token is then issued as if the user signed in with a password.
+
+	Providers are registered with RegisterLoginProvider and enabled by defining the
+	<PROVIDER>_CLIENT_ID and <PROVIDER>_CLIENT_SECRET environment variables.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	IDENTITY_TABLE = "user_identity"
+
+	LOGIN_STATE_COOKIE  = "login_state"
+	LOGIN_STATE_SUBJECT = "login_state" // Subject of the state tokens of pending logins
+	LOGIN_STATE_TTL     = 10 * time.Minute
+	LOGIN_STATE_BYTES   = 16
+	LOGIN_TIMEOUT       = 10 * time.Second // Limit of each request made to a provider
+	LOGIN_RESPONSE_MAX  = 1 << 20          // Bytes read from provider responses
+
+	PROVIDER_GOOGLE = "google"
+	PROVIDER_GITHUB = "github"
+
+	AUDIT_LINK = "link_login" // Provider account linked to the user
+)
+
+// Endpoints of the built in providers
+const (
+	GOOGLE_AUTH_URL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	GOOGLE_TOKEN_URL    = "https://oauth2.googleapis.com/token"
+	GOOGLE_USERINFO_URL = "https://openidconnect.googleapis.com/v1/userinfo"
+	GITHUB_AUTH_URL     = "https://github.com/login/oauth/authorize"
+	GITHUB_TOKEN_URL    = "https://github.com/login/oauth/access_token"
+	GITHUB_API_URL      = "https://api.github.com"
+)
+
+var (
+	ErrIdentityLinked  = errors.New("account is linked to another user")
+	ErrNoVerifiedEmail = errors.New("account has no verified email")
+)
+
+// UserIdentity links the account of a login provider to a user tagged for sql serialization
+type UserIdentity struct {
+	Id       int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32     `sql:"uid"`
+	Provider string    `sql:"provider"`
+	Subject  string    `sql:"subject"` // Id of the account at the provider
+	Email    string    `sql:"email"`   // Email of the account when it was linked
+	Created  time.Time `sql:"created"`
+}
+
+// SocialProfile is the account of the user at a login provider
+type SocialProfile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Firstname     string
+	Lastname      string
+}
+
+// LoginProvider is an OAuth2 provider users may sign in with, Profile retrieves the account
+// authorized by an access token
+type LoginProvider struct {
+	Name     string
+	AuthURL  string
+	TokenURL string
+	Scopes   []string
+	Profile  func(ctx context.Context, accessToken string) (SocialProfile, error)
+}
+
+// LoginState is the state of a pending login held by the state cookie
+type LoginState struct {
+	Nonce    string // Sent to the provider as the state parameter
+	Provider string
+	Redirect string `json:",omitempty"` // Where the user is sent once signed in
+	Uid      int    `json:",omitempty"` // Signed in user linking the account
+	jwt.StandardClaims
+}
+
+// loginProviders are the providers users may sign in with by name
+var loginProviders = map[string]LoginProvider{}
+
+// loginClient makes the requests to providers
+var loginClient = &http.Client{Timeout: LOGIN_TIMEOUT}
+
+func init() {
+	RegisterLoginProvider(LoginProvider{
+		Name:     PROVIDER_GOOGLE,
+		AuthURL:  GOOGLE_AUTH_URL,
+		TokenURL: GOOGLE_TOKEN_URL,
+		Scopes:   []string{"openid", "email", "profile"},
+		Profile:  googleProfile,
+	})
+	RegisterLoginProvider(LoginProvider{
+		Name:     PROVIDER_GITHUB,
+		AuthURL:  GITHUB_AUTH_URL,
+		TokenURL: GITHUB_TOKEN_URL,
+		Scopes:   []string{"read:user", "user:email"},
+		Profile:  githubProfile,
+	})
+}
+
+// RegisterLoginProvider adds a provider users may sign in with once its credentials are defined
+func RegisterLoginProvider(provider LoginProvider) {
+	loginProviders[provider.Name] = provider
+}
+
+// credentials returns the client id and secret of the provider defined by the <PROVIDER>_CLIENT_ID
+// and <PROVIDER>_CLIENT_SECRET environment variables, reporting false if either is undefined
+func (p LoginProvider) credentials() (string, string, bool) {
+	prefix := strings.ToUpper(p.Name)
+	id, secret := os.Getenv(prefix+"_CLIENT_ID"), os.Getenv(prefix+"_CLIENT_SECRET")
+	return id, secret, len(id) > 0 && len(secret) > 0
+}
+
+// enabledProvider returns the provider named in the url if its credentials are defined
+func enabledProvider(req *http.Request) (LoginProvider, string, string, bool) {
+	provider, ok := loginProviders[mux.Vars(req)["provider"]]
+	if !ok {
+		return LoginProvider{}, "", "", false
+	}
+	id, secret, ok := provider.credentials()
+	return provider, id, secret, ok
+}
+
+// callbackUrl returns the url providers return users to, based on the LOGIN_BASE_URL environment
+// variable or the host of the request when it is undefined
+func callbackUrl(req *http.Request, provider string) string {
+	base := strings.TrimSuffix(os.Getenv("LOGIN_BASE_URL"), "/")
+	if len(base) == 0 {
+		scheme := "https"
+		if req.TLS == nil {
+			scheme = "http"
+		}
+		base = fmt.Sprintf("%s://%s", scheme, req.Host)
+	}
+	return fmt.Sprintf("%s%s/auth/%s/callback", base, versionPrefix(req), provider)
+}
+
+// allowedRedirect reports whether users may be sent to the target once signed in, a path of the API
+// or an origin explicitly allowed by the CORS configuration so logins can't redirect elsewhere
+func allowedRedirect(target string) bool {
+	if strings.HasPrefix(target, "/") {
+		return !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\")
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || len(parsed.Host) == 0 {
+		return false
+	}
+	origin := fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+	return currentCors().allowOrigin(origin) == origin
+}
+
+// setLoginStateCookie sets the cookie holding the state of a pending login, an expired cookie removes it.
+// The cookie is sent with the top level navigation from the provider so it is never SameSite strict
+func setLoginStateCookie(w http.ResponseWriter, req *http.Request, provider string, value string, expires time.Time) {
+	config := currentCookie()
+	http.SetCookie(w, &http.Cookie{
+		Name:     LOGIN_STATE_COOKIE,
+		Value:    value,
+		Expires:  expires,
+		Path:     fmt.Sprintf("%s/auth/%s/", versionPrefix(req), provider),
+		Domain:   config.Domain,
+		Secure:   config.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// socialLogin redirects the user to the provider to sign in, signed in users link the account to themselves
+func socialLogin(w http.ResponseWriter, req *http.Request) {
+	provider, clientId, _, ok := enabledProvider(req)
+	if !ok {
+		logger.Error("Login with unknown or disabled provider %s sending 404", mux.Vars(req)["provider"])
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Signing in with this provider is not available"))
+		return
+	}
+
+	redirect := req.URL.Query().Get("redirect")
+	if len(redirect) > 0 && !allowedRedirect(redirect) {
+		logger.Error("Login with disallowed redirect %s sending 400", redirect)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - redirect must be a path or an allowed origin"))
+		return
+	}
+
+	buf := make([]byte, LOGIN_STATE_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		logger.Error("failed to generate login state sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to start sign in, try again later"))
+		return
+	}
+
+	expires := time.Now().Add(LOGIN_STATE_TTL)
+	nonce := hex.EncodeToString(buf)
+	state := &LoginState{
+		Nonce:    nonce,
+		Provider: provider.Name,
+		Redirect: redirect,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   LOGIN_STATE_SUBJECT,
+			ExpiresAt: expires.Unix(),
+		},
+	}
+	if claims, err := authRequest(req); err == nil && len(claims.ClientId) == 0 {
+		state.Uid = claims.Uid
+	}
+	stateStr, err := signToken(state)
+	if err != nil {
+		logger.Error("failed to sign login state sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to start sign in, try again later"))
+		return
+	}
+	setLoginStateCookie(w, req, provider.Name, stateStr, expires)
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientId},
+		"redirect_uri":  {callbackUrl(req, provider.Name)},
+		"scope":         {strings.Join(provider.Scopes, " ")},
+		"state":         {nonce},
+	}
+	http.Redirect(w, req, provider.AuthURL+"?"+params.Encode(), http.StatusFound)
+}
+
+// loginState returns the state of the pending login of the request if it was started with the
+// provider and matches the state returned by the provider
+func loginState(req *http.Request, provider string) (LoginState, error) {
+	cookie, err := req.Cookie(LOGIN_STATE_COOKIE)
+	if err != nil {
+		return LoginState{}, fmt.Errorf("no pending login")
+	}
+	state := LoginState{}
+	token, err := jwt.ParseWithClaims(cookie.Value, &state, verificationKey)
+	if err != nil || !token.Valid || state.Subject != LOGIN_STATE_SUBJECT || state.Provider != provider {
+		return LoginState{}, fmt.Errorf("invalid or expired login state")
+	}
+	if subtle.ConstantTimeCompare([]byte(state.Nonce), []byte(req.URL.Query().Get("state"))) != 1 {
+		return LoginState{}, fmt.Errorf("login state mismatch")
+	}
+	return state, nil
+}
+
+// socialCallback completes a login once the provider returns the user, signing in the linked user
+func socialCallback(w http.ResponseWriter, req *http.Request) {
+	provider, clientId, secret, ok := enabledProvider(req)
+	if !ok {
+		logger.Error("Login with unknown or disabled provider %s sending 404", mux.Vars(req)["provider"])
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Signing in with this provider is not available"))
+		return
+	}
+
+	state, err := loginState(req, provider.Name)
+	if err != nil {
+		logger.Error("Login callback from %s refused sending 400: %v", provider.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Sign in expired or was started elsewhere, sign in again"))
+		return
+	}
+	setLoginStateCookie(w, req, provider.Name, "", time.Unix(0, 0))
+
+	query := req.URL.Query()
+	if len(query.Get("error")) > 0 || len(query.Get("code")) == 0 {
+		logger.Error("Login with %s declined sending 401: %s", provider.Name, query.Get("error"))
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, sign in was declined"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 2*LOGIN_TIMEOUT)
+	defer cancel()
+	accessToken, err := exchangeLoginCode(ctx, provider, clientId, secret, query.Get("code"), callbackUrl(req, provider.Name))
+	if err == nil {
+		var profile SocialProfile
+		profile, err = provider.Profile(ctx, accessToken)
+		if err == nil && len(profile.Subject) == 0 {
+			err = fmt.Errorf("profile has no subject")
+		}
+		if err == nil {
+			signInIdentity(w, req, provider.Name, profile, state)
+			return
+		}
+	}
+	logger.Error("Login with %s failed sending 502: %v", provider.Name, err)
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(fmt.Sprintf("502 - Unable to sign in with %s, try again later", provider.Name)))
+}
+
+// signInIdentity signs in the user linked to the account, linking or registering a user if needed
+func signInIdentity(w http.ResponseWriter, req *http.Request, provider string, profile SocialProfile, state LoginState) {
+	user, action, err := identityUser(provider, profile, int32(state.Uid))
+	if err == ErrIdentityLinked {
+		logger.Error("Account of %s already linked sending 409: %v", provider, err)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - This %s account is linked to another user", provider)))
+		return
+	}
+	if err == ErrNoVerifiedEmail {
+		logger.Error("Account of %s has no verified email sending 400", provider)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - Your %s account has no verified email, verify it and try again", provider)))
+		return
+	}
+	if err != nil {
+		logger.Error("Unable to sign in with %s sending 500: %v", provider, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to sign in, try again later"))
+		return
+	}
+
+	if !requireSecondFactor(w, req, user, action, state.Redirect) {
+		return
+	}
+
+	logger.Info("Successfull %s login with %s for user: %v", action, provider, user.Uid)
+	recordAudit(req, int(user.Uid), action, 0)
+
+	token, exp, ok := issueToken(w, user)
+	if !ok {
+		return
+	}
+	if len(state.Redirect) > 0 {
+		http.Redirect(w, req, state.Redirect, http.StatusSeeOther)
+		return
+	}
+	writeTokenResp(w, token, exp)
+}
+
+// identityUser returns the user linked to the account and the audit action of the sign in. Accounts
+// are linked to the signed in user, otherwise to the user with the verified email of the account
+// or a newly registered user
+func identityUser(provider string, profile SocialProfile, linkUid int32) (User, string, error) {
+	identity, found, err := GetUserIdentity(provider, profile.Subject)
+	if err != nil {
+		return User{}, "", err
+	}
+	if found {
+		if linkUid != 0 && identity.Uid != linkUid {
+			return User{}, "", ErrIdentityLinked
+		}
+		user, err := GetUserById(identity.Uid)
+		return user, AUDIT_LOGIN, err
+	}
+
+	identity = UserIdentity{Provider: provider, Subject: profile.Subject, Email: profile.Email, Created: time.Now().UTC()}
+	if linkUid != 0 {
+		user, err := GetUserById(linkUid)
+		if err != nil {
+			return User{}, "", err
+		}
+		identity.Uid = user.Uid
+		return user, AUDIT_LINK, AddUserIdentity(identity)
+	}
+
+	if len(profile.Email) == 0 || !profile.EmailVerified {
+		return User{}, "", ErrNoVerifiedEmail
+	}
+	user, found, err := GetUserByEmail(profile.Email)
+	if err != nil {
+		return User{}, "", err
+	}
+	if found {
+		identity.Uid = user.Uid
+		return user, AUDIT_LINK, AddUserIdentity(identity)
+	}
+
+	user = User{Email: profile.Email, Firstname: profile.Firstname, Lastname: profile.Lastname}
+	user.Uid, err = RegisterIdentityUser(user, identity)
+	return user, AUDIT_REGISTER, err
+}
+
+// exchangeLoginCode exchanges the authorization code returned by the provider for an access token
+func exchangeLoginCode(ctx context.Context, provider LoginProvider, clientId string, secret string, code string, redirectUri string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUri},
+		"client_id":     {clientId},
+		"client_secret": {secret},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp := struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}{}
+	err = doProviderRequest(req, &resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	if len(resp.AccessToken) == 0 {
+		return "", fmt.Errorf("failed to exchange code: %s", resp.Error)
+	}
+	return resp.AccessToken, nil
+}
+
+// getProviderJSON decodes the json response of a provider api authorized by the access token
+func getProviderJSON(ctx context.Context, endpoint string, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return doProviderRequest(req, v)
+}
+
+// doProviderRequest sends the request to a provider and decodes its json response
+func doProviderRequest(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := loginClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %v", req.URL.Host, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, LOGIN_RESPONSE_MAX)).Decode(v)
+}
+
+// googleProfile retrieves the Google account authorized by the access token
+func googleProfile(ctx context.Context, accessToken string) (SocialProfile, error) {
+	info := struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+	}{}
+	err := getProviderJSON(ctx, GOOGLE_USERINFO_URL, accessToken, &info)
+	if err != nil {
+		return SocialProfile{}, fmt.Errorf("failed to retrieve profile: %v", err)
+	}
+	return SocialProfile{
+		Subject:       info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Firstname:     info.GivenName,
+		Lastname:      info.FamilyName,
+	}, nil
+}
+
+// githubProfile retrieves the GitHub account authorized by the access token with its primary verified email
+func githubProfile(ctx context.Context, accessToken string) (SocialProfile, error) {
+	account := struct {
+		Id    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}{}
+	err := getProviderJSON(ctx, GITHUB_API_URL+"/user", accessToken, &account)
+	if err != nil {
+		return SocialProfile{}, fmt.Errorf("failed to retrieve profile: %v", err)
+	}
+	if account.Id == 0 {
+		return SocialProfile{}, fmt.Errorf("profile has no id")
+	}
+	emails := []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}{}
+	err = getProviderJSON(ctx, GITHUB_API_URL+"/user/emails", accessToken, &emails)
+	if err != nil {
+		return SocialProfile{}, fmt.Errorf("failed to retrieve emails: %v", err)
+	}
+
+	profile := SocialProfile{Subject: strconv.FormatInt(account.Id, 10), Firstname: account.Login}
+	if names := strings.SplitN(strings.TrimSpace(account.Name), " ", 2); len(names[0]) > 0 {
+		profile.Firstname = names[0]
+		if len(names) == 2 {
+			profile.Lastname = strings.TrimSpace(names[1])
+		}
+	}
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email, profile.EmailVerified = email.Email, email.Verified
+		}
+	}
+	return profile, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testLoginProvider registers a provider named after the test whose token endpoint is served by a
+// fake provider, the profile of each code is returned for its access token
+func testLoginProvider(t *testing.T, profiles map[string]SocialProfile) string {
+	name := strings.ToLower(strings.TrimPrefix(t.Name(), "Test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if _, ok := profiles[req.FormValue("code")]; !ok || req.FormValue("client_secret") != "secret" {
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"access_token": %q, "token_type": "bearer"}`, req.FormValue("code"))))
+	}))
+	t.Cleanup(server.Close)
+
+	RegisterLoginProvider(LoginProvider{
+		Name:     name,
+		AuthURL:  server.URL + "/authorize",
+		TokenURL: server.URL + "/token",
+		Scopes:   []string{"email"},
+		Profile: func(ctx context.Context, accessToken string) (SocialProfile, error) {
+			return profiles[accessToken], nil
+		},
+	})
+	os.Setenv(strings.ToUpper(name)+"_CLIENT_ID", "client")
+	os.Setenv(strings.ToUpper(name)+"_CLIENT_SECRET", "secret")
+	t.Cleanup(func() {
+		delete(loginProviders, name)
+		os.Unsetenv(strings.ToUpper(name) + "_CLIENT_ID")
+		os.Unsetenv(strings.ToUpper(name) + "_CLIENT_SECRET")
+	})
+	return name
+}
+
+// startTestLogin starts a login with the provider returning the state cookie and the state sent to the provider
+func startTestLogin(t *testing.T, router http.Handler, provider string, query string, token string) (*http.Cookie, string) {
+	req := httptest.NewRequest("GET", fmt.Sprintf("/auth/%s/login?%s", provider, query), nil)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("failed to start login: got %v %s", rr.Code, rr.Body.String())
+	}
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != LOGIN_STATE_COOKIE {
+		t.Fatalf("expected login state cookie: got %v", cookies)
+	}
+	return cookies[0], location.Query().Get("state")
+}
+
+// finishTestLogin returns the response of the provider returning the user with the code
+func finishTestLogin(router http.Handler, provider string, cookie *http.Cookie, state string, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", fmt.Sprintf("/auth/%s/callback?code=%s&state=%s", provider, code, state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAllowedRedirect ensures logins only redirect to paths and explicitly allowed origins
+func TestAllowedRedirect(t *testing.T) {
+	defer configureCors(currentCors())
+	configureCors(CorsConfig{Origins: []string{"https://app.example.com"}})
+
+	for target, want := range map[string]bool{
+		"/gallery":                          true,
+		"https://app.example.com/signed-in": true,
+		"//evil.com":                        false,
+		"/\\evil.com":                       false,
+		"https://evil.com":                  false,
+		"javascript:alert(1)":               false,
+		"gallery":                           false,
+	} {
+		if got := allowedRedirect(target); got != want {
+			t.Errorf("wrong result for %q: got %v want %v", target, got, want)
+		}
+	}
+
+	configureCors(CorsConfig{Origins: []string{CORS_ANY_ORIGIN}})
+	if allowedRedirect("https://evil.com") {
+		t.Errorf("expected any origin not to allow redirects to every origin")
+	}
+}
+
+// TestSocialLoginState ensures logins redirect to the provider and callbacks are refused unless they
+// return the state of the login started by the same browser
+func TestSocialLoginState(t *testing.T) {
+	provider := testLoginProvider(t, map[string]SocialProfile{})
+	router := configureRoutes()
+
+	cookie, state := startTestLogin(t, router, provider, "redirect=/gallery", "")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/"+provider+"/login", nil))
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	query := location.Query()
+	if query.Get("client_id") != "client" || query.Get("redirect_uri") != "http://example.com/auth/"+provider+"/callback" || query.Get("response_type") != "code" || len(query.Get("state")) == 0 {
+		t.Errorf("wrong provider redirect: got %v", location)
+	}
+	if !cookie.HttpOnly || cookie.Path != "/auth/"+provider+"/" {
+		t.Errorf("wrong state cookie: got %+v", cookie)
+	}
+
+	// The state cookie is signed with the auth keys but never grants access
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+cookie.Value)
+	if _, err := authRequest(req); err == nil {
+		t.Errorf("expected login state to be refused as an auth token")
+	}
+
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"no cookie":      finishTestLogin(router, provider, nil, state, "code"),
+		"wrong state":    finishTestLogin(router, provider, cookie, state+"0", "code"),
+		"other provider": finishTestLogin(router, PROVIDER_GOOGLE, cookie, state, "code"),
+	} {
+		if rr.Code != http.StatusBadRequest && rr.Code != http.StatusNotFound {
+			t.Errorf("wrong code with %s: got %v", name, rr.Code)
+		}
+	}
+
+	// Unknown codes are refused by the provider
+	if rr := finishTestLogin(router, provider, cookie, state, "unknown"); rr.Code != http.StatusBadGateway {
+		t.Errorf("wrong code for refused code: got %v want %v", rr.Code, http.StatusBadGateway)
+	}
+
+	for _, path := range []string{"/auth/unknown/login", "/auth/" + PROVIDER_GITHUB + "/login"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("wrong code for disabled provider %s: got %v want %v", path, rr.Code, http.StatusNotFound)
+		}
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/"+provider+"/login?redirect=https://evil.com", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for disallowed redirect: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestSocialLogin ensures provider accounts register, sign in and link local users
+func TestSocialLogin(t *testing.T) {
+	existing := createTestUser(t, "existing")
+	provider := testLoginProvider(t, map[string]SocialProfile{
+		"new":        {Subject: "1", Email: testEmail(t, "new"), EmailVerified: true, Firstname: "Ada", Lastname: "Lovelace"},
+		"existing":   {Subject: "2", Email: existing.Email, EmailVerified: true},
+		"unverified": {Subject: "3", Email: testEmail(t, "unverified")},
+		"linked":     {Subject: "4"},
+	})
+	router := configureRoutes()
+	login := func(code string, query string, token string) *httptest.ResponseRecorder {
+		cookie, state := startTestLogin(t, router, provider, query, token)
+		return finishTestLogin(router, provider, cookie, state, code)
+	}
+	signedIn := func(rr *httptest.ResponseRecorder) JWTClaims {
+		resp := TokenResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		claims, err := parseToken(resp.Value)
+		if rr.Code != http.StatusOK || err != nil {
+			t.Fatalf("failed to sign in: got %v %s", rr.Code, rr.Body.String())
+		}
+		return claims
+	}
+
+	// An unknown account registers a user without a password
+	registered := signedIn(login("new", "", ""))
+	cleanupTestUser(t, int32(registered.Uid))
+	user, err := GetUserById(int32(registered.Uid))
+	if err != nil || user.Email != testEmail(t, "new") || user.Firstname != "Ada" || user.Lastname != "Lovelace" {
+		t.Errorf("wrong registered user: got %+v %v", user, err)
+	}
+	if _, err := GetUserPass(user.Uid); err == nil {
+		t.Errorf("expected registered user to have no password")
+	}
+	if again := signedIn(login("new", "", "")); again.Uid != registered.Uid {
+		t.Errorf("expected the linked user to sign in: got %v want %v", again.Uid, registered.Uid)
+	}
+
+	// Accounts with the verified email of a user are linked to it
+	if claims := signedIn(login("existing", "", "")); claims.Uid != int(existing.Uid) {
+		t.Errorf("expected account to be linked by email: got %v want %v", claims.Uid, existing.Uid)
+	}
+	if rr := login("unverified", "", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for unverified email: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// Signed in users link accounts to themselves and are redirected once signed in
+	token, _, _ := generateJWT(int(existing.Uid), existing.Email)
+	rr := login("linked", "redirect=/gallery", token)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/gallery" {
+		t.Errorf("wrong response linking account: got %v %v", rr.Code, rr.Header().Get("Location"))
+	}
+	if claims := signedIn(login("linked", "", "")); claims.Uid != int(existing.Uid) {
+		t.Errorf("expected linked account to sign in: got %v want %v", claims.Uid, existing.Uid)
+	}
+
+	// Accounts linked to another user can't be linked again
+	other, _, _ := generateJWT(registered.Uid, registered.Email)
+	if rr := login("linked", "", other); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code linking account of another user: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+/*
+	This file defines how image files are persisted. Handlers never touch the file system
+	directly, instead they read and write objects by key through the configured Storage driver.
+	Keys take the form UID/ID.ext so they map directly onto the public image references.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	STORAGE_DRIVER = "local" // Default if STORAGE_DRIVER env variable is not defined
+)
+
+// ErrObjectNotFound is returned by storage drivers when the requested key does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage persists and retrieves image files by key
+type Storage interface {
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// objectRenamer is implemented by drivers that rename files without copying their content
+type objectRenamer interface {
+	Rename(ctx context.Context, from string, to string) error
+}
+
+// renameObject moves the file stored at from to the key to, files of drivers that can't rename are copied and deleted
+func renameObject(ctx context.Context, from string, to string, contentType string) error {
+	if renamer, ok := storage.(objectRenamer); ok {
+		return renamer.Rename(ctx, from, to)
+	}
+
+	err := moveObject(ctx, from, to, contentType)
+	if err != nil {
+		return err
+	}
+	err = storage.Delete(ctx, from)
+	if err != nil && err != ErrObjectNotFound {
+		logger.Error("failed to delete %s after copying it to %s: %v", from, to, err)
+	}
+	return nil
+}
+
+// Object is a stored file opened for reading
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// storage is the driver used by all handlers, local disk storage unless configured otherwise
+var storage Storage = &localStorage{root: IMAGE_DIR}
+
+// initStorage configures the named storage driver used by all handlers, files are also written
+// to the mirror driver when one is named
+func initStorage(driver string, mirror string) error {
+	configured, err := newStorage(driver)
+	if err != nil {
+		return err
+	}
+	if len(mirror) > 0 {
+		mirrorStore, err := newStorage(mirror)
+		if err != nil {
+			return fmt.Errorf("invalid storage mirror: %v", err)
+		}
+		configured = &mirrorStorage{primary: configured, mirror: mirrorStore}
+	}
+	storage = configured
+	logger.Info("Using %s storage driver", storage.Name())
+
+	if faultInjectionEnabled() {
+		storage = &faultStorage{storage}
+		logger.Warning("Fault injection enabled, administrators may make database and storage calls fail")
+	}
+	return nil
+}
+
+// newStorage returns the storage driver with the provided name configured from the environment
+func newStorage(driver string) (Storage, error) {
+	switch driver {
+	case "local":
+		return &localStorage{root: IMAGE_DIR}, nil
+	case "s3":
+		config, err := s3ConfigFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 configuration: %v", err)
+		}
+		return newS3Storage(config)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
+
+// imageKey returns the storage key of the image file, images linked to another member's upload share its key
+func imageKey(image Image) string {
+	if len(image.FileKey) > 0 {
+		return image.FileKey
+	}
+	return ownImageKey(image)
+}
+
+// ownImageKey returns the storage key derived from the image id where its own upload is stored
+func ownImageKey(image Image) string {
+	return fmt.Sprintf("%v/%v.%v", image.Uid, image.Id, strings.Split(image.Encoding, "/")[1])
+}
+
+// thumbKey returns the storage key of the image thumbnail
+func thumbKey(image Image) string {
+	return fmt.Sprintf("%v/%s/%v.%v", image.Uid, THUMB_DIR, image.Id, strings.Split(image.Encoding, "/")[1])
+}
+
+// localStorage stores objects as files beneath the root directory
+type localStorage struct {
+	root string
+}
+
+// localObject wraps an open file with its stat information
+type localObject struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (o *localObject) Size() int64 {
+	return o.info.Size()
+}
+
+func (o *localObject) ModTime() time.Time {
+	return o.info.ModTime()
+}
+
+func (s *localStorage) Name() string {
+	return "local"
+}
+
+// path converts a key to a file path ensuring it can't escape the root directory
+func (s *localStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.root, clean), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to establish directory: %v", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return file.Close()
+}
+
+func (s *localStorage) Open(ctx context.Context, key string) (Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	return &localObject{file, info}, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// Rename moves the file to the new key, replacing any file stored there
+func (s *localStorage) Rename(ctx context.Context, from string, to string) error {
+	source, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	destination, err := s.path(to)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to establish directory: %v", err)
+	}
+
+	err = os.Rename(source, destination)
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,691 @@
+package main
+
+/*
+	This file implements the storage administration API so operators can manage image storage
+	without shell access to the hosts. GET /admin/storage probes every configured driver, the
+	primary and its mirror, reporting their health, the latency of a write, read and delete round
+	trip and the objects they hold. Integrity checks compare the objects stored beneath a user's
+	directory or any key prefix with the database, files no image or blob references are reported
+	as orphans and images or blobs whose file is absent as missing. Checks are recorded as jobs
+	run asynchronously by an outbox handler like purges, their findings are listed page by page.
+	The endpoints are open to administrators and to the uids granted the storage role.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	STORAGE_CHECK_TABLE   = "storage_check"
+	STORAGE_FINDING_TABLE = "storage_finding"
+	STORAGE_CHECK_BATCH   = 500  // Images read per database round trip while checking
+	STORAGE_FINDINGS_MAX  = 5000 // Findings recorded per check, later findings are only counted
+
+	// Storage check statuses
+	CHECK_PENDING  = "pending"
+	CHECK_RUNNING  = "running"
+	CHECK_COMPLETE = "complete"
+
+	// Storage finding kinds
+	FINDING_ORPHAN  = "orphan"  // Stored file nothing references
+	FINDING_MISSING = "missing" // Referenced file absent from storage
+
+	// Event topics
+	EVENT_STORAGE_CHECK = "storage.check"
+)
+
+// ErrListUnsupported is returned when the storage driver can't enumerate its objects
+var ErrListUnsupported = errors.New("storage driver can't list objects")
+
+// ObjectInfo describes a stored object found by listing
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// objectLister is implemented by storage drivers that can enumerate the objects beneath a key prefix
+type objectLister interface {
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// StorageCheck is a requested integrity check and its report tagged for sql serialization
+type StorageCheck struct {
+	Id          int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid         int32     `sql:"uid"`    // User whose files are checked, 0 when checking a prefix
+	Prefix      string    `sql:"prefix"` // Key prefix listed, the user's directory for user checks
+	RequestedBy int32     `sql:"requested_by"`
+	Status      string    `sql:"status"`
+	Objects     int64     `sql:"objects"` // Objects listed beneath the prefix
+	Bytes       int64     `sql:"bytes"`
+	Orphans     int32     `sql:"orphans"`
+	OrphanBytes int64     `sql:"orphan_bytes"`
+	Missing     int32     `sql:"missing"`
+	Error       string    `sql:"error"` // Last failure, the check is retried by the outbox
+	Created     time.Time `sql:"created"`
+	Completed   time.Time `sql:"completed"`
+}
+
+// StorageFinding is an orphaned or missing file found by a check tagged for sql serialization
+type StorageFinding struct {
+	Id       int32     `json:"-" sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	CheckId  int32     `json:"-" sql:"check_id"`
+	Kind     string    `json:"kind" sql:"kind"`
+	FileKey  string    `json:"key" sql:"file_key"`
+	Size     int64     `json:"size" sql:"size"`        // Size of orphans, 0 for missing files
+	ImageId  int32     `json:"imageId" sql:"image_id"` // Image of missing files, 0 for blobs and orphans
+	Modified time.Time `json:"-" sql:"modified"`       // Last modification of orphans
+}
+
+// StorageCheckEvent is the payload of EVENT_STORAGE_CHECK
+type StorageCheckEvent struct {
+	CheckId int32 `json:"checkId"`
+}
+
+// StorageCheckReport is the json representation of a storage check
+type StorageCheckReport struct {
+	Id          int32      `json:"id"`
+	Uid         int32      `json:"uid,omitempty"`
+	Prefix      string     `json:"prefix"`
+	RequestedBy int32      `json:"requestedBy"`
+	Status      string     `json:"status"`
+	Objects     int64      `json:"objects"`
+	Bytes       int64      `json:"bytes"`
+	Orphans     int32      `json:"orphans"`
+	OrphanBytes int64      `json:"orphanBytes"`
+	Missing     int32      `json:"missing"`
+	Error       string     `json:"error,omitempty"`
+	Created     Timestamp  `json:"created"`
+	Completed   *Timestamp `json:"completed,omitempty"`
+}
+
+// StorageFindingResp is a finding with its modification time encoded for the API
+type StorageFindingResp struct {
+	StorageFinding
+	Modified *Timestamp `json:"modified,omitempty"`
+}
+
+// StorageFindingsResp is a page of the findings of a check
+type StorageFindingsResp struct {
+	Page         int                  `json:"page"`
+	PageSize     int                  `json:"pageSize"`
+	TotalResults int                  `json:"totalResults"`
+	Findings     []StorageFindingResp `json:"findings"`
+}
+
+// DriverStatus is the health of a storage driver
+type DriverStatus struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"` // primary or mirror
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`         // Write, read and delete round trip of a probe object
+	Objects   *int64 `json:"objects,omitempty"` // Omitted when the driver can't list its objects
+	Bytes     *int64 `json:"bytes,omitempty"`
+}
+
+func init() {
+	RegisterOutboxHandler(EVENT_STORAGE_CHECK, handleStorageCheckEvent)
+}
+
+// Report returns the json representation of the check
+func (c StorageCheck) Report() StorageCheckReport {
+	report := StorageCheckReport{
+		Id:          c.Id,
+		Uid:         c.Uid,
+		Prefix:      c.Prefix,
+		RequestedBy: c.RequestedBy,
+		Status:      c.Status,
+		Objects:     c.Objects,
+		Bytes:       c.Bytes,
+		Orphans:     c.Orphans,
+		OrphanBytes: c.OrphanBytes,
+		Missing:     c.Missing,
+		Error:       c.Error,
+		Created:     Timestamp(c.Created),
+	}
+	if c.Status == CHECK_COMPLETE {
+		completed := Timestamp(c.Completed)
+		report.Completed = &completed
+	}
+	return report
+}
+
+// listObjects passes every object of the driver beneath the prefix to fn, mirrored storage lists its primary
+func listObjects(ctx context.Context, driver Storage, prefix string, fn func(ObjectInfo) error) error {
+	switch d := driver.(type) {
+	case objectLister:
+		return d.List(ctx, prefix, fn)
+	case *faultStorage:
+		return listObjects(ctx, d.Storage, prefix, fn)
+	case *mirrorStorage:
+		return listObjects(ctx, d.primary, prefix, fn)
+	}
+	return ErrListUnsupported
+}
+
+// List walks the files beneath the directory of the prefix passing those whose key has the prefix to fn
+func (s *localStorage) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		var err error
+		dir, err = s.path(prefix[:i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// s3ListResult is the response of a ListObjectsV2 request
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List pages through the objects of the bucket beneath the prefix with ListObjectsV2 requests
+func (s *s3Storage) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		query := map[string]string{"list-type": "2", "prefix": prefix}
+		if len(token) > 0 {
+			query["continuation-token"] = token
+		}
+		pairs := []string{}
+		for key, value := range query {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+
+		u := s.objectURL("")
+		u.RawQuery = strings.Join(pairs, "&")
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to prepare s3 request: %v", err)
+		}
+		s.sign(req, S3_UNSIGNED_PAYLOAD, time.Now())
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("s3 list request failed: %v", err)
+		}
+		result := s3ListResult{}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return fmt.Errorf("s3 list request returned %v: %s", resp.StatusCode, msg)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid s3 list response: %v", err)
+		}
+
+		for _, object := range result.Contents {
+			err = fn(ObjectInfo{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+			if err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// storageDrivers returns the configured drivers by role, the primary and the mirror of mirrored storage
+func storageDrivers(driver Storage) map[string]Storage {
+	switch d := driver.(type) {
+	case *faultStorage:
+		return storageDrivers(d.Storage)
+	case *mirrorStorage:
+		return map[string]Storage{"primary": d.primary, "mirror": d.mirror}
+	}
+	return map[string]Storage{"primary": driver}
+}
+
+// inspectDriver probes the driver and counts the objects it holds
+func inspectDriver(ctx context.Context, role string, driver Storage) DriverStatus {
+	status := DriverStatus{Name: driver.Name(), Role: role, Healthy: true}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	start := time.Now()
+	err = probeStorage(ctx, driver, fmt.Sprintf("%s/%s.admin", HEALTH_PROBE_DIR, host))
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+		return status
+	}
+
+	var objects, bytes int64
+	err = listObjects(ctx, driver, "", func(object ObjectInfo) error {
+		if !strings.HasPrefix(object.Key, HEALTH_PROBE_DIR+"/") {
+			objects++
+			bytes += object.Size
+		}
+		return nil
+	})
+	if err == nil {
+		status.Objects, status.Bytes = &objects, &bytes
+	} else if err != ErrListUnsupported {
+		status.Healthy = false
+		status.Error = fmt.Sprintf("unable to list objects: %v", err)
+	}
+	return status
+}
+
+// storageStatus reports the health, latency and object counts of every storage driver
+func storageStatus(w http.ResponseWriter, req *http.Request) {
+	_, err := authRole(req, ROLE_STORAGE)
+	if err != nil {
+		logger.Error("Unauthorized request for storage status: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	drivers := []DriverStatus{}
+	for _, role := range []string{"primary", "mirror"} {
+		if driver, ok := storageDrivers(storage)[role]; ok {
+			drivers = append(drivers, inspectDriver(req.Context(), role, driver))
+		}
+	}
+
+	js, err := json.Marshal(drivers)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(js)
+}
+
+// startStorageCheck accepts a json body with the uid of the user or the key prefix to check
+// and schedules the integrity check
+func startStorageCheck(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRole(req, ROLE_STORAGE)
+	if err != nil {
+		logger.Error("Unauthorized request to check storage: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	var body struct {
+		Uid    int32   `json:"uid"`
+		Prefix *string `json:"prefix"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	if (body.Uid > 0) == (body.Prefix != nil) {
+		logger.Error("storage check without exactly one of uid and prefix sending 400")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - provide either the uid of a user or a key prefix to check"))
+		return
+	}
+
+	check := StorageCheck{
+		Uid:         body.Uid,
+		RequestedBy: int32(claims.Uid),
+		Status:      CHECK_PENDING,
+		Created:     time.Now().UTC(),
+	}
+	if body.Uid > 0 {
+		_, err = GetUserById(body.Uid)
+		if err != nil {
+			logger.Error("failed to retrieve user %v to check sending 404: %v", body.Uid, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not found, no user with that id"))
+			return
+		}
+		check.Prefix = fmt.Sprintf("%v/", body.Uid)
+	} else {
+		check.Prefix = strings.TrimPrefix(*body.Prefix, "/")
+		if strings.Contains(check.Prefix, "..") {
+			logger.Error("invalid storage check prefix %q sending 400", check.Prefix)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - prefix may not contain dot segments"))
+			return
+		}
+	}
+
+	check.Id, err = AddStorageCheck(check)
+	if err != nil {
+		logger.Error("failed to schedule storage check sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to schedule storage check, try again later"))
+		return
+	}
+
+	logger.Info("Administrator %v scheduled storage check %v of %q", claims.Uid, check.Id, check.Prefix)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/storage/checks/%v", versionPrefix(req), check.Id))
+	writeStorageCheckReport(w, http.StatusAccepted, check)
+}
+
+// storageCheckStatus returns the report of the storage check with the id
+func storageCheckStatus(w http.ResponseWriter, req *http.Request) {
+	_, err := authRole(req, ROLE_STORAGE)
+	if err != nil {
+		logger.Error("Unauthorized request for storage check status: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	check, ok := getStorageCheck(w, req)
+	if !ok {
+		return
+	}
+
+	writeStorageCheckReport(w, http.StatusOK, check)
+}
+
+// listStorageFindings returns a page of the orphaned or missing files found by the storage check
+func listStorageFindings(w http.ResponseWriter, req *http.Request) {
+	_, err := authRole(req, ROLE_STORAGE)
+	if err != nil {
+		logger.Error("Unauthorized request for storage findings: %v", err)
+		writeAdminAuthError(w, err)
+		return
+	}
+
+	page := 0
+	if param := req.URL.Query().Get("page"); len(param) > 0 {
+		page, err = strconv.Atoi(param)
+		if err != nil || page < 0 {
+			logger.Error("invalid storage findings page %q sending 400", param)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - page must be a non negative integer"))
+			return
+		}
+	}
+
+	check, ok := getStorageCheck(w, req)
+	if !ok {
+		return
+	}
+
+	kind := FINDING_ORPHAN
+	if mux.Vars(req)["kind"] == "missing" {
+		kind = FINDING_MISSING
+	}
+	findings, total, err := StorageFindings(check.Id, kind, page)
+	if err != nil {
+		logger.Error("failed to retrieve storage findings sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve findings, try again later"))
+		return
+	}
+
+	resp := StorageFindingsResp{Page: page, PageSize: PAGE_SIZE, TotalResults: total, Findings: []StorageFindingResp{}}
+	for _, finding := range findings {
+		entry := StorageFindingResp{StorageFinding: finding}
+		if !finding.Modified.IsZero() {
+			modified := Timestamp(finding.Modified)
+			entry.Modified = &modified
+		}
+		resp.Findings = append(resp.Findings, entry)
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getStorageCheck retrieves the check with the id of the request path writing the error response if it fails
+func getStorageCheck(w http.ResponseWriter, req *http.Request) (StorageCheck, bool) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	check, ok, err := GetStorageCheck(int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve storage check sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve storage check, try again later"))
+		return StorageCheck{}, false
+	}
+	if !ok {
+		logger.Error("storage check %v not found sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no storage check with that id"))
+		return StorageCheck{}, false
+	}
+	return check, true
+}
+
+// writeStorageCheckReport writes the report of the check as the json response body
+func writeStorageCheckReport(w http.ResponseWriter, status int, check StorageCheck) {
+	js, err := json.Marshal(check.Report())
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// handleStorageCheckEvent runs the storage check of the event, completed checks are ignored
+// so redelivered events are harmless and failed checks start over
+func handleStorageCheckEvent(ctx context.Context, event OutboxEvent) error {
+	var payload StorageCheckEvent
+	err := json.Unmarshal([]byte(event.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("invalid storage check event payload: %v", err)
+	}
+
+	check, ok, err := GetStorageCheck(payload.CheckId)
+	if err != nil {
+		return err
+	}
+	if !ok || check.Status == CHECK_COMPLETE {
+		return nil
+	}
+
+	check.Status = CHECK_RUNNING
+	findings, err := runStorageCheck(ctx, &check)
+	if err == nil {
+		err = ReplaceStorageFindings(check.Id, findings)
+	}
+	if err != nil {
+		check.Error = err.Error()
+	} else {
+		check.Status = CHECK_COMPLETE
+		check.Error = ""
+		check.Completed = time.Now().UTC()
+		logger.Info("Completed storage check %v of %q: %v objects, %v orphans, %v missing", check.Id, check.Prefix, check.Objects, check.Orphans, check.Missing)
+	}
+
+	updateErr := UpdateStorageCheck(check)
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// expectedFile is a storage key referenced by the database
+type expectedFile struct {
+	imageId  int32
+	required bool // Originals and blobs must exist, derived files such as thumbnails may not have been generated
+	found    bool
+}
+
+// runStorageCheck compares the objects beneath the prefix of the check with the files the database
+// references, the report counts of the check are set and the findings to record returned
+func runStorageCheck(ctx context.Context, check *StorageCheck) ([]StorageFinding, error) {
+	expected, err := expectedFiles(ctx, *check)
+	if err != nil {
+		return nil, err
+	}
+
+	check.Objects, check.Bytes, check.Orphans, check.OrphanBytes, check.Missing = 0, 0, 0, 0, 0
+	findings := []StorageFinding{}
+	record := func(finding StorageFinding) {
+		if len(findings) < STORAGE_FINDINGS_MAX {
+			findings = append(findings, finding)
+		}
+	}
+
+	err = listObjects(ctx, storage, check.Prefix, func(object ObjectInfo) error {
+		if strings.HasPrefix(object.Key, HEALTH_PROBE_DIR+"/") || strings.HasPrefix(object.Key, INTAKE_DIR+"/") {
+			return nil
+		}
+		check.Objects++
+		check.Bytes += object.Size
+		if file, ok := expected[object.Key]; ok {
+			file.found = true
+			return nil
+		}
+		check.Orphans++
+		check.OrphanBytes += object.Size
+		record(StorageFinding{CheckId: check.Id, Kind: FINDING_ORPHAN, FileKey: object.Key, Size: object.Size, Modified: object.ModTime})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s storage: %v", storage.Name(), err)
+	}
+
+	for key, file := range expected {
+		if !file.required || file.found {
+			continue
+		}
+		// Files of the user stored outside the listed prefix, such as blobs, are opened instead
+		if !strings.HasPrefix(key, check.Prefix) {
+			object, err := storage.Open(ctx, key)
+			if err == nil {
+				object.Close()
+				continue
+			}
+			if err != ErrObjectNotFound {
+				return nil, fmt.Errorf("failed to open %s: %v", key, err)
+			}
+		}
+		check.Missing++
+		record(StorageFinding{CheckId: check.Id, Kind: FINDING_MISSING, FileKey: key, ImageId: file.imageId})
+	}
+
+	return findings, nil
+}
+
+// expectedFiles returns the keys the database references that the check covers, every file of the
+// images of the user for user checks and the files beneath the prefix for prefix checks
+func expectedFiles(ctx context.Context, check StorageCheck) (map[string]*expectedFile, error) {
+	expected := map[string]*expectedFile{}
+	add := func(key string, imageId int32, required bool) {
+		if check.Uid > 0 || strings.HasPrefix(key, check.Prefix) {
+			expected[key] = &expectedFile{imageId: imageId, required: required}
+		}
+	}
+
+	var after int32
+	for {
+		images, err := StorageCheckImages(check.Uid, after, STORAGE_CHECK_BATCH)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		ids := []int32{}
+		for _, image := range images {
+			ids = append(ids, image.Id)
+		}
+		formats, err := ImageFormatKeys(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			add(imageKey(image), image.Id, true)
+			add(thumbKey(image), image.Id, false)
+			add(watermarkKey(image), image.Id, false)
+			add(exifKey(image), image.Id, false)
+			for _, key := range formats[image.Id] {
+				add(key, image.Id, false)
+			}
+		}
+		after = images[len(images)-1].Id
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	// Blobs are only listed by prefix checks, user checks open the blobs of the user's images
+	if check.Uid == 0 && (strings.HasPrefix(check.Prefix, BLOB_DIR+"/") || strings.HasPrefix(BLOB_DIR+"/", check.Prefix)) {
+		keys, err := BlobKeys(check.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := expected[key]; !ok {
+				add(key, 0, true)
+			}
+		}
+	}
+
+	return expected, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listKeys returns the keys the driver lists beneath the prefix
+func listKeys(t *testing.T, driver Storage, prefix string) []string {
+	keys := []string{}
+	err := listObjects(context.Background(), driver, prefix, func(object ObjectInfo) error {
+		keys = append(keys, object.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list %q: %v", prefix, err)
+	}
+	return keys
+}
+
+// TestLocalStorageList ensures local storage lists the files beneath any key prefix
+func TestLocalStorageList(t *testing.T) {
+	dir := t.TempDir()
+
+	local := &localStorage{root: dir}
+	for _, key := range []string{"1/1.png", "1/thumb/1.png", "12/3.png", "blobs/ab/abc.png"} {
+		local.Put(context.Background(), key, strings.NewReader("x"), 1, "image/png")
+	}
+
+	tt := []struct {
+		Prefix   string
+		Expected []string
+	}{
+		{"", []string{"1/1.png", "1/thumb/1.png", "12/3.png", "blobs/ab/abc.png"}},
+		{"1", []string{"1/1.png", "1/thumb/1.png", "12/3.png"}},
+		{"1/", []string{"1/1.png", "1/thumb/1.png"}},
+		{"1/thumb/", []string{"1/thumb/1.png"}},
+		{"blobs/ab/a", []string{"blobs/ab/abc.png"}},
+		{"7/", []string{}},
+	}
+	for _, tc := range tt {
+		if keys := listKeys(t, local, tc.Prefix); !reflect.DeepEqual(keys, tc.Expected) {
+			t.Errorf("wrong keys beneath %q: got %v want %v", tc.Prefix, keys, tc.Expected)
+		}
+	}
+
+	// Mirrored storage lists its primary
+	if keys := listKeys(t, &mirrorStorage{primary: local, mirror: &brokenStorage{}}, "12/"); !reflect.DeepEqual(keys, []string{"12/3.png"}) {
+		t.Errorf("wrong keys of mirrored storage: got %v", keys)
+	}
+}
+
+// TestS3List ensures the s3 driver follows continuation tokens until every object is listed
+func TestS3List(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s3, err := newS3Storage(s3Config{Endpoint: server.URL, Region: "us-east-1", Bucket: "pictures", AccessKey: "access", SecretKey: "secret", PathStyle: true})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, key := range []string{"1/1.png", "1/2 copy.png", "2/3.png"} {
+		s3.Put(context.Background(), key, bytes.NewReader([]byte("data")), 4, "image/png")
+	}
+
+	objects := []ObjectInfo{}
+	err = s3.List(context.Background(), "1/", func(object ObjectInfo) error {
+		objects = append(objects, object)
+		return nil
+	})
+	expected := []ObjectInfo{
+		{Key: "1/1.png", Size: 4, ModTime: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{Key: "1/2 copy.png", Size: 4, ModTime: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	if err != nil || !reflect.DeepEqual(objects, expected) {
+		t.Errorf("wrong objects: got %+v %v want %+v", objects, err, expected)
+	}
+}
+
+// TestInspectDriver ensures drivers report their latency and objects and unwritable drivers are unhealthy
+func TestInspectDriver(t *testing.T) {
+	dir := t.TempDir()
+
+	local := &localStorage{root: dir}
+	local.Put(context.Background(), "1/1.png", strings.NewReader("image"), 5, "image/png")
+
+	status := inspectDriver(context.Background(), "primary", local)
+	if !status.Healthy || status.Objects == nil || *status.Objects != 1 || *status.Bytes != 5 {
+		t.Errorf("wrong status of healthy driver: got %+v", status)
+	}
+	if keys := listKeys(t, local, HEALTH_PROBE_DIR+"/"); len(keys) > 0 {
+		t.Errorf("expected probe to be removed: got %v", keys)
+	}
+
+	status = inspectDriver(context.Background(), "mirror", &brokenStorage{})
+	if status.Healthy || len(status.Error) == 0 || status.Objects != nil {
+		t.Errorf("wrong status of broken driver: got %+v", status)
+	}
+
+	drivers := storageDrivers(&faultStorage{&mirrorStorage{primary: local, mirror: &brokenStorage{}}})
+	if len(drivers) != 2 || drivers["primary"] != Storage(local) {
+		t.Errorf("wrong drivers of mirrored storage: got %v", drivers)
+	}
+}
+
+// TestStorageAdminValidation ensures the storage endpoints are restricted to administrators and the storage
+// role and that checks name exactly one user or prefix. None of the evaluated requests reach the database
+func TestStorageAdminValidation(t *testing.T) {
+	defer func(configured Storage) { storage = configured }(storage)
+	dir := t.TempDir()
+	storage = &localStorage{root: dir}
+
+	router := configureRoutes()
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+	os.Setenv("STORAGE_ADMIN_UIDS", "8")
+	defer os.Unsetenv("STORAGE_ADMIN_UIDS")
+
+	tt := []struct {
+		Uid      int
+		Method   string
+		Path     string
+		Body     string
+		Expected int
+	}{
+		{1, "GET", "/admin/storage", "", http.StatusForbidden},
+		{7, "GET", "/admin/storage", "", http.StatusOK},
+		{8, "GET", "/admin/storage", "", http.StatusOK},
+		{1, "POST", "/admin/storage/checks", `{"uid": 1}`, http.StatusForbidden},
+		{8, "POST", "/admin/storage/checks", `not json`, http.StatusBadRequest},
+		{8, "POST", "/admin/storage/checks", `{}`, http.StatusBadRequest},
+		{8, "POST", "/admin/storage/checks", `{"uid": 1, "prefix": "1/"}`, http.StatusBadRequest},
+		{8, "POST", "/admin/storage/checks", `{"prefix": "1/../2/"}`, http.StatusBadRequest},
+		{1, "GET", "/admin/storage/checks/1/orphans", "", http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		token, _, err := generateJWT(tc.Uid, testUser.Email)
+		if err != nil {
+			t.Fatalf("failed to generate jwt: %v", err)
+		}
+		req := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(tc.Body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.Expected {
+			t.Errorf("wrong code for %s %s %s by %v: got %v want %v", tc.Method, tc.Path, tc.Body, tc.Uid, rr.Code, tc.Expected)
+		}
+		if tc.Path == "/admin/storage" && rr.Code == http.StatusOK {
+			drivers := []DriverStatus{}
+			json.Unmarshal(rr.Body.Bytes(), &drivers)
+			if len(drivers) != 1 || drivers[0].Role != "primary" || !drivers[0].Healthy {
+				t.Errorf("wrong drivers: got %+v", drivers)
+			}
+		}
+	}
+}
+
+// TestStorageCheck ensures a check of a user reports files nothing references as orphans and
+// lists the images whose file is missing
+func TestStorageCheck(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	kept := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), kept)
+	lost := uploadTestImage(t, router, token, false)
+	defer DeleteImageData(context.Background(), lost)
+
+	ctx := context.Background()
+	storage.Delete(ctx, imageKey(lost))
+	orphan := fmt.Sprintf("%v/stray.png", kept.Uid)
+	storage.Put(ctx, orphan, strings.NewReader("stray"), 5, "image/png")
+	defer storage.Delete(ctx, orphan)
+
+	os.Setenv("ADMIN_UIDS", "7")
+	defer os.Unsetenv("ADMIN_UIDS")
+	adminToken, _, err := generateJWT(7, "admin@mail.com")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", adminToken))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/admin/storage/checks", fmt.Sprintf(`{"uid": %v}`, kept.Uid))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("failed to start check: got %v", rr.Code)
+	}
+	report := StorageCheckReport{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	location := rr.Header().Get("Location")
+
+	payload, _ := json.Marshal(StorageCheckEvent{CheckId: report.Id})
+	err = handleStorageCheckEvent(ctx, OutboxEvent{Topic: EVENT_STORAGE_CHECK, Payload: string(payload)})
+	if err != nil {
+		t.Fatalf("failed to run check: %v", err)
+	}
+
+	json.Unmarshal(send("GET", location, "").Body.Bytes(), &report)
+	if report.Status != CHECK_COMPLETE || report.Orphans != 1 || report.OrphanBytes != 5 || report.Missing != 1 {
+		t.Errorf("wrong check report: got %+v", report)
+	}
+
+	for kind, expected := range map[string]StorageFinding{
+		"orphans": {Kind: FINDING_ORPHAN, FileKey: orphan, Size: 5},
+		"missing": {Kind: FINDING_MISSING, FileKey: imageKey(lost), ImageId: lost.Id},
+	} {
+		resp := StorageFindingsResp{}
+		json.Unmarshal(send("GET", location+"/"+kind, "").Body.Bytes(), &resp)
+		if resp.TotalResults != 1 || resp.Findings[0].StorageFinding != expected {
+			t.Errorf("wrong %s: got %+v want %+v", kind, resp, expected)
+		}
+	}
+}
@@ -9,14 +9,17 @@ package main
 */
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/inflowml/logger"
-	"github.com/inflowml/structql"
+	"github.com/lib/pq" // The PostgreSQL driver, also scanning array aggregates
 )
 
 // Default database configuration for non-production deployments
@@ -25,453 +28,4770 @@ const (
 	IMAGE_TABLE = "image_meta"
 	USER_TABLE  = "user_meta"
 	PASS_TABLE  = "user_pass"
+	TAG_TABLE   = "image_tags"
 
 	// Request Constants
 	PAGE_SIZE = 50 // Retrieve no more than 50 responses at a time
 
 	// Default DB Configuration
-	DB_NAME   = "dbtest"
-	DB_USER   = "tester"
-	DB_PASS   = "testpass"
-	DB_HOST   = "localhost"
-	DB_PORT   = "5432"
-	DB_DRIVER = structql.Postgres
+	DB_NAME = "dbtest"
+	DB_USER = "tester"
+	DB_PASS = "testpass"
+	DB_HOST = "localhost"
+	DB_PORT = "5432"
+
+	// Default Connection Pool Configuration
+	DB_MAX_OPEN        = 20 // Maximum connections open at once
+	DB_MAX_IDLE        = 5  // Maximum idle connections kept for reuse
+	DB_CONN_LIFETIME   = 30 // Minutes before a connection is recycled
+	DB_HEALTH_INTERVAL = 30 // Seconds between database health checks
+)
+
+// pool is the connection pool shared by every database action, opened on first use by getDB
+var (
+	pool     *sql.DB
+	poolLock sync.Mutex
 )
 
+func init() {
+	RegisterJob("database-health", DB_HEALTH_INTERVAL*time.Second, CheckDB)
+}
+
+// tables are created by InitSQL in order, columns are declared by the sql, typ and opt tags of each object
+var tables = []struct {
+	Name   string
+	Object interface{}
+}{
+	{IMAGE_TABLE, Image{}},
+	{USER_TABLE, User{}},
+	{PASS_TABLE, UserPassword{}},
+	{TAG_TABLE, ImageTag{}},
+	{OUTBOX_TABLE, OutboxEvent{}},
+	{RESET_TABLE, PasswordReset{}},
+	{SHARING_TABLE, SharingPolicy{}},
+	{INGEST_TABLE, IngestPolicy{}},
+	{OAUTH_CLIENT_TABLE, OAuthClient{}},
+	{OAUTH_GRANT_TABLE, OAuthGrant{}},
+	{USER_PURGE_TABLE, PurgeJob{}},
+	{ALBUM_TABLE, Album{}},
+	{ALBUM_IMAGE_TABLE, AlbumImage{}},
+	{IMAGE_SHARE_TABLE, ImageShare{}},
+	{USER_BLOCK_TABLE, UserBlock{}},
+	{AUDIT_TABLE, AuditEntry{}},
+	{MODERATION_POLICY_TABLE, ModerationPolicy{}},
+	{MODERATION_CASE_TABLE, ModerationCase{}},
+	{REPORT_TABLE, ImageReport{}},
+	{BLOB_TABLE, Blob{}},
+	{IMAGE_VIEW_TABLE, ImageView{}},
+	{IMAGE_VIEW_DAY_TABLE, ImageViewDay{}},
+	{REENCODE_TABLE, ReencodeCampaign{}},
+	{IMAGE_FORMAT_TABLE, ImageFormat{}},
+	{MENTION_TABLE, Mention{}},
+	{NOTIFICATION_TABLE, Notification{}},
+	{STORAGE_CHECK_TABLE, StorageCheck{}},
+	{STORAGE_FINDING_TABLE, StorageFinding{}},
+	{DAMAGED_IMAGE_TABLE, DamagedImage{}},
+	{GUEST_LINK_TABLE, GuestLink{}},
+	{INTAKE_TABLE, UploadIntake{}},
+	{ANNOUNCEMENT_TABLE, Announcement{}},
+	{IDENTITY_TABLE, UserIdentity{}},
+	{API_KEY_TABLE, ApiKey{}},
+	{TOTP_TABLE, UserTotp{}},
+	{RECOVERY_TABLE, RecoveryCode{}},
+	{WEBHOOK_TABLE, Webhook{}},
+	{LIKE_TABLE, ImageLike{}},
+	{COMMENT_TABLE, Comment{}},
+	{RATING_TABLE, ImageRating{}},
+}
+
 // InitSQL attempts to connect to the database and generates necessary tables if required
 func InitSQL() error {
 	logger.Info("Attempting to initialize database")
 
-	// Connect to database
-	conn, err := connectSQL()
+	// Open the connection pool shared by later database actions
+	db, err := getDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
-	defer conn.Close()
 
-	// Create image_meta table if it doesn't already exist
-	err = conn.CreateTableFromObject(IMAGE_TABLE, Image{})
+	// Create each table if it doesn't already exist
+	for _, table := range tables {
+		err = createTable(db, table.Name, table.Object)
+		if err != nil {
+			return fmt.Errorf("failed to create %s table: %v", table.Name, err)
+		}
+	}
+
+	// Add content hash, file key, date and trash columns to image_meta, images stored earlier remain
+	// unversioned and are dated when the columns are added
+	added, err := addMissingColumns(db, IMAGE_TABLE, Image{})
 	if err != nil {
-		return fmt.Errorf("failed to create image_meta table: %v", err)
+		return fmt.Errorf("failed to add image columns: %v", err)
 	}
 
-	// Create user_meta table if it doesn't already exist
-	err = conn.CreateTableFromObject(USER_TABLE, User{})
+	// Index descriptions for word searches, the indexed expression must match DESCRIPTION_SEARCH
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_description_idx ON %s USING GIN (%s)", IMAGE_TABLE, IMAGE_TABLE, DESCRIPTION_SEARCH))
 	if err != nil {
-		return fmt.Errorf("failed to create user_meta table: %v", err)
+		return fmt.Errorf("failed to index descriptions: %v", err)
+	}
+
+	// Index the columns filtered and ordered by image meta queries
+	for _, index := range imageQueryIndexes {
+		_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s", index.Name, index.Definition))
+		if err != nil {
+			return fmt.Errorf("failed to create index %s: %v", index.Name, err)
+		}
 	}
 
-	// Create user_pass table if it doesn't already exist
-	err = conn.CreateTableFromObject(PASS_TABLE, UserPassword{})
+	// Files of each image are looked up when it is served
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_image_idx ON %s (image_id)", IMAGE_FORMAT_TABLE, IMAGE_FORMAT_TABLE))
 	if err != nil {
-		return fmt.Errorf("failed to create user_meta table: %v", err)
+		return fmt.Errorf("failed to index image formats: %v", err)
 	}
 
-	logger.Info("Database successfully initialized")
+	// Images stored before deduplication own the file at the key derived from their id
+	if containsString(added, "file_key") {
+		_, err = db.Exec(fmt.Sprintf("UPDATE %s SET file_key = uid || '/' || id || '.' || split_part(encoding, '/', 2) WHERE file_key = ''", IMAGE_TABLE))
+		if err != nil {
+			return fmt.Errorf("failed to assign file keys: %v", err)
+		}
+	}
 
-	return nil
-}
+	// Add the username column to user_meta, usernames are unique regardless of case
+	_, err = addMissingColumns(db, USER_TABLE, User{})
+	if err != nil {
+		return fmt.Errorf("failed to add user columns: %v", err)
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_username_idx ON %s (lower(username)) WHERE username <> ''", USER_TABLE, USER_TABLE))
+	if err != nil {
+		return fmt.Errorf("failed to index usernames: %v", err)
+	}
 
-// AddImageMeta inserts a row into the image_meta table and returns the assigned id
-func AddImageData(imgData Image) (int32, error) {
+	// Mentions and comments are retrieved with their images, notifications, storage findings, guest links, queued uploads, api keys and webhooks listed per user, check, album and user
+	for table, col := range map[string]string{MENTION_TABLE: "image_id", COMMENT_TABLE: "image_id", NOTIFICATION_TABLE: "uid", STORAGE_FINDING_TABLE: "check_id", GUEST_LINK_TABLE: "album_id", INTAKE_TABLE: "uid", API_KEY_TABLE: "uid", RECOVERY_TABLE: "uid", WEBHOOK_TABLE: "uid"} {
+		_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s_idx ON %s (%s)", table, col, table, col))
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %v", table, err)
+		}
+	}
 
-	conn, err := connectSQL()
+	// Users like each image once, liked images are listed per user
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_image_uid_idx ON %s (image_id, uid)", LIKE_TABLE, LIKE_TABLE))
+	if err != nil {
+		return fmt.Errorf("failed to index likes: %v", err)
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_uid_idx ON %s (uid)", LIKE_TABLE, LIKE_TABLE))
 	if err != nil {
-		return 0, fmt.Errorf("unable to add image meta to db due to connection error: %v", err)
+		return fmt.Errorf("failed to index likes: %v", err)
 	}
-	defer conn.Close()
 
-	id, err := conn.InsertObject(IMAGE_TABLE, imgData)
+	// Ratings are keyed by image and user, rating an image again replaces the rating
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_image_uid_idx ON %s (image_id, uid)", RATING_TABLE, RATING_TABLE))
 	if err != nil {
-		return 0, fmt.Errorf("unable to add image meta due to insertion error: %v", err)
+		return fmt.Errorf("failed to index ratings: %v", err)
 	}
 
-	return int32(id), nil
-}
+	// Each image has a single row of counts per day
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_image_day_idx ON %s (image_id, day)", IMAGE_VIEW_DAY_TABLE, IMAGE_VIEW_DAY_TABLE))
+	if err != nil {
+		return fmt.Errorf("failed to index daily views: %v", err)
+	}
 
-// UpdateImageData accepts an imgData objects and updates the corresponding row to match the parameter
-func UpdateImageData(imgData Image) error {
-	conn, err := connectSQL()
+	// Each provider account is linked to a single user
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_subject_idx ON %s (provider, subject)", IDENTITY_TABLE, IDENTITY_TABLE))
 	if err != nil {
-		return fmt.Errorf("unable to update image meta to db due to connection error: %v", err)
+		return fmt.Errorf("failed to index identities: %v", err)
 	}
-	defer conn.Close()
 
-	err = conn.UpdateObject(IMAGE_TABLE, imgData)
+	// Add storage quota columns to user_meta
+	added, err = addMissingColumns(db, USER_TABLE, UserUsage{})
 	if err != nil {
-		return fmt.Errorf("unable to update image meta: %v", err)
+		return fmt.Errorf("failed to add quota columns: %v", err)
+	}
+
+	// Usage of users created before quotas were tracked is computed from their images
+	if containsString(added, "used_bytes") {
+		_, err = db.Exec(fmt.Sprintf("UPDATE %s SET used_bytes = (SELECT COALESCE(SUM(size), 0) FROM %s WHERE uid = %s.id)", USER_TABLE, IMAGE_TABLE, USER_TABLE))
+		if err != nil {
+			return fmt.Errorf("failed to compute storage usage: %v", err)
+		}
 	}
 
+	logger.Info("Database successfully initialized")
+
 	return nil
 }
 
-// DeleteImageData deletes the row corresponding to the imageData provided in the func parameter
-func DeleteImageData(imageData Image) error {
-	conn, err := connectSQL()
+// AddImageData inserts a row into the image_meta table with the tags and mentions of the image in a single
+// transaction adding the image size to the owner's storage usage. ref derives the reference of the image from
+// the assigned id and place is called last to store the file of the image, the image only commits once its file
+// is in place and nothing is recorded when any step fails. Returns the image with its id and reference.
+// ErrQuotaExceeded is returned if the image would exceed the owner's quota
+func AddImageData(ctx context.Context, imgData Image, ref func(id int32) string, place func(tx dbtx) error) (Image, error) {
+
+	db, err := getDB()
 	if err != nil {
-		return fmt.Errorf("unable to delete image meta to db due to connection error: %v", err)
+		return Image{}, fmt.Errorf("unable to add image meta to db due to connection error: %v", err)
 	}
-	defer conn.Close()
 
-	err = conn.DeleteObject(IMAGE_TABLE, imageData)
+	err = withTxContext(ctx, db, func(tx *sql.Tx) error {
+		err := reserveBytes(tx, imgData.Uid, int64(imgData.Size))
+		if err != nil {
+			return err
+		}
+
+		imgData.Id, err = insertObject(tx, IMAGE_TABLE, imgData)
+		if err != nil {
+			return fmt.Errorf("unable to add image meta due to insertion error: %v", err)
+		}
+
+		// References contain the id to ensure unique file names
+		imgData.Ref = ref(imgData.Id)
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET ref = $1 WHERE id = $2", IMAGE_TABLE), imgData.Ref, imgData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to update image reference: %v", err)
+		}
+
+		for _, tag := range imgData.Tags {
+			_, err = insertObject(tx, TAG_TABLE, ImageTag{ImageId: imgData.Id, Tag: tag})
+			if err != nil {
+				return fmt.Errorf("unable to add image tag due to insertion error: %v", err)
+			}
+		}
+		for _, mention := range imgData.Mentions {
+			_, err = insertObject(tx, MENTION_TABLE, Mention{ImageId: imgData.Id, Uid: mention.Uid, Username: mention.Username, Offset: mention.Offset, Length: mention.Length, Created: imgData.Uploaded})
+			if err != nil {
+				return fmt.Errorf("unable to add mention: %v", err)
+			}
+		}
+
+		err = place(tx)
+		if err != nil {
+			return err
+		}
+
+		// The title is claimed after the file is in place so the user's title lock is only held until the commit
+		title, err := claimTitle(tx, imgData.Uid, imgData.Title, imgData.Id)
+		if err != nil {
+			return err
+		}
+		if title != imgData.Title {
+			imgData.Title = title
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET title = $1 WHERE id = $2", IMAGE_TABLE), imgData.Title, imgData.Id)
+			if err != nil {
+				return fmt.Errorf("unable to update image title: %v", err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("unable to delete image meta: %v", err)
+		return Image{}, err
 	}
 
-	return nil
+	return imgData, nil
 }
 
-// GetImageMeta accepts an image id and returns a single image interface that corresponds to the request.
-// This function will return an error if it is unable to retrieve an image with the given id
-func GetImageMeta(id int32) (Image, error) {
+// UpdateImageData accepts an imgData objects and updates the corresponding row to match the parameter.
+// A changed title is claimed with the title policy, the stored image is returned with the title it was given
+func UpdateImageData(ctx context.Context, imgData Image) (Image, error) {
+	db, err := getDB()
+	if err != nil {
+		return Image{}, fmt.Errorf("unable to update image meta to db due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(imgData.Id)
 
-	// Connect to database
-	conn, err := connectSQL()
+	err = withTxContext(ctx, db, func(tx *sql.Tx) error {
+		// Unchanged titles aren't claimed so images keep titles stored under an earlier policy
+		var current string
+		err := tx.QueryRow(fmt.Sprintf("SELECT title FROM %s WHERE id = $1", IMAGE_TABLE), imgData.Id).Scan(&current)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve image title: %v", err)
+		}
+		if imgData.Title != current {
+			imgData.Title, err = claimTitle(tx, imgData.Uid, imgData.Title, imgData.Id)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = updateObject(tx, IMAGE_TABLE, imgData)
+		if err != nil {
+			return fmt.Errorf("unable to update image meta: %v", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return Image{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+		return Image{}, err
 	}
-	defer conn.Close()
 
-	// Query database for requested image meta
-	dbReturn, err := conn.SelectFromWhere(Image{}, IMAGE_TABLE, fmt.Sprintf("id=%v", id))
+	return imgData, nil
+}
+
+// BulkUpdateImages applies the changes to every image in a single transaction. A nil shareable or tags
+// leaves the value unchanged, an album adds the images that aren't in it yet to its end or returns
+// ErrAlbumFull without changing any image if they don't fit
+func BulkUpdateImages(imageIds []int32, shareable *bool, tags []string, album *Album) error {
+	db, err := getDB()
 	if err != nil {
-		return Image{}, fmt.Errorf("unable to retrieve metadata: %v", err)
+		return fmt.Errorf("unable to update images due to connection error: %v", err)
 	}
+	defer invalidateImageMeta(imageIds...)
 
-	// Failed to retrieve
-	if len(dbReturn) != 1 {
-		return Image{}, fmt.Errorf("404 - Not found")
+	ids := []interface{}{}
+	for _, id := range imageIds {
+		ids = append(ids, id)
 	}
 
-	// Cast and return image at 0 index
-	return dbReturn[0].(Image), nil
-}
+	return withTx(db, func(tx *sql.Tx) error {
+		if shareable != nil {
+			where := &whereBuilder{}
+			value := where.bind(*shareable)
+			where.add(fmt.Sprintf("id IN (%s)", where.placeholders(ids)))
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET shareable = %s WHERE %s", IMAGE_TABLE, value, where.String()), where.Args()...)
+			if err != nil {
+				return fmt.Errorf("unable to update image meta: %v", err)
+			}
+		}
 
-// ImageMetaQuery accepts query parameters and returns an array of image interfaces
-func ImageMetaQuery(uid int, params url.Values) (QueryResp, error) {
+		if tags != nil {
+			where := &whereBuilder{}
+			where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(ids)))
+			_, err := deleteWhere(tx, TAG_TABLE, where.String(), where.Args()...)
+			if err != nil {
+				return fmt.Errorf("unable to clear image tags: %v", err)
+			}
+			for _, id := range imageIds {
+				for _, tag := range tags {
+					_, err = insertObject(tx, TAG_TABLE, ImageTag{ImageId: id, Tag: tag})
+					if err != nil {
+						return fmt.Errorf("unable to add image tag due to insertion error: %v", err)
+					}
+				}
+			}
+		}
 
-	// Connect to database
-	conn, err := connectSQL()
+		if album != nil {
+			var locked int32
+			err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = $1 FOR UPDATE", ALBUM_TABLE), album.Id).Scan(&locked)
+			if err != nil {
+				return fmt.Errorf("unable to lock album: %v", err)
+			}
+
+			current, err := albumImageIds(tx, album.Id, "")
+			if err != nil {
+				return err
+			}
+			added := []int32{}
+			for _, id := range imageIds {
+				if !containsImageId(current, id) {
+					added = append(added, id)
+				}
+			}
+			if len(added) == 0 {
+				return nil
+			}
+
+			order := albumOrder(current, added, -1)
+			if len(order) > ALBUM_MAX_IMAGES {
+				return ErrAlbumFull
+			}
+			return writeAlbumOrder(tx, album.Id, order)
+		}
+		return nil
+	})
+}
+
+// DeleteImageData deletes the row corresponding to the imageData provided in the func parameter
+// the stored size is released from the owner's storage usage in the same transaction
+func DeleteImageData(ctx context.Context, imageData Image) error {
+	db, err := getDB()
 	if err != nil {
-		return QueryResp{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+		return fmt.Errorf("unable to delete image meta to db due to connection error: %v", err)
 	}
-	defer conn.Close()
+	defer invalidateImageMeta(imageData.Id)
+
+	return withTxContext(ctx, db, func(tx *sql.Tx) error {
+		var uid, size int32
+		err := tx.QueryRow(fmt.Sprintf("DELETE FROM %s WHERE id = $1 RETURNING uid, size", IMAGE_TABLE), imageData.Id).Scan(&uid, &size)
+		if err == sql.ErrNoRows {
+			return nil // Already deleted, usage was released by the first delete
+		}
+		if err != nil {
+			return fmt.Errorf("unable to delete image meta: %v", err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET used_bytes = GREATEST(used_bytes - $1, 0) WHERE id = $2", USER_TABLE), int64(size), uid)
+		if err != nil {
+			return fmt.Errorf("unable to release storage usage: %v", err)
+		}
 
-	// Define page of request
-	page, err := strconv.Atoi(params.Get("page"))
+		// Remove tags belonging to the deleted image
+		_, err = deleteWhere(tx, TAG_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete image tags: %v", err)
+		}
+
+		// Remove the deleted image from albums
+		_, err = deleteWhere(tx, ALBUM_IMAGE_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete album images: %v", err)
+		}
+
+		// Revoke the grants to the deleted image
+		_, err = deleteWhere(tx, IMAGE_SHARE_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete image shares: %v", err)
+		}
+
+		// Remove the reports and moderation cases of the deleted image
+		_, err = deleteWhere(tx, REPORT_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete reports: %v", err)
+		}
+		_, err = deleteWhere(tx, MODERATION_CASE_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete moderation cases: %v", err)
+		}
+
+		// Remove the view counts and re-encoded files of the deleted image, the files are removed with the image
+		_, err = deleteWhere(tx, IMAGE_VIEW_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete image views: %v", err)
+		}
+		_, err = deleteWhere(tx, IMAGE_VIEW_DAY_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete daily image views: %v", err)
+		}
+		_, err = deleteWhere(tx, IMAGE_FORMAT_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete image formats: %v", err)
+		}
+		_, err = deleteWhere(tx, DAMAGED_IMAGE_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete damage record: %v", err)
+		}
+		_, err = deleteWhere(tx, LIKE_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete likes: %v", err)
+		}
+		_, err = deleteWhere(tx, COMMENT_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete comments: %v", err)
+		}
+		_, err = deleteWhere(tx, RATING_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete ratings: %v", err)
+		}
+
+		// Remove the mentions of the deleted image and notifications about it
+		_, err = deleteWhere(tx, MENTION_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete mentions: %v", err)
+		}
+		_, err = deleteWhere(tx, NOTIFICATION_TABLE, "image_id = $1", imageData.Id)
+		if err != nil {
+			return fmt.Errorf("unable to delete notifications: %v", err)
+		}
+		return nil
+	})
+}
+
+// TrashImageData moves the image to the trash recording the storage key its file was moved to,
+// reporting false if the image doesn't exist or is already trashed
+func TrashImageData(ctx context.Context, id int32, fileKey string, deleted time.Time) (bool, error) {
+	pool, err := getDB()
 	if err != nil {
-		page = 0
+		return false, fmt.Errorf("unable to trash image due to connection error: %v", err)
 	}
+	defer invalidateImageMeta(id)
 
-	// Build query string based on parameters
-	query := ""
+	result, err := withContext(ctx, pool).Exec(fmt.Sprintf("UPDATE %s SET deleted_at = $1, file_key = $2 WHERE id = $3 AND %s", IMAGE_TABLE, NOT_TRASHED), deleted, fileKey, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to trash image: %v", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to trash image: %v", err)
+	}
 
-	// Build complex db query based on url parameters
-	conditions := []string{}
+	return count > 0, nil
+}
 
-	if params.Has("id") {
-		conditions = append(conditions, fmt.Sprintf("id='%v'", params.Get("id")))
+// TrashImagesData moves the images to the trash in a single transaction recording the storage key of each
+// image's file, returning the ids of the images that were trashed. Images already in the trash are skipped
+func TrashImagesData(fileKeys map[int32]string, deleted time.Time) ([]int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to trash images due to connection error: %v", err)
 	}
-	if params.Has("uid") {
-		conditions = append(conditions, fmt.Sprintf("uid='%v'", params.Get("uid")))
+
+	trashed := []int32{}
+	defer func() { invalidateImageMeta(trashed...) }()
+	err = withTx(db, func(tx *sql.Tx) error {
+		for id, fileKey := range fileKeys {
+			result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET deleted_at = $1, file_key = $2 WHERE id = $3 AND %s", IMAGE_TABLE, NOT_TRASHED), deleted, fileKey, id)
+			if err != nil {
+				return fmt.Errorf("unable to trash image: %v", err)
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("unable to trash image: %v", err)
+			}
+			if count > 0 {
+				trashed = append(trashed, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if params.Has("title") {
-		conditions = append(conditions, fmt.Sprintf("title='%v'", params.Get("title")))
+
+	return trashed, nil
+}
+
+// RestoreImageData takes the image out of the trash recording the storage key its file was moved back to,
+// reporting false if the image isn't trashed
+func RestoreImageData(id int32, fileKey string) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to restore image due to connection error: %v", err)
 	}
-	if params.Has("shareable") {
-		conditions = append(conditions, fmt.Sprintf("shareable='%v'", params.Get("shareable")))
+	defer invalidateImageMeta(id)
+
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET deleted_at = $1, file_key = $2 WHERE id = $3 AND NOT %s", IMAGE_TABLE, NOT_TRASHED), time.Time{}, fileKey, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to restore image: %v", err)
 	}
-	if params.Has("encoding") {
-		conditions = append(conditions, fmt.Sprintf("encoding='%v'", params.Get("encoding")))
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to restore image: %v", err)
 	}
-	// Add permissions condition make sure user owns or image is shareable
-	conditions = append(conditions, fmt.Sprintf("(uid=%v OR shareable=true)", uid))
-
-	logger.Info("%v", conditions)
 
-	// Join dynamic conditions with SQL AND
-	query = strings.Join(conditions, " AND ")
+	return count > 0, nil
+}
 
-	// Default request for default parameters
-	if len(params) == 0 || (len(params) == 1 && params.Has("page")) {
-		query = fmt.Sprintf("uid=%v", uid)
+// GetTrashedImage retrieves the image if it is in the trash
+func GetTrashedImage(ctx context.Context, id int32) (Image, bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return Image{}, false, fmt.Errorf("unable to retrieve trashed image due to connection error: %v", err)
 	}
+	db := withContext(ctx, pool)
 
-	totalResp, err := conn.CountRowsWhere(IMAGE_TABLE, query)
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, "id = $1 AND NOT "+NOT_TRASHED, id)
 	if err != nil {
-		return QueryResp{}, fmt.Errorf("failed to count rows with query: %v", err)
+		return Image{}, false, fmt.Errorf("unable to retrieve trashed image: %v", err)
+	}
+	if len(rows) == 0 {
+		return Image{}, false, nil
 	}
 
-	resp := QueryResp{
-		Page:         page,
-		PageSize:     PAGE_SIZE,
-		TotalResults: int(totalResp),
-		ImageMeta:    []Image{},
+	images := []Image{rows[0].(Image)}
+	err = attachRelations(db, images)
+	if err != nil {
+		return Image{}, false, fmt.Errorf("unable to retrieve tags and mentions: %v", err)
 	}
 
-	pagedQuery := fmt.Sprintf("%s LIMIT %v OFFSET %v", query, PAGE_SIZE, page*PAGE_SIZE)
+	return images[0], true, nil
+}
 
-	// Query database for requested image meta
-	dbReturn, err := conn.SelectFromWhere(Image{}, IMAGE_TABLE, pagedQuery)
+// TrashedImages returns a page of the user's trashed images, most recently trashed first, and the number of trashed images
+func TrashedImages(ctx context.Context, uid int32, page int) ([]Image, int64, error) {
+	pool, err := getDB()
 	if err != nil {
-		return QueryResp{}, fmt.Errorf("unable to retrieve metadata: %v", err)
+		return nil, 0, fmt.Errorf("unable to retrieve trash due to connection error: %v", err)
 	}
+	db := withContext(ctx, pool)
 
-	// Cast dbReturn to array of images
+	total, err := countWhere(db, IMAGE_TABLE, "uid = $1 AND NOT "+NOT_TRASHED, uid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to count trashed images: %v", err)
+	}
+
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, "uid = $1 AND NOT "+NOT_TRASHED+" ORDER BY deleted_at DESC, id DESC LIMIT $2 OFFSET $3", uid, PAGE_SIZE, page*PAGE_SIZE)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve trashed images: %v", err)
+	}
 	images := []Image{}
-	for _, image := range dbReturn {
-		images = append(images, image.(Image))
+	for _, row := range rows {
+		images = append(images, row.(Image))
 	}
 
-	resp.ImageMeta = images
+	err = attachRelations(db, images)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve tags and mentions: %v", err)
+	}
 
-	return resp, nil
+	return images, total, nil
 }
 
-// AddUserMeta inserts a row into the image_meta table and returns the assigned id
-func AddUserData(userData User) (int32, error) {
-
-	conn, err := connectSQL()
+// ExpiredTrash returns up to limit images trashed before the cutoff, oldest first
+func ExpiredTrash(cutoff time.Time, limit int) ([]Image, error) {
+	db, err := getDB()
 	if err != nil {
-		return 0, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+		return nil, fmt.Errorf("unable to retrieve expired trash due to connection error: %v", err)
 	}
-	defer conn.Close()
 
-	id, err := conn.InsertObject(USER_TABLE, userData)
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, "NOT "+NOT_TRASHED+" AND deleted_at <= $1 ORDER BY deleted_at LIMIT $2", cutoff, limit)
 	if err != nil {
-		return 0, fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+		return nil, fmt.Errorf("unable to retrieve expired trash: %v", err)
+	}
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
 	}
 
-	return int32(id), nil
+	return images, nil
 }
 
-// GetUserData retrieves user data based on the provided email
-func GetUserData(email string) (User, error) {
+// FindImageByHash returns the oldest image with the content hash owned by a user other than uid, trashed images are ignored
+func FindImageByHash(ctx context.Context, hash string, uid int32) (Image, bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return Image{}, false, fmt.Errorf("unable to find image due to connection error: %v", err)
+	}
 
-	conn, err := connectSQL()
+	rows, err := selectWhere(withContext(ctx, pool), Image{}, IMAGE_TABLE, "hash = $1 AND uid <> $2 AND "+NOT_TRASHED+" ORDER BY id LIMIT 1", hash, uid)
 	if err != nil {
-		return User{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+		return Image{}, false, fmt.Errorf("unable to find image by hash: %v", err)
+	}
+	if len(rows) == 0 {
+		return Image{}, false, nil
 	}
-	defer conn.Close()
 
-	users, err := conn.SelectFromWhere(User{}, USER_TABLE, fmt.Sprintf("email='%s'", email))
+	return rows[0].(Image), true, nil
+}
+
+// FindOwnImageByHash returns the oldest image of the user outside the trash with the content hash
+func FindOwnImageByHash(ctx context.Context, uid int32, hash string) (Image, bool, error) {
+	pool, err := getDB()
 	if err != nil {
-		return User{}, fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+		return Image{}, false, fmt.Errorf("unable to find image due to connection error: %v", err)
 	}
-	// Failed to retrieve
-	if len(users) != 1 {
-		return User{}, fmt.Errorf("404 - Not found")
+
+	rows, err := selectWhere(withContext(ctx, pool), Image{}, IMAGE_TABLE, "uid = $1 AND hash = $2 AND "+NOT_TRASHED+" ORDER BY id LIMIT 1", uid, hash)
+	if err != nil {
+		return Image{}, false, fmt.Errorf("unable to find image by hash: %v", err)
+	}
+	if len(rows) == 0 {
+		return Image{}, false, nil
 	}
 
-	return users[0].(User), nil
+	return rows[0].(Image), true, nil
 }
 
-// UpdateUserMeta updates the corresponding row into the user_meta table according to the provided parameter
-func UpdateUserData(userData User) error {
+// UserImageHashes returns the user's images outside the trash that have a content or perceptual hash, without tags
+func UserImageHashes(ctx context.Context, uid int32) ([]Image, error) {
+	pool, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images due to connection error: %v", err)
+	}
+	db := withContext(ctx, pool)
 
-	conn, err := connectSQL()
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, "uid = $1 AND "+NOT_TRASHED+" AND (hash <> '' OR phash <> '') ORDER BY id", uid)
 	if err != nil {
-		return fmt.Errorf("unable to update user meta to db due to connection error: %v", err)
+		return nil, fmt.Errorf("unable to retrieve images: %v", err)
+	}
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
 	}
-	defer conn.Close()
+	return images, nil
+}
 
-	err = conn.UpdateObject(USER_TABLE, userData)
+// UpdateImagePHash records the perceptual hash of the image
+func UpdateImagePHash(id int32, phash string) error {
+	db, err := getDB()
 	if err != nil {
-		return fmt.Errorf("unable to update user meta: %v", err)
+		return fmt.Errorf("unable to update perceptual hash due to connection error: %v", err)
 	}
+	defer invalidateImageMeta(id)
 
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET phash = $1 WHERE id = $2", IMAGE_TABLE), phash, id)
+	if err != nil {
+		return fmt.Errorf("unable to update perceptual hash: %v", err)
+	}
 	return nil
 }
 
-// DeleteUserMeta deletes the corresponding row from the user_meta tables
-func DeleteUserData(userData User) error {
-
-	conn, err := connectSQL()
+// AttachImageRelations sets the tags and mentions of each image
+func AttachImageRelations(ctx context.Context, images []Image) error {
+	pool, err := getDB()
 	if err != nil {
-		return fmt.Errorf("unable to delete user meta to db due to connection error: %v", err)
+		return fmt.Errorf("unable to retrieve tags and mentions due to connection error: %v", err)
 	}
-	defer conn.Close()
+	return attachRelations(withContext(ctx, pool), images)
+}
 
-	password, _, err := GetHashedPass(userData.Email)
+// attachRelations sets the tags and mentions of each image
+func attachRelations(db dbtx, images []Image) error {
+	err := attachTags(db, images)
 	if err != nil {
-		return fmt.Errorf("failed to get hashed pass for deletion: %v", err)
+		return err
+	}
+	err = attachMentions(db, images)
+	if err != nil {
+		return err
+	}
+	err = attachLikeCounts(db, images)
+	if err != nil {
+		return err
 	}
+	err = attachRatings(db, images)
+	if err != nil {
+		return err
+	}
+	return attachViewCounts(db, images)
+}
 
-	err = conn.DeleteObject(USER_TABLE, userData)
+// FileReferences returns the number of images referencing the file stored at key
+func FileReferences(key string) (int64, error) {
+	db, err := getDB()
 	if err != nil {
-		return fmt.Errorf("unable to delete user meta: %v", err)
+		return 0, fmt.Errorf("unable to count file references due to connection error: %v", err)
 	}
 
-	err = conn.DeleteObject(PASS_TABLE, password)
+	refs, err := countWhere(db, IMAGE_TABLE, "file_key = $1", key)
 	if err != nil {
-		return fmt.Errorf("unable to delete user meta: %v", err)
+		return 0, fmt.Errorf("unable to count file references: %v", err)
 	}
 
-	return nil
+	return refs, nil
 }
 
-// AddUserPass inserts a hashed password into the password table adn returns the assigned id
-func AddUserPass(pass UserPassword) (int32, error) {
+// AcquireBlob adds a reference to the blob at key reporting true if the blob was created by the reference
+func AcquireBlob(key string, size int64) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to acquire blob due to connection error: %v", err)
+	}
+
+	return acquireBlobRef(db, key, size)
+}
 
-	conn, err := connectSQL()
+// acquireBlobRef adds a reference to the blob at key reporting true if the blob was created by the reference,
+// within a transaction the blob row stays locked until it ends
+func acquireBlobRef(db dbtx, key string, size int64) (bool, error) {
+	var refs int32
+	stmt := fmt.Sprintf("INSERT INTO %s (file_key, size, refs, created) VALUES ($1, $2, 1, $3) ON CONFLICT (file_key) DO UPDATE SET refs = %s.refs + 1 RETURNING refs", BLOB_TABLE, BLOB_TABLE)
+	err := db.QueryRow(stmt, key, size, time.Now().UTC()).Scan(&refs)
 	if err != nil {
-		return 0, fmt.Errorf("unable to add user pass to db due to connection error: %v", err)
+		return false, fmt.Errorf("unable to acquire blob: %v", err)
 	}
-	defer conn.Close()
 
-	id, err := conn.InsertObject(PASS_TABLE, pass)
+	return refs == 1, nil
+}
+
+// ReleaseBlob removes a reference to the blob at key. When no reference remains the blob row is deleted and
+// remove is called to delete its file before the deletion commits, references acquired meanwhile wait for the
+// commit so they never find the row of a deleted file
+func ReleaseBlob(key string, remove func() error) error {
+	db, err := getDB()
 	if err != nil {
-		return 0, fmt.Errorf("unable to add user pass due to insertion error: %v", err)
+		return fmt.Errorf("unable to release blob due to connection error: %v", err)
 	}
 
-	return int32(id), nil
+	return withTx(db, func(tx *sql.Tx) error {
+		var refs int32
+		err := tx.QueryRow(fmt.Sprintf("UPDATE %s SET refs = refs - 1 WHERE file_key = $1 RETURNING refs", BLOB_TABLE), key).Scan(&refs)
+		if err == sql.ErrNoRows {
+			return nil // Already released
+		}
+		if err != nil {
+			return fmt.Errorf("unable to release blob: %v", err)
+		}
+		if refs > 0 {
+			return nil
+		}
+
+		_, err = deleteWhere(tx, BLOB_TABLE, "file_key = $1", key)
+		if err != nil {
+			return fmt.Errorf("unable to delete blob: %v", err)
+		}
+		return remove()
+	})
 }
 
-// UpdateUserMeta updates the corresponding row into the user_meta table according to the provided parameter
-func UpdateUserPass(pass UserPassword) error {
+// UpdateImageContent records the new content hash and storage key of an image whose file was rewritten and
+// adjusts the owner's storage usage by the change in size. The change is applied even if it exceeds the
+// quota as the user did not choose to store more
+func UpdateImageContent(id int32, uid int32, sizeDelta int32, hash string, fileKey string) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update image content due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(id)
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET size = size + $1, hash = $2, file_key = $3 WHERE id = $4", IMAGE_TABLE), sizeDelta, hash, fileKey, id)
+		if err != nil {
+			return fmt.Errorf("unable to update image meta: %v", err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET used_bytes = GREATEST(used_bytes + $1, 0) WHERE id = $2", USER_TABLE), int64(sizeDelta), uid)
+		if err != nil {
+			return fmt.Errorf("unable to update storage usage: %v", err)
+		}
+		return nil
+	})
+}
 
-	conn, err := connectSQL()
+// reserveBytes adds bytes to the user's storage usage if it remains within their quota
+// returns ErrQuotaExceeded without changing the usage otherwise
+func reserveBytes(db dbtx, uid int32, bytes int64) error {
+	stmt := fmt.Sprintf("UPDATE %s SET used_bytes = used_bytes + $1 WHERE id = $2 AND used_bytes + $1 <= CASE WHEN quota > 0 THEN quota ELSE $3 END", USER_TABLE)
+	result, err := db.Exec(stmt, bytes, uid, getUserQuota())
 	if err != nil {
-		return fmt.Errorf("unable to update user pass to db due to connection error: %v", err)
+		return fmt.Errorf("unable to reserve storage: %v", err)
 	}
-	defer conn.Close()
 
-	err = conn.UpdateObject(PASS_TABLE, pass)
+	reserved, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("unable to update user pass: %v", err)
+		return fmt.Errorf("unable to reserve storage: %v", err)
+	}
+	if reserved == 0 {
+		return ErrQuotaExceeded
 	}
 
 	return nil
 }
 
-// DeleteUserMeta deletes the corresponding row from the user_meta tables
-func DeleteUserPass(pass UserPassword) error {
-
-	conn, err := connectSQL()
+// GetUserUsage returns the storage quota and usage of the user
+func GetUserUsage(uid int32) (UserUsage, error) {
+	db, err := getDB()
 	if err != nil {
-		return fmt.Errorf("unable to delete user pass to db due to connection error: %v", err)
+		return UserUsage{}, fmt.Errorf("unable to retrieve usage due to connection error: %v", err)
 	}
-	defer conn.Close()
 
-	err = conn.DeleteObject(PASS_TABLE, pass)
+	rows, err := selectWhere(db, UserUsage{}, USER_TABLE, "id = $1", uid)
 	if err != nil {
-		return fmt.Errorf("unable to delete user pass: %v", err)
+		return UserUsage{}, fmt.Errorf("unable to retrieve usage: %v", err)
+	}
+	if len(rows) != 1 {
+		return UserUsage{}, fmt.Errorf("404 - Not found")
 	}
 
-	return nil
+	return rows[0].(UserUsage), nil
 }
 
-func GetHashedPass(email string) (UserPassword, User, error) {
-	conn, err := connectSQL()
+// GetStorageUsage sums the stored bytes of the user and projects the bytes served in a month from the
+// views of their images spread over the months since upload, a uid of 0 sums the usage of every user
+func GetStorageUsage(uid int32) (StorageUsage, error) {
+	db, err := getDB()
 	if err != nil {
-		return UserPassword{}, User{}, fmt.Errorf("unable to delete user pass to db due to connection error: %v", err)
+		return StorageUsage{}, fmt.Errorf("unable to retrieve storage usage due to connection error: %v", err)
 	}
-	defer conn.Close()
 
-	userRows, err := conn.SelectFromWhere(User{}, USER_TABLE, fmt.Sprintf("email='%s'", email))
+	userFilter, imageFilter, args := "", "", []interface{}{}
+	if uid > 0 {
+		userFilter, imageFilter, args = " WHERE id = $1", " WHERE i.uid = $1", []interface{}{uid}
+	}
+
+	usage := StorageUsage{}
+	stmt := fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(used_bytes), 0) FROM %s", USER_TABLE) + userFilter
+	err = db.QueryRow(stmt, args...).Scan(&usage.Users, &usage.Stored)
 	if err != nil {
-		return UserPassword{}, User{}, fmt.Errorf("selection failed, unable to retrieve hashed uid: %v", err)
+		return StorageUsage{}, fmt.Errorf("unable to retrieve stored bytes: %v", err)
 	}
 
-	if len(userRows) != 1 {
-		return UserPassword{}, User{}, fmt.Errorf("cannot find email")
+	stmt = fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(COALESCE(v.views, 0) * i.size / GREATEST(EXTRACT(EPOCH FROM now() - i.uploaded) / %v, 1)), 0)::BIGINT FROM %s i LEFT JOIN %s v ON v.image_id = i.id",
+		ESTIMATE_MONTH*24*60*60, IMAGE_TABLE, IMAGE_VIEW_TABLE) + imageFilter
+	err = db.QueryRow(stmt, args...).Scan(&usage.Images, &usage.Served)
+	if err != nil {
+		return StorageUsage{}, fmt.Errorf("unable to retrieve served bytes: %v", err)
 	}
 
-	user := userRows[0].(User)
+	return usage, nil
+}
 
-	passRows, err := conn.SelectFromWhere(UserPassword{}, PASS_TABLE, fmt.Sprintf("id=%v", user.Uid))
+// SetImageTags replaces the tags assigned to an image with the provided tags
+func SetImageTags(ctx context.Context, imageId int32, tags []string) error {
+	pool, err := getDB()
 	if err != nil {
-		return UserPassword{}, User{}, fmt.Errorf("selection failed, unable to retrieve hashed uid: %v", err)
+		return fmt.Errorf("unable to set image tags due to connection error: %v", err)
 	}
+	defer invalidateImageMeta(imageId)
+	db := withContext(ctx, pool)
 
-	if len(userRows) != 1 {
-		return UserPassword{}, User{}, fmt.Errorf("cannot find hashed pass")
+	_, err = deleteWhere(db, TAG_TABLE, "image_id = $1", imageId)
+	if err != nil {
+		return fmt.Errorf("unable to clear image tags: %v", err)
 	}
 
-	pass := passRows[0].(UserPassword)
+	for _, tag := range tags {
+		_, err = insertObject(db, TAG_TABLE, ImageTag{ImageId: imageId, Tag: tag})
+		if err != nil {
+			return fmt.Errorf("unable to add image tag due to insertion error: %v", err)
+		}
+	}
 
-	return pass, user, nil
+	return nil
 }
 
-// UniqueEmail queries the user_table in order to determine if an email is unique
-func UniqueEmail(email string) (bool, error) {
-	conn, err := connectSQL()
-	if err != nil {
-		return false, fmt.Errorf("unable to connect to database: %v", err)
+// attachTags populates the tags of each provided image
+func attachTags(db dbtx, images []Image) error {
+	if len(images) == 0 {
+		return nil
 	}
-	defer conn.Close()
 
-	users, err := conn.SelectFromWhere(User{}, USER_TABLE, fmt.Sprintf("email='%s'", email))
+	// Index images by id to assign tags in a single query
+	where := &whereBuilder{}
+	ids := []interface{}{}
+	index := map[int32]int{}
+	for i := range images {
+		images[i].Tags = []string{}
+		ids = append(ids, images[i].Id)
+		index[images[i].Id] = i
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(ids)))
+
+	rows, err := selectWhere(db, ImageTag{}, TAG_TABLE, where.String()+" ORDER BY id", where.Args()...)
 	if err != nil {
-		return false, fmt.Errorf("unable to query user table: %v", err)
+		return fmt.Errorf("unable to retrieve image tags: %v", err)
 	}
-	if len(users) > 0 {
-		return false, nil
+
+	for _, row := range rows {
+		tag := row.(ImageTag)
+		if i, ok := index[tag.ImageId]; ok {
+			images[i].Tags = append(images[i].Tags, tag.Tag)
+		}
 	}
 
-	return true, nil
+	return nil
 }
 
-// connectSQL returns structql Connection this must be closed after the the database action is done
-func connectSQL() (*structql.Connection, error) {
-	dbConfig, err := generateDBConfig()
-	if err != nil {
-		return nil, fmt.Errorf("unable to generate db config: %v", err)
+// GetImageMeta accepts an image id and returns a single image interface that corresponds to the request.
+// This function will return an error if it is unable to retrieve an image with the given id, trashed images are not found
+// Images are served from the metadata cache when present
+func GetImageMeta(ctx context.Context, id int32) (Image, error) {
+	if image, ok := imageMetas.Get(id, time.Now()); ok {
+		return image, nil
 	}
+	generation := imageMetas.Generation()
 
-	conn, err := structql.Connect(dbConfig)
+	// Connect to database
+	pool, err := getDB()
 	if err != nil {
-		return nil, fmt.Errorf("undable to connect to sql db: %v", err)
+		return Image{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
 	}
+	db := withContext(ctx, pool)
 
-	return conn, nil
-}
+	// Query database for requested image meta
+	dbReturn, err := selectWhere(db, Image{}, IMAGE_TABLE, "id = $1 AND "+NOT_TRASHED, id)
+	if err != nil {
+		return Image{}, fmt.Errorf("unable to retrieve metadata: %v", err)
+	}
 
-// GenerateDBConfig assigns appropriate environment variables
-// when environment variables don't exist the defaults for testing are applied
-func generateDBConfig() (structql.ConnectionConfig, error) {
+	// Failed to retrieve
+	if len(dbReturn) != 1 {
+		return Image{}, fmt.Errorf("404 - Not found")
+	}
 
-	// DBNAME Env Variable -> Name of database
-	dbName := os.Getenv("DB_NAME")
-	if len(dbName) == 0 {
-		dbName = DB_NAME
+	// Cast image at 0 index and retrieve its tags
+	images := []Image{dbReturn[0].(Image)}
+	err = attachRelations(db, images)
+	if err != nil {
+		return Image{}, fmt.Errorf("unable to retrieve tags and mentions: %v", err)
 	}
 
-	// DBUSER Env Variable -> User for this service
-	dbUser := os.Getenv("DB_USER")
-	if len(dbUser) == 0 {
-		dbUser = DB_USER
+	imageMetas.Add(images[0], generation, time.Now())
+	return images[0], nil
+}
+
+// GetImages retrieves the images with the ids and their relations by id, trashed images are omitted
+func GetImages(ids []int32) (map[int32]Image, error) {
+	images := map[int32]Image{}
+	if len(ids) == 0 {
+		return images, nil
 	}
 
-	// DBPASS Env Variable -> Pass for this service's user
-	dbPass := os.Getenv("DB_PASS")
-	if len(dbPass) == 0 {
-		dbPass = DB_PASS
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images due to connection error: %v", err)
 	}
 
-	// DBHOST Env Variable -> Address of server
-	dbHost := os.Getenv("DB_HOST")
-	if len(dbHost) == 0 {
-		dbHost = DB_HOST
+	where := &whereBuilder{}
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	where.add(NOT_TRASHED)
+	where.add(fmt.Sprintf("id IN (%s)", where.placeholders(args)))
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images: %v", err)
 	}
 
-	// DBHOST Env Variable -> Port of server
-	dbPort := os.Getenv("DB_PORT")
-	if len(dbPort) == 0 {
-		dbPort = DB_PORT
+	list := []Image{}
+	for _, row := range rows {
+		list = append(list, row.(Image))
+	}
+	err = attachRelations(db, list)
+	if err != nil {
+		return nil, err
 	}
 
-	// Configuration for test db
-	// NOTE: PRODUCTION DEPLOYMENTS MUST USE SECURED PASSWORDS
-	dbConfig := structql.ConnectionConfig{
-		Database: dbName,
-		User:     dbUser,
-		Password: dbPass,
-		Host:     dbHost,
-		Port:     dbPort,
-		Driver:   structql.Postgres,
+	for _, image := range list {
+		images[image.Id] = image
 	}
+	return images, nil
+}
+
+// imageQueryCondition validates the query parameters and builds the condition selecting the images
+// visible to the user. Parameter values are bound as arguments and never embedded in the condition
+func imageQueryCondition(uid int, params url.Values) (*whereBuilder, error) {
+	where := &whereBuilder{}
+	where.add(NOT_TRASHED)
 
-	return dbConfig, nil
+	// Default request when no parameter filters the images
+	filtered := false
+	for name := range params {
+		filtered = filtered || !isPagingParam(name)
+	}
+	if !filtered {
+		where.add("uid = ?", uid)
+		return where, nil
+	}
 
+	if params.Has("id") {
+		id, err := strconv.ParseInt(params.Get("id"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", params.Get("id"))
+		}
+		where.add("id = ?", id)
+	}
+	owned := false
+	if params.Has("uid") {
+		owner, err := strconv.ParseInt(params.Get("uid"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid %q", params.Get("uid"))
+		}
+		where.add("uid = ?", owner)
+		owned = owner == int64(uid)
+	}
+	if params.Has("title") {
+		// Titles match exactly, the case insensitive comparison lets the title index find the candidates
+		where.add("lower(title) = lower(?) AND title = ?", params.Get("title"), params.Get("title"))
+	}
+	if params.Has("description") {
+		where.add(DESCRIPTION_SEARCH+" @@ plainto_tsquery('simple', ?)", params.Get("description"))
+	}
+	if params.Has("shareable") {
+		shareable, err := strconv.ParseBool(params.Get("shareable"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid shareable %q, use true or false", params.Get("shareable"))
+		}
+		where.add("shareable = ?", shareable)
+	}
+	if params.Has("encoding") {
+		where.add("encoding = ?", params.Get("encoding"))
+	}
+	if params.Has("tags") || params.Has("tagMode") {
+		tags, err := parseTags(params.Get("tags"))
+		if err != nil {
+			return nil, err
+		}
+		mode := params.Get("tagMode")
+		if len(mode) == 0 {
+			mode = TAG_MODE_AND
+		}
+		err = tagCondition(where, tags, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.Has("sharedWithMe") {
+		shared, err := strconv.ParseBool(params.Get("sharedWithMe"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sharedWithMe %q, use true or false", params.Get("sharedWithMe"))
+		}
+		if shared {
+			where.add(fmt.Sprintf("id IN (SELECT image_id FROM %s WHERE uid = ?)", IMAGE_SHARE_TABLE), uid)
+		}
+	}
+
+	if params.Has("liked") {
+		liked, err := strconv.ParseBool(params.Get("liked"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid liked %q, use true or false", params.Get("liked"))
+		}
+		if liked {
+			where.add(fmt.Sprintf("id IN (SELECT image_id FROM %s WHERE uid = ?)", LIKE_TABLE), uid)
+		}
+	}
+
+	// Users see all of their own images, leaving the owner indexes to serve queries restricted to them
+	if owned {
+		return where, nil
+	}
+
+	// Add permissions condition make sure user owns, is granted access or image is shareable
+	// and the owner hasn't blocked the user
+	where.add(fmt.Sprintf("(uid = ? OR shareable = true OR id IN (SELECT image_id FROM %s WHERE uid = ?))", IMAGE_SHARE_TABLE), uid, uid)
+	where.add(fmt.Sprintf("uid NOT IN (SELECT uid FROM %s WHERE blocked_uid = ?)", USER_BLOCK_TABLE), uid)
+
+	return where, nil
+}
+
+// imageQueryIndexes serve image meta queries. Indexes of image_meta only cover images outside the trash,
+// matching NOT_TRASHED of every query, and end with the id breaking ties of sorted pages so pages are read
+// in index order. Shares, blocks and tags are looked up by the subqueries of the visibility and tag conditions
+var imageQueryIndexes = []struct {
+	Name       string
+	Definition string
+}{
+	{IMAGE_TABLE + "_uid_idx", fmt.Sprintf("%s (uid, id) WHERE %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_TABLE + "_uid_uploaded_idx", fmt.Sprintf("%s (uid, uploaded, id) WHERE %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_TABLE + "_uid_title_idx", fmt.Sprintf("%s (uid, lower(title), id) WHERE %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_TABLE + "_uid_encoding_idx", fmt.Sprintf("%s (uid, encoding) WHERE %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_TABLE + "_title_idx", fmt.Sprintf("%s (lower(title)) WHERE %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_TABLE + "_shareable_idx", fmt.Sprintf("%s (id) WHERE shareable AND %s", IMAGE_TABLE, NOT_TRASHED)},
+	{IMAGE_SHARE_TABLE + "_uid_idx", IMAGE_SHARE_TABLE + " (uid, image_id)"},
+	{IMAGE_SHARE_TABLE + "_image_idx", IMAGE_SHARE_TABLE + " (image_id)"},
+	{USER_BLOCK_TABLE + "_blocked_idx", USER_BLOCK_TABLE + " (blocked_uid, uid)"},
+	{TAG_TABLE + "_tag_idx", TAG_TABLE + " (tag, image_id)"},
+	{TAG_TABLE + "_image_idx", TAG_TABLE + " (image_id)"},
+}
+
+// ImageMetaQuery accepts query parameters and returns an array of image interfaces
+// queries are served by the read replica when one is configured unless strong is set
+func ImageMetaQuery(ctx context.Context, uid int, params url.Values, strong bool) (QueryResp, error) {
+
+	// Build query condition based on url parameters
+	where, err := imageQueryCondition(uid, params)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("invalid query parameters: %v", err)
+	}
+
+	// Connect to database
+	pool, err := getReadDB(strong)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+	}
+	db := withContext(ctx, pool)
+
+	// Define page and order of request
+	page, err := parseMetaPage(params)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("invalid query parameters: %v", err)
+	}
+
+	logger.Info("%v", where.String())
+
+	totalResp, err := countWhere(db, IMAGE_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("failed to count rows with query: %v", err)
+	}
+
+	facets, err := imageFacets(db, uid, where)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("failed to count facets: %v", err)
+	}
+
+	resp := QueryResp{
+		Page:         page.Page,
+		PageSize:     page.PageSize,
+		TotalResults: int(totalResp),
+		ImageMeta:    []Image{},
+		Facets:       facets,
+	}
+
+	pagedQuery := page.condition(where)
+
+	// Query database for requested image meta
+	dbReturn, err := selectWhere(db, Image{}, IMAGE_TABLE, pagedQuery, where.Args()...)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("unable to retrieve metadata: %v", err)
+	}
+
+	// Cast dbReturn to array of images
+	images := []Image{}
+	for _, image := range dbReturn {
+		images = append(images, image.(Image))
+	}
+
+	err = attachRelations(db, images)
+	if err != nil {
+		return QueryResp{}, fmt.Errorf("unable to retrieve tags and mentions: %v", err)
+	}
+
+	resp.ImageMeta = images
+
+	return resp, nil
+}
+
+// ExportImageMeta passes every image owned by uid that matches the query parameters to emit
+// in batches ordered by id, rows are read a batch at a time so exports of any size use bounded memory
+func ExportImageMeta(uid int, params url.Values, emit func([]Image) error) error {
+
+	// Connect to database
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to export image meta due to connection error: %v", err)
+	}
+
+	lastId := int32(0)
+	for {
+		// Build the condition for each batch as bound arguments accumulate in the builder
+		where, err := imageQueryCondition(uid, params)
+		if err != nil {
+			return fmt.Errorf("invalid query parameters: %v", err)
+		}
+		where.add("uid = ?", uid)
+		where.add("id > ?", lastId)
+
+		batchQuery := fmt.Sprintf("%s ORDER BY id LIMIT %s", where.String(), where.bind(EXPORT_BATCH))
+		dbReturn, err := selectWhere(db, Image{}, IMAGE_TABLE, batchQuery, where.Args()...)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve metadata: %v", err)
+		}
+
+		images := []Image{}
+		for _, image := range dbReturn {
+			images = append(images, image.(Image))
+		}
+		if len(images) == 0 {
+			return nil
+		}
+
+		err = attachRelations(db, images)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve tags and mentions: %v", err)
+		}
+
+		err = emit(images)
+		if err != nil {
+			return err
+		}
+
+		if len(images) < EXPORT_BATCH {
+			return nil
+		}
+		lastId = images[len(images)-1].Id
+	}
+}
+
+// ImageTitles returns the titles of the user's images that equal base+ext or number it as base (n)+ext
+// the image with id exclude is ignored so an image doesn't conflict with itself
+func ImageTitles(ctx context.Context, uid int32, base string, ext string, exclude int32) ([]string, error) {
+	pool, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve titles due to connection error: %v", err)
+	}
+
+	return imageTitles(withContext(ctx, pool), uid, base, ext, exclude)
+}
+
+// claimTitle applies the title policy to the title of the user's image id within the transaction writing it.
+// Unless duplicates are allowed the transaction holds an advisory lock of the user until it ends, so writes
+// on any instance resolve the titles of a user one at a time while other users aren't held up
+func claimTitle(tx dbtx, uid int32, title string, id int32) (string, error) {
+	policy := getTitlePolicy()
+	if policy == TITLE_ALLOW {
+		return title, nil
+	}
+
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1::int, $2::int)", TITLE_LOCK_CLASS, uid)
+	if err != nil {
+		return "", fmt.Errorf("unable to lock titles: %v", err)
+	}
+
+	base, ext := splitTitle(title)
+	taken, err := imageTitles(tx, uid, base, ext, id)
+	if err != nil {
+		return "", err
+	}
+	return applyTitlePolicy(policy, title, taken)
+}
+
+// imageTitles returns the conflicting titles of ImageTitles through the database handle or transaction
+func imageTitles(db dbtx, uid int32, base string, ext string, exclude int32) ([]string, error) {
+	where := &whereBuilder{}
+	where.add("uid = ? AND id <> ?", uid, exclude)
+	where.add(`(title = ? OR title LIKE ? ESCAPE '\')`, base+ext, escapeLike(base)+" (%)"+escapeLike(ext))
+
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve titles: %v", err)
+	}
+
+	titles := []string{}
+	for _, row := range rows {
+		titles = append(titles, row.(Image).Title)
+	}
+
+	return titles, nil
+}
+
+// AddUserMeta inserts a row into the image_meta table and returns the assigned id
+func AddUserData(userData User) (int32, error) {
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, USER_TABLE, userData)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+	}
+
+	return id, nil
+}
+
+// RegisterUser inserts the user and their hashed password and queues the user.registered event
+// in a single transaction so a failure never leaves a partial account. Returns the assigned uid
+func RegisterUser(userData User, hashedPass string) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to register user due to connection error: %v", err)
+	}
+
+	err = withTx(db, func(tx *sql.Tx) error {
+		userData.Uid, err = insertObject(tx, USER_TABLE, userData)
+		if err != nil {
+			return fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+		}
+
+		_, err = insertObject(tx, PASS_TABLE, UserPassword{Uid: userData.Uid, HashedPass: hashedPass})
+		if err != nil {
+			return fmt.Errorf("unable to add user pass due to insertion error: %v", err)
+		}
+
+		return insertEvent(tx, EVENT_USER_REGISTERED, userData)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userData.Uid, nil
+}
+
+// GetUserData retrieves user data based on the provided email
+func GetUserData(email string) (User, error) {
+
+	db, err := getDB()
+	if err != nil {
+		return User{}, fmt.Errorf("unable to add user meta to db due to connection error: %v", err)
+	}
+
+	users, err := selectWhere(db, User{}, USER_TABLE, "email = $1", email)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+	}
+	// Failed to retrieve
+	if len(users) != 1 {
+		return User{}, fmt.Errorf("404 - Not found")
+	}
+
+	return users[0].(User), nil
+}
+
+// GetSharingPolicy retrieves the sharing policy, the environment defaults apply until a policy is set
+func GetSharingPolicy(ctx context.Context) (SharingPolicy, error) {
+	pool, err := getDB()
+	if err != nil {
+		return SharingPolicy{}, fmt.Errorf("unable to retrieve sharing policy due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(withContext(ctx, pool), SharingPolicy{}, SHARING_TABLE, "id = 1")
+	if err != nil {
+		return SharingPolicy{}, fmt.Errorf("unable to retrieve sharing policy: %v", err)
+	}
+	if len(rows) == 0 {
+		return defaultSharingPolicy(), nil
+	}
+
+	return rows[0].(SharingPolicy), nil
+}
+
+// SetSharingPolicy replaces the sharing policy
+func SetSharingPolicy(policy SharingPolicy) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to set sharing policy due to connection error: %v", err)
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %s (id, default_shareable, allow_public, watermark) VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET default_shareable = $1, allow_public = $2, watermark = $3`, SHARING_TABLE)
+	_, err = db.Exec(stmt, policy.DefaultShareable, policy.AllowPublic, policy.Watermark)
+	if err != nil {
+		return fmt.Errorf("unable to set sharing policy: %v", err)
+	}
+
+	return nil
+}
+
+// GetIngestPolicy retrieves the ingest policy, uploads follow the instance rules until a policy is set
+func GetIngestPolicy(ctx context.Context) (IngestPolicy, error) {
+	pool, err := getDB()
+	if err != nil {
+		return IngestPolicy{}, fmt.Errorf("unable to retrieve ingest policy due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(withContext(ctx, pool), IngestPolicy{}, INGEST_TABLE, "id = 1")
+	if err != nil {
+		return IngestPolicy{}, fmt.Errorf("unable to retrieve ingest policy: %v", err)
+	}
+	if len(rows) == 0 {
+		return IngestPolicy{Id: 1}, nil
+	}
+
+	return rows[0].(IngestPolicy), nil
+}
+
+// SetIngestPolicy replaces the ingest policy
+func SetIngestPolicy(policy IngestPolicy) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to set ingest policy due to connection error: %v", err)
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %s (id, types, strip_exif, require_scan) VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET types = $1, strip_exif = $2, require_scan = $3`, INGEST_TABLE)
+	_, err = db.Exec(stmt, policy.Types, policy.StripExif, policy.RequireScan)
+	if err != nil {
+		return fmt.Errorf("unable to set ingest policy: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserById retrieves user data based on the provided uid
+func GetUserById(uid int32) (User, error) {
+
+	db, err := getDB()
+	if err != nil {
+		return User{}, fmt.Errorf("unable to retrieve user meta due to connection error: %v", err)
+	}
+
+	users, err := selectWhere(db, User{}, USER_TABLE, "id = $1", uid)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to retrieve user meta: %v", err)
+	}
+	// Failed to retrieve
+	if len(users) != 1 {
+		return User{}, fmt.Errorf("404 - Not found")
+	}
+
+	return users[0].(User), nil
+}
+
+// UpdateUserMeta updates the corresponding row into the user_meta table according to the provided parameter
+func UpdateUserData(userData User) error {
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update user meta to db due to connection error: %v", err)
+	}
+
+	err = updateObject(db, USER_TABLE, userData)
+	if err != nil {
+		return fmt.Errorf("unable to update user meta: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteUserMeta deletes the corresponding row from the user_meta tables
+func DeleteUserData(userData User) error {
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete user meta to db due to connection error: %v", err)
+	}
+
+	password, _, err := GetHashedPass(userData.Email)
+	if err != nil {
+		return fmt.Errorf("failed to get hashed pass for deletion: %v", err)
+	}
+
+	err = deleteObject(db, USER_TABLE, userData)
+	if err != nil {
+		return fmt.Errorf("unable to delete user meta: %v", err)
+	}
+
+	err = deleteObject(db, PASS_TABLE, password)
+	if err != nil {
+		return fmt.Errorf("unable to delete user meta: %v", err)
+	}
+
+	return nil
+}
+
+// AddUserPass inserts a hashed password into the password table adn returns the assigned id
+func AddUserPass(pass UserPassword) (int32, error) {
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add user pass to db due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, PASS_TABLE, pass)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add user pass due to insertion error: %v", err)
+	}
+
+	return id, nil
+}
+
+// UpdateUserMeta updates the corresponding row into the user_meta table according to the provided parameter
+func UpdateUserPass(pass UserPassword) error {
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update user pass to db due to connection error: %v", err)
+	}
+
+	err = updateObject(db, PASS_TABLE, pass)
+	if err != nil {
+		return fmt.Errorf("unable to update user pass: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteUserMeta deletes the corresponding row from the user_meta tables
+func DeleteUserPass(pass UserPassword) error {
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete user pass to db due to connection error: %v", err)
+	}
+
+	err = deleteObject(db, PASS_TABLE, pass)
+	if err != nil {
+		return fmt.Errorf("unable to delete user pass: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserPass retrieves the hashed password of the user
+func GetUserPass(uid int32) (UserPassword, error) {
+	db, err := getDB()
+	if err != nil {
+		return UserPassword{}, fmt.Errorf("unable to retrieve user pass due to connection error: %v", err)
+	}
+
+	passRows, err := selectWhere(db, UserPassword{}, PASS_TABLE, "id = $1", uid)
+	if err != nil {
+		return UserPassword{}, fmt.Errorf("selection failed, unable to retrieve hashed pass: %v", err)
+	}
+	if len(passRows) != 1 {
+		return UserPassword{}, fmt.Errorf("cannot find hashed pass")
+	}
+
+	return passRows[0].(UserPassword), nil
+}
+
+// AddPasswordReset stores a reset token together with the event delivering it
+func AddPasswordReset(reset PasswordReset, event PasswordResetEvent) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add password reset due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := insertObject(tx, RESET_TABLE, reset)
+		if err != nil {
+			return fmt.Errorf("unable to add password reset: %v", err)
+		}
+		return insertEvent(tx, EVENT_PASSWORD_RESET, event)
+	})
+}
+
+// ResetPassword consumes the unexpired reset token with the hash and replaces the password of its user
+// every other reset token of the user is revoked, returns ErrInvalidResetToken if no token matches
+func ResetPassword(tokenHash string, hashedPass string) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to reset password due to connection error: %v", err)
+	}
+
+	var uid int32
+	err = withTx(db, func(tx *sql.Tx) error {
+		err := tx.QueryRow(fmt.Sprintf("DELETE FROM %s WHERE token_hash = $1 AND expires > $2 RETURNING uid", RESET_TABLE), tokenHash, time.Now().UTC()).Scan(&uid)
+		if err == sql.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return fmt.Errorf("unable to consume reset token: %v", err)
+		}
+
+		// Users registered through a login provider have no password until they set one
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (id, hashed_pass) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET hashed_pass = EXCLUDED.hashed_pass", PASS_TABLE), uid, hashedPass)
+		if err != nil {
+			return fmt.Errorf("unable to update user pass: %v", err)
+		}
+
+		_, err = deleteWhere(tx, RESET_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to revoke reset tokens: %v", err)
+		}
+		return nil
+	})
+	return uid, err
+}
+
+func GetHashedPass(email string) (UserPassword, User, error) {
+	db, err := getDB()
+	if err != nil {
+		return UserPassword{}, User{}, fmt.Errorf("unable to delete user pass to db due to connection error: %v", err)
+	}
+
+	userRows, err := selectWhere(db, User{}, USER_TABLE, "email = $1", email)
+	if err != nil {
+		return UserPassword{}, User{}, fmt.Errorf("selection failed, unable to retrieve hashed uid: %v", err)
+	}
+
+	if len(userRows) != 1 {
+		return UserPassword{}, User{}, fmt.Errorf("cannot find email")
+	}
+
+	user := userRows[0].(User)
+
+	passRows, err := selectWhere(db, UserPassword{}, PASS_TABLE, "id = $1", user.Uid)
+	if err != nil {
+		return UserPassword{}, User{}, fmt.Errorf("selection failed, unable to retrieve hashed uid: %v", err)
+	}
+
+	if len(passRows) != 1 {
+		return UserPassword{}, User{}, fmt.Errorf("cannot find hashed pass")
+	}
+
+	pass := passRows[0].(UserPassword)
+
+	return pass, user, nil
+}
+
+// UniqueEmail queries the user_table in order to determine if an email is unique
+func UniqueEmail(email string) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to database: %v", err)
+	}
+
+	count, err := countWhere(db, USER_TABLE, "email = $1", email)
+	if err != nil {
+		return false, fmt.Errorf("unable to query user table: %v", err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// AddOAuthClient inserts a registered client and returns the assigned id
+func AddOAuthClient(client OAuthClient) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add client due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, OAUTH_CLIENT_TABLE, client)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add client: %v", err)
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("client id %s already exists", client.ClientId)
+	}
+
+	return id, nil
+}
+
+// GetOAuthClient retrieves the client with the client id, reporting false if it doesn't exist
+func GetOAuthClient(clientId string) (OAuthClient, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return OAuthClient{}, false, fmt.Errorf("unable to retrieve client due to connection error: %v", err)
+	}
+
+	clients, err := selectWhere(db, OAuthClient{}, OAUTH_CLIENT_TABLE, "client_id = $1", clientId)
+	if err != nil {
+		return OAuthClient{}, false, fmt.Errorf("unable to retrieve client: %v", err)
+	}
+	if len(clients) == 0 {
+		return OAuthClient{}, false, nil
+	}
+
+	return clients[0].(OAuthClient), true, nil
+}
+
+// GetOAuthClients retrieves the clients registered by the user ordered by registration
+func GetOAuthClients(owner int32) ([]OAuthClient, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve clients due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, OAuthClient{}, OAUTH_CLIENT_TABLE, "owner = $1 ORDER BY id", owner)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve clients: %v", err)
+	}
+
+	clients := []OAuthClient{}
+	for _, row := range rows {
+		clients = append(clients, row.(OAuthClient))
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes the client registered by the owner along with every grant to it
+// returns false if the owner has no client with the id
+func DeleteOAuthClient(clientId string, owner int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete client due to connection error: %v", err)
+	}
+
+	deleted := false
+	err = withTx(db, func(tx *sql.Tx) error {
+		count, err := deleteWhere(tx, OAUTH_CLIENT_TABLE, "client_id = $1 AND owner = $2", clientId, owner)
+		if err != nil || count == 0 {
+			return err
+		}
+		deleted = true
+
+		_, err = deleteWhere(tx, OAUTH_GRANT_TABLE, "client_id = $1", clientId)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to delete client: %v", err)
+	}
+
+	return deleted, nil
+}
+
+// GrantOAuthClient stores the scopes the user granted the client replacing any previous grant
+func GrantOAuthClient(grant OAuthGrant) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to grant client due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := deleteWhere(tx, OAUTH_GRANT_TABLE, "uid = $1 AND client_id = $2", grant.Uid, grant.ClientId)
+		if err != nil {
+			return fmt.Errorf("unable to replace grant: %v", err)
+		}
+
+		_, err = insertObject(tx, OAUTH_GRANT_TABLE, grant)
+		if err != nil {
+			return fmt.Errorf("unable to grant client: %v", err)
+		}
+		return nil
+	})
+}
+
+// GetOAuthGrant retrieves the grant of the user to the client, reporting false if there is none
+func GetOAuthGrant(uid int32, clientId string) (OAuthGrant, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return OAuthGrant{}, false, fmt.Errorf("unable to retrieve grant due to connection error: %v", err)
+	}
+
+	grants, err := selectWhere(db, OAuthGrant{}, OAUTH_GRANT_TABLE, "uid = $1 AND client_id = $2", uid, clientId)
+	if err != nil {
+		return OAuthGrant{}, false, fmt.Errorf("unable to retrieve grant: %v", err)
+	}
+	if len(grants) == 0 {
+		return OAuthGrant{}, false, nil
+	}
+
+	return grants[0].(OAuthGrant), true, nil
+}
+
+// GetOAuthGrants retrieves the grants of the user with the names of their clients
+func GetOAuthGrants(uid int32) ([]OAuthGrantResp, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve grants due to connection error: %v", err)
+	}
+
+	stmt := fmt.Sprintf(`SELECT g.client_id, c.name, g.scope, g.created FROM %s g
+		JOIN %s c ON c.client_id = g.client_id WHERE g.uid = $1 ORDER BY g.created`, OAUTH_GRANT_TABLE, OAUTH_CLIENT_TABLE)
+	rows, err := db.Query(stmt, uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve grants: %v", err)
+	}
+	defer rows.Close()
+
+	grants := []OAuthGrantResp{}
+	for rows.Next() {
+		var grant OAuthGrantResp
+		var scope string
+		var created time.Time
+		err = rows.Scan(&grant.ClientId, &grant.ClientName, &scope, &created)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan grant: %v", err)
+		}
+		grant.Scopes = strings.Fields(scope)
+		grant.Created = Timestamp(created)
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// RevokeOAuthGrant deletes the grant of the user to the client, returns false if there was none
+func RevokeOAuthGrant(uid int32, clientId string) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to revoke grant due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, OAUTH_GRANT_TABLE, "uid = $1 AND client_id = $2", uid, clientId)
+	if err != nil {
+		return false, fmt.Errorf("unable to revoke grant: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// AddPurgeJob stores the purge job together with the event performing it and returns the assigned id
+func AddPurgeJob(job PurgeJob) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add purge due to connection error: %v", err)
+	}
+
+	var id int32
+	err = withTx(db, func(tx *sql.Tx) error {
+		id, err = insertObject(tx, USER_PURGE_TABLE, job)
+		if err != nil {
+			return fmt.Errorf("unable to add purge: %v", err)
+		}
+		return insertEvent(tx, EVENT_USER_PURGE, PurgeEvent{JobId: id})
+	})
+	return id, err
+}
+
+// GetPurgeJob retrieves the purge job with the id, reporting false if it doesn't exist
+func GetPurgeJob(id int32) (PurgeJob, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return PurgeJob{}, false, fmt.Errorf("unable to retrieve purge due to connection error: %v", err)
+	}
+
+	jobs, err := selectWhere(db, PurgeJob{}, USER_PURGE_TABLE, "id = $1", id)
+	if err != nil {
+		return PurgeJob{}, false, fmt.Errorf("unable to retrieve purge: %v", err)
+	}
+	if len(jobs) == 0 {
+		return PurgeJob{}, false, nil
+	}
+
+	return jobs[0].(PurgeJob), true, nil
+}
+
+// UpdatePurgeJob stores the status and report of the purge job
+func UpdatePurgeJob(job PurgeJob) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update purge due to connection error: %v", err)
+	}
+
+	err = updateObject(db, USER_PURGE_TABLE, job)
+	if err != nil {
+		return fmt.Errorf("unable to update purge: %v", err)
+	}
+
+	return nil
+}
+
+// UserImageBatch retrieves up to limit images of the user ordered by id
+func UserImageBatch(uid int32, limit int) ([]Image, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, fmt.Sprintf("uid = $1 ORDER BY id LIMIT %v", limit), uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images: %v", err)
+	}
+
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
+	}
+	return images, nil
+}
+
+// PurgeUserAccount removes the credentials, password resets, clients and grants of the user
+// then deletes the user or, when anonymize is set, replaces its name and email
+func PurgeUserAccount(uid int32, anonymize bool) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to purge account due to connection error: %v", err)
+	}
+	// Likes, ratings and mentions of the user are removed from images of other users too
+	defer purgeImageMeta()
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := deleteWhere(tx, RESET_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete password resets: %v", err)
+		}
+
+		clients := fmt.Sprintf("uid = $1 OR client_id IN (SELECT client_id FROM %s WHERE owner = $1)", OAUTH_CLIENT_TABLE)
+		_, err = deleteWhere(tx, OAUTH_GRANT_TABLE, clients, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete grants: %v", err)
+		}
+		_, err = deleteWhere(tx, OAUTH_CLIENT_TABLE, "owner = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete clients: %v", err)
+		}
+
+		albums := fmt.Sprintf("album_id IN (SELECT id FROM %s WHERE uid = $1)", ALBUM_TABLE)
+		_, err = deleteWhere(tx, ALBUM_IMAGE_TABLE, albums, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete album images: %v", err)
+		}
+		_, err = deleteWhere(tx, GUEST_LINK_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete guest links: %v", err)
+		}
+		_, err = deleteWhere(tx, ALBUM_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete albums: %v", err)
+		}
+
+		shares := fmt.Sprintf("uid = $1 OR image_id IN (SELECT id FROM %s WHERE uid = $1)", IMAGE_TABLE)
+		_, err = deleteWhere(tx, IMAGE_SHARE_TABLE, shares, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete image shares: %v", err)
+		}
+		_, err = deleteWhere(tx, USER_BLOCK_TABLE, "uid = $1 OR blocked_uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete user blocks: %v", err)
+		}
+		_, err = deleteWhere(tx, LIKE_TABLE, shares, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete likes: %v", err)
+		}
+		_, err = deleteWhere(tx, COMMENT_TABLE, shares, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete comments: %v", err)
+		}
+		_, err = deleteWhere(tx, RATING_TABLE, shares, uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete ratings: %v", err)
+		}
+
+		_, err = deleteWhere(tx, AUDIT_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete audit log: %v", err)
+		}
+
+		_, err = deleteWhere(tx, MENTION_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete mentions: %v", err)
+		}
+		_, err = deleteWhere(tx, NOTIFICATION_TABLE, "uid = $1 OR actor_uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete notifications: %v", err)
+		}
+
+		// Reports keep counting towards their cases without identifying the reporter
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET reporter = 0, reason = '' WHERE reporter = $1", REPORT_TABLE), uid)
+		if err != nil {
+			return fmt.Errorf("unable to anonymize reports: %v", err)
+		}
+
+		_, err = deleteWhere(tx, PASS_TABLE, "id = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete user pass: %v", err)
+		}
+		_, err = deleteWhere(tx, IDENTITY_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete identities: %v", err)
+		}
+		_, err = deleteWhere(tx, API_KEY_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete api keys: %v", err)
+		}
+		_, err = deleteWhere(tx, TOTP_TABLE, "id = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete totp: %v", err)
+		}
+		_, err = deleteWhere(tx, RECOVERY_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete recovery codes: %v", err)
+		}
+		_, err = deleteWhere(tx, WEBHOOK_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete webhooks: %v", err)
+		}
+
+		if anonymize {
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET firstname = '', lastname = '', username = '', email = $1 WHERE id = $2", USER_TABLE), anonymizedEmail(uid), uid)
+		} else {
+			_, err = deleteWhere(tx, USER_TABLE, "id = $1", uid)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to purge user: %v", err)
+		}
+		return nil
+	})
+}
+
+// AddAlbum inserts an album and returns the assigned id
+func AddAlbum(album Album) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add album due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, ALBUM_TABLE, album)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add album: %v", err)
+	}
+
+	return id, nil
+}
+
+// GetAlbum retrieves the album with the id, reporting false if it doesn't exist
+func GetAlbum(id int32) (Album, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return Album{}, false, fmt.Errorf("unable to retrieve album due to connection error: %v", err)
+	}
+
+	albums, err := selectWhere(db, Album{}, ALBUM_TABLE, "id = $1", id)
+	if err != nil {
+		return Album{}, false, fmt.Errorf("unable to retrieve album: %v", err)
+	}
+	if len(albums) == 0 {
+		return Album{}, false, nil
+	}
+
+	return albums[0].(Album), true, nil
+}
+
+// UserAlbums retrieves the albums of the user in order of creation with the ids of the images in each album in order
+func UserAlbums(uid int32) ([]Album, map[int32][]int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve albums due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Album{}, ALBUM_TABLE, "uid = $1 ORDER BY id", uid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve albums: %v", err)
+	}
+	albums := []Album{}
+	for _, row := range rows {
+		albums = append(albums, row.(Album))
+	}
+
+	orders := map[int32][]int32{}
+	orderRows, err := db.Query(fmt.Sprintf("SELECT album_id, image_id FROM %s WHERE album_id IN (SELECT id FROM %s WHERE uid = $1) AND image_id IN (SELECT id FROM %s WHERE %s) ORDER BY album_id, position, id",
+		ALBUM_IMAGE_TABLE, ALBUM_TABLE, IMAGE_TABLE, NOT_TRASHED), uid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve album images: %v", err)
+	}
+	defer orderRows.Close()
+	for orderRows.Next() {
+		var albumId, imageId int32
+		err = orderRows.Scan(&albumId, &imageId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to retrieve album images: %v", err)
+		}
+		orders[albumId] = append(orders[albumId], imageId)
+	}
+
+	return albums, orders, orderRows.Err()
+}
+
+// UpdateAlbum replaces the stored album with the provided album
+func UpdateAlbum(album Album) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update album due to connection error: %v", err)
+	}
+
+	err = updateObject(db, ALBUM_TABLE, album)
+	if err != nil {
+		return fmt.Errorf("unable to update album: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteAlbum deletes the album, the images it contained are kept
+func DeleteAlbum(id int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete album due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := deleteWhere(tx, ALBUM_IMAGE_TABLE, "album_id = $1", id)
+		if err != nil {
+			return fmt.Errorf("unable to delete album images: %v", err)
+		}
+		_, err = deleteWhere(tx, GUEST_LINK_TABLE, "album_id = $1", id)
+		if err != nil {
+			return fmt.Errorf("unable to delete guest links: %v", err)
+		}
+		_, err = deleteWhere(tx, ALBUM_TABLE, "id = $1", id)
+		if err != nil {
+			return fmt.Errorf("unable to delete album: %v", err)
+		}
+		return nil
+	})
+}
+
+// AlbumImages retrieves the images of the album with their tags in album order
+func AlbumImages(albumId int32) ([]Image, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve album images due to connection error: %v", err)
+	}
+
+	cond := fmt.Sprintf("id IN (SELECT image_id FROM %[1]s WHERE album_id = $1) AND %[3]s ORDER BY (SELECT position FROM %[1]s WHERE album_id = $1 AND image_id = %[2]s.id), id", ALBUM_IMAGE_TABLE, IMAGE_TABLE, NOT_TRASHED)
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, cond, albumId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve album images: %v", err)
+	}
+
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
+	}
+
+	err = attachRelations(db, images)
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// AddAlbumImages inserts the images of the album owner into the album at position, see albumOrder.
+// Positions of every image are rewritten so the album is locked while its order changes.
+// Returns ErrAlbumImageNotFound if an image isn't owned by the album owner and ErrAlbumFull if the
+// album would exceed ALBUM_MAX_IMAGES
+func AddAlbumImages(album Album, imageIds []int32, position int) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add album images due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		var locked int32
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = $1 FOR UPDATE", ALBUM_TABLE), album.Id).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("unable to lock album: %v", err)
+		}
+
+		where := &whereBuilder{}
+		ids := []interface{}{}
+		for _, id := range imageIds {
+			ids = append(ids, id)
+		}
+		where.add("uid = ?", album.Uid)
+		where.add(NOT_TRASHED)
+		where.add(fmt.Sprintf("id IN (%s)", where.placeholders(ids)))
+		owned, err := countWhere(tx, IMAGE_TABLE, where.String(), where.Args()...)
+		if err != nil {
+			return fmt.Errorf("unable to verify image owner: %v", err)
+		}
+		if owned != int64(len(imageIds)) {
+			return ErrAlbumImageNotFound
+		}
+
+		current, err := albumImageIds(tx, album.Id, "")
+		if err != nil {
+			return err
+		}
+
+		order := albumOrder(current, imageIds, position)
+		if len(order) > ALBUM_MAX_IMAGES {
+			return ErrAlbumFull
+		}
+
+		return writeAlbumOrder(tx, album.Id, order)
+	})
+}
+
+// ReorderAlbumImages replaces the order of the album with imageIds, which must list every image of the
+// album that isn't in the trash exactly once or ErrAlbumOrderMismatch is returned. Images in the trash
+// follow the listed images so they keep their place among each other if they are restored
+func ReorderAlbumImages(album Album, imageIds []int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to reorder album images due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		var locked int32
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = $1 FOR UPDATE", ALBUM_TABLE), album.Id).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("unable to lock album: %v", err)
+		}
+
+		visible, err := albumImageIds(tx, album.Id, NOT_TRASHED)
+		if err != nil {
+			return err
+		}
+		if len(visible) != len(imageIds) {
+			return ErrAlbumOrderMismatch
+		}
+		for _, id := range imageIds {
+			if !containsImageId(visible, id) {
+				return ErrAlbumOrderMismatch
+			}
+		}
+
+		current, err := albumImageIds(tx, album.Id, "")
+		if err != nil {
+			return err
+		}
+
+		return writeAlbumOrder(tx, album.Id, albumOrder(current, imageIds, 0))
+	})
+}
+
+// albumImageIds returns the ids of the images of the album in order, restricted to the images
+// matching the image condition when it isn't empty
+func albumImageIds(db dbtx, albumId int32, imageCond string) ([]int32, error) {
+	cond := "album_id = $1"
+	if len(imageCond) > 0 {
+		cond += fmt.Sprintf(" AND image_id IN (SELECT id FROM %s WHERE %s)", IMAGE_TABLE, imageCond)
+	}
+	rows, err := selectWhere(db, AlbumImage{}, ALBUM_IMAGE_TABLE, cond+" ORDER BY position, id", albumId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve album images: %v", err)
+	}
+
+	ids := []int32{}
+	for _, row := range rows {
+		ids = append(ids, row.(AlbumImage).ImageId)
+	}
+	return ids, nil
+}
+
+// writeAlbumOrder rewrites the positions of the images of the album to follow order
+func writeAlbumOrder(tx *sql.Tx, albumId int32, order []int32) error {
+	_, err := deleteWhere(tx, ALBUM_IMAGE_TABLE, "album_id = $1", albumId)
+	if err != nil {
+		return fmt.Errorf("unable to reorder album images: %v", err)
+	}
+	for i, id := range order {
+		_, err = insertObject(tx, ALBUM_IMAGE_TABLE, AlbumImage{AlbumId: albumId, ImageId: id, Position: int32(i)})
+		if err != nil {
+			return fmt.Errorf("unable to add album image: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET updated = $1 WHERE id = $2", ALBUM_TABLE), time.Now().UTC(), albumId)
+	if err != nil {
+		return fmt.Errorf("unable to update album: %v", err)
+	}
+	return nil
+}
+
+// SetAlbumCover chooses the image of the album as its cover, an id of 0 resets the cover to the first image.
+// Reports false if the image isn't in the album or is in the trash
+func SetAlbumCover(albumId int32, imageId int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to set album cover due to connection error: %v", err)
+	}
+
+	if imageId != 0 {
+		count, err := countWhere(db, ALBUM_IMAGE_TABLE, fmt.Sprintf("album_id = $1 AND image_id = $2 AND image_id IN (SELECT id FROM %s WHERE %s)", IMAGE_TABLE, NOT_TRASHED), albumId, imageId)
+		if err != nil {
+			return false, fmt.Errorf("unable to verify album image: %v", err)
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET cover_id = $1, updated = $2 WHERE id = $3", ALBUM_TABLE), imageId, time.Now().UTC(), albumId)
+	if err != nil {
+		return false, fmt.Errorf("unable to set album cover: %v", err)
+	}
+	return true, nil
+}
+
+// RemoveAlbumImage removes the image from the album, reporting false if it wasn't in the album
+func RemoveAlbumImage(albumId int32, imageId int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to remove album image due to connection error: %v", err)
+	}
+
+	var count int64
+	err = withTx(db, func(tx *sql.Tx) error {
+		count, err = deleteWhere(tx, ALBUM_IMAGE_TABLE, "album_id = $1 AND image_id = $2", albumId, imageId)
+		if err != nil {
+			return fmt.Errorf("unable to remove album image: %v", err)
+		}
+
+		// A removed cover isn't the cover if the image is added again
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET cover_id = 0 WHERE id = $1 AND cover_id = $2", ALBUM_TABLE), albumId, imageId)
+		if err != nil {
+			return fmt.Errorf("unable to reset album cover: %v", err)
+		}
+		return nil
+	})
+
+	return count > 0, err
+}
+
+// AlbumImageCount returns the number of images in the album
+func AlbumImageCount(albumId int32) (int64, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count album images due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, ALBUM_IMAGE_TABLE, "album_id = $1", albumId)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count album images: %v", err)
+	}
+	return count, nil
+}
+
+// AddGuestLink inserts a guest link and returns the assigned id
+func AddGuestLink(link GuestLink) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add guest link due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, GUEST_LINK_TABLE, link)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add guest link: %v", err)
+	}
+	return id, nil
+}
+
+// AlbumGuestLinks retrieves the guest links of the album most recent first
+func AlbumGuestLinks(albumId int32) ([]GuestLink, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve guest links due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, GuestLink{}, GUEST_LINK_TABLE, "album_id = $1 ORDER BY id DESC", albumId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve guest links: %v", err)
+	}
+
+	links := []GuestLink{}
+	for _, row := range rows {
+		links = append(links, row.(GuestLink))
+	}
+	return links, nil
+}
+
+// GuestLinkByToken retrieves the guest link with the token hash, reporting false if it doesn't exist
+func GuestLinkByToken(tokenHash string) (GuestLink, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return GuestLink{}, false, fmt.Errorf("unable to retrieve guest link due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, GuestLink{}, GUEST_LINK_TABLE, "token_hash = $1", tokenHash)
+	if err != nil {
+		return GuestLink{}, false, fmt.Errorf("unable to retrieve guest link: %v", err)
+	}
+	if len(rows) == 0 {
+		return GuestLink{}, false, nil
+	}
+	return rows[0].(GuestLink), true, nil
+}
+
+// DeleteGuestLink deletes the guest link of the album, reporting false if the album has no such link
+func DeleteGuestLink(albumId int32, id int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete guest link due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, GUEST_LINK_TABLE, "album_id = $1 AND id = $2", albumId, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete guest link: %v", err)
+	}
+	return count > 0, nil
+}
+
+// ReserveGuestUpload counts an upload through the link and returns the updated link
+// Returns ErrGuestLinkExhausted if the link expired or its uploads are used up
+func ReserveGuestUpload(id int32) (GuestLink, error) {
+	db, err := getDB()
+	if err != nil {
+		return GuestLink{}, fmt.Errorf("unable to reserve guest upload due to connection error: %v", err)
+	}
+
+	var link GuestLink
+	err = withTx(db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(fmt.Sprintf("UPDATE %s SET uploads = uploads + 1 WHERE id = $1 AND uploads < max_uploads AND expires > $2", GUEST_LINK_TABLE), id, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("unable to reserve guest upload: %v", err)
+		}
+		if count, err := res.RowsAffected(); err != nil || count == 0 {
+			return ErrGuestLinkExhausted
+		}
+
+		rows, err := selectWhere(tx, GuestLink{}, GUEST_LINK_TABLE, "id = $1", id)
+		if err != nil || len(rows) == 0 {
+			return fmt.Errorf("unable to retrieve guest link: %v", err)
+		}
+		link = rows[0].(GuestLink)
+		return nil
+	})
+	return link, err
+}
+
+// ReleaseGuestUpload returns an upload reserved through the link that failed
+func ReleaseGuestUpload(id int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to release guest upload due to connection error: %v", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET uploads = uploads - 1 WHERE id = $1 AND uploads > 0", GUEST_LINK_TABLE), id)
+	if err != nil {
+		return fmt.Errorf("unable to release guest upload: %v", err)
+	}
+	return nil
+}
+
+// InShareableAlbum reports whether the image belongs to an album marked shareable
+func InShareableAlbum(ctx context.Context, imageId int32) (bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve albums due to connection error: %v", err)
+	}
+
+	count, err := countWhere(withContext(ctx, pool), ALBUM_IMAGE_TABLE, fmt.Sprintf("image_id = $1 AND album_id IN (SELECT id FROM %s WHERE shareable)", ALBUM_TABLE), imageId)
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve albums: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetUserByEmail retrieves the user with the email, reporting whether one exists
+func GetUserByEmail(email string) (User, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return User{}, false, fmt.Errorf("unable to retrieve user due to connection error: %v", err)
+	}
+
+	users, err := selectWhere(db, User{}, USER_TABLE, "email = $1", email)
+	if err != nil {
+		return User{}, false, fmt.Errorf("unable to retrieve user: %v", err)
+	}
+	if len(users) == 0 {
+		return User{}, false, nil
+	}
+
+	return users[0].(User), true, nil
+}
+
+// RegisterIdentityUser adds a user without a password linked to the identity together with the registration event
+func RegisterIdentityUser(userData User, identity UserIdentity) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to register user due to connection error: %v", err)
+	}
+
+	err = withTx(db, func(tx *sql.Tx) error {
+		userData.Uid, err = insertObject(tx, USER_TABLE, userData)
+		if err != nil {
+			return fmt.Errorf("unable to add user meta due to insertion error: %v", err)
+		}
+
+		identity.Uid = userData.Uid
+		_, err = insertObject(tx, IDENTITY_TABLE, identity)
+		if err != nil {
+			return fmt.Errorf("unable to add identity due to insertion error: %v", err)
+		}
+
+		return insertEvent(tx, EVENT_USER_REGISTERED, userData)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userData.Uid, nil
+}
+
+// AddUserIdentity links the identity to its user
+func AddUserIdentity(identity UserIdentity) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add identity due to connection error: %v", err)
+	}
+
+	_, err = insertObject(db, IDENTITY_TABLE, identity)
+	if err != nil {
+		return fmt.Errorf("unable to add identity: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserIdentity retrieves the identity of the provider account, reporting false if it isn't linked
+func GetUserIdentity(provider string, subject string) (UserIdentity, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return UserIdentity{}, false, fmt.Errorf("unable to retrieve identity due to connection error: %v", err)
+	}
+
+	identities, err := selectWhere(db, UserIdentity{}, IDENTITY_TABLE, "provider = $1 AND subject = $2", provider, subject)
+	if err != nil {
+		return UserIdentity{}, false, fmt.Errorf("unable to retrieve identity: %v", err)
+	}
+	if len(identities) == 0 {
+		return UserIdentity{}, false, nil
+	}
+
+	return identities[0].(UserIdentity), true, nil
+}
+
+// AddApiKey inserts the api key and returns the assigned id
+func AddApiKey(key ApiKey) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add api key due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, API_KEY_TABLE, key)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add api key: %v", err)
+	}
+	return id, nil
+}
+
+// CountApiKeys returns the number of api keys of the user
+func CountApiKeys(uid int32) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count api keys due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, API_KEY_TABLE, "uid = $1", uid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count api keys: %v", err)
+	}
+	return int(count), nil
+}
+
+// UserApiKeys retrieves the api keys of the user in order of creation
+func UserApiKeys(uid int32) ([]ApiKey, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve api keys due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ApiKey{}, API_KEY_TABLE, "uid = $1 ORDER BY id", uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve api keys: %v", err)
+	}
+
+	keys := []ApiKey{}
+	for _, row := range rows {
+		keys = append(keys, row.(ApiKey))
+	}
+	return keys, nil
+}
+
+// GetApiKeyByHash retrieves the api key with the hash, reporting false if it doesn't exist
+func GetApiKeyByHash(keyHash string) (ApiKey, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return ApiKey{}, false, fmt.Errorf("unable to retrieve api key due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ApiKey{}, API_KEY_TABLE, "key_hash = $1", keyHash)
+	if err != nil {
+		return ApiKey{}, false, fmt.Errorf("unable to retrieve api key: %v", err)
+	}
+	if len(rows) == 0 {
+		return ApiKey{}, false, nil
+	}
+	return rows[0].(ApiKey), true, nil
+}
+
+// TouchApiKey records the last use of the api key
+func TouchApiKey(id int32, used time.Time) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update api key due to connection error: %v", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET last_used = $1 WHERE id = $2", API_KEY_TABLE), used, id)
+	if err != nil {
+		return fmt.Errorf("unable to update api key: %v", err)
+	}
+	return nil
+}
+
+// DeleteApiKey deletes the api key of the user, reporting false if the user has no such key
+func DeleteApiKey(uid int32, id int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete api key due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, API_KEY_TABLE, "uid = $1 AND id = $2", uid, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete api key: %v", err)
+	}
+	return count > 0, nil
+}
+
+// AddWebhook inserts the webhook and returns the assigned id
+func AddWebhook(hook Webhook) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add webhook due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, WEBHOOK_TABLE, hook)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add webhook: %v", err)
+	}
+	return id, nil
+}
+
+// CountWebhooks returns the number of webhooks of the user
+func CountWebhooks(uid int32) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count webhooks due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, WEBHOOK_TABLE, "uid = $1", uid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count webhooks: %v", err)
+	}
+	return int(count), nil
+}
+
+// UserWebhooks retrieves the webhooks of the user in order of creation
+func UserWebhooks(uid int32) ([]Webhook, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve webhooks due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Webhook{}, WEBHOOK_TABLE, "uid = $1 ORDER BY id", uid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve webhooks: %v", err)
+	}
+
+	hooks := []Webhook{}
+	for _, row := range rows {
+		hooks = append(hooks, row.(Webhook))
+	}
+	return hooks, nil
+}
+
+// GetWebhook retrieves the webhook with the id, reporting false if it doesn't exist
+func GetWebhook(id int32) (Webhook, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return Webhook{}, false, fmt.Errorf("unable to retrieve webhook due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Webhook{}, WEBHOOK_TABLE, "id = $1", id)
+	if err != nil {
+		return Webhook{}, false, fmt.Errorf("unable to retrieve webhook: %v", err)
+	}
+	if len(rows) == 0 {
+		return Webhook{}, false, nil
+	}
+	return rows[0].(Webhook), true, nil
+}
+
+// RecordWebhookAttempt records the status and moment of the last delivery attempt to the webhook
+func RecordWebhookAttempt(id int32, status int32, attempted time.Time) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update webhook due to connection error: %v", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET last_status = $1, last_attempt = $2 WHERE id = $3", WEBHOOK_TABLE), status, attempted, id)
+	if err != nil {
+		return fmt.Errorf("unable to update webhook: %v", err)
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook of the user, reporting false if the user has no such webhook
+func DeleteWebhook(uid int32, id int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete webhook due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, WEBHOOK_TABLE, "uid = $1 AND id = $2", uid, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete webhook: %v", err)
+	}
+	return count > 0, nil
+}
+
+// AddWebhookEvent queues the event for delivery to its webhook by the outbox
+func AddWebhookEvent(event WebhookEvent) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to queue webhook event due to connection error: %v", err)
+	}
+
+	return insertEvent(db, EVENT_WEBHOOK, event)
+}
+
+// GetUserTotp retrieves the second factor of the user, reporting false if the user hasn't enrolled
+func GetUserTotp(uid int32) (UserTotp, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return UserTotp{}, false, fmt.Errorf("unable to retrieve totp due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, UserTotp{}, TOTP_TABLE, "id = $1", uid)
+	if err != nil {
+		return UserTotp{}, false, fmt.Errorf("unable to retrieve totp: %v", err)
+	}
+	if len(rows) == 0 {
+		return UserTotp{}, false, nil
+	}
+	return rows[0].(UserTotp), true, nil
+}
+
+// SaveUserTotp stores a pending enrollment of the user, replacing a previous pending enrollment
+func SaveUserTotp(totp UserTotp) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to save totp due to connection error: %v", err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (id, secret, enabled, last_step, created) VALUES ($1, $2, false, 0, $3) ON CONFLICT (id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created = EXCLUDED.created WHERE %s.enabled = false", TOTP_TABLE, TOTP_TABLE), totp.Uid, totp.Secret, totp.Created)
+	if err != nil {
+		return fmt.Errorf("unable to save totp: %v", err)
+	}
+	return nil
+}
+
+// EnableUserTotp enables the pending enrollment of the user after its code of the period was accepted
+// and replaces the recovery codes of the user with the hashes
+func EnableUserTotp(uid int32, step int64, codeHashes []string) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to enable totp due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET enabled = true, last_step = $1 WHERE id = $2", TOTP_TABLE), step, uid)
+		if err != nil {
+			return fmt.Errorf("unable to enable totp: %v", err)
+		}
+		_, err = deleteWhere(tx, RECOVERY_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete recovery codes: %v", err)
+		}
+		for _, hash := range codeHashes {
+			_, err = insertObject(tx, RECOVERY_TABLE, RecoveryCode{Uid: uid, CodeHash: hash})
+			if err != nil {
+				return fmt.Errorf("unable to add recovery code: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseTotpStep records the period of an accepted code of the user, reporting false if a code of the
+// period or a later one was already accepted
+func UseTotpStep(uid int32, step int64) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to update totp due to connection error: %v", err)
+	}
+
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET last_step = $1 WHERE id = $2 AND enabled AND last_step < $1", TOTP_TABLE), step, uid)
+	if err != nil {
+		return false, fmt.Errorf("unable to update totp: %v", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to update totp: %v", err)
+	}
+	return count > 0, nil
+}
+
+// UseRecoveryCode deletes the recovery code of the user with the hash, reporting false if there is none
+func UseRecoveryCode(uid int32, codeHash string) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to use recovery code due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, RECOVERY_TABLE, "uid = $1 AND code_hash = $2", uid, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("unable to use recovery code: %v", err)
+	}
+	return count > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func CountRecoveryCodes(uid int32) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count recovery codes due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, RECOVERY_TABLE, "uid = $1", uid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count recovery codes: %v", err)
+	}
+	return int(count), nil
+}
+
+// DeleteUserTotp removes the second factor of the user and its recovery codes
+func DeleteUserTotp(uid int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete totp due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := deleteWhere(tx, TOTP_TABLE, "id = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete totp: %v", err)
+		}
+		_, err = deleteWhere(tx, RECOVERY_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete recovery codes: %v", err)
+		}
+		return nil
+	})
+}
+
+// AddImageShare grants the user view access to the image, returning the existing grant if there is one
+// and whether the grant was created. The image row is locked so concurrent grants respect IMAGE_SHARE_MAX
+func AddImageShare(imageId int32, uid int32) (ImageShare, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return ImageShare{}, false, fmt.Errorf("unable to add image share due to connection error: %v", err)
+	}
+
+	var share ImageShare
+	created := false
+	err = withTx(db, func(tx *sql.Tx) error {
+		var locked int32
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = $1 FOR UPDATE", IMAGE_TABLE), imageId).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("unable to lock image: %v", err)
+		}
+
+		rows, err := selectWhere(tx, ImageShare{}, IMAGE_SHARE_TABLE, "image_id = $1 AND uid = $2", imageId, uid)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve image share: %v", err)
+		}
+		if len(rows) > 0 {
+			share = rows[0].(ImageShare)
+			return nil
+		}
+
+		count, err := countWhere(tx, IMAGE_SHARE_TABLE, "image_id = $1", imageId)
+		if err != nil {
+			return fmt.Errorf("unable to count image shares: %v", err)
+		}
+		if count >= IMAGE_SHARE_MAX {
+			return ErrTooManyShares
+		}
+
+		share = ImageShare{ImageId: imageId, Uid: uid, Created: time.Now().UTC()}
+		share.Id, err = insertObject(tx, IMAGE_SHARE_TABLE, share)
+		if err != nil {
+			return fmt.Errorf("unable to add image share: %v", err)
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return ImageShare{}, false, err
+	}
+
+	return share, created, nil
+}
+
+// ImageShares returns the grants to the image in the order they were made and the granted users by uid
+func ImageShares(imageId int32) ([]ImageShare, map[int32]User, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve image shares due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ImageShare{}, IMAGE_SHARE_TABLE, "image_id = $1 ORDER BY id", imageId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve image shares: %v", err)
+	}
+	shares := []ImageShare{}
+	for _, row := range rows {
+		shares = append(shares, row.(ImageShare))
+	}
+
+	users := map[int32]User{}
+	userRows, err := selectWhere(db, User{}, USER_TABLE, fmt.Sprintf("id IN (SELECT uid FROM %s WHERE image_id = $1)", IMAGE_SHARE_TABLE), imageId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve shared users: %v", err)
+	}
+	for _, row := range userRows {
+		user := row.(User)
+		users[user.Uid] = user
+	}
+
+	return shares, users, nil
+}
+
+// HasImageShare reports whether the user was granted view access to the image
+func HasImageShare(ctx context.Context, imageId int32, uid int32) (bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve image share due to connection error: %v", err)
+	}
+
+	count, err := countWhere(withContext(ctx, pool), IMAGE_SHARE_TABLE, "image_id = $1 AND uid = $2", imageId, uid)
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve image share: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteImageShare revokes the grant of the user to the image, reporting whether one existed
+func DeleteImageShare(imageId int32, uid int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete image share due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, IMAGE_SHARE_TABLE, "image_id = $1 AND uid = $2", imageId, uid)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete image share: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// AddUserBlock blocks the account for the user, returning the existing block if there is one
+// and whether the block was created
+func AddUserBlock(uid int32, blockedUid int32) (UserBlock, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return UserBlock{}, false, fmt.Errorf("unable to add user block due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, UserBlock{}, USER_BLOCK_TABLE, "uid = $1 AND blocked_uid = $2", uid, blockedUid)
+	if err != nil {
+		return UserBlock{}, false, fmt.Errorf("unable to retrieve user block: %v", err)
+	}
+	if len(rows) > 0 {
+		return rows[0].(UserBlock), false, nil
+	}
+
+	block := UserBlock{Uid: uid, BlockedUid: blockedUid, Created: time.Now().UTC()}
+	block.Id, err = insertObject(db, USER_BLOCK_TABLE, block)
+	if err != nil {
+		return UserBlock{}, false, fmt.Errorf("unable to add user block: %v", err)
+	}
+
+	return block, true, nil
+}
+
+// UserBlocks returns the blocks of the user in the order they were made and the blocked users by uid
+func UserBlocks(uid int32) ([]UserBlock, map[int32]User, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve user blocks due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, UserBlock{}, USER_BLOCK_TABLE, "uid = $1 ORDER BY id", uid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve user blocks: %v", err)
+	}
+	blocks := []UserBlock{}
+	for _, row := range rows {
+		blocks = append(blocks, row.(UserBlock))
+	}
+
+	users := map[int32]User{}
+	userRows, err := selectWhere(db, User{}, USER_TABLE, fmt.Sprintf("id IN (SELECT blocked_uid FROM %s WHERE uid = $1)", USER_BLOCK_TABLE), uid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve blocked users: %v", err)
+	}
+	for _, row := range userRows {
+		user := row.(User)
+		users[user.Uid] = user
+	}
+
+	return blocks, users, nil
+}
+
+// IsBlocked reports whether the user blocked the account
+func IsBlocked(ctx context.Context, uid int32, blockedUid int32) (bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve user block due to connection error: %v", err)
+	}
+
+	count, err := countWhere(withContext(ctx, pool), USER_BLOCK_TABLE, "uid = $1 AND blocked_uid = $2", uid, blockedUid)
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve user block: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUserBlock removes the block of the account by the user, reporting whether one existed
+func DeleteUserBlock(uid int32, blockedUid int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete user block due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, USER_BLOCK_TABLE, "uid = $1 AND blocked_uid = $2", uid, blockedUid)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete user block: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetModerationPolicy retrieves the moderation policy, the environment defaults apply until a policy is set
+func GetModerationPolicy() (ModerationPolicy, error) {
+	db, err := getDB()
+	if err != nil {
+		return ModerationPolicy{}, fmt.Errorf("unable to retrieve moderation policy due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ModerationPolicy{}, MODERATION_POLICY_TABLE, "id = 1")
+	if err != nil {
+		return ModerationPolicy{}, fmt.Errorf("unable to retrieve moderation policy: %v", err)
+	}
+	if len(rows) == 0 {
+		return defaultModerationPolicy(), nil
+	}
+
+	return rows[0].(ModerationPolicy), nil
+}
+
+// SetModerationPolicy replaces the moderation policy
+func SetModerationPolicy(policy ModerationPolicy) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to set moderation policy due to connection error: %v", err)
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %s (id, copyright, nsfw, spam) VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET copyright = $1, nsfw = $2, spam = $3`, MODERATION_POLICY_TABLE)
+	_, err = db.Exec(stmt, policy.Copyright, policy.Nsfw, policy.Spam)
+	if err != nil {
+		return fmt.Errorf("unable to set moderation policy: %v", err)
+	}
+
+	return nil
+}
+
+// AddImageReport records the report in the active case of the image and category, opening one if there is none.
+// Once the case collects threshold reports, unless it was appealed, the image is unshared and EVENT_IMAGE_UNSHARED
+// is emitted in the same transaction. The image row is locked so concurrent reports are counted once each.
+// ErrAlreadyReported is returned if the reporter already reported the image in the category
+func AddImageReport(image Image, reporter int32, report ReportReq, threshold int32) (ModerationCase, error) {
+	db, err := getDB()
+	if err != nil {
+		return ModerationCase{}, fmt.Errorf("unable to add report due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(image.Id)
+
+	var modCase ModerationCase
+	err = withTx(db, func(tx *sql.Tx) error {
+		var locked int32
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = $1 FOR UPDATE", IMAGE_TABLE), image.Id).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("unable to lock image: %v", err)
+		}
+
+		// Resolved cases stay closed, later reports open a new case
+		now := time.Now().UTC()
+		rows, err := selectWhere(tx, ModerationCase{}, MODERATION_CASE_TABLE, "image_id = $1 AND category = $2 AND status IN ($3, $4) ORDER BY id DESC LIMIT 1",
+			image.Id, report.Category, CASE_OPEN, CASE_UNSHARED)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve moderation case: %v", err)
+		}
+		if len(rows) > 0 {
+			modCase = rows[0].(ModerationCase)
+		} else {
+			modCase = ModerationCase{ImageId: image.Id, Uid: image.Uid, Category: report.Category, Status: CASE_OPEN, Created: now, Updated: now}
+			modCase.Id, err = insertObject(tx, MODERATION_CASE_TABLE, modCase)
+			if err != nil {
+				return fmt.Errorf("unable to add moderation case: %v", err)
+			}
+		}
+
+		count, err := countWhere(tx, REPORT_TABLE, "case_id = $1 AND reporter = $2", modCase.Id, reporter)
+		if err != nil {
+			return fmt.Errorf("unable to count reports: %v", err)
+		}
+		if count > 0 {
+			return ErrAlreadyReported
+		}
+
+		_, err = insertObject(tx, REPORT_TABLE, ImageReport{CaseId: modCase.Id, ImageId: image.Id, Reporter: reporter, Category: report.Category, Reason: report.Reason, Created: now})
+		if err != nil {
+			return fmt.Errorf("unable to add report: %v", err)
+		}
+		modCase.Reports++
+		modCase.Updated = now
+
+		// Appealed cases await an administrator instead of unsharing again
+		unshare := modCase.Status == CASE_OPEN && !modCase.Appealed && threshold > 0 && modCase.Reports >= threshold
+		if unshare {
+			modCase.Status = CASE_UNSHARED
+			modCase.Unshared = true
+
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET shareable = false WHERE id = $1", IMAGE_TABLE), image.Id)
+			if err != nil {
+				return fmt.Errorf("unable to unshare image: %v", err)
+			}
+		}
+
+		err = updateObject(tx, MODERATION_CASE_TABLE, modCase)
+		if err != nil {
+			return fmt.Errorf("unable to update moderation case: %v", err)
+		}
+
+		if unshare {
+			return insertModerationEvent(tx, EVENT_IMAGE_UNSHARED, modCase)
+		}
+		return nil
+	})
+	if err != nil {
+		return ModerationCase{}, err
+	}
+
+	return modCase, nil
+}
+
+// ModerationCases returns the moderation cases matching the condition
+func ModerationCases(cond string, args ...interface{}) ([]ModerationCase, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve moderation cases due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ModerationCase{}, MODERATION_CASE_TABLE, cond, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve moderation cases: %v", err)
+	}
+	cases := []ModerationCase{}
+	for _, row := range rows {
+		cases = append(cases, row.(ModerationCase))
+	}
+
+	return cases, nil
+}
+
+// AppealModerationCase reopens the unshared case with the reason of the owner, the image remains
+// restricted until an administrator resolves the case. ErrNotAppealable is returned for other cases
+func AppealModerationCase(id int32, reason string) (ModerationCase, error) {
+	db, err := getDB()
+	if err != nil {
+		return ModerationCase{}, fmt.Errorf("unable to appeal moderation case due to connection error: %v", err)
+	}
+
+	var modCase ModerationCase
+	err = withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, ModerationCase{}, MODERATION_CASE_TABLE, "id = $1 FOR UPDATE", id)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve moderation case: %v", err)
+		}
+		if len(rows) == 0 {
+			return ErrNotAppealable
+		}
+		modCase = rows[0].(ModerationCase)
+		if modCase.Status != CASE_UNSHARED || modCase.Appealed {
+			return ErrNotAppealable
+		}
+
+		modCase.Status = CASE_OPEN
+		modCase.Appealed = true
+		modCase.Appeal = reason
+		modCase.Updated = time.Now().UTC()
+		err = updateObject(tx, MODERATION_CASE_TABLE, modCase)
+		if err != nil {
+			return fmt.Errorf("unable to update moderation case: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return modCase, err
+	}
+
+	return modCase, nil
+}
+
+// ResolveModerationCase closes the case with the status, dismissed cases stop restricting the image
+// while upheld cases restrict it. EVENT_CASE_RESOLVED is emitted in the same transaction
+func ResolveModerationCase(id int32, status string) (ModerationCase, error) {
+	db, err := getDB()
+	if err != nil {
+		return ModerationCase{}, fmt.Errorf("unable to resolve moderation case due to connection error: %v", err)
+	}
+
+	var modCase ModerationCase
+	err = withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, ModerationCase{}, MODERATION_CASE_TABLE, "id = $1 FOR UPDATE", id)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve moderation case: %v", err)
+		}
+		if len(rows) == 0 {
+			return fmt.Errorf("404 - Not found")
+		}
+		modCase = rows[0].(ModerationCase)
+
+		modCase.Status = status
+		modCase.Unshared = status == CASE_UPHELD
+		modCase.Updated = time.Now().UTC()
+		err = updateObject(tx, MODERATION_CASE_TABLE, modCase)
+		if err != nil {
+			return fmt.Errorf("unable to update moderation case: %v", err)
+		}
+
+		// Upheld images stop being shared even if the case never reached its threshold
+		if modCase.Unshared {
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET shareable = false WHERE id = $1", IMAGE_TABLE), modCase.ImageId)
+			if err != nil {
+				return fmt.Errorf("unable to unshare image: %v", err)
+			}
+		}
+
+		return insertModerationEvent(tx, EVENT_CASE_RESOLVED, modCase)
+	})
+	if err != nil {
+		return ModerationCase{}, err
+	}
+	if modCase.Unshared {
+		invalidateImageMeta(modCase.ImageId)
+	}
+
+	return modCase, nil
+}
+
+// insertModerationEvent adds the event notifying the owner of the image about the case to the outbox
+func insertModerationEvent(tx *sql.Tx, topic string, modCase ModerationCase) error {
+	var email string
+	err := tx.QueryRow(fmt.Sprintf("SELECT email FROM %s WHERE id = $1", USER_TABLE), modCase.Uid).Scan(&email)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve owner email: %v", err)
+	}
+
+	return insertEvent(tx, topic, ModerationEvent{
+		CaseId:   modCase.Id,
+		ImageId:  modCase.ImageId,
+		Uid:      modCase.Uid,
+		Email:    email,
+		Category: modCase.Category,
+		Status:   modCase.Status,
+	})
+}
+
+// ModerationRestricted reports whether a moderation case prevents the image from being shared
+func ModerationRestricted(ctx context.Context, imageId int32) (bool, error) {
+	pool, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve moderation cases due to connection error: %v", err)
+	}
+
+	count, err := countWhere(withContext(ctx, pool), MODERATION_CASE_TABLE, "image_id = $1 AND unshared = true", imageId)
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve moderation cases: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// TimelineBuckets returns a page of the user's images grouped by the day or month they were taken,
+// newest first, and whether older buckets follow. Counts and thumbnails are aggregated in one query
+func TimelineBuckets(ctx context.Context, uid int32, group string, page int, strong bool) ([]TimelineBucket, bool, error) {
+	pool, err := getReadDB(strong)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to retrieve timeline due to connection error: %v", err)
+	}
+	db := withContext(ctx, pool)
+
+	// One bucket beyond the page is read to report whether more follow
+	query := fmt.Sprintf(`SELECT date_trunc('%s', taken) AS bucket, COUNT(*),
+		(array_agg(id ORDER BY taken DESC, id DESC))[1:%v], (array_agg(ref ORDER BY taken DESC, id DESC))[1:%v]
+		FROM %s WHERE uid = $1 AND %s GROUP BY bucket ORDER BY bucket DESC LIMIT $2 OFFSET $3`,
+		group, TIMELINE_PREVIEWS, TIMELINE_PREVIEWS, IMAGE_TABLE, NOT_TRASHED)
+	rows, err := db.Query(query, uid, TIMELINE_PAGE_SIZE+1, page*TIMELINE_PAGE_SIZE)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to retrieve timeline: %v", err)
+	}
+	defer rows.Close()
+
+	buckets := []TimelineBucket{}
+	for rows.Next() {
+		var date time.Time
+		var ids pq.Int64Array
+		var refs pq.StringArray
+		bucket := TimelineBucket{Thumbnails: []TimelineThumbnail{}}
+		err = rows.Scan(&date, &bucket.Count, &ids, &refs)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to read timeline bucket: %v", err)
+		}
+
+		bucket.Date = date.Format(timelineDateFormat(group))
+		for i := range ids {
+			if i < len(refs) {
+				bucket.Thumbnails = append(bucket.Thumbnails, TimelineThumbnail{ImageId: int32(ids[i]), Url: thumbnailUrl(refs[i])})
+			}
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("unable to retrieve timeline: %v", err)
+	}
+
+	if len(buckets) > TIMELINE_PAGE_SIZE {
+		return buckets[:TIMELINE_PAGE_SIZE], true, nil
+	}
+	return buckets, false, nil
+}
+
+// PurgeRows deletes up to PURGE_BATCH rows of the table that match the condition
+// the condition uses $n placeholders bound to args. Returns the number of rows deleted
+func PurgeRows(table string, cond string, args ...interface{}) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to purge rows due to connection error: %v", err)
+	}
+
+	batch := fmt.Sprintf("id IN (SELECT id FROM %s WHERE %s LIMIT %v)", table, cond, PURGE_BATCH)
+	purged, err := deleteWhere(db, table, batch, args...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to purge rows: %v", err)
+	}
+
+	return int(purged), nil
+}
+
+// getDB returns the shared connection pool opening it on first use
+// the pool must not be closed by callers, injected database faults are applied to every call
+func getDB() (*sql.DB, error) {
+	err := injectFault(context.Background(), FAULT_DATABASE)
+	if err != nil {
+		return nil, err
+	}
+
+	poolLock.Lock()
+	defer poolLock.Unlock()
+
+	if pool != nil {
+		return pool, nil
+	}
+
+	// The pool is only kept once the database is reachable so later calls retry
+	db, err := openPool(databaseConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	pool = db
+	return pool, nil
+}
+
+// openPool opens a connection pool to the database with the configured limits and verifies it is reachable
+func openPool(config DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dataSourceName(config))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sql db: %v", err)
+	}
+
+	db.SetMaxOpenConns(config.MaxOpen)
+	db.SetMaxIdleConns(config.MaxIdle)
+	db.SetConnMaxLifetime(config.lifetime())
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to connect to sql db: %v", err)
+	}
+
+	return db, nil
+}
+
+// CheckDB verifies the database is reachable through the pool
+func CheckDB(ctx context.Context) error {
+	db, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		stats := db.Stats()
+		return fmt.Errorf("database unreachable (open: %v, in use: %v, waiting: %v): %v", stats.OpenConnections, stats.InUse, stats.WaitCount, err)
+	}
+
+	return nil
+}
+
+// CloseDB closes the shared connection pools, the next database action reopens them
+func CloseDB() error {
+	closeReplica()
+
+	poolLock.Lock()
+	defer poolLock.Unlock()
+
+	if pool == nil {
+		return nil
+	}
+	err := pool.Close()
+	pool = nil
+	return err
+}
+
+// dataSourceName formats the configuration as a postgres connection string quoting each value
+// a configured schema becomes the search path of every connection
+func dataSourceName(config DatabaseConfig) string {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	dsn := fmt.Sprintf("dbname='%s' user='%s' password='%s' host='%s' port='%s'",
+		quote.Replace(config.Name), quote.Replace(config.User), quote.Replace(config.Password), quote.Replace(config.Host), quote.Replace(config.Port))
+	if len(config.Schema) > 0 {
+		dsn += fmt.Sprintf(" search_path='%s'", quote.Replace(config.Schema))
+	}
+	return dsn
+}
+
+// AddAuditEntry inserts an entry into the audit log
+func AddAuditEntry(entry AuditEntry) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add audit entry due to connection error: %v", err)
+	}
+
+	_, err = insertObject(db, AUDIT_TABLE, entry)
+	if err != nil {
+		return fmt.Errorf("unable to add audit entry: %v", err)
+	}
+	return nil
+}
+
+// AuditEntries returns a page of the user's audit log most recent first and the total number of entries
+func AuditEntries(ctx context.Context, uid int32, page int) ([]AuditEntry, int64, error) {
+	pool, err := getDB()
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve audit log due to connection error: %v", err)
+	}
+	db := withContext(ctx, pool)
+
+	total, err := countWhere(db, AUDIT_TABLE, "uid = $1", uid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to count audit entries: %v", err)
+	}
+
+	rows, err := selectWhere(db, AuditEntry{}, AUDIT_TABLE, "uid = $1 ORDER BY id DESC LIMIT $2 OFFSET $3", uid, PAGE_SIZE, page*PAGE_SIZE)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve audit entries: %v", err)
+	}
+	entries := []AuditEntry{}
+	for _, row := range rows {
+		entries = append(entries, row.(AuditEntry))
+	}
+	return entries, total, nil
+}
+
+// AddEvent writes a single event to the outbox for changes not recorded in the database
+func AddEvent(topic string, payload interface{}) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add %s event due to connection error: %v", topic, err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		return insertEvent(tx, topic, payload)
+	})
+}
+
+// AddAnalyticsEvents writes the analytics events to the outbox in a single transaction
+func AddAnalyticsEvents(events []AnalyticsEvent) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add analytics events due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		for _, event := range events {
+			err := insertEvent(tx, "analytics."+event.Type, event)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ProcessOutbox passes each due outbox event to deliver removing delivered events and
+// scheduling failed events for retry. Due events are claimed in a short transaction leasing them
+// to this drain so concurrent drains never deliver the same event, they are delivered outside of
+// any transaction and each is removed or rescheduled on its own. Events of a drain that stops part
+// way are delivered again once their lease expires. Returns the number of events delivered
+func ProcessOutbox(deliver func(event OutboxEvent) error) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to process outbox due to connection error: %v", err)
+	}
+
+	events, err := claimOutboxEvents(db)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var failed error
+	for _, event := range events {
+		err = deliver(event)
+		if err != nil {
+			logger.Error("failed to deliver outbox event %v: %v", event.Id, err)
+			// Events whose lease expired may have been claimed by another drain, their schedule is left to it
+			lease := event.NextAttempt
+			event.Attempts++
+			event.NextAttempt = time.Now().UTC().Add(outboxBackoff(event.Attempts))
+			_, err = db.Exec(fmt.Sprintf("UPDATE %s SET attempts = $1, next_attempt = $2 WHERE id = $3 AND next_attempt = $4", OUTBOX_TABLE),
+				event.Attempts, event.NextAttempt, event.Id, lease)
+			if err != nil && failed == nil {
+				failed = fmt.Errorf("unable to reschedule outbox event %v: %v", event.Id, err)
+			}
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = $1", OUTBOX_TABLE), event.Id)
+		if err != nil {
+			if failed == nil {
+				failed = fmt.Errorf("unable to remove delivered outbox event %v: %v", event.Id, err)
+			}
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, failed
+}
+
+// claimOutboxEvents leases up to OUTBOX_BATCH due events by moving their next attempt to the end of
+// the lease, events locked by a concurrent claim are skipped. The leased events are returned
+func claimOutboxEvents(db *sql.DB) ([]OutboxEvent, error) {
+	// Timestamps are stored with microsecond precision, the lease is compared with the stored value when rescheduling
+	now := time.Now().UTC()
+	lease := now.Add(OUTBOX_LEASE * time.Second).Truncate(time.Microsecond)
+
+	events := []OutboxEvent{}
+	err := withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, OutboxEvent{}, OUTBOX_TABLE, "attempts < $1 AND next_attempt <= $2 ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED",
+			OUTBOX_MAX_ATTEMPTS, now, OUTBOX_BATCH)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve outbox events: %v", err)
+		}
+
+		for _, row := range rows {
+			event := row.(OutboxEvent)
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET next_attempt = $1 WHERE id = $2", OUTBOX_TABLE), lease, event.Id)
+			if err != nil {
+				return fmt.Errorf("unable to claim outbox event: %v", err)
+			}
+			event.NextAttempt = lease
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AddImageViews adds the counted views and downloads to the totals of each image and to their counts of the day of t
+func AddImageViews(t time.Time, views map[int32]int64, downloads map[int32]int64) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add image views due to connection error: %v", err)
+	}
+
+	ids := map[int32]bool{}
+	for imageId := range views {
+		ids[imageId] = true
+	}
+	for imageId := range downloads {
+		ids[imageId] = true
+	}
+
+	day := viewDay(t)
+	return withTx(db, func(tx *sql.Tx) error {
+		total := fmt.Sprintf("INSERT INTO %s (image_id, views, downloads) VALUES ($1, $2, $3) ON CONFLICT (image_id) DO UPDATE SET views = %s.views + EXCLUDED.views, downloads = %s.downloads + EXCLUDED.downloads",
+			IMAGE_VIEW_TABLE, IMAGE_VIEW_TABLE, IMAGE_VIEW_TABLE)
+		daily := fmt.Sprintf("INSERT INTO %s (image_id, day, views, downloads) VALUES ($1, $2, $3, $4) ON CONFLICT (image_id, day) DO UPDATE SET views = %s.views + EXCLUDED.views, downloads = %s.downloads + EXCLUDED.downloads",
+			IMAGE_VIEW_DAY_TABLE, IMAGE_VIEW_DAY_TABLE, IMAGE_VIEW_DAY_TABLE)
+		for imageId := range ids {
+			_, err := tx.Exec(total, imageId, views[imageId], downloads[imageId])
+			if err != nil {
+				return fmt.Errorf("unable to add image views: %v", err)
+			}
+			_, err = tx.Exec(daily, imageId, day, views[imageId], downloads[imageId])
+			if err != nil {
+				return fmt.Errorf("unable to add daily image views: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ImageViewDays returns the daily views and downloads of the image recorded since the day starting at from oldest first
+func ImageViewDays(imageId int32, from time.Time) ([]ImageViewDay, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve daily image views due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, ImageViewDay{}, IMAGE_VIEW_DAY_TABLE, "image_id = $1 AND day >= $2 ORDER BY day", imageId, from)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve daily image views: %v", err)
+	}
+
+	days := []ImageViewDay{}
+	for _, row := range rows {
+		days = append(days, row.(ImageViewDay))
+	}
+	return days, nil
+}
+
+// attachViewCounts sets the total views and downloads of each image
+func attachViewCounts(db dbtx, images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := []int32{}
+	for _, image := range images {
+		ids = append(ids, image.Id)
+	}
+	counts, err := viewCounts(db, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range images {
+		images[i].ViewCount = counts[images[i].Id].Views
+		images[i].DownloadCount = counts[images[i].Id].Downloads
+	}
+	return nil
+}
+
+// ImageViewCounts returns the view count of each image with recorded views
+func ImageViewCounts(ids []int32) (map[int32]int64, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image views due to connection error: %v", err)
+	}
+
+	views, err := viewCounts(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[int32]int64{}
+	for imageId, view := range views {
+		counts[imageId] = view.Views
+	}
+	return counts, nil
+}
+
+// viewCounts returns the total views and downloads of each image with recorded requests
+func viewCounts(db dbtx, ids []int32) (map[int32]ImageView, error) {
+	counts := map[int32]ImageView{}
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	where := &whereBuilder{}
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(args)))
+	rows, err := selectWhere(db, ImageView{}, IMAGE_VIEW_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image views: %v", err)
+	}
+
+	for _, row := range rows {
+		view := row.(ImageView)
+		counts[view.ImageId] = view
+	}
+	return counts, nil
+}
+
+// AddReencodeCampaign stores the campaign together with the event starting it and returns the assigned id
+func AddReencodeCampaign(campaign ReencodeCampaign) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add re-encode campaign due to connection error: %v", err)
+	}
+
+	var id int32
+	err = withTx(db, func(tx *sql.Tx) error {
+		id, err = insertObject(tx, REENCODE_TABLE, campaign)
+		if err != nil {
+			return fmt.Errorf("unable to add re-encode campaign: %v", err)
+		}
+		return insertEvent(tx, EVENT_REENCODE, ReencodeEvent{CampaignId: id, Step: campaign.Step})
+	})
+	return id, err
+}
+
+// GetReencodeCampaign retrieves the campaign with the id, reporting false if it doesn't exist
+func GetReencodeCampaign(id int32) (ReencodeCampaign, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return ReencodeCampaign{}, false, fmt.Errorf("unable to retrieve re-encode campaign due to connection error: %v", err)
+	}
+
+	campaigns, err := selectWhere(db, ReencodeCampaign{}, REENCODE_TABLE, "id = $1", id)
+	if err != nil {
+		return ReencodeCampaign{}, false, fmt.Errorf("unable to retrieve re-encode campaign: %v", err)
+	}
+	if len(campaigns) == 0 {
+		return ReencodeCampaign{}, false, nil
+	}
+
+	return campaigns[0].(ReencodeCampaign), true, nil
+}
+
+// UpdateReencodeCampaign stores the status and report of the campaign, when next is set
+// an event continuing the campaign at its current step is added in the same transaction
+func UpdateReencodeCampaign(campaign ReencodeCampaign, next bool) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update re-encode campaign due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		err := updateObject(tx, REENCODE_TABLE, campaign)
+		if err != nil {
+			return fmt.Errorf("unable to update re-encode campaign: %v", err)
+		}
+		if !next {
+			return nil
+		}
+		return insertEvent(tx, EVENT_REENCODE, ReencodeEvent{CampaignId: campaign.Id, Step: campaign.Step})
+	})
+}
+
+// NextReencodeImages retrieves up to limit images lacking a file in any of the encodings produced
+// from their current content, the most viewed images first
+func NextReencodeImages(encodings []string, limit int) ([]Image, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images due to connection error: %v", err)
+	}
+
+	where := &whereBuilder{}
+	missing := []string{}
+	for _, encoding := range encodings {
+		missing = append(missing, fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.image_id = %s.id AND %s.source_hash = %s.hash AND %s.encoding = %s)",
+			IMAGE_FORMAT_TABLE, IMAGE_FORMAT_TABLE, IMAGE_TABLE, IMAGE_FORMAT_TABLE, IMAGE_TABLE, IMAGE_FORMAT_TABLE, where.bind(encoding)))
+	}
+	where.add(NOT_TRASHED)
+	where.add("(" + strings.Join(missing, " OR ") + ")")
+	order := fmt.Sprintf(" ORDER BY (SELECT views FROM %s WHERE %s.image_id = %s.id) DESC NULLS LAST, id LIMIT %s",
+		IMAGE_VIEW_TABLE, IMAGE_VIEW_TABLE, IMAGE_TABLE, where.bind(limit))
+
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, where.String()+order, where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images: %v", err)
+	}
+
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
+	}
+	return images, nil
+}
+
+// AddImageFormats records the files produced for an image replacing earlier files of the same encodings
+func AddImageFormats(formats []ImageFormat) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add image formats due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		for _, format := range formats {
+			_, err := deleteWhere(tx, IMAGE_FORMAT_TABLE, "image_id = $1 AND encoding = $2", format.ImageId, format.Encoding)
+			if err != nil {
+				return fmt.Errorf("unable to replace image format: %v", err)
+			}
+			_, err = insertObject(tx, IMAGE_FORMAT_TABLE, format)
+			if err != nil {
+				return fmt.Errorf("unable to add image format: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ImageFormats retrieves the stored files of the image in other encodings
+func ImageFormats(ctx context.Context, imageId int32) ([]ImageFormat, error) {
+	pool, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image formats due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(withContext(ctx, pool), ImageFormat{}, IMAGE_FORMAT_TABLE, "image_id = $1 AND file_key <> '' ORDER BY size", imageId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image formats: %v", err)
+	}
+
+	formats := []ImageFormat{}
+	for _, row := range rows {
+		formats = append(formats, row.(ImageFormat))
+	}
+	return formats, nil
+}
+
+// ReencodeTotals returns the number and bytes of the files stored by the campaign in each encoding
+func ReencodeTotals(campaignId int32) ([]FormatTotal, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to total re-encoded files due to connection error: %v", err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT encoding, COUNT(*), SUM(size) FROM %s WHERE campaign_id = $1 AND file_key <> '' GROUP BY encoding ORDER BY encoding", IMAGE_FORMAT_TABLE), campaignId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to total re-encoded files: %v", err)
+	}
+	defer rows.Close()
+
+	totals := []FormatTotal{}
+	for rows.Next() {
+		var total FormatTotal
+		err = rows.Scan(&total.Encoding, &total.Files, &total.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan re-encoded totals: %v", err)
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// UniqueUsername reports whether no account other than the user's has the username regardless of case
+func UniqueUsername(username string, uid int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to validate username due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, USER_TABLE, "lower(username) = lower($1) AND id <> $2", username, uid)
+	if err != nil {
+		return false, fmt.Errorf("unable to validate username: %v", err)
+	}
+	return count == 0, nil
+}
+
+// UsersByUsername returns the users with the usernames keyed by their lower case username
+func UsersByUsername(usernames []string) (map[string]User, error) {
+	users := map[string]User{}
+	if len(usernames) == 0 {
+		return users, nil
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve users due to connection error: %v", err)
+	}
+
+	where := &whereBuilder{}
+	names := []interface{}{}
+	for _, username := range usernames {
+		names = append(names, strings.ToLower(username))
+	}
+	where.add(fmt.Sprintf("username <> '' AND lower(username) IN (%s)", where.placeholders(names)))
+	rows, err := selectWhere(db, User{}, USER_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve users: %v", err)
+	}
+
+	for _, row := range rows {
+		user := row.(User)
+		users[strings.ToLower(user.Username)] = user
+	}
+	return users, nil
+}
+
+// ReplaceImageMentions records the mentions of the image description replacing its previous
+// mentions and returns the users mentioned before
+func ReplaceImageMentions(imageId int32, mentions []MentionEntity) ([]int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to update mentions due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(imageId)
+
+	previous := []int32{}
+	err = withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, Mention{}, MENTION_TABLE, "image_id = $1", imageId)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve mentions: %v", err)
+		}
+		for _, row := range rows {
+			previous = append(previous, row.(Mention).Uid)
+		}
+
+		_, err = deleteWhere(tx, MENTION_TABLE, "image_id = $1", imageId)
+		if err != nil {
+			return fmt.Errorf("unable to delete mentions: %v", err)
+		}
+		now := time.Now().UTC()
+		for _, mention := range mentions {
+			_, err = insertObject(tx, MENTION_TABLE, Mention{ImageId: imageId, Uid: mention.Uid, Username: mention.Username, Offset: mention.Offset, Length: mention.Length, Created: now})
+			if err != nil {
+				return fmt.Errorf("unable to add mention: %v", err)
+			}
+		}
+		return nil
+	})
+	return previous, err
+}
+
+// attachMentions sets the mentions of each image
+func attachMentions(db dbtx, images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	where := &whereBuilder{}
+	ids := []interface{}{}
+	index := map[int32]int{}
+	for i := range images {
+		images[i].Mentions = []MentionEntity{}
+		ids = append(ids, images[i].Id)
+		index[images[i].Id] = i
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(ids)))
+
+	rows, err := selectWhere(db, Mention{}, MENTION_TABLE, where.String()+" ORDER BY start_offset", where.Args()...)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve mentions: %v", err)
+	}
+
+	for _, row := range rows {
+		mention := row.(Mention)
+		if i, ok := index[mention.ImageId]; ok {
+			images[i].Mentions = append(images[i].Mentions, MentionEntity{Uid: mention.Uid, Username: mention.Username, Offset: mention.Offset, Length: mention.Length})
+		}
+	}
+
+	return nil
+}
+
+// attachLikeCounts sets the number of users liking each image
+func attachLikeCounts(db dbtx, images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := []int32{}
+	for _, image := range images {
+		ids = append(ids, image.Id)
+	}
+	counts, err := likeCounts(db, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range images {
+		images[i].LikeCount = counts[images[i].Id]
+	}
+	return nil
+}
+
+// ImageLikeCounts returns the number of users liking each image with likes
+func ImageLikeCounts(ids []int32) (map[int32]int64, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to count likes due to connection error: %v", err)
+	}
+	return likeCounts(db, ids)
+}
+
+// likeCounts returns the number of users liking each image with likes
+func likeCounts(db dbtx, ids []int32) (map[int32]int64, error) {
+	counts := map[int32]int64{}
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	where := &whereBuilder{}
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(args)))
+	rows, err := db.Query(fmt.Sprintf("SELECT image_id, COUNT(*) FROM %s WHERE %s GROUP BY image_id", LIKE_TABLE, where.String()), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count likes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		var count int64
+		err = rows.Scan(&id, &count)
+		if err != nil {
+			return nil, fmt.Errorf("unable to count likes: %v", err)
+		}
+		counts[id] = count
+	}
+	return counts, rows.Err()
+}
+
+// AddImageLike records the like unless the user already likes the image
+func AddImageLike(like ImageLike) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add like due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(like.ImageId)
+
+	_, err = insertObject(db, LIKE_TABLE, like)
+	if err != nil {
+		return fmt.Errorf("unable to add like: %v", err)
+	}
+	return nil
+}
+
+// DeleteImageLike removes the like of the user from the image if there is one
+func DeleteImageLike(imageId int32, uid int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete like due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(imageId)
+
+	_, err = deleteWhere(db, LIKE_TABLE, "image_id = $1 AND uid = $2", imageId, uid)
+	if err != nil {
+		return fmt.Errorf("unable to delete like: %v", err)
+	}
+	return nil
+}
+
+// attachRatings sets the average rating and number of ratings of each image
+func attachRatings(db dbtx, images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := []int32{}
+	for _, image := range images {
+		ids = append(ids, image.Id)
+	}
+	summaries, err := ratingSummaries(db, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range images {
+		images[i].Rating = summaries[images[i].Id].Average
+		images[i].RatingCount = summaries[images[i].Id].Count
+	}
+	return nil
+}
+
+// ImageRatingSummaries returns the aggregate rating of each image with ratings
+func ImageRatingSummaries(ids []int32) (map[int32]RatingSummary, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve ratings due to connection error: %v", err)
+	}
+	return ratingSummaries(db, ids)
+}
+
+// ratingSummaries returns the aggregate rating of each image with ratings, averages are rounded to 2 decimals
+func ratingSummaries(db dbtx, ids []int32) (map[int32]RatingSummary, error) {
+	summaries := map[int32]RatingSummary{}
+	if len(ids) == 0 {
+		return summaries, nil
+	}
+
+	where := &whereBuilder{}
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(args)))
+	rows, err := db.Query(fmt.Sprintf("SELECT image_id, ROUND(AVG(stars), 2)::float8, COUNT(*) FROM %s WHERE %s GROUP BY image_id", RATING_TABLE, where.String()), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve ratings: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		summary := RatingSummary{}
+		err = rows.Scan(&id, &summary.Average, &summary.Count)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve ratings: %v", err)
+		}
+		summaries[id] = summary
+	}
+	return summaries, rows.Err()
+}
+
+// SetImageRating records the rating replacing an earlier rating of the image by the same user
+func SetImageRating(rating ImageRating) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to rate image due to connection error: %v", err)
+	}
+	defer invalidateImageMeta(rating.ImageId)
+
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (image_id, uid, stars, rated) VALUES ($1, $2, $3, $4) ON CONFLICT (image_id, uid) DO UPDATE SET stars = EXCLUDED.stars, rated = EXCLUDED.rated", RATING_TABLE), rating.ImageId, rating.Uid, rating.Stars, rating.Rated)
+	if err != nil {
+		return fmt.Errorf("unable to rate image: %v", err)
+	}
+	return nil
+}
+
+// AddComment inserts the comment and returns the assigned id
+func AddComment(comment Comment) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add comment due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, COMMENT_TABLE, comment)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add comment due to insertion error: %v", err)
+	}
+	return id, nil
+}
+
+// GetComment retrieves the comment with the id, reporting false if it doesn't exist
+func GetComment(id int32) (Comment, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return Comment{}, false, fmt.Errorf("unable to retrieve comment due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Comment{}, COMMENT_TABLE, "id = $1", id)
+	if err != nil {
+		return Comment{}, false, fmt.Errorf("unable to retrieve comment: %v", err)
+	}
+	if len(rows) == 0 {
+		return Comment{}, false, nil
+	}
+	return rows[0].(Comment), true, nil
+}
+
+// ImageComments returns a page of the comments of the image oldest first with their authors by uid
+// and the number of comments on the image
+func ImageComments(imageId int32, page MetaPage) ([]Comment, map[int32]User, int, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("unable to retrieve comments due to connection error: %v", err)
+	}
+
+	total, err := countWhere(db, COMMENT_TABLE, "image_id = $1", imageId)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("unable to count comments: %v", err)
+	}
+
+	rows, err := selectWhere(db, Comment{}, COMMENT_TABLE, "image_id = $1 ORDER BY id LIMIT $2 OFFSET $3", imageId, page.PageSize, page.Page*page.PageSize)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("unable to retrieve comments: %v", err)
+	}
+	comments := []Comment{}
+	where := &whereBuilder{}
+	authors := []interface{}{}
+	for _, row := range rows {
+		comment := row.(Comment)
+		comments = append(comments, comment)
+		authors = append(authors, comment.Uid)
+	}
+
+	users := map[int32]User{}
+	if len(authors) == 0 {
+		return comments, users, int(total), nil
+	}
+	where.add(fmt.Sprintf("id IN (%s)", where.placeholders(authors)))
+	userRows, err := selectWhere(db, User{}, USER_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("unable to retrieve comment authors: %v", err)
+	}
+	for _, row := range userRows {
+		user := row.(User)
+		users[user.Uid] = user
+	}
+
+	return comments, users, int(total), nil
+}
+
+// UpdateComment replaces the stored comment with the provided one
+func UpdateComment(comment Comment) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update comment due to connection error: %v", err)
+	}
+
+	err = updateObject(db, COMMENT_TABLE, comment)
+	if err != nil {
+		return fmt.Errorf("unable to update comment: %v", err)
+	}
+	return nil
+}
+
+// DeleteComment deletes the comment with the id
+func DeleteComment(id int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to delete comment due to connection error: %v", err)
+	}
+
+	_, err = deleteWhere(db, COMMENT_TABLE, "id = $1", id)
+	if err != nil {
+		return fmt.Errorf("unable to delete comment: %v", err)
+	}
+	return nil
+}
+
+// AddNotification stores the notification together with the event delivering it
+func AddNotification(notification Notification) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add notification due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		id, err := insertObject(tx, NOTIFICATION_TABLE, notification)
+		if err != nil {
+			return fmt.Errorf("unable to add notification: %v", err)
+		}
+		return insertEvent(tx, EVENT_USER_MENTIONED, MentionEvent{NotificationId: id, Uid: notification.Uid, ActorUid: notification.ActorUid, ImageId: notification.ImageId})
+	})
+}
+
+// UserNotifications returns a page of the user's notifications newest first, only unread ones when unread is set,
+// with the number of notifications listed and the number unread
+func UserNotifications(uid int32, unread bool, page int) ([]Notification, int, int, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unable to retrieve notifications due to connection error: %v", err)
+	}
+
+	cond := "uid = $1"
+	if unread {
+		cond += " AND NOT read"
+	}
+	total, err := countWhere(db, NOTIFICATION_TABLE, cond, uid)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unable to count notifications: %v", err)
+	}
+	unreadCount, err := countWhere(db, NOTIFICATION_TABLE, "uid = $1 AND NOT read", uid)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unable to count unread notifications: %v", err)
+	}
+
+	rows, err := selectWhere(db, Notification{}, NOTIFICATION_TABLE, cond+" ORDER BY id DESC LIMIT $2 OFFSET $3", uid, PAGE_SIZE, page*PAGE_SIZE)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unable to retrieve notifications: %v", err)
+	}
+
+	notifications := []Notification{}
+	for _, row := range rows {
+		notifications = append(notifications, row.(Notification))
+	}
+	return notifications, int(total), int(unreadCount), nil
+}
+
+// MarkNotificationsRead marks the user's notifications with the ids read, every notification of the user when ids is empty
+func MarkNotificationsRead(uid int32, ids []int32) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update notifications due to connection error: %v", err)
+	}
+
+	where := &whereBuilder{}
+	where.add("uid = ?", uid)
+	if len(ids) > 0 {
+		args := []interface{}{}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		where.add(fmt.Sprintf("id IN (%s)", where.placeholders(args)))
+	}
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET read = true WHERE %s", NOTIFICATION_TABLE, where.String()), where.Args()...)
+	if err != nil {
+		return fmt.Errorf("unable to update notifications: %v", err)
+	}
+	return nil
+}
+
+// AddStorageCheck stores the storage check together with the event running it and returns the assigned id
+func AddStorageCheck(check StorageCheck) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add storage check due to connection error: %v", err)
+	}
+
+	var id int32
+	err = withTx(db, func(tx *sql.Tx) error {
+		id, err = insertObject(tx, STORAGE_CHECK_TABLE, check)
+		if err != nil {
+			return fmt.Errorf("unable to add storage check: %v", err)
+		}
+		return insertEvent(tx, EVENT_STORAGE_CHECK, StorageCheckEvent{CheckId: id})
+	})
+	return id, err
+}
+
+// MarkImageDamaged records the damaged image and schedules the integrity check in the same transaction,
+// reporting false without scheduling the check if the image was already marked
+func MarkImageDamaged(damaged DamagedImage, check StorageCheck) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to mark image damaged due to connection error: %v", err)
+	}
+
+	marked := false
+	err = withTx(db, func(tx *sql.Tx) error {
+		stmt := fmt.Sprintf("INSERT INTO %s (image_id, file_key, check_id, detected) VALUES ($1, $2, 0, $3) ON CONFLICT (image_id) DO NOTHING", DAMAGED_IMAGE_TABLE)
+		result, err := tx.Exec(stmt, damaged.ImageId, damaged.FileKey, damaged.Detected)
+		if err != nil {
+			return fmt.Errorf("unable to mark image damaged: %v", err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil || count == 0 {
+			return err
+		}
+
+		checkId, err := insertObject(tx, STORAGE_CHECK_TABLE, check)
+		if err != nil {
+			return fmt.Errorf("unable to add storage check: %v", err)
+		}
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET check_id = $1 WHERE image_id = $2", DAMAGED_IMAGE_TABLE), checkId, damaged.ImageId)
+		if err != nil {
+			return fmt.Errorf("unable to record storage check of damaged image: %v", err)
+		}
+		marked = true
+		return insertEvent(tx, EVENT_STORAGE_CHECK, StorageCheckEvent{CheckId: checkId})
+	})
+	return marked, err
+}
+
+// GetStorageCheck retrieves the storage check with the id, reporting false if it doesn't exist
+func GetStorageCheck(id int32) (StorageCheck, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return StorageCheck{}, false, fmt.Errorf("unable to retrieve storage check due to connection error: %v", err)
+	}
+
+	checks, err := selectWhere(db, StorageCheck{}, STORAGE_CHECK_TABLE, "id = $1", id)
+	if err != nil {
+		return StorageCheck{}, false, fmt.Errorf("unable to retrieve storage check: %v", err)
+	}
+	if len(checks) == 0 {
+		return StorageCheck{}, false, nil
+	}
+
+	return checks[0].(StorageCheck), true, nil
+}
+
+// UpdateStorageCheck stores the status and report of the storage check
+func UpdateStorageCheck(check StorageCheck) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update storage check due to connection error: %v", err)
+	}
+
+	err = updateObject(db, STORAGE_CHECK_TABLE, check)
+	if err != nil {
+		return fmt.Errorf("unable to update storage check: %v", err)
+	}
+	return nil
+}
+
+// ReplaceStorageFindings replaces the findings of the check, findings of a failed attempt are discarded
+func ReplaceStorageFindings(checkId int32, findings []StorageFinding) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to add storage findings due to connection error: %v", err)
+	}
+
+	return withTx(db, func(tx *sql.Tx) error {
+		_, err := deleteWhere(tx, STORAGE_FINDING_TABLE, "check_id = $1", checkId)
+		if err != nil {
+			return fmt.Errorf("unable to remove storage findings: %v", err)
+		}
+		for _, finding := range findings {
+			_, err = insertObject(tx, STORAGE_FINDING_TABLE, finding)
+			if err != nil {
+				return fmt.Errorf("unable to add storage finding: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// StorageFindings returns a page of the findings of the kind recorded by the check ordered by key and their total
+func StorageFindings(checkId int32, kind string, page int) ([]StorageFinding, int, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve storage findings due to connection error: %v", err)
+	}
+
+	total, err := countWhere(db, STORAGE_FINDING_TABLE, "check_id = $1 AND kind = $2", checkId, kind)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to count storage findings: %v", err)
+	}
+
+	rows, err := selectWhere(db, StorageFinding{}, STORAGE_FINDING_TABLE, "check_id = $1 AND kind = $2 ORDER BY file_key LIMIT $3 OFFSET $4", checkId, kind, PAGE_SIZE, page*PAGE_SIZE)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to retrieve storage findings: %v", err)
+	}
+
+	findings := []StorageFinding{}
+	for _, row := range rows {
+		findings = append(findings, row.(StorageFinding))
+	}
+	return findings, int(total), nil
+}
+
+// StorageCheckImages retrieves up to limit images with ids above after, trashed images included,
+// of the user or of every user when uid is 0
+func StorageCheckImages(uid int32, after int32, limit int) ([]Image, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images due to connection error: %v", err)
+	}
+
+	where := &whereBuilder{}
+	where.add("id > ?", after)
+	if uid > 0 {
+		where.add("uid = ?", uid)
+	}
+	rows, err := selectWhere(db, Image{}, IMAGE_TABLE, fmt.Sprintf("%s ORDER BY id LIMIT %s", where.String(), where.bind(limit)), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve images: %v", err)
+	}
+
+	images := []Image{}
+	for _, row := range rows {
+		images = append(images, row.(Image))
+	}
+	return images, nil
+}
+
+// ImageFormatKeys returns the keys of the stored files in other encodings of each of the images
+func ImageFormatKeys(imageIds []int32) (map[int32][]string, error) {
+	keys := map[int32][]string{}
+	if len(imageIds) == 0 {
+		return keys, nil
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image formats due to connection error: %v", err)
+	}
+
+	where := &whereBuilder{}
+	args := []interface{}{}
+	for _, id := range imageIds {
+		args = append(args, id)
+	}
+	where.add(fmt.Sprintf("image_id IN (%s)", where.placeholders(args)))
+	where.add("file_key <> ''")
+	rows, err := selectWhere(db, ImageFormat{}, IMAGE_FORMAT_TABLE, where.String(), where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image formats: %v", err)
+	}
+
+	for _, row := range rows {
+		format := row.(ImageFormat)
+		keys[format.ImageId] = append(keys[format.ImageId], format.FileKey)
+	}
+	return keys, nil
+}
+
+// BlobKeys returns the keys of the referenced blobs beginning with the prefix
+func BlobKeys(prefix string) ([]string, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve blobs due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Blob{}, BLOB_TABLE, `refs > 0 AND file_key LIKE $1 ESCAPE '\'`, escapeLike(prefix)+"%")
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve blobs: %v", err)
+	}
+
+	keys := []string{}
+	for _, row := range rows {
+		keys = append(keys, row.(Blob).Key)
+	}
+	return keys, nil
+}
+
+// AddUploadIntakes inserts the queued uploads and returns them with their assigned ids
+func AddUploadIntakes(items []UploadIntake) ([]UploadIntake, error) {
+	db, err := getDB()
+	if err != nil {
+		return items, fmt.Errorf("unable to queue uploads due to connection error: %v", err)
+	}
+
+	added := make([]UploadIntake, len(items))
+	err = withTx(db, func(tx *sql.Tx) error {
+		for i, item := range items {
+			item.Id, err = insertObject(tx, INTAKE_TABLE, item)
+			if err != nil {
+				return fmt.Errorf("unable to queue upload: %v", err)
+			}
+			added[i] = item
+		}
+		return nil
+	})
+	if err != nil {
+		return items, err
+	}
+	return added, nil
+}
+
+// CountUnfinishedIntake counts the queued uploads of the user that are yet to be saved
+func CountUnfinishedIntake(uid int32) (int, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count queued uploads due to connection error: %v", err)
+	}
+
+	count, err := countWhere(db, INTAKE_TABLE, "uid = $1 AND status IN ($2, $3)", uid, INTAKE_QUEUED, INTAKE_PROCESSING)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count queued uploads: %v", err)
+	}
+	return int(count), nil
+}
+
+// GetUploadIntake retrieves the queued upload with the id, reporting false if it doesn't exist
+func GetUploadIntake(id int32) (UploadIntake, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return UploadIntake{}, false, fmt.Errorf("unable to retrieve queued upload due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, UploadIntake{}, INTAKE_TABLE, "id = $1", id)
+	if err != nil {
+		return UploadIntake{}, false, fmt.Errorf("unable to retrieve queued upload: %v", err)
+	}
+	if len(rows) == 0 {
+		return UploadIntake{}, false, nil
+	}
+	return rows[0].(UploadIntake), true, nil
+}
+
+// ClaimUploadIntakes marks up to limit queued uploads as processing and returns them. Users take turns,
+// the next upload of every user is claimed before the one after it, oldest first. Uploads processing
+// since before stale were interrupted and are claimed again
+func ClaimUploadIntakes(limit int, stale time.Time) ([]UploadIntake, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to claim queued uploads due to connection error: %v", err)
+	}
+
+	items := []UploadIntake{}
+	err = withTx(db, func(tx *sql.Tx) error {
+		queue := fmt.Sprintf("SELECT id, ROW_NUMBER() OVER (PARTITION BY uid ORDER BY id) AS turn FROM %s WHERE status = $1 OR (status = $2 AND started < $3)", INTAKE_TABLE)
+		cond := fmt.Sprintf("id IN (SELECT id FROM (%s) queue ORDER BY turn, id LIMIT $4) FOR UPDATE SKIP LOCKED", queue)
+		rows, err := selectWhere(tx, UploadIntake{}, INTAKE_TABLE, cond, INTAKE_QUEUED, INTAKE_PROCESSING, stale, limit)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve queued uploads: %v", err)
+		}
+
+		now := time.Now().UTC()
+		for _, row := range rows {
+			item := row.(UploadIntake)
+			item.Status = INTAKE_PROCESSING
+			item.Started = now
+			item.Attempts++
+			err = updateObject(tx, INTAKE_TABLE, item)
+			if err != nil {
+				return fmt.Errorf("unable to claim queued upload: %v", err)
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// UpdateUploadIntake stores the status and outcome of the queued upload
+func UpdateUploadIntake(item UploadIntake) error {
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("unable to update queued upload due to connection error: %v", err)
+	}
+
+	err = updateObject(db, INTAKE_TABLE, item)
+	if err != nil {
+		return fmt.Errorf("unable to update queued upload: %v", err)
+	}
+	return nil
+}
+
+// DeleteUserIntake deletes the queued uploads of the user and returns those deleted
+func DeleteUserIntake(uid int32) ([]UploadIntake, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete queued uploads due to connection error: %v", err)
+	}
+
+	items := []UploadIntake{}
+	err = withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, UploadIntake{}, INTAKE_TABLE, "uid = $1 FOR UPDATE", uid)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve queued uploads: %v", err)
+		}
+		for _, row := range rows {
+			items = append(items, row.(UploadIntake))
+		}
+		_, err = deleteWhere(tx, INTAKE_TABLE, "uid = $1", uid)
+		if err != nil {
+			return fmt.Errorf("unable to delete queued uploads: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddAnnouncement inserts an announcement and returns the assigned id
+func AddAnnouncement(announcement Announcement) (int32, error) {
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("unable to add announcement due to connection error: %v", err)
+	}
+
+	id, err := insertObject(db, ANNOUNCEMENT_TABLE, announcement)
+	if err != nil {
+		return 0, fmt.Errorf("unable to add announcement: %v", err)
+	}
+	return id, nil
+}
+
+// CurrentAnnouncements retrieves the announcements shown at the time, including those yet to start when upcoming is true
+func CurrentAnnouncements(now time.Time, upcoming bool) ([]Announcement, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve announcements due to connection error: %v", err)
+	}
+
+	where := whereBuilder{}
+	where.add("(ends > ? OR ends = ?)", now, time.Time{})
+	if !upcoming {
+		where.add("starts <= ?", now)
+	}
+	rows, err := selectWhere(db, Announcement{}, ANNOUNCEMENT_TABLE, where.String()+" ORDER BY starts, id", where.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve announcements: %v", err)
+	}
+
+	announcements := []Announcement{}
+	for _, row := range rows {
+		announcements = append(announcements, row.(Announcement))
+	}
+	return announcements, nil
+}
+
+// AllAnnouncements retrieves every announcement most recently started first
+func AllAnnouncements() ([]Announcement, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve announcements due to connection error: %v", err)
+	}
+
+	rows, err := selectWhere(db, Announcement{}, ANNOUNCEMENT_TABLE, "TRUE ORDER BY starts DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve announcements: %v", err)
+	}
+
+	announcements := []Announcement{}
+	for _, row := range rows {
+		announcements = append(announcements, row.(Announcement))
+	}
+	return announcements, nil
+}
+
+// ReplaceAnnouncement replaces the content and schedule of the announcement with the id of the provided one,
+// its author and creation date are kept. Reports false if there is no such announcement
+func ReplaceAnnouncement(announcement Announcement) (Announcement, bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return Announcement{}, false, fmt.Errorf("unable to update announcement due to connection error: %v", err)
+	}
+
+	found := false
+	err = withTx(db, func(tx *sql.Tx) error {
+		rows, err := selectWhere(tx, Announcement{}, ANNOUNCEMENT_TABLE, "id = $1 FOR UPDATE", announcement.Id)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve announcement: %v", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		existing := rows[0].(Announcement)
+		announcement.CreatedBy = existing.CreatedBy
+		announcement.Created = existing.Created
+		err = updateObject(tx, ANNOUNCEMENT_TABLE, announcement)
+		if err != nil {
+			return fmt.Errorf("unable to update announcement: %v", err)
+		}
+		found = true
+		return nil
+	})
+	return announcement, found, err
+}
+
+// DeleteAnnouncement deletes the announcement, reporting false if there is no such announcement
+func DeleteAnnouncement(id int32) (bool, error) {
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("unable to delete announcement due to connection error: %v", err)
+	}
+
+	count, err := deleteWhere(db, ANNOUNCEMENT_TABLE, "id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete announcement: %v", err)
+	}
+	return count > 0, nil
 }
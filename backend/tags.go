@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MAX_TAGS = 20 // Maximum number of tags that can be assigned to a single image
+
+	// Tag filter modes for image meta queries
+	TAG_MODE_AND = "and"
+	TAG_MODE_OR  = "or"
+)
+
+// ImageTag associates a single tag with an image tagged for sql serialization
+type ImageTag struct {
+	Id      int32  `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId int32  `sql:"image_id"`
+	Tag     string `sql:"tag"`
+}
+
+// validTag restricts tags to short lowercase words so they are safe to embed in urls and filenames
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// parseTags accepts a comma separated list of tags and returns the normalized unique tags
+// an error is returned if any tag is invalid or too many tags are provided
+func parseTags(tagList string) ([]string, error) {
+	tags := []string{}
+	for _, tag := range strings.Split(tagList, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) == 0 || containsString(tags, tag) {
+			continue
+		}
+		if !validTag.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, tags must be 1-32 characters of a-z, 0-9, _ or -", tag)
+		}
+		tags = append(tags, tag)
+	}
+
+	if len(tags) > MAX_TAGS {
+		return nil, fmt.Errorf("too many tags, images may have at most %v tags", MAX_TAGS)
+	}
+
+	return tags, nil
+}
+
+// tagCondition adds a condition to the query matching images with the provided tags
+// in and mode images must have every tag, in or mode images must have at least one
+func tagCondition(where *whereBuilder, tags []string, mode string) error {
+	if mode != TAG_MODE_AND && mode != TAG_MODE_OR {
+		return fmt.Errorf("invalid tag mode %q, use %s or %s", mode, TAG_MODE_AND, TAG_MODE_OR)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	values := []interface{}{}
+	for _, tag := range tags {
+		values = append(values, tag)
+	}
+	tagSet := where.placeholders(values)
+
+	if mode == TAG_MODE_OR {
+		where.add(fmt.Sprintf("id IN (SELECT image_id FROM %s WHERE tag IN (%s))", TAG_TABLE, tagSet))
+		return nil
+	}
+
+	where.add(fmt.Sprintf("id IN (SELECT image_id FROM %s WHERE tag IN (%s) GROUP BY image_id HAVING COUNT(DISTINCT tag) = %s)", TAG_TABLE, tagSet, where.bind(len(tags))))
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseTags evaluates tag normalization and validation
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags(" Beach,sunset ,,beach, road-trip_2021")
+	if err != nil {
+		t.Fatalf("failed to parse valid tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"beach", "sunset", "road-trip_2021"}) {
+		t.Errorf("wrong tags: got %v", tags)
+	}
+
+	tags, err = parseTags("")
+	if err != nil || tags == nil || len(tags) != 0 {
+		t.Errorf("empty tag list should parse to no tags: got %v %v", tags, err)
+	}
+
+	invalid := []string{"a b", "x'); DROP TABLE image_meta;--", "-leading", strings.Repeat("a", 33), "ünicode"}
+	for _, tagList := range invalid {
+		if _, err := parseTags(tagList); err == nil {
+			t.Errorf("expected error for invalid tag %q", tagList)
+		}
+	}
+
+	tooMany := []string{}
+	for i := 0; i <= MAX_TAGS; i++ {
+		tooMany = append(tooMany, strings.Repeat("t", i+1))
+	}
+	if _, err := parseTags(strings.Join(tooMany, ",")); err == nil {
+		t.Errorf("expected error for more than %v tags", MAX_TAGS)
+	}
+}
+
+// TestTagCondition ensures and/or tag filters produce the expected conditions
+func TestTagCondition(t *testing.T) {
+	where := &whereBuilder{}
+	if err := tagCondition(where, []string{"a", "b"}, TAG_MODE_OR); err != nil {
+		t.Fatal(err)
+	}
+	if where.String() != "id IN (SELECT image_id FROM image_tags WHERE tag IN ($1, $2))" {
+		t.Errorf("wrong or condition: got %s", where.String())
+	}
+	if !reflect.DeepEqual(where.Args(), []interface{}{"a", "b"}) {
+		t.Errorf("wrong or arguments: got %v", where.Args())
+	}
+
+	where = &whereBuilder{}
+	if err := tagCondition(where, []string{"a", "b"}, TAG_MODE_AND); err != nil {
+		t.Fatal(err)
+	}
+	if where.String() != "id IN (SELECT image_id FROM image_tags WHERE tag IN ($1, $2) GROUP BY image_id HAVING COUNT(DISTINCT tag) = $3)" {
+		t.Errorf("wrong and condition: got %s", where.String())
+	}
+	if !reflect.DeepEqual(where.Args(), []interface{}{"a", "b", 2}) {
+		t.Errorf("wrong and arguments: got %v", where.Args())
+	}
+
+	if err := tagCondition(&whereBuilder{}, []string{"a"}, "xor"); err == nil {
+		t.Errorf("expected error for invalid mode")
+	}
+}
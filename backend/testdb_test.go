@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	TEST_DB_IMAGE = "postgres:16-alpine" // Default if TEST_DB_IMAGE env variable is not defined
+	TEST_DB_WAIT  = 60 * time.Second     // Time the container is given to accept connections
+)
+
+// startTestPostgres runs a disposable PostgreSQL container with the user, password and database of the
+// configuration and returns the configuration pointing at it with a function removing the container.
+// TEST_DB_IMAGE names the image, none disables the container
+func startTestPostgres(config DatabaseConfig) (DatabaseConfig, func(), error) {
+	image := os.Getenv("TEST_DB_IMAGE")
+	if len(image) == 0 {
+		image = TEST_DB_IMAGE
+	}
+	if image == "none" {
+		return config, func() {}, fmt.Errorf("no database at %s:%s and TEST_DB_IMAGE is none", config.Host, config.Port)
+	}
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return config, func() {}, fmt.Errorf("no database at %s:%s and docker is unavailable to start one: %v", config.Host, config.Port, err)
+	}
+
+	// Publish the port on a free port of the loopback interface so concurrent runs don't collide
+	out, err := exec.Command(docker, "run", "--detach", "--rm", "--publish", "127.0.0.1::5432",
+		"--env", "POSTGRES_USER="+config.User, "--env", "POSTGRES_PASSWORD="+config.Password, "--env", "POSTGRES_DB="+config.Name, image).Output()
+	if err != nil {
+		return config, func() {}, fmt.Errorf("failed to start %s container: %v", image, commandError(err))
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() {
+		err := exec.Command(docker, "rm", "--force", id).Run()
+		if err != nil {
+			fmt.Printf("failed to remove test database container %s: %v\n", id, err)
+		}
+	}
+
+	out, err = exec.Command(docker, "port", id, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return config, func() {}, fmt.Errorf("failed to find port of test database container: %v", commandError(err))
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSpace(strings.Split(string(out), "\n")[0]))
+	if err != nil {
+		stop()
+		return config, func() {}, fmt.Errorf("unexpected port of test database container %q", out)
+	}
+	config.Host, config.Port = host, port
+	config.ReplicaHost, config.ReplicaPort = "", ""
+
+	// The server restarts once its data directory is initialized, it accepts TCP connections after that
+	deadline := time.Now().Add(TEST_DB_WAIT)
+	for {
+		err = execTestDB(config, "SELECT 1")
+		if err == nil {
+			fmt.Printf("running tests against %s container %.12s on %s:%s\n", image, id, host, port)
+			return config, stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return config, func() {}, fmt.Errorf("test database container not ready after %v: %v", TEST_DB_WAIT, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// commandError adds the output a failed command wrote to stderr to its error
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
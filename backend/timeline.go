@@ -0,0 +1,133 @@
+package main
+
+/*
+	This file implements the photo timeline, the requester's images grouped into days or months
+	by the date they were taken, falling back to the upload date for images without an EXIF date.
+	Each bucket reports its number of images and the most recent images as thumbnails so clients
+	can render long scrolling timelines without listing every image, newest buckets first.
+	A page of buckets is read with a single grouped query.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	TIMELINE_DAY   = "day" // Default grouping
+	TIMELINE_MONTH = "month"
+
+	TIMELINE_PAGE_SIZE = 30 // Buckets per page
+	TIMELINE_PREVIEWS  = 4  // Thumbnails per bucket
+)
+
+// TimelineBucket is a day or month of the timeline
+type TimelineBucket struct {
+	Date       string              `json:"date"` // 2006-01-02 for days, 2006-01 for months
+	Count      int                 `json:"count"`
+	Thumbnails []TimelineThumbnail `json:"thumbnails"` // Most recently taken images first
+}
+
+// TimelineThumbnail references a representative image of a bucket
+type TimelineThumbnail struct {
+	ImageId int32  `json:"imageId"`
+	Url     string `json:"url"` // Variant of the image fitting within THUMB_SIZE
+}
+
+// TimelineResp is a page of timeline buckets
+type TimelineResp struct {
+	Group    string           `json:"group"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+	More     bool             `json:"more"` // Whether older buckets follow this page
+	Buckets  []TimelineBucket `json:"buckets"`
+}
+
+// getTimeline returns a page of the authenticated user's images grouped by the day or month they were taken
+func getTimeline(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to read timeline sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	params := req.URL.Query()
+	group, page, err := parseTimelineParams(params.Get("group"), params.Get("page"))
+	if err != nil {
+		logger.Error("invalid timeline request sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+	strong, err := parseConsistency(params)
+	if err != nil {
+		logger.Error("invalid timeline request sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	buckets, more, err := TimelineBuckets(req.Context(), int32(claims.Uid), group, page, strong)
+	if err != nil {
+		logger.Error("failed to retrieve timeline: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve timeline, try again later"))
+		return
+	}
+
+	js, err := json.Marshal(TimelineResp{
+		Group:    group,
+		Page:     page,
+		PageSize: TIMELINE_PAGE_SIZE,
+		More:     more,
+		Buckets:  buckets,
+	})
+	if err != nil {
+		logger.Error("failed to marshal timeline: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve timeline, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// parseTimelineParams validates the group and page query parameters, empty values use the defaults
+func parseTimelineParams(group string, page string) (string, int, error) {
+	if len(group) == 0 {
+		group = TIMELINE_DAY
+	}
+	if group != TIMELINE_DAY && group != TIMELINE_MONTH {
+		return "", 0, fmt.Errorf("group must be %s or %s", TIMELINE_DAY, TIMELINE_MONTH)
+	}
+
+	if len(page) == 0 {
+		return group, 0, nil
+	}
+	parsed, err := strconv.Atoi(page)
+	if err != nil || parsed < 0 {
+		return "", 0, fmt.Errorf("page must be a non negative integer")
+	}
+	return group, parsed, nil
+}
+
+// timelineDateFormat returns the layout of bucket dates for the grouping
+func timelineDateFormat(group string) string {
+	if group == TIMELINE_MONTH {
+		return "2006-01"
+	}
+	return "2006-01-02"
+}
+
+// thumbnailUrl returns the url of the image variant fitting within THUMB_SIZE
+func thumbnailUrl(ref string) string {
+	return fmt.Sprintf("%s?w=%v&h=%v", ref, THUMB_SIZE, THUMB_SIZE)
+}
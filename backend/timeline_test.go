@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestParseTimelineParams ensures the grouping and page default and invalid values are refused
+func TestParseTimelineParams(t *testing.T) {
+	tt := []struct {
+		Group    string
+		Page     string
+		Expected string
+		Valid    bool
+	}{
+		{"", "", TIMELINE_DAY, true},
+		{"month", "3", TIMELINE_MONTH, true},
+		{"year", "", "", false},
+		{"day", "-1", "", false},
+		{"day", "next", "", false},
+	}
+
+	for _, tc := range tt {
+		group, _, err := parseTimelineParams(tc.Group, tc.Page)
+		if (err == nil) != tc.Valid || group != tc.Expected {
+			t.Errorf("wrong timeline params for %q %q: got %q %v", tc.Group, tc.Page, group, err)
+		}
+	}
+}
+
+// TestTimeline ensures images are bucketed by their EXIF taken date falling back to the upload date
+func TestTimeline(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+	router := configureRoutes()
+
+	jpg := testImage(t, "jpeg", 8)
+	dated := dedupUpload(t, router, token, DEDUP_STORE, withSegment(jpg, exifDateSegment(binary.BigEndian, "2019:07:20 08:00:00", "2019:07:14 18:30:05")), http.StatusOK)
+	undated := uploadTestImage(t, router, token, false)
+
+	timeline := func(query string) TimelineResp {
+		req := httptest.NewRequest("GET", "/timeline"+query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("failed to retrieve timeline %q: got %v", query, rr.Code)
+		}
+		resp := TimelineResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := timeline("")
+	today := time.Now().UTC().Format("2006-01-02")
+	if len(resp.Buckets) != 2 || resp.More || resp.Buckets[0].Date != today || resp.Buckets[1].Date != "2019-07-14" {
+		t.Fatalf("wrong timeline buckets: got %+v", resp)
+	}
+	if resp.Buckets[1].Count != 1 || len(resp.Buckets[1].Thumbnails) != 1 || resp.Buckets[1].Thumbnails[0].ImageId != dated.Id {
+		t.Errorf("wrong dated bucket: got %+v", resp.Buckets[1])
+	}
+	if resp.Buckets[0].Thumbnails[0].ImageId != undated.Id || resp.Buckets[0].Thumbnails[0].Url != thumbnailUrl(undated.Ref) {
+		t.Errorf("wrong undated bucket: got %+v", resp.Buckets[0])
+	}
+
+	resp = timeline("?group=month")
+	if len(resp.Buckets) != 2 || resp.Buckets[1].Date != "2019-07" {
+		t.Errorf("wrong monthly buckets: got %+v", resp)
+	}
+	if resp = timeline("?page=1"); len(resp.Buckets) != 0 {
+		t.Errorf("wrong buckets beyond the last page: got %+v", resp)
+	}
+}
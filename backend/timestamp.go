@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	TIMESTAMP_FORMAT = TIMESTAMP_RFC3339 // Default if TIMESTAMP_FORMAT env variable is not defined
+
+	// Formats of timestamps in API responses
+	TIMESTAMP_RFC3339 = "rfc3339" // RFC 3339 in UTC such as 2021-09-20T05:21:00Z
+	TIMESTAMP_LEGACY  = "legacy"  // Go time.String in the server time zone for clients predating RFC 3339 timestamps
+
+	legacyLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+)
+
+// Timestamp is a time serialized in API responses according to TIMESTAMP_FORMAT
+// every timestamp exposed by the API should use this type so the format is consistent
+type Timestamp time.Time
+
+// MarshalJSON encodes the timestamp as a string in the configured format
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatTimestamp(time.Time(t)))
+}
+
+// UnmarshalJSON decodes a timestamp in either the RFC 3339 or legacy format
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		parsed, err = time.Parse(legacyLayout, value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	*t = Timestamp(parsed)
+	return nil
+}
+
+// Time returns the timestamp as a time.Time
+func (t Timestamp) Time() time.Time {
+	return time.Time(t)
+}
+
+// formatTimestamp formats the time according to the TIMESTAMP_FORMAT environment variable
+func formatTimestamp(t time.Time) string {
+	if os.Getenv("TIMESTAMP_FORMAT") == TIMESTAMP_LEGACY {
+		return t.Round(0).Local().String()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
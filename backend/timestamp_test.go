@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTimestamp ensures timestamps are encoded as RFC 3339 UTC unless the legacy format is configured
+func TestTimestamp(t *testing.T) {
+	zone := time.FixedZone("EDT", -4*60*60)
+	ts := Timestamp(time.Date(2021, 9, 20, 1, 21, 0, 0, zone))
+
+	js, err := json.Marshal(TokenResp{Expiration: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `{"name":"","token":"","expiration":"2021-09-20T05:21:00Z"}` {
+		t.Errorf("wrong encoding: got %s", js)
+	}
+
+	// Both formats decode to the same instant
+	decoded := TokenResp{}
+	if err = json.Unmarshal(js, &decoded); err != nil || !decoded.Expiration.Time().Equal(ts.Time()) {
+		t.Errorf("failed to decode rfc3339 timestamp: got %v %v", decoded.Expiration.Time(), err)
+	}
+
+	os.Setenv("TIMESTAMP_FORMAT", TIMESTAMP_LEGACY)
+	defer os.Unsetenv("TIMESTAMP_FORMAT")
+
+	js, err = json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `"`+ts.Time().Local().String()+`"` {
+		t.Errorf("wrong legacy encoding: got %s", js)
+	}
+	if err = json.Unmarshal(js, &decoded.Expiration); err != nil || !decoded.Expiration.Time().Equal(ts.Time()) {
+		t.Errorf("failed to decode legacy timestamp: got %v %v", decoded.Expiration.Time(), err)
+	}
+
+	if err = json.Unmarshal([]byte(`"tomorrow"`), &decoded.Expiration); err == nil {
+		t.Errorf("expected error for invalid timestamp")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	TITLE_POLICY = TITLE_ALLOW // Default if TITLE_POLICY env variable is not defined
+	TITLE_MAX    = 255         // Characters allowed in an image title before its extension is assigned
+
+	DESCRIPTION_MAX = 2000 // Characters allowed in an image description
+	ALT_TEXT_MAX    = 500  // Characters allowed in the alternative text of an image
+
+	// DESCRIPTION_SEARCH is the indexed text search document of image descriptions, the simple configuration
+	// matches words in any language without stemming
+	DESCRIPTION_SEARCH = "to_tsvector('simple', description)"
+
+	// Policies applied when an image title is already used by another image of the same user
+	TITLE_ALLOW  = "allow"  // Duplicate titles are stored as provided
+	TITLE_REJECT = "reject" // Duplicate titles are refused
+	TITLE_SUFFIX = "suffix" // Duplicate titles are numbered such as photo (2).png
+
+	TITLE_LOCK_CLASS = 1 // First key of the advisory locks claiming titles, the second is the uid
+)
+
+// ErrDuplicateTitle is returned when the reject policy refuses a title
+var ErrDuplicateTitle = errors.New("title is already used by another image")
+
+// cleanText removes control characters and invalid utf-8 from client supplied text, newlines and tabs
+// are kept when multiline is true
+func cleanText(text string, multiline bool) string {
+	return strings.Map(func(r rune) rune {
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// validateTitle checks a client supplied image title before it is used in file names, references or
+// queries. Titles resembling paths or containing null bytes are refused, other control characters
+// and surrounding whitespace are removed. An empty title is returned when nothing is left
+func validateTitle(title string) (string, error) {
+	if strings.ContainsRune(title, 0) {
+		return "", fmt.Errorf("title may not contain null bytes")
+	}
+	if strings.ContainsAny(title, `/\`) || strings.Contains(title, "..") {
+		return "", fmt.Errorf("title may not contain path separators or ..")
+	}
+
+	title = strings.TrimSpace(cleanText(title, false))
+	if utf8.RuneCountInString(title) > TITLE_MAX {
+		return "", fmt.Errorf("title may not exceed %v characters", TITLE_MAX)
+	}
+	return title, nil
+}
+
+// imageText is the client supplied text describing an image
+type imageText struct {
+	Description string // Shown with the image and searched by the description query parameter
+	AltText     string // Read by screen readers in place of the image
+}
+
+// uploadText returns the description and alternative text fields of an upload form
+func uploadText(req *http.Request) imageText {
+	return imageText{Description: req.FormValue("description"), AltText: req.FormValue("altText")}
+}
+
+// validateImageText cleans the description and alternative text of an image, descriptions may span
+// multiple lines while alternative text is a single line. Text exceeding its limit is refused
+func validateImageText(text imageText) (imageText, error) {
+	text.Description = strings.TrimSpace(cleanText(text.Description, true))
+	if utf8.RuneCountInString(text.Description) > DESCRIPTION_MAX {
+		return text, fmt.Errorf("description may not exceed %v characters", DESCRIPTION_MAX)
+	}
+
+	text.AltText = strings.TrimSpace(cleanText(text.AltText, false))
+	if utf8.RuneCountInString(text.AltText) > ALT_TEXT_MAX {
+		return text, fmt.Errorf("altText may not exceed %v characters", ALT_TEXT_MAX)
+	}
+	return text, nil
+}
+
+// filenameTitle returns the title of an upload named by its file name, browsers may send full paths
+// so only the final element is used
+func filenameTitle(filename string) string {
+	return filename[strings.LastIndexAny(filename, `/\`)+1:]
+}
+
+// getTitlePolicy returns the policy defined by the TITLE_POLICY environment variable
+func getTitlePolicy() string {
+	policy := os.Getenv("TITLE_POLICY")
+	if len(policy) == 0 {
+		return TITLE_POLICY
+	}
+	if policy != TITLE_ALLOW && policy != TITLE_REJECT && policy != TITLE_SUFFIX {
+		logger.Warning("unknown TITLE_POLICY %q, using %s", policy, TITLE_POLICY)
+		return TITLE_POLICY
+	}
+	return policy
+}
+
+// resolveTitle applies the title policy to a title for the user's images ignoring the image with id exclude.
+// Returns the title to store or ErrDuplicateTitle. The title isn't claimed, writes apply the policy again
+// with claimTitle so refusing a title early spares the work of a write that would be rolled back
+func (s *Server) resolveTitle(ctx context.Context, uid int32, title string, exclude int32) (string, error) {
+	policy := getTitlePolicy()
+	if policy == TITLE_ALLOW {
+		return title, nil
+	}
+
+	base, ext := splitTitle(title)
+	taken, err := s.store.ImageTitles(ctx, uid, base, ext, exclude)
+	if err != nil {
+		return "", fmt.Errorf("unable to check title: %v", err)
+	}
+	return applyTitlePolicy(policy, title, taken)
+}
+
+// applyTitlePolicy returns the title to store given the titles of the user's other images that may conflict with it
+func applyTitlePolicy(policy string, title string, taken []string) (string, error) {
+	if !containsString(taken, title) {
+		return title, nil
+	}
+
+	if policy == TITLE_REJECT {
+		return "", ErrDuplicateTitle
+	}
+
+	return nextTitle(title, taken), nil
+}
+
+// nextTitle returns the first numbered variant of the title that isn't taken
+func nextTitle(title string, taken []string) string {
+	base, ext := splitTitle(title)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%v)%s", base, n, ext)
+		if !containsString(taken, candidate) {
+			return candidate
+		}
+	}
+}
+
+// splitTitle separates the title into its name and extension including the dot
+func splitTitle(title string) (string, string) {
+	i := strings.LastIndex(title, ".")
+	if i < 0 {
+		return title, ""
+	}
+	return title[:i], title[i:]
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestValidateTitle ensures titles resembling paths are refused and control characters removed
+func TestValidateTitle(t *testing.T) {
+	titleTests := []struct {
+		Title    string
+		Expected string
+		Valid    bool
+	}{
+		{"holiday.png", "holiday.png", true},
+		{"  beach\x1b[31m day\t ", "beach[31m day", true},
+		{"caf\xe9", "caf", true},
+		{"\r\n", "", true},
+		{strings.Repeat("é", TITLE_MAX), strings.Repeat("é", TITLE_MAX), true},
+		{strings.Repeat("a", TITLE_MAX+1), "", false},
+		{"../../etc/passwd", "", false},
+		{"..", "", false},
+		{"photos/holiday.png", "", false},
+		{`C:\photos\holiday.png`, "", false},
+		{"holiday.png\x00.php", "", false},
+	}
+
+	for _, titleTest := range titleTests {
+		got, err := validateTitle(titleTest.Title)
+		if (err == nil) != titleTest.Valid || got != titleTest.Expected {
+			t.Errorf("wrong result for %q: got %q %v want %q", titleTest.Title, got, err, titleTest.Expected)
+		}
+	}
+
+	for filename, expected := range map[string]string{"holiday.png": "holiday.png", "/home/me/holiday.png": "holiday.png", `C:\photos\holiday.png`: "holiday.png", "dir/": ""} {
+		if got := filenameTitle(filename); got != expected {
+			t.Errorf("wrong title for file %q: got %q want %q", filename, got, expected)
+		}
+	}
+}
+
+// TestValidateImageText ensures descriptions keep their lines, alternative text is a single line and both are limited
+func TestValidateImageText(t *testing.T) {
+	text, err := validateImageText(imageText{Description: " Sunset\r\nover the bay\x00 ", AltText: "Orange sky\nabove water "})
+	if err != nil || text.Description != "Sunset\nover the bay" || text.AltText != "Orange skyabove water" {
+		t.Errorf("wrong cleaned text: got %+v %v", text, err)
+	}
+
+	if _, err := validateImageText(imageText{Description: strings.Repeat("é", DESCRIPTION_MAX)}); err != nil {
+		t.Errorf("description at the limit refused: %v", err)
+	}
+	if _, err := validateImageText(imageText{Description: strings.Repeat("a", DESCRIPTION_MAX+1)}); err == nil {
+		t.Errorf("expected error for long description")
+	}
+	if _, err := validateImageText(imageText{AltText: strings.Repeat("a", ALT_TEXT_MAX+1)}); err == nil {
+		t.Errorf("expected error for long alternative text")
+	}
+}
+
+// TestNextTitle ensures duplicate titles receive the first free numbered suffix
+func TestNextTitle(t *testing.T) {
+	titleTests := []struct {
+		Title    string
+		Taken    []string
+		Expected string
+	}{
+		{"photo.png", []string{"photo.png"}, "photo (2).png"},
+		{"photo.png", []string{"photo.png", "photo (2).png", "photo (4).png"}, "photo (3).png"},
+		{"photo (2).png", []string{"photo (2).png"}, "photo (2) (2).png"},
+		{"photo", []string{"photo"}, "photo (2)"},
+	}
+
+	for _, titleTest := range titleTests {
+		if got := nextTitle(titleTest.Title, titleTest.Taken); got != titleTest.Expected {
+			t.Errorf("wrong title for %s: got %s want %s", titleTest.Title, got, titleTest.Expected)
+		}
+	}
+}
+
+// TestTitlePolicy ensures the policy is read from the environment and unknown policies fall back to allow
+func TestTitlePolicy(t *testing.T) {
+	defer os.Unsetenv("TITLE_POLICY")
+
+	for env, expected := range map[string]string{"": TITLE_ALLOW, "reject": TITLE_REJECT, "suffix": TITLE_SUFFIX, "overwrite": TITLE_ALLOW} {
+		os.Setenv("TITLE_POLICY", env)
+		if got := getTitlePolicy(); got != expected {
+			t.Errorf("wrong policy for %q: got %s want %s", env, got, expected)
+		}
+	}
+
+	// The allow policy never queries existing titles
+	os.Setenv("TITLE_POLICY", TITLE_ALLOW)
+	title, err := (&Server{store: sqlStore{}}).resolveTitle(context.Background(), 1, "photo.png", 0)
+	if err != nil || title != "photo.png" {
+		t.Errorf("wrong title with allow policy: got %s %v", title, err)
+	}
+	// Nor locks the titles of the user within the write
+	title, err = claimTitle(nil, 1, "photo.png", 1)
+	if err != nil || title != "photo.png" {
+		t.Errorf("wrong claimed title with allow policy: got %s %v", title, err)
+	}
+}
+
+// TestApplyTitlePolicy ensures taken titles are refused or numbered and free titles kept by every policy
+func TestApplyTitlePolicy(t *testing.T) {
+	taken := []string{"photo.png", "photo (2).png"}
+	tt := []struct {
+		Policy   string
+		Title    string
+		Expected string
+		Err      error
+	}{
+		{TITLE_REJECT, "other.png", "other.png", nil},
+		{TITLE_REJECT, "photo.png", "", ErrDuplicateTitle},
+		{TITLE_SUFFIX, "other.png", "other.png", nil},
+		{TITLE_SUFFIX, "photo.png", "photo (3).png", nil},
+	}
+
+	for _, tc := range tt {
+		title, err := applyTitlePolicy(tc.Policy, tc.Title, taken)
+		if title != tc.Expected || err != tc.Err {
+			t.Errorf("wrong title for %s with %s policy: got %q %v want %q %v", tc.Title, tc.Policy, title, err, tc.Expected, tc.Err)
+		}
+	}
+}
+
+// TestImageText uploads an image with a description and alternative text, updates them and searches the description
+func TestImageText(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	writer.WriteField("description", "Lighthouse at dusk")
+	writer.WriteField("altText", "A white lighthouse against a purple sky")
+	part, _ := writer.CreateFormFile("image", "lighthouse.png")
+	part.Write(testImage(t, "png", 19))
+	writer.Close()
+
+	router := configureRoutes()
+	send := func(method string, path string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Add("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/image", form, writer.FormDataContentType())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to upload image: got %v", rr.Code)
+	}
+	image := Image{}
+	json.Unmarshal(rr.Body.Bytes(), &image)
+	if image.Description != "Lighthouse at dusk" || image.AltText != "A white lighthouse against a purple sky" {
+		t.Errorf("wrong text of upload: got %+v", image)
+	}
+
+	imagePath := strings.TrimPrefix(image.Ref, REF_URL)
+	rr = send("PUT", imagePath, strings.NewReader(`{"description": "Lighthouse on the northern cape"}`), "application/json")
+	json.Unmarshal(rr.Body.Bytes(), &image)
+	if rr.Code != http.StatusOK || image.Description != "Lighthouse on the northern cape" || image.AltText != "A white lighthouse against a purple sky" {
+		t.Errorf("wrong text after update: got %v %+v", rr.Code, image)
+	}
+	if rr := send("PUT", imagePath, strings.NewReader(fmt.Sprintf(`{"altText": %q}`, strings.Repeat("a", ALT_TEXT_MAX+1))), "application/json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for long alternative text: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	for query, expected := range map[string]int{"northern": 1, "cape+lighthouse": 1, "dusk": 0} {
+		rr = send("GET", "/image/meta?consistency=strong&description="+query, nil, "")
+		resp := QueryResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusOK || len(resp.ImageMeta) != expected {
+			t.Errorf("wrong results for description %q: got %v %v want %v", query, rr.Code, len(resp.ImageMeta), expected)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+/*
+	This file implements two-factor authentication with time based one-time passwords (RFC 6238)
+	generated by authenticator apps. Users enroll by adding the secret, usually by scanning the
+	provisioning URI as a QR code, and confirming a code, which enables the second factor and
+	returns single use recovery codes once. Only hashes of recovery codes are stored.
+
+	Once enabled signing in requires a code, sent with the password in the X-TOTP-Code header or
+	afterwards at /auth/totp with the challenge returned in place of the auth token. Each code and
+	challenge is accepted once and attempts are limited per user to slow guessing.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/inflowml/logger"
+)
+
+const (
+	TOTP_TABLE     = "user_totp"
+	RECOVERY_TABLE = "recovery_code"
+
+	TOTP_ISSUER        = "PictoCache"
+	TOTP_PERIOD        = 30 // Seconds each code is valid
+	TOTP_DIGITS        = 6
+	TOTP_SKEW          = 1  // Periods before and after the current period whose codes are accepted for clock drift
+	TOTP_SECRET_BYTES  = 20 // Length of secrets recommended for HMAC-SHA1
+	TOTP_ATTEMPT_LIMIT = 5  // Code attempts per minute for each user
+	TOTP_HEADER        = "X-TOTP-Code"
+
+	TOTP_CHALLENGE_SUBJECT = "totp_challenge" // Subject of the challenges of sign ins awaiting a code
+	TOTP_CHALLENGE_TTL     = 5 * time.Minute
+
+	RECOVERY_CODES = 10 // Recovery codes issued when the second factor is enabled
+	RECOVERY_BYTES = 8  // Random bytes of a recovery code
+
+	AUDIT_TOTP_ENABLE  = "totp_enable"
+	AUDIT_TOTP_DISABLE = "totp_disable"
+)
+
+// totpLimiter limits the code attempts of each user
+var totpLimiter = newSharedRateLimiter("TOTP_ATTEMPT_LIMIT", TOTP_ATTEMPT_LIMIT, TOTP_ATTEMPT_LIMIT)
+
+// UserTotp is the second factor of a user tagged for sql serialization
+type UserTotp struct {
+	Uid      int32     `sql:"id" opt:"PRIMARY KEY"` // Corresponds to User Uid
+	Secret   string    `sql:"secret"`               // Base32 secret shared with the authenticator
+	Enabled  bool      `sql:"enabled"`              // False until a code confirms the enrollment
+	LastStep int64     `sql:"last_step"`            // Period of the last accepted code, codes of earlier periods are refused
+	Created  time.Time `sql:"created"`
+}
+
+// RecoveryCode is the hash of a single use recovery code tagged for sql serialization
+type RecoveryCode struct {
+	Id       int32  `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid      int32  `sql:"uid"`
+	CodeHash string `sql:"code_hash"`
+}
+
+// TotpChallenge is the claims of the challenge of a sign in awaiting a code
+type TotpChallenge struct {
+	Uid    int
+	Action string // Audit action recorded once the sign in completes
+	jwt.StandardClaims
+}
+
+// TotpStatus describes the second factor of the user
+type TotpStatus struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recoveryCodes"` // Unused recovery codes
+}
+
+// TotpEnrollment is the secret of a pending enrollment
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"` // otpauth provisioning URI to display as a QR code
+}
+
+// TotpRequired is returned in place of the auth token when a sign in needs a code
+type TotpRequired struct {
+	TotpRequired bool   `json:"totpRequired"`
+	Challenge    string `json:"challenge"`
+}
+
+// TotpCodeParams carries a code, or a recovery code where accepted
+type TotpCodeParams struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code"`
+}
+
+// totpCode returns the code of the secret for the period
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod)
+}
+
+// totpStep returns the period of the time
+func totpStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// matchTotp returns the period of the code if it is the code of the secret for a period near now
+// after the last accepted period, reporting false otherwise
+func matchTotp(totp UserTotp, code string, now time.Time) (int64, bool) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(totp.Secret)
+	if err != nil || len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		if step > totp.LastStep && hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newTotpSecret returns a random base32 secret
+func newTotpSecret() (string, error) {
+	buf := make([]byte, TOTP_SECRET_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate totp secret: %v", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// totpUri returns the provisioning URI of the secret for the account
+func totpUri(email string, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTP_ISSUER},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(TOTP_DIGITS)},
+		"period":    {strconv.Itoa(TOTP_PERIOD)},
+	}
+	label := url.PathEscape(TOTP_ISSUER + ":" + email)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// newRecoveryCodes returns random recovery codes and the hashes stored in their place
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < RECOVERY_CODES; i++ {
+		buf := make([]byte, RECOVERY_BYTES)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		raw := hex.EncodeToString(buf)
+		code := strings.Join([]string{raw[0:4], raw[4:8], raw[8:12], raw[12:16]}, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes the separators and case of a recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkSecondFactor verifies a code, or recovery code which is used up, of the user's enabled second
+// factor. Reports false for wrong or reused codes and when attempts are exhausted
+func checkSecondFactor(uid int32, code string) (bool, error) {
+	if allowed, _ := totpLimiter.Allow(strconv.Itoa(int(uid))); !allowed {
+		logger.Warning("user %v exceeded totp attempts", uid)
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return UseRecoveryCode(uid, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	totp, found, err := GetUserTotp(uid)
+	if err != nil || !found || !totp.Enabled {
+		return false, err
+	}
+	step, ok := matchTotp(totp, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	// The period is recorded only if no other request used it first so each code works once
+	return UseTotpStep(uid, step)
+}
+
+// requireSecondFactor checks the second factor of a user who has signed in, verifying the code of the
+// X-TOTP-Code header or otherwise responding 401 with a challenge to complete the sign in with at
+// /auth/totp. Reports whether the sign in may complete, a challenge is returned as the totpChallenge
+// query parameter of the redirect if there is one
+func requireSecondFactor(w http.ResponseWriter, req *http.Request, user User, action string, redirect string) bool {
+	totp, found, err := GetUserTotp(user.Uid)
+	if err != nil {
+		logger.Error("Unable to retrieve second factor sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to sign in, try again later"))
+		return false
+	}
+	if !found || !totp.Enabled {
+		return true
+	}
+
+	if code := req.Header.Get(TOTP_HEADER); len(code) > 0 {
+		ok, err := checkSecondFactor(user.Uid, code)
+		if err != nil {
+			logger.Error("Unable to verify second factor sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Unable to sign in, try again later"))
+			return false
+		}
+		if !ok {
+			logger.Error("Invalid second factor code for user %v sending 401", user.Uid)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 - Unauthorized, invalid authentication code"))
+		}
+		return ok
+	}
+
+	challenge, err := signToken(&TotpChallenge{
+		Uid:    int(user.Uid),
+		Action: action,
+		StandardClaims: jwt.StandardClaims{
+			Id:        newTokenId(), // Nonce completing a single sign in
+			Subject:   TOTP_CHALLENGE_SUBJECT,
+			ExpiresAt: time.Now().Add(TOTP_CHALLENGE_TTL).Unix(),
+		},
+	})
+	if err != nil {
+		logger.Error("Failed to sign totp challenge sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to sign in, try again later"))
+		return false
+	}
+
+	logger.Info("Second factor required to sign in user: %v", user.Uid)
+	if len(redirect) > 0 {
+		target, _ := url.Parse(redirect)
+		query := target.Query()
+		query.Set("totpChallenge", challenge)
+		target.RawQuery = query.Encode()
+		http.Redirect(w, req, target.String(), http.StatusSeeOther)
+		return false
+	}
+	writeAlbumJSON(w, http.StatusUnauthorized, TotpRequired{TotpRequired: true, Challenge: challenge})
+	return false
+}
+
+// completeTotpLogin accepts a json body with the challenge of a sign in and a code, or recovery
+// code, and returns the auth token
+func completeTotpLogin(w http.ResponseWriter, req *http.Request) {
+	params := TotpCodeParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil || len(params.Challenge) == 0 || len(params.Code) == 0 {
+		logger.Error("invalid totp login body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - challenge and code are required"))
+		return
+	}
+
+	challenge := TotpChallenge{}
+	parsed, err := jwt.ParseWithClaims(params.Challenge, &challenge, verificationKey)
+	if err != nil || !parsed.Valid || challenge.Subject != TOTP_CHALLENGE_SUBJECT {
+		logger.Error("Invalid totp challenge sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, the sign in expired, sign in again"))
+		return
+	}
+
+	ok, err := checkSecondFactor(int32(challenge.Uid), params.Code)
+	if err != nil {
+		logger.Error("Unable to verify second factor sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Unable to sign in, try again later"))
+		return
+	}
+	if !ok {
+		logger.Error("Invalid second factor code for user %v sending 401", challenge.Uid)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, invalid authentication code"))
+		return
+	}
+
+	// Each challenge completes a single sign in on every replica
+	if !claimNonce("totp", challenge.Id, time.Unix(challenge.ExpiresAt, 0)) {
+		logger.Error("totp challenge of user %v used twice sending 401", challenge.Uid)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, the sign in was already completed, sign in again"))
+		return
+	}
+
+	user, err := GetUserById(int32(challenge.Uid))
+	if err != nil {
+		logger.Error("Unable to retrieve user sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized, unable to verify this login attempt"))
+		return
+	}
+
+	logger.Info("Successfull login with second factor for user: %v", user.Uid)
+	recordAudit(req, int(user.Uid), challenge.Action, 0)
+
+	token, exp, ok := issueToken(w, user)
+	if !ok {
+		return
+	}
+	writeTokenResp(w, token, exp)
+}
+
+// getTotpStatus returns whether the user has enabled the second factor
+func getTotpStatus(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for totp status sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	status := TotpStatus{}
+	totp, found, err := GetUserTotp(int32(claims.Uid))
+	if err == nil && found && totp.Enabled {
+		status.Enabled = true
+		status.RecoveryCodes, err = CountRecoveryCodes(int32(claims.Uid))
+	}
+	if err != nil {
+		logger.Error("failed to retrieve totp status sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve two-factor status, try again later"))
+		return
+	}
+	writeAlbumJSON(w, http.StatusOK, status)
+}
+
+// enrollTotp starts an enrollment and returns the new secret with its provisioning URI, replacing
+// any pending enrollment
+func enrollTotp(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage two-factor authentication")
+	if !ok {
+		return
+	}
+
+	totp, found, err := GetUserTotp(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to enroll, try again later"))
+		return
+	}
+	if found && totp.Enabled {
+		logger.Error("user %v already enabled totp sending 409", claims.Uid)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - Two-factor authentication is already enabled, disable it first"))
+		return
+	}
+
+	totp = UserTotp{Uid: int32(claims.Uid), Created: time.Now().UTC()}
+	totp.Secret, err = newTotpSecret()
+	if err == nil {
+		err = SaveUserTotp(totp)
+	}
+	if err != nil {
+		logger.Error("failed to save totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to enroll, try again later"))
+		return
+	}
+
+	writeAlbumJSON(w, http.StatusCreated, TotpEnrollment{Secret: totp.Secret, Uri: totpUri(claims.Email, totp.Secret)})
+	logger.Info("Started totp enrollment of user %v", claims.Uid)
+}
+
+// verifyTotp accepts a json body with a code of the pending enrollment, enabling the second factor
+// and returning the recovery codes
+func verifyTotp(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage two-factor authentication")
+	if !ok {
+		return
+	}
+
+	params := TotpCodeParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil || len(params.Code) == 0 {
+		logger.Error("invalid totp verification body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - code is required"))
+		return
+	}
+
+	totp, found, err := GetUserTotp(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to verify code, try again later"))
+		return
+	}
+	if !found || totp.Enabled {
+		logger.Error("user %v has no pending totp enrollment sending 409", claims.Uid)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("409 - No pending enrollment, enroll first"))
+		return
+	}
+
+	if allowed, _ := totpLimiter.Allow(strconv.Itoa(claims.Uid)); !allowed {
+		logger.Error("user %v exceeded totp attempts sending 403", claims.Uid)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Invalid authentication code"))
+		return
+	}
+	step, ok := matchTotp(totp, strings.TrimSpace(params.Code), time.Now())
+	if !ok {
+		logger.Error("invalid totp code confirming enrollment of user %v sending 403", claims.Uid)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Invalid authentication code"))
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = EnableUserTotp(int32(claims.Uid), step, hashes)
+	}
+	if err != nil {
+		logger.Error("failed to enable totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to enable two-factor authentication, try again later"))
+		return
+	}
+
+	recordAudit(req, claims.Uid, AUDIT_TOTP_ENABLE, 0)
+	writeAlbumJSON(w, http.StatusOK, map[string][]string{"recoveryCodes": codes})
+	logger.Info("Enabled totp of user %v", claims.Uid)
+}
+
+// disableTotp accepts a json body with a code, or recovery code, and removes the second factor with
+// its recovery codes
+func disableTotp(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage two-factor authentication")
+	if !ok {
+		return
+	}
+
+	params := TotpCodeParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	totp, found, err := GetUserTotp(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to disable two-factor authentication, try again later"))
+		return
+	}
+	if !found {
+		logger.Error("user %v has no totp sending 404", claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Two-factor authentication is not enabled"))
+		return
+	}
+
+	// Pending enrollments never protected the account and are removed without a code
+	if totp.Enabled {
+		ok, err := checkSecondFactor(int32(claims.Uid), params.Code)
+		if err != nil {
+			logger.Error("failed to verify totp code sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to disable two-factor authentication, try again later"))
+			return
+		}
+		if !ok {
+			logger.Error("invalid totp code disabling totp of user %v sending 403", claims.Uid)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 - Invalid authentication code"))
+			return
+		}
+	}
+
+	err = DeleteUserTotp(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to delete totp sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to disable two-factor authentication, try again later"))
+		return
+	}
+
+	if totp.Enabled {
+		recordAudit(req, claims.Uid, AUDIT_TOTP_DISABLE, 0)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Disabled totp of user %v", claims.Uid)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestTotpCode ensures codes match the SHA1 test vectors of RFC 6238 truncated to six digits
+func TestTotpCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for seconds, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	} {
+		if code := totpCode(secret, totpStep(time.Unix(seconds, 0))); code != want {
+			t.Errorf("wrong code at %v: got %v want %v", seconds, code, want)
+		}
+	}
+}
+
+// TestMatchTotp ensures codes of neighbouring periods are accepted once
+func TestMatchTotp(t *testing.T) {
+	secret, err := newTotpSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %v", err)
+	}
+	raw, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	now := time.Now()
+	current := totpStep(now)
+	totp := UserTotp{Secret: secret}
+
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		step, ok := matchTotp(totp, totpCode(raw, current+offset), now)
+		if ok != accepted || (ok && step != current+offset) {
+			t.Errorf("wrong match of code %v periods away: got %v %v", offset, step, ok)
+		}
+	}
+
+	totp.LastStep = current
+	if _, ok := matchTotp(totp, totpCode(raw, current), now); ok {
+		t.Errorf("expected code of the last accepted period to be refused")
+	}
+	if _, ok := matchTotp(totp, totpCode(raw, current+1), now); !ok {
+		t.Errorf("expected code of the next period to be accepted")
+	}
+	if _, ok := matchTotp(totp, "12345", now); ok {
+		t.Errorf("expected short code to be refused")
+	}
+}
+
+// TestTotpUri ensures the provisioning URI names the account and parameters of the secret
+func TestTotpUri(t *testing.T) {
+	uri, err := url.Parse(totpUri("user@mail.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatalf("failed to parse uri: %v", err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/PictoCache:user@mail.com" {
+		t.Errorf("wrong uri label: got %v", uri)
+	}
+	if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != TOTP_ISSUER || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("wrong uri parameters: got %v", query)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil || len(codes) != RECOVERY_CODES || hashes[0] != hashToken(normalizeRecoveryCode(strings.ToUpper(codes[0]))) {
+		t.Errorf("wrong recovery codes: got %v %v", codes, err)
+	}
+}
+
+// TestTotpChallengeToken ensures challenges never act as auth tokens
+func TestTotpChallengeToken(t *testing.T) {
+	challenge, _ := signToken(&TotpChallenge{Uid: 1, StandardClaims: jwt.StandardClaims{Subject: TOTP_CHALLENGE_SUBJECT, ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+	if _, err := tokenPrincipal(challenge); err == nil {
+		t.Errorf("expected challenge to be refused as auth token")
+	}
+}
+
+// TestTotp ensures enabling the second factor requires a code to sign in and codes work once
+func TestTotp(t *testing.T) {
+	t.Parallel()
+	token, _ := getTestToken(t)
+	router := configureRoutes()
+	send := func(method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	signedIn := http.Header{"Authorization": {"Bearer " + token}}
+	login := func(code string) http.Header {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		req.SetBasicAuth(testEmail(t, "user"), userPass)
+		if len(code) > 0 {
+			req.Header.Set(TOTP_HEADER, code)
+		}
+		return req.Header
+	}
+
+	rr := send("POST", "/user/totp", "", signedIn)
+	enrollment := TotpEnrollment{}
+	json.Unmarshal(rr.Body.Bytes(), &enrollment)
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(enrollment.Uri, "otpauth://totp/") {
+		t.Fatalf("failed to enroll: got %v %s", rr.Code, rr.Body.String())
+	}
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	step := totpStep(time.Now())
+
+	// Pending enrollments don't protect the account
+	if rr := send("GET", "/auth", "", login("")); rr.Code != http.StatusOK {
+		t.Errorf("wrong code signing in with pending enrollment: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = send("POST", "/user/totp/verify", `{"code": "`+totpCode(secret, step)+`"}`, signedIn)
+	recovery := map[string][]string{}
+	json.Unmarshal(rr.Body.Bytes(), &recovery)
+	if rr.Code != http.StatusOK || len(recovery["recoveryCodes"]) != RECOVERY_CODES {
+		t.Fatalf("failed to enable totp: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("POST", "/user/totp", "", signedIn); rr.Code != http.StatusConflict {
+		t.Errorf("wrong code enrolling twice: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Signing in returns a challenge in place of the token until a code is given
+	rr = send("GET", "/auth", "", login(""))
+	required := TotpRequired{}
+	json.Unmarshal(rr.Body.Bytes(), &required)
+	if rr.Code != http.StatusUnauthorized || !required.TotpRequired || len(required.Challenge) == 0 || len(rr.Result().Cookies()) > 0 {
+		t.Fatalf("expected challenge signing in: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("GET", "/auth", "", login(totpCode(secret, step+1))); rr.Code != http.StatusOK {
+		t.Errorf("wrong code signing in with code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("GET", "/auth", "", login(totpCode(secret, step+1))); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code signing in with reused code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = send("POST", "/auth/totp", `{"challenge": "`+required.Challenge+`", "code": "`+recovery["recoveryCodes"][0]+`"}`, nil)
+	tokenResp := TokenResp{}
+	json.Unmarshal(rr.Body.Bytes(), &tokenResp)
+	if rr.Code != http.StatusOK || len(tokenResp.Value) == 0 {
+		t.Errorf("failed to complete sign in with recovery code: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = send("GET", "/user/totp", "", signedIn)
+	status := TotpStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if !status.Enabled || status.RecoveryCodes != RECOVERY_CODES-1 {
+		t.Errorf("wrong totp status: got %+v", status)
+	}
+
+	if rr := send("DELETE", "/user/totp", `{"code": "`+recovery["recoveryCodes"][1]+`"}`, signedIn); rr.Code != http.StatusNoContent {
+		t.Errorf("wrong code disabling totp: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := send("GET", "/auth", "", login("")); rr.Code != http.StatusOK {
+		t.Errorf("wrong code signing in after disabling totp: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
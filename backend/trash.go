@@ -0,0 +1,313 @@
+package main
+
+/*
+	This file implements the trash. Deleting an image moves it to the trash instead of destroying
+	it: the image is marked with the moment it was deleted and its file is moved beneath TRASH_DIR
+	in storage, blobs and files still referenced by linked images stay in place. Trashed images are hidden
+	from every endpoint other than the trash, keep counting towards the owner's storage usage and
+	may be restored until the reaper permanently purges them once the retention period passes.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	TRASH_DIR           = "trash"   // Storage key prefix of trashed files
+	TRASH_RETENTION     = 30        // Default days images remain in the trash if the TRASH_RETENTION env variable is not defined
+	TRASH_REAP_INTERVAL = time.Hour // Interval between runs of the reaper
+
+	// NOT_TRASHED selects images that are not in the trash, trashed images have a deleted_at after the zero time
+	NOT_TRASHED = "deleted_at = '0001-01-01'"
+)
+
+// TrashedImage describes an image in the trash
+type TrashedImage struct {
+	Image   Image     `json:"image"`
+	Deleted Timestamp `json:"deleted"`
+	Expires Timestamp `json:"expires"` // Moment the reaper purges the image
+}
+
+// TrashResp is a page of the trash
+type TrashResp struct {
+	Page         int            `json:"page"`
+	PageSize     int            `json:"pageSize"`
+	TotalResults int64          `json:"totalResults"`
+	Images       []TrashedImage `json:"images"`
+}
+
+func init() {
+	RegisterJob("trash-reaper", TRASH_REAP_INTERVAL, reapTrash)
+}
+
+// getTrashRetention returns how long images remain in the trash defined by the TRASH_RETENTION environment variable in days
+func getTrashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION"))
+	if err != nil || days < 0 {
+		days = TRASH_RETENTION
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// trashKey returns the storage key a file is moved to while its image is in the trash
+func trashKey(key string) string {
+	return fmt.Sprintf("%s/%s", TRASH_DIR, key)
+}
+
+// moveObject copies the stored object to a new key, the caller deletes the source once the move is recorded
+func moveObject(ctx context.Context, from string, to string, contentType string) error {
+	object, err := storage.Open(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", from, err)
+	}
+	defer object.Close()
+
+	err = storage.Put(ctx, to, object, object.Size(), contentType)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", to, err)
+	}
+	return nil
+}
+
+// copyToTrash copies the file of the image to the trash and returns the key the trashed image references,
+// blobs keep their content address and files shared with linked images stay in place for them
+func copyToTrash(ctx context.Context, image Image) (string, error) {
+	key := imageKey(image)
+	if isBlobKey(key) {
+		return key, nil
+	}
+
+	refs, err := FileReferences(key)
+	if err != nil {
+		return "", err
+	}
+	if refs > 1 {
+		return key, nil
+	}
+
+	err = moveObject(ctx, key, trashKey(key), image.Encoding)
+	if err != nil {
+		return "", err
+	}
+	return trashKey(key), nil
+}
+
+// trashImage moves the image and, unless it is a blob or linked images still reference it, its file to the trash
+// reporting false if the image was already trashed
+func (s *Server) trashImage(ctx context.Context, image Image) (bool, error) {
+	key := imageKey(image)
+	fileKey, err := copyToTrash(ctx, image)
+	if err != nil {
+		return false, err
+	}
+
+	trashed, err := s.store.TrashImageData(ctx, image.Id, fileKey, time.Now().UTC())
+	if err != nil || !trashed {
+		if fileKey != key {
+			storage.Delete(ctx, fileKey)
+		}
+		return false, err
+	}
+
+	if fileKey != key {
+		err = storage.Delete(ctx, key)
+		if err != nil {
+			logger.Error("failed to delete file of trashed image %v, clean orphaned files via automated data integrity check: %v", image.Id, err)
+		}
+	}
+	return true, nil
+}
+
+// restoreTrashedImage moves the trashed image and its file out of the trash reporting false if it wasn't trashed
+func restoreTrashedImage(ctx context.Context, image Image) (bool, error) {
+	key := image.FileKey
+	if strings.HasPrefix(key, TRASH_DIR+"/") {
+		key = strings.TrimPrefix(key, TRASH_DIR+"/")
+		err := moveObject(ctx, image.FileKey, key, image.Encoding)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	restored, err := RestoreImageData(image.Id, key)
+	if err != nil || !restored {
+		if key != image.FileKey {
+			storage.Delete(ctx, key)
+		}
+		return false, err
+	}
+
+	if key != image.FileKey {
+		err = storage.Delete(ctx, image.FileKey)
+		if err != nil {
+			logger.Error("failed to delete trashed file of restored image %v, clean orphaned files via automated data integrity check: %v", image.Id, err)
+		}
+	}
+	return true, nil
+}
+
+// purgeImage permanently deletes the image metadata and its files
+func purgeImage(ctx context.Context, image Image) error {
+	err := DeleteImageData(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	removeImageFiles(ctx, image)
+	return nil
+}
+
+// reapTrash permanently purges a batch of images trashed longer than the retention period
+func reapTrash(ctx context.Context) error {
+	images, err := ExpiredTrash(time.Now().UTC().Add(-getTrashRetention()), PURGE_BATCH)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve expired trash: %v", err)
+	}
+
+	for _, image := range images {
+		err = purgeImage(ctx, image)
+		if err != nil {
+			return fmt.Errorf("failed to purge image %v: %v", image.Id, err)
+		}
+	}
+	if len(images) > 0 {
+		logger.Info("Purged %v images from the trash", len(images))
+	}
+	return nil
+}
+
+// listTrash returns a page of the authenticated user's trashed images, most recently trashed first
+func listTrash(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for trash sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	page := 0
+	if param := req.URL.Query().Get("page"); len(param) > 0 {
+		page, err = strconv.Atoi(param)
+		if err != nil || page < 0 {
+			logger.Error("invalid trash page %q sending 400", param)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - page must be a non negative integer"))
+			return
+		}
+	}
+
+	images, total, err := TrashedImages(req.Context(), int32(claims.Uid), page)
+	if err != nil {
+		logger.Error("failed to retrieve trash sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve trash, try again later"))
+		return
+	}
+
+	retention := getTrashRetention()
+	resp := TrashResp{Page: page, PageSize: PAGE_SIZE, TotalResults: total, Images: []TrashedImage{}}
+	for _, image := range images {
+		resp.Images = append(resp.Images, TrashedImage{
+			Image:   image,
+			Deleted: Timestamp(image.DeletedAt),
+			Expires: Timestamp(image.DeletedAt.Add(retention)),
+		})
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal trash sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve trash, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// restoreImage moves a trashed image of the authenticated user out of the trash and returns its meta
+func (s *Server) restoreImage(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to restore image sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(strings.TrimSuffix(vars["fileId"], filepath.Ext(vars["fileId"])))
+	uid, uidErr := strconv.Atoi(vars["uid"])
+	if err != nil || uidErr != nil {
+		logger.Error("invalid restore request sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+
+	image, found, err := GetTrashedImage(req.Context(), int32(id))
+	if err != nil {
+		logger.Error("failed to retrieve trashed image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to restore image, try again later"))
+		return
+	}
+
+	// Other users' images and images outside the trash are reported as not found
+	if !found || image.Uid != int32(uid) || image.Uid != int32(claims.Uid) {
+		logger.Error("image %v not in the trash of user %v sending 404", id, claims.Uid)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information in your trash"))
+		return
+	}
+
+	restored, err := restoreTrashedImage(req.Context(), image)
+	if err != nil {
+		logger.Error("failed to restore image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to restore image, try again later"))
+		return
+	}
+	if !restored {
+		logger.Error("image %v restored concurrently sending 404", id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information in your trash"))
+		return
+	}
+	recordAudit(req, claims.Uid, AUDIT_RESTORE, image.Id)
+
+	image, err = s.store.GetImageMeta(req.Context(), image.Id)
+	if err != nil {
+		logger.Error("failed to retrieve restored image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Image restored but its meta could not be retrieved"))
+		return
+	}
+
+	js, err := json.Marshal(image)
+	if err != nil {
+		logger.Error("failed to marshal image sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Image restored but its meta could not be retrieved"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	logger.Info("Restored image %v from the trash", image.Id)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTrashRetention ensures the retention period is configured in days
+func TestTrashRetention(t *testing.T) {
+	defer os.Unsetenv("TRASH_RETENTION")
+
+	if retention := getTrashRetention(); retention != TRASH_RETENTION*24*time.Hour {
+		t.Errorf("wrong default retention: got %v", retention)
+	}
+	os.Setenv("TRASH_RETENTION", "7")
+	if retention := getTrashRetention(); retention != 7*24*time.Hour {
+		t.Errorf("wrong configured retention: got %v", retention)
+	}
+	os.Setenv("TRASH_RETENTION", "-1")
+	if retention := getTrashRetention(); retention != TRASH_RETENTION*24*time.Hour {
+		t.Errorf("wrong retention for invalid configuration: got %v", retention)
+	}
+
+	if key := trashKey("4/12.png"); key != "trash/4/12.png" {
+		t.Errorf("wrong trash key: got %s", key)
+	}
+}
+
+// TestTrash ensures deleted images move to the trash, can be restored and are purged by the reaper
+func TestTrash(t *testing.T) {
+	token, _ := getTestToken(t)
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, false)
+	imagePath := strings.TrimPrefix(image.Ref, REF_URL)
+	restorePath := imagePath + "/restore"
+
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	trash := func() TrashResp {
+		rr := send("GET", "/image/trash")
+		resp := TrashResp{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if rr := send("POST", restorePath); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for restoring image outside the trash: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := send("DELETE", imagePath); rr.Code != http.StatusOK {
+		t.Fatalf("failed to delete image: got %v", rr.Code)
+	}
+	if rr := send("GET", imagePath); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for trashed image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if resp := trash(); resp.TotalResults != 1 || len(resp.Images) != 1 || resp.Images[0].Image.Id != image.Id {
+		t.Fatalf("wrong trash: got %+v", resp)
+	}
+	key := blobKey(image.Hash, image.Encoding)
+	if file, err := storage.Open(context.Background(), key); err != nil {
+		t.Errorf("blob of trashed image not kept in place: %v", err)
+	} else {
+		file.Close()
+	}
+
+	rr := send("POST", restorePath)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to restore image: got %v", rr.Code)
+	}
+	if rr := send("GET", imagePath); rr.Code != http.StatusOK {
+		t.Errorf("wrong code for restored image: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if resp := trash(); resp.TotalResults != 0 {
+		t.Errorf("restored image still in the trash: got %+v", resp)
+	}
+
+	// Images trashed longer than the retention period are purged
+	os.Setenv("TRASH_RETENTION", "0")
+	defer os.Unsetenv("TRASH_RETENTION")
+	refs := blobRefs(t, key)
+	send("DELETE", imagePath)
+	err := reapTrash(context.Background())
+	if err != nil {
+		t.Fatalf("failed to reap trash: %v", err)
+	}
+	if resp := trash(); resp.TotalResults != 0 {
+		t.Errorf("expired image not purged: got %+v", resp)
+	}
+	if remaining := blobRefs(t, key); remaining != refs-1 {
+		t.Errorf("blob of purged image not released: got %v references want %v", remaining, refs-1)
+	}
+}
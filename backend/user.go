@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/inflowml/logger"
+)
+
+// getUser returns the profile of the authenticated user
+func getUser(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for user sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	user, err := GetUserById(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve user sending 404: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found"))
+		return
+	}
+
+	writeUser(w, user)
+}
+
+// updateUser accepts a json body with any of firstname, lastname, email and username
+// and updates the profile of the authenticated user
+func updateUser(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to update user sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	// decode json message into string map
+	// string map must be used to account for empty values
+	var newParams map[string]string
+	err = json.NewDecoder(req.Body).Decode(&newParams)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+
+	user, err := GetUserById(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve user sending 404: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found"))
+		return
+	}
+
+	// Names may be updated but not cleared
+	for key, field := range map[string]*string{"firstname": &user.Firstname, "lastname": &user.Lastname} {
+		if value, ok := newParams[key]; ok {
+			value = strings.TrimSpace(value)
+			if len(value) == 0 {
+				logger.Error("empty %s sending 400", key)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("400 - %s cannot be empty", key)))
+				return
+			}
+			*field = value
+		}
+	}
+
+	// A new email must be valid and not registered to another account
+	if email, ok := newParams["email"]; ok && email != user.Email {
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			logger.Error("invalid email sending 400: %v", email)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - Invalid email address"))
+			return
+		}
+
+		emailUnique, err := UniqueEmail(email)
+		if err != nil {
+			logger.Error("Unable to validate email sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update account try again later"))
+			return
+		}
+		if !emailUnique {
+			logger.Error("Email already exists sending 400")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - That email already exists, use a different email"))
+			return
+		}
+		user.Email = email
+	}
+
+	// A username must be valid and not chosen by another account regardless of case, an empty username clears it
+	if username, ok := newParams["username"]; ok && username != user.Username {
+		if !validUsername(username) {
+			logger.Error("invalid username sending 400: %v", username)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - username must be 3 to 30 letters, digits or underscores"))
+			return
+		}
+
+		usernameUnique, err := UniqueUsername(username, user.Uid)
+		if err != nil {
+			logger.Error("Unable to validate username sending 500: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Failed to update account try again later"))
+			return
+		}
+		if !usernameUnique {
+			logger.Error("Username already exists sending 409")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("409 - That username is taken, choose a different username"))
+			return
+		}
+		user.Username = username
+	}
+
+	err = UpdateUserData(user)
+	if err != nil {
+		logger.Error("failed to update user sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to update account try again later"))
+		return
+	}
+
+	writeUser(w, user)
+	logger.Info("Successfully updated user: %v", user.Uid)
+}
+
+// writeUser writes the user profile as the json response body
+func writeUser(w http.ResponseWriter, user User) {
+	js, err := json.Marshal(user)
+	if err != nil {
+		logger.Error("failed to marshal json sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Something went wrong on our end"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
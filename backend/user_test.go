@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUserProfile retrieves and updates the profile of the test user
+func TestUserProfile(t *testing.T) {
+	t.Parallel()
+
+	token, uid := getTestToken(t)
+
+	router := configureRoutes()
+
+	user := getTestProfile(t, router, token)
+	if int(user.Uid) != uid || user.Email != testEmail(t, "user") {
+		t.Errorf("wrong profile returned: got %+v", user)
+	}
+
+	tt := []struct {
+		Body     string
+		Expected int
+	}{
+		{`{"firstname": "Updated", "lastname": "Name"}`, http.StatusOK},
+		{`{"firstname": " "}`, http.StatusBadRequest},
+		{`{"email": "not an email"}`, http.StatusBadRequest},
+		{fmt.Sprintf(`{"email": %q}`, testEmail(t, "user")), http.StatusOK},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		req, _ := http.NewRequest("PUT", "/user", strings.NewReader(tc.Body))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.Expected {
+			t.Errorf("handler returned wrong code for %s: got %v want %v", tc.Body, status, tc.Expected)
+		}
+	}
+
+	user = getTestProfile(t, router, token)
+	if user.Firstname != "Updated" || user.Lastname != "Name" || user.Email != testEmail(t, "user") {
+		t.Errorf("profile not updated: got %+v", user)
+	}
+}
+
+// getTestProfile retrieves the profile of the token owner
+func getTestProfile(t *testing.T, router http.Handler, token string) User {
+	req, _ := http.NewRequest("GET", "/user", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong code for user: got %v want %v", status, http.StatusOK)
+	}
+
+	user := User{}
+	err := json.Unmarshal(rr.Body.Bytes(), &user)
+	if err != nil {
+		t.Fatalf("failed to unmarshal user: %v", err)
+	}
+	return user
+}
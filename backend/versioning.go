@@ -0,0 +1,109 @@
+package main
+
+/*
+	This file versions the API so breaking changes can be rolled out without breaking existing
+	clients. Every endpoint of the API is served under the prefix of its version, /api/v1, while
+	service endpoints such as health checks, metrics and the changelog stay at the root. A later
+	version is added as a router of its own mounted beside /api/v1 and registering only the routes
+	it changes or adds.
+
+	The routes of version 1 are also served at the root as aliases for clients predating versioning.
+	Their responses are deprecated in favour of the versioned path and announce the date set by
+	LEGACY_ROUTES_SUNSET, once it passes the aliases are removed. Links in responses, such as status
+	urls, guest links and login callbacks, keep the version of the request so clients never switch
+	between the two. Image refs are stored when images are saved and keep their unversioned form.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	API_V1_PREFIX = "/api/v1"
+
+	LEGACY_DEPRECATED = "2026-10-16" // Day the unversioned aliases were deprecated
+)
+
+// apiPath returns the path of the request relative to the version of the API it was made to,
+// paths of unversioned aliases and service endpoints are returned unchanged
+func apiPath(path string) string {
+	if rest := strings.TrimPrefix(path, API_V1_PREFIX); rest != path && strings.HasPrefix(rest, "/") {
+		return rest
+	}
+	return path
+}
+
+// versionPrefix returns the prefix of the API version the request was made to, empty for
+// unversioned aliases
+func versionPrefix(req *http.Request) string {
+	if apiPath(req.URL.Path) != req.URL.Path {
+		return API_V1_PREFIX
+	}
+	return ""
+}
+
+// legacySunset returns the moment the unversioned aliases are removed, defined by the
+// LEGACY_ROUTES_SUNSET environment variable as YYYY-MM-DD, zero if not scheduled
+func legacySunset() time.Time {
+	raw := os.Getenv("LEGACY_ROUTES_SUNSET")
+	if len(raw) == 0 {
+		return time.Time{}
+	}
+	sunset, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		logger.Warning("ignoring invalid LEGACY_ROUTES_SUNSET %q, use YYYY-MM-DD", raw)
+		return time.Time{}
+	}
+	return sunset
+}
+
+// legacyAliases is router middleware deprecating the unversioned aliases of version 1 in favour
+// of the same path under API_V1_PREFIX
+func legacyAliases(next http.Handler) http.Handler {
+	deprecated, _ := time.Parse("2006-01-02", LEGACY_DEPRECATED)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecated.Unix()))
+		if sunset := legacySunset(); !sunset.IsZero() {
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", API_V1_PREFIX, req.URL.EscapedPath()))
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// useMiddleware applies the middleware shared by every version of the API to one of the routers
+// of the root router
+func useMiddleware(root *mux.Router, router *mux.Router) {
+	// Apply the cross-origin policy to every response including refusals and answer preflights
+	router.Use(corsPolicy(root))
+
+	// Announce the deprecation of deprecated routes on every response including refusals
+	router.Use(deprecationHeaders)
+
+	// Compress text responses for clients accepting gzip or deflate
+	router.Use(compressResponses)
+
+	// Apply the deadline requested by the client to everything done for the request
+	router.Use(requestDeadline)
+
+	// Refuse changes while the instance is read-only
+	router.Use(rejectWrites)
+
+	// Limit request rates per address and user before credentials are resolved or handlers run. The limit
+	// follows the middleware above, none of which reaches the database, so refusals carry its headers
+	router.Use(rateLimit)
+
+	// Resolve the credentials of each request to the principal handlers act for
+	router.Use(authenticate)
+
+	// Requests made by clients are rate limited and restricted to the scopes granted by the user
+	router.Use(authorizeClients)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestApiPath ensures paths are made relative to their API version
+func TestApiPath(t *testing.T) {
+	tt := map[string]string{
+		"/api/v1/image/trash": "/image/trash",
+		"/api/v1/auth":        "/auth",
+		"/image/trash":        "/image/trash",
+		"/api/v1":             "/api/v1",
+		"/api/v10/auth":       "/api/v10/auth",
+		"/api/changelog":      "/api/changelog",
+	}
+	for path, expected := range tt {
+		if got := apiPath(path); got != expected {
+			t.Errorf("wrong api path of %s: got %s want %s", path, got, expected)
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		if prefix := versionPrefix(req); (prefix == API_V1_PREFIX) != (expected != path) {
+			t.Errorf("wrong version prefix of %s: got %q", path, prefix)
+		}
+	}
+}
+
+// TestVersionedRoutes ensures the API is served under /api/v1 and by deprecated unversioned aliases
+// while service endpoints stay at the root
+func TestVersionedRoutes(t *testing.T) {
+	defer os.Unsetenv("LEGACY_ROUTES_SUNSET")
+	os.Setenv("LEGACY_ROUTES_SUNSET", "2027-04-01")
+	router := configureRoutes()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/image/trash", nil))
+	if rr.Code != http.StatusUnauthorized || len(rr.Header().Get("Deprecation")) > 0 {
+		t.Errorf("wrong response of versioned route: got %v %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/image/trash", nil))
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Deprecation") != "@1792108800" || rr.Header().Get("Sunset") != "Thu, 01 Apr 2027 00:00:00 GMT" ||
+		rr.Header().Get("Link") != `</api/v1/image/trash>; rel="successor-version"` {
+		t.Errorf("wrong response of unversioned alias: got %v %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("OPTIONS", "/api/v1/user", nil))
+	if allow := rr.Header().Get("Allow"); allow != "GET, PUT, OPTIONS" {
+		t.Errorf("wrong methods of versioned route: got %q", allow)
+	}
+
+	for path, expected := range map[string]int{"/healthz": http.StatusOK, "/api/v1/healthz": http.StatusNotFound, "/api/v1/missing": http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != expected || len(rr.Header().Get("Deprecation")) > 0 {
+			t.Errorf("wrong response of %s: got %v want %v", path, rr.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+/*
+	This file counts image views and downloads. Requests are tallied in memory and added to the
+	image_view totals and the image_view_day rows of the current day periodically so popular
+	images don't contend on a row per request. Totals are reported in the image metadata and used
+	to order background work such as re-encode campaigns by popularity, owners read the daily
+	series at /image/{uid}/{fileId}/stats. Read-only instances can't write the counts so their
+	requests are not counted.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	IMAGE_VIEW_TABLE     = "image_view"
+	IMAGE_VIEW_DAY_TABLE = "image_view_day"
+	VIEW_FLUSH_INTERVAL  = time.Minute // Interval at which tallied views are written to the database
+
+	STATS_DAYS     = 30  // Days of the stats series by default
+	STATS_DAYS_MAX = 366 // Most days of a stats series
+)
+
+// ImageView is the number of times an image was viewed and downloaded tagged for sql serialization
+type ImageView struct {
+	ImageId   int32 `sql:"image_id" opt:"PRIMARY KEY"`
+	Views     int64 `sql:"views"`
+	Downloads int64 `sql:"downloads" opt:"NOT NULL DEFAULT 0"`
+}
+
+// ImageViewDay is the number of times an image was viewed and downloaded during a UTC day tagged for sql serialization
+type ImageViewDay struct {
+	Id        int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	ImageId   int32     `sql:"image_id"`
+	Day       time.Time `sql:"day"` // Midnight UTC starting the day
+	Views     int64     `sql:"views"`
+	Downloads int64     `sql:"downloads"`
+}
+
+// StatsDay is a day of the stats series
+type StatsDay struct {
+	Date      string `json:"date"` // 2006-01-02
+	Views     int64  `json:"views"`
+	Downloads int64  `json:"downloads"`
+}
+
+// ImageStatsResp reports the total views and downloads of an image and their daily series oldest first
+type ImageStatsResp struct {
+	ImageId   int32      `json:"imageId"`
+	Views     int64      `json:"views"`
+	Downloads int64      `json:"downloads"`
+	Days      []StatsDay `json:"days"`
+}
+
+// viewTally holds the views counted since they were last written
+type viewTally struct {
+	lock   sync.Mutex
+	counts map[int32]int64
+}
+
+// pendingViews and pendingDownloads are the requests waiting to be written
+var (
+	pendingViews     = &viewTally{counts: map[int32]int64{}}
+	pendingDownloads = &viewTally{counts: map[int32]int64{}}
+)
+
+// add counts views of the image
+func (t *viewTally) add(imageId int32, views int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.counts[imageId] += views
+}
+
+// take returns the counted views and resets the tally
+func (t *viewTally) take() map[int32]int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	counts := t.counts
+	t.counts = map[int32]int64{}
+	return counts
+}
+
+// countView counts a view of the image
+func countView(imageId int32) {
+	if readOnly() {
+		return
+	}
+	pendingViews.add(imageId, 1)
+}
+
+// countDownload counts a download of the image
+func countDownload(imageId int32) {
+	if readOnly() {
+		return
+	}
+	pendingDownloads.add(imageId, 1)
+}
+
+// downloadRequested reports whether the request asks for the image as an attachment with download=true
+func downloadRequested(req *http.Request) bool {
+	download, err := strconv.ParseBool(req.URL.Query().Get("download"))
+	return err == nil && download
+}
+
+// countImageRequest counts a GET of the image as a download when requested as an attachment, otherwise as a view,
+// downloads are served with a Content-Disposition naming the file after the image title
+// HEAD requests only inspect the image and aren't counted
+func countImageRequest(w http.ResponseWriter, req *http.Request, imageMeta Image) {
+	if downloadRequested(req) {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": imageMeta.Title}))
+	}
+	if req.Method != "GET" {
+		return
+	}
+	if downloadRequested(req) {
+		countDownload(imageMeta.Id)
+		return
+	}
+	countView(imageMeta.Id)
+}
+
+// flushViews writes the tallied views and downloads to the current day, requests that fail to be written are kept for the next flush
+func flushViews() {
+	views := pendingViews.take()
+	downloads := pendingDownloads.take()
+	if len(views) == 0 && len(downloads) == 0 {
+		return
+	}
+
+	err := AddImageViews(time.Now(), views, downloads)
+	if err != nil {
+		logger.Error("failed to record image views, retrying later: %v", err)
+		for imageId, count := range views {
+			pendingViews.add(imageId, count)
+		}
+		for imageId, count := range downloads {
+			pendingDownloads.add(imageId, count)
+		}
+	}
+}
+
+// startViewCounter writes tallied views every VIEW_FLUSH_INTERVAL until the context is cancelled
+// every instance writes its own views so the counter doesn't depend on the scheduler
+func startViewCounter(ctx context.Context) {
+	if readOnly() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(VIEW_FLUSH_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				flushViews()
+				return
+			case <-ticker.C:
+				flushViews()
+			}
+		}
+	}()
+}
+
+// viewDay returns midnight UTC starting the day of t
+func viewDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// parseStatsDays validates the days query parameter, empty values use STATS_DAYS
+func parseStatsDays(value string) (int, error) {
+	if len(value) == 0 {
+		return STATS_DAYS, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 || days > STATS_DAYS_MAX {
+		return 0, fmt.Errorf("days must be an integer from 1 to %v", STATS_DAYS_MAX)
+	}
+	return days, nil
+}
+
+// statsSeries returns the days ending with the day of now oldest first, days without recorded requests are zero
+func statsSeries(now time.Time, days int, recorded []ImageViewDay) []StatsDay {
+	byDay := map[string]ImageViewDay{}
+	for _, day := range recorded {
+		byDay[viewDay(day.Day).Format("2006-01-02")] = day
+	}
+
+	series := []StatsDay{}
+	today := viewDay(now)
+	for i := days - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		series = append(series, StatsDay{Date: date, Views: byDay[date].Views, Downloads: byDay[date].Downloads})
+	}
+	return series
+}
+
+// getImageStats returns the views and downloads of an image of the authenticated user per day,
+// counts lag behind requests by up to VIEW_FLUSH_INTERVAL
+func (s *Server) getImageStats(w http.ResponseWriter, req *http.Request) {
+	// Authenticate user
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request for image stats sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	image, err := s.validateVars(req.Context(), mux.Vars(req))
+	if err != nil && !strings.Contains(err.Error(), "404 - Not found") {
+		logger.Error("Failed to validate vars sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request unable to parse url parameters"))
+		return
+	}
+	// Stats of other users' images are reported as not found
+	if err != nil || image.Uid != int32(claims.Uid) {
+		logger.Error("image stats not available to user %v sending 404: %v", claims.Uid, err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no image with that information available"))
+		return
+	}
+
+	days, err := parseStatsDays(req.URL.Query().Get("days"))
+	if err != nil {
+		logger.Error("invalid stats days sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	now := time.Now()
+	recorded, err := ImageViewDays(image.Id, viewDay(now).AddDate(0, 0, 1-days))
+	if err != nil {
+		logger.Error("failed to retrieve image stats sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve stats, try again later"))
+		return
+	}
+
+	resp := ImageStatsResp{ImageId: image.Id, Views: image.ViewCount, Downloads: image.DownloadCount, Days: statsSeries(now, days, recorded)}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("failed to marshal image stats sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve stats, try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestViewTally ensures views are summed per image until taken and not counted on read-only instances
+func TestViewTally(t *testing.T) {
+	defer func(tally *viewTally) { pendingViews = tally }(pendingViews)
+	pendingViews = &viewTally{counts: map[int32]int64{}}
+
+	countView(3)
+	countView(3)
+	countView(5)
+	pendingViews.add(5, 10)
+	if counts := pendingViews.take(); !reflect.DeepEqual(counts, map[int32]int64{3: 2, 5: 11}) {
+		t.Errorf("wrong views: got %v", counts)
+	}
+	if counts := pendingViews.take(); len(counts) != 0 {
+		t.Errorf("expected views to be reset: got %v", counts)
+	}
+
+	os.Setenv("READ_ONLY", "true")
+	defer os.Unsetenv("READ_ONLY")
+	countView(3)
+	if counts := pendingViews.take(); len(counts) != 0 {
+		t.Errorf("expected views of read-only instance not to be counted: got %v", counts)
+	}
+}
+
+// TestCountImageRequest ensures downloads are served as attachments and tallied apart from views
+func TestCountImageRequest(t *testing.T) {
+	defer func(views *viewTally, downloads *viewTally) { pendingViews, pendingDownloads = views, downloads }(pendingViews, pendingDownloads)
+	pendingViews = &viewTally{counts: map[int32]int64{}}
+	pendingDownloads = &viewTally{counts: map[int32]int64{}}
+
+	image := Image{Id: 7, Title: "beach day.png"}
+	rr := httptest.NewRecorder()
+	countImageRequest(rr, httptest.NewRequest("GET", "/image/1/abc?download=true", nil), image)
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="beach day.png"` {
+		t.Errorf("wrong Content-Disposition: got %q", disposition)
+	}
+	countImageRequest(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/image/1/abc?download=true", nil), image)
+	countImageRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/image/1/abc", nil), image)
+	rr = httptest.NewRecorder()
+	countImageRequest(rr, httptest.NewRequest("GET", "/image/1/abc?download=no", nil), image)
+	if disposition := rr.Header().Get("Content-Disposition"); len(disposition) > 0 {
+		t.Errorf("unexpected Content-Disposition for a view: got %q", disposition)
+	}
+
+	if views := pendingViews.take(); !reflect.DeepEqual(views, map[int32]int64{7: 2}) {
+		t.Errorf("wrong views: got %v", views)
+	}
+	if downloads := pendingDownloads.take(); !reflect.DeepEqual(downloads, map[int32]int64{7: 1}) {
+		t.Errorf("wrong downloads: got %v", downloads)
+	}
+}
+
+// TestStatsSeries ensures the series covers each day up to today oldest first with missing days at zero
+func TestStatsSeries(t *testing.T) {
+	now := time.Date(2026, 3, 2, 15, 4, 5, 0, time.UTC)
+	recorded := []ImageViewDay{
+		{Day: time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC), Views: 4, Downloads: 1},
+		{Day: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Views: 2},
+	}
+	want := []StatsDay{{"2026-02-27", 0, 0}, {"2026-02-28", 4, 1}, {"2026-03-01", 0, 0}, {"2026-03-02", 2, 0}}
+	if series := statsSeries(now, 4, recorded); !reflect.DeepEqual(series, want) {
+		t.Errorf("wrong series: got %v want %v", series, want)
+	}
+
+	for value, valid := range map[string]bool{"": true, "1": true, "366": true, "0": false, "367": false, "week": false} {
+		if _, err := parseStatsDays(value); (err == nil) != valid {
+			t.Errorf("wrong validation of days %q: got %v", value, err)
+		}
+	}
+}
+
+// TestImageStats ensures owners read the recorded views and downloads of their images
+func TestImageStats(t *testing.T) {
+	t.Parallel()
+
+	token, _ := getTestToken(t)
+
+	friend := createTestUser(t, "statsviewer")
+	friendToken, _, err := generateJWT(int(friend.Uid), friend.Email)
+	if err != nil {
+		t.Fatalf("failed to generate friend jwt token: %v", err)
+	}
+
+	router := configureRoutes()
+	image := uploadTestImage(t, router, token, true)
+	defer DeleteImageData(context.Background(), image)
+	statsPath := strings.TrimPrefix(image.Ref, REF_URL) + "/stats"
+
+	now := time.Now()
+	err = AddImageViews(now.AddDate(0, 0, -1), map[int32]int64{image.Id: 3}, nil)
+	if err == nil {
+		err = AddImageViews(now, map[int32]int64{image.Id: 2}, map[int32]int64{image.Id: 1})
+	}
+	if err == nil {
+		err = AddImageViews(now, map[int32]int64{image.Id: 1}, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to add image views: %v", err)
+	}
+
+	send := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send(statsPath, friendToken); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong code for stats of another user's image: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send(statsPath+"?days=0", token); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code for invalid days: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := send(statsPath+"?days=2", token)
+	stats := ImageStatsResp{}
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	want := []StatsDay{{viewDay(now).AddDate(0, 0, -1).Format("2006-01-02"), 3, 0}, {viewDay(now).Format("2006-01-02"), 3, 1}}
+	if rr.Code != http.StatusOK || stats.Views != 6 || stats.Downloads != 1 || !reflect.DeepEqual(stats.Days, want) {
+		t.Errorf("wrong stats: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = send(strings.TrimPrefix(image.Ref, REF_URL)+"?meta=true", token)
+	meta := Image{}
+	json.Unmarshal(rr.Body.Bytes(), &meta)
+	if meta.ViewCount != 6 || meta.DownloadCount != 1 {
+		t.Errorf("wrong counts in metadata: got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+/*
+	This file serves the web client so the server can be deployed without a separate web server.
+	When web.dir is configured the built client in that directory is served at the root: paths
+	naming a file serve it and other page navigations, GET requests accepting text/html without a
+	file extension, receive index.html so the client can route them itself. API routes take
+	precedence, client routes must not collide with the unversioned aliases of /api/v1 such as
+	/image or /album. index.html must be revalidated so new deployments are picked up at once.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/inflowml/logger"
+)
+
+const (
+	WEB_INDEX = "index.html"
+)
+
+// WebConfig names the directory of the built web client
+type WebConfig struct {
+	Dir string `yaml:"dir"` // Empty serves no web client
+}
+
+// validate returns a problem when the directory doesn't hold a built client
+func (c WebConfig) validate() []string {
+	if len(c.Dir) == 0 {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(c.Dir, WEB_INDEX))
+	if err != nil || info.IsDir() {
+		return []string{fmt.Sprintf("web.dir (WEB_DIR) must be a directory containing %s, got %q", WEB_INDEX, c.Dir)}
+	}
+	return nil
+}
+
+// webConfig is the web client served by the router, none until configureWeb is called
+var (
+	webConfig     WebConfig
+	webConfigLock sync.RWMutex
+)
+
+// configureWeb sets the web client served by routers configured afterwards
+func configureWeb(config WebConfig) {
+	webConfigLock.Lock()
+	defer webConfigLock.Unlock()
+	webConfig = config
+}
+
+// webDir returns the directory of the web client, empty when none is served
+func webDir() string {
+	webConfigLock.RLock()
+	defer webConfigLock.RUnlock()
+	return webConfig.Dir
+}
+
+// webClient returns the handler of paths without an API route serving the files of the web client in dir
+func webClient(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := path.Clean("/" + req.URL.Path)
+		if req.Method != "GET" && req.Method != "HEAD" {
+			http.NotFound(w, req)
+			return
+		}
+		if name != "/" && serveWebFile(w, req, dir, name) {
+			return
+		}
+		// Probes and stale asset urls are ordinary 404s and aren't logged
+		if !clientRoute(req, name) {
+			http.NotFound(w, req)
+			return
+		}
+		serveWebIndex(w, req, dir)
+	})
+}
+
+// clientRoute reports whether the request navigates to a page routed by the web client,
+// paths with a file extension and under the API prefix are never pages
+func clientRoute(req *http.Request, name string) bool {
+	if len(path.Ext(name)) > 0 || name == API_V1_PREFIX || strings.HasPrefix(name, API_V1_PREFIX+"/") {
+		return false
+	}
+	return acceptsType(req.Header.Get("Accept"), "text/html")
+}
+
+// serveWebIndex serves the index of the web client, revalidated on every load
+func serveWebIndex(w http.ResponseWriter, req *http.Request, dir string) {
+	w.Header().Set("Cache-Control", CACHE_REVALIDATE)
+	if !serveWebFile(w, req, dir, "/"+WEB_INDEX) {
+		w.Header().Del("Cache-Control")
+		logger.Error("web client index missing from %s sending 404", dir)
+		http.NotFound(w, req)
+	}
+}
+
+// serveWebFile serves the file at name in dir reporting false if there is no such file,
+// directories and hidden files are never served
+func serveWebFile(w http.ResponseWriter, req *http.Request, dir string, name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+
+	file, err := http.Dir(dir).Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	// ServeContent sets the Content-Type from the extension and handles conditional and range requests
+	http.ServeContent(w, req, info.Name(), info.ModTime(), file)
+	return true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWebClient ensures files of the web client are served, page navigations receive the index
+// and API routes keep precedence
+func TestWebClient(t *testing.T) {
+	dir := t.TempDir()
+
+	index := "<!doctype html><title>Picto</title>"
+	os.Mkdir(filepath.Join(dir, "assets"), 0700)
+	for name, content := range map[string]string{WEB_INDEX: index, "assets/app.js": "console.log(1)", ".env": "SECRET=1"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if problems := (WebConfig{Dir: dir}).validate(); len(problems) > 0 {
+		t.Errorf("unexpected problems with the web client: %v", problems)
+	}
+	if problems := (WebConfig{Dir: filepath.Join(dir, "assets")}).validate(); len(problems) != 1 {
+		t.Errorf("expected a problem without %s: got %v", WEB_INDEX, problems)
+	}
+
+	configureWeb(WebConfig{Dir: dir})
+	defer configureWeb(WebConfig{})
+	router := configureRoutes()
+
+	tt := []struct {
+		Method string
+		Path   string
+		Accept string
+		Code   int
+		Body   string
+		Type   string
+	}{
+		{"GET", "/", "text/html", http.StatusOK, index, "text/html"},
+		{"GET", "/albums/3", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, index, "text/html"},
+		{"HEAD", "/settings", "text/html", http.StatusOK, "", "text/html"},
+		{"GET", "/assets/app.js", "*/*", http.StatusOK, "console.log(1)", "text/javascript"},
+		{"GET", "/albums/3", "application/json", http.StatusNotFound, "", ""},
+		{"GET", "/assets/missing.js", "text/html", http.StatusNotFound, "", ""},
+		{"GET", "/api/v1/missing", "text/html", http.StatusNotFound, "", ""},
+		{"GET", "/.env", "*/*", http.StatusNotFound, "", ""},
+		{"POST", "/albums", "text/html", http.StatusNotFound, "", ""},
+		{"GET", "/ping", "text/html", http.StatusOK, `{"message":"pong"}`, "application/json"},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.Method, tc.Path, nil)
+		req.Header.Set("Accept", tc.Accept)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.Code {
+			t.Errorf("wrong status of %s %s: got %v want %v", tc.Method, tc.Path, rr.Code, tc.Code)
+			continue
+		}
+		if tc.Code != http.StatusOK {
+			continue
+		}
+		if rr.Body.String() != tc.Body || !strings.HasPrefix(rr.Header().Get("Content-Type"), tc.Type) {
+			t.Errorf("wrong response of %s %s: got %q %v", tc.Method, tc.Path, rr.Body.String(), rr.Header())
+		}
+		if tc.Body == index && rr.Header().Get("Cache-Control") != CACHE_REVALIDATE {
+			t.Errorf("index of %s not revalidated: got %v", tc.Path, rr.Header())
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+/*
+	This file implements webhook subscriptions so integrations learn about changes to a user's
+	images without polling. Users register callback urls at /user/webhooks for the lifecycle events
+	of their images: added, updated, shared, unshared, deleted and restored. Each event is queued in
+	the outbox once per subscribed webhook and posted by the outbox dispatcher, failed deliveries
+	are retried with its exponential backoff until OUTBOX_MAX_ATTEMPTS. Bodies are signed with the
+	secret returned when the webhook is created: the X-Picto-Signature header holds the hex HMAC-SHA256
+	of the X-Picto-Timestamp header, a dot and the body. Callbacks to loopback and private addresses
+	are refused unless WEBHOOK_ALLOW_PRIVATE is set so webhooks can't probe the server's network.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/inflowml/logger"
+)
+
+const (
+	WEBHOOK_TABLE = "webhook"
+
+	WEBHOOK_MAX            = 10   // Webhooks a user may have at once
+	WEBHOOK_URL_MAX        = 2048 // Characters allowed in a callback url
+	WEBHOOK_SECRET_PREFIX  = "whsec_"
+	WEBHOOK_SECRET_BYTES   = 32
+	WEBHOOK_TIMEOUT        = 5 * time.Second // Time a callback may take to respond
+	WEBHOOK_SIGNATURE      = "X-Picto-Signature"
+	WEBHOOK_TIMESTAMP      = "X-Picto-Timestamp"
+	WEBHOOK_EVENT_HEADER   = "X-Picto-Event"
+	WEBHOOK_DELIVERY       = "X-Picto-Delivery"
+	WEBHOOK_RESPONSE_LIMIT = 4096 // Bytes of a callback response read before the connection is released
+
+	// Event topics
+	EVENT_WEBHOOK = "webhook.delivery"
+)
+
+// ErrPrivateAddress is returned when a callback resolves to an address of the server's network
+var ErrPrivateAddress = errors.New("callback address is loopback, private or link-local")
+
+// Webhook is a callback url of a user tagged for sql serialization
+type Webhook struct {
+	Id          int32     `sql:"id" typ:"SERIAL" opt:"PRIMARY KEY"`
+	Uid         int32     `sql:"uid"`
+	Url         string    `sql:"url"`
+	Secret      string    `sql:"secret"`
+	Events      string    `sql:"events"` // Comma separated event types, empty subscribes to every event
+	Created     time.Time `sql:"created"`
+	LastStatus  int32     `sql:"last_status"` // Status of the last delivery attempt, 0 when the callback couldn't be reached
+	LastAttempt time.Time `sql:"last_attempt"`
+}
+
+// WebhookParams registers a webhook
+type WebhookParams struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookResp describes a webhook to its user, the secret is only returned when it is created
+type WebhookResp struct {
+	Id          int32      `json:"id"`
+	Url         string     `json:"url"`
+	Events      []string   `json:"events"`
+	Secret      string     `json:"secret,omitempty"`
+	Created     Timestamp  `json:"created"`
+	LastStatus  int32      `json:"lastStatus,omitempty"`
+	LastAttempt *Timestamp `json:"lastAttempt,omitempty"` // Omitted until an event is delivered
+}
+
+// WebhookEvent is the payload of EVENT_WEBHOOK, an event to post to a webhook
+type WebhookEvent struct {
+	WebhookId int32        `json:"webhookId"`
+	Event     LibraryEvent `json:"event"`
+}
+
+// WebhookBody is the body posted to a callback
+type WebhookBody struct {
+	Delivery int32 `json:"delivery"` // Outbox event id, repeated deliveries of an event share it
+	LibraryEvent
+}
+
+func init() {
+	RegisterOutboxHandler(EVENT_WEBHOOK, deliverWebhook)
+}
+
+// Resp returns the response describing the webhook
+func (h Webhook) Resp() WebhookResp {
+	resp := WebhookResp{Id: h.Id, Url: h.Url, Events: h.EventTypes(), Created: Timestamp(h.Created), LastStatus: h.LastStatus}
+	if !h.LastAttempt.IsZero() {
+		lastAttempt := Timestamp(h.LastAttempt)
+		resp.LastAttempt = &lastAttempt
+	}
+	return resp
+}
+
+// EventTypes returns the event types the webhook is subscribed to
+func (h Webhook) EventTypes() []string {
+	if len(h.Events) == 0 {
+		return []string{}
+	}
+	return strings.Split(h.Events, ",")
+}
+
+// Subscribed reports whether the webhook receives events of the type
+func (h Webhook) Subscribed(eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range h.EventTypes() {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookParams returns the callback url and comma separated event types of the params
+func validateWebhookParams(params WebhookParams) (string, string, error) {
+	callback, err := url.Parse(strings.TrimSpace(params.Url))
+	if err != nil || (callback.Scheme != "https" && callback.Scheme != "http") || len(callback.Host) == 0 || callback.User != nil {
+		return "", "", fmt.Errorf("url must be an absolute http or https url without credentials")
+	}
+	if len(callback.String()) > WEBHOOK_URL_MAX {
+		return "", "", fmt.Errorf("url may not exceed %v characters", WEBHOOK_URL_MAX)
+	}
+
+	known := map[string]bool{}
+	for _, eventType := range libraryEventTypes {
+		known[eventType] = true
+	}
+	events := []string{}
+	seen := map[string]bool{}
+	for _, eventType := range params.Events {
+		if !known[eventType] {
+			return "", "", fmt.Errorf("unknown event %q", eventType)
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			events = append(events, eventType)
+		}
+	}
+	return callback.String(), strings.Join(events, ","), nil
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, WEBHOOK_SECRET_BYTES)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	return WEBHOOK_SECRET_PREFIX + hex.EncodeToString(buf), nil
+}
+
+// signWebhook returns the signature of the body sent at the timestamp with the secret
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueWebhooks queues the library event for every webhook of the owner subscribed to its type
+func queueWebhooks(event LibraryEvent) {
+	if readOnly() {
+		return
+	}
+	hooks, err := UserWebhooks(event.Owner)
+	if err != nil {
+		logger.Error("failed to retrieve webhooks of user %v, %s of image %v is not delivered: %v", event.Owner, event.Type, event.ImageId, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Subscribed(event.Type) {
+			continue
+		}
+		err = AddWebhookEvent(WebhookEvent{WebhookId: hook.Id, Event: event})
+		if err != nil {
+			logger.Error("failed to queue %s of image %v for webhook %v: %v", event.Type, event.ImageId, hook.Id, err)
+		}
+	}
+}
+
+// webhookClient posts callbacks, refusing private addresses at connection time so DNS can't be used to bypass the check
+var webhookClient = &http.Client{
+	Timeout: WEBHOOK_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: WEBHOOK_TIMEOUT,
+			Control: func(network, address string, c syscall.RawConn) error {
+				if envBool("WEBHOOK_ALLOW_PRIVATE", false) {
+					return nil
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+					return ErrPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	// Redirects are not followed so a callback can't send the signed body elsewhere
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// deliverWebhook posts the event to the webhook, events of deleted webhooks are dropped
+// and responses other than 2xx fail the delivery so the outbox retries it
+func deliverWebhook(ctx context.Context, event OutboxEvent) error {
+	var payload WebhookEvent
+	err := json.Unmarshal([]byte(event.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("invalid webhook event payload: %v", err)
+	}
+
+	hook, found, err := GetWebhook(payload.WebhookId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	body, err := json.Marshal(WebhookBody{Delivery: event.Id, LibraryEvent: payload.Event})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook body: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "picto-cache-webhooks")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, payload.Event.Type)
+	req.Header.Set(WEBHOOK_DELIVERY, strconv.Itoa(int(event.Id)))
+	req.Header.Set(WEBHOOK_TIMESTAMP, timestamp)
+	req.Header.Set(WEBHOOK_SIGNATURE, signWebhook(hook.Secret, timestamp, body))
+
+	status := 0
+	resp, err := webhookClient.Do(req)
+	if err == nil {
+		status = resp.StatusCode
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, WEBHOOK_RESPONSE_LIMIT))
+		resp.Body.Close()
+	}
+	recordErr := RecordWebhookAttempt(hook.Id, int32(status), time.Now().UTC())
+	if recordErr != nil {
+		logger.Error("failed to record delivery to webhook %v: %v", hook.Id, recordErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to post to webhook %v: %v", hook.Id, err)
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("webhook %v responded with %v", hook.Id, status)
+	}
+	return nil
+}
+
+// createWebhook accepts a json body with the callback url and events of a new webhook and returns it with its secret
+func createWebhook(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage webhooks")
+	if !ok {
+		return
+	}
+
+	params := WebhookParams{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		logger.Error("failed to demarshal json body sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unable to parse json, check your request"))
+		return
+	}
+	callback, events, err := validateWebhookParams(params)
+	if err != nil {
+		logger.Error("invalid webhook sending 400: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("400 - %v", err)))
+		return
+	}
+
+	count, err := CountWebhooks(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to count webhooks sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create webhook, try again later"))
+		return
+	}
+	if count >= WEBHOOK_MAX {
+		logger.Error("user %v has %v webhooks sending 409", claims.Uid, count)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("409 - You may have at most %v webhooks, delete one first", WEBHOOK_MAX)))
+		return
+	}
+
+	hook := Webhook{Uid: int32(claims.Uid), Url: callback, Events: events, Created: time.Now().UTC()}
+	hook.Secret, err = newWebhookSecret()
+	if err == nil {
+		hook.Id, err = AddWebhook(hook)
+	}
+	if err != nil {
+		logger.Error("failed to add webhook sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to create webhook, try again later"))
+		return
+	}
+
+	resp := hook.Resp()
+	resp.Secret = hook.Secret
+	writeAlbumJSON(w, http.StatusCreated, resp)
+	logger.Info("Created webhook %v for user %v", hook.Id, claims.Uid)
+}
+
+// listWebhooks returns the webhooks of the user in order of creation
+func listWebhooks(w http.ResponseWriter, req *http.Request) {
+	claims, err := authRequest(req)
+	if err != nil {
+		logger.Error("Unauthorized request to list webhooks sending 401: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized request, ensure you sign in and obtain the jwt auth token"))
+		return
+	}
+
+	hooks, err := UserWebhooks(int32(claims.Uid))
+	if err != nil {
+		logger.Error("failed to retrieve webhooks sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to retrieve webhooks, try again later"))
+		return
+	}
+
+	resp := []WebhookResp{}
+	for _, hook := range hooks {
+		resp = append(resp, hook.Resp())
+	}
+	writeAlbumJSON(w, http.StatusOK, resp)
+}
+
+// deleteWebhook removes a webhook of the user, its queued events are dropped
+func deleteWebhook(w http.ResponseWriter, req *http.Request) {
+	claims, ok := authSignedIn(w, req, "manage webhooks")
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	deleted, err := DeleteWebhook(int32(claims.Uid), int32(id))
+	if err != nil {
+		logger.Error("failed to delete webhook sending 500: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - Failed to delete webhook, try again later"))
+		return
+	}
+	if !deleted {
+		logger.Error("user %v has no webhook %v sending 404", claims.Uid, id)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not found, no webhook with that id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logger.Info("Deleted webhook %v of user %v", id, claims.Uid)
+}